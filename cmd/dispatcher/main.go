@@ -16,25 +16,65 @@ package main
 
 import (
 	"context"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/kelseyhightower/envconfig"
 	log "github.com/sirupsen/logrus"
 
+	"github.com/praetorian-inc/trident/pkg/db"
 	"github.com/praetorian-inc/trident/pkg/dispatch"
+	"github.com/praetorian-inc/trident/pkg/queue"
+	"github.com/praetorian-inc/trident/pkg/secrets"
 
+	_ "github.com/praetorian-inc/trident/pkg/dispatch/clients/multi"
 	_ "github.com/praetorian-inc/trident/pkg/dispatch/clients/webhook"
+	_ "github.com/praetorian-inc/trident/pkg/queue/gcppubsub"
+	_ "github.com/praetorian-inc/trident/pkg/queue/jetstream"
+	_ "github.com/praetorian-inc/trident/pkg/queue/redisstream"
+	_ "github.com/praetorian-inc/trident/pkg/queue/webhook"
+	_ "github.com/praetorian-inc/trident/pkg/secrets/gcpsecretmanager"
+	_ "github.com/praetorian-inc/trident/pkg/secrets/vault"
 )
 
 type specification struct {
 	LogLevel string `envconfig:"LOG_LEVEL" default:"INFO"`
 
-	ProjectID      string `envconfig:"PROJECT_ID" required:"true"`
-	ResultTopicID  string `envconfig:"RESULT_TOPIC_ID" required:"true"`
-	SubscriptionID string `envconfig:"SUBSCRIPTION_ID" required:"true"`
+	// queue configuration options. PROJECT_ID is only used by the pubsub
+	// queue, and subscriptions of the redis and nats queues are named
+	// "stream/group".
+	Queue          string        `envconfig:"QUEUE" default:"pubsub"`
+	QueueConfig    queue.Options `envconfig:"QUEUE_CONFIG"`
+	ProjectID      string        `envconfig:"PROJECT_ID"`
+	ResultTopicID  string        `envconfig:"RESULT_TOPIC_ID" required:"true"`
+	SubscriptionID string        `envconfig:"SUBSCRIPTION_ID" required:"true"`
+
+	// the queue results are published to, if different from QUEUE (e.g.
+	// "webhook" to post signed results to the orchestrator)
+	ResultQueue       string        `envconfig:"RESULT_QUEUE"`
+	ResultQueueConfig queue.Options `envconfig:"RESULT_QUEUE_CONFIG"`
+
+	// the database used to claim tasks before they are executed, so that a
+	// redelivered task is never executed twice (optional)
+	DBConnectionString string `envconfig:"DB_CONNECTION_STRING"`
 
 	WorkerName   string                 `envconfig:"WORKER_NAME" required:"true"`
 	WorkerConfig dispatch.WorkerOptions `envconfig:"WORKER_CONFIG" required:"true"`
+
+	// the longest a task waits for a batch to fill (batching is enabled by
+	// the worker's batch_size option)
+	BatchTimeout time.Duration `envconfig:"BATCH_TIMEOUT" default:"1s"`
+
+	// the longest in-flight tasks are waited for on SIGTERM. tasks which
+	// were not submitted to the worker are returned to the queue.
+	DrainTimeout time.Duration `envconfig:"DRAIN_TIMEOUT" default:"25s"`
+
+	// secret store configuration options. values of the form "secret:<name>"
+	// are fetched from the store (vault or gcpsecretmanager) on startup
+	SecretStore       string          `envconfig:"SECRET_STORE"`
+	SecretStoreConfig secrets.Options `envconfig:"SECRET_STORE_CONFIG"`
 }
 
 var spec specification
@@ -45,6 +85,11 @@ func init() {
 		log.Fatal(err)
 	}
 
+	_, err = secrets.Load(spec.SecretStore, spec.SecretStoreConfig, &spec)
+	if err != nil {
+		log.Fatalf("error loading secrets: %s", err)
+	}
+
 	level, err := log.ParseLevel(spec.LogLevel)
 	if err != nil {
 		log.Fatal(err)
@@ -58,21 +103,50 @@ func init() {
 }
 
 func main() {
-	ctx := context.Background()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
 	worker, err := dispatch.Open(spec.WorkerName, spec.WorkerConfig)
 	if err != nil {
 		log.Fatal(err)
 	}
-	dis, err := dispatch.NewDispatcher(ctx, dispatch.Options{
-		ProjectID:      spec.ProjectID,
-		SubscriptionID: spec.SubscriptionID,
-		ResultTopicID:  spec.ResultTopicID,
-	}, worker)
+	opts := dispatch.Options{
+		Queue:             spec.Queue,
+		QueueConfig:       spec.QueueConfig,
+		ProjectID:         spec.ProjectID,
+		SubscriptionID:    spec.SubscriptionID,
+		ResultTopicID:     spec.ResultTopicID,
+		BatchTimeout:      spec.BatchTimeout,
+		DrainTimeout:      spec.DrainTimeout,
+		ResultQueue:       spec.ResultQueue,
+		ResultQueueConfig: spec.ResultQueueConfig,
+	}
+	if spec.DBConnectionString != "" {
+		database, err := db.New(spec.DBConnectionString)
+		if err != nil {
+			log.Fatalf("error connecting to database: %s", err)
+		}
+		defer database.Close() // nolint:errcheck
+		opts.Claims = database
+	}
+
+	dis, err := dispatch.NewDispatcher(ctx, opts, worker)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	// stop taking tasks on SIGTERM and exit once in-flight tasks complete
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		log.Printf("shutting down, waiting for in-flight tasks")
+		cancel()
+	}()
+
 	log.Printf("starting dispatcher for subscription %s", spec.SubscriptionID)
-	log.Fatal(dis.Listen(ctx))
+	err = dis.Listen(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
 }
@@ -0,0 +1,175 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/kelseyhightower/envconfig"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/praetorian-inc/trident/pkg/auth/token"
+	"github.com/praetorian-inc/trident/pkg/kms"
+	"github.com/praetorian-inc/trident/pkg/rules"
+	"github.com/praetorian-inc/trident/pkg/secrets"
+	"github.com/praetorian-inc/trident/pkg/usernames"
+	awslambda "github.com/praetorian-inc/trident/pkg/worker/lambda"
+	"github.com/praetorian-inc/trident/pkg/worker/webhook"
+
+	_ "github.com/praetorian-inc/trident/pkg/action/o365mailbox"
+	_ "github.com/praetorian-inc/trident/pkg/action/smtpauth"
+	_ "github.com/praetorian-inc/trident/pkg/kms/gcpkms"
+	_ "github.com/praetorian-inc/trident/pkg/kms/local"
+
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/adfs"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/atlassian"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/bigip"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/external"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/ivanti"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/mock"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/netskope"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/o365"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/okta"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/sonicwall"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/zscaler"
+	_ "github.com/praetorian-inc/trident/pkg/secrets/gcpsecretmanager"
+	_ "github.com/praetorian-inc/trident/pkg/secrets/vault"
+)
+
+type specification struct {
+	LogLevel    string `envconfig:"LOG_LEVEL" default:"INFO"`
+	AccessToken []byte `envconfig:"ACCESS_TOKEN"`
+
+	// signed token configuration options (preferred over ACCESS_TOKEN). a
+	// comma separated list of secrets allows for zero-downtime rotation.
+	SigningKeys string        `envconfig:"SIGNING_KEYS"`
+	TokenTTL    time.Duration `envconfig:"TOKEN_TTL" default:"30s"`
+
+	// the redis recording token nonces, shared by the replicas of the
+	// worker so that a request cannot be replayed against another replica.
+	// nonces are only recorded by the replica verifying them when unset.
+	NonceRedisURI      string `envconfig:"NONCE_REDIS_URI"`
+	NonceRedisPassword string `envconfig:"NONCE_REDIS_PASSWORD"`
+
+	// the path prefix stripped by API Gateway (e.g. "/prod" when invoked
+	// through the stage URL) which is part of the path signed by the
+	// dispatcher
+	PathPrefix string `envconfig:"PATH_PREFIX"`
+
+	// key management configuration options used to decrypt task passwords
+	KeyManager       string      `envconfig:"KEY_MANAGER"`
+	KeyManagerConfig kms.Options `envconfig:"KEY_MANAGER_CONFIG"`
+
+	// how often the egress IP reported with results is refreshed (0
+	// disables refreshing)
+	EgressInterval time.Duration `envconfig:"EGRESS_INTERVAL" default:"5m"`
+
+	// response classification rules, loaded from a file or an http(s) URL
+	// and reloaded every RULES_INTERVAL (0 disables reloading)
+	Rules         string        `envconfig:"RULES"`
+	RulesInterval time.Duration `envconfig:"RULES_INTERVAL" default:"5m"`
+
+	// secret store configuration options. values of the form "secret:<name>"
+	// are fetched from the store (vault or gcpsecretmanager) on startup
+	SecretStore       string          `envconfig:"SECRET_STORE"`
+	SecretStoreConfig secrets.Options `envconfig:"SECRET_STORE_CONFIG"`
+
+	// prefix of the secrets that the provider metadata and actions of tasks
+	// may reference (e.g. trident/providers/). other references are refused
+	// so that a campaign cannot send arbitrary secrets to its target.
+	SecretProviderPrefix string `envconfig:"SECRET_PROVIDER_PREFIX"`
+
+	// comma separated patterns of accounts the worker refuses to attempt,
+	// whatever the campaign (e.g. admin-*@example.org)
+	ProtectedAccounts []string `envconfig:"PROTECTED_ACCOUNTS"`
+}
+
+var (
+	spec        specification
+	secretStore secrets.Store
+)
+
+func init() {
+	err := envconfig.Process("worker", &spec)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	secretStore, err = secrets.Load(spec.SecretStore, spec.SecretStoreConfig, &spec)
+	if err != nil {
+		log.Fatalf("error loading secrets: %s", err)
+	}
+
+	level, err := log.ParseLevel(spec.LogLevel)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.SetLevel(level)
+	log.SetFormatter(&log.TextFormatter{
+		FullTimestamp:   true,
+		TimestampFormat: time.RFC3339Nano,
+	})
+}
+
+func main() {
+	s, err := webhook.NewWebhookServer()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if spec.KeyManager != "" {
+		keys, err := kms.Open(spec.KeyManager, spec.KeyManagerConfig)
+		if err != nil {
+			log.Fatalf("error opening key manager: %s", err)
+		}
+		s.Envelope = kms.NewEnvelope(keys)
+	}
+	s.Secrets = secrets.NewScoped(secrets.NewCache(secretStore, webhook.SecretTTL), spec.SecretProviderPrefix)
+
+	err = usernames.ValidateExclusions(spec.ProtectedAccounts)
+	if err != nil {
+		log.Fatalf("error in PROTECTED_ACCOUNTS: %s", err)
+	}
+	s.Protected = spec.ProtectedAccounts
+
+	if spec.EgressInterval > 0 {
+		go s.WatchEgress(spec.EgressInterval)
+	}
+
+	if spec.Rules != "" {
+		err = rules.Watch(spec.Rules, spec.RulesInterval)
+		if err != nil {
+			log.Fatalf("error loading rules: %s", err)
+		}
+	}
+
+	auth := webhook.AccessTokenVerifier(spec.AccessToken)
+	if spec.SigningKeys != "" {
+		verifier := token.NewVerifier(spec.SigningKeys, spec.TokenTTL)
+		verifier.Prefix = spec.PathPrefix
+		if spec.NonceRedisURI != "" {
+			verifier.Nonces, err = token.NewRedisNonces(spec.NonceRedisURI, spec.NonceRedisPassword)
+			if err != nil {
+				log.Fatalf("error connecting to redis: %s", err)
+			}
+		}
+		auth = verifier.Middleware
+	}
+
+	log.Printf("starting lambda worker")
+	lambda.Start(awslambda.Handler(webhook.NewRouter(s, auth)))
+}
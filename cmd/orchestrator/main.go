@@ -15,6 +15,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"time"
@@ -24,10 +25,47 @@ import (
 	"github.com/kelseyhightower/envconfig"
 	log "github.com/sirupsen/logrus"
 
+	"github.com/praetorian-inc/trident/pkg/archive"
 	"github.com/praetorian-inc/trident/pkg/auth/cloudflare"
+	"github.com/praetorian-inc/trident/pkg/auth/oidc"
+	"github.com/praetorian-inc/trident/pkg/auth/rbac"
+	"github.com/praetorian-inc/trident/pkg/auth/token"
+	"github.com/praetorian-inc/trident/pkg/credentials"
 	"github.com/praetorian-inc/trident/pkg/db"
+	"github.com/praetorian-inc/trident/pkg/kms"
+	"github.com/praetorian-inc/trident/pkg/notify"
+	"github.com/praetorian-inc/trident/pkg/queue"
+	"github.com/praetorian-inc/trident/pkg/redact"
+	"github.com/praetorian-inc/trident/pkg/retention"
+	"github.com/praetorian-inc/trident/pkg/retry"
 	"github.com/praetorian-inc/trident/pkg/scheduler"
+	"github.com/praetorian-inc/trident/pkg/secrets"
 	"github.com/praetorian-inc/trident/pkg/server"
+	"github.com/praetorian-inc/trident/pkg/stream"
+
+	_ "github.com/praetorian-inc/trident/pkg/action/o365mailbox"
+	_ "github.com/praetorian-inc/trident/pkg/action/smtpauth"
+	_ "github.com/praetorian-inc/trident/pkg/archive/file"
+	_ "github.com/praetorian-inc/trident/pkg/archive/gcs"
+	_ "github.com/praetorian-inc/trident/pkg/archive/s3"
+	_ "github.com/praetorian-inc/trident/pkg/kms/gcpkms"
+	_ "github.com/praetorian-inc/trident/pkg/kms/local"
+	_ "github.com/praetorian-inc/trident/pkg/notify/logger"
+	_ "github.com/praetorian-inc/trident/pkg/notify/slack"
+	_ "github.com/praetorian-inc/trident/pkg/notify/webhook"
+
+	// the nozzles are only opened to check that they support fingerprinting
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/bigip"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/ivanti"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/o365"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/okta"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/sonicwall"
+
+	_ "github.com/praetorian-inc/trident/pkg/queue/gcppubsub"
+	_ "github.com/praetorian-inc/trident/pkg/queue/jetstream"
+	_ "github.com/praetorian-inc/trident/pkg/queue/redisstream"
+	_ "github.com/praetorian-inc/trident/pkg/secrets/gcpsecretmanager"
+	_ "github.com/praetorian-inc/trident/pkg/secrets/vault"
 )
 
 type specification struct {
@@ -36,18 +74,105 @@ type specification struct {
 	AdminListenerPort  int    `envconfig:"ADMIN_LISTENING_PORT" default:"9999"`
 	DBConnectionString string `envconfig:"DB_CONNECTION_STRING" required:"true"`
 
+	// database connection pool options (0 keeps the driver default), and
+	// the buffering of the bulk inserts of results
+	DBMaxOpenConns      int           `envconfig:"DB_MAX_OPEN_CONNS" default:"0"`
+	DBMaxIdleConns      int           `envconfig:"DB_MAX_IDLE_CONNS" default:"10"`
+	DBConnMaxLifetime   time.Duration `envconfig:"DB_CONN_MAX_LIFETIME" default:"30m"`
+	ResultBatchSize     int           `envconfig:"RESULT_BATCH_SIZE" default:"5000"`
+	ResultFlushInterval time.Duration `envconfig:"RESULT_FLUSH_INTERVAL" default:"3s"`
+
+	// if true, pending schema migrations are applied on startup. otherwise
+	// they are applied with "trident-server migrate".
+	AutoMigrate bool `envconfig:"AUTO_MIGRATE" default:"true"`
+
+	// authentication provider used to verify operators (cloudflare or oidc)
+	AuthProvider string `envconfig:"AUTH_PROVIDER" default:"cloudflare"`
+
 	// cloudflare configuration options
 	AuthDomain string `envconfig:"CF_AUTH_DOMAIN"`
 	PolicyAUD  string `envconfig:"CF_AUDIENCE"`
 
-	// pubsub configuration options
-	ProjectID      string `envconfig:"PROJECT_ID" required:"true"`
-	TopicID        string `envconfig:"TOPIC_ID" required:"true"`
-	SubscriptionID string `envconfig:"SUBSCRIPTION_ID" required:"true"`
+	// openid connect configuration options
+	OIDCIssuer   string `envconfig:"OIDC_ISSUER"`
+	OIDCClientID string `envconfig:"OIDC_CLIENT_ID"`
+
+	// access control configuration options. if unset, every authenticated
+	// operator may access every campaign.
+	RBACPolicyFile string `envconfig:"RBAC_POLICY_FILE"`
+
+	// queue configuration options. PROJECT_ID is only used by the pubsub
+	// queue, and subscriptions of the redis and nats queues are named
+	// "stream/group".
+	Queue          string        `envconfig:"QUEUE" default:"pubsub"`
+	QueueConfig    queue.Options `envconfig:"QUEUE_CONFIG"`
+	ProjectID      string        `envconfig:"PROJECT_ID"`
+	TopicID        string        `envconfig:"TOPIC_ID" required:"true"`
+	SubscriptionID string        `envconfig:"SUBSCRIPTION_ID" required:"true"`
+
+	// key management configuration options. if unset, the credential vault
+	// is disabled.
+	KeyManager       string      `envconfig:"KEY_MANAGER"`
+	KeyManagerConfig kms.Options `envconfig:"KEY_MANAGER_CONFIG"`
+
+	// if true, task and result passwords are encrypted with the configured
+	// key manager. workers must be configured with the same key manager.
+	EncryptPasswords bool `envconfig:"ENCRYPT_PASSWORDS" default:"false"`
+
+	// retry policy for tasks failing with transient errors. the number of
+	// retries is configured per campaign.
+	RetryBaseDelay time.Duration `envconfig:"RETRY_BASE_DELAY" default:"30s"`
+	RetryMaxDelay  time.Duration `envconfig:"RETRY_MAX_DELAY" default:"30m"`
+
+	// notifier used to alert operators of failing tasks (log, slack, webhook)
+	Notifier       string         `envconfig:"NOTIFIER"`
+	NotifierConfig notify.Options `envconfig:"NOTIFIER_CONFIG"`
+
+	// how long an account found locked out is not attempted by any campaign
+	// (0 disables the cooldown)
+	AccountLockoutCooldown time.Duration `envconfig:"ACCOUNT_LOCKOUT_COOLDOWN" default:"24h"`
+
+	// credential vault revalidation interval (0 disables revalidation)
+	RevalidateInterval time.Duration `envconfig:"REVALIDATE_INTERVAL" default:"0"`
+
+	// campaigns which ended more than RETENTION_DAYS ago are archived to the
+	// archive store (gcs, s3, file) and purged from the database every
+	// RETENTION_INTERVAL (0 disables retention). archives are encrypted with
+	// the KEY_MANAGER. campaigns are only purged without being archived if
+	// RETENTION_PURGE_ONLY is set.
+	RetentionDays      int             `envconfig:"RETENTION_DAYS" default:"0"`
+	RetentionInterval  time.Duration   `envconfig:"RETENTION_INTERVAL" default:"24h"`
+	RetentionPurgeOnly bool            `envconfig:"RETENTION_PURGE_ONLY" default:"false"`
+	ArchiveStore       string          `envconfig:"ARCHIVE_STORE"`
+	ArchiveStoreConfig archive.Options `envconfig:"ARCHIVE_STORE_CONFIG"`
+
+	// result ingestion configuration options. results posted to
+	// /results/ingest must be signed with one of the comma separated shared
+	// keys, or with a per-worker key (e.g. "worker-1:key1,worker-2:key2").
+	// ingestion is disabled if no key is configured.
+	IngestSigningKeys string            `envconfig:"INGEST_SIGNING_KEYS"`
+	IngestWorkerKeys  map[string]string `envconfig:"INGEST_WORKER_KEYS"`
+	IngestTTL         time.Duration     `envconfig:"INGEST_TTL" default:"30s"`
 
 	// redis configuration options
 	RedisURI      string `envconfig:"REDIS_URI" required:"true"`
 	RedisPassword string `envconfig:"REDIS_PASSWORD"`
+
+	// secret store configuration options. values of the form "secret:<name>"
+	// are fetched from the store (vault or gcpsecretmanager) on startup
+	SecretStore       string          `envconfig:"SECRET_STORE"`
+	SecretStoreConfig secrets.Options `envconfig:"SECRET_STORE_CONFIG"`
+
+	// prefix of the secrets that the provider metadata and actions of
+	// campaigns may reference (e.g. trident/providers/). campaigns
+	// referencing other secrets are rejected, and none may be referenced if
+	// it is unset.
+	SecretProviderPrefix string `envconfig:"SECRET_PROVIDER_PREFIX"`
+
+	// if true, a worker has the nozzle of each new campaign probe its
+	// tenant once, at the campaign's start, and the detected features are
+	// recorded with the campaign
+	FingerprintCampaigns bool `envconfig:"FINGERPRINT_CAMPAIGNS" default:"false"`
 }
 
 var spec specification
@@ -58,6 +183,11 @@ func init() {
 		log.Fatal(err)
 	}
 
+	_, err = secrets.Load(spec.SecretStore, spec.SecretStoreConfig, &spec)
+	if err != nil {
+		log.Fatalf("error loading secrets: %s", err)
+	}
+
 	level, err := log.ParseLevel(spec.LogLevel)
 	if err != nil {
 		log.Fatal(err)
@@ -74,6 +204,14 @@ func init() {
 func main() {
 	finish := make(chan bool)
 
+	opts := db.Options{
+		MaxOpenConns:    spec.DBMaxOpenConns,
+		MaxIdleConns:    spec.DBMaxIdleConns,
+		ConnMaxLifetime: spec.DBConnMaxLifetime,
+		BatchSize:       spec.ResultBatchSize,
+		FlushInterval:   spec.ResultFlushInterval,
+	}
+
 	db, err := db.New(spec.DBConnectionString)
 	if err != nil {
 		log.WithFields(log.Fields{
@@ -81,22 +219,124 @@ func main() {
 		}).Fatal(err)
 	}
 	defer db.Close() // nolint:errcheck
+	db.Configure(opts)
+
+	if spec.AutoMigrate {
+		applied, err := db.Migrate(false)
+		if err != nil {
+			log.Fatalf("error migrating database: %s", err)
+		}
+		for _, m := range applied {
+			log.Printf("applied migration %d (%s)", m.Version, m.Name)
+		}
+	}
+
+	var vault *credentials.Vault
+	var envelope *kms.Envelope
+	var redactor *redact.Redactor
+	if spec.KeyManager != "" {
+		keys, err := kms.Open(spec.KeyManager, spec.KeyManagerConfig)
+		if err != nil {
+			log.Fatalf("error opening key manager: %s", err)
+		}
+		// passwords from before encryption was enabled remain readable, so
+		// the envelope is always used for decryption
+		envelope = kms.NewEnvelope(keys)
+		vault = &credentials.Vault{DB: db, Keys: keys, Envelope: envelope}
+		redactor = &redact.Redactor{DB: db, Envelope: envelope}
+	} else if spec.EncryptPasswords {
+		log.Fatal("ENCRYPT_PASSWORDS requires a KEY_MANAGER")
+	}
+
+	var sealer *kms.Envelope
+	if spec.EncryptPasswords {
+		sealer = envelope
+	}
+
+	var archiver *retention.Archiver
+	if spec.RetentionDays > 0 {
+		archiver = &retention.Archiver{DB: db, Envelope: envelope}
+		if !spec.RetentionPurgeOnly {
+			if spec.ArchiveStore == "" {
+				log.Fatal("RETENTION_DAYS requires an ARCHIVE_STORE unless RETENTION_PURGE_ONLY is set")
+			}
+			if envelope == nil {
+				log.Fatal("ARCHIVE_STORE requires a KEY_MANAGER")
+			}
+			archiver.Store, err = archive.Open(spec.ArchiveStore, spec.ArchiveStoreConfig)
+			if err != nil {
+				log.Fatalf("error opening archive store: %s", err)
+			}
+		}
+	}
+
+	var notifier notify.Notifier
+	if spec.Notifier != "" {
+		notifier, err = notify.Open(spec.Notifier, spec.NotifierConfig)
+		if err != nil {
+			log.Fatalf("error opening notifier: %s", err)
+		}
+	}
+
+	policy := retry.DefaultPolicy
+	policy.Base = spec.RetryBaseDelay
+	policy.Max = spec.RetryMaxDelay
+
+	hub := &stream.Hub{}
 
 	sch, err := scheduler.NewPubSubScheduler(scheduler.Options{
-		Database:       db,
-		ProjectID:      spec.ProjectID,
-		TopicID:        spec.TopicID,
-		SubscriptionID: spec.SubscriptionID,
-		RedisURI:       spec.RedisURI,
-		RedisPassword:  spec.RedisPassword,
+		Database:        db,
+		Vault:           vault,
+		Redactor:        redactor,
+		Envelope:        sealer,
+		RetryPolicy:     policy,
+		Notifier:        notifier,
+		Hub:             hub,
+		Queue:           spec.Queue,
+		QueueConfig:     spec.QueueConfig,
+		ProjectID:       spec.ProjectID,
+		TopicID:         spec.TopicID,
+		SubscriptionID:  spec.SubscriptionID,
+		RedisURI:        spec.RedisURI,
+		RedisPassword:   spec.RedisPassword,
+		AccountCooldown: spec.AccountLockoutCooldown,
+		Fingerprint:     spec.FingerprintCampaigns,
 	})
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	s := &server.Server{
-		DB:  db,
-		Sch: sch,
+		DB:       db,
+		Sch:      sch,
+		Vault:    vault,
+		Envelope: envelope,
+		Hub:      hub,
+
+		EncryptPasswords: spec.EncryptPasswords,
+
+		SecretPrefix: spec.SecretProviderPrefix,
+	}
+
+	if spec.IngestSigningKeys != "" || len(spec.IngestWorkerKeys) > 0 {
+		s.Ingest = token.NewVerifier(spec.IngestSigningKeys, spec.IngestTTL)
+		// nonces are shared with the other orchestrators through redis, so a
+		// result cannot be replayed against another replica
+		s.Ingest.Nonces, err = token.NewRedisNonces(spec.RedisURI, spec.RedisPassword)
+		if err != nil {
+			log.Fatalf("error connecting to redis: %s", err)
+		}
+		s.Ingest.Keys = make(map[string][]byte, len(spec.IngestWorkerKeys))
+		for id, key := range spec.IngestWorkerKeys {
+			s.Ingest.Keys[id] = []byte(key)
+		}
+	}
+
+	if spec.RBACPolicyFile != "" {
+		s.Policy, err = rbac.LoadPolicy(spec.RBACPolicyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
 
 	log.WithFields(log.Fields{
@@ -111,21 +351,54 @@ func main() {
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 
-	// Set a timeout value on the request context (ctx), that will signal
-	// through ctx.Done() that the request has timed out and further
-	// processing should be stopped.
-	r.Use(middleware.Timeout(60 * time.Second))
+	// authenication middleware to verify JWTs on operator requests
+	var authenticate func(http.Handler) http.Handler
+	switch spec.AuthProvider {
+	case "cloudflare":
+		authenticate = cloudflare.Verifier(spec.AuthDomain, spec.PolicyAUD)
+	case "oidc":
+		authenticate, err = oidc.Verifier(context.Background(), spec.OIDCIssuer, spec.OIDCClientID)
+		if err != nil {
+			log.Fatalf("error configuring oidc verifier: %s", err)
+		}
+	default:
+		log.Fatalf("unknown auth provider %q", spec.AuthProvider)
+	}
+
+	// results posted by workers are authenticated by their signature rather
+	// than by an operator token
+	r.With(middleware.Timeout(60*time.Second)).Post("/results/ingest", s.IngestHandler)
+
+	r.Group(func(r chi.Router) {
+		r.Use(authenticate)
 
-	// Insert authenication middleware to verify JWTs on all requests
-	r.Use(cloudflare.Verifier(spec.AuthDomain, spec.PolicyAUD))
+		// streaming routes are long-lived and are not subject to the timeout
+		r.With(s.Audit("results.follow")).Get("/results/stream", s.ResultsStreamHandler)
 
-	// routes
-	r.Get("/healthz", s.HealthzHandler)
-	r.Post("/campaign/status", s.StatusUpdateHandler)
-	r.Post("/campaign", s.CampaignHandler)
-	r.Post("/results", s.ResultsHandler)
-	r.Get("/list", s.CampaignListHandler)
-	r.Post("/describe", s.CampaignDescribeHandler)
+		r.Group(func(r chi.Router) {
+			// Set a timeout value on the request context (ctx), that will signal
+			// through ctx.Done() that the request has timed out and further
+			// processing should be stopped.
+			r.Use(middleware.Timeout(60 * time.Second))
+
+			// routes. every operator call is audited, except health checks
+			r.Get("/healthz", s.HealthzHandler)
+			r.With(s.Audit("campaign.status")).Post("/campaign/status", s.StatusUpdateHandler)
+			r.With(s.Audit("campaign.progress")).Post("/campaign/progress", s.CampaignProgressHandler)
+			r.With(s.Audit("campaign.timeline")).Post("/campaign/timeline", s.TimelineHandler)
+			r.With(s.Audit("campaign.egress")).Post("/campaign/egress", s.EgressHandler)
+			r.With(s.Audit("report.export")).Post("/report", s.ReportHandler)
+			r.With(s.Audit("campaign.create")).Post("/campaign", s.CampaignHandler)
+			r.With(s.Audit("results.export")).Post("/results", s.ResultsHandler)
+			r.With(s.Audit("campaign.list")).Get("/list", s.CampaignListHandler)
+			r.With(s.Audit("campaign.describe")).Post("/describe", s.CampaignDescribeHandler)
+			r.With(s.Audit("credentials.export")).Post("/credentials", s.CredentialsHandler)
+			r.With(s.Audit("tasks.errors")).Post("/tasks/errors", s.FailedTasksHandler)
+			r.With(s.Audit("tasks.requeue")).Post("/tasks/errors/requeue", s.RequeueHandler)
+			r.With(s.Audit("workers.list")).Get("/workers", s.WorkersHandler)
+			r.With(s.Audit("audit.export")).Post("/audit", s.AuditHandler)
+		})
+	})
 
 	go func() {
 		log.Printf("starting server on port %d", spec.AdminListenerPort)
@@ -142,5 +415,22 @@ func main() {
 		log.Fatal(sch.ConsumeResults())
 	}()
 
+	if vault != nil && spec.RevalidateInterval > 0 {
+		go func() {
+			log.Printf("starting credential revalidation every %s", spec.RevalidateInterval)
+			vault.Revalidate(context.Background(), sch, spec.RevalidateInterval)
+		}()
+	}
+
+	if archiver != nil {
+		// replicas share the lock, so that a single one enforces retention
+		archiver.Lock = sch
+		go func() {
+			log.Printf("starting purge of campaigns ended more than %d days ago", spec.RetentionDays)
+			maxAge := time.Duration(spec.RetentionDays) * 24 * time.Hour
+			archiver.Enforce(context.Background(), maxAge, spec.RetentionInterval)
+		}()
+	}
+
 	<-finish
 }
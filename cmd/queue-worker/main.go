@@ -0,0 +1,242 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/kelseyhightower/envconfig"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+	"github.com/praetorian-inc/trident/pkg/dispatch"
+	"github.com/praetorian-inc/trident/pkg/kms"
+	"github.com/praetorian-inc/trident/pkg/queue"
+	"github.com/praetorian-inc/trident/pkg/rules"
+	"github.com/praetorian-inc/trident/pkg/secrets"
+	"github.com/praetorian-inc/trident/pkg/usernames"
+	"github.com/praetorian-inc/trident/pkg/worker/pull"
+	"github.com/praetorian-inc/trident/pkg/worker/webhook"
+
+	_ "github.com/praetorian-inc/trident/pkg/action/o365mailbox"
+	_ "github.com/praetorian-inc/trident/pkg/action/smtpauth"
+	_ "github.com/praetorian-inc/trident/pkg/kms/gcpkms"
+	_ "github.com/praetorian-inc/trident/pkg/kms/local"
+
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/adfs"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/atlassian"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/bigip"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/external"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/ivanti"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/mock"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/netskope"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/o365"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/okta"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/sonicwall"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/zscaler"
+
+	_ "github.com/praetorian-inc/trident/pkg/queue/gcppubsub"
+	_ "github.com/praetorian-inc/trident/pkg/queue/jetstream"
+	_ "github.com/praetorian-inc/trident/pkg/queue/redisstream"
+	_ "github.com/praetorian-inc/trident/pkg/queue/webhook"
+	_ "github.com/praetorian-inc/trident/pkg/secrets/gcpsecretmanager"
+	_ "github.com/praetorian-inc/trident/pkg/secrets/vault"
+)
+
+type specification struct {
+	LogLevel string `envconfig:"LOG_LEVEL" default:"INFO"`
+
+	// the port serving the probes and metrics
+	Port int `envconfig:"PORT" default:"8080"`
+
+	// queue configuration options, shared with the dispatcher
+	Queue          string        `envconfig:"QUEUE" default:"pubsub"`
+	QueueConfig    queue.Options `envconfig:"QUEUE_CONFIG"`
+	ProjectID      string        `envconfig:"PROJECT_ID"`
+	ResultTopicID  string        `envconfig:"RESULT_TOPIC_ID" required:"true"`
+	SubscriptionID string        `envconfig:"SUBSCRIPTION_ID" required:"true"`
+
+	// the queue results are published to, if different from QUEUE (e.g.
+	// "webhook" to post signed results to the orchestrator)
+	ResultQueue       string        `envconfig:"RESULT_QUEUE"`
+	ResultQueueConfig queue.Options `envconfig:"RESULT_QUEUE_CONFIG"`
+
+	// the database used to claim tasks before they are executed, so that a
+	// redelivered task is never executed twice (optional)
+	DBConnectionString string `envconfig:"DB_CONNECTION_STRING"`
+
+	// the number of tasks executed at once, and how often the queue backlog
+	// metric is refreshed
+	Concurrency     int           `envconfig:"CONCURRENCY" default:"10"`
+	BacklogInterval time.Duration `envconfig:"BACKLOG_INTERVAL" default:"15s"`
+
+	// the longest in-flight tasks are waited for on SIGTERM, within the
+	// pod's termination grace period
+	DrainTimeout time.Duration `envconfig:"DRAIN_TIMEOUT" default:"25s"`
+
+	// key management configuration options used to decrypt task passwords
+	KeyManager       string      `envconfig:"KEY_MANAGER"`
+	KeyManagerConfig kms.Options `envconfig:"KEY_MANAGER_CONFIG"`
+
+	// how often the egress IP reported with results is refreshed (0
+	// disables refreshing)
+	EgressInterval time.Duration `envconfig:"EGRESS_INTERVAL" default:"5m"`
+
+	// response classification rules, loaded from a file or an http(s) URL
+	// and reloaded every RULES_INTERVAL (0 disables reloading)
+	Rules         string        `envconfig:"RULES"`
+	RulesInterval time.Duration `envconfig:"RULES_INTERVAL" default:"5m"`
+
+	// secret store configuration options. values of the form "secret:<name>"
+	// are fetched from the store (vault or gcpsecretmanager) on startup
+	SecretStore       string          `envconfig:"SECRET_STORE"`
+	SecretStoreConfig secrets.Options `envconfig:"SECRET_STORE_CONFIG"`
+
+	// prefix of the secrets that the provider metadata and actions of tasks
+	// may reference (e.g. trident/providers/). other references are refused
+	// so that a campaign cannot send arbitrary secrets to its target.
+	SecretProviderPrefix string `envconfig:"SECRET_PROVIDER_PREFIX"`
+
+	// comma separated patterns of accounts the worker refuses to attempt,
+	// whatever the campaign (e.g. admin-*@example.org)
+	ProtectedAccounts []string `envconfig:"PROTECTED_ACCOUNTS"`
+}
+
+var (
+	spec        specification
+	secretStore secrets.Store
+)
+
+func init() {
+	err := envconfig.Process("worker", &spec)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	secretStore, err = secrets.Load(spec.SecretStore, spec.SecretStoreConfig, &spec)
+	if err != nil {
+		log.Fatalf("error loading secrets: %s", err)
+	}
+
+	level, err := log.ParseLevel(spec.LogLevel)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.SetLevel(level)
+	log.SetFormatter(&log.TextFormatter{
+		FullTimestamp:   true,
+		TimestampFormat: time.RFC3339Nano,
+	})
+}
+
+func main() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s, err := webhook.NewWebhookServer()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if spec.KeyManager != "" {
+		keys, err := kms.Open(spec.KeyManager, spec.KeyManagerConfig)
+		if err != nil {
+			log.Fatalf("error opening key manager: %s", err)
+		}
+		s.Envelope = kms.NewEnvelope(keys)
+	}
+	s.Secrets = secrets.NewScoped(secrets.NewCache(secretStore, webhook.SecretTTL), spec.SecretProviderPrefix)
+
+	err = usernames.ValidateExclusions(spec.ProtectedAccounts)
+	if err != nil {
+		log.Fatalf("error in PROTECTED_ACCOUNTS: %s", err)
+	}
+	s.Protected = spec.ProtectedAccounts
+
+	if spec.EgressInterval > 0 {
+		go s.WatchEgress(spec.EgressInterval)
+	}
+
+	if spec.Rules != "" {
+		err = rules.Watch(spec.Rules, spec.RulesInterval)
+		if err != nil {
+			log.Fatalf("error loading rules: %s", err)
+		}
+	}
+
+	opts := dispatch.Options{
+		MaxOutstanding:    spec.Concurrency,
+		DrainTimeout:      spec.DrainTimeout,
+		Queue:             spec.Queue,
+		QueueConfig:       spec.QueueConfig,
+		ProjectID:         spec.ProjectID,
+		SubscriptionID:    spec.SubscriptionID,
+		ResultTopicID:     spec.ResultTopicID,
+		ResultQueue:       spec.ResultQueue,
+		ResultQueueConfig: spec.ResultQueueConfig,
+	}
+	if spec.DBConnectionString != "" {
+		database, err := db.New(spec.DBConnectionString)
+		if err != nil {
+			log.Fatalf("error connecting to database: %s", err)
+		}
+		defer database.Close() // nolint:errcheck
+		opts.Claims = database
+	}
+
+	worker := pull.NewWorker(s, spec.Concurrency)
+	dis, err := dispatch.NewDispatcher(ctx, opts, worker)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if _, err = dis.Backlog(ctx); err != queue.ErrBacklogUnsupported {
+		go worker.WatchBacklog(ctx, dis.Backlog, spec.BacklogInterval)
+	}
+
+	r := chi.NewRouter()
+	r.Get("/healthz", worker.HealthzHandler)
+	r.Get("/readyz", worker.ReadyzHandler)
+	r.Get("/metrics", worker.MetricsHandler)
+	go func() {
+		log.Printf("starting probe server on port %d", spec.Port)
+		log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", spec.Port), r))
+	}()
+
+	// stop taking tasks on SIGTERM and exit once in-flight tasks complete
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		log.Printf("shutting down, waiting for in-flight tasks")
+		worker.SetReady(false)
+		cancel()
+	}()
+
+	log.Printf("starting queue worker for subscription %s", spec.SubscriptionID)
+	worker.SetReady(true)
+	err = dis.Listen(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -16,6 +16,7 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -27,10 +28,20 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"github.com/praetorian-inc/trident/pkg/nozzle"
+	"github.com/praetorian-inc/trident/pkg/replay"
+	"github.com/praetorian-inc/trident/pkg/rules"
 
 	_ "github.com/praetorian-inc/trident/pkg/nozzle/adfs"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/atlassian"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/bigip"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/external"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/ivanti"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/mock"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/netskope"
 	_ "github.com/praetorian-inc/trident/pkg/nozzle/o365"
 	_ "github.com/praetorian-inc/trident/pkg/nozzle/okta"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/sonicwall"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/zscaler"
 )
 
 var (
@@ -38,6 +49,9 @@ var (
 	flagProviderMeta string
 	flagUsernames    string
 	flagPasswords    string
+	flagRules        string
+	flagRecord       string
+	flagReplay       string
 )
 
 func main() {
@@ -45,14 +59,30 @@ func main() {
 	flag.StringVar(&flagProviderMeta, "metadata", "{}", "configuration data for auth provider")
 	flag.StringVar(&flagUsernames, "usernames", "-", "path to username list (or '-' for stdin)")
 	flag.StringVar(&flagPasswords, "passwords", "passwords.txt", "path to password list")
+	flag.StringVar(&flagRules, "rules", "", "path or url of response classification rules")
+	flag.StringVar(&flagRecord, "record", "", "append the sanitized responses of each login to this file")
+	flag.StringVar(&flagReplay, "replay", "",
+		"classify the responses recorded in this file instead of logging in, and report the changed outcomes")
 	flag.Parse()
 
+	if flagRules != "" {
+		err := rules.Watch(flagRules, 0)
+		if err != nil {
+			log.Fatalf("error loading rules: %s", err)
+		}
+	}
+
 	var metadata map[string]string
 	err := json.Unmarshal([]byte(flagProviderMeta), &metadata)
 	if err != nil {
 		log.Fatalf("error parsing provider metadata: %s", err)
 	}
 
+	if flagReplay != "" {
+		replayRecordings(metadata)
+		return
+	}
+
 	if flagUsernames == "-" {
 		flagUsernames = "/dev/stdin"
 	}
@@ -69,10 +99,21 @@ func main() {
 	}
 	passwords := strings.Split(string(content), "\n")
 
+	if flagRecord != "" {
+		metadata["capture_response"] = "true"
+	}
 	noz, err := nozzle.Open(flagProvider, metadata)
 	if err != nil {
 		log.Fatalf("error opening nozzle: %s", err)
 	}
+	if flagRecord != "" {
+		f, err := os.OpenFile(flagRecord, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			log.Fatalf("error opening recording: %s", err)
+		}
+		defer f.Close() // nolint:errcheck,gosec
+		noz = replay.NewRecorder(flagProvider, noz, f)
+	}
 
 	var wg sync.WaitGroup
 
@@ -90,7 +131,7 @@ func main() {
 
 			wg.Add(1)
 			go func(username, password string) {
-				res, err := noz.Login(username, password)
+				res, err := noz.Login(context.Background(), username, password)
 				if err != nil {
 					log.Fatalf("error logging in: %s", err)
 				}
@@ -112,3 +153,40 @@ func main() {
 
 	wg.Wait()
 }
+
+// replayRecordings classifies the recorded responses of the provider and
+// exits with a non-zero status if the outcome of any of them changed. no
+// request is sent to the provider.
+func replayRecordings(metadata map[string]string) {
+	noz, err := nozzle.Open(flagProvider, metadata)
+	if err != nil {
+		log.Fatalf("error opening nozzle: %s", err)
+	}
+	classifier, ok := noz.(nozzle.Classifier)
+	if !ok {
+		log.Fatalf("the %s nozzle does not support replay", flagProvider)
+	}
+
+	all, err := replay.Load(flagReplay)
+	if err != nil {
+		log.Fatalf("error reading recordings: %s", err)
+	}
+	var recordings []replay.Recording
+	for _, rec := range all {
+		if rec.Provider == flagProvider {
+			recordings = append(recordings, rec)
+		}
+	}
+	if skipped := len(all) - len(recordings); skipped > 0 {
+		log.Warnf("skipped %d recordings of other providers", skipped)
+	}
+
+	mismatches := replay.Replay(classifier, recordings)
+	for _, m := range mismatches {
+		fmt.Println(m)
+	}
+	fmt.Printf("replayed %d recordings, %d changed\n", len(recordings), len(mismatches))
+	if len(mismatches) > 0 {
+		os.Exit(1)
+	}
+}
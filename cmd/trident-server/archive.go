@@ -0,0 +1,183 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jedib0t/go-pretty/table"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/praetorian-inc/trident/pkg/archive"
+	"github.com/praetorian-inc/trident/pkg/db"
+	"github.com/praetorian-inc/trident/pkg/retention"
+
+	_ "github.com/praetorian-inc/trident/pkg/archive/file"
+	_ "github.com/praetorian-inc/trident/pkg/archive/gcs"
+	_ "github.com/praetorian-inc/trident/pkg/archive/s3"
+)
+
+var (
+	// archive the campaigns which ended more than this many days ago
+	flagDays int
+
+	// purge campaigns without archiving them
+	flagPurgeOnly bool
+
+	// an archive to decrypt rather than archiving campaigns
+	flagRead string
+)
+
+var archiveCmd = &cobra.Command{
+	Use:   "archive",
+	Short: "archive and purge the data of ended campaigns",
+	Long: `archives the campaign given with --campaign, or every campaign which ended
+more than --days ago, to the archive store configured like the orchestrator's
+(ARCHIVE_STORE and ARCHIVE_STORE_CONFIG), then purges the campaigns with their
+results, failed tasks and task claims from the database. each line of an
+archive is encrypted with the key manager (KEY_MANAGER and KEY_MANAGER_CONFIG);
+--read decrypts an archive to stdout. the orchestrator does the same
+periodically when RETENTION_DAYS is set.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if flagRead != "" {
+			readArchive(cmd, args)
+			return
+		}
+		archiveCampaigns(cmd, args)
+	},
+}
+
+func init() {
+	archiveCmd.Flags().UintVar(&flagCampaign, "campaign", 0, "the campaign to archive")
+	archiveCmd.Flags().IntVar(&flagDays, "days", 0,
+		"archive the campaigns which ended more than this many days ago")
+	archiveCmd.Flags().BoolVar(&flagPurgeOnly, "purge-only", false,
+		"purge the campaigns without archiving them")
+	archiveCmd.Flags().StringVar(&flagRead, "read", "",
+		"decrypt the archive at this path to stdout")
+	archiveCmd.Flags().BoolVar(&flagDryRun, "dry-run", false,
+		"list the campaigns which would be archived without archiving them")
+
+	rootCmd.AddCommand(archiveCmd)
+}
+
+// openArchive opens the archive store configured like the orchestrator's
+// (ARCHIVE_STORE and ARCHIVE_STORE_CONFIG).
+func openArchive() archive.Store {
+	name := os.Getenv("ARCHIVE_STORE")
+	if name == "" {
+		log.Fatal("ARCHIVE_STORE is required unless --purge-only is set")
+	}
+	var opts archive.Options
+	if config := os.Getenv("ARCHIVE_STORE_CONFIG"); config != "" {
+		err := opts.UnmarshalText([]byte(config))
+		if err != nil {
+			log.Fatalf("error parsing ARCHIVE_STORE_CONFIG: %s", err)
+		}
+	}
+	store, err := archive.Open(name, opts)
+	if err != nil {
+		log.Fatalf("error opening archive store: %s", err)
+	}
+	return store
+}
+
+func archiveCampaigns(cmd *cobra.Command, args []string) {
+	if (flagCampaign == 0) == (flagDays <= 0) {
+		log.Fatal("exactly one of --campaign or --days is required")
+	}
+
+	database := openDB()
+	defer database.Close() // nolint:errcheck
+
+	a := &retention.Archiver{DB: database, Envelope: openEnvelope()}
+	if !flagPurgeOnly {
+		if a.Envelope == nil {
+			log.Fatal("KEY_MANAGER is required unless --purge-only is set")
+		}
+		a.Store = openArchive()
+	}
+
+	var campaigns []db.Campaign
+	if flagCampaign != 0 {
+		c, err := database.DescribeCampaign(db.Query{Filter: map[string]interface{}{"id": flagCampaign}})
+		if err != nil {
+			log.Fatalf("error reading campaign %d: %s", flagCampaign, err)
+		}
+		if time.Now().Before(c.NotAfter) && c.Status != db.CampaignStatusCancelled {
+			log.Fatalf("campaign %d has not ended (not after %s)", c.ID, c.NotAfter.Format(time.RFC3339))
+		}
+		campaigns = append(campaigns, c)
+	} else {
+		var err error
+		campaigns, err = a.Expired(time.Duration(flagDays) * 24 * time.Hour)
+		if err != nil {
+			log.Fatalf("error selecting expired campaigns: %s", err)
+		}
+	}
+
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"campaign", "ended", "results", "failed tasks", "archive"})
+	var archived int
+	for _, c := range campaigns {
+		if flagDryRun {
+			t.AppendRow(table.Row{c.ID, c.NotAfter.Format(time.RFC3339), "", "", ""})
+			continue
+		}
+		report, err := a.Archive(context.Background(), c)
+		if err != nil {
+			log.Fatal(err)
+		}
+		t.AppendRow(table.Row{c.ID, c.NotAfter.Format(time.RFC3339), report.Results, report.FailedTasks, report.Object})
+		archived++
+	}
+	if len(campaigns) > 0 {
+		t.Render()
+	}
+
+	verb := "purged"
+	if flagDryRun {
+		verb = "would purge"
+		archived = len(campaigns)
+	}
+	fmt.Printf("%s %d campaigns\n", verb, archived)
+}
+
+func readArchive(cmd *cobra.Command, args []string) {
+	envelope := openEnvelope()
+	if envelope == nil {
+		log.Fatal("KEY_MANAGER is required to decrypt an archive")
+	}
+
+	f, err := os.Open(flagRead)
+	if err != nil {
+		log.Fatalf("error opening archive: %s", err)
+	}
+	defer f.Close() // nolint:errcheck
+
+	enc := json.NewEncoder(os.Stdout)
+	err = retention.Read(context.Background(), f, envelope, func(rec retention.Record) error {
+		return enc.Encode(rec)
+	})
+	if err != nil {
+		log.Fatalf("error reading archive: %s", err)
+	}
+}
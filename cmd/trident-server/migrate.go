@@ -0,0 +1,148 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jedib0t/go-pretty/table"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+)
+
+var (
+	// only print the migrations which would be run
+	flagDryRun bool
+
+	// the number of migrations to roll back
+	flagSteps int
+)
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "apply pending schema migrations",
+	Long: `applies the schema migrations built into this version of trident which
+have not been applied to the database yet. the orchestrator applies them on
+startup unless AUTO_MIGRATE is false.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		migrateUp(cmd, args)
+	},
+}
+
+var migrateStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "list the schema migrations and whether they are applied",
+	Run: func(cmd *cobra.Command, args []string) {
+		migrateStatus(cmd, args)
+	},
+}
+
+var migrateRollbackCmd = &cobra.Command{
+	Use:   "rollback",
+	Short: "roll back the most recent schema migrations",
+	Long: `reverts the most recently applied schema migrations. rolling back a
+migration may delete data, use --dry-run to list the migrations which would be
+reverted.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		migrateRollback(cmd, args)
+	},
+}
+
+func init() {
+	migrateCmd.Flags().BoolVar(&flagDryRun, "dry-run", false,
+		"list the pending migrations without applying them")
+	migrateRollbackCmd.Flags().BoolVar(&flagDryRun, "dry-run", false,
+		"list the migrations which would be rolled back without reverting them")
+	migrateRollbackCmd.Flags().IntVar(&flagSteps, "steps", 1,
+		"the number of migrations to roll back")
+
+	migrateCmd.AddCommand(migrateStatusCmd)
+	migrateCmd.AddCommand(migrateRollbackCmd)
+	rootCmd.AddCommand(migrateCmd)
+}
+
+// printMigrations prints the migrations which were (or would be) run.
+func printMigrations(verb string, migrations []db.Migration) {
+	if len(migrations) == 0 {
+		fmt.Println("no migrations to run")
+		return
+	}
+	if flagDryRun {
+		verb = "would be " + verb
+	}
+	for _, m := range migrations {
+		fmt.Printf("%s %d (%s)\n", verb, m.Version, m.Name)
+	}
+}
+
+func migrateUp(cmd *cobra.Command, args []string) {
+	database := openDB()
+	defer database.Close() // nolint:errcheck
+
+	applied, err := database.Migrate(flagDryRun)
+	if err != nil {
+		log.Fatalf("error migrating database: %s", err)
+	}
+	printMigrations("applied", applied)
+}
+
+func migrateRollback(cmd *cobra.Command, args []string) {
+	if flagSteps < 1 {
+		log.Fatal("--steps must be at least 1")
+	}
+
+	database := openDB()
+	defer database.Close() // nolint:errcheck
+
+	reverted, err := database.Rollback(flagSteps, flagDryRun)
+	if err != nil {
+		log.Fatalf("error rolling back database: %s", err)
+	}
+	printMigrations("rolled back", reverted)
+}
+
+func migrateStatus(cmd *cobra.Command, args []string) {
+	database := openDB()
+	defer database.Close() // nolint:errcheck
+
+	migrations, err := database.Migrations()
+	if err != nil {
+		log.Fatalf("error reading migrations: %s", err)
+	}
+	applied, err := database.AppliedMigrations()
+	if err != nil {
+		log.Fatalf("error reading applied migrations: %s", err)
+	}
+	appliedAt := make(map[uint]time.Time)
+	for _, m := range applied {
+		appliedAt[m.Version] = m.AppliedAt
+	}
+
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"version", "name", "applied at"})
+	for _, m := range migrations {
+		at := "pending"
+		if ts, ok := appliedAt[m.Version]; ok {
+			at = ts.Format(time.RFC3339)
+		}
+		t.AppendRow(table.Row{m.Version, m.Name, at})
+	}
+	t.Render()
+}
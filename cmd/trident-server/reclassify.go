@@ -0,0 +1,173 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/jedib0t/go-pretty/table"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+	"github.com/praetorian-inc/trident/pkg/kms"
+	"github.com/praetorian-inc/trident/pkg/nozzle"
+	"github.com/praetorian-inc/trident/pkg/reclassify"
+	"github.com/praetorian-inc/trident/pkg/rules"
+	"github.com/praetorian-inc/trident/pkg/secrets"
+
+	_ "github.com/praetorian-inc/trident/pkg/kms/gcpkms"
+	_ "github.com/praetorian-inc/trident/pkg/kms/local"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/atlassian"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/bigip"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/ivanti"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/netskope"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/o365"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/okta"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/sonicwall"
+)
+
+var (
+	// the campaign to re-classify
+	flagCampaign uint
+
+	// the classification rules applied while re-classifying
+	flagRules string
+)
+
+var reclassifyCmd = &cobra.Command{
+	Use:   "reclassify",
+	Short: "re-classify the results of a campaign from their captured responses",
+	Long: `re-runs the classification of the campaign's nozzle (and of the
+classification rules given with --rules) over the provider responses captured
+with the capture_response nozzle option, and updates the results whose outcome
+changed. attempts are never repeated. sealed responses are unsealed with the
+key manager configured like the orchestrator's (KEY_MANAGER and
+KEY_MANAGER_CONFIG).`,
+	Run: func(cmd *cobra.Command, args []string) {
+		reclassifyCampaign(cmd, args)
+	},
+}
+
+func init() {
+	reclassifyCmd.Flags().UintVar(&flagCampaign, "campaign", 0, "the campaign to re-classify")
+	reclassifyCmd.Flags().StringVar(&flagRules, "rules", "",
+		"a file or URL of classification rules to apply")
+	reclassifyCmd.Flags().BoolVar(&flagDryRun, "dry-run", false,
+		"list the results which would change without updating them")
+
+	rootCmd.AddCommand(reclassifyCmd)
+}
+
+// openEnvelope opens the key manager configured like the orchestrator's
+// (KEY_MANAGER and KEY_MANAGER_CONFIG), if any.
+func openEnvelope() *kms.Envelope {
+	name := os.Getenv("KEY_MANAGER")
+	if name == "" {
+		return nil
+	}
+	var opts kms.Options
+	if config := os.Getenv("KEY_MANAGER_CONFIG"); config != "" {
+		err := opts.UnmarshalText([]byte(config))
+		if err != nil {
+			log.Fatalf("error parsing KEY_MANAGER_CONFIG: %s", err)
+		}
+	}
+	keys, err := kms.Open(name, opts)
+	if err != nil {
+		log.Fatalf("error opening key manager: %s", err)
+	}
+	return kms.NewEnvelope(keys)
+}
+
+func reclassifyCampaign(cmd *cobra.Command, args []string) {
+	if flagCampaign == 0 {
+		log.Fatal("--campaign is required")
+	}
+	if flagRules != "" {
+		rs, err := rules.Load(flagRules)
+		if err != nil {
+			log.Fatalf("error loading classification rules: %s", err)
+		}
+		rules.Set(rs)
+	}
+
+	database := openDB()
+	defer database.Close() // nolint:errcheck
+
+	ctx := context.Background()
+	campaign, err := database.DescribeCampaign(db.Query{Filter: map[string]interface{}{"id": flagCampaign}})
+	if err != nil {
+		log.Fatalf("error reading campaign %d: %s", flagCampaign, err)
+	}
+	var opts map[string]string
+	err = json.Unmarshal(campaign.ProviderMetadata, &opts)
+	if err != nil {
+		log.Fatalf("error decoding provider metadata: %s", err)
+	}
+	opts, err = secrets.ResolveOptions(ctx, secrets.NewScoped(openSecrets(), os.Getenv("SECRET_PROVIDER_PREFIX")), opts)
+	if err != nil {
+		log.Fatalf("error resolving provider secrets: %s", err)
+	}
+	noz, err := nozzle.Open(campaign.Provider, opts)
+	if err != nil {
+		log.Fatalf("error opening nozzle: %s", err)
+	}
+	r, err := reclassify.New(noz, openEnvelope())
+	if err != nil {
+		log.Fatalf("unable to re-classify %s results: %s", campaign.Provider, err)
+	}
+
+	results, err := database.SelectCapturedResults(flagCampaign)
+	if err != nil {
+		log.Fatalf("error reading results: %s", err)
+	}
+
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"result", "username", "before", "after"})
+	var changed, failed int
+	for i := range results {
+		change, ok, err := r.Result(ctx, &results[i])
+		if err != nil {
+			log.Warn(err)
+			failed++
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if !flagDryRun {
+			err = database.UpdateResultClassification(&results[i])
+			if err != nil {
+				log.Fatalf("error updating result %d: %s", results[i].ID, err)
+			}
+		}
+		t.AppendRow(table.Row{results[i].ID, results[i].Username, change.Before, change.After})
+		changed++
+	}
+	if changed > 0 {
+		t.Render()
+	}
+
+	verb := "updated"
+	if flagDryRun {
+		verb = "would update"
+	}
+	fmt.Printf("re-classified %d results, %s %d (%d failed)\n", len(results), verb, changed, failed)
+}
@@ -15,30 +15,109 @@
 package main
 
 import (
-	"crypto/subtle"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
-	"github.com/go-chi/chi"
-	"github.com/go-chi/chi/middleware"
 	"github.com/kelseyhightower/envconfig"
 	log "github.com/sirupsen/logrus"
 
+	"github.com/praetorian-inc/trident/pkg/auth/token"
+	"github.com/praetorian-inc/trident/pkg/kms"
+	"github.com/praetorian-inc/trident/pkg/rules"
+	"github.com/praetorian-inc/trident/pkg/secrets"
+	"github.com/praetorian-inc/trident/pkg/usernames"
 	"github.com/praetorian-inc/trident/pkg/worker/webhook"
 
+	_ "github.com/praetorian-inc/trident/pkg/action/o365mailbox"
+	_ "github.com/praetorian-inc/trident/pkg/action/smtpauth"
+	_ "github.com/praetorian-inc/trident/pkg/kms/gcpkms"
+	_ "github.com/praetorian-inc/trident/pkg/kms/local"
+
 	_ "github.com/praetorian-inc/trident/pkg/nozzle/adfs"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/atlassian"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/bigip"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/external"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/ivanti"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/mock"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/netskope"
 	_ "github.com/praetorian-inc/trident/pkg/nozzle/o365"
 	_ "github.com/praetorian-inc/trident/pkg/nozzle/okta"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/sonicwall"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/zscaler"
+	_ "github.com/praetorian-inc/trident/pkg/secrets/gcpsecretmanager"
+	_ "github.com/praetorian-inc/trident/pkg/secrets/vault"
 )
 
 type specification struct {
 	LogLevel    string `envconfig:"LOG_LEVEL" default:"INFO"`
 	Port        int    `envconfig:"PORT"`
 	AccessToken []byte `envconfig:"ACCESS_TOKEN"`
+
+	// the port assigned to Azure Functions custom handlers, used when PORT
+	// is unset
+	FunctionsPort int `envconfig:"FUNCTIONS_CUSTOMHANDLER_PORT"`
+
+	// signed token configuration options (preferred over ACCESS_TOKEN). a
+	// comma separated list of secrets allows for zero-downtime rotation.
+	SigningKeys string        `envconfig:"SIGNING_KEYS"`
+	TokenTTL    time.Duration `envconfig:"TOKEN_TTL" default:"30s"`
+
+	// the redis recording token nonces, shared by the replicas of the
+	// worker so that a request cannot be replayed against another replica.
+	// nonces are only recorded by the replica verifying them when unset.
+	NonceRedisURI      string `envconfig:"NONCE_REDIS_URI"`
+	NonceRedisPassword string `envconfig:"NONCE_REDIS_PASSWORD"`
+
+	// mutual TLS configuration options. CLIENT_CA_FILE requires
+	// TLS_CERT_FILE.
+	TLSCertFile  string `envconfig:"TLS_CERT_FILE"`
+	TLSKeyFile   string `envconfig:"TLS_KEY_FILE"`
+	ClientCAFile string `envconfig:"CLIENT_CA_FILE"`
+
+	// the longest in-flight tasks are waited for on SIGTERM (Cloud Run
+	// allows 10s)
+	DrainTimeout time.Duration `envconfig:"DRAIN_TIMEOUT" default:"9s"`
+
+	// key management configuration options used to decrypt task passwords
+	KeyManager       string      `envconfig:"KEY_MANAGER"`
+	KeyManagerConfig kms.Options `envconfig:"KEY_MANAGER_CONFIG"`
+
+	// how often the egress IP reported with results is refreshed (0
+	// disables refreshing)
+	EgressInterval time.Duration `envconfig:"EGRESS_INTERVAL" default:"5m"`
+
+	// response classification rules, loaded from a file or an http(s) URL
+	// and reloaded every RULES_INTERVAL (0 disables reloading)
+	Rules         string        `envconfig:"RULES"`
+	RulesInterval time.Duration `envconfig:"RULES_INTERVAL" default:"5m"`
+
+	// secret store configuration options. values of the form "secret:<name>"
+	// are fetched from the store (vault or gcpsecretmanager) on startup
+	SecretStore       string          `envconfig:"SECRET_STORE"`
+	SecretStoreConfig secrets.Options `envconfig:"SECRET_STORE_CONFIG"`
+
+	// prefix of the secrets that the provider metadata and actions of tasks
+	// may reference (e.g. trident/providers/). other references are refused
+	// so that a campaign cannot send arbitrary secrets to its target.
+	SecretProviderPrefix string `envconfig:"SECRET_PROVIDER_PREFIX"`
+
+	// comma separated patterns of accounts the worker refuses to attempt,
+	// whatever the campaign (e.g. admin-*@example.org)
+	ProtectedAccounts []string `envconfig:"PROTECTED_ACCOUNTS"`
 }
 
-var spec specification
+var (
+	spec        specification
+	secretStore secrets.Store
+)
 
 func init() {
 	err := envconfig.Process("worker", &spec)
@@ -46,6 +125,15 @@ func init() {
 		log.Fatal(err)
 	}
 
+	secretStore, err = secrets.Load(spec.SecretStore, spec.SecretStoreConfig, &spec)
+	if err != nil {
+		log.Fatalf("error loading secrets: %s", err)
+	}
+
+	if spec.Port == 0 {
+		spec.Port = spec.FunctionsPort
+	}
+
 	level, err := log.ParseLevel(spec.LogLevel)
 	if err != nil {
 		log.Fatal(err)
@@ -58,42 +146,113 @@ func init() {
 	})
 }
 
-func tokenVerifier(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		token := r.Header.Get("X-Access-Token")
-		if subtle.ConstantTimeCompare([]byte(token), spec.AccessToken) == 0 {
-			http.Error(w, http.StatusText(403), 403)
-			return
-		}
-		next.ServeHTTP(w, r)
-	})
+// tlsConfig requires clients to present a certificate signed by the configured
+// client CA.
+func tlsConfig() (*tls.Config, error) {
+	pem, err := ioutil.ReadFile(spec.ClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", spec.ClientCAFile)
+	}
+	return &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  pool,
+		MinVersion: tls.VersionTLS12,
+	}, nil
 }
 
 func main() {
+	// client certificates are only verified over TLS, do not silently serve
+	// plain HTTP
+	if spec.ClientCAFile != "" && spec.TLSCertFile == "" {
+		log.Fatal("CLIENT_CA_FILE requires TLS_CERT_FILE and TLS_KEY_FILE")
+	}
+
 	s, err := webhook.NewWebhookServer()
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	r := chi.NewRouter()
+	if spec.KeyManager != "" {
+		keys, err := kms.Open(spec.KeyManager, spec.KeyManagerConfig)
+		if err != nil {
+			log.Fatalf("error opening key manager: %s", err)
+		}
+		s.Envelope = kms.NewEnvelope(keys)
+	}
+	s.Secrets = secrets.NewScoped(secrets.NewCache(secretStore, webhook.SecretTTL), spec.SecretProviderPrefix)
+
+	err = usernames.ValidateExclusions(spec.ProtectedAccounts)
+	if err != nil {
+		log.Fatalf("error in PROTECTED_ACCOUNTS: %s", err)
+	}
+	s.Protected = spec.ProtectedAccounts
+
+	if spec.EgressInterval > 0 {
+		go s.WatchEgress(spec.EgressInterval)
+	}
+
+	if spec.Rules != "" {
+		err = rules.Watch(spec.Rules, spec.RulesInterval)
+		if err != nil {
+			log.Fatalf("error loading rules: %s", err)
+		}
+	}
 
-	// A good base middleware stack
-	r.Use(middleware.RequestID)
-	r.Use(middleware.RealIP)
-	r.Use(middleware.Logger)
-	r.Use(middleware.Recoverer)
+	auth := webhook.AccessTokenVerifier(spec.AccessToken)
+	if spec.SigningKeys != "" {
+		verifier := token.NewVerifier(spec.SigningKeys, spec.TokenTTL)
+		if spec.NonceRedisURI != "" {
+			verifier.Nonces, err = token.NewRedisNonces(spec.NonceRedisURI, spec.NonceRedisPassword)
+			if err != nil {
+				log.Fatalf("error connecting to redis: %s", err)
+			}
+		}
+		auth = verifier.Middleware
+	}
 
-	// Set a timeout value on the request context (ctx), that will signal
-	// through ctx.Done() that the request has timed out and further
-	// processing should be stopped.
-	r.Use(middleware.Timeout(60 * time.Second))
+	srv := &http.Server{
+		Addr:    fmt.Sprintf(":%d", spec.Port),
+		Handler: webhook.NewRouter(s, auth),
+	}
 
-	// Insert authenication middleware to verify access token on all requests
-	r.Use(tokenVerifier)
+	// stop accepting tasks on SIGTERM and exit once in-flight tasks complete,
+	// so that their results are returned to the dispatcher
+	drained := make(chan struct{})
+	go func() {
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+		<-sigs
+		log.Printf("shutting down, waiting for in-flight tasks")
 
-	r.Get("/healthz", s.HealthzHandler)
-	r.Post("/", s.EventHandler)
+		ctx, cancel := context.WithTimeout(context.Background(), spec.DrainTimeout)
+		defer cancel()
+		err := srv.Shutdown(ctx)
+		if err != nil {
+			log.Printf("error waiting for in-flight tasks: %s", err)
+		}
+		close(drained)
+	}()
 
-	log.Printf("starting server on port %d", spec.Port)
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", spec.Port), r))
+	if spec.TLSCertFile == "" {
+		log.Printf("starting server on port %d", spec.Port)
+		err = srv.ListenAndServe()
+	} else {
+		if spec.ClientCAFile != "" {
+			srv.TLSConfig, err = tlsConfig()
+			if err != nil {
+				log.Fatalf("error loading client ca: %s", err)
+			}
+		}
+
+		log.Printf("starting tls server on port %d", spec.Port)
+		err = srv.ListenAndServeTLS(spec.TLSCertFile, spec.TLSKeyFile)
+	}
+	if err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-drained
 }
@@ -1,23 +1,30 @@
 module github.com/praetorian-inc/trident
 
-go 1.14
+go 1.16
 
 require (
 	cloud.google.com/go/pubsub v1.6.1
 	github.com/Azure/go-ntlmssp v0.0.0-20200615164410-66371956d46c
+	github.com/alicebob/miniredis/v2 v2.23.1
+	github.com/aws/aws-lambda-go v1.23.0
 	github.com/cloudflare/cloudflared v0.0.0-20200820175612-810d268c99ac
 	github.com/coreos/go-oidc/v3 v3.0.0-alpha.1
 	github.com/go-chi/chi v4.1.2+incompatible
 	github.com/go-openapi/strfmt v0.19.5 // indirect
 	github.com/go-redis/redis/v7 v7.4.0
+	github.com/go-sql-driver/mysql v1.5.0
 	github.com/golang/gddo v0.0.0-20200715224205-051695c33a3f
 	github.com/jedib0t/go-pretty v4.3.0+incompatible
 	github.com/jinzhu/gorm v1.9.16
 	github.com/kelseyhightower/envconfig v1.4.0
 	github.com/lib/pq v1.8.0
 	github.com/mattn/go-runewidth v0.0.9 // indirect
+	github.com/nats-io/nats-server/v2 v2.8.4
+	github.com/nats-io/nats.go v1.15.0
 	github.com/sirupsen/logrus v1.6.0
 	github.com/spf13/cobra v1.0.0
 	github.com/spf13/viper v1.7.1
-	golang.org/x/time v0.0.0-20200630173020-3af7569d3a1e
+	golang.org/x/time v0.0.0-20211116232009-f0f3c7e86c11
+	google.golang.org/api v0.29.0
+	gopkg.in/yaml.v2 v2.2.4
 )
@@ -0,0 +1,181 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package action defines an interface for post-success actions: read-only
+// follow-up checks run by the workers once a credential validates (e.g.
+// whether the account can access its mailbox or authenticate to SMTP). Each
+// campaign lists the actions to run, and their findings are recorded with
+// the result. Similar to the nozzle package, actions register themselves and
+// must be "blank imported".
+//
+//  import (
+//      "github.com/praetorian-inc/trident/pkg/action"
+//
+//      _ "github.com/praetorian-inc/trident/pkg/action/o365mailbox"
+//      _ "github.com/praetorian-inc/trident/pkg/action/smtpauth"
+//  )
+//
+//  act, err := action.Open("smtp_auth", map[string]string{"host":"smtp.office365.com"})
+//  if err != nil {
+//      // handle error
+//  }
+//  data, err := act.Run(ctx, "username", "password")
+//  // ...
+//
+// Actions must be strictly read-only: they may authenticate and read, but
+// never send mail, change settings or otherwise modify the account. HTTP
+// actions should use Client, which refuses any request that is not a read.
+package action
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/praetorian-inc/trident/pkg/event"
+)
+
+// Timeout bounds the run of a single action.
+var Timeout = 30 * time.Second
+
+var (
+	driversMu sync.RWMutex
+	drivers   = make(map[string]Driver)
+)
+
+// Driver is the interface that wraps creation of an Action.
+type Driver interface {
+	New(opts map[string]string) (Action, error)
+}
+
+// Action is the interface that wraps a read-only check of a valid credential.
+// Run returns the structured data of the finding, and should abandon the
+// check once ctx is done. Run must be safe to call from multiple goroutines.
+type Action interface {
+	Run(ctx context.Context, username, password string) (map[string]interface{}, error)
+}
+
+// Open opens an action specified by its driver name (e.g. smtp_auth) and
+// configures it via the provided opts argument. Each Action should document
+// its configuration options in its New() method.
+func Open(name string, opts map[string]string) (Action, error) {
+	driversMu.RLock()
+	d, ok := drivers[name]
+	driversMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("action: unknown driver %q (forgotten import?)", name)
+	}
+
+	return d.New(opts)
+}
+
+// Register makes an action driver available at the provided name. If Register
+// is called twice or if the driver is nil, it panics.
+func Register(name string, driver Driver) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	if driver == nil {
+		panic("action: Register driver is nil")
+	}
+	if _, dup := drivers[name]; dup {
+		panic("action: Register called twice for driver " + name)
+	}
+	drivers[name] = driver
+}
+
+// Drivers returns the sorted names of the registered actions.
+func Drivers() []string {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+	names := make([]string, 0, len(drivers))
+	for name := range drivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Run opens and runs an action against a valid credential. errors are
+// recorded in the finding rather than returned, so that a failing action does
+// not fail the task.
+func Run(ctx context.Context, spec event.Action, username, password string) event.Finding {
+	f := event.Finding{Action: spec.Name}
+
+	act, err := Open(spec.Name, spec.Options)
+	if err != nil {
+		f.Error = err.Error()
+		return f
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+	f.Data, err = act.Run(ctx, username, password)
+	if err != nil {
+		f.Error = err.Error()
+	}
+	return f
+}
+
+// Client returns an HTTP client which only sends reads (GET and HEAD
+// requests), except for POSTs to the given token endpoints which are needed
+// to authenticate. redirects are not followed.
+func Client(tokenURLs ...string) *http.Client {
+	return &http.Client{
+		Transport: &readOnly{
+			next:   http.DefaultClient.Transport,
+			tokens: tokenURLs,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// readOnly is an http.RoundTripper refusing requests which could modify the
+// account.
+type readOnly struct {
+	next   http.RoundTripper
+	tokens []string
+}
+
+func (t *readOnly) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.allowed(req) {
+		return nil, fmt.Errorf("action: refusing %s %s, actions are read-only", req.Method, req.URL.Redacted())
+	}
+
+	next := t.next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return next.RoundTrip(req)
+}
+
+func (t *readOnly) allowed(req *http.Request) bool {
+	switch req.Method {
+	case "GET", "HEAD":
+		return true
+	case "POST":
+		u := *req.URL
+		u.RawQuery = ""
+		for _, token := range t.tokens {
+			if u.String() == token {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package action
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/praetorian-inc/trident/pkg/event"
+)
+
+func TestClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	client := Client(srv.URL + "/token")
+
+	var testcases = []struct {
+		method  string
+		path    string
+		allowed bool
+	}{
+		{method: "GET", path: "/me", allowed: true},
+		{method: "HEAD", path: "/me", allowed: true},
+		{method: "POST", path: "/token", allowed: true},
+		{method: "POST", path: "/token?api-version=1", allowed: true},
+		{method: "POST", path: "/me/sendMail", allowed: false},
+		{method: "PATCH", path: "/me", allowed: false},
+		{method: "DELETE", path: "/me", allowed: false},
+	}
+
+	for _, test := range testcases {
+		req, _ := http.NewRequest(test.method, srv.URL+test.path, nil)
+		resp, err := client.Do(req)
+		if err == nil {
+			resp.Body.Close() // nolint:errcheck,gosec
+		}
+		if (err == nil) != test.allowed {
+			t.Errorf("%s %s: expected allowed=%t, got %v", test.method, test.path, test.allowed, err)
+		}
+	}
+}
+
+type testDriver struct{}
+
+func (testDriver) New(opts map[string]string) (Action, error) {
+	return testAction{}, nil
+}
+
+type testAction struct{}
+
+func (testAction) Run(ctx context.Context, username, password string) (map[string]interface{}, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestRun(t *testing.T) {
+	f := Run(context.Background(), event.Action{Name: "unknown"}, "alice", "Password1!")
+	if f.Action != "unknown" || !strings.Contains(f.Error, "unknown driver") {
+		t.Errorf("unexpected finding %+v", f)
+	}
+
+	Register("test", testDriver{})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	f = Run(ctx, event.Action{Name: "test"}, "alice", "Password1!")
+	if f.Error != context.Canceled.Error() {
+		t.Errorf("unexpected finding %+v", f)
+	}
+}
@@ -0,0 +1,254 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package o365mailbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/praetorian-inc/trident/pkg/action"
+	"github.com/praetorian-inc/trident/pkg/retry"
+)
+
+const (
+	// DefaultClientID is the client ID of Microsoft Office, a public client
+	// which is pre-consented for the Microsoft Graph in every tenant
+	DefaultClientID = "d3590ed6-52b3-4102-aeff-aad2292ab01c"
+
+	// DefaultTenant accepts work and school accounts of any tenant
+	DefaultTenant = "organizations"
+)
+
+var (
+	tokenURL = "https://login.microsoftonline.com/%s/oauth2/v2.0/token" // nolint:gosec
+	graphURL = "https://graph.microsoft.com"
+)
+
+// Driver implements the action.Driver interface.
+type Driver struct{}
+
+func init() {
+	action.Register("o365_mailbox", Driver{})
+}
+
+// New is used to create an O365 mailbox action, which requests a Microsoft
+// Graph token for a valid credential and reads the account's profile, inbox
+// item counts, directory roles and registered authentication methods. It
+// only reads metadata: messages are never read, sent or modified. It accepts
+// the following configuration options:
+//
+// tenant
+//
+// The tenant ID or domain the token is requested from, defaults to
+// "organizations".
+//
+// client_id
+//
+// The public client the token is requested for, defaults to Microsoft
+// Office.
+func (Driver) New(opts map[string]string) (action.Action, error) {
+	a := &Action{
+		Tenant:   DefaultTenant,
+		ClientID: DefaultClientID,
+	}
+	if tenant, ok := opts["tenant"]; ok {
+		a.Tenant = tenant
+	}
+	if clientID, ok := opts["client_id"]; ok {
+		a.ClientID = clientID
+	}
+	if a.Tenant == "" || strings.ContainsAny(a.Tenant, "/?#") {
+		return nil, fmt.Errorf("o365_mailbox action requires a tenant ID or domain, got %q", a.Tenant)
+	}
+	return a, nil
+}
+
+// Action implements the action.Action interface for O365 mailboxes.
+type Action struct {
+	// Tenant is the tenant the token is requested from
+	Tenant string
+
+	// ClientID is the public client the token is requested for
+	ClientID string
+}
+
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+	ErrorCodes       []int  `json:"error_codes"`
+}
+
+type inbox struct {
+	TotalItemCount  int `json:"totalItemCount"`
+	UnreadItemCount int `json:"unreadItemCount"`
+}
+
+type profile struct {
+	DisplayName string `json:"displayName"`
+	JobTitle    string `json:"jobTitle"`
+	Department  string `json:"department"`
+}
+
+type directoryObjects struct {
+	Value []struct {
+		Type        string `json:"@odata.type"`
+		DisplayName string `json:"displayName"`
+	} `json:"value"`
+}
+
+// Run fulfils the action.Action interface. a token which cannot be issued
+// (e.g. because MFA or a conditional access policy is required) is a finding
+// rather than an error, as are mailboxes and roles the token cannot read.
+func (a *Action) Run(ctx context.Context, username, password string) (map[string]interface{}, error) {
+	u := fmt.Sprintf(tokenURL, url.PathEscape(a.Tenant))
+	client := action.Client(u)
+
+	token, err := a.token(ctx, client, u, username, password)
+	if err != nil {
+		return nil, err
+	}
+	data := map[string]interface{}{
+		"token": token.AccessToken != "",
+	}
+	if token.AccessToken == "" {
+		data["reason"] = strings.SplitN(token.ErrorDescription, "\r\n", 2)[0]
+		data["error_codes"] = token.ErrorCodes
+		return data, nil
+	}
+
+	var p profile
+	ok, err := get(ctx, client, token.AccessToken, "/v1.0/me?$select=displayName,jobTitle,department", &p)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		data["display_name"] = p.DisplayName
+		data["job_title"] = p.JobTitle
+		data["department"] = p.Department
+	}
+
+	var i inbox
+	ok, err = get(ctx, client, token.AccessToken,
+		"/v1.0/me/mailFolders/inbox?$select=totalItemCount,unreadItemCount", &i)
+	if err != nil {
+		return nil, err
+	}
+	data["mailbox"] = ok
+	if ok {
+		data["inbox_items"] = i.TotalItemCount
+		data["inbox_unread"] = i.UnreadItemCount
+	}
+
+	var roles directoryObjects
+	ok, err = get(ctx, client, token.AccessToken,
+		"/v1.0/me/memberOf/microsoft.graph.directoryRole?$select=displayName", &roles)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		names := make([]string, 0, len(roles.Value))
+		for _, r := range roles.Value {
+			names = append(names, r.DisplayName)
+		}
+		data["directory_roles"] = names
+		data["privileged"] = len(names) > 0
+	}
+
+	var methods directoryObjects
+	ok, err = get(ctx, client, token.AccessToken, "/v1.0/me/authentication/methods", &methods)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		types := make([]string, 0, len(methods.Value))
+		for _, m := range methods.Value {
+			t := strings.TrimPrefix(m.Type, "#microsoft.graph.")
+			types = append(types, strings.TrimSuffix(t, "AuthenticationMethod"))
+		}
+		data["mfa_methods"] = types
+	}
+
+	return data, nil
+}
+
+// token requests a Microsoft Graph token with the resource owner password
+// credentials grant.
+func (a *Action) token(ctx context.Context, client *http.Client, u, username, password string) (*tokenResponse, error) {
+	form := url.Values{}
+	form.Set("grant_type", "password")
+	form.Set("client_id", a.ClientID)
+	form.Set("scope", "https://graph.microsoft.com/.default")
+	form.Set("username", username)
+	form.Set("password", password)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", u, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	if resp.StatusCode != 200 && resp.StatusCode != 400 && resp.StatusCode != 401 {
+		return nil, retry.Errorf(retry.ClassifyStatus(resp.StatusCode),
+			"unhandled status code from token endpoint: %d", resp.StatusCode)
+	}
+	var token tokenResponse
+	err = json.NewDecoder(resp.Body).Decode(&token)
+	if err != nil {
+		return nil, retry.New(retry.ClassParse, err)
+	}
+	return &token, nil
+}
+
+// get reads a Graph resource into v. it returns false if the token is not
+// allowed to read the resource.
+func get(ctx context.Context, client *http.Client, token, path string, v interface{}) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", graphURL+path, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	switch resp.StatusCode {
+	case 200:
+	case 401, 403, 404:
+		return false, nil
+	default:
+		return false, retry.Errorf(retry.ClassifyStatus(resp.StatusCode),
+			"unhandled status code from graph: %d", resp.StatusCode)
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(v)
+	if err != nil {
+		return false, retry.New(retry.ClassParse, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,99 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package o365mailbox
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/organizations/oauth2/v2.0/token" {
+			r.ParseForm() // nolint:errcheck,gosec
+			switch r.PostForm.Get("password") {
+			case "Password1!":
+				w.Write([]byte(`{"access_token":"token"}`)) // nolint:errcheck,gosec
+			case "MFA1!":
+				w.WriteHeader(400)
+				w.Write([]byte(`{"error":"invalid_grant","error_description":"AADSTS50076: ` + // nolint:errcheck,gosec
+					`you must use multi-factor authentication\r\nTrace ID: 1","error_codes":[50076]}`))
+			}
+			return
+		}
+
+		if r.Method != "GET" {
+			t.Errorf("unexpected %s %s", r.Method, r.URL)
+		}
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(401)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1.0/me":
+			w.Write([]byte(`{"displayName":"Alice","jobTitle":"CFO","department":"Finance"}`)) // nolint:errcheck,gosec
+		case "/v1.0/me/mailFolders/inbox":
+			w.Write([]byte(`{"totalItemCount":120,"unreadItemCount":3}`)) // nolint:errcheck,gosec
+		case "/v1.0/me/memberOf/microsoft.graph.directoryRole":
+			w.Write([]byte(`{"value":[{"displayName":"Global Reader"}]}`)) // nolint:errcheck,gosec
+		case "/v1.0/me/authentication/methods":
+			w.WriteHeader(403)
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer srv.Close()
+
+	client := http.DefaultClient
+	http.DefaultClient = srv.Client()
+	defer func() { http.DefaultClient = client }()
+
+	tokenURL, graphURL = srv.URL+"/%s/oauth2/v2.0/token", srv.URL
+
+	a, err := Driver{}.New(nil)
+	if err != nil {
+		t.Fatalf("unable to create action: %s", err)
+	}
+
+	data, err := a.Run(context.Background(), "alice@example.org", "Password1!")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := map[string]interface{}{
+		"token":           true,
+		"display_name":    "Alice",
+		"job_title":       "CFO",
+		"department":      "Finance",
+		"mailbox":         true,
+		"inbox_items":     120,
+		"inbox_unread":    3,
+		"directory_roles": []string{"Global Reader"},
+		"privileged":      true,
+	}
+	if !reflect.DeepEqual(data, expected) {
+		t.Errorf("unexpected finding %v", data)
+	}
+
+	data, err = a.Run(context.Background(), "alice@example.org", "MFA1!")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if data["token"] != false || data["reason"] != "AADSTS50076: you must use multi-factor authentication" {
+		t.Errorf("unexpected finding %v", data)
+	}
+}
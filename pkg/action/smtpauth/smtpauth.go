@@ -0,0 +1,203 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package smtpauth
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+
+	"github.com/praetorian-inc/trident/pkg/action"
+)
+
+const (
+	// DefaultHost is the SMTP submission host of Exchange Online
+	DefaultHost = "smtp.office365.com"
+
+	// DefaultPort is the SMTP submission port
+	DefaultPort = "587"
+)
+
+// Driver implements the action.Driver interface.
+type Driver struct{}
+
+func init() {
+	action.Register("smtp_auth", Driver{})
+}
+
+// New is used to create an SMTP AUTH action, which checks whether a valid
+// credential can authenticate to an SMTP submission server (and therefore
+// relay mail as the account, bypassing MFA on providers such as Exchange
+// Online). The session ends right after authentication: no mail is ever
+// sent. It accepts the following configuration options:
+//
+// host
+//
+// The hostname of the SMTP server, defaults to smtp.office365.com.
+//
+// port
+//
+// The submission port of the SMTP server, defaults to 587.
+func (Driver) New(opts map[string]string) (action.Action, error) {
+	a := &Action{
+		Host: DefaultHost,
+		Port: DefaultPort,
+	}
+	if host, ok := opts["host"]; ok {
+		a.Host = host
+	}
+	if port, ok := opts["port"]; ok {
+		a.Port = port
+	}
+	if a.Host == "" || strings.ContainsAny(a.Host, ":/") {
+		return nil, fmt.Errorf("smtp_auth action requires a hostname, got %q", a.Host)
+	}
+	return a, nil
+}
+
+// Action implements the action.Action interface for SMTP AUTH.
+type Action struct {
+	// Host is the hostname of the SMTP server
+	Host string
+
+	// Port is the submission port of the SMTP server
+	Port string
+}
+
+// Run fulfils the action.Action interface. it upgrades the connection with
+// STARTTLS when offered, authenticates with the PLAIN or LOGIN mechanism and
+// quits. a rejected credential is a finding rather than an error.
+func (a *Action) Run(ctx context.Context, username, password string) (map[string]interface{}, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(a.Host, a.Port))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close() // nolint:errcheck
+
+	// the SMTP client does not take a context, so the connection is closed
+	// to abandon the session
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close() // nolint:errcheck,gosec
+		case <-done:
+		}
+	}()
+
+	c, err := smtp.NewClient(conn, a.Host)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close() // nolint:errcheck
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		err = c.StartTLS(&tls.Config{ServerName: a.Host}) // nolint:gosec
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	data := map[string]interface{}{
+		"host":      a.Host,
+		"smtp_auth": false,
+	}
+
+	ok, params := c.Extension("AUTH")
+	if !ok {
+		data["reason"] = "authentication is not offered"
+		return data, c.Quit()
+	}
+	mechanisms := strings.Fields(strings.ToUpper(params))
+	data["mechanisms"] = mechanisms
+
+	var auth smtp.Auth
+	switch {
+	case contains(mechanisms, "PLAIN"):
+		auth = smtp.PlainAuth("", username, password, a.Host)
+	case contains(mechanisms, "LOGIN"):
+		auth = &loginAuth{host: a.Host, username: username, password: password}
+	default:
+		data["reason"] = "no password mechanism is offered"
+		return data, c.Quit()
+	}
+
+	// the client cancels the exchange and quits when authentication fails
+	err = c.Auth(auth)
+	var perr *textproto.Error
+	switch {
+	case err == nil:
+		data["smtp_auth"] = true
+		return data, c.Quit()
+	case errors.As(err, &perr) && perr.Code >= 500:
+		// e.g. 535 5.7.139 Authentication unsuccessful, SmtpClientAuthentication
+		// is disabled for the Tenant
+		data["reason"] = perr.Msg
+		return data, nil
+	}
+	return nil, err
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// loginAuth implements the LOGIN mechanism, which is the only password
+// mechanism offered by Exchange Online. like smtp.PlainAuth, it refuses to
+// send the credential over an unencrypted connection to a remote host.
+type loginAuth struct {
+	host     string
+	username string
+	password string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch strings.ToLower(strings.TrimSpace(string(fromServer))) {
+	case "username:":
+		return []byte(a.username), nil
+	case "password:":
+		return []byte(a.password), nil
+	}
+	return nil, fmt.Errorf("unexpected LOGIN challenge %q", fromServer)
+}
+
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
@@ -0,0 +1,137 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package smtpauth
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+// serve runs a minimal SMTP server accepting a single session, which fails
+// the test if a command other than EHLO, AUTH and QUIT is sent.
+func serve(t *testing.T, mechanisms string) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %s", err)
+	}
+
+	go func() {
+		defer l.Close() // nolint:errcheck
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		c := textproto.NewConn(conn)
+		defer c.Close() // nolint:errcheck
+
+		c.PrintfLine("220 localhost ESMTP") // nolint:errcheck,gosec
+		for {
+			line, err := c.ReadLine()
+			if err != nil {
+				return
+			}
+			verb := strings.ToUpper(strings.Fields(line)[0])
+			switch verb {
+			case "EHLO":
+				c.PrintfLine("250-localhost")           // nolint:errcheck,gosec
+				c.PrintfLine("250 AUTH %s", mechanisms) // nolint:errcheck,gosec
+			case "AUTH":
+				fields := strings.Fields(line)
+				var user, pass string
+				if fields[1] == "PLAIN" {
+					b, _ := base64.StdEncoding.DecodeString(fields[2])
+					parts := strings.Split(string(b), "\x00")
+					user, pass = parts[1], parts[2]
+				} else {
+					c.PrintfLine("334 %s", base64.StdEncoding.EncodeToString([]byte("Username:"))) // nolint:errcheck,gosec
+					l, _ := c.ReadLine()
+					b, _ := base64.StdEncoding.DecodeString(l)
+					user = string(b)
+					c.PrintfLine("334 %s", base64.StdEncoding.EncodeToString([]byte("Password:"))) // nolint:errcheck,gosec
+					l, _ = c.ReadLine()
+					b, _ = base64.StdEncoding.DecodeString(l)
+					pass = string(b)
+				}
+				if user == "alice@example.org" && pass == "Password1!" {
+					c.PrintfLine("235 2.7.0 Authentication successful") // nolint:errcheck,gosec
+				} else {
+					c.PrintfLine("535 5.7.139 Authentication unsuccessful") // nolint:errcheck,gosec
+				}
+			case "*":
+				c.PrintfLine("501 5.7.0 Authentication cancelled") // nolint:errcheck,gosec
+			case "QUIT":
+				c.PrintfLine("221 Bye") // nolint:errcheck,gosec
+				return
+			default:
+				t.Errorf("unexpected command %q", line)
+				c.PrintfLine("502 not implemented") // nolint:errcheck,gosec
+			}
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	return port
+}
+
+func TestRun(t *testing.T) {
+	var testcases = []struct {
+		mechanisms string
+		password   string
+		auth       bool
+	}{
+		{mechanisms: "PLAIN LOGIN", password: "Password1!", auth: true},
+		{mechanisms: "PLAIN LOGIN", password: "Winter2020", auth: false},
+		{mechanisms: "LOGIN XOAUTH2", password: "Password1!", auth: true},
+		{mechanisms: "LOGIN XOAUTH2", password: "Winter2020", auth: false},
+		{mechanisms: "XOAUTH2", password: "Password1!", auth: false},
+	}
+
+	for _, test := range testcases {
+		desc := fmt.Sprintf("%s/%s", test.mechanisms, test.password)
+		a, err := Driver{}.New(map[string]string{"host": "127.0.0.1", "port": serve(t, test.mechanisms)})
+		if err != nil {
+			t.Fatalf("[%s] unable to create action: %s", desc, err)
+		}
+
+		data, err := a.Run(context.Background(), "alice@example.org", test.password)
+		if err != nil {
+			t.Errorf("[%s] unexpected error: %s", desc, err)
+			continue
+		}
+		if data["smtp_auth"] != test.auth {
+			t.Errorf("[%s] unexpected finding %v", desc, data)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	a, err := Driver{}.New(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if a.(*Action).Host != DefaultHost || a.(*Action).Port != DefaultPort {
+		t.Errorf("unexpected defaults %+v", a)
+	}
+
+	_, err = Driver{}.New(map[string]string{"host": "smtp.example.org:25"})
+	if err == nil {
+		t.Errorf("expected error for host with a port")
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package archive defines an interface for object stores which hold archived
+// campaigns. Similar to the nozzle package, drivers register themselves and
+// must be "blank imported".
+//
+//  import (
+//      "github.com/praetorian-inc/trident/pkg/archive"
+//
+//      _ "github.com/praetorian-inc/trident/pkg/archive/gcs"
+//      _ "github.com/praetorian-inc/trident/pkg/archive/s3"
+//  )
+//
+//  store, err := archive.Open("gcs", map[string]string{"bucket":"trident-archive"})
+//  if err != nil {
+//      // handle error
+//  }
+//  err = store.Put(ctx, "campaign-1.jsonl", f, size)
+//  // ...
+package archive
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+var (
+	driversMu sync.RWMutex
+	drivers   = make(map[string]Driver)
+)
+
+// Store is the interface that wraps writing an object to an archive store.
+// size is the length of the content read from r.
+type Store interface {
+	Put(ctx context.Context, name string, r io.Reader, size int64) error
+}
+
+// Driver is the interface that wraps creation of a Store.
+type Driver interface {
+	New(opts map[string]string) (Store, error)
+}
+
+// Options is a type alias for simple marshaling/unmarshaling of archive store
+// configuration options from the environment.
+type Options map[string]string
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface.
+func (opts *Options) UnmarshalText(text []byte) error {
+	return json.Unmarshal(text, (*map[string]string)(opts))
+}
+
+// Open opens an archive store specified by its driver name (e.g. gcs) and
+// configures it via the provided opts argument. Each Store should document its
+// configuration options in its New() method.
+func Open(name string, opts map[string]string) (Store, error) {
+	driversMu.RLock()
+	d, ok := drivers[name]
+	driversMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("archive: unknown driver %q (forgotten import?)", name)
+	}
+
+	return d.New(opts)
+}
+
+// Register makes an archive store driver available at the provided name. If
+// register is called twice or if the driver is nil, if panics.
+func Register(name string, driver Driver) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	if driver == nil {
+		panic("archive: Register driver is nil")
+	}
+	if _, dup := drivers[name]; dup {
+		panic("archive: Register called twice for driver " + name)
+	}
+	drivers[name] = driver
+}
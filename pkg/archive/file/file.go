@@ -0,0 +1,81 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package file implements an archive store writing objects to a local
+// directory (e.g. a mounted volume).
+package file
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/praetorian-inc/trident/pkg/archive"
+)
+
+// Driver implements the archive.Driver interface.
+type Driver struct{}
+
+func init() {
+	archive.Register("file", Driver{})
+}
+
+// New is used to create a local directory store and accepts the following
+// configuration options:
+//
+// dir
+//
+// The directory objects are written to. It is created if it does not exist.
+func (Driver) New(opts map[string]string) (archive.Store, error) {
+	dir, ok := opts["dir"]
+	if !ok {
+		return nil, fmt.Errorf("file archive store requires 'dir' config parameter")
+	}
+
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Store{Dir: dir}, nil
+}
+
+// Store implements the archive.Store interface for a local directory.
+type Store struct {
+	Dir string
+}
+
+// Put writes the object to a temporary file which is renamed once complete,
+// so that a partially written object is never left in the directory.
+func (s *Store) Put(ctx context.Context, name string, r io.Reader, size int64) error {
+	f, err := os.CreateTemp(s.Dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // nolint:errcheck
+
+	_, err = io.Copy(f, r)
+	if err != nil {
+		f.Close() // nolint:errcheck,gosec
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), filepath.Join(s.Dir, filepath.Base(name)))
+}
@@ -0,0 +1,62 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package file
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/praetorian-inc/trident/pkg/archive"
+)
+
+func TestPut(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint:errcheck
+
+	store, err := archive.Open("file", map[string]string{"dir": filepath.Join(dir, "campaigns")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := "enc:v1:abc\n"
+	err = store.Put(context.Background(), "campaign-1.jsonl", strings.NewReader(content), int64(len(content)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := ioutil.ReadDir(filepath.Join(dir, "campaigns"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Name() != "campaign-1.jsonl" {
+		t.Fatalf("unexpected files %v", files)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "campaigns", "campaign-1.jsonl"))
+	if err != nil || string(b) != content {
+		t.Errorf("unexpected object %q (%v)", b, err)
+	}
+
+	_, err = archive.Open("file", map[string]string{})
+	if err == nil {
+		t.Error("expected an error without a directory")
+	}
+}
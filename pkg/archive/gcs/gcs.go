@@ -0,0 +1,83 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package gcs implements an archive store writing objects to a Google Cloud
+// Storage bucket.
+package gcs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+
+	"google.golang.org/api/googleapi"
+	storage "google.golang.org/api/storage/v1"
+
+	"github.com/praetorian-inc/trident/pkg/archive"
+)
+
+// Driver implements the archive.Driver interface.
+type Driver struct{}
+
+func init() {
+	archive.Register("gcs", Driver{})
+}
+
+// New is used to create a Google Cloud Storage store and accepts the following
+// configuration options:
+//
+// bucket
+//
+// The name of the bucket objects are written to. Application default
+// credentials are used to authenticate to Cloud Storage.
+//
+// prefix
+//
+// An optional prefix of object names (e.g. archive/).
+func (Driver) New(opts map[string]string) (archive.Store, error) {
+	bucket, ok := opts["bucket"]
+	if !ok {
+		return nil, fmt.Errorf("gcs archive store requires 'bucket' config parameter")
+	}
+
+	svc, err := storage.NewService(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	return &Store{
+		Bucket:  bucket,
+		Prefix:  opts["prefix"],
+		objects: svc.Objects,
+	}, nil
+}
+
+// Store implements the archive.Store interface for Google Cloud Storage.
+type Store struct {
+	Bucket string
+	Prefix string
+
+	objects *storage.ObjectsService
+}
+
+// Put uploads the object to the bucket.
+func (s *Store) Put(ctx context.Context, name string, r io.Reader, size int64) error {
+	obj := &storage.Object{Name: path.Join(s.Prefix, name)}
+	_, err := s.objects.Insert(s.Bucket, obj).
+		Media(r, googleapi.ContentType("application/x-ndjson")).
+		Context(ctx).
+		Do()
+	return err
+}
@@ -0,0 +1,224 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package s3 implements an archive store writing objects to an Amazon S3 (or
+// S3 compatible) bucket. requests are signed with AWS Signature Version 4.
+package s3
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/praetorian-inc/trident/pkg/archive"
+)
+
+// unsignedPayload is the payload hash of requests whose body is not signed,
+// allowing objects to be streamed.
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// Driver implements the archive.Driver interface.
+type Driver struct{}
+
+func init() {
+	archive.Register("s3", Driver{})
+}
+
+// New is used to create an S3 store and accepts the following configuration
+// options:
+//
+// bucket
+//
+// The name of the bucket objects are written to.
+//
+// region
+//
+// The region of the bucket (default: us-east-1).
+//
+// prefix
+//
+// An optional prefix of object names (e.g. archive/).
+//
+// endpoint
+//
+// An optional endpoint of an S3 compatible service (e.g.
+// https://minio.example.com). Objects are addressed path-style.
+//
+// access_key_id, secret_access_key, session_token
+//
+// The credentials used to sign requests. If unset, they are read from the
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment
+// variables.
+func (Driver) New(opts map[string]string) (archive.Store, error) {
+	bucket, ok := opts["bucket"]
+	if !ok {
+		return nil, fmt.Errorf("s3 archive store requires 'bucket' config parameter")
+	}
+
+	s := &Store{
+		Bucket:          bucket,
+		Region:          opts["region"],
+		Prefix:          opts["prefix"],
+		Endpoint:        strings.TrimSuffix(opts["endpoint"], "/"),
+		AccessKeyID:     optOrEnv(opts, "access_key_id", "AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: optOrEnv(opts, "secret_access_key", "AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    optOrEnv(opts, "session_token", "AWS_SESSION_TOKEN"),
+	}
+	if s.Region == "" {
+		s.Region = "us-east-1"
+	}
+	if s.Endpoint == "" {
+		s.Endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", s.Region)
+	}
+	if s.AccessKeyID == "" || s.SecretAccessKey == "" {
+		return nil, fmt.Errorf("s3 archive store requires credentials")
+	}
+
+	return s, nil
+}
+
+func optOrEnv(opts map[string]string, key, env string) string {
+	if v, ok := opts[key]; ok {
+		return v
+	}
+	return os.Getenv(env)
+}
+
+// Store implements the archive.Store interface for Amazon S3.
+type Store struct {
+	Bucket   string
+	Region   string
+	Prefix   string
+	Endpoint string
+
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// Put uploads the object to the bucket.
+func (s *Store) Put(ctx context.Context, name string, r io.Reader, size int64) error {
+	url := fmt.Sprintf("%s/%s/%s", s.Endpoint, s.Bucket, path.Join(s.Prefix, name))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, url, ioutil.NopCloser(r))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	req.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+	s.sign(req, unsignedPayload, time.Now())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("s3: error uploading %s: %s: %s", name, resp.Status, body)
+	}
+	return nil
+}
+
+// sign adds the X-Amz-Date and Authorization headers of a Signature Version 4
+// request. the host and every header of the request are signed.
+func (s *Store) sign(req *http.Request, payloadHash string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", k, headers[k])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		escapePath(req.URL.Path),
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, s.Region)
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hexSHA256(canonicalRequest),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretAccessKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// escapePath URI encodes each segment of a path as required by Signature
+// Version 4: every byte except unreserved characters is percent-encoded.
+func escapePath(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if c == '/' || c == '-' || c == '_' || c == '.' || c == '~' ||
+			('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data)) // nolint:errcheck,gosec
+	return h.Sum(nil)
+}
+
+func hexSHA256(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
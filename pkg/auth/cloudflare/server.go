@@ -23,6 +23,7 @@ import (
 
 	"github.com/coreos/go-oidc/v3/oidc"
 
+	"github.com/praetorian-inc/trident/pkg/auth"
 	"github.com/praetorian-inc/trident/pkg/util"
 )
 
@@ -69,7 +70,7 @@ func VerifyToken(verifier *oidc.IDTokenVerifier) func(http.Handler) http.Handler
 
 			// Verify the access token
 			ctx := r.Context()
-			_, err := verifier.Verify(ctx, accessJWT)
+			token, err := verifier.Verify(ctx, accessJWT)
 			if err != nil {
 				w.WriteHeader(http.StatusUnauthorized)
 				_, err = w.Write([]byte(fmt.Sprintf("Invalid token: %s", err.Error())))
@@ -78,6 +79,14 @@ func VerifyToken(verifier *oidc.IDTokenVerifier) func(http.Handler) http.Handler
 				}
 				return
 			}
+
+			// Record the authenticated operator for authorization checks
+			var claims struct {
+				Email string `json:"email"`
+			}
+			if err = token.Claims(&claims); err == nil && claims.Email != "" {
+				r = r.WithContext(auth.NewContext(ctx, claims.Email))
+			}
 			next.ServeHTTP(w, r)
 		}
 		return http.HandlerFunc(hfn)
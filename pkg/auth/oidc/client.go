@@ -0,0 +1,324 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package oidc authenticates operators to an orchestrator using any OpenID
+// Connect identity provider. The client side implements the OAuth 2.0 device
+// authorization grant (RFC 8628) and caches and refreshes tokens locally. The
+// server side verifies the resulting ID tokens.
+package oidc
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+)
+
+// expiryLeeway refreshes tokens shortly before they expire
+const expiryLeeway = 30 * time.Second
+
+// Token is the locally cached set of tokens.
+type Token struct {
+	AccessToken  string    `json:"access_token"`
+	IDToken      string    `json:"id_token"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	Expiry       time.Time `json:"expiry"`
+}
+
+// valid returns true if the ID token can still be used.
+func (t *Token) valid() bool {
+	return t.IDToken != "" && time.Now().Add(expiryLeeway).Before(t.Expiry)
+}
+
+// tokenResponse is a token endpoint response or error.
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	IDToken      string `json:"id_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// deviceResponse is a device authorization endpoint response.
+type deviceResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval"`
+}
+
+// DeviceAuthenticator implements the auth.Authenticator interface using
+// tokens obtained from an OpenID Connect provider via the device
+// authorization grant.
+type DeviceAuthenticator struct {
+	// Issuer is the OpenID Connect issuer URL
+	Issuer string
+
+	// ClientID is the OAuth client identifier registered for trident-client
+	ClientID string
+
+	// ClientSecret is optional and only required for confidential clients
+	ClientSecret string
+
+	// Scopes requested during login (defaults to openid, email and
+	// offline_access)
+	Scopes []string
+
+	// CachePath is the file used to cache tokens between invocations
+	CachePath string
+}
+
+// DefaultCachePath returns the default token cache location,
+// ~/.trident/token.json.
+func DefaultCachePath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "token.json"
+	}
+	return filepath.Join(home, ".trident", "token.json")
+}
+
+// Auth attaches the cached ID token to the request, refreshing it if it has
+// expired.
+func (a *DeviceAuthenticator) Auth(req *http.Request) error {
+	tok, err := a.load()
+	if err != nil {
+		return fmt.Errorf("not logged in (run `trident-client login`): %w", err)
+	}
+
+	if !tok.valid() {
+		if tok.RefreshToken == "" {
+			return errors.New("session expired, run `trident-client login`")
+		}
+		tok, err = a.refresh(req.Context(), tok)
+		if err != nil {
+			return fmt.Errorf("error refreshing token (run `trident-client login`): %w", err)
+		}
+	}
+
+	req.Header.Set("Authorization", "Bearer "+tok.IDToken)
+	return nil
+}
+
+// Login performs the device authorization grant, printing instructions for
+// the operator to out, and caches the resulting tokens.
+func (a *DeviceAuthenticator) Login(ctx context.Context, out io.Writer) error {
+	provider, err := oidc.NewProvider(ctx, a.Issuer)
+	if err != nil {
+		return err
+	}
+
+	var endpoints struct {
+		DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint"`
+	}
+	err = provider.Claims(&endpoints)
+	if err != nil {
+		return err
+	}
+	if endpoints.DeviceAuthorizationEndpoint == "" {
+		return fmt.Errorf("%s does not support the device authorization grant", a.Issuer)
+	}
+
+	scopes := a.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{oidc.ScopeOpenID, "email", oidc.ScopeOfflineAccess}
+	}
+
+	var dev deviceResponse
+	err = a.post(ctx, endpoints.DeviceAuthorizationEndpoint, url.Values{
+		"scope": {strings.Join(scopes, " ")},
+	}, &dev)
+	if err != nil {
+		return err
+	}
+
+	if dev.VerificationURIComplete != "" {
+		fmt.Fprintf(out, "Open %s to log in.\n", dev.VerificationURIComplete)
+	} else {
+		fmt.Fprintf(out, "Open %s and enter code %s to log in.\n", dev.VerificationURI, dev.UserCode)
+	}
+
+	interval := time.Duration(dev.Interval) * time.Second
+	if interval == 0 {
+		interval = 5 * time.Second
+	}
+	deadline := time.Now().Add(time.Duration(dev.ExpiresIn) * time.Second)
+
+	for time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+
+		var res tokenResponse
+		err = a.post(ctx, provider.Endpoint().TokenURL, url.Values{
+			"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+			"device_code": {dev.DeviceCode},
+		}, &res)
+		if err != nil {
+			return err
+		}
+
+		switch res.Error {
+		case "":
+			return a.save(tokenFromResponse(&res, nil))
+		case "authorization_pending":
+			continue
+		case "slow_down":
+			interval += 5 * time.Second
+			continue
+		default:
+			return fmt.Errorf("login failed: %s %s", res.Error, res.ErrorDescription)
+		}
+	}
+
+	return errors.New("login failed: device code expired")
+}
+
+// Logout removes the cached tokens.
+func (a *DeviceAuthenticator) Logout() error {
+	err := os.Remove(a.CachePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (a *DeviceAuthenticator) refresh(ctx context.Context, tok *Token) (*Token, error) {
+	provider, err := oidc.NewProvider(ctx, a.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	var res tokenResponse
+	err = a.post(ctx, provider.Endpoint().TokenURL, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {tok.RefreshToken},
+	}, &res)
+	if err != nil {
+		return nil, err
+	}
+	if res.Error != "" {
+		return nil, fmt.Errorf("%s %s", res.Error, res.ErrorDescription)
+	}
+
+	refreshed := tokenFromResponse(&res, tok)
+	return refreshed, a.save(refreshed)
+}
+
+// post sends a form encoded request authenticated as the OAuth client and
+// decodes the JSON response. OAuth error responses are decoded rather than
+// returned as errors so callers may inspect them.
+func (a *DeviceAuthenticator) post(ctx context.Context, endpoint string, form url.Values, v interface{}) error {
+	form.Set("client_id", a.ClientID)
+	if a.ClientSecret != "" {
+		form.Set("client_secret", a.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("unexpected status code from %s: %d", endpoint, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// tokenFromResponse builds a Token, keeping the previous refresh token if the
+// provider did not rotate it.
+func tokenFromResponse(res *tokenResponse, prev *Token) *Token {
+	tok := &Token{
+		AccessToken:  res.AccessToken,
+		IDToken:      res.IDToken,
+		RefreshToken: res.RefreshToken,
+		Expiry:       time.Now().Add(time.Duration(res.ExpiresIn) * time.Second),
+	}
+	if tok.RefreshToken == "" && prev != nil {
+		tok.RefreshToken = prev.RefreshToken
+	}
+	// prefer the expiry of the ID token itself, since that is what we send
+	if exp, ok := idTokenExpiry(tok.IDToken); ok {
+		tok.Expiry = exp
+	}
+	return tok
+}
+
+// idTokenExpiry reads the (unverified) exp claim of a JWT. the orchestrator
+// performs the actual verification, this is only used to decide when to
+// refresh.
+func idTokenExpiry(jwt string) (time.Time, bool) {
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		return time.Time{}, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}, false
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err = json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(claims.Exp, 0), true
+}
+
+func (a *DeviceAuthenticator) load() (*Token, error) {
+	b, err := ioutil.ReadFile(a.CachePath)
+	if err != nil {
+		return nil, err
+	}
+	var tok Token
+	err = json.Unmarshal(b, &tok)
+	return &tok, err
+}
+
+func (a *DeviceAuthenticator) save(tok *Token) error {
+	err := os.MkdirAll(filepath.Dir(a.CachePath), 0700)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(tok)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(a.CachePath, b, 0600)
+}
@@ -0,0 +1,64 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oidc
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+
+	"github.com/praetorian-inc/trident/pkg/auth"
+)
+
+// Verifier returns a middleware verifying the bearer ID token on each request
+// against the provided issuer and client ID (audience).
+func Verifier(ctx context.Context, issuer, clientID string) (func(http.Handler) http.Handler, error) {
+	provider, err := oidc.NewProvider(ctx, issuer)
+	if err != nil {
+		return nil, err
+	}
+	verifier := provider.Verifier(&oidc.Config{ClientID: clientID})
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			raw := r.Header.Get("Authorization")
+			if !strings.HasPrefix(raw, "Bearer ") {
+				http.Error(w, "No token on the request", http.StatusUnauthorized)
+				return
+			}
+
+			token, err := verifier.Verify(r.Context(), strings.TrimPrefix(raw, "Bearer "))
+			if err != nil {
+				http.Error(w, "Invalid token: "+err.Error(), http.StatusUnauthorized)
+				return
+			}
+
+			// Record the authenticated operator for authorization checks,
+			// falling back to the subject if no verified email claim is
+			// present: an unverified email may belong to someone else
+			var claims struct {
+				Email         string `json:"email"`
+				EmailVerified bool   `json:"email_verified"`
+			}
+			identity := token.Subject
+			if err = token.Claims(&claims); err == nil && claims.Email != "" && claims.EmailVerified {
+				identity = claims.Email
+			}
+			next.ServeHTTP(w, r.WithContext(auth.NewContext(r.Context(), identity)))
+		})
+	}, nil
+}
@@ -0,0 +1,139 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package rbac implements role-based access control for the orchestrator API.
+// Operators are mapped to a role and a set of teams (engagements) by a JSON
+// policy document:
+//
+//  {
+//    "users": {
+//      "alice@example.org": {"role": "admin"},
+//      "bob@example.org":   {"role": "operator", "teams": ["acme"]}
+//    },
+//    "default": {"role": "readonly", "teams": ["shared"]}
+//  }
+//
+// Admins may access every campaign. Operators and read-only users may only
+// access campaigns belonging to one of their teams.
+package rbac
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// Role is the level of access granted to a principal.
+type Role string
+
+const (
+	// RoleReadOnly may view campaigns and results.
+	RoleReadOnly Role = "readonly"
+
+	// RoleOperator may additionally create and manage campaigns.
+	RoleOperator Role = "operator"
+
+	// RoleAdmin may access the campaigns of every team.
+	RoleAdmin Role = "admin"
+)
+
+var roleLevels = map[Role]int{
+	RoleReadOnly: 1,
+	RoleOperator: 2,
+	RoleAdmin:    3,
+}
+
+// Principal is an authenticated operator and their granted access.
+type Principal struct {
+	// Identity is the authenticated identity (e.g. email address)
+	Identity string `json:"-"`
+
+	// Role is the role granted to the principal
+	Role Role `json:"role"`
+
+	// Teams are the teams (engagements) the principal belongs to
+	Teams []string `json:"teams"`
+}
+
+// Admin is the principal used when access control is disabled.
+var Admin = Principal{Role: RoleAdmin}
+
+// Has returns true if the principal's role is at least the provided role.
+func (p Principal) Has(role Role) bool {
+	return roleLevels[p.Role] >= roleLevels[role]
+}
+
+// CanAccess returns true if the principal may access campaigns of the
+// provided team.
+func (p Principal) CanAccess(team string) bool {
+	if p.Role == RoleAdmin {
+		return true
+	}
+	for _, t := range p.Teams {
+		if t == team {
+			return true
+		}
+	}
+	return false
+}
+
+// Policy maps authenticated identities to principals.
+type Policy struct {
+	// Users maps an identity to its role and teams
+	Users map[string]Principal `json:"users"`
+
+	// Default is applied to authenticated identities missing from Users. if
+	// unset, unknown identities are denied.
+	Default *Principal `json:"default"`
+}
+
+// LoadPolicy reads a JSON policy document from the provided path.
+func LoadPolicy(path string) (*Policy, error) {
+	b, err := ioutil.ReadFile(path) // nolint:gosec
+	if err != nil {
+		return nil, err
+	}
+
+	var p Policy
+	err = json.Unmarshal(b, &p)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing rbac policy: %w", err)
+	}
+
+	for identity, principal := range p.Users {
+		if _, ok := roleLevels[principal.Role]; !ok {
+			return nil, fmt.Errorf("unknown role %q for %s", principal.Role, identity)
+		}
+	}
+	if p.Default != nil {
+		if _, ok := roleLevels[p.Default.Role]; !ok {
+			return nil, fmt.Errorf("unknown default role %q", p.Default.Role)
+		}
+	}
+
+	return &p, nil
+}
+
+// Principal returns the principal for the provided identity.
+func (p *Policy) Principal(identity string) (Principal, error) {
+	principal, ok := p.Users[identity]
+	if !ok {
+		if p.Default == nil {
+			return Principal{}, fmt.Errorf("rbac: no policy for %q", identity)
+		}
+		principal = *p.Default
+	}
+	principal.Identity = identity
+	return principal, nil
+}
@@ -0,0 +1,123 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package token
+
+import (
+	"container/heap"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+// NonceKeyF is the redis key of a nonce recorded by RedisNonces.
+const NonceKeyF = "token.nonce.%s"
+
+// NonceStore records the nonces of verified tokens until the tokens expire.
+type NonceStore interface {
+	// Remember records nonce until expires, returning ErrReplay if the
+	// nonce is already recorded.
+	Remember(nonce string, expires time.Time) error
+}
+
+// MemoryNonces is a NonceStore local to the process. It only protects a
+// single replica: replicated verifiers should share a RedisNonces instead.
+// The zero value is ready to use.
+type MemoryNonces struct {
+	mu      sync.Mutex
+	seen    map[string]struct{}
+	expires nonceHeap
+}
+
+// Remember implements NonceStore.
+func (m *MemoryNonces) Remember(nonce string, expires time.Time) error {
+	return m.remember(nonce, expires, time.Now())
+}
+
+func (m *MemoryNonces) remember(nonce string, expires, now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.seen == nil {
+		m.seen = make(map[string]struct{})
+	}
+	// nonces are ordered by expiry, so only the expired ones are visited
+	for len(m.expires) > 0 && now.After(m.expires[0].expires) {
+		delete(m.seen, heap.Pop(&m.expires).(nonceEntry).nonce)
+	}
+	if _, seen := m.seen[nonce]; seen {
+		return ErrReplay
+	}
+	m.seen[nonce] = struct{}{}
+	heap.Push(&m.expires, nonceEntry{nonce: nonce, expires: expires})
+	return nil
+}
+
+type nonceEntry struct {
+	nonce   string
+	expires time.Time
+}
+
+// nonceHeap is a min-heap of nonces ordered by expiry.
+type nonceHeap []nonceEntry
+
+func (h nonceHeap) Len() int            { return len(h) }
+func (h nonceHeap) Less(i, j int) bool  { return h[i].expires.Before(h[j].expires) }
+func (h nonceHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *nonceHeap) Push(x interface{}) { *h = append(*h, x.(nonceEntry)) }
+
+func (h *nonceHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+// RedisNonces is a NonceStore shared by every verifier using the same redis,
+// so that a request cannot be replayed against another replica. Nonces expire
+// with their keys.
+type RedisNonces struct {
+	Client *redis.Client
+}
+
+// NewRedisNonces connects to the redis at uri.
+func NewRedisNonces(uri, password string) (*RedisNonces, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:       uri,
+		Password:   password,
+		MaxRetries: 10,
+	})
+	if _, err := client.Ping().Result(); err != nil {
+		return nil, err
+	}
+	return &RedisNonces{Client: client}, nil
+}
+
+// Remember implements NonceStore.
+func (r *RedisNonces) Remember(nonce string, expires time.Time) error {
+	ttl := time.Until(expires)
+	if ttl < time.Millisecond {
+		ttl = time.Millisecond
+	}
+	first, err := r.Client.SetNX(fmt.Sprintf(NonceKeyF, nonce), 1, ttl).Result()
+	if err != nil {
+		return err
+	}
+	if !first {
+		return ErrReplay
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package token
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestMemoryNonces(t *testing.T) {
+	var m MemoryNonces
+	now := time.Now()
+
+	if err := m.remember("a", now.Add(time.Minute), now); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.remember("b", now.Add(2*time.Minute), now); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.remember("a", now.Add(time.Minute), now); err != ErrReplay {
+		t.Errorf("expected replay error, got %v", err)
+	}
+
+	// only the expired nonce is forgotten
+	later := now.Add(90 * time.Second)
+	if err := m.remember("c", later.Add(time.Minute), later); err != nil {
+		t.Fatal(err)
+	}
+	if _, seen := m.seen["a"]; seen {
+		t.Errorf("expired nonce was not forgotten")
+	}
+	if err := m.remember("b", now.Add(2*time.Minute), later); err != ErrReplay {
+		t.Errorf("expected replay error, got %v", err)
+	}
+}
+
+func TestRedisNonces(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mr.Close()
+
+	// two replicas sharing the same redis
+	first, err := NewRedisNonces(mr.Addr(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := NewRedisNonces(mr.Addr(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer := &Signer{Secret: []byte("secret")}
+	req := newRequest(t, `{"username":"alice"}`)
+	if err := signer.Auth(req); err != nil {
+		t.Fatal(err)
+	}
+
+	verifier := NewVerifier("secret", time.Minute)
+	verifier.Nonces = first
+	if err := verifier.Verify(req); err != nil {
+		t.Fatalf("unexpected verification error: %s", err)
+	}
+
+	replica := NewVerifier("secret", time.Minute)
+	replica.Nonces = second
+	if err := replica.Verify(req); err != ErrReplay {
+		t.Errorf("expected replay error, got %v", err)
+	}
+
+	// the nonce expires with the token
+	key := fmt.Sprintf(NonceKeyF, strings.Split(req.Header.Get(DefaultHeader), ".")[2])
+	if !mr.Exists(key) {
+		t.Fatalf("nonce was not recorded")
+	}
+	mr.FastForward(2 * time.Minute)
+	if mr.Exists(key) {
+		t.Errorf("nonce did not expire")
+	}
+}
@@ -0,0 +1,312 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package token implements short-lived, request-bound access tokens used to
+// authenticate the dispatcher to workers. Each token is an HMAC over the
+// request method, path, body, a timestamp and a random nonce. The HMAC key is
+// derived from a shared secret and the current epoch. This only keeps the
+// signatures of different epochs apart and is not key rotation: every epoch
+// key is derived from the same secret, which must be rotated by listing both
+// the new and the old secret in Verifier.Secrets until every signer uses the
+// new one. Verifiers reject expired tokens and record nonces in a NonceStore
+// for the lifetime of a token, so a captured request cannot be replayed;
+// replicated verifiers must share the store (see RedisNonces) to reject a
+// request replayed against another replica.
+package token
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultHeader is the HTTP header used to carry the token.
+	DefaultHeader = "X-Trident-Token"
+
+	// KeyIDHeader is the HTTP header identifying the secret of a token signed
+	// with a per-client key.
+	KeyIDHeader = "X-Trident-Key-Id"
+
+	// DefaultTTL is the amount of time a token is considered valid.
+	DefaultTTL = 30 * time.Second
+
+	// DefaultEpoch is the lifetime of a derived signing key.
+	DefaultEpoch = time.Hour
+
+	// DefaultMaxBodySize is the largest request body read to verify a token.
+	DefaultMaxBodySize = 16 << 20
+
+	version = "v1"
+)
+
+var (
+	// ErrMalformed is returned when a token cannot be parsed.
+	ErrMalformed = errors.New("token: malformed token")
+
+	// ErrExpired is returned when a token is outside of the allowed TTL.
+	ErrExpired = errors.New("token: token expired")
+
+	// ErrSignature is returned when no configured secret produced the
+	// provided signature.
+	ErrSignature = errors.New("token: invalid signature")
+
+	// ErrReplay is returned when a token nonce has already been seen.
+	ErrReplay = errors.New("token: token replayed")
+
+	// ErrTooLarge is returned when the body of a request exceeds the size
+	// read to verify its token.
+	ErrTooLarge = errors.New("token: request body too large")
+)
+
+// epochKey derives the signing key for the epoch containing ts.
+func epochKey(secret []byte, length time.Duration, ts int64) []byte {
+	epoch := ts / int64(length/time.Second)
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(epoch))
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("trident-token-epoch")) // nolint:errcheck,gosec
+	mac.Write(b[:])                          // nolint:errcheck,gosec
+	return mac.Sum(nil)
+}
+
+func sign(key []byte, ts int64, nonce, method, path string, body []byte) string {
+	digest := sha256.Sum256(body)
+
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%s\n%d\n%s\n%s\n%s\n", version, ts, nonce, method, path)
+	mac.Write(digest[:]) // nolint:errcheck,gosec
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// readBody returns the request body and replaces it so that it can be read
+// again by the next handler. bodies larger than max bytes are rejected with
+// ErrTooLarge, unless max is 0.
+func readBody(req *http.Request, max int64) ([]byte, error) {
+	if req.Body == nil {
+		return nil, nil
+	}
+	r := io.Reader(req.Body)
+	if max > 0 {
+		r = io.LimitReader(req.Body, max+1)
+	}
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if max > 0 && int64(len(body)) > max {
+		return nil, ErrTooLarge
+	}
+	req.Body.Close() // nolint:errcheck,gosec
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// Signer implements the auth.Authenticator interface by attaching a freshly
+// minted token to each request.
+type Signer struct {
+	// Secret is the shared secret used to derive signing keys.
+	Secret []byte
+
+	// Header is the HTTP header used to carry the token (defaults to
+	// X-Trident-Token).
+	Header string
+
+	// Epoch is the lifetime of a derived signing key (defaults to 1h). it
+	// must match the Epoch of the verifier.
+	Epoch time.Duration
+
+	// KeyID, if set, identifies Secret as a per-client key of the verifier.
+	KeyID string
+}
+
+// Auth signs the request and sets the token header.
+func (s *Signer) Auth(req *http.Request) error {
+	body, err := readBody(req, 0)
+	if err != nil {
+		return err
+	}
+
+	var n [16]byte
+	if _, err = rand.Read(n[:]); err != nil {
+		return err
+	}
+	nonce := hex.EncodeToString(n[:])
+
+	epoch := s.Epoch
+	if epoch == 0 {
+		epoch = DefaultEpoch
+	}
+	header := s.Header
+	if header == "" {
+		header = DefaultHeader
+	}
+
+	ts := time.Now().Unix()
+	sig := sign(epochKey(s.Secret, epoch, ts), ts, nonce, req.Method, req.URL.EscapedPath(), body)
+	req.Header.Set(header, strings.Join([]string{version, strconv.FormatInt(ts, 10), nonce, sig}, "."))
+	if s.KeyID != "" {
+		req.Header.Set(KeyIDHeader, s.KeyID)
+	}
+	return nil
+}
+
+// Verifier validates tokens created by a Signer. Multiple secrets may be
+// configured to allow the shared secret to be rotated without downtime.
+type Verifier struct {
+	// Secrets is the list of accepted shared secrets. it is the rotation
+	// mechanism: add the new secret, move the signers over, then drop the
+	// old secret.
+	Secrets [][]byte
+
+	// Keys maps key IDs to per-client secrets. a token carrying a key ID is
+	// only verified with the secret of that ID.
+	Keys map[string][]byte
+
+	// Header is the HTTP header used to carry the token (defaults to
+	// X-Trident-Token).
+	Header string
+
+	// TTL is the maximum age (and clock skew) of an accepted token
+	// (defaults to 30s).
+	TTL time.Duration
+
+	// Epoch is the lifetime of a derived signing key (defaults to 1h). it
+	// must match the Epoch of the verifier.
+	Epoch time.Duration
+
+	// MaxBodySize is the largest request body accepted, as the body is read
+	// before the token is verified (defaults to 16MiB).
+	MaxBodySize int64
+
+	// Prefix is prepended to the request path before verification. It is
+	// used by workers behind a gateway which strips part of the path signed
+	// by the client (e.g. an API Gateway stage).
+	Prefix string
+
+	// Nonces records the nonces of verified tokens (defaults to a store
+	// local to the process, which does not protect other replicas).
+	Nonces NonceStore
+
+	memory MemoryNonces
+}
+
+// NewVerifier creates a Verifier from a comma separated list of secrets.
+func NewVerifier(secrets string, ttl time.Duration) *Verifier {
+	v := &Verifier{TTL: ttl}
+	for _, s := range strings.Split(secrets, ",") {
+		s = strings.TrimSpace(s)
+		if s != "" {
+			v.Secrets = append(v.Secrets, []byte(s))
+		}
+	}
+	return v
+}
+
+// Verify checks the token attached to the provided request.
+func (v *Verifier) Verify(req *http.Request) error {
+	header := v.Header
+	if header == "" {
+		header = DefaultHeader
+	}
+	ttl := v.TTL
+	if ttl == 0 {
+		ttl = DefaultTTL
+	}
+	epoch := v.Epoch
+	if epoch == 0 {
+		epoch = DefaultEpoch
+	}
+
+	parts := strings.Split(req.Header.Get(header), ".")
+	if len(parts) != 4 || parts[0] != version {
+		return ErrMalformed
+	}
+	ts, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return ErrMalformed
+	}
+	nonce, sig := parts[2], parts[3]
+
+	issued := time.Unix(ts, 0)
+	now := time.Now()
+	if now.Sub(issued) > ttl || issued.Sub(now) > ttl {
+		return ErrExpired
+	}
+
+	max := v.MaxBodySize
+	if max == 0 {
+		max = DefaultMaxBodySize
+	}
+	body, err := readBody(req, max)
+	if err != nil {
+		return err
+	}
+
+	path := req.URL.EscapedPath()
+	if v.Prefix != "" {
+		path = strings.TrimSuffix(v.Prefix, "/") + path
+	}
+
+	secrets := v.Secrets
+	if id := req.Header.Get(KeyIDHeader); id != "" {
+		secret, ok := v.Keys[id]
+		if !ok {
+			return ErrSignature
+		}
+		secrets = [][]byte{secret}
+	}
+
+	valid := false
+	for _, secret := range secrets {
+		expected := sign(epochKey(secret, epoch, ts), ts, nonce, req.Method, path, body)
+		if hmac.Equal([]byte(expected), []byte(sig)) {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return ErrSignature
+	}
+
+	nonces := v.Nonces
+	if nonces == nil {
+		nonces = &v.memory
+	}
+	return nonces.Remember(nonce, issued.Add(ttl))
+}
+
+// Middleware returns an http middleware rejecting requests without a valid
+// token.
+func (v *Verifier) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := v.Verify(r); err != nil {
+			http.Error(w, http.StatusText(403), 403)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,145 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package token
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func newRequest(t *testing.T, body string) *http.Request {
+	req, err := http.NewRequest("POST", "https://worker.example.org/", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+func TestVerify(t *testing.T) {
+	signer := &Signer{Secret: []byte("secret")}
+	verifier := NewVerifier("rotated, secret", time.Minute)
+
+	req := newRequest(t, `{"username":"alice"}`)
+	if err := signer.Auth(req); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifier.Verify(req); err != nil {
+		t.Fatalf("unexpected verification error: %s", err)
+	}
+
+	// the body must still be readable by the next handler
+	body, _ := ioutil.ReadAll(req.Body)
+	if string(body) != `{"username":"alice"}` {
+		t.Errorf("request body was not restored: %s", body)
+	}
+
+	// replay the same request
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err := verifier.Verify(req); err != ErrReplay {
+		t.Errorf("expected replay error, got %v", err)
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	signer := &Signer{Secret: []byte("secret")}
+
+	var testcases = []struct {
+		desc   string
+		modify func(*http.Request)
+		err    error
+	}{
+		{"wrong secret", func(r *http.Request) {}, ErrSignature},
+		{"tampered body", func(r *http.Request) {
+			r.Body = ioutil.NopCloser(bytes.NewBufferString(`{"username":"eve"}`))
+		}, ErrSignature},
+		{"missing token", func(r *http.Request) { r.Header.Del(DefaultHeader) }, ErrMalformed},
+		{"expired token", func(r *http.Request) {
+			r.Header.Set(DefaultHeader, "v1.1000.abcd.ef")
+		}, ErrExpired},
+		{"oversized body", func(r *http.Request) {
+			r.Body = ioutil.NopCloser(bytes.NewReader(make([]byte, DefaultMaxBodySize+1)))
+		}, ErrTooLarge},
+	}
+
+	for _, test := range testcases {
+		verifier := NewVerifier("other", time.Minute)
+		if test.desc != "wrong secret" {
+			verifier = NewVerifier("secret", time.Minute)
+		}
+
+		req := newRequest(t, `{"username":"alice"}`)
+		if err := signer.Auth(req); err != nil {
+			t.Fatal(err)
+		}
+		test.modify(req)
+
+		if err := verifier.Verify(req); err != test.err {
+			t.Errorf("[%s] expected %v, got %v", test.desc, test.err, err)
+		}
+	}
+}
+
+func TestVerifyPrefix(t *testing.T) {
+	signer := &Signer{Secret: []byte("secret")}
+	verifier := NewVerifier("secret", time.Minute)
+	verifier.Prefix = "/prod/"
+
+	req, err := http.NewRequest("POST", "https://api.example.org/prod/batch", bytes.NewBufferString(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = signer.Auth(req); err != nil {
+		t.Fatal(err)
+	}
+
+	// the gateway strips the stage before the request reaches the worker
+	req.URL.Path = "/batch"
+	if err = verifier.Verify(req); err != nil {
+		t.Errorf("unexpected verification error: %s", err)
+	}
+}
+
+func TestVerifyKeyID(t *testing.T) {
+	verifier := NewVerifier("shared", time.Minute)
+	verifier.Keys = map[string][]byte{
+		"worker-1": []byte("secret-1"),
+		"worker-2": []byte("secret-2"),
+	}
+
+	var testcases = []struct {
+		desc   string
+		signer *Signer
+		err    error
+	}{
+		{"per-client key", &Signer{Secret: []byte("secret-1"), KeyID: "worker-1"}, nil},
+		{"shared key", &Signer{Secret: []byte("shared")}, nil},
+		{"key of another client", &Signer{Secret: []byte("secret-2"), KeyID: "worker-1"}, ErrSignature},
+		{"shared key with key id", &Signer{Secret: []byte("shared"), KeyID: "worker-1"}, ErrSignature},
+		{"unknown key id", &Signer{Secret: []byte("secret-1"), KeyID: "worker-3"}, ErrSignature},
+	}
+
+	for _, test := range testcases {
+		req := newRequest(t, `{"valid":true}`)
+		if err := test.signer.Auth(req); err != nil {
+			t.Fatal(err)
+		}
+		if err := verifier.Verify(req); err != test.err {
+			t.Errorf("[%s] expected %v, got %v", test.desc, test.err, err)
+		}
+	}
+}
@@ -0,0 +1,339 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package client is a Go client of the orchestrator API, for tools which
+// drive campaigns programmatically rather than through trident-client. Requests
+// are authenticated by an auth.Authenticator, the same way as the CLI:
+//
+//	c := client.New("https://trident.example.org", &cloudflare.ArgoAuthenticator{URL: u})
+//	campaign, err := c.CreateCampaign(ctx, &db.Campaign{...})
+//	if err != nil {
+//	    // handle error
+//	}
+//	err = c.StreamResults(ctx, client.StreamOptions{CampaignID: campaign.ID},
+//	    func(res db.Result) error {
+//	        // ...
+//	    })
+//
+// Workers posting results to the orchestrator authenticate with a
+// token.Signer instead (see IngestResult).
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/praetorian-inc/trident/pkg/auth"
+	"github.com/praetorian-inc/trident/pkg/db"
+	"github.com/praetorian-inc/trident/pkg/report"
+)
+
+// Client sends requests to the orchestrator API. It is safe for concurrent
+// use.
+type Client struct {
+	// URL is the base URL of the orchestrator (e.g.
+	// https://trident.example.org)
+	URL string
+
+	// Auth authenticates each request. if nil, requests are sent without
+	// credentials.
+	Auth auth.Authenticator
+
+	// HTTPClient sends the requests (defaults to http.DefaultClient)
+	HTTPClient *http.Client
+}
+
+// New creates a Client of the orchestrator at the provided base URL.
+func New(baseURL string, a auth.Authenticator) *Client {
+	return &Client{URL: strings.TrimSuffix(baseURL, "/"), Auth: a}
+}
+
+// Error is returned when the orchestrator responds with a status other than
+// 200.
+type Error struct {
+	// StatusCode is the HTTP status of the response
+	StatusCode int
+
+	// Message is the body of the response
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("orchestrator returned %d: %s", e.StatusCode, e.Message)
+}
+
+// request creates an authenticated request. a nil body sends no request
+// body.
+func (c *Client) request(ctx context.Context, method, path string, body interface{}) (*http.Request, error) {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(c.URL, "/")+path, r)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Auth != nil {
+		err = c.Auth.Auth(req)
+		if err != nil {
+			return nil, fmt.Errorf("error authenticating request: %w", err)
+		}
+	}
+	return req, nil
+}
+
+// send sends a request and returns the response, or an *Error if its status
+// is not 200.
+func (c *Client) send(req *http.Request) (*http.Response, error) {
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		defer resp.Body.Close() // nolint:errcheck
+		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, &Error{StatusCode: resp.StatusCode, Message: string(bytes.TrimSpace(b))}
+	}
+	return resp, nil
+}
+
+// Do sends a JSON request to an API path of the orchestrator and decodes the
+// JSON response into v, unless v is nil. It can be used to call endpoints
+// without a typed method.
+func (c *Client) Do(ctx context.Context, method, path string, body, v interface{}) error {
+	req, err := c.request(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	resp, err := c.send(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	if v == nil {
+		return nil
+	}
+	err = json.NewDecoder(resp.Body).Decode(v)
+	if err != nil {
+		return fmt.Errorf("error decoding response of %s: %w", path, err)
+	}
+	return nil
+}
+
+// CreateCampaign creates and schedules a campaign. The created campaign is
+// returned, including its ID and the users generated from its names.
+func (c *Client) CreateCampaign(ctx context.Context, campaign *db.Campaign) (*db.Campaign, error) {
+	var created db.Campaign
+	err := c.Do(ctx, "POST", "/campaign", campaign, &created)
+	if err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// ListCampaigns returns the campaigns visible to the caller.
+func (c *Client) ListCampaigns(ctx context.Context) ([]db.Campaign, error) {
+	var campaigns []db.Campaign
+	err := c.Do(ctx, "GET", "/list", nil, &campaigns)
+	return campaigns, err
+}
+
+// DescribeCampaign returns the parameters of a campaign.
+func (c *Client) DescribeCampaign(ctx context.Context, id uint) (*db.Campaign, error) {
+	var campaign db.Campaign
+	err := c.Do(ctx, "POST", "/describe", db.Query{
+		Filter: map[string]interface{}{"id": id},
+	}, &campaign)
+	if err != nil {
+		return nil, err
+	}
+	return &campaign, nil
+}
+
+// SetCampaignStatus pauses, resumes or cancels a campaign.
+func (c *Client) SetCampaignStatus(ctx context.Context, id uint, status db.CampaignStatus) error {
+	return c.Do(ctx, "POST", "/campaign/status", map[string]interface{}{
+		"ID":     id,
+		"Status": status,
+	}, nil)
+}
+
+// PauseCampaign pauses a campaign, which can then be resumed.
+func (c *Client) PauseCampaign(ctx context.Context, id uint) error {
+	return c.SetCampaignStatus(ctx, id, db.CampaignStatusPaused)
+}
+
+// ResumeCampaign resumes a paused campaign.
+func (c *Client) ResumeCampaign(ctx context.Context, id uint) error {
+	return c.SetCampaignStatus(ctx, id, db.CampaignStatusActive)
+}
+
+// CancelCampaign permanently cancels a campaign. Campaigns are never deleted
+// through the API; they are purged by the retention policy.
+func (c *Client) CancelCampaign(ctx context.Context, id uint) error {
+	return c.SetCampaignStatus(ctx, id, db.CampaignStatusCancelled)
+}
+
+// Progress returns the progress of a campaign, or of every visible campaign
+// if id is 0.
+func (c *Client) Progress(ctx context.Context, id uint) ([]db.CampaignProgress, error) {
+	var progress []db.CampaignProgress
+	err := c.Do(ctx, "POST", "/campaign/progress", map[string]interface{}{"ID": id}, &progress)
+	return progress, err
+}
+
+// Results returns the results matching the query (e.g. a filter on
+// campaign_id and valid).
+func (c *Client) Results(ctx context.Context, q db.Query) ([]db.Result, error) {
+	var results []db.Result
+	err := c.Do(ctx, "POST", "/results", q, &results)
+	return results, err
+}
+
+// Report aggregates the results of the provided campaigns, or of every
+// visible campaign if none are provided.
+func (c *Client) Report(ctx context.Context, campaignIDs ...uint) (*report.Report, error) {
+	var r report.Report
+	err := c.Do(ctx, "POST", "/report", map[string]interface{}{"CampaignIDs": campaignIDs}, &r)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// StreamOptions filters the results of StreamResults.
+type StreamOptions struct {
+	// CampaignID only streams the results of a campaign, if set
+	CampaignID uint
+
+	// ValidOnly only streams valid credentials
+	ValidOnly bool
+}
+
+// StreamResults calls fn with each result as it is consumed by the
+// orchestrator. It blocks until ctx is cancelled, the orchestrator closes the
+// stream or fn returns an error, which is then returned.
+func (c *Client) StreamResults(ctx context.Context, opts StreamOptions, fn func(db.Result) error) error {
+	q := url.Values{}
+	if opts.CampaignID != 0 {
+		q.Set("campaign_id", strconv.FormatUint(uint64(opts.CampaignID), 10))
+	}
+	if opts.ValidOnly {
+		q.Set("valid", "true")
+	}
+
+	req, err := c.request(ctx, "GET", "/results/stream?"+q.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := c.send(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+
+		var res db.Result
+		err = json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &res)
+		if err != nil {
+			return fmt.Errorf("error decoding streamed result: %w", err)
+		}
+		err = fn(res)
+		if err != nil {
+			return err
+		}
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return scanner.Err()
+}
+
+// FailedTasks returns the failed tasks matching the query.
+func (c *Client) FailedTasks(ctx context.Context, q db.Query) ([]db.FailedTask, error) {
+	var tasks []db.FailedTask
+	err := c.Do(ctx, "POST", "/tasks/errors", q, &tasks)
+	return tasks, err
+}
+
+// Requeue schedules failed tasks again, either the tasks with the provided
+// IDs or, if none are provided, every failed task of the campaign. The number
+// of requeued tasks is returned.
+func (c *Client) Requeue(ctx context.Context, campaignID uint, ids ...uint) (int, error) {
+	var resp struct {
+		Requeued int `json:"requeued"`
+	}
+	err := c.Do(ctx, "POST", "/tasks/errors/requeue", map[string]interface{}{
+		"IDs":        ids,
+		"CampaignID": campaignID,
+	}, &resp)
+	return resp.Requeued, err
+}
+
+// Credentials returns the credentials of the vault. Passwords are only
+// included if reveal is true, which requires the operator role.
+func (c *Client) Credentials(ctx context.Context, reveal bool) ([]db.Credential, error) {
+	var creds []db.Credential
+	err := c.Do(ctx, "POST", "/credentials", map[string]interface{}{"Reveal": reveal}, &creds)
+	return creds, err
+}
+
+// Workers returns the health of the workers which recently made attempts.
+// Workers are not registered with the orchestrator: they are known from the
+// egress IP and region of their results.
+func (c *Client) Workers(ctx context.Context) ([]report.WorkerHealth, error) {
+	var workers []report.WorkerHealth
+	err := c.Do(ctx, "GET", "/workers", nil, &workers)
+	return workers, err
+}
+
+// IngestResult posts the result of a task to the orchestrator, as workers do
+// when results are not returned through the queue. The client must
+// authenticate with a token.Signer configured with the shared or per-worker
+// ingestion key.
+func (c *Client) IngestResult(ctx context.Context, res *db.Result) error {
+	return c.Do(ctx, "POST", "/results/ingest", res, nil)
+}
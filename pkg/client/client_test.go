@@ -0,0 +1,184 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/praetorian-inc/trident/pkg/auth/token"
+	"github.com/praetorian-inc/trident/pkg/db"
+)
+
+type headerAuth struct{}
+
+func (headerAuth) Auth(req *http.Request) error {
+	req.Header.Set("Authorization", "Bearer test")
+	return nil
+}
+
+func TestCampaigns(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/campaign", func(w http.ResponseWriter, r *http.Request) {
+		var c db.Campaign
+		if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+			t.Fatal(err)
+		}
+		c.ID = 7
+		json.NewEncoder(w).Encode(c) // nolint:errcheck
+	})
+	mux.HandleFunc("/describe", func(w http.ResponseWriter, r *http.Request) {
+		var q db.Query
+		if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+			t.Fatal(err)
+		}
+		if q.Filter["id"] != float64(7) {
+			http.Error(w, "campaign not found", 404)
+			return
+		}
+		var c db.Campaign
+		c.ID = 7
+		json.NewEncoder(w).Encode(c) // nolint:errcheck
+	})
+	mux.HandleFunc("/campaign/status", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			ID     uint
+			Status db.CampaignStatus
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if body.ID != 7 || body.Status != db.CampaignStatusPaused {
+			t.Errorf("unexpected status update %+v", body)
+		}
+	})
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer test" {
+			http.Error(w, http.StatusText(401), 401)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	c := New(srv.URL+"/", headerAuth{})
+
+	created, err := c.CreateCampaign(ctx, &db.Campaign{Passwords: []string{"Winter2020!"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.ID != 7 || len(created.Passwords) != 1 {
+		t.Errorf("unexpected campaign %+v", created)
+	}
+
+	if _, err = c.DescribeCampaign(ctx, 7); err != nil {
+		t.Error(err)
+	}
+
+	_, err = c.DescribeCampaign(ctx, 8)
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 404 || apiErr.Message != "campaign not found" {
+		t.Errorf("expected a 404 error, got %v", err)
+	}
+
+	if err = c.PauseCampaign(ctx, 7); err != nil {
+		t.Error(err)
+	}
+
+	// requests without credentials are rejected
+	_, err = New(srv.URL, nil).ListCampaigns(ctx)
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 401 {
+		t.Errorf("expected a 401 error, got %v", err)
+	}
+}
+
+func TestStreamResults(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("campaign_id") != "3" || r.URL.Query().Get("valid") != "true" {
+			t.Errorf("unexpected query %s", r.URL.RawQuery)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, ": ping\n\n")
+		for _, u := range []string{"alice", "bob", "carol"} {
+			fmt.Fprintf(w, "event: result\ndata: {\"campaign_id\":3,\"username\":%q,\"valid\":true}\n\n", u)
+		}
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL, nil)
+	opts := StreamOptions{CampaignID: 3, ValidOnly: true}
+
+	var users []string
+	err := c.StreamResults(context.Background(), opts, func(res db.Result) error {
+		users = append(users, res.Username)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 3 || users[0] != "alice" || users[2] != "carol" {
+		t.Errorf("unexpected results %v", users)
+	}
+
+	// errors returned by the callback stop the stream
+	stop := errors.New("stop")
+	users = nil
+	err = c.StreamResults(context.Background(), opts, func(res db.Result) error {
+		users = append(users, res.Username)
+		return stop
+	})
+	if err != stop || len(users) != 1 {
+		t.Errorf("expected the stream to stop after one result, got %v %v", err, users)
+	}
+}
+
+func TestIngestResult(t *testing.T) {
+	verifier := token.NewVerifier("shared", time.Minute)
+	var ingested db.Result
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := verifier.Verify(r); err != nil {
+			http.Error(w, http.StatusText(403), 403)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&ingested); err != nil {
+			t.Fatal(err)
+		}
+	}))
+	defer srv.Close()
+
+	res := &db.Result{CampaignID: 1, Username: "alice@example.org", Valid: true}
+
+	c := New(srv.URL, &token.Signer{Secret: []byte("shared")})
+	if err := c.IngestResult(context.Background(), res); err != nil {
+		t.Fatal(err)
+	}
+	if ingested.Username != "alice@example.org" {
+		t.Errorf("unexpected ingested result %+v", ingested)
+	}
+
+	c = New(srv.URL, &token.Signer{Secret: []byte("other")})
+	var apiErr *Error
+	if err := c.IngestResult(context.Background(), res); !errors.As(err, &apiErr) || apiErr.StatusCode != 403 {
+		t.Errorf("expected a 403 error, got %v", err)
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/jedib0t/go-pretty/table"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+)
+
+var (
+	// only list the calls made by this principal
+	flagAuditPrincipal string
+
+	// only list calls of this action (e.g. campaign.create)
+	flagAuditAction string
+
+	// only list calls made within this period
+	flagAuditSince time.Duration
+
+	// the maximum number of entries listed
+	flagAuditLimit int
+)
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "list the audit log of operator actions",
+	Long: `can be used to list the API calls which created, modified or exported
+campaigns and results, along with the operator who made them, their
+parameters (with passwords redacted) and their outcome. only admins may read
+the audit log.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		auditGet(cmd, args)
+	},
+}
+
+func init() {
+	auditCmd.Flags().UintVarP(&campaignID, "campaign", "c", 0,
+		"only list calls targeting this campaign")
+	auditCmd.Flags().StringVar(&flagAuditPrincipal, "principal", "",
+		"only list calls made by this operator")
+	auditCmd.Flags().StringVar(&flagAuditAction, "action", "",
+		"only list calls of this action (e.g. campaign.create)")
+	auditCmd.Flags().DurationVar(&flagAuditSince, "since", 0,
+		"only list calls made within this period (e.g. 24h)")
+	auditCmd.Flags().IntVar(&flagAuditLimit, "limit", 100,
+		"the maximum number of entries listed (0 for no limit)")
+	rootCmd.AddCommand(auditCmd)
+}
+
+func auditGet(cmd *cobra.Command, args []string) {
+	filter := map[string]interface{}{}
+	if campaignID != 0 {
+		filter["campaign_id"] = campaignID
+	}
+	if flagAuditPrincipal != "" {
+		filter["principal"] = flagAuditPrincipal
+	}
+	if flagAuditAction != "" {
+		filter["action"] = flagAuditAction
+	}
+
+	query := db.AuditQuery{
+		Filter: filter,
+		Limit:  flagAuditLimit,
+	}
+	if flagAuditSince > 0 {
+		query.Since = time.Now().Add(-flagAuditSince)
+	}
+
+	var entries []db.AuditEntry
+	err := json.Unmarshal(apiPost("/audit", query), &entries)
+	if err != nil {
+		log.Fatalf("error parsing response json: %s", err)
+	}
+	if printData(entries) {
+		return
+	}
+
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"time", "principal", "role", "action", "campaign id", "status", "params"})
+	for _, e := range entries {
+		var campaign interface{} = e.CampaignID
+		if e.CampaignID == 0 {
+			campaign = ""
+		}
+		t.AppendRow(table.Row{e.Timestamp.Format(time.RFC3339), e.Principal, e.Role, e.Action,
+			campaign, e.Status, string(e.Params)})
+	}
+
+	render(t)
+}
@@ -0,0 +1,150 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+)
+
+var (
+	// the number of synthetic users and passwords to schedule
+	flagBenchUsers     int
+	flagBenchPasswords int
+
+	// mock nozzle behaviour
+	flagBenchLatency        time.Duration
+	flagBenchJitter         time.Duration
+	flagBenchSuccessRatio   float64
+	flagBenchRateLimitRatio float64
+	flagBenchErrorRatio     float64
+
+	// how often to poll the campaign's progress
+	flagBenchPoll time.Duration
+
+	// the maximum amount of time the benchmark may run for
+	flagBenchTimeout time.Duration
+)
+
+var benchCmd = &cobra.Command{
+	Use:   "bench",
+	Short: "pipeline load-test subcommand",
+	Long: `can be used to measure the throughput of a trident deployment. bench
+creates a campaign against the "mock" nozzle, which makes no requests to an
+identity provider, and reports the attempt rate until every task completes.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		benchRun(cmd, args)
+	},
+}
+
+func init() {
+	benchCmd.Flags().IntVar(&flagBenchUsers, "users", 1000, "the number of synthetic users")
+	benchCmd.Flags().IntVar(&flagBenchPasswords, "passwords", 10, "the number of synthetic passwords")
+	benchCmd.Flags().DurationVar(&flagBenchLatency, "latency", 100*time.Millisecond,
+		"the simulated latency of each login")
+	benchCmd.Flags().DurationVar(&flagBenchJitter, "jitter", 50*time.Millisecond,
+		"a random duration of up to this value added to the latency")
+	benchCmd.Flags().Float64Var(&flagBenchSuccessRatio, "success-ratio", 0.01,
+		"the fraction of logins which are valid")
+	benchCmd.Flags().Float64Var(&flagBenchRateLimitRatio, "rate-limit-ratio", 0,
+		"the fraction of logins which are rate limited")
+	benchCmd.Flags().Float64Var(&flagBenchErrorRatio, "error-ratio", 0,
+		"the fraction of logins which fail with a transient error")
+	benchCmd.Flags().DurationVar(&flagBenchPoll, "poll", 5*time.Second,
+		"how often to report progress")
+	benchCmd.Flags().DurationVar(&flagBenchTimeout, "timeout", time.Hour,
+		"the maximum amount of time to wait for the campaign to complete")
+	benchCmd.Flags().StringVarP(&flagTeam, "team", "t", "",
+		"the team (engagement) that owns the benchmark campaign")
+	rootCmd.AddCommand(benchCmd)
+}
+
+// benchRun creates a mock campaign and reports its progress until every
+// scheduled task has completed or errored.
+func benchRun(cmd *cobra.Command, args []string) {
+	users := make([]string, flagBenchUsers)
+	for i := range users {
+		users[i] = fmt.Sprintf("bench%d@example.org", i)
+	}
+	passwords := make([]string, flagBenchPasswords)
+	for i := range passwords {
+		passwords[i] = fmt.Sprintf("Bench%d!", i)
+	}
+
+	start := time.Now()
+	respBody := apiPost("/campaign", map[string]interface{}{
+		"not_before":        start,
+		"not_after":         start.Add(flagBenchTimeout),
+		"status":            db.CampaignStatusActive,
+		"schedule_interval": 0,
+		"users":             users,
+		"passwords":         passwords,
+		"provider":          "mock",
+		"provider_metadata": map[string]string{
+			"latency":          flagBenchLatency.String(),
+			"jitter":           flagBenchJitter.String(),
+			"success_ratio":    fmt.Sprint(flagBenchSuccessRatio),
+			"rate_limit_ratio": fmt.Sprint(flagBenchRateLimitRatio),
+			"error_ratio":      fmt.Sprint(flagBenchErrorRatio),
+		},
+		"team":        flagTeam,
+		"max_retries": 0,
+	})
+
+	var campaign db.Campaign
+	err := json.Unmarshal(respBody, &campaign)
+	if err != nil {
+		log.Fatalf("error parsing response json: %s", err)
+	}
+	total := int64(flagBenchUsers * flagBenchPasswords)
+	log.Infof("created benchmark campaign %d with %d tasks", campaign.ID, total)
+
+	// always cancel the campaign so an interrupted benchmark stops
+	defer updateStatus(campaign.ID, db.CampaignStatusCancelled)
+
+	ticker := time.NewTicker(flagBenchPoll)
+	defer ticker.Stop()
+	for range ticker.C {
+		var progress []db.CampaignProgress
+		err = json.Unmarshal(apiPost("/campaign/progress", map[string]interface{}{
+			"ID": campaign.ID,
+		}), &progress)
+		if err != nil || len(progress) != 1 {
+			log.Fatalf("error parsing campaign progress: %v", err)
+		}
+		p := progress[0]
+
+		done := p.Completed + p.Errored
+		elapsed := time.Since(start)
+		log.Infof("%d/%d tasks (%d errored), %.1f/min current, %.1f/s overall",
+			done, total, p.Errored, p.Rate, float64(done)/elapsed.Seconds())
+
+		if done >= total {
+			fmt.Printf("completed %d tasks in %s (%.1f tasks/s, %d errored, %d valid)\n",
+				done, elapsed.Round(time.Second), float64(done)/elapsed.Seconds(), p.Errored, p.Valid)
+			return
+		}
+		if elapsed > flagBenchTimeout {
+			log.Errorf("benchmark timed out after %s with %d/%d tasks done", elapsed, done, total)
+			return
+		}
+	}
+}
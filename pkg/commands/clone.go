@@ -0,0 +1,168 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+	"github.com/praetorian-inc/trident/pkg/mangle"
+)
+
+var (
+	// string with RFC3339Nano date format of the cloned campaign, default is
+	// time.Now()
+	flagCloneNotBefore string
+
+	// duration of the cloned campaign's window, defaults to the window of the
+	// original campaign
+	flagCloneWindow time.Duration
+
+	// interval of the cloned campaign, defaults to the interval of the
+	// original campaign
+	flagCloneInterval time.Duration
+
+	// path to file containing the passwords of the cloned campaign, defaults
+	// to the passwords of the original campaign
+	flagClonePasswordFile string
+
+	// path to file containing the password rules of the cloned campaign,
+	// defaults to the rules of the original campaign
+	flagCloneRulesFile string
+)
+
+var cloneCmd = &cobra.Command{
+	Use:   "clone",
+	Short: "create a campaign from an existing campaign",
+	Long: `can be used to re-run a previous campaign (e.g. a recurring spray for the
+same client) with new passwords or a new schedule. users, provider and team are
+copied from the original campaign.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		campaignClone(cmd, args)
+	},
+}
+
+func init() {
+	cloneCmd.Flags().UintVarP(&campaignID, "campaign", "c", 0,
+		"the identifier of the campaign to clone")
+	err := cloneCmd.MarkFlagRequired("campaign")
+	if err != nil {
+		log.Fatalf("issue during argument parsing: %s", err)
+	}
+
+	cloneCmd.Flags().StringVarP(&flagCloneNotBefore, "notbefore", "b", "",
+		"requests will not start before this time (default now)")
+	cloneCmd.Flags().DurationVarP(&flagCloneWindow, "window", "w", 0,
+		"a duration that this campaign will be active (default: the original window)")
+	cloneCmd.Flags().DurationVarP(&flagCloneInterval, "interval", "i", 0,
+		"requests will happen with this interval between them (default: the original interval)")
+	cloneCmd.Flags().StringVarP(&flagClonePasswordFile, "passfile", "p", "",
+		"file of passwords (default: the original passwords)")
+	cloneCmd.Flags().StringVar(&flagCloneRulesFile, "rules", "",
+		"file of password mangling rules (default: the original rules)")
+
+	campaignCmd.AddCommand(cloneCmd)
+}
+
+func campaignClone(cmd *cobra.Command, args []string) {
+	orig := fetchCampaign(campaignID)
+
+	notBefore := time.Now()
+	if flagCloneNotBefore != "" {
+		var err error
+		notBefore, err = time.Parse(time.RFC3339Nano, flagCloneNotBefore)
+		if err != nil {
+			log.Fatalf("error parsing notBefore time: %s", err)
+		}
+	}
+
+	window := orig.NotAfter.Sub(orig.NotBefore)
+	if flagCloneWindow != 0 {
+		window = flagCloneWindow
+	}
+
+	c := db.Campaign{
+		NotBefore:        notBefore,
+		NotAfter:         notBefore.Add(window),
+		ScheduleInterval: orig.ScheduleInterval,
+		Preset:           orig.Preset,
+		Status:           db.CampaignStatusActive,
+		Mode:             orig.Mode,
+		Team:             orig.Team,
+		Users:            orig.Users,
+		Excluded:         orig.Excluded,
+		Passwords:        orig.Passwords,
+		PasswordRules:    orig.PasswordRules,
+		Provider:         orig.Provider,
+		MaxRetries:       orig.MaxRetries,
+		Limits:           orig.Limits,
+		Guardrails:       orig.Guardrails,
+		ProviderMetadata: orig.ProviderMetadata,
+		Actions:          orig.Actions,
+		Redaction:        orig.Redaction,
+	}
+	if flagCloneInterval != 0 {
+		// the preset would override the interval
+		c.ScheduleInterval = flagCloneInterval
+		c.Preset = ""
+	}
+
+	var err error
+	if flagClonePasswordFile != "" {
+		c.Passwords, err = readLines(flagClonePasswordFile)
+		if err != nil {
+			log.Fatalf("error reading lines from password file: %s", err)
+		}
+	}
+	if flagCloneRulesFile != "" {
+		c.PasswordRules, err = readLines(flagCloneRulesFile)
+		if err != nil {
+			log.Fatalf("error reading lines from rules file: %s", err)
+		}
+	}
+
+	compiled, err := mangle.Compile(c.PasswordRules, c.NotBefore)
+	if err != nil {
+		log.Fatalf("error parsing password rules: %s", err)
+	}
+	candidates, err := mangle.Generate(c.Passwords, compiled)
+	if err != nil {
+		log.Fatalf("error generating passwords: %s", err)
+	}
+
+	fmt.Printf("\n[Cloning Campaign #%d]", orig.ID)
+	fmt.Printf(campaignSummary, c.NotBefore, c.NotAfter, c.ScheduleInterval, c.Preset,
+		len(c.Users), 0, len(c.Excluded), len(c.Passwords), len(compiled), len(candidates), c.Provider,
+		string(c.ProviderMetadata), c.Team, c.MaxRetries, c.Limits, c.Guardrails, c.Actions,
+		c.Redaction)
+	if !confirm("Send campaign?") {
+		log.Printf("not sending campaign")
+		return
+	}
+
+	respBody := apiPost("/campaign", &c)
+
+	var created db.Campaign
+	err = json.Unmarshal(respBody, &created)
+	if err != nil {
+		log.Fatalf("error parsing response json: %s", err)
+	}
+	log.Infof("successfully created campaign #%d", created.ID)
+}
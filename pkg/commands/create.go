@@ -20,6 +20,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/praetorian-inc/trident/pkg/db"
+	"github.com/praetorian-inc/trident/pkg/detection"
+	"github.com/praetorian-inc/trident/pkg/mangle"
+	"github.com/praetorian-inc/trident/pkg/scheduler"
+	"github.com/praetorian-inc/trident/pkg/usernames"
 	"net/http"
 	"os"
 	"strings"
@@ -34,9 +38,30 @@ var (
 	// path to file containing usernames to test(newline separated)
 	flagUsernameFile string
 
+	// path to csv file containing people's names
+	flagNamesFile string
+
+	// username format templates used to generate usernames from names
+	flagUsernameFormats []string
+
+	// patterns of accounts which must never be attempted
+	flagExcluded []string
+
+	// path to file containing exclusion patterns (newline separated)
+	flagExcludeFile string
+
 	// path to file containing passwords to test(newline separated)
 	flagPasswordFile string
 
+	// path to file containing password mangling rules (newline separated)
+	flagRulesFile string
+
+	// create a detection validation campaign
+	flagDetection bool
+
+	// number of known-bad passwords attempted per user by detection campaigns
+	flagDetectionAttempts int
+
 	// string with RFC3339Nano date format, default is time.Now()
 	flagNotBefore string
 
@@ -47,9 +72,34 @@ var (
 	// duration used to throttle individual requests by this much
 	flagScheduleInterval time.Duration
 
+	// name of the built-in preset setting the interval and per user limit
+	flagPreset string
+
 	// authentication provider to select for target, provider metadata is
 	// read from the config file
 	flagProvider string
+
+	// team (engagement) that owns the campaign
+	flagTeam string
+
+	// number of times a task failing with a transient error is retried
+	flagMaxRetries int
+
+	// campaign-level caps enforced by the scheduler
+	flagLimits db.Limits
+
+	// thresholds which automatically pause or abort the campaign
+	flagGuardrails db.Guardrails
+	flagTripwires  []string
+	flagAbort      bool
+	flagOnCaptcha  string
+
+	// names of the post-success actions run against valid credentials,
+	// their options are read from the config file
+	flagActions []string
+
+	// credentials replaced with tokens in the stored results
+	flagRedaction string
 )
 
 const (
@@ -58,10 +108,21 @@ const (
 Not Before: %s
 Not After: %s
 Interval: %s
+Preset: %s
 Username count: %d
+Generated username count: %d
+Exclusion patterns: %d
 Password count: %d
+Password rules: %d
+Candidate count: %d
 Provider: %s
 Metadata: %v
+Team: %s
+Max retries: %d
+Limits: %s
+Guardrails: %s
+Actions: %s
+Redaction: %s
 
 `
 )
@@ -80,23 +141,34 @@ func init() {
 
 	// required arguments
 
-	campaignCreateCmd.Flags().StringVarP(&flagUsernameFile, "userfile", "u", "",
-		"file of usernames (newline separated)")
-	err := campaignCreateCmd.MarkFlagRequired("userfile")
-	if err != nil {
-		log.Fatalf("issue during argument parsing: %s", err)
-
-	}
+	// optional arguments
 
+	// required unless the campaign is a detection campaign
 	campaignCreateCmd.Flags().StringVarP(&flagPasswordFile, "passfile", "p", "",
 		"file of passwords (newline separated)")
-	err = campaignCreateCmd.MarkFlagRequired("passfile")
-	if err != nil {
-		log.Fatalf("issue during argument parsing: %s", err)
 
-	}
+	campaignCreateCmd.Flags().BoolVar(&flagDetection, "detection", false,
+		"create a detection validation campaign, which attempts known-bad passwords in bursts against each user")
 
-	// optional arguments
+	campaignCreateCmd.Flags().IntVar(&flagDetectionAttempts, "attempts", detection.DefaultAttempts,
+		"the number of known-bad passwords attempted per user by detection campaigns")
+
+	// at least one of userfile or names is required
+	campaignCreateCmd.Flags().StringVarP(&flagUsernameFile, "userfile", "u", "",
+		"file of usernames (newline separated)")
+
+	campaignCreateCmd.Flags().StringVarP(&flagNamesFile, "names", "n", "",
+		"csv file of people's names to generate usernames from")
+
+	campaignCreateCmd.Flags().StringSliceVar(&flagUsernameFormats, "username-format", nil,
+		"username format template used with --names, may be repeated (ex: {f}{last}@example.org)")
+
+	campaignCreateCmd.Flags().StringSliceVar(&flagExcluded, "exclude", nil,
+		"accounts never to attempt, may use * wildcards (ex: admin-*@example.org)")
+	campaignCreateCmd.Flags().StringVar(&flagExcludeFile, "exclude-file", "",
+		"file containing accounts never to attempt (newline separated)")
+	campaignCreateCmd.Flags().StringVar(&flagRulesFile, "rules", "",
+		"file of password mangling rules applied to the passwords (newline separated)")
 
 	// default: time.Now()
 	campaignCreateCmd.Flags().StringVarP(&flagNotBefore, "notbefore", "b", defaultNotBefore,
@@ -110,10 +182,46 @@ func init() {
 	campaignCreateCmd.Flags().DurationVarP(&flagScheduleInterval, "interval", "i", time.Second,
 		"requests will happen with this interval between them")
 
+	campaignCreateCmd.Flags().StringVar(&flagPreset, "preset", "",
+		"a built-in low-and-slow preset setting --interval and a per user limit (see 'campaign presets')")
+
 	// default: okta
 	campaignCreateCmd.Flags().StringVarP(&flagProvider, "auth-provider", "a", "okta",
 		"this is the authentication platform you are attacking")
 
+	campaignCreateCmd.Flags().StringVarP(&flagTeam, "team", "t", "",
+		"the team (engagement) that owns this campaign")
+
+	// default: 3
+	campaignCreateCmd.Flags().IntVar(&flagMaxRetries, "max-retries", 3,
+		"the number of times a task failing with a transient error is retried")
+
+	campaignCreateCmd.Flags().IntVar(&flagLimits.MaxAttemptsPerUser, "max-attempts-per-user", 0,
+		"the maximum attempts against a single user within --user-window (0 disables)")
+	campaignCreateCmd.Flags().DurationVar(&flagLimits.UserWindow, "user-window", 0,
+		"the sliding window of --max-attempts-per-user (ex: 30m)")
+	campaignCreateCmd.Flags().IntVar(&flagLimits.MaxAttemptsPerHour, "max-attempts-per-hour", 0,
+		"the maximum attempts of the campaign within any hour (0 disables)")
+	campaignCreateCmd.Flags().IntVar(&flagLimits.MaxInFlight, "max-in-flight", 0,
+		"the maximum tasks of the campaign in flight at once (0 disables)")
+
+	campaignCreateCmd.Flags().Float64Var(&flagGuardrails.MaxLockoutRate, "max-lockout-rate", 0,
+		"pause the campaign if more than this percentage of attempts find locked accounts (0 disables)")
+	campaignCreateCmd.Flags().IntVar(&flagGuardrails.MaxConsecutiveRateLimited, "max-rate-limited", 0,
+		"pause the campaign after this many consecutive rate limited attempts (0 disables)")
+	campaignCreateCmd.Flags().StringSliceVar(&flagTripwires, "tripwire", nil,
+		"pause the campaign if this account is found locked (can be repeated)")
+	campaignCreateCmd.Flags().BoolVar(&flagAbort, "abort-on-guardrail", false,
+		"cancel the campaign instead of pausing it when a guardrail trips")
+	campaignCreateCmd.Flags().StringVar(&flagOnCaptcha, "on-captcha", "backoff",
+		"action taken when a user is challenged with a CAPTCHA (backoff, skip or trip)")
+	campaignCreateCmd.Flags().StringSliceVar(&flagActions, "action", nil,
+		"read-only post-success action run against valid credentials (e.g. smtp_auth), "+
+			"configured under actions in the config file")
+	campaignCreateCmd.Flags().StringVar(&flagRedaction, "redact", "",
+		"replace the passwords (passwords) or the usernames and passwords (credentials) of stored results "+
+			"with tokens for read-only users")
+
 	campaignCmd.AddCommand(campaignCreateCmd)
 }
 
@@ -134,6 +242,17 @@ func readLines(path string) ([]string, error) {
 	return lines, scanner.Err()
 }
 
+// readNames reads people's names from a csv file.
+func readNames(path string) ([]string, error) {
+	file, err := os.Open(path) //nolint:gosec
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close() // nolint:errcheck,gosec
+
+	return usernames.ReadCSV(file)
+}
+
 func confirm(s string) bool {
 	fmt.Printf("%s [y/N]: ", s)
 
@@ -154,14 +273,110 @@ func campaignCreate(cmd *cobra.Command, args []string) {
 	orchestrator := viper.GetString("orchestrator-url")
 	providers := viper.GetStringMap("providers")
 
-	users, err := readLines(flagUsernameFile)
+	if flagUsernameFile == "" && flagNamesFile == "" {
+		log.Fatal("either --userfile or --names is required")
+	}
+
+	var users []string
+	var err error
+	if flagUsernameFile != "" {
+		users, err = readLines(flagUsernameFile)
+		if err != nil {
+			log.Fatalf("error reading lines from user file: %s", err)
+		}
+	}
+
+	excluded := flagExcluded
+	if flagExcludeFile != "" {
+		lines, err := readLines(flagExcludeFile)
+		if err != nil {
+			log.Fatalf("error reading lines from exclude file: %s", err)
+		}
+		for _, line := range lines {
+			if line = strings.TrimSpace(line); line != "" {
+				excluded = append(excluded, line)
+			}
+		}
+	}
+	err = usernames.ValidateExclusions(excluded)
 	if err != nil {
-		log.Fatalf("error reading lines from user file: %s", err)
+		log.Fatalf("error in exclusions: %s", err)
+	}
+
+	actions := make(db.Actions, 0, len(flagActions))
+	for _, name := range flagActions {
+		actions = append(actions, db.Action{
+			Name:    name,
+			Options: viper.GetStringMapString("actions." + name),
+		})
+	}
+
+	var names []string
+	if flagNamesFile != "" {
+		names, err = readNames(flagNamesFile)
+		if err != nil {
+			log.Fatalf("error reading names from names file: %s", err)
+		}
 	}
 
-	passwords, err := readLines(flagPasswordFile)
+	// usernames are generated by the orchestrator, they are only generated
+	// here to validate the formats and summarize the campaign
+	generated, err := usernames.Generate(names, flagUsernameFormats)
 	if err != nil {
-		log.Fatalf("error reading lines from password file: %s", err)
+		log.Fatalf("error generating usernames: %s", err)
+	}
+
+	mode := db.CampaignModeSpray
+	var passwords []string
+	switch {
+	case flagDetection:
+		if flagPasswordFile != "" || flagRulesFile != "" {
+			log.Fatal("detection campaigns do not accept passwords or password rules")
+		}
+		// the orchestrator replaces these with its own known-bad passwords
+		mode = db.CampaignModeDetection
+		passwords, err = detection.Passwords(flagDetectionAttempts)
+		if err != nil {
+			log.Fatalf("error generating detection passwords: %s", err)
+		}
+	case flagPasswordFile != "":
+		passwords, err = readLines(flagPasswordFile)
+		if err != nil {
+			log.Fatalf("error reading lines from password file: %s", err)
+		}
+	default:
+		log.Fatal("--passfile is required")
+	}
+
+	if flagPreset != "" {
+		p, err := scheduler.LookupPreset(flagPreset)
+		if err != nil {
+			log.Fatalf("error in campaign preset: %s", err)
+		}
+		flagScheduleInterval = p.Window
+		flagLimits.MaxAttemptsPerUser = 1
+		flagLimits.UserWindow = p.Window
+	}
+
+	err = flagLimits.Validate()
+	if err != nil {
+		log.Fatalf("error in campaign limits: %s", err)
+	}
+
+	flagGuardrails.Tripwires = flagTripwires
+	if flagAbort {
+		flagGuardrails.GuardrailAction = db.GuardrailAbort
+	}
+	flagGuardrails.OnCaptcha = db.CaptchaAction(flagOnCaptcha)
+	err = flagGuardrails.Validate()
+	if err != nil {
+		log.Fatalf("error in campaign guardrails: %s", err)
+	}
+
+	redaction := db.RedactionMode(flagRedaction)
+	err = redaction.Validate()
+	if err != nil {
+		log.Fatalf("error in campaign redaction: %s", err)
 	}
 
 	parsedNotBefore, err := time.Parse(time.RFC3339Nano, flagNotBefore)
@@ -172,23 +387,65 @@ func campaignCreate(cmd *cobra.Command, args []string) {
 	// duration math. NotAfter = NotBefore + ActiveWindow
 	parsedNotAfter := parsedNotBefore.Add(flagActiveWindow)
 
+	var rules []string
+	if flagRulesFile != "" {
+		rules, err = readLines(flagRulesFile)
+		if err != nil {
+			log.Fatalf("error reading lines from rules file: %s", err)
+		}
+	}
+
+	// candidates are generated by the orchestrator, they are only generated
+	// here to validate the rules and summarize the campaign
+	compiled, err := mangle.Compile(rules, parsedNotBefore)
+	if err != nil {
+		log.Fatalf("error parsing password rules: %s", err)
+	}
+	candidates, err := mangle.Generate(passwords, compiled)
+	if err != nil {
+		log.Fatalf("error generating passwords: %s", err)
+	}
+
 	requestBody, err := json.Marshal(map[string]interface{}{
-		"not_before":        parsedNotBefore,
-		"not_after":         parsedNotAfter,
-		"status":            db.CampaignStatusActive,
-		"schedule_interval": flagScheduleInterval,
-		"users":             users,
-		"passwords":         passwords,
-		"provider":          flagProvider,
-		"provider_metadata": providers[flagProvider],
+		"not_before":            parsedNotBefore,
+		"not_after":             parsedNotAfter,
+		"status":                db.CampaignStatusActive,
+		"mode":                  mode,
+		"schedule_interval":     flagScheduleInterval,
+		"preset":                flagPreset,
+		"users":                 users,
+		"excluded":              excluded,
+		"names":                 names,
+		"username_formats":      flagUsernameFormats,
+		"passwords":             passwords,
+		"password_rules":        rules,
+		"provider":              flagProvider,
+		"provider_metadata":     providers[flagProvider],
+		"team":                  flagTeam,
+		"max_retries":           flagMaxRetries,
+		"max_attempts_per_user": flagLimits.MaxAttemptsPerUser,
+		"user_window":           flagLimits.UserWindow,
+		"max_attempts_per_hour": flagLimits.MaxAttemptsPerHour,
+		"max_in_flight":         flagLimits.MaxInFlight,
+
+		"max_lockout_rate":             flagGuardrails.MaxLockoutRate,
+		"max_consecutive_rate_limited": flagGuardrails.MaxConsecutiveRateLimited,
+		"tripwires":                    flagGuardrails.Tripwires,
+		"guardrail_action":             flagGuardrails.GuardrailAction,
+		"on_captcha":                   flagGuardrails.OnCaptcha,
+
+		"actions":   actions,
+		"redaction": redaction,
 	})
 	if err != nil {
 		log.Fatalf("error during JSON marshalling for request body: %s", err)
 	}
 
 	// print summary of campaign and prompt user to accept
-	fmt.Printf(campaignSummary, parsedNotBefore, parsedNotAfter, flagScheduleInterval,
-		len(users), len(passwords), flagProvider, providers[flagProvider])
+	fmt.Printf(campaignSummary, parsedNotBefore, parsedNotAfter, flagScheduleInterval, flagPreset,
+		len(users), len(generated), len(excluded), len(passwords), len(compiled), len(candidates),
+		flagProvider, providers[flagProvider], flagTeam, flagMaxRetries, flagLimits, flagGuardrails, actions,
+		redaction)
 	if !confirm("Send campaign?") {
 		log.Printf("not sending campaign")
 		return
@@ -211,6 +468,10 @@ func campaignCreate(cmd *cobra.Command, args []string) {
 	}
 	defer resp.Body.Close() // nolint:errcheck
 
+	if resp.StatusCode != 200 {
+		log.Fatalf("error creating campaign on server: %d", resp.StatusCode)
+	}
+
 	log.Debug(resp)
 	log.Info("successfully created campaign")
 }
@@ -0,0 +1,114 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	"github.com/jedib0t/go-pretty/table"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+)
+
+// whether or not to decrypt stored passwords
+var flagReveal bool
+
+var credentialsCmd = &cobra.Command{
+	Use:   "credentials",
+	Short: "credential vault reporting subcommand",
+	Long:  `can be used to list the deduplicated credentials discovered across campaigns`,
+	Run: func(cmd *cobra.Command, args []string) {
+		credentialsGet(cmd, args)
+	},
+}
+
+func init() {
+	credentialsCmd.Flags().BoolVar(&flagReveal, "reveal", false,
+		"decrypt and display stored passwords (requires the operator role)")
+	rootCmd.AddCommand(credentialsCmd)
+}
+
+// credentialsGet will retrieve the credential vault from the orchestrator and
+// print it to the CLI
+func credentialsGet(cmd *cobra.Command, args []string) {
+	orchestrator := viper.GetString("orchestrator-url")
+
+	requestBody, err := json.Marshal(map[string]interface{}{
+		"Reveal": flagReveal,
+	})
+	if err != nil {
+		log.Fatalf("error during JSON marshalling for request body: %s", err)
+	}
+
+	req, err := http.NewRequest("POST", orchestrator+"/credentials", bytes.NewBuffer(requestBody))
+	if err != nil {
+		log.Fatalf("error during request creation: %s", err)
+	}
+
+	err = authenticator.Auth(req)
+	if err != nil {
+		log.Fatalf("error during authentication: %s", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Fatalf("error sending request: %s", err)
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		log.Fatalf("error reading response body: %s", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		log.Fatalf("error listing credentials: %s", bytes.TrimSpace(respBody))
+	}
+
+	var creds []db.Credential
+	err = json.Unmarshal(respBody, &creds)
+	if err != nil {
+		log.Fatalf("error parsing response json: %s", err)
+	}
+	if printData(creds) {
+		return
+	}
+
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+
+	header := table.Row{"username", "provider", "team", "valid", "mfa", "first seen", "last validated"}
+	if flagReveal {
+		header = append(header, "password")
+	}
+	t.AppendHeader(header)
+
+	for _, c := range creds {
+		row := table.Row{c.Username, c.Provider, c.Team, c.Valid, c.MFA, c.FirstSeen, c.LastValidated}
+		if flagReveal {
+			row = append(row, c.Password)
+		}
+		t.AppendRow(row)
+	}
+
+	render(t)
+}
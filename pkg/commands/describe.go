@@ -19,6 +19,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/praetorian-inc/trident/pkg/db"
 
@@ -55,9 +56,52 @@ func init() {
 // describeGet will retrieve the parameters that make up the given campaign
 // and print the parameters to the CLI
 func describeGet(cmd *cobra.Command, args []string) {
+	campaign := fetchCampaign(campaignID)
+	if printData(campaign) {
+		return
+	}
+
+	fmt.Printf("-------------------------------------------\n")
+	fmt.Printf("Campaign #%d Parameters:\n", campaignID)
+	fmt.Printf("-------------------------------------------\n")
+	fmt.Printf("Start Time:     %s\n", campaign.NotBefore)
+	fmt.Printf("End Time:       %s\n", campaign.NotAfter)
+	if campaign.Status != "" {
+		fmt.Printf("Status:         %s\n", campaign.Status)
+	} else {
+		fmt.Printf("Status:         %s\n", db.CampaignStatusActive)
+	}
+	if campaign.Mode != db.CampaignModeSpray {
+		fmt.Printf("Mode:           %s\n", campaign.Mode)
+	}
+	fmt.Printf("User Count:     %d\n", len(campaign.Users))
+	if len(campaign.Excluded) > 0 {
+		fmt.Printf("Excluded:       %s\n", strings.Join(campaign.Excluded, ", "))
+	}
+	fmt.Printf("Password Count: %d\n", len(campaign.Passwords))
+	fmt.Printf("Password Rules: %d\n", len(campaign.PasswordRules))
+	fmt.Printf("Provider:       %s\n", campaign.Provider)
+	fmt.Printf("Team:           %s\n", campaign.Team)
+	fmt.Printf("Max Retries:    %d\n", campaign.MaxRetries)
+	fmt.Printf("Limits:         %s\n", campaign.Limits)
+	fmt.Printf("Guardrails:     %s\n", campaign.Guardrails)
+	fmt.Printf("Metadata:       %s\n", campaign.ProviderMetadata)
+	if len(campaign.Actions) > 0 {
+		fmt.Printf("Actions:        %s\n", campaign.Actions)
+	}
+	if campaign.Redaction != db.RedactionNone {
+		fmt.Printf("Redaction:      %s\n", campaign.Redaction)
+	}
+	if !campaign.Fingerprint.ProbedAt.IsZero() {
+		fmt.Printf("Tenant:         %s\n", campaign.Fingerprint)
+	}
+}
+
+// fetchCampaign retrieves the parameters of a campaign
+func fetchCampaign(id uint) db.Campaign {
 	orchestrator := viper.GetString("orchestrator-url")
 
-	var flagFilter = fmt.Sprintf("{\"id\":%d}", campaignID)
+	var flagFilter = fmt.Sprintf("{\"id\":%d}", id)
 
 	var filter map[string]interface{}
 	err := json.Unmarshal([]byte(flagFilter), &filter)
@@ -102,18 +146,5 @@ func describeGet(cmd *cobra.Command, args []string) {
 		log.Fatalf("error parsing response json: %s", err)
 	}
 
-	fmt.Printf("-------------------------------------------\n")
-	fmt.Printf("Campaign #%d Parameters:\n", campaignID)
-	fmt.Printf("-------------------------------------------\n")
-	fmt.Printf("Start Time:     %s\n", campaign.NotBefore)
-	fmt.Printf("End Time:       %s\n", campaign.NotAfter)
-	if campaign.Status != "" {
-		fmt.Printf("Status:         %s\n", campaign.Status)
-	} else {
-		fmt.Printf("Status:         %s\n", db.CampaignStatusActive)
-	}
-	fmt.Printf("User Count:     %d\n", len(campaign.Users))
-	fmt.Printf("Password Count: %d\n", len(campaign.Passwords))
-	fmt.Printf("Provider:       %s\n", campaign.Provider)
-	fmt.Printf("Metadata:       %s\n", campaign.ProviderMetadata)
+	return campaign
 }
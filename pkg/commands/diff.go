@@ -0,0 +1,142 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/jedib0t/go-pretty/table"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+)
+
+var diffCmd = &cobra.Command{
+	Use:   "diff <campaign> <campaign>",
+	Short: "compare the results of two campaigns",
+	Long: `can be used to compare the results of two campaigns (e.g. recurring sprays
+for the same client) to find accounts which are newly valid or newly locked,
+or which no longer are.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		campaignDiff(cmd, args)
+	},
+}
+
+func init() {
+	campaignCmd.AddCommand(diffCmd)
+}
+
+// accountState summarizes the results of an account within a campaign
+type accountState struct {
+	Valid  bool
+	Locked bool
+}
+
+// accountChange is a change of an account's state between two campaigns
+type accountChange struct {
+	Username string `json:"username"`
+	Change   string `json:"change"`
+}
+
+// campaignStates retrieves the state of every account attempted by the
+// campaign
+func campaignStates(id uint) map[string]accountState {
+	respBody := apiPost("/results", map[string]interface{}{
+		"ReturnedFields": []string{"username", "valid", "locked"},
+		"Filter":         map[string]interface{}{"campaign_id": id},
+	})
+
+	var results []db.Result
+	err := json.Unmarshal(respBody, &results)
+	if err != nil {
+		log.Fatalf("error parsing response json: %s", err)
+	}
+
+	states := make(map[string]accountState)
+	for _, res := range results {
+		s := states[res.Username]
+		s.Valid = s.Valid || res.Valid
+		s.Locked = s.Locked || res.Locked
+		states[res.Username] = s
+	}
+	return states
+}
+
+// diffStates lists the accounts whose state changed between campaigns
+func diffStates(before, after map[string]accountState) []accountChange {
+	changes := make([]accountChange, 0)
+	for username, a := range after {
+		b, ok := before[username]
+		if !ok {
+			continue
+		}
+		switch {
+		case a.Valid && !b.Valid:
+			changes = append(changes, accountChange{username, "newly valid"})
+		case !a.Valid && b.Valid:
+			changes = append(changes, accountChange{username, "no longer valid"})
+		}
+		switch {
+		case a.Locked && !b.Locked:
+			changes = append(changes, accountChange{username, "newly locked"})
+		case !a.Locked && b.Locked:
+			changes = append(changes, accountChange{username, "no longer locked"})
+		}
+	}
+	for username, a := range after {
+		if _, ok := before[username]; !ok && a.Valid {
+			changes = append(changes, accountChange{username, "valid (new account)"})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Change != changes[j].Change {
+			return changes[i].Change < changes[j].Change
+		}
+		return changes[i].Username < changes[j].Username
+	})
+	return changes
+}
+
+func campaignDiff(cmd *cobra.Command, args []string) {
+	ids := make([]uint, 0, len(args))
+	for _, arg := range args {
+		id, err := strconv.ParseUint(arg, 10, 32)
+		if err != nil {
+			log.Fatalf("invalid campaign id %q", arg)
+		}
+		ids = append(ids, uint(id))
+	}
+
+	changes := diffStates(campaignStates(ids[0]), campaignStates(ids[1]))
+
+	if printData(changes) {
+		return
+	}
+
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"username", "change"})
+	for _, c := range changes {
+		t.AppendRow(table.Row{c.Username, c.Change})
+	}
+
+	render(t)
+}
@@ -0,0 +1,76 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/jedib0t/go-pretty/table"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/praetorian-inc/trident/pkg/report"
+)
+
+var egressCmd = &cobra.Command{
+	Use:   "egress",
+	Short: "summarize the egress IPs of a campaign",
+	Long: `can be used to list every IP (and region) a campaign's attempts originated
+from, along with the number of attempts and outcomes per IP and when each IP
+was first and last used, e.g. to share with a client or correlate with blocks
+applied by the target.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		egressGet(cmd, args)
+	},
+}
+
+func init() {
+	egressCmd.Flags().UintVarP(&campaignID, "campaign", "c", 0,
+		"the identifier of the campaign")
+	err := egressCmd.MarkFlagRequired("campaign")
+	if err != nil {
+		log.Fatalf("issue during argument parsing: %s", err)
+	}
+
+	campaignCmd.AddCommand(egressCmd)
+}
+
+func egressGet(cmd *cobra.Command, args []string) {
+	respBody := apiPost("/campaign/egress", map[string]interface{}{
+		"ID": campaignID,
+	})
+
+	var stats []report.EgressStats
+	err := json.Unmarshal(respBody, &stats)
+	if err != nil {
+		log.Fatalf("error parsing response json: %s", err)
+	}
+	if printData(stats) {
+		return
+	}
+
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"ip", "region", "attempts", "valid", "locked", "rate limited",
+		"captcha", "errors", "first seen", "last seen"})
+	for _, e := range stats {
+		t.AppendRow(table.Row{e.IP, e.Region, e.Attempts, e.Valid, e.Locked, e.RateLimited,
+			e.Captcha, e.Errors, e.FirstSeen.Format(time.RFC3339), e.LastSeen.Format(time.RFC3339)})
+	}
+
+	render(t)
+}
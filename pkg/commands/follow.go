@@ -0,0 +1,131 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+)
+
+var (
+	// only stream valid results
+	flagValidOnly bool
+)
+
+var resultsFollowCmd = &cobra.Command{
+	Use:   "follow",
+	Short: "stream results as they are received",
+	Long: `can be used to tail the results of running campaigns live. results are
+printed as they are received by the orchestrator until interrupted.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		resultsFollow(cmd, args)
+	},
+}
+
+func init() {
+	resultsFollowCmd.Flags().UintVarP(&campaignID, "campaign", "c", 0,
+		"only stream results of this campaign")
+	resultsFollowCmd.Flags().BoolVar(&flagValidOnly, "valid", false,
+		"only stream valid credentials")
+	resultsCmd.AddCommand(resultsFollowCmd)
+}
+
+// resultsFollow subscribes to the orchestrator's result stream and prints
+// each result as it arrives
+func resultsFollow(cmd *cobra.Command, args []string) {
+	orchestrator := viper.GetString("orchestrator-url")
+
+	q := url.Values{}
+	if campaignID != 0 {
+		q.Set("campaign_id", strconv.FormatUint(uint64(campaignID), 10))
+	}
+	if flagValidOnly {
+		q.Set("valid", "true")
+	}
+
+	req, err := http.NewRequest("GET", orchestrator+"/results/stream?"+q.Encode(), nil)
+	if err != nil {
+		log.Fatalf("error during request creation: %s", err)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	err = authenticator.Auth(req)
+	if err != nil {
+		log.Fatalf("error during authentication: %s", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Fatalf("error sending request: %s", err)
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	if resp.StatusCode != 200 {
+		log.Fatalf("error returning results from server: %d", resp.StatusCode)
+	}
+
+	log.Info("following results, press ctrl+c to stop")
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		data := strings.TrimPrefix(line, "data: ")
+
+		// results are printed one per line in json, and as separate
+		// documents in yaml
+		if flagOutputFormat == formatJSON {
+			fmt.Println(data)
+			continue
+		}
+
+		var res db.Result
+		err = json.Unmarshal([]byte(data), &res)
+		if err != nil {
+			log.Errorf("error parsing result: %s", err)
+			continue
+		}
+		if flagOutputFormat == formatYAML {
+			b, err := toYAML(res)
+			if err != nil {
+				log.Errorf("error encoding result: %s", err)
+				continue
+			}
+			fmt.Printf("---\n%s", b)
+			continue
+		}
+		fmt.Printf("%s campaign=%d username=%s password=%s valid=%t mfa=%t locked=%t rate_limited=%t captcha=%t\n",
+			res.Timestamp.Format(time.RFC3339), res.CampaignID, res.Username, res.Password,
+			res.Valid, res.MFA, res.Locked, res.RateLimited, res.Captcha)
+	}
+
+	if err := scanner.Err(); err != nil {
+		log.Fatalf("error reading result stream: %s", err)
+	}
+	log.Info("result stream closed by the orchestrator")
+}
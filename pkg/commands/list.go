@@ -42,6 +42,7 @@ var listTableHeaderNames = []string{
 	"provider",
 	"metadata",
 	"status",
+	"team",
 	"creation date",
 }
 
@@ -50,6 +51,7 @@ var listTableHeaderFields = []string{
 	"provider",
 	"provider_metadata",
 	"status",
+	"team",
 	"created_at",
 }
 
@@ -91,6 +93,18 @@ func listGet(cmd *cobra.Command, args []string) {
 		log.Fatalf("error parsing response json: %s", err)
 	}
 
+	// only the listed fields are returned by the orchestrator
+	campaigns := make([]map[string]interface{}, len(results))
+	for i, result := range results {
+		campaigns[i] = make(map[string]interface{}, len(listTableHeaderFields))
+		for _, field := range listTableHeaderFields {
+			campaigns[i][field] = result[field]
+		}
+	}
+	if printData(campaigns) {
+		return
+	}
+
 	t := table.NewWriter()
 	t.SetOutputMirror(os.Stdout)
 
@@ -117,10 +131,5 @@ func listGet(cmd *cobra.Command, args []string) {
 		t.AppendRows([]table.Row{row})
 	}
 
-	if flagOutputFormat == "csv" {
-		t.RenderCSV()
-		return
-	}
-
-	t.Render()
+	render(t)
 }
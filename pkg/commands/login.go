@@ -0,0 +1,78 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/praetorian-inc/trident/pkg/auth/oidc"
+)
+
+var loginCmd = &cobra.Command{
+	Use:   "login",
+	Short: "log in to the orchestrator's identity provider",
+	Long: `can be used to authenticate to an orchestrator configured with an
+	OpenID Connect identity provider (auth.provider: oidc). tokens are cached in
+	~/.trident/token.json and refreshed automatically.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		login(cmd, args)
+	},
+}
+
+var logoutCmd = &cobra.Command{
+	Use:   "logout",
+	Short: "remove cached identity provider tokens",
+	Long:  `can be used to remove the tokens cached by the login subcommand.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		logout(cmd, args)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(loginCmd)
+	rootCmd.AddCommand(logoutCmd)
+}
+
+// deviceAuthenticator returns the configured OIDC authenticator or exits if
+// a different auth provider is configured.
+func deviceAuthenticator() *oidc.DeviceAuthenticator {
+	a, ok := authenticator.(*oidc.DeviceAuthenticator)
+	if !ok {
+		log.Fatal("login is only supported with the oidc auth provider")
+	}
+	return a
+}
+
+// login performs the device authorization grant against the configured
+// identity provider.
+func login(cmd *cobra.Command, args []string) {
+	err := deviceAuthenticator().Login(context.Background(), os.Stdout)
+	if err != nil {
+		log.Fatalf("error logging in: %s", err)
+	}
+	log.Info("successfully logged in")
+}
+
+// logout removes the cached tokens.
+func logout(cmd *cobra.Command, args []string) {
+	err := deviceAuthenticator().Logout()
+	if err != nil {
+		log.Fatalf("error logging out: %s", err)
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/jedib0t/go-pretty/table"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// the output formats selected with --output-format
+const (
+	formatTable = "table"
+	formatCSV   = "csv"
+	formatJSON  = "json"
+	formatYAML  = "yaml"
+)
+
+var (
+	// the desired format for output (table, csv, json, yaml)
+	flagOutputFormat string
+)
+
+// printData prints v in the machine-readable output format, if one was
+// requested. false is returned for the table and csv formats, which are
+// rendered by the command.
+func printData(v interface{}) bool {
+	switch flagOutputFormat {
+	case formatJSON:
+		b, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			log.Fatalf("error encoding output: %s", err)
+		}
+		fmt.Println(string(b))
+	case formatYAML:
+		b, err := toYAML(v)
+		if err != nil {
+			log.Fatalf("error encoding output: %s", err)
+		}
+		fmt.Print(string(b))
+	case formatTable, formatCSV:
+		return false
+	default:
+		log.Fatalf("unknown output format %q", flagOutputFormat)
+	}
+	return true
+}
+
+// toYAML encodes v as YAML using its JSON field names, so that both formats
+// have the same keys.
+func toYAML(v interface{}) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var data interface{}
+	err = yaml.Unmarshal(b, &data)
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(data)
+}
+
+// render renders a table in the csv or table output format.
+func render(t table.Writer) {
+	if flagOutputFormat == formatCSV {
+		t.RenderCSV()
+		return
+	}
+	t.Render()
+}
@@ -0,0 +1,54 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"os"
+
+	"github.com/jedib0t/go-pretty/table"
+	"github.com/spf13/cobra"
+
+	"github.com/praetorian-inc/trident/pkg/scheduler"
+)
+
+var presetsCmd = &cobra.Command{
+	Use:   "presets",
+	Short: "list the built-in campaign presets",
+	Long: `can be used to list the low-and-slow presets which may be selected with
+'campaign create --preset'. a preset attempts one password against every user
+per window, and the scheduler never attempts a user twice within it.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		presetsList(cmd, args)
+	},
+}
+
+func init() {
+	campaignCmd.AddCommand(presetsCmd)
+}
+
+func presetsList(cmd *cobra.Command, args []string) {
+	if printData(scheduler.Presets) {
+		return
+	}
+
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"name", "window", "description"})
+	for _, p := range scheduler.Presets {
+		t.AppendRow(table.Row{p.Name, p.Window, p.Description})
+	}
+
+	render(t)
+}
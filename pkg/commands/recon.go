@@ -0,0 +1,96 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jedib0t/go-pretty/table"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+
+	"github.com/praetorian-inc/trident/pkg/recon"
+)
+
+var reconCmd = &cobra.Command{
+	Use:   "recon <domain>",
+	Short: "fingerprint the identity providers of a domain",
+	Long: `can be used before creating a campaign to detect which identity providers
+a domain uses (Azure AD realm discovery, Okta organizations, MX and autodiscover
+records, and common VPN portals), and suggests the providers section of the
+config file for the detected providers trident has a nozzle for. recon runs
+from the operator's machine and does not contact the orchestrator.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		reconRun(cmd, args)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(reconCmd)
+}
+
+func reconRun(cmd *cobra.Command, args []string) {
+	r, err := recon.Run(context.Background(), args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, e := range r.Errors {
+		log.Warnf("recon check failed: %s", e)
+	}
+	if printData(r) {
+		return
+	}
+
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"product", "provider", "evidence"})
+	for _, f := range r.Findings {
+		t.AppendRow(table.Row{f.Product, f.Provider, f.Evidence})
+	}
+	render(t)
+
+	if flagOutputFormat == formatCSV {
+		return
+	}
+	if len(r.MX) > 0 {
+		fmt.Printf("\nMX: %s\n", strings.Join(r.MX, ", "))
+	}
+
+	suggested := r.Providers()
+	if len(suggested) == 0 {
+		return
+	}
+	providers := make(map[string]map[string]string)
+	for _, f := range suggested {
+		config, ok := providers[f.Provider]
+		if !ok {
+			config = make(map[string]string)
+			providers[f.Provider] = config
+		}
+		for k, v := range f.Config {
+			config[k] = v
+		}
+	}
+	b, err := yaml.Marshal(map[string]interface{}{"providers": providers})
+	if err != nil {
+		log.Fatalf("error encoding providers: %s", err)
+	}
+	fmt.Printf("\nsuggested config:\n\n%s", b)
+}
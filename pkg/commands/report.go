@@ -0,0 +1,98 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/praetorian-inc/trident/pkg/report"
+)
+
+var (
+	// campaigns to include in the report (default: every campaign)
+	flagReportCampaigns []uint
+
+	// path the report is written to (default: stdout)
+	flagReportOutput string
+)
+
+var reportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "generate a report across campaigns",
+	Long: `can be used to aggregate the results of one or more campaigns into a
+report: success rate by password and domain, MFA coverage, lockout rate, the
+weakest passwords and the time to the first valid credential.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		reportGet(cmd, args)
+	},
+}
+
+func init() {
+	reportCmd.Flags().UintSliceVarP(&flagReportCampaigns, "campaign", "c", nil,
+		"campaigns to include in the report (default: every campaign)")
+	reportCmd.Flags().StringVar(&flagReportOutput, "out", "",
+		"file the report is written to (default: stdout)")
+	rootCmd.AddCommand(reportCmd)
+}
+
+func reportGet(cmd *cobra.Command, args []string) {
+	respBody := apiPost("/report", map[string]interface{}{
+		"CampaignIDs": flagReportCampaigns,
+	})
+
+	var r report.Report
+	err := json.Unmarshal(respBody, &r)
+	if err != nil {
+		log.Fatalf("error parsing response json: %s", err)
+	}
+
+	var w io.Writer = os.Stdout
+	if flagReportOutput != "" {
+		f, err := os.Create(flagReportOutput)
+		if err != nil {
+			log.Fatalf("error creating report file: %s", err)
+		}
+		defer f.Close() // nolint:errcheck,gosec
+		w = f
+	}
+
+	// the table format, which is the default, renders the report as markdown
+	switch flagOutputFormat {
+	case formatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(&r)
+	case formatYAML:
+		var b []byte
+		b, err = toYAML(&r)
+		if err == nil {
+			_, err = w.Write(b)
+		}
+	case "html":
+		err = report.HTML(w, &r)
+	case formatTable, "markdown":
+		err = report.Markdown(w, &r)
+	default:
+		log.Fatalf("unknown report format %q", flagOutputFormat)
+	}
+	if err != nil {
+		log.Fatalf("error writing report: %s", err)
+	}
+}
@@ -17,7 +17,6 @@ package commands
 import (
 	"bytes"
 	"encoding/json"
-	"fmt"
 	"io/ioutil"
 	"net/http"
 	"os"
@@ -35,9 +34,6 @@ var (
 
 	// a JSON filter to use in a database query
 	flagFilter string
-
-	// the desired format for output (csv, json, table)
-	flagOutputFormat string
 )
 
 var (
@@ -68,10 +64,6 @@ func init() {
 	// default: {"valid": true}, returns all valid credentials in the campaign
 	resultsCmd.Flags().StringVarP(&flagFilter, "filter", "f", `{"valid":true}`,
 		"filter on db results (specified in JSON)")
-
-	// default: table (terminal friendly)
-	resultsCmd.Flags().StringVarP(&flagOutputFormat, "output-format", "o", "table",
-		"output format (table, csv, json)")
 	rootCmd.AddCommand(resultsCmd)
 }
 
@@ -129,8 +121,7 @@ func resultsGet(cmd *cobra.Command, args []string) {
 		log.Fatalf("error parsing response json: %s", err)
 	}
 
-	if flagOutputFormat == "json" {
-		fmt.Print(string(respBody))
+	if printData(results) {
 		return
 	}
 
@@ -159,10 +150,5 @@ func resultsGet(cmd *cobra.Command, args []string) {
 		t.AppendRows([]table.Row{row})
 	}
 
-	if flagOutputFormat == "csv" {
-		t.RenderCSV()
-		return
-	}
-
-	t.Render()
+	render(t)
 }
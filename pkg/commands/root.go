@@ -23,20 +23,44 @@ import (
 
 	"github.com/praetorian-inc/trident/pkg/auth"
 	"github.com/praetorian-inc/trident/pkg/auth/cloudflare"
+	"github.com/praetorian-inc/trident/pkg/auth/oidc"
 )
 
 var authenticator auth.Authenticator
 
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
-	Use:   "trident-cli",
+	Use:   "trident-client",
 	Short: "command-line client for the trident password spraying system",
 	Long: `used by an operator to input password spraying tasks into the
 	orchestrator which will be then handed out to the registered dispatch
 	nodes`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		// shell completion and recon do not contact the orchestrator, so
+		// they must work without a config file
+		if cmd == completionCmd || cmd == reconCmd || cmd.Name() == cobra.ShellCompRequestCmd ||
+			cmd.Name() == cobra.ShellCompNoDescRequestCmd {
+			return
+		}
+		initConfig()
+	},
 }
 
 func init() {
+	rootCmd.PersistentFlags().StringVarP(&flagOutputFormat, "output-format", "o", formatTable,
+		"output format (table, json, yaml, or csv for tabular output)")
+	err := rootCmd.RegisterFlagCompletionFunc("output-format",
+		func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			return []string{formatTable, formatJSON, formatYAML, formatCSV}, cobra.ShellCompDirectiveNoFileComp
+		})
+	if err != nil {
+		log.Fatalf("issue during argument parsing: %s", err)
+	}
+}
+
+// initConfig reads the config file and creates the authenticator used by
+// every command contacting the orchestrator.
+func initConfig() {
 	// we want to support config directories in home or etc
 	viper.AddConfigPath("$HOME/.trident")
 	viper.AddConfigPath("/etc/trident")
@@ -64,8 +88,21 @@ func init() {
 
 	// create the global authenticator that will be used to add an auth
 	// token to each command that needs it
-	authenticator = &cloudflare.ArgoAuthenticator{
-		URL: url,
+	switch viper.GetString("auth.provider") {
+	case "", "cloudflare":
+		authenticator = &cloudflare.ArgoAuthenticator{
+			URL: url,
+		}
+	case "oidc":
+		authenticator = &oidc.DeviceAuthenticator{
+			Issuer:       viper.GetString("auth.issuer"),
+			ClientID:     viper.GetString("auth.client-id"),
+			ClientSecret: viper.GetString("auth.client-secret"),
+			Scopes:       viper.GetStringSlice("auth.scopes"),
+			CachePath:    oidc.DefaultCachePath(),
+		}
+	default:
+		log.Fatalf("unknown auth provider %q", viper.GetString("auth.provider"))
 	}
 }
 
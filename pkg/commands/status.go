@@ -0,0 +1,128 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/jedib0t/go-pretty/table"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+)
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "campaign progress reporting subcommand",
+	Long: `can be used to view the progress of campaigns, including completed and
+errored tasks, the current attempt rate, detected lockouts and the projected
+completion time.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		statusGet(cmd, args)
+	},
+}
+
+func init() {
+	statusCmd.Flags().UintVarP(&campaignID, "campaign", "c", 0,
+		"the identifier of the campaign (default: all campaigns)")
+	campaignCmd.AddCommand(statusCmd)
+}
+
+// statusGet will retrieve the progress of one or all campaigns and print it
+// to the CLI
+func statusGet(cmd *cobra.Command, args []string) {
+	orchestrator := viper.GetString("orchestrator-url")
+
+	requestBody, err := json.Marshal(map[string]interface{}{
+		"ID": campaignID,
+	})
+	if err != nil {
+		log.Fatalf("error during JSON marshalling for request body: %s", err)
+	}
+
+	req, err := http.NewRequest("POST", orchestrator+"/campaign/progress", bytes.NewBuffer(requestBody))
+	if err != nil {
+		log.Fatalf("error during request creation: %s", err)
+	}
+
+	err = authenticator.Auth(req)
+	if err != nil {
+		log.Fatalf("error during authentication: %s", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Fatalf("error sending request: %s", err)
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	if resp.StatusCode != 200 {
+		log.Fatalf("error returning results from server: %d", resp.StatusCode)
+	}
+
+	var progress []db.CampaignProgress
+	err = json.NewDecoder(resp.Body).Decode(&progress)
+	if err != nil {
+		log.Fatalf("error parsing response json: %s", err)
+	}
+	if printData(progress) {
+		return
+	}
+
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{
+		"campaign id", "provider", "status", "scheduled", "completed", "errored",
+		"pending", "valid", "locked", "rate (/min)", "projected completion",
+	})
+
+	attempts := make(map[string]int64)
+	for _, p := range progress {
+		attempts[p.Provider] += p.Completed + p.Errored
+
+		eta := "done"
+		if !p.ProjectedCompletion.IsZero() {
+			eta = fmt.Sprintf("%s (in %s)", p.ProjectedCompletion.Format(time.RFC3339),
+				time.Until(p.ProjectedCompletion).Round(time.Minute))
+		}
+		t.AppendRow(table.Row{
+			p.CampaignID, p.Provider, p.Status, p.Scheduled, p.Completed, p.Errored,
+			p.Pending, p.Valid, p.Locked, fmt.Sprintf("%.1f", p.Rate), eta,
+		})
+	}
+	render(t)
+
+	providers := make([]string, 0, len(attempts))
+	for provider := range attempts {
+		providers = append(providers, provider)
+	}
+	sort.Strings(providers)
+
+	pt := table.NewWriter()
+	pt.SetOutputMirror(os.Stdout)
+	pt.AppendHeader(table.Row{"provider", "attempts"})
+	for _, provider := range providers {
+		pt.AppendRow(table.Row{provider, attempts[provider]})
+	}
+	render(pt)
+}
@@ -0,0 +1,244 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/jedib0t/go-pretty/table"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+)
+
+// the error class used to filter failed tasks
+var flagErrorClass string
+
+var tasksCmd = &cobra.Command{
+	Use:   "tasks",
+	Short: "top-level command for inspecting tasks",
+	Long:  `used by an operator to inspect and manage individual tasks`,
+}
+
+var tasksErrorsCmd = &cobra.Command{
+	Use:   "errors",
+	Short: "dead-letter queue subcommand",
+	Long: `can be used to list, inspect and requeue tasks which failed permanently
+or exhausted their retries`,
+}
+
+var tasksErrorsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list failed tasks",
+	Run: func(cmd *cobra.Command, args []string) {
+		tasksErrorsList(cmd, args)
+	},
+}
+
+var tasksErrorsInspectCmd = &cobra.Command{
+	Use:   "inspect <id>",
+	Short: "show the details of a failed task",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		tasksErrorsInspect(cmd, args)
+	},
+}
+
+var tasksErrorsRequeueCmd = &cobra.Command{
+	Use:   "requeue [id...]",
+	Short: "requeue failed tasks",
+	Long: `can be used to requeue failed tasks by ID, or every failed task of a
+campaign with --campaign`,
+	Run: func(cmd *cobra.Command, args []string) {
+		tasksErrorsRequeue(cmd, args)
+	},
+}
+
+func init() {
+	tasksErrorsListCmd.Flags().UintVarP(&campaignID, "campaign", "c", 0,
+		"only list failed tasks of this campaign")
+	tasksErrorsListCmd.Flags().StringVar(&flagErrorClass, "class", "",
+		"only list failed tasks with this error class (e.g. config, not_found)")
+	tasksErrorsRequeueCmd.Flags().UintVarP(&campaignID, "campaign", "c", 0,
+		"requeue every failed task of this campaign")
+
+	tasksErrorsCmd.AddCommand(tasksErrorsListCmd)
+	tasksErrorsCmd.AddCommand(tasksErrorsInspectCmd)
+	tasksErrorsCmd.AddCommand(tasksErrorsRequeueCmd)
+	tasksCmd.AddCommand(tasksErrorsCmd)
+	rootCmd.AddCommand(tasksCmd)
+}
+
+// apiPost sends a request to the orchestrator and returns the response body,
+// exiting on error
+func apiPost(path string, body interface{}) []byte {
+	respBody, err := apiDo("POST", path, body)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return respBody
+}
+
+// apiDo sends an authenticated request to the orchestrator and returns the
+// response body. a nil body sends no request body.
+func apiDo(method, path string, body interface{}) ([]byte, error) {
+	orchestrator := viper.GetString("orchestrator-url")
+
+	var reqBody io.Reader
+	if body != nil {
+		requestBody, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("error during JSON marshalling for request body: %w", err)
+		}
+		reqBody = bytes.NewBuffer(requestBody)
+	}
+
+	req, err := http.NewRequest(method, orchestrator+path, reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("error during request creation: %w", err)
+	}
+
+	err = authenticator.Auth(req)
+	if err != nil {
+		return nil, fmt.Errorf("error during authentication: %w", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending request: %w", err)
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body: %w", err)
+	}
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("error returning results from server: %d %s", resp.StatusCode, bytes.TrimSpace(respBody))
+	}
+	return respBody, nil
+}
+
+// failedTasks retrieves the failed tasks matching the filter
+func failedTasks(filter map[string]interface{}) []db.FailedTask {
+	respBody := apiPost("/tasks/errors", map[string]interface{}{
+		"Filter": filter,
+	})
+
+	var tasks []db.FailedTask
+	err := json.Unmarshal(respBody, &tasks)
+	if err != nil {
+		log.Fatalf("error parsing response json: %s", err)
+	}
+	return tasks
+}
+
+// tasksErrorsList prints the failed tasks to the CLI
+func tasksErrorsList(cmd *cobra.Command, args []string) {
+	filter := map[string]interface{}{}
+	if campaignID != 0 {
+		filter["campaign_id"] = campaignID
+	}
+	if flagErrorClass != "" {
+		filter["error_class"] = flagErrorClass
+	}
+
+	tasks := failedTasks(filter)
+	if printData(tasks) {
+		return
+	}
+
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"id", "campaign id", "username", "provider", "attempts", "class", "error", "failed at"})
+	for _, f := range tasks {
+		t.AppendRow(table.Row{f.ID, f.CampaignID, f.Username, f.Provider, f.Attempt + 1, f.ErrorClass, f.Error, f.CreatedAt})
+	}
+	render(t)
+}
+
+// tasksErrorsInspect prints the details of a single failed task to the CLI
+func tasksErrorsInspect(cmd *cobra.Command, args []string) {
+	id, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		log.Fatalf("invalid task id %q: %s", args[0], err)
+	}
+
+	tasks := failedTasks(map[string]interface{}{"id": id})
+	if len(tasks) == 0 {
+		log.Fatalf("failed task %d not found", id)
+	}
+	f := tasks[0]
+	if printData(f) {
+		return
+	}
+
+	fmt.Printf("-------------------------------------------\n")
+	fmt.Printf("Failed Task #%d:\n", f.ID)
+	fmt.Printf("-------------------------------------------\n")
+	fmt.Printf("Campaign:    %d\n", f.CampaignID)
+	fmt.Printf("Username:    %s\n", f.Task.Username)
+	fmt.Printf("Password:    %s\n", f.Task.Password)
+	fmt.Printf("Provider:    %s\n", f.Task.Provider)
+	fmt.Printf("Metadata:    %s\n", f.Task.ProviderMetadata)
+	fmt.Printf("Not Before:  %s\n", f.Task.NotBefore)
+	fmt.Printf("Not After:   %s\n", f.Task.NotAfter)
+	fmt.Printf("Attempts:    %d (max retries: %d)\n", f.Attempt+1, f.Task.MaxRetries)
+	fmt.Printf("Error Class: %s\n", f.ErrorClass)
+	fmt.Printf("Error:       %s\n", f.Error)
+	fmt.Printf("Failed At:   %s\n", f.CreatedAt)
+}
+
+// tasksErrorsRequeue requeues failed tasks by ID or campaign
+func tasksErrorsRequeue(cmd *cobra.Command, args []string) {
+	ids := make([]uint, 0, len(args))
+	for _, arg := range args {
+		id, err := strconv.ParseUint(arg, 10, 64)
+		if err != nil {
+			log.Fatalf("invalid task id %q: %s", arg, err)
+		}
+		ids = append(ids, uint(id))
+	}
+	if len(ids) == 0 && campaignID == 0 {
+		log.Fatal("either task IDs or --campaign must be provided")
+	}
+
+	respBody := apiPost("/tasks/errors/requeue", map[string]interface{}{
+		"IDs":        ids,
+		"CampaignID": campaignID,
+	})
+
+	var res struct {
+		Requeued int `json:"requeued"`
+		Expired  int `json:"expired"`
+	}
+	err := json.Unmarshal(respBody, &res)
+	if err != nil {
+		log.Fatalf("error parsing response json: %s", err)
+	}
+	log.Infof("requeued %d failed tasks", res.Requeued)
+	if res.Expired > 0 {
+		log.Warnf("%d failed tasks were not requeued, their campaign has ended", res.Expired)
+	}
+}
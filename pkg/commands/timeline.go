@@ -0,0 +1,117 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jedib0t/go-pretty/table"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/praetorian-inc/trident/pkg/detection"
+)
+
+var (
+	// path to a csv export of SIEM alerts to evaluate detection coverage
+	flagAlertsFile string
+)
+
+var timelineCmd = &cobra.Command{
+	Use:   "timeline",
+	Short: "export the timeline of a campaign's attempts",
+	Long: `can be used to export every attempt of a campaign (timestamp, source IP,
+target account and outcome), e.g. to diff a detection campaign against SIEM
+alerts. with --alerts, the detection coverage and latency are reported instead.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		timelineGet(cmd, args)
+	},
+}
+
+func init() {
+	timelineCmd.Flags().UintVarP(&campaignID, "campaign", "c", 0,
+		"the identifier of the campaign")
+	err := timelineCmd.MarkFlagRequired("campaign")
+	if err != nil {
+		log.Fatalf("issue during argument parsing: %s", err)
+	}
+
+	timelineCmd.Flags().StringVar(&flagAlertsFile, "alerts", "",
+		"csv file of SIEM alerts (timestamp and username columns) to measure detection coverage")
+
+	campaignCmd.AddCommand(timelineCmd)
+}
+
+func timelineGet(cmd *cobra.Command, args []string) {
+	respBody := apiPost("/campaign/timeline", map[string]interface{}{
+		"ID": campaignID,
+	})
+
+	var events []detection.Event
+	err := json.Unmarshal(respBody, &events)
+	if err != nil {
+		log.Fatalf("error parsing response json: %s", err)
+	}
+
+	if flagAlertsFile != "" {
+		printCoverage(events)
+		return
+	}
+
+	if printData(events) {
+		return
+	}
+
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"timestamp", "ip", "region", "username", "provider", "outcome"})
+	for _, e := range events {
+		t.AppendRow(table.Row{e.Timestamp.Format(time.RFC3339Nano), e.IP, e.Region, e.Username,
+			e.Provider, e.Outcome})
+	}
+
+	render(t)
+}
+
+// printCoverage evaluates the alerts against the timeline
+func printCoverage(events []detection.Event) {
+	f, err := os.Open(flagAlertsFile)
+	if err != nil {
+		log.Fatalf("error opening alerts file: %s", err)
+	}
+	defer f.Close() // nolint:errcheck,gosec
+
+	alerts, err := detection.ReadAlerts(f)
+	if err != nil {
+		log.Fatalf("error reading alerts: %s", err)
+	}
+
+	c := detection.Evaluate(events, alerts)
+	if printData(&c) {
+		return
+	}
+
+	fmt.Printf("Accounts:     %d\n", c.Accounts)
+	fmt.Printf("Detected:     %d\n", c.Detected)
+	fmt.Printf("Coverage:     %.1f%%\n", c.Coverage*100)
+	fmt.Printf("Mean Latency: %s\n", c.MeanLatency.Round(time.Second))
+	fmt.Printf("Max Latency:  %s\n", c.MaxLatency.Round(time.Second))
+	for _, u := range c.Undetected {
+		fmt.Printf("Undetected:   %s\n", u)
+	}
+}
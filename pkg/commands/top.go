@@ -0,0 +1,189 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/jedib0t/go-pretty/table"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+	"github.com/praetorian-inc/trident/pkg/report"
+)
+
+var (
+	// how often the dashboard is refreshed
+	flagTopInterval time.Duration
+
+	// the number of recent valid credentials shown
+	flagTopHits int
+)
+
+var topCmd = &cobra.Command{
+	Use:   "top",
+	Short: "live dashboard of running campaigns",
+	Long: `shows the running campaigns with their attempt rates and lockouts, the most
+recent valid credentials and the health of the workers, refreshing in place
+until interrupted.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		topRun(cmd, args)
+	},
+}
+
+func init() {
+	topCmd.Flags().DurationVarP(&flagTopInterval, "interval", "n", 5*time.Second,
+		"how often the dashboard is refreshed")
+	topCmd.Flags().IntVar(&flagTopHits, "hits", 10,
+		"the number of recent valid credentials shown")
+	rootCmd.AddCommand(topCmd)
+}
+
+// dashboard is the state shown by the top command. data from the last
+// successful refresh is kept when the orchestrator cannot be reached.
+type dashboard struct {
+	progress []db.CampaignProgress
+	hits     []db.Result
+	workers  []report.WorkerHealth
+	updated  time.Time
+	err      error
+}
+
+// refresh fetches the state of the running campaigns from the orchestrator.
+func (d *dashboard) refresh() {
+	var progress []db.CampaignProgress
+	var hits []db.Result
+	var workers []report.WorkerHealth
+
+	d.err = fetchJSON("POST", "/campaign/progress", map[string]interface{}{"ID": 0}, &progress)
+	if d.err == nil {
+		d.err = fetchJSON("POST", "/results", map[string]interface{}{
+			"Filter":         map[string]interface{}{"valid": true},
+			"ReturnedFields": []string{"campaign_id", "timestamp", "username", "mfa", "ip"},
+		}, &hits)
+	}
+	if d.err == nil {
+		d.err = fetchJSON("GET", "/workers", nil, &workers)
+	}
+	if d.err != nil {
+		return
+	}
+
+	d.progress = d.progress[:0]
+	for _, p := range progress {
+		if p.Pending > 0 && p.Status != db.CampaignStatusCancelled {
+			d.progress = append(d.progress, p)
+		}
+	}
+	if len(hits) > flagTopHits {
+		hits = hits[:flagTopHits]
+	}
+	d.hits = hits
+	d.workers = workers
+	d.updated = time.Now()
+}
+
+// fetchJSON sends a request to the orchestrator and decodes its response.
+func fetchJSON(method, path string, body, v interface{}) error {
+	respBody, err := apiDo(method, path, body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(respBody, v)
+}
+
+// render draws the dashboard.
+func (d *dashboard) render(w io.Writer) {
+	fmt.Fprintf(w, "trident top - updated %s, refreshing every %s\n", // nolint:errcheck
+		d.updated.Format(time.RFC3339), flagTopInterval)
+	if d.err != nil {
+		fmt.Fprintf(w, "error refreshing: %s\n", d.err) // nolint:errcheck
+	}
+
+	var rate float64
+	var locked int64
+	ct := table.NewWriter()
+	ct.SetOutputMirror(w)
+	ct.SetTitle("running campaigns")
+	ct.AppendHeader(table.Row{"campaign id", "provider", "status", "progress", "rate (/min)",
+		"valid", "locked", "rate limited", "errored", "eta"})
+	for _, p := range d.progress {
+		rate += p.Rate
+		locked += p.Locked
+
+		done := p.Completed + p.Errored
+		percent := 0.0
+		if p.Scheduled > 0 {
+			percent = 100 * float64(done) / float64(p.Scheduled)
+		}
+		ct.AppendRow(table.Row{
+			p.CampaignID, p.Provider, p.Status,
+			fmt.Sprintf("%d/%d (%.0f%%)", done, p.Scheduled, percent),
+			fmt.Sprintf("%.1f", p.Rate), p.Valid, p.Locked, p.RateLimited, p.Errored,
+			time.Until(p.ProjectedCompletion).Round(time.Minute),
+		})
+	}
+	ct.AppendFooter(table.Row{"", "", "", "total", fmt.Sprintf("%.1f", rate), "", locked})
+	ct.Render()
+
+	ht := table.NewWriter()
+	ht.SetOutputMirror(w)
+	ht.SetTitle("recent valid credentials")
+	ht.AppendHeader(table.Row{"campaign id", "timestamp", "username", "mfa", "ip"})
+	for _, h := range d.hits {
+		ht.AppendRow(table.Row{h.CampaignID, h.Timestamp.Format(time.RFC3339), h.Username, h.MFA, h.IP})
+	}
+	ht.Render()
+
+	wt := table.NewWriter()
+	wt.SetOutputMirror(w)
+	wt.SetTitle("workers")
+	wt.AppendHeader(table.Row{"ip", "region", "status", "attempts", "errors", "rate limited", "last seen"})
+	for _, wk := range d.workers {
+		wt.AppendRow(table.Row{wk.IP, wk.Region, wk.Status, wk.Attempts, wk.Errors, wk.RateLimited,
+			fmt.Sprintf("%s ago", time.Since(wk.LastSeen).Round(time.Second))})
+	}
+	wt.Render()
+}
+
+// topRun refreshes and redraws the dashboard until interrupted.
+func topRun(cmd *cobra.Command, args []string) {
+	if flagTopInterval <= 0 {
+		log.Fatal("--interval must be positive")
+	}
+
+	var d dashboard
+	ticker := time.NewTicker(flagTopInterval)
+	defer ticker.Stop()
+
+	for {
+		d.refresh()
+
+		// draw off-screen first so that the screen is cleared and redrawn at
+		// once, avoiding flicker
+		var buf bytes.Buffer
+		d.render(&buf)
+		fmt.Print("\033[H\033[2J")
+		os.Stdout.Write(buf.Bytes()) // nolint:errcheck,gosec
+
+		<-ticker.C
+	}
+}
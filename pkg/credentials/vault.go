@@ -0,0 +1,226 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package credentials implements a vault of valid credentials discovered by
+// campaigns. Credentials are deduplicated across campaigns, their passwords
+// are encrypted with a kms.KeyManager and they can be periodically revalidated
+// to detect password changes.
+package credentials
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+	"github.com/praetorian-inc/trident/pkg/kms"
+)
+
+// Revalidator is the interface that wraps scheduling revalidation tasks for
+// stored credentials. the Password of each credential is populated.
+type Revalidator interface {
+	Revalidate([]db.Credential) error
+}
+
+// Vault stores and retrieves credentials.
+type Vault struct {
+	// DB is the datastore used to persist credentials
+	DB db.Datastore
+
+	// Keys encrypts passwords at rest
+	Keys kms.KeyManager
+
+	// Envelope, if set, decrypts sealed result passwords before they are
+	// stored
+	Envelope *kms.Envelope
+
+	mu        sync.Mutex
+	campaigns map[uint]db.Campaign
+}
+
+// campaign returns the (cached) campaign with the provided ID.
+func (v *Vault) campaign(id uint) (db.Campaign, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if c, ok := v.campaigns[id]; ok {
+		return c, nil
+	}
+
+	c, err := v.DB.DescribeCampaign(db.Query{
+		Filter: map[string]interface{}{"id": id},
+	})
+	if err != nil {
+		return c, err
+	}
+
+	if v.campaigns == nil {
+		v.campaigns = make(map[uint]db.Campaign)
+	}
+	v.campaigns[id] = c
+	return c, nil
+}
+
+// Record stores a result in the vault. results from campaigns are upserted if
+// valid, while results of revalidation tasks update the validation status of
+// the stored credential if they are definitive.
+func (v *Vault) Record(ctx context.Context, res *db.Result) error {
+	if res.Error != "" {
+		return nil
+	}
+	if res.CredentialID != 0 {
+		if !definitive(res) {
+			return nil
+		}
+		return v.DB.UpdateCredentialValidation(res.CredentialID, res.Valid, res.Timestamp)
+	}
+	if !res.Valid {
+		return nil
+	}
+
+	c, err := v.campaign(res.CampaignID)
+	if err != nil {
+		return fmt.Errorf("error looking up campaign %d: %w", res.CampaignID, err)
+	}
+
+	password := res.Password
+	if v.Envelope != nil {
+		password, err = v.Envelope.Unseal(ctx, password)
+		if err != nil {
+			return fmt.Errorf("error decrypting result password: %w", err)
+		}
+	}
+
+	encrypted, err := v.Keys.Encrypt(ctx, []byte(password))
+	if err != nil {
+		return fmt.Errorf("error encrypting password: %w", err)
+	}
+
+	return v.DB.UpsertCredential(&db.Credential{
+		Username:          res.Username,
+		Provider:          c.Provider,
+		Tenant:            Tenant(c.ProviderMetadata),
+		Team:              c.Team,
+		ProviderMetadata:  c.ProviderMetadata,
+		EncryptedPassword: encrypted,
+		Valid:             true,
+		MFA:               res.MFA,
+		LastValidated:     res.Timestamp,
+		LastCampaignID:    res.CampaignID,
+	})
+}
+
+// transportOptions are the nozzle options which change how logins are sent
+// rather than which tenant they are sent to.
+var transportOptions = []string{
+	"source_ip", "interface", "ip_version", "timeout",
+	"capture_response", "capture_session", "enumerate_factors",
+}
+
+// Tenant returns the identifier of the provider tenant configured by the
+// provider metadata of a campaign: a digest of its options (such as the Okta
+// subdomain or the ADFS domain), ignoring the options in transportOptions.
+// the same username in two tenants is stored as two credentials. it is empty
+// if the metadata has no options.
+func Tenant(metadata json.RawMessage) string {
+	if len(metadata) == 0 {
+		return ""
+	}
+	var opts map[string]interface{}
+	err := json.Unmarshal(metadata, &opts)
+	if err != nil {
+		return digest(metadata)
+	}
+
+	for _, opt := range transportOptions {
+		delete(opts, opt)
+	}
+	if len(opts) == 0 {
+		return ""
+	}
+	// maps are marshalled with sorted keys
+	canonical, err := json.Marshal(opts)
+	if err != nil {
+		return digest(metadata)
+	}
+	return digest(canonical)
+}
+
+// digest returns the hex encoded SHA-256 digest of data.
+func digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// definitive returns true if a result tells whether the password is valid. a
+// locked, rate limited or challenged login is not proof the password changed.
+func definitive(res *db.Result) bool {
+	return res.Valid || !(res.Locked || res.RateLimited || res.Captcha)
+}
+
+// Reveal decrypts the password of each provided credential.
+func (v *Vault) Reveal(ctx context.Context, creds []db.Credential) error {
+	for i := range creds {
+		password, err := v.Keys.Decrypt(ctx, creds[i].EncryptedPassword)
+		if err != nil {
+			return fmt.Errorf("error decrypting credential %d: %w", creds[i].ID, err)
+		}
+		creds[i].Password = string(password)
+	}
+	return nil
+}
+
+// Revalidate periodically schedules revalidation of every valid credential
+// which has not been validated within the provided interval. This function
+// blocks until the context is cancelled.
+func (v *Vault) Revalidate(ctx context.Context, r Revalidator, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		creds, err := v.DB.SelectStaleCredentials(time.Now().Add(-interval))
+		if err != nil {
+			log.Errorf("error selecting stale credentials: %s", err)
+			continue
+		}
+		if len(creds) == 0 {
+			continue
+		}
+
+		err = v.Reveal(ctx, creds)
+		if err != nil {
+			log.Errorf("error decrypting stale credentials: %s", err)
+			continue
+		}
+
+		err = r.Revalidate(creds)
+		if err != nil {
+			log.Errorf("error scheduling credential revalidation: %s", err)
+			continue
+		}
+		log.Infof("scheduled revalidation of %d credentials", len(creds))
+	}
+}
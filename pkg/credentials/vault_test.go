@@ -0,0 +1,85 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package credentials
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+)
+
+// fakeDB records credential validation updates. other Datastore methods are
+// not used by revalidation results.
+type fakeDB struct {
+	db.Datastore
+	updates map[uint]bool
+}
+
+func (f *fakeDB) UpdateCredentialValidation(id uint, valid bool, ts time.Time) error {
+	f.updates[id] = valid
+	return nil
+}
+
+func TestRecordRevalidation(t *testing.T) {
+	var tests = []struct {
+		desc    string
+		res     db.Result
+		updated bool
+		valid   bool
+	}{
+		{"valid", db.Result{Valid: true}, true, true},
+		{"invalid", db.Result{}, true, false},
+		{"locked", db.Result{Locked: true}, false, false},
+		{"rate limited", db.Result{RateLimited: true}, false, false},
+		{"challenged", db.Result{Captcha: true}, false, false},
+		{"error", db.Result{Error: "timeout"}, false, false},
+	}
+
+	for _, test := range tests {
+		fdb := &fakeDB{updates: make(map[uint]bool)}
+		v := &Vault{DB: fdb}
+
+		res := test.res
+		res.CredentialID = 1
+		err := v.Record(context.Background(), &res)
+		if err != nil {
+			t.Fatalf("[%s] unexpected error: %s", test.desc, err)
+		}
+		valid, ok := fdb.updates[1]
+		if ok != test.updated || valid != test.valid {
+			t.Errorf("[%s] got update %v (valid %v), expected %v (valid %v)", test.desc, ok, valid,
+				test.updated, test.valid)
+		}
+	}
+}
+
+func TestTenant(t *testing.T) {
+	okta := Tenant(json.RawMessage(`{"subdomain": "acme", "timeout": "5s"}`))
+	if okta == "" {
+		t.Fatal("expected a tenant")
+	}
+	if tenant := Tenant(json.RawMessage(`{"source_ip": "192.0.2.1", "subdomain":"acme"}`)); tenant != okta {
+		t.Errorf("expected transport options to be ignored, got %s and %s", tenant, okta)
+	}
+	if tenant := Tenant(json.RawMessage(`{"subdomain": "globex"}`)); tenant == okta {
+		t.Error("expected another subdomain to be another tenant")
+	}
+	if tenant := Tenant(nil); tenant != "" {
+		t.Errorf("expected no tenant without metadata, got %s", tenant)
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// fakeInserter fails the inserts of results whose username is in bad with a
+// constraint violation, and every insert while down is set.
+type fakeInserter struct {
+	bad      map[string]bool
+	down     bool
+	inserted []string
+}
+
+func (f *fakeInserter) insert(results []*Result) error {
+	if f.down {
+		return errors.New("driver: bad connection")
+	}
+	for _, r := range results {
+		if f.bad[r.Username] {
+			return &pq.Error{Code: "23505", Message: "duplicate key value"}
+		}
+	}
+	for _, r := range results {
+		f.inserted = append(f.inserted, r.Username)
+	}
+	return nil
+}
+
+func batchOf(usernames ...string) []*Result {
+	batch := make([]*Result, len(usernames))
+	for i, u := range usernames {
+		batch[i] = &Result{Username: u}
+	}
+	return batch
+}
+
+func TestFlush(t *testing.T) {
+	f := &fakeInserter{bad: map[string]bool{"bob": true}}
+
+	left, err := flush(f.insert, batchOf("alice", "bob", "carol"))
+	if err != nil || len(left) != 0 {
+		t.Fatalf("expected the batch to be flushed, got %d left (%v)", len(left), err)
+	}
+	if len(f.inserted) != 2 || f.inserted[0] != "alice" || f.inserted[1] != "carol" {
+		t.Errorf("expected only the invalid result to be dropped, inserted %v", f.inserted)
+	}
+
+	f.down = true
+	left, err = flush(f.insert, batchOf("dave", "erin"))
+	if err == nil || len(left) != 2 {
+		t.Errorf("expected the batch to be kept after a connection error, got %d left (%v)", len(left), err)
+	}
+
+	f.down = false
+	left, err = flush(f.insert, left)
+	if err != nil || len(left) != 0 || len(f.inserted) != 4 {
+		t.Errorf("expected the kept batch to be flushed, got %d left (%v)", len(left), err)
+	}
+}
+
+func TestFlushBackoff(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, FlushBackoff},
+		{2, 2 * FlushBackoff},
+		{4, 8 * FlushBackoff},
+		{100, MaxFlushBackoff},
+	}
+	for _, test := range tests {
+		if got := flushBackoff(test.failures); got != test.want {
+			t.Errorf("flushBackoff(%d) = %s, want %s", test.failures, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+)
+
+// resultColumns are the columns written by InsertResults (see
+// Result.values).
+var resultColumns = []string{
+	"campaign_id", "ip", "region", "timestamp", "username", "password",
+	"valid", "locked", "mfa", "rate_limited", "captcha", "policy_blocked", "metadata", "session", "response",
+	"findings", "error", "error_class",
+}
+
+// dialect holds what differs between the supported database drivers. gorm
+// generates the remaining queries.
+type dialect struct {
+	// dsn converts a connection URL into the driver's connection string
+	dsn func(u *url.URL, password string) (string, error)
+
+	// bindVar returns the placeholder of the nth (1-based) parameter of a
+	// statement
+	bindVar func(n int) string
+
+	// claimTask and completeClaim insert claims. they affect no rows if
+	// the claim exists (or is already complete), except claimTask takes over
+	// incomplete claims made before its last argument.
+	claimTask     string
+	completeClaim string
+
+	// renewClaims refreshes the time of incomplete claims
+	renewClaims string
+
+	// releaseClaim deletes a claim unless it is complete
+	releaseClaim string
+
+	// upsertAccount records the observations of an account, keeping the
+	// previous time of the outcomes which were not observed
+	upsertAccount string
+
+	// insertRedaction records a redaction token. it affects no rows if the
+	// token exists.
+	insertRedaction string
+
+	// createSchemaMigrations creates the migration history table, and
+	// lockMigrations and unlockMigrations serialize migrations
+	createSchemaMigrations string
+	lockMigrations         string
+	unlockMigrations       string
+}
+
+var dialects = map[string]dialect{
+	"postgres": {
+		dsn:     postgresDSN,
+		bindVar: func(n int) string { return fmt.Sprintf("$%d", n) },
+		claimTask: "INSERT INTO claims (key, campaign_id, claimed_at) VALUES (?, ?, ?) " +
+			"ON CONFLICT (key) DO UPDATE SET claimed_at = excluded.claimed_at " +
+			"WHERE claims.completed_at IS NULL AND claims.claimed_at < ?",
+		completeClaim: "INSERT INTO claims (key, campaign_id, claimed_at, completed_at) VALUES (?, ?, ?, ?) " +
+			"ON CONFLICT (key) DO UPDATE SET completed_at = excluded.completed_at " +
+			"WHERE claims.completed_at IS NULL",
+		renewClaims:  "UPDATE claims SET claimed_at = ? WHERE key IN (?) AND completed_at IS NULL",
+		releaseClaim: "DELETE FROM claims WHERE key = ? AND completed_at IS NULL",
+		upsertAccount: "INSERT INTO accounts (created_at, updated_at, domain, username, " +
+			"last_locked, last_mfa, last_valid, last_campaign_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?) " +
+			"ON CONFLICT (domain, username) DO UPDATE SET updated_at = excluded.updated_at, " +
+			"last_locked = COALESCE(excluded.last_locked, accounts.last_locked), " +
+			"last_mfa = COALESCE(excluded.last_mfa, accounts.last_mfa), " +
+			"last_valid = COALESCE(excluded.last_valid, accounts.last_valid), " +
+			"last_campaign_id = excluded.last_campaign_id",
+		insertRedaction: "INSERT INTO redactions (token, campaign_id, kind, value, created_at) " +
+			"VALUES (?, ?, ?, ?, ?) ON CONFLICT (token) DO NOTHING",
+		createSchemaMigrations: "CREATE TABLE IF NOT EXISTS schema_migrations " +
+			"(version bigint PRIMARY KEY, name text, applied_at timestamp with time zone)",
+		lockMigrations: fmt.Sprintf("SELECT pg_advisory_xact_lock(%d)", MigrationLock),
+	},
+	"mysql": {
+		dsn:       mysqlDSN,
+		bindVar:   func(int) string { return "?" },
+		claimTask: "INSERT INTO claims (`key`, campaign_id, claimed_at) VALUES (?, ?, ?) " +
+			"ON DUPLICATE KEY UPDATE claimed_at = " +
+			"IF(completed_at IS NULL AND claimed_at < ?, VALUES(claimed_at), claimed_at)",
+		completeClaim: "INSERT INTO claims (`key`, campaign_id, claimed_at, completed_at) VALUES (?, ?, ?, ?) " +
+			"ON DUPLICATE KEY UPDATE completed_at = IFNULL(completed_at, VALUES(completed_at))",
+		renewClaims:  "UPDATE claims SET claimed_at = ? WHERE `key` IN (?) AND completed_at IS NULL",
+		releaseClaim: "DELETE FROM claims WHERE `key` = ? AND completed_at IS NULL",
+		upsertAccount: "INSERT INTO accounts (created_at, updated_at, domain, username, " +
+			"last_locked, last_mfa, last_valid, last_campaign_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?) " +
+			"ON DUPLICATE KEY UPDATE updated_at = VALUES(updated_at), " +
+			"last_locked = IFNULL(VALUES(last_locked), last_locked), " +
+			"last_mfa = IFNULL(VALUES(last_mfa), last_mfa), " +
+			"last_valid = IFNULL(VALUES(last_valid), last_valid), " +
+			"last_campaign_id = VALUES(last_campaign_id)",
+		insertRedaction: "INSERT IGNORE INTO redactions (token, campaign_id, kind, value, created_at) " +
+			"VALUES (?, ?, ?, ?, ?)",
+		createSchemaMigrations: "CREATE TABLE IF NOT EXISTS schema_migrations " +
+			"(version bigint PRIMARY KEY, name text, applied_at datetime(6) NULL)",
+		lockMigrations:   fmt.Sprintf("DO GET_LOCK('trident_migrations_%d', -1)", MigrationLock),
+		unlockMigrations: fmt.Sprintf("DO RELEASE_LOCK('trident_migrations_%d')", MigrationLock),
+	},
+}
+
+// mysqlDataErrors are the MySQL error numbers caused by the data of a
+// statement: duplicate keys, NULL, out of range, truncated or invalid values,
+// foreign keys and invalid JSON.
+var mysqlDataErrors = map[uint16]bool{
+	1048: true, 1062: true, 1264: true, 1265: true, 1292: true, 1366: true,
+	1406: true, 1451: true, 1452: true, 3140: true,
+}
+
+// isDataError returns true if the error is caused by the data of a statement
+// (a data exception or an integrity constraint violation), which would fail
+// again however often it is retried, rather than by the connection or the
+// state of the database.
+func isDataError(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		class := pqErr.Code.Class()
+		return class == "22" || class == "23"
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlDataErrors[mysqlErr.Number]
+	}
+	return false
+}
+
+// insertResults returns a multi-row INSERT statement of n results.
+func (d dialect) insertResults(n int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "INSERT INTO results (%s) VALUES ", strings.Join(resultColumns, ", "))
+	param := 1
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString("(")
+		for j := range resultColumns {
+			if j > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(d.bindVar(param))
+			param++
+		}
+		b.WriteString(")")
+	}
+	return b.String()
+}
+
+// postgresDSN builds a lib/pq connection string. the query parameters of the
+// URL are passed as is (e.g. sslmode=disable).
+func postgresDSN(u *url.URL, password string) (string, error) {
+	dsn := fmt.Sprintf("host=%s user=%s dbname=%s password=%s",
+		u.Host, u.User.Username(), strings.Trim(u.Path, "/"), password)
+	for k, v := range u.Query() {
+		dsn += fmt.Sprintf(" %s=%s", k, v[0])
+	}
+	return dsn, nil
+}
+
+// mysqlDSN builds a go-sql-driver/mysql connection string. the query
+// parameters of the URL are parsed as driver parameters (e.g. tls=true).
+// times are always parsed, and migrations require multiple statements.
+func mysqlDSN(u *url.URL, password string) (string, error) {
+	cfg, err := mysql.ParseDSN("/?" + u.RawQuery)
+	if err != nil {
+		return "", err
+	}
+	cfg.User = u.User.Username()
+	cfg.Passwd = password
+	cfg.Net = "tcp"
+	cfg.Addr = u.Host
+	cfg.DBName = strings.Trim(u.Path, "/")
+	cfg.ParseTime = true
+	cfg.MultiStatements = true
+	return cfg.FormatDSN(), nil
+}
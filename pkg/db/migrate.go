@@ -0,0 +1,294 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// migrationFS holds the versioned schema migrations of each driver, which
+// share the same versions. files are named <version>_<name>.up.sql and
+// <version>_<name>.down.sql.
+//
+//go:embed migrations/*/*.sql
+var migrationFS embed.FS
+
+// MigrationLock is the key of the lock held while migrating, so that
+// orchestrators starting at the same time do not migrate concurrently.
+const MigrationLock = 7262347
+
+// Migration is a versioned change to the database schema.
+type Migration struct {
+	// Version orders the migrations, starting at 1
+	Version uint
+
+	// Name describes the migration
+	Name string
+
+	// Up applies the migration
+	Up string
+
+	// Down reverts the migration. migrations without a down script cannot
+	// be rolled back.
+	Down string
+}
+
+// SchemaMigration records a migration applied to the database.
+type SchemaMigration struct {
+	Version   uint      `json:"version" gorm:"primary_key;auto_increment:false"`
+	Name      string    `json:"name"`
+	AppliedAt time.Time `json:"applied_at"`
+}
+
+// Migrations returns the schema migrations of the database's driver built
+// into the binary, ordered by version.
+func (t *TridentDB) Migrations() ([]Migration, error) {
+	return parseMigrations(migrationFS, path.Join("migrations", t.driver))
+}
+
+// parseMigrations reads the migration scripts of a directory.
+func parseMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[uint]*Migration)
+	for _, e := range entries {
+		file := e.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(file, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(file, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+
+		base := strings.TrimSuffix(file, "."+direction+".sql")
+		i := strings.Index(base, "_")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid migration file name %s", file)
+		}
+		version, err := strconv.ParseUint(base[:i], 10, 32)
+		if err != nil || version == 0 {
+			return nil, fmt.Errorf("invalid migration version in %s", file)
+		}
+		data, err := fs.ReadFile(fsys, path.Join(dir, file))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[uint(version)]
+		if !ok {
+			m = &Migration{Version: uint(version), Name: base[i+1:]}
+			byVersion[m.Version] = m
+		} else if m.Name != base[i+1:] {
+			return nil, fmt.Errorf("migration %d has conflicting names %s and %s", version, m.Name, base[i+1:])
+		}
+		if direction == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d has no up script", m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// AppliedMigrations returns the migrations applied to the database, ordered
+// by version.
+func (t *TridentDB) AppliedMigrations() ([]SchemaMigration, error) {
+	var applied []SchemaMigration
+	if !t.db.HasTable("schema_migrations") {
+		return applied, nil
+	}
+	err := t.db.Order("version").Find(&applied).Error
+	return applied, err
+}
+
+// Migrate applies the pending migrations in order and returns them. all
+// migrations are applied within a single transaction, so a failing migration
+// leaves the schema unchanged (except on mysql, which commits schema changes
+// implicitly). if dryRun is set, the pending migrations are only returned.
+func (t *TridentDB) Migrate(dryRun bool) ([]Migration, error) {
+	return t.migrate(dryRun, true, func(migrations []Migration, applied map[uint]bool) ([]Migration, error) {
+		var pending []Migration
+		for _, m := range migrations {
+			if !applied[m.Version] {
+				pending = append(pending, m)
+			}
+		}
+		return pending, nil
+	})
+}
+
+// Rollback reverts the last steps applied migrations, most recent first, and
+// returns them. if dryRun is set, the migrations are only returned.
+func (t *TridentDB) Rollback(steps int, dryRun bool) ([]Migration, error) {
+	return t.migrate(dryRun, false, func(migrations []Migration, applied map[uint]bool) ([]Migration, error) {
+		known := make(map[uint]Migration)
+		for _, m := range migrations {
+			known[m.Version] = m
+		}
+
+		var versions []uint
+		for v := range applied {
+			versions = append(versions, v)
+		}
+		sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+		if steps < len(versions) {
+			versions = versions[:steps]
+		}
+
+		var plan []Migration
+		for _, v := range versions {
+			m, ok := known[v]
+			if !ok {
+				return nil, fmt.Errorf("migration %d is unknown to this version of trident", v)
+			}
+			if m.Down == "" {
+				return nil, fmt.Errorf("migration %d cannot be rolled back", v)
+			}
+			plan = append(plan, m)
+		}
+		return plan, nil
+	})
+}
+
+// migrate plans and runs migrations within a transaction holding the
+// migration lock.
+func (t *TridentDB) migrate(dryRun, up bool,
+	plan func([]Migration, map[uint]bool) ([]Migration, error)) ([]Migration, error) {
+	migrations, err := t.Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	unlock, err := t.lockSession()
+	if err != nil {
+		return nil, fmt.Errorf("error acquiring migration lock: %w", err)
+	}
+	defer unlock()
+
+	tx := t.db.Begin()
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	defer tx.Rollback() // nolint:errcheck
+
+	if t.dialect.unlockMigrations == "" {
+		// the lock is released when the transaction ends
+		err = tx.Exec(t.dialect.lockMigrations).Error
+		if err != nil {
+			return nil, fmt.Errorf("error acquiring migration lock: %w", err)
+		}
+	}
+
+	planned, err := t.runMigrations(tx, migrations, dryRun, up, plan)
+	if err != nil || dryRun || len(planned) == 0 {
+		return planned, err
+	}
+	return planned, tx.Commit().Error
+}
+
+// lockSession acquires the migration lock of dialects whose lock is held by
+// a session rather than a transaction, on a connection of its own. the
+// returned function releases it, once the migrations are committed: releasing
+// it earlier would let another migration run before the history is committed.
+func (t *TridentDB) lockSession() (func(), error) {
+	if t.dialect.unlockMigrations == "" {
+		return func() {}, nil
+	}
+
+	ctx := context.Background()
+	conn, err := t.db.DB().Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	_, err = conn.ExecContext(ctx, t.dialect.lockMigrations)
+	if err != nil {
+		conn.Close() // nolint:errcheck,gosec
+		return nil, err
+	}
+	return func() {
+		conn.ExecContext(ctx, t.dialect.unlockMigrations) // nolint:errcheck,gosec
+		conn.Close()                                      // nolint:errcheck,gosec
+	}, nil
+}
+
+// runMigrations plans migrations from the migration history and runs them
+// unless dryRun is set.
+func (t *TridentDB) runMigrations(tx *gorm.DB, migrations []Migration, dryRun, up bool,
+	plan func([]Migration, map[uint]bool) ([]Migration, error)) ([]Migration, error) {
+	err := tx.Exec(t.dialect.createSchemaMigrations).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []SchemaMigration
+	err = tx.Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	applied := make(map[uint]bool)
+	for _, r := range rows {
+		applied[r.Version] = true
+	}
+
+	planned, err := plan(migrations, applied)
+	if err != nil || dryRun {
+		return planned, err
+	}
+
+	for _, m := range planned {
+		if up {
+			err = tx.Exec(m.Up).Error
+			if err == nil {
+				err = tx.Create(&SchemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}).Error
+			}
+		} else {
+			err = tx.Exec(m.Down).Error
+			if err == nil {
+				err = tx.Delete(&SchemaMigration{Version: m.Version}).Error
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error in migration %d (%s): %w", m.Version, m.Name, err)
+		}
+	}
+	return planned, nil
+}
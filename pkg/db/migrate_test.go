@@ -0,0 +1,277 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/jinzhu/gorm"
+)
+
+func TestMigrations(t *testing.T) {
+	var reference []Migration
+	for driver := range dialects {
+		db := &TridentDB{driver: driver}
+		migrations, err := db.Migrations()
+		if err != nil {
+			t.Fatalf("[%s] %s", driver, err)
+		}
+		if len(migrations) == 0 {
+			t.Errorf("[%s] expected migrations", driver)
+		}
+		for i, m := range migrations {
+			if m.Version != uint(i+1) {
+				t.Errorf("[%s] expected migration %d to have version %d, got %d", driver, i, i+1, m.Version)
+			}
+			if m.Down == "" {
+				t.Errorf("[%s] expected migration %d to have a down script", driver, m.Version)
+			}
+		}
+
+		// every driver shares the same migration set
+		if reference == nil {
+			reference = migrations
+			continue
+		}
+		if len(migrations) != len(reference) {
+			t.Errorf("[%s] got %d migrations, other drivers have %d", driver, len(migrations), len(reference))
+			continue
+		}
+		for i := range migrations {
+			if migrations[i].Name != reference[i].Name {
+				t.Errorf("[%s] migration %d is named %s, other drivers name it %s",
+					driver, migrations[i].Version, migrations[i].Name, reference[i].Name)
+			}
+		}
+	}
+}
+
+func TestParseMigrations(t *testing.T) {
+	var tests = []struct {
+		name    string
+		files   fstest.MapFS
+		want    []Migration
+		wantErr bool
+	}{
+		{
+			name: "ordered",
+			files: fstest.MapFS{
+				"m/0002_second.up.sql":  {Data: []byte("up2")},
+				"m/0001_first.up.sql":   {Data: []byte("up1")},
+				"m/0001_first.down.sql": {Data: []byte("down1")},
+				"m/README.md":           {Data: []byte("ignored")},
+			},
+			want: []Migration{
+				{Version: 1, Name: "first", Up: "up1", Down: "down1"},
+				{Version: 2, Name: "second", Up: "up2"},
+			},
+		},
+		{
+			name:    "missing up script",
+			files:   fstest.MapFS{"m/0001_first.down.sql": {Data: []byte("down1")}},
+			wantErr: true,
+		},
+		{
+			name: "conflicting names",
+			files: fstest.MapFS{
+				"m/0001_first.up.sql": {Data: []byte("up1")},
+				"m/0001_other.up.sql": {Data: []byte("up1")},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "invalid version",
+			files:   fstest.MapFS{"m/first.up.sql": {Data: []byte("up1")}},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		got, err := parseMigrations(test.files, "m")
+		if (err != nil) != test.wantErr {
+			t.Errorf("[%s] unexpected error: %v", test.name, err)
+			continue
+		}
+		if len(got) != len(test.want) {
+			t.Errorf("[%s] got %d migrations, want %d", test.name, len(got), len(test.want))
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("[%s] got %+v, want %+v", test.name, got[i], test.want[i])
+			}
+		}
+	}
+}
+
+// schema tracks the columns of each table while migration scripts are
+// applied to it, so that migrations can be checked without a database.
+type schema map[string]map[string]bool
+
+var (
+	createTablePattern = regexp.MustCompile(`(?is)^CREATE TABLE (IF NOT EXISTS )?(\w+) \((.*)\)$`)
+	alterTablePattern  = regexp.MustCompile(`(?is)^ALTER TABLE (\w+)\s+(.*)$`)
+	dropTablePattern   = regexp.MustCompile(`(?i)^DROP TABLE (IF EXISTS )?(\w+)$`)
+	addColumnPattern   = regexp.MustCompile("(?i)^ADD COLUMN (IF NOT EXISTS )?`?(\\w+)`?")
+	dropColumnPattern  = regexp.MustCompile("(?i)^DROP COLUMN (IF EXISTS )?`?(\\w+)`?")
+)
+
+// apply applies the CREATE TABLE, ALTER TABLE and DROP TABLE statements of a
+// script, failing like a database would (e.g. when adding a column twice).
+func (s schema) apply(script string) error {
+	var lines []string
+	for _, line := range strings.Split(script, "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), "--") {
+			lines = append(lines, line)
+		}
+	}
+
+	for _, stmt := range strings.Split(strings.Join(lines, "\n"), ";") {
+		stmt = strings.TrimSpace(stmt)
+		if m := createTablePattern.FindStringSubmatch(stmt); m != nil {
+			if _, ok := s[m[2]]; ok {
+				if m[1] == "" {
+					return fmt.Errorf("table %s already exists", m[2])
+				}
+				continue
+			}
+			columns := make(map[string]bool)
+			for _, def := range strings.Split(m[3], "\n") {
+				fields := strings.Fields(def)
+				if len(fields) == 0 {
+					continue
+				}
+				switch strings.ToUpper(fields[0]) {
+				case "INDEX", "UNIQUE", "PRIMARY", "CONSTRAINT":
+					continue
+				}
+				columns[strings.Trim(fields[0], "`")] = true
+			}
+			s[m[2]] = columns
+		} else if m := alterTablePattern.FindStringSubmatch(stmt); m != nil {
+			columns, ok := s[m[1]]
+			if !ok {
+				return fmt.Errorf("table %s does not exist", m[1])
+			}
+			for _, clause := range strings.Split(m[2], "\n") {
+				clause = strings.TrimSpace(clause)
+				if c := addColumnPattern.FindStringSubmatch(clause); c != nil {
+					if columns[c[2]] && c[1] == "" {
+						return fmt.Errorf("column %s.%s already exists", m[1], c[2])
+					}
+					columns[c[2]] = true
+				} else if c := dropColumnPattern.FindStringSubmatch(clause); c != nil {
+					if !columns[c[2]] && c[1] == "" {
+						return fmt.Errorf("column %s.%s does not exist", m[1], c[2])
+					}
+					delete(columns, c[2])
+				}
+			}
+		} else if m := dropTablePattern.FindStringSubmatch(stmt); m != nil {
+			delete(s, m[2])
+		}
+	}
+	return nil
+}
+
+// baselineSchema returns the schema created by gorm's AutoMigrate before
+// migrations were introduced.
+func baselineSchema() schema {
+	table := func(columns ...string) map[string]bool {
+		m := map[string]bool{"id": true, "created_at": true, "updated_at": true, "deleted_at": true}
+		for _, c := range columns {
+			m[c] = true
+		}
+		return m
+	}
+	return schema{
+		"campaigns": table("not_before", "not_after", "schedule_interval", "status", "users", "passwords",
+			"provider", "provider_metadata"),
+		"results": table("campaign_id", "ip", "timestamp", "username", "password", "valid", "locked", "mfa",
+			"rate_limited", "metadata"),
+	}
+}
+
+// modelColumns returns the columns gorm reads and writes for a model.
+func modelColumns(model interface{}) map[string]bool {
+	columns := make(map[string]bool)
+	for _, f := range (&gorm.Scope{Value: model}).GetModelStruct().StructFields {
+		if f.IsNormal && !f.IsIgnored {
+			columns[f.DBName] = true
+		}
+	}
+	return columns
+}
+
+func TestMigrateBaselineSchema(t *testing.T) {
+	models := map[string]interface{}{
+		"campaigns":     &Campaign{},
+		"results":       &Result{},
+		"credentials":   &Credential{},
+		"failed_tasks":  &FailedTask{},
+		"claims":        &Claim{},
+		"audit_entries": &AuditEntry{},
+		"accounts":      &Account{},
+		"redactions":    &Redaction{},
+	}
+
+	for driver := range dialects {
+		migrations, err := (&TridentDB{driver: driver}).Migrations()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for name, s := range map[string]schema{"baseline": baselineSchema(), "empty": {}} {
+			for _, m := range migrations {
+				err = s.apply(m.Up)
+				if err != nil {
+					t.Fatalf("[%s] error migrating the %s schema to %d (%s): %s", driver, name, m.Version, m.Name, err)
+				}
+			}
+			for table, model := range models {
+				if !reflect.DeepEqual(s[table], modelColumns(model)) {
+					t.Errorf("[%s] migrated %s schema has columns %v in %s, the model has %v",
+						driver, name, keys(s[table]), table, keys(modelColumns(model)))
+				}
+			}
+
+			// rolling back to the first migration restores the baseline
+			for i := len(migrations) - 1; i > 0; i-- {
+				err = s.apply(migrations[i].Down)
+				if err != nil {
+					t.Fatalf("[%s] error rolling back %d (%s): %s", driver, migrations[i].Version, migrations[i].Name, err)
+				}
+			}
+			if !reflect.DeepEqual(s, baselineSchema()) {
+				t.Errorf("[%s] expected rolling back the %s schema to restore the baseline, got %v", driver, name, s)
+			}
+		}
+	}
+}
+
+func keys(m map[string]bool) []string {
+	var list []string
+	for k := range m {
+		list = append(list, k)
+	}
+	sort.Strings(list)
+	return list
+}
@@ -0,0 +1,34 @@
+-- the baseline schema, matching the postgres schema of the same version.
+-- string arrays are stored in their postgres text representation.
+
+CREATE TABLE IF NOT EXISTS campaigns (
+    id int unsigned AUTO_INCREMENT PRIMARY KEY,
+    created_at datetime(6) NULL,
+    updated_at datetime(6) NULL,
+    deleted_at datetime(6) NULL,
+    not_before datetime(6) NULL,
+    not_after datetime(6) NULL,
+    schedule_interval bigint,
+    status varchar(255),
+    users longtext,
+    passwords longtext,
+    provider varchar(255),
+    provider_metadata json
+);
+
+CREATE TABLE IF NOT EXISTS results (
+    id int unsigned AUTO_INCREMENT PRIMARY KEY,
+    created_at datetime(6) NULL,
+    updated_at datetime(6) NULL,
+    deleted_at datetime(6) NULL,
+    campaign_id int unsigned,
+    ip varchar(255),
+    timestamp datetime(6) NULL,
+    username varchar(255),
+    password text,
+    valid boolean,
+    locked boolean,
+    mfa boolean,
+    rate_limited boolean,
+    metadata json
+);
@@ -0,0 +1,12 @@
+ALTER TABLE campaigns
+    DROP INDEX idx_campaigns_team,
+    DROP COLUMN max_in_flight,
+    DROP COLUMN max_attempts_per_hour,
+    DROP COLUMN user_window,
+    DROP COLUMN max_attempts_per_user,
+    DROP COLUMN max_retries,
+    DROP COLUMN password_rules,
+    DROP COLUMN username_formats,
+    DROP COLUMN names,
+    DROP COLUMN team,
+    DROP COLUMN mode;
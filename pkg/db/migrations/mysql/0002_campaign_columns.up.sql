@@ -0,0 +1,15 @@
+-- the modes, teams, generated usernames, password rules, retries and limits
+-- of campaigns.
+
+ALTER TABLE campaigns
+    ADD COLUMN mode varchar(255),
+    ADD COLUMN team varchar(255),
+    ADD COLUMN names longtext,
+    ADD COLUMN username_formats text,
+    ADD COLUMN password_rules text,
+    ADD COLUMN max_retries int,
+    ADD COLUMN max_attempts_per_user int,
+    ADD COLUMN user_window bigint,
+    ADD COLUMN max_attempts_per_hour int,
+    ADD COLUMN max_in_flight int,
+    ADD INDEX idx_campaigns_team (team);
@@ -0,0 +1,4 @@
+ALTER TABLE results
+    DROP COLUMN error_class,
+    DROP COLUMN error,
+    DROP COLUMN region;
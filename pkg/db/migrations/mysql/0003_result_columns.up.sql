@@ -0,0 +1,6 @@
+-- the worker region of results, and the errors of failed tasks.
+
+ALTER TABLE results
+    ADD COLUMN region varchar(255),
+    ADD COLUMN error text,
+    ADD COLUMN error_class varchar(255);
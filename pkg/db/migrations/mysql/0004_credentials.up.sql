@@ -0,0 +1,19 @@
+-- the credential vault.
+
+CREATE TABLE IF NOT EXISTS credentials (
+    id int unsigned AUTO_INCREMENT PRIMARY KEY,
+    created_at datetime(6) NULL,
+    updated_at datetime(6) NULL,
+    deleted_at datetime(6) NULL,
+    username varchar(255),
+    provider varchar(255),
+    team varchar(255),
+    provider_metadata json,
+    encrypted_password blob,
+    valid boolean,
+    mfa boolean,
+    first_seen datetime(6) NULL,
+    last_validated datetime(6) NULL,
+    last_campaign_id int unsigned,
+    UNIQUE INDEX idx_credential_identity (username, provider, team)
+);
@@ -0,0 +1,16 @@
+-- the dead-letter table of failed tasks.
+
+CREATE TABLE IF NOT EXISTS failed_tasks (
+    id int unsigned AUTO_INCREMENT PRIMARY KEY,
+    created_at datetime(6) NULL,
+    updated_at datetime(6) NULL,
+    deleted_at datetime(6) NULL,
+    campaign_id int unsigned,
+    username varchar(255),
+    provider varchar(255),
+    attempt int,
+    error text,
+    error_class varchar(255),
+    task json,
+    INDEX idx_failed_tasks_campaign_id (campaign_id)
+);
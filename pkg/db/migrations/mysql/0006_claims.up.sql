@@ -0,0 +1,9 @@
+-- the claims of idempotent tasks.
+
+CREATE TABLE IF NOT EXISTS claims (
+    `key` varchar(64) PRIMARY KEY,
+    campaign_id int unsigned,
+    claimed_at datetime(6) NULL,
+    completed_at datetime(6) NULL,
+    INDEX idx_claims_campaign_id (campaign_id)
+);
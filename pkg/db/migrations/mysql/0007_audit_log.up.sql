@@ -0,0 +1,19 @@
+-- the audit log of operator API calls. entries are only ever inserted.
+
+CREATE TABLE IF NOT EXISTS audit_entries (
+    id int unsigned AUTO_INCREMENT PRIMARY KEY,
+    timestamp datetime(6) NULL,
+    principal varchar(255),
+    role varchar(255),
+    action varchar(255),
+    method varchar(16),
+    path text,
+    campaign_id int unsigned,
+    params json,
+    status int,
+    remote_addr varchar(255),
+    INDEX idx_audit_entries_timestamp (timestamp),
+    INDEX idx_audit_entries_principal (principal),
+    INDEX idx_audit_entries_action (action),
+    INDEX idx_audit_entries_campaign_id (campaign_id)
+);
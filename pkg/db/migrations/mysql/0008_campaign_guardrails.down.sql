@@ -0,0 +1,5 @@
+ALTER TABLE campaigns
+    DROP COLUMN guardrail_action,
+    DROP COLUMN tripwires,
+    DROP COLUMN max_consecutive_rate_limited,
+    DROP COLUMN max_lockout_rate;
@@ -0,0 +1,7 @@
+-- thresholds which automatically pause or abort a campaign.
+
+ALTER TABLE campaigns
+    ADD COLUMN max_lockout_rate double,
+    ADD COLUMN max_consecutive_rate_limited int,
+    ADD COLUMN tripwires text,
+    ADD COLUMN guardrail_action varchar(255);
@@ -0,0 +1,2 @@
+ALTER TABLE results
+    DROP COLUMN captcha;
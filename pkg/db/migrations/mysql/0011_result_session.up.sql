@@ -0,0 +1,4 @@
+-- sessions captured after successful logins, sealed by the workers.
+
+ALTER TABLE results
+    ADD COLUMN session text;
@@ -0,0 +1,4 @@
+-- records valid credentials whose login was blocked by a provider policy.
+
+ALTER TABLE results
+    ADD COLUMN policy_blocked boolean;
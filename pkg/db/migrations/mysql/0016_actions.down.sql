@@ -0,0 +1,5 @@
+ALTER TABLE results
+    DROP COLUMN findings;
+
+ALTER TABLE campaigns
+    DROP COLUMN actions;
@@ -0,0 +1,7 @@
+-- read-only follow-up checks of valid credentials and their findings.
+
+ALTER TABLE campaigns
+    ADD COLUMN actions json;
+
+ALTER TABLE results
+    ADD COLUMN findings json;
@@ -0,0 +1,16 @@
+-- the state of accounts observed across campaigns (lockout memory).
+
+CREATE TABLE IF NOT EXISTS accounts (
+    id int unsigned AUTO_INCREMENT PRIMARY KEY,
+    created_at datetime(6) NULL,
+    updated_at datetime(6) NULL,
+    deleted_at datetime(6) NULL,
+    domain varchar(255) NOT NULL DEFAULT '',
+    username varchar(255) NOT NULL,
+    last_locked datetime(6) NULL,
+    last_mfa datetime(6) NULL,
+    last_valid datetime(6) NULL,
+    last_campaign_id int unsigned,
+    UNIQUE INDEX idx_account_identity (domain, username),
+    INDEX idx_accounts_last_locked (last_locked)
+);
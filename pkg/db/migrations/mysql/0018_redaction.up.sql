@@ -0,0 +1,15 @@
+-- the redaction of the credentials stored in the results of a campaign, and
+-- the sealed values replaced by redaction tokens.
+
+ALTER TABLE campaigns
+    ADD COLUMN redaction varchar(255),
+    ADD COLUMN redaction_key text;
+
+CREATE TABLE IF NOT EXISTS redactions (
+    token varchar(64) PRIMARY KEY,
+    campaign_id int unsigned,
+    kind varchar(255),
+    value text,
+    created_at datetime(6) NULL,
+    INDEX idx_redactions_campaign_id (campaign_id)
+);
@@ -0,0 +1,5 @@
+-- the tenant-level security features fingerprinted by the nozzle of a
+-- campaign when it was created.
+
+ALTER TABLE campaigns
+    ADD COLUMN fingerprint json;
@@ -0,0 +1,5 @@
+ALTER TABLE credentials
+    DROP INDEX idx_credential_identity,
+    DROP COLUMN tenant,
+    MODIFY COLUMN provider varchar(255),
+    ADD UNIQUE INDEX idx_credential_identity (username, provider, team);
@@ -0,0 +1,10 @@
+-- credentials are deduplicated per provider tenant, as well as per username,
+-- provider and team. credentials stored before have an empty tenant until
+-- they are found again. the provider column is shortened so that the index
+-- fits the key length limit of InnoDB.
+
+ALTER TABLE credentials
+    MODIFY COLUMN provider varchar(64),
+    ADD COLUMN tenant varchar(64) NOT NULL DEFAULT '',
+    DROP INDEX idx_credential_identity,
+    ADD UNIQUE INDEX idx_credential_identity (username, provider, tenant, team);
@@ -0,0 +1,2 @@
+ALTER TABLE campaigns
+    MODIFY COLUMN passwords longtext;
@@ -0,0 +1,5 @@
+-- campaign passwords are sealed at rest. the column is already unbounded on
+-- MySQL.
+
+ALTER TABLE campaigns
+    MODIFY COLUMN passwords longtext;
@@ -0,0 +1,36 @@
+-- the baseline schema, exactly as created by gorm's AutoMigrate before
+-- migrations were introduced. tables are only created if missing so that
+-- existing deployments are adopted; the columns added since are added by the
+-- following migrations.
+
+CREATE TABLE IF NOT EXISTS campaigns (
+    id serial PRIMARY KEY,
+    created_at timestamp with time zone,
+    updated_at timestamp with time zone,
+    deleted_at timestamp with time zone,
+    not_before timestamp with time zone,
+    not_after timestamp with time zone,
+    schedule_interval bigint,
+    status text,
+    users varchar(255)[],
+    passwords varchar(255)[],
+    provider text,
+    provider_metadata jsonb
+);
+
+CREATE TABLE IF NOT EXISTS results (
+    id serial PRIMARY KEY,
+    created_at timestamp with time zone,
+    updated_at timestamp with time zone,
+    deleted_at timestamp with time zone,
+    campaign_id integer,
+    ip text,
+    timestamp timestamp with time zone,
+    username text,
+    password text,
+    valid boolean,
+    locked boolean,
+    mfa boolean,
+    rate_limited boolean,
+    metadata jsonb
+);
@@ -0,0 +1,12 @@
+DROP INDEX IF EXISTS idx_campaigns_team;
+ALTER TABLE campaigns
+    DROP COLUMN IF EXISTS max_in_flight,
+    DROP COLUMN IF EXISTS max_attempts_per_hour,
+    DROP COLUMN IF EXISTS user_window,
+    DROP COLUMN IF EXISTS max_attempts_per_user,
+    DROP COLUMN IF EXISTS max_retries,
+    DROP COLUMN IF EXISTS password_rules,
+    DROP COLUMN IF EXISTS username_formats,
+    DROP COLUMN IF EXISTS names,
+    DROP COLUMN IF EXISTS team,
+    DROP COLUMN IF EXISTS mode;
@@ -0,0 +1,15 @@
+-- the modes, teams, generated usernames, password rules, retries and limits
+-- of campaigns.
+
+ALTER TABLE campaigns
+    ADD COLUMN IF NOT EXISTS mode text,
+    ADD COLUMN IF NOT EXISTS team text,
+    ADD COLUMN IF NOT EXISTS names text[],
+    ADD COLUMN IF NOT EXISTS username_formats text[],
+    ADD COLUMN IF NOT EXISTS password_rules text[],
+    ADD COLUMN IF NOT EXISTS max_retries integer,
+    ADD COLUMN IF NOT EXISTS max_attempts_per_user integer,
+    ADD COLUMN IF NOT EXISTS user_window bigint,
+    ADD COLUMN IF NOT EXISTS max_attempts_per_hour integer,
+    ADD COLUMN IF NOT EXISTS max_in_flight integer;
+CREATE INDEX IF NOT EXISTS idx_campaigns_team ON campaigns (team);
@@ -0,0 +1,4 @@
+ALTER TABLE results
+    DROP COLUMN IF EXISTS error_class,
+    DROP COLUMN IF EXISTS error,
+    DROP COLUMN IF EXISTS region;
@@ -0,0 +1,6 @@
+-- the worker region of results, and the errors of failed tasks.
+
+ALTER TABLE results
+    ADD COLUMN IF NOT EXISTS region text,
+    ADD COLUMN IF NOT EXISTS error text,
+    ADD COLUMN IF NOT EXISTS error_class text;
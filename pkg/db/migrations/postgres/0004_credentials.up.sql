@@ -0,0 +1,19 @@
+-- the credential vault.
+
+CREATE TABLE IF NOT EXISTS credentials (
+    id serial PRIMARY KEY,
+    created_at timestamp with time zone,
+    updated_at timestamp with time zone,
+    deleted_at timestamp with time zone,
+    username text,
+    provider text,
+    team text,
+    provider_metadata jsonb,
+    encrypted_password bytea,
+    valid boolean,
+    mfa boolean,
+    first_seen timestamp with time zone,
+    last_validated timestamp with time zone,
+    last_campaign_id integer
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_credential_identity ON credentials (username, provider, team);
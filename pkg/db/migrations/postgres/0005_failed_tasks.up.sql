@@ -0,0 +1,16 @@
+-- the dead-letter table of failed tasks.
+
+CREATE TABLE IF NOT EXISTS failed_tasks (
+    id serial PRIMARY KEY,
+    created_at timestamp with time zone,
+    updated_at timestamp with time zone,
+    deleted_at timestamp with time zone,
+    campaign_id integer,
+    username text,
+    provider text,
+    attempt integer,
+    error text,
+    error_class text,
+    task jsonb
+);
+CREATE INDEX IF NOT EXISTS idx_failed_tasks_campaign_id ON failed_tasks (campaign_id);
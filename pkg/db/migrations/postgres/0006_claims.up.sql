@@ -0,0 +1,9 @@
+-- the claims of idempotent tasks.
+
+CREATE TABLE IF NOT EXISTS claims (
+    key text PRIMARY KEY,
+    campaign_id integer,
+    claimed_at timestamp with time zone,
+    completed_at timestamp with time zone
+);
+CREATE INDEX IF NOT EXISTS idx_claims_campaign_id ON claims (campaign_id);
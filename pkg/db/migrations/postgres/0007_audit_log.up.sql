@@ -0,0 +1,19 @@
+-- the audit log of operator API calls. entries are only ever inserted.
+
+CREATE TABLE IF NOT EXISTS audit_entries (
+    id serial PRIMARY KEY,
+    timestamp timestamp with time zone,
+    principal text,
+    role text,
+    action text,
+    method text,
+    path text,
+    campaign_id integer,
+    params jsonb,
+    status integer,
+    remote_addr text
+);
+CREATE INDEX IF NOT EXISTS idx_audit_entries_timestamp ON audit_entries (timestamp);
+CREATE INDEX IF NOT EXISTS idx_audit_entries_principal ON audit_entries (principal);
+CREATE INDEX IF NOT EXISTS idx_audit_entries_action ON audit_entries (action);
+CREATE INDEX IF NOT EXISTS idx_audit_entries_campaign_id ON audit_entries (campaign_id);
@@ -0,0 +1,5 @@
+ALTER TABLE campaigns
+    DROP COLUMN IF EXISTS guardrail_action,
+    DROP COLUMN IF EXISTS tripwires,
+    DROP COLUMN IF EXISTS max_consecutive_rate_limited,
+    DROP COLUMN IF EXISTS max_lockout_rate;
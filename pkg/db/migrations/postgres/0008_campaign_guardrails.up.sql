@@ -0,0 +1,7 @@
+-- thresholds which automatically pause or abort a campaign.
+
+ALTER TABLE campaigns
+    ADD COLUMN IF NOT EXISTS max_lockout_rate double precision,
+    ADD COLUMN IF NOT EXISTS max_consecutive_rate_limited integer,
+    ADD COLUMN IF NOT EXISTS tripwires text[],
+    ADD COLUMN IF NOT EXISTS guardrail_action text;
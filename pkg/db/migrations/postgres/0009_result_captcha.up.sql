@@ -0,0 +1,4 @@
+-- records attempts which the provider answered with a CAPTCHA challenge.
+
+ALTER TABLE results
+    ADD COLUMN IF NOT EXISTS captcha boolean;
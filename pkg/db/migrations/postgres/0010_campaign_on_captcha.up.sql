@@ -0,0 +1,4 @@
+-- the action taken when a user of a campaign is challenged with a CAPTCHA.
+
+ALTER TABLE campaigns
+    ADD COLUMN IF NOT EXISTS on_captcha text;
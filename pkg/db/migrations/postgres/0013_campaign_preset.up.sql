@@ -0,0 +1,4 @@
+-- the built-in preset which paced a campaign.
+
+ALTER TABLE campaigns
+    ADD COLUMN IF NOT EXISTS preset text;
@@ -0,0 +1,4 @@
+-- raw provider responses captured for re-classification, sealed by the workers.
+
+ALTER TABLE results
+    ADD COLUMN IF NOT EXISTS response text;
@@ -0,0 +1,4 @@
+-- accounts a campaign must never attempt.
+
+ALTER TABLE campaigns
+    ADD COLUMN IF NOT EXISTS excluded text[];
@@ -0,0 +1,5 @@
+ALTER TABLE results
+    DROP COLUMN IF EXISTS findings;
+
+ALTER TABLE campaigns
+    DROP COLUMN IF EXISTS actions;
@@ -0,0 +1,7 @@
+-- read-only follow-up checks of valid credentials and their findings.
+
+ALTER TABLE campaigns
+    ADD COLUMN IF NOT EXISTS actions jsonb;
+
+ALTER TABLE results
+    ADD COLUMN IF NOT EXISTS findings jsonb;
@@ -0,0 +1,16 @@
+-- the state of accounts observed across campaigns (lockout memory).
+
+CREATE TABLE IF NOT EXISTS accounts (
+    id serial PRIMARY KEY,
+    created_at timestamp with time zone,
+    updated_at timestamp with time zone,
+    deleted_at timestamp with time zone,
+    domain text NOT NULL DEFAULT '',
+    username text NOT NULL,
+    last_locked timestamp with time zone,
+    last_mfa timestamp with time zone,
+    last_valid timestamp with time zone,
+    last_campaign_id integer
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_account_identity ON accounts (domain, username);
+CREATE INDEX IF NOT EXISTS idx_accounts_last_locked ON accounts (last_locked);
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS redactions;
+
+ALTER TABLE campaigns
+    DROP COLUMN IF EXISTS redaction_key,
+    DROP COLUMN IF EXISTS redaction;
@@ -0,0 +1,15 @@
+-- the redaction of the credentials stored in the results of a campaign, and
+-- the sealed values replaced by redaction tokens.
+
+ALTER TABLE campaigns
+    ADD COLUMN IF NOT EXISTS redaction text,
+    ADD COLUMN IF NOT EXISTS redaction_key text;
+
+CREATE TABLE IF NOT EXISTS redactions (
+    token text PRIMARY KEY,
+    campaign_id integer,
+    kind text,
+    value text,
+    created_at timestamp with time zone
+);
+CREATE INDEX IF NOT EXISTS idx_redactions_campaign_id ON redactions (campaign_id);
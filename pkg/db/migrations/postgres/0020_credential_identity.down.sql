@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_credential_identity;
+ALTER TABLE credentials
+    DROP COLUMN IF EXISTS tenant;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_credential_identity ON credentials (username, provider, team);
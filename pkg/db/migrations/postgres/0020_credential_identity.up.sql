@@ -0,0 +1,8 @@
+-- credentials are deduplicated per provider tenant, as well as per username,
+-- provider and team. credentials stored before have an empty tenant until
+-- they are found again.
+
+ALTER TABLE credentials
+    ADD COLUMN IF NOT EXISTS tenant text NOT NULL DEFAULT '';
+DROP INDEX IF EXISTS idx_credential_identity;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_credential_identity ON credentials (username, provider, tenant, team);
@@ -0,0 +1,4 @@
+-- sealed passwords do not fit varchar(255), so the column is left unbounded
+-- rather than failing the rollback (or truncating passwords).
+
+SELECT 1;
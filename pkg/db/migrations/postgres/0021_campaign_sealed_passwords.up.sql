@@ -0,0 +1,4 @@
+-- campaign passwords are sealed at rest, which exceeds 255 characters.
+
+ALTER TABLE campaigns
+    ALTER COLUMN passwords TYPE text[];
@@ -15,7 +15,11 @@
 package db
 
 import (
+	"database/sql/driver"
 	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/lib/pq"
@@ -44,6 +48,53 @@ const (
 	CampaignStatusPaused = "Paused"
 )
 
+// The CampaignMode enum indicates the purpose of a Campaign
+type CampaignMode string
+
+const (
+	// CampaignModeSpray is the mode of regular password spraying campaigns
+	CampaignModeSpray CampaignMode = ""
+	// CampaignModeDetection is the mode of blue-team detection validation
+	// campaigns, which only attempt known-bad passwords
+	CampaignModeDetection CampaignMode = "detection"
+)
+
+// The RedactionMode enum indicates which credentials are redacted from the
+// stored results of a Campaign
+type RedactionMode string
+
+const (
+	// RedactionNone stores the results of the campaign as is
+	RedactionNone RedactionMode = ""
+	// RedactionPasswords replaces the passwords of the results with tokens
+	RedactionPasswords RedactionMode = "passwords"
+	// RedactionCredentials replaces both the usernames and the passwords of
+	// the results with tokens
+	RedactionCredentials RedactionMode = "credentials"
+)
+
+// Validate returns an error if the redaction mode is unknown.
+func (m RedactionMode) Validate() error {
+	switch m {
+	case RedactionNone, RedactionPasswords, RedactionCredentials:
+		return nil
+	}
+	return fmt.Errorf("unknown redaction mode %q", m)
+}
+
+// String returns the redaction mode, or "none".
+func (m RedactionMode) String() string {
+	if m == RedactionNone {
+		return "none"
+	}
+	return string(m)
+}
+
+// Usernames returns true if the usernames of the results are redacted.
+func (m RedactionMode) Usernames() bool {
+	return m == RedactionCredentials
+}
+
 // Campaign stores the metadata associated with an entire password spraying campaign
 type Campaign struct {
 	// inherit the base model's fields
@@ -58,26 +109,351 @@ type Campaign struct {
 	// a campaign should make requests with this interval in between them
 	ScheduleInterval time.Duration `json:"schedule_interval"`
 
+	// the name of the built-in preset which set the schedule interval and
+	// per user limit of the campaign, if any (see scheduler.Presets)
+	Preset string `json:"preset"`
+
 	// current status of the campaign, used to pause/cancel/resume without deletion
 	Status CampaignStatus `json:"status"`
 
+	// the purpose of the campaign (spray or detection)
+	Mode CampaignMode `json:"mode"`
+
+	// the team (engagement) which owns this campaign, used to isolate
+	// campaigns between operators on a shared deployment
+	Team string `json:"team" gorm:"index"`
+
 	// the slice of usernames to guess in this campaign
 	Users pq.StringArray `json:"users" gorm:"type:varchar(255)[]"`
 
+	// patterns of accounts which must never be attempted (e.g. break-glass
+	// admins or out of scope executives), enforced by the scheduler and
+	// again by the workers (see usernames.Excluded)
+	Excluded pq.StringArray `json:"excluded" gorm:"type:text[]"`
+
+	// full names of people whose usernames are generated from the username
+	// formats and added to the users when the campaign is created (see the
+	// usernames package)
+	Names pq.StringArray `json:"names" gorm:"type:text[]"`
+
+	// username format templates, e.g. {f}{last}@example.org
+	UsernameFormats pq.StringArray `json:"username_formats" gorm:"type:text[]"`
+
 	// passwords to try during this campaign
-	Passwords pq.StringArray `json:"passwords" gorm:"type:varchar(255)[]"`
+	Passwords pq.StringArray `json:"passwords" gorm:"type:text[]"`
+
+	// mangling rules applied to the passwords when the campaign is scheduled
+	// (see the mangle package)
+	PasswordRules pq.StringArray `json:"password_rules" gorm:"type:text[]"`
 
 	// the authentication portal this campaign is targeting
 	Provider string `json:"provider"`
 
+	// the maximum number of times a task failing with a transient error is
+	// retried
+	MaxRetries int `json:"max_retries"`
+
+	// caps on the attempts of the campaign, enforced by the scheduler
+	Limits
+
+	// thresholds which automatically pause or abort the campaign
+	Guardrails
+
 	// any extra metadata that the auth provider will need to make
 	// successful requests to the portal
 	ProviderMetadata json.RawMessage `json:"provider_metadata"`
 
+	// the credentials replaced with tokens in the stored results, so that
+	// read-only users can review outcomes without seeing plaintext
+	// credentials (see the redact package)
+	Redaction RedactionMode `json:"redaction"`
+
+	// the sealed key from which the redaction tokens of the campaign are
+	// derived
+	RedactionKey string `json:"-"`
+
+	// read-only follow-up checks run by the workers once a credential
+	// validates (see the action package)
+	Actions Actions `json:"actions" gorm:"type:jsonb"`
+
+	// the tenant-level security features detected by the nozzle's probe
+	// when the campaign was created (see the fingerprint package)
+	Fingerprint Fingerprint `json:"fingerprint" gorm:"type:jsonb"`
+
 	// the results of the campaign
 	Results []Result `json:"results"`
 }
 
+// Limits are campaign-level caps enforced by the scheduler when tasks are
+// published. They are tracked in the task schedule's cache so that they hold
+// across orchestrator restarts and any number of workers. A zero value
+// disables a cap.
+type Limits struct {
+	// the maximum number of attempts against a single user within
+	// UserWindow
+	MaxAttemptsPerUser int `json:"max_attempts_per_user"`
+
+	// the sliding window of MaxAttemptsPerUser
+	UserWindow time.Duration `json:"user_window"`
+
+	// the maximum number of attempts of the campaign within any hour
+	MaxAttemptsPerHour int `json:"max_attempts_per_hour"`
+
+	// the maximum number of tasks of the campaign in flight at once
+	MaxInFlight int `json:"max_in_flight"`
+}
+
+// Enabled returns true if any cap is set.
+func (l Limits) Enabled() bool {
+	return l.MaxAttemptsPerUser > 0 || l.MaxAttemptsPerHour > 0 || l.MaxInFlight > 0
+}
+
+// String summarizes the caps, e.g. "3 per user per 1h0m0s, 10 in flight".
+func (l Limits) String() string {
+	var caps []string
+	if l.MaxAttemptsPerUser > 0 {
+		caps = append(caps, fmt.Sprintf("%d per user per %s", l.MaxAttemptsPerUser, l.UserWindow))
+	}
+	if l.MaxAttemptsPerHour > 0 {
+		caps = append(caps, fmt.Sprintf("%d per hour", l.MaxAttemptsPerHour))
+	}
+	if l.MaxInFlight > 0 {
+		caps = append(caps, fmt.Sprintf("%d in flight", l.MaxInFlight))
+	}
+	if len(caps) == 0 {
+		return "none"
+	}
+	return strings.Join(caps, ", ")
+}
+
+// Validate returns an error if the limits are inconsistent.
+func (l Limits) Validate() error {
+	if l.MaxAttemptsPerUser < 0 || l.MaxAttemptsPerHour < 0 || l.MaxInFlight < 0 || l.UserWindow < 0 {
+		return fmt.Errorf("campaign limits must not be negative")
+	}
+	if l.MaxAttemptsPerUser > 0 && l.UserWindow == 0 {
+		return fmt.Errorf("max_attempts_per_user requires a user_window")
+	}
+	return nil
+}
+
+// The GuardrailAction enum is the action taken when a guardrail trips
+type GuardrailAction string
+
+const (
+	// GuardrailPause pauses the campaign, which can then be resumed
+	GuardrailPause GuardrailAction = "pause"
+	// GuardrailAbort cancels the campaign
+	GuardrailAbort GuardrailAction = "abort"
+)
+
+// The CaptchaAction enum is the action taken when a user is challenged with a
+// CAPTCHA
+type CaptchaAction string
+
+const (
+	// CaptchaBackoff postpones the attempts against the user, including the
+	// challenged one
+	CaptchaBackoff CaptchaAction = "backoff"
+	// CaptchaSkip stops attempting the user for the rest of the campaign
+	CaptchaSkip CaptchaAction = "skip"
+	// CaptchaTrip trips the campaign's guardrails, pausing or aborting it
+	CaptchaTrip CaptchaAction = "trip"
+)
+
+// Guardrails are thresholds on the outcomes of a campaign's attempts, checked
+// by the scheduler as results are consumed. When one trips, the campaign is
+// paused (or aborted) and operators are notified. A zero value disables a
+// guardrail.
+type Guardrails struct {
+	// the percentage of attempts returning a locked account above which the
+	// guardrail trips
+	MaxLockoutRate float64 `json:"max_lockout_rate"`
+
+	// the number of consecutive rate limited attempts at which the guardrail
+	// trips
+	MaxConsecutiveRateLimited int `json:"max_consecutive_rate_limited"`
+
+	// accounts which trip the guardrail as soon as they are found locked
+	Tripwires pq.StringArray `json:"tripwires" gorm:"type:text[]"`
+
+	// the action taken when a guardrail trips (defaults to pause)
+	GuardrailAction GuardrailAction `json:"guardrail_action"`
+
+	// the action taken when a user is challenged with a CAPTCHA (defaults to
+	// backoff)
+	OnCaptcha CaptchaAction `json:"on_captcha"`
+}
+
+// Enabled returns true if any guardrail is set.
+func (g Guardrails) Enabled() bool {
+	return g.MaxLockoutRate > 0 || g.MaxConsecutiveRateLimited > 0 || len(g.Tripwires) > 0
+}
+
+// Action returns the action taken when a guardrail trips.
+func (g Guardrails) Action() GuardrailAction {
+	if g.GuardrailAction == "" {
+		return GuardrailPause
+	}
+	return g.GuardrailAction
+}
+
+// Captcha returns the action taken when a user is challenged with a CAPTCHA.
+func (g Guardrails) Captcha() CaptchaAction {
+	if g.OnCaptcha == "" {
+		return CaptchaBackoff
+	}
+	return g.OnCaptcha
+}
+
+// String summarizes the guardrails, e.g. "lockout rate > 5%, 2 tripwires
+// (pause)".
+func (g Guardrails) String() string {
+	var rails []string
+	if g.MaxLockoutRate > 0 {
+		rails = append(rails, fmt.Sprintf("lockout rate > %g%%", g.MaxLockoutRate))
+	}
+	if g.MaxConsecutiveRateLimited > 0 {
+		rails = append(rails, fmt.Sprintf("%d consecutive rate limits", g.MaxConsecutiveRateLimited))
+	}
+	if len(g.Tripwires) > 0 {
+		rails = append(rails, fmt.Sprintf("%d tripwires", len(g.Tripwires)))
+	}
+	if g.OnCaptcha == CaptchaTrip {
+		rails = append(rails, "captcha")
+	}
+	if len(rails) == 0 {
+		return "none"
+	}
+	return fmt.Sprintf("%s (%s)", strings.Join(rails, ", "), g.Action())
+}
+
+// Validate returns an error if the guardrails are inconsistent.
+func (g Guardrails) Validate() error {
+	if g.MaxLockoutRate < 0 || g.MaxLockoutRate > 100 {
+		return fmt.Errorf("max_lockout_rate must be a percentage")
+	}
+	if g.MaxConsecutiveRateLimited < 0 {
+		return fmt.Errorf("max_consecutive_rate_limited must not be negative")
+	}
+	switch g.GuardrailAction {
+	case "", GuardrailPause, GuardrailAbort:
+	default:
+		return fmt.Errorf("unknown guardrail_action %q", g.GuardrailAction)
+	}
+	switch g.OnCaptcha {
+	case "", CaptchaBackoff, CaptchaSkip, CaptchaTrip:
+	default:
+		return fmt.Errorf("unknown on_captcha action %q", g.OnCaptcha)
+	}
+	return nil
+}
+
+// Action configures a post-success action of a campaign (see the action
+// package).
+type Action struct {
+	// Name is the name of the action driver (e.g. smtp_auth)
+	Name string `json:"name"`
+
+	// Options configure the action
+	Options map[string]string `json:"options,omitempty"`
+}
+
+// Actions are the post-success actions of a campaign, stored as JSON.
+type Actions []Action
+
+// String lists the names of the actions, e.g. "smtp_auth, o365_mailbox".
+func (a Actions) String() string {
+	if len(a) == 0 {
+		return "none"
+	}
+	names := make([]string, len(a))
+	for i, action := range a {
+		names[i] = action.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// Value implements the driver.Valuer interface.
+func (a Actions) Value() (driver.Value, error) {
+	if a == nil {
+		return nil, nil
+	}
+	return json.Marshal(a)
+}
+
+// Scan implements the sql.Scanner interface.
+func (a *Actions) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case []byte:
+		return json.Unmarshal(v, a)
+	case string:
+		return json.Unmarshal([]byte(v), a)
+	case nil:
+		*a = nil
+		return nil
+	}
+	return fmt.Errorf("unsupported actions type %T", value)
+}
+
+// Fingerprint records the tenant-level security features (e.g. the Okta
+// pipeline or a VPN portal's banner) detected by a single benign probe of a
+// campaign's nozzle, stored as JSON.
+type Fingerprint struct {
+	// ProbedAt is the time of the probe
+	ProbedAt time.Time `json:"probed_at"`
+
+	// Features maps the detected features to their values
+	Features map[string]string `json:"features,omitempty"`
+
+	// Error is the reason the probe failed, if it did
+	Error string `json:"error,omitempty"`
+}
+
+// String lists the detected features, e.g. "org: 00o1, pipeline: idx".
+func (f Fingerprint) String() string {
+	switch {
+	case f.Error != "":
+		return "probe failed: " + f.Error
+	case f.ProbedAt.IsZero():
+		return "none"
+	case len(f.Features) == 0:
+		return "no features detected"
+	}
+	names := make([]string, 0, len(f.Features))
+	for name := range f.Features {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	features := make([]string, len(names))
+	for i, name := range names {
+		features[i] = name + ": " + f.Features[name]
+	}
+	return strings.Join(features, ", ")
+}
+
+// Value implements the driver.Valuer interface.
+func (f Fingerprint) Value() (driver.Value, error) {
+	if f.ProbedAt.IsZero() {
+		return nil, nil
+	}
+	return json.Marshal(f)
+}
+
+// Scan implements the sql.Scanner interface.
+func (f *Fingerprint) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case []byte:
+		return json.Unmarshal(v, f)
+	case string:
+		return json.Unmarshal([]byte(v), f)
+	case nil:
+		*f = Fingerprint{}
+		return nil
+	}
+	return fmt.Errorf("unsupported fingerprint type %T", value)
+}
+
 // Result carries metadata about an individual result from the password spraying
 // campaign
 type Result struct {
@@ -90,6 +466,9 @@ type Result struct {
 	// IP is the originating IP of the credential guess
 	IP string `json:"ip"`
 
+	// Region is the cloud region of the worker which made the guess
+	Region string `json:"region"`
+
 	// Timestamp is the time that we made the request
 	Timestamp time.Time `json:"timestamp"`
 
@@ -111,8 +490,266 @@ type Result struct {
 	// RateLimited indicates the provider has detected a large number of requests
 	RateLimited bool `json:"rate_limited"`
 
+	// Captcha indicates the provider required a CAPTCHA, so the validity of
+	// the credential is unknown
+	Captcha bool `json:"captcha"`
+
+	// PolicyBlocked indicates the credential is valid but the login was
+	// blocked by a policy of the provider
+	PolicyBlocked bool `json:"policy_blocked"`
+
 	// Additional metadata from the auth provider (e.g. information about MFA)
 	Metadata json.RawMessage `json:"metadata"`
+
+	// Session is the sealed session captured after a successful login, only
+	// unsealed for operators
+	Session string `json:"session"`
+
+	// Response is the sealed raw provider response, captured for nozzles
+	// configured with capture_response so that the result can be
+	// re-classified
+	Response string `json:"response,omitempty"`
+
+	// Findings are recorded by the post-success actions of a valid
+	// credential
+	Findings json.RawMessage `json:"findings,omitempty"`
+
+	// CredentialID is set when the result revalidates a stored credential
+	CredentialID uint `json:"credential_id,omitempty" gorm:"-"`
+
+	// Error is set when the task could not be completed by the worker
+	Error string `json:"error,omitempty"`
+
+	// ErrorClass classifies the error (see the retry package)
+	ErrorClass string `json:"error_class,omitempty"`
+
+	// Task is the failed (or CAPTCHA challenged) task, returned by the
+	// dispatcher so that it can be retried
+	Task *Task `json:"task,omitempty" gorm:"-"`
+
+	// Key is the idempotency key of the task which produced the result
+	Key string `json:"key,omitempty" gorm:"-"`
+
+	// Fingerprint is set by fingerprint tasks, whose results are recorded
+	// with their campaign rather than stored
+	Fingerprint *Fingerprint `json:"fingerprint,omitempty" gorm:"-"`
+}
+
+// CampaignProgress summarizes the progress of a campaign.
+type CampaignProgress struct {
+	// CampaignID identifies the campaign
+	CampaignID uint `json:"campaign_id"`
+
+	// Provider is the authentication portal the campaign is targeting
+	Provider string `json:"provider"`
+
+	// Status is the current status of the campaign
+	Status CampaignStatus `json:"status"`
+
+	// Scheduled is the number of tasks which fit in the campaign's window
+	Scheduled int64 `json:"scheduled"`
+
+	// Pending is the number of tasks still waiting in the schedule
+	Pending int64 `json:"pending"`
+
+	// Completed is the number of tasks the workers completed
+	Completed int64 `json:"completed"`
+
+	// Errored is the number of tasks the workers failed to complete
+	Errored int64 `json:"errored"`
+
+	// Valid is the number of valid credentials found
+	Valid int64 `json:"valid"`
+
+	// Locked is the number of attempts which detected a locked account
+	Locked int64 `json:"locked"`
+
+	// RateLimited is the number of attempts which were rate limited
+	RateLimited int64 `json:"rate_limited"`
+
+	// Recent is the number of attempts made within the rate window
+	Recent int64 `json:"-"`
+
+	// Rate is the number of attempts per minute over the rate window
+	Rate float64 `json:"rate"`
+
+	// ProjectedCompletion is the time the last pending task is expected to
+	// run. it is zero once the campaign has no pending tasks.
+	ProjectedCompletion time.Time `json:"projected_completion"`
+}
+
+// Credential is a valid credential discovered by one or more campaigns. valid
+// results are deduplicated per (username, provider, tenant, team) and the
+// password is encrypted at rest.
+type Credential struct {
+	// inherit the base model's fields
+	Model
+
+	// Username is the username at the identity provider
+	Username string `json:"username" gorm:"unique_index:idx_credential_identity"`
+
+	// Provider is the name of identity provider the credential is valid for
+	Provider string `json:"provider" gorm:"unique_index:idx_credential_identity"`
+
+	// Tenant identifies the provider tenant (e.g. the Okta subdomain or the
+	// ADFS domain) the credential is valid for, see credentials.Tenant
+	Tenant string `json:"-" gorm:"unique_index:idx_credential_identity"`
+
+	// Team is the team (engagement) that discovered the credential
+	Team string `json:"team" gorm:"unique_index:idx_credential_identity"`
+
+	// ProviderMetadata is the provider configuration used to (re)validate the
+	// credential
+	ProviderMetadata json.RawMessage `json:"provider_metadata"`
+
+	// EncryptedPassword is the password encrypted by the configured key
+	// manager
+	EncryptedPassword []byte `json:"-"`
+
+	// Password is only populated when a credential is explicitly revealed
+	Password string `json:"password,omitempty" gorm:"-"`
+
+	// Valid is false once revalidation detects the password has changed
+	Valid bool `json:"valid"`
+
+	// MFA will be true iff the account requires MFA to log in
+	MFA bool `json:"mfa"`
+
+	// FirstSeen is the first time the credential was found to be valid
+	FirstSeen time.Time `json:"first_seen"`
+
+	// LastValidated is the last time the credential was found to be valid
+	LastValidated time.Time `json:"last_validated"`
+
+	// LastCampaignID is the most recent campaign which found the credential
+	LastCampaignID uint `json:"last_campaign_id"`
+}
+
+// Account records the outcomes observed for an account across campaigns, so
+// that the scheduler can hold off attempting an account which was recently
+// locked out, whichever campaign locked it. accounts are identified by their
+// domain and normalized username (see usernames.Identity).
+type Account struct {
+	// inherit the base model's fields
+	Model
+
+	// Domain is the domain of the username, or empty for a bare username
+	Domain string `json:"domain"`
+
+	// Username is the lowercased username
+	Username string `json:"username"`
+
+	// LastLocked is the last time the account was found locked out
+	LastLocked *time.Time `json:"last_locked"`
+
+	// LastMFA is the last time a valid password required MFA
+	LastMFA *time.Time `json:"last_mfa"`
+
+	// LastValid is the last time a password of the account was valid
+	LastValid *time.Time `json:"last_valid"`
+
+	// LastCampaignID is the most recent campaign which observed the account
+	LastCampaignID uint `json:"last_campaign_id"`
+}
+
+// FailedTask is a task which failed permanently or exhausted its retries.
+// failed tasks are kept in a dead-letter table until they are requeued.
+type FailedTask struct {
+	// inherit the base model's fields
+	Model
+
+	// CampaignID is the campaign the task belongs to (0 for revalidation
+	// tasks)
+	CampaignID uint `json:"campaign_id" gorm:"index"`
+
+	// Username is the username at the identity provider
+	Username string `json:"username"`
+
+	// Provider is the name of identity provider
+	Provider string `json:"provider"`
+
+	// Attempt is the number of times the task was retried
+	Attempt int `json:"attempt"`
+
+	// Error is the last error returned for the task
+	Error string `json:"error"`
+
+	// ErrorClass classifies the error (see the retry package)
+	ErrorClass string `json:"error_class"`
+
+	// Task is the complete task, used to requeue it
+	Task Task `json:"task" gorm:"type:jsonb"`
+}
+
+// Claim records that a task was handed to a worker, making task execution
+// idempotent: a claimed key is never executed again, and the result of a key
+// is only recorded once.
+type Claim struct {
+	// Key is the idempotency key of the task
+	Key string `json:"key" gorm:"primary_key"`
+
+	// CampaignID is the campaign the task belongs to
+	CampaignID uint `json:"campaign_id" gorm:"index"`
+
+	// ClaimedAt is the time a worker claimed the task
+	ClaimedAt *time.Time `json:"claimed_at"`
+
+	// CompletedAt is the time the result of the task was recorded
+	CompletedAt *time.Time `json:"completed_at"`
+}
+
+// Redaction maps a token stored in the results of a redacted campaign to the
+// sealed value it replaces. redactions are only read to reveal credentials to
+// operators.
+type Redaction struct {
+	// Token is the token stored in place of the value
+	Token string `json:"token" gorm:"primary_key"`
+
+	// CampaignID is the campaign the token belongs to
+	CampaignID uint `json:"campaign_id" gorm:"index"`
+
+	// Kind is the kind of value replaced (username or password)
+	Kind string `json:"kind"`
+
+	// Value is the sealed value
+	Value string `json:"value"`
+
+	// CreatedAt is the time the token was first issued
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AuditEntry records an API call made by an operator. the audit log is
+// append-only: entries are never updated or deleted.
+type AuditEntry struct {
+	ID uint `json:"id" gorm:"primary_key"`
+
+	// Timestamp is the time the call was made
+	Timestamp time.Time `json:"timestamp" gorm:"index"`
+
+	// Principal is the authenticated identity of the operator
+	Principal string `json:"principal" gorm:"index"`
+
+	// Role is the role of the operator at the time of the call
+	Role string `json:"role"`
+
+	// Action names the operation (e.g. campaign.create)
+	Action string `json:"action" gorm:"index"`
+
+	// Method and Path are the HTTP method and path of the call
+	Method string `json:"method"`
+	Path   string `json:"path"`
+
+	// CampaignID is the campaign targeted by the call, if any
+	CampaignID uint `json:"campaign_id" gorm:"index"`
+
+	// Params are the parameters of the call, with passwords redacted
+	Params json.RawMessage `json:"params"`
+
+	// Status is the HTTP status code returned to the operator
+	Status int `json:"status"`
+
+	// RemoteAddr is the address the call was made from
+	RemoteAddr string `json:"remote_addr"`
 }
 
 // Task carries metadata about a single task in the password spraying campaign
@@ -137,6 +774,34 @@ type Task struct {
 
 	// ProviderMetadata is any required configuration data for the provider
 	ProviderMetadata json.RawMessage `json:"metadata"`
+
+	// CredentialID is set when the task revalidates a stored credential
+	// rather than belonging to a campaign
+	CredentialID uint `json:"credential_id,omitempty"`
+
+	// Attempt is the number of times this task has been retried
+	Attempt int `json:"attempt,omitempty"`
+
+	// MaxRetries is the maximum number of times this task may be retried
+	MaxRetries int `json:"max_retries,omitempty"`
+
+	// Limits are the caps of the task's campaign, if any
+	Limits *Limits `json:"limits,omitempty"`
+
+	// Excluded are the exclusion patterns of the task's campaign, checked
+	// again by the worker before the attempt
+	Excluded []string `json:"excluded,omitempty"`
+
+	// Actions are the post-success actions of the task's campaign
+	Actions Actions `json:"actions,omitempty"`
+
+	// Key is the idempotency key of the task. a task is executed at most
+	// once per key, regardless of how often its message is delivered.
+	Key string `json:"key,omitempty"`
+
+	// Fingerprint is set when the task probes the tenant of its campaign
+	// instead of attempting a login (see the fingerprint package)
+	Fingerprint bool `json:"fingerprint,omitempty"`
 }
 
 // MarshalBinary task marshalling
@@ -148,3 +813,21 @@ func (t *Task) MarshalBinary() ([]byte, error) {
 func (t *Task) UnmarshalBinary(data []byte) error {
 	return json.Unmarshal(data, &t)
 }
+
+// Value implements the driver.Valuer interface, storing tasks as JSON.
+func (t Task) Value() (driver.Value, error) {
+	return json.Marshal(t)
+}
+
+// Scan implements the sql.Scanner interface.
+func (t *Task) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case []byte:
+		return json.Unmarshal(v, t)
+	case string:
+		return json.Unmarshal([]byte(v), t)
+	case nil:
+		return nil
+	}
+	return fmt.Errorf("unsupported task type %T", value)
+}
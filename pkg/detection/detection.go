@@ -0,0 +1,241 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package detection supports blue-team detection validation campaigns. such
+// campaigns only attempt known-bad passwords, and their timeline of attempts
+// is compared against the alerts raised by the defenders' SIEM to measure
+// detection coverage and latency.
+package detection
+
+import (
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+)
+
+const (
+	// PasswordPrefix prefixes every password generated for detection
+	// campaigns
+	PasswordPrefix = "trident-detection-"
+
+	// DefaultAttempts is the number of passwords attempted per account by
+	// detection campaigns
+	DefaultAttempts = 3
+)
+
+// Passwords generates n random passwords which are known to be invalid.
+func Passwords(n int) ([]string, error) {
+	passwords := make([]string, n)
+	for i := range passwords {
+		buf := make([]byte, 16)
+		_, err := rand.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		passwords[i] = PasswordPrefix + hex.EncodeToString(buf)
+	}
+	return passwords, nil
+}
+
+// Event is a single authentication attempt of a campaign.
+type Event struct {
+	Timestamp time.Time `json:"timestamp"`
+	IP        string    `json:"ip"`
+	Region    string    `json:"region,omitempty"`
+	Username  string    `json:"username"`
+	Provider  string    `json:"provider"`
+
+	// Outcome is one of invalid, valid, locked, rate_limited, captcha or
+	// error
+	Outcome string `json:"outcome"`
+}
+
+func outcome(res *db.Result) string {
+	switch {
+	case res.Error != "":
+		return "error"
+	case res.RateLimited:
+		return "rate_limited"
+	case res.Captcha:
+		return "captcha"
+	case res.Locked:
+		return "locked"
+	case res.Valid:
+		return "valid"
+	}
+	return "invalid"
+}
+
+// Timeline returns the attempts of a campaign in chronological order.
+func Timeline(campaign db.Campaign, results []db.Result) []Event {
+	events := make([]Event, 0, len(results))
+	for i := range results {
+		res := &results[i]
+		events = append(events, Event{
+			Timestamp: res.Timestamp,
+			IP:        res.IP,
+			Region:    res.Region,
+			Username:  res.Username,
+			Provider:  campaign.Provider,
+			Outcome:   outcome(res),
+		})
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Timestamp.Before(events[j].Timestamp)
+	})
+	return events
+}
+
+// Alert is an alert raised by the defenders for an account.
+type Alert struct {
+	Timestamp time.Time
+	Username  string
+}
+
+// ReadAlerts reads alerts from a CSV export with a header row. the timestamp
+// column (named like "time") must be in RFC 3339 format and the account
+// column may be named "username", "user" or "account".
+func ReadAlerts(r io.Reader) ([]Alert, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	ts, user := -1, -1
+	for i, col := range header {
+		col = strings.ToLower(col)
+		switch {
+		case strings.Contains(col, "time") && ts < 0:
+			ts = i
+		case (strings.Contains(col, "user") || strings.Contains(col, "account")) && user < 0:
+			user = i
+		}
+	}
+	if ts < 0 || user < 0 {
+		return nil, fmt.Errorf("alerts require a timestamp and a username column")
+	}
+
+	var alerts []Alert
+	for {
+		rec, err := reader.Read()
+		if err == io.EOF {
+			return alerts, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		t, err := time.Parse(time.RFC3339Nano, rec[ts])
+		if err != nil {
+			return nil, fmt.Errorf("invalid alert timestamp %q: %w", rec[ts], err)
+		}
+		alerts = append(alerts, Alert{Timestamp: t, Username: rec[user]})
+	}
+}
+
+// Coverage measures how many of the accounts attempted by a campaign were
+// detected, and how quickly.
+type Coverage struct {
+	// Accounts is the number of accounts attempted
+	Accounts int `json:"accounts"`
+
+	// Detected is the number of accounts with an alert raised after their
+	// first attempt
+	Detected int `json:"detected"`
+
+	// Coverage is the ratio of detected accounts
+	Coverage float64 `json:"coverage"`
+
+	// MeanLatency and MaxLatency are the delays between the first attempt
+	// against an account and its first alert
+	MeanLatency time.Duration `json:"mean_latency"`
+	MaxLatency  time.Duration `json:"max_latency"`
+
+	// Undetected lists the accounts without an alert
+	Undetected []string `json:"undetected"`
+}
+
+// account normalizes a username so that alerts reported with or without a
+// domain (alice@example.org, EXAMPLE\alice, alice) match the same account.
+func account(username string) string {
+	username = strings.ToLower(strings.TrimSpace(username))
+	if i := strings.IndexByte(username, '@'); i >= 0 {
+		username = username[:i]
+	}
+	if i := strings.LastIndexByte(username, '\\'); i >= 0 {
+		username = username[i+1:]
+	}
+	return username
+}
+
+// Evaluate matches alerts against the timeline of a campaign. an account is
+// detected if an alert was raised for it at or after its first attempt.
+func Evaluate(events []Event, alerts []Alert) Coverage {
+	first := make(map[string]time.Time)
+	names := make(map[string]string)
+	for _, e := range events {
+		a := account(e.Username)
+		if t, ok := first[a]; !ok || e.Timestamp.Before(t) {
+			first[a] = e.Timestamp
+			names[a] = e.Username
+		}
+	}
+
+	detected := make(map[string]time.Time)
+	for _, alert := range alerts {
+		a := account(alert.Username)
+		start, ok := first[a]
+		if !ok || alert.Timestamp.Before(start) {
+			continue
+		}
+		if t, ok := detected[a]; !ok || alert.Timestamp.Before(t) {
+			detected[a] = alert.Timestamp
+		}
+	}
+
+	c := Coverage{
+		Accounts:   len(first),
+		Detected:   len(detected),
+		Undetected: []string{},
+	}
+	var total time.Duration
+	for a, start := range first {
+		t, ok := detected[a]
+		if !ok {
+			c.Undetected = append(c.Undetected, names[a])
+			continue
+		}
+		latency := t.Sub(start)
+		total += latency
+		if latency > c.MaxLatency {
+			c.MaxLatency = latency
+		}
+	}
+	sort.Strings(c.Undetected)
+	if c.Accounts > 0 {
+		c.Coverage = float64(c.Detected) / float64(c.Accounts)
+	}
+	if c.Detected > 0 {
+		c.MeanLatency = total / time.Duration(c.Detected)
+	}
+	return c
+}
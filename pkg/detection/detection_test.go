@@ -0,0 +1,91 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package detection
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+)
+
+func TestPasswords(t *testing.T) {
+	passwords, err := Passwords(3)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(passwords) != 3 || passwords[0] == passwords[1] {
+		t.Errorf("expected 3 distinct passwords, got %v", passwords)
+	}
+	for _, p := range passwords {
+		if !strings.HasPrefix(p, PasswordPrefix) {
+			t.Errorf("password %s is missing the prefix", p)
+		}
+	}
+}
+
+func TestTimeline(t *testing.T) {
+	start := time.Date(2020, 10, 1, 9, 0, 0, 0, time.UTC)
+	events := Timeline(db.Campaign{Provider: "okta"}, []db.Result{
+		{Timestamp: start.Add(time.Minute), Username: "bob", Locked: true},
+		{Timestamp: start, Username: "alice", IP: "192.0.2.1"},
+		{Timestamp: start.Add(2 * time.Minute), Username: "carol", Error: "timeout"},
+	})
+
+	expected := []string{"alice:invalid", "bob:locked", "carol:error"}
+	for i, e := range events {
+		if actual := e.Username + ":" + e.Outcome; actual != expected[i] || e.Provider != "okta" {
+			t.Errorf("event %d: expected %s, got %+v", i, expected[i], e)
+		}
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	start := time.Date(2020, 10, 1, 9, 0, 0, 0, time.UTC)
+	events := []Event{
+		{Timestamp: start, Username: "alice@example.org"},
+		{Timestamp: start.Add(time.Minute), Username: "alice@example.org"},
+		{Timestamp: start, Username: "bob@example.org"},
+		{Timestamp: start, Username: "carol@example.org"},
+	}
+
+	alerts, err := ReadAlerts(strings.NewReader(`Time,Account,Severity
+2020-10-01T09:05:00Z,EXAMPLE\alice,high
+2020-10-01T09:02:00Z,alice,low
+2020-10-01T08:00:00Z,bob@example.org,low
+2020-10-01T09:10:00Z,carol@example.org,low
+2020-10-01T09:01:00Z,dave@example.org,low
+`))
+	if err != nil {
+		t.Fatalf("unexpected error reading alerts: %s", err)
+	}
+
+	c := Evaluate(events, alerts)
+	if c.Accounts != 3 || c.Detected != 2 {
+		t.Errorf("expected 2 of 3 accounts detected, got %+v", c)
+	}
+	if c.MeanLatency != 6*time.Minute || c.MaxLatency != 10*time.Minute {
+		t.Errorf("unexpected latencies %s, %s", c.MeanLatency, c.MaxLatency)
+	}
+	if len(c.Undetected) != 1 || c.Undetected[0] != "bob@example.org" {
+		t.Errorf("expected bob to be undetected, got %v", c.Undetected)
+	}
+
+	_, err = ReadAlerts(strings.NewReader("user\nalice\n"))
+	if err == nil {
+		t.Errorf("expected an error without a timestamp column")
+	}
+}
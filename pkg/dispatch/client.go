@@ -33,6 +33,15 @@ type WorkerClient interface {
 	Submit(event.AuthRequest) (*event.AuthResponse, error)
 }
 
+// BatchWorkerClient is implemented by worker clients which can submit several
+// tasks in a single invocation. Results must be returned in the same order as
+// the requests. the dispatcher groups up to BatchSize() tasks per call.
+type BatchWorkerClient interface {
+	WorkerClient
+	BatchSize() int
+	SubmitBatch([]event.AuthRequest) ([]*event.AuthResponse, error)
+}
+
 // Driver is an interface which wraps the creation of a WorkerClient.
 type Driver interface {
 	New(opts map[string]string) (WorkerClient, error)
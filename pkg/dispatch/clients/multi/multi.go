@@ -0,0 +1,191 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package multi
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/praetorian-inc/trident/pkg/dispatch"
+	"github.com/praetorian-inc/trident/pkg/event"
+	"github.com/praetorian-inc/trident/pkg/retry"
+)
+
+func init() {
+	dispatch.Register("multi", Driver{})
+}
+
+// Driver implements the dispatch.WorkerClient interface.
+type Driver struct{}
+
+// Worker configures one of the workers of a multi worker client.
+type Worker struct {
+	// Driver is the worker client driver name (e.g. webhook)
+	Driver string `json:"driver"`
+
+	// Config is passed to the worker client driver
+	Config dispatch.WorkerOptions `json:"config"`
+
+	// Weight is the share of tasks sent to this worker (defaults to 1)
+	Weight int `json:"weight"`
+}
+
+// New is used to create a worker client which spreads tasks across several
+// workers, such as webhook workers hosted in different clouds, and accepts the
+// following configuration options:
+//  workers:    a JSON list of workers, each with a "driver", its "config"
+//              and an optional "weight".
+//  batch_size: the number of tasks grouped per batch (defaults to the largest
+//              batch size of the workers).
+func (Driver) New(opts map[string]string) (dispatch.WorkerClient, error) {
+	raw, ok := opts["workers"]
+	if !ok {
+		return nil, fmt.Errorf("multi client requires 'workers' config parameter")
+	}
+
+	var workers []Worker
+	err := json.Unmarshal([]byte(raw), &workers)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing workers: %w", err)
+	}
+	if len(workers) == 0 {
+		return nil, fmt.Errorf("multi client requires at least one worker")
+	}
+
+	c := &Client{Batch: 1}
+	for i, w := range workers {
+		if w.Driver == "multi" {
+			return nil, fmt.Errorf("multi client workers cannot be nested")
+		}
+		wc, err := dispatch.Open(w.Driver, w.Config)
+		if err != nil {
+			return nil, fmt.Errorf("error opening worker %d: %w", i, err)
+		}
+		c.Workers = append(c.Workers, wc)
+
+		weight := w.Weight
+		if weight == 0 {
+			weight = 1
+		}
+		if weight < 0 {
+			return nil, fmt.Errorf("worker %d has a negative weight", i)
+		}
+		for j := 0; j < weight; j++ {
+			c.schedule = append(c.schedule, i)
+		}
+
+		if b, ok := wc.(dispatch.BatchWorkerClient); ok && b.BatchSize() > c.Batch {
+			c.Batch = b.BatchSize()
+		}
+	}
+
+	if v, ok := opts["batch_size"]; ok {
+		size, err := strconv.Atoi(v)
+		if err != nil || size < 1 {
+			return nil, fmt.Errorf("multi client requires a positive integer 'batch_size'")
+		}
+		c.Batch = size
+	}
+
+	return c, nil
+}
+
+// Client implements the dispatch.BatchWorkerClient interface by sending
+// tasks to its workers in weighted round-robin order.
+type Client struct {
+	// Workers are the underlying worker clients
+	Workers []dispatch.WorkerClient
+
+	// Batch is the number of tasks grouped per batch
+	Batch int
+
+	// schedule lists worker indices, each repeated by the worker's weight
+	schedule []int
+	next     uint64
+}
+
+// worker returns the next worker in the schedule.
+func (c *Client) worker() dispatch.WorkerClient {
+	n := atomic.AddUint64(&c.next, 1) - 1
+	return c.Workers[c.schedule[n%uint64(len(c.schedule))]]
+}
+
+// BatchSize fulfils the dispatch.BatchWorkerClient interface.
+func (c *Client) BatchSize() int {
+	return c.Batch
+}
+
+// Submit fulfils the dispatch.WorkerClient interface and submits a task to the
+// next worker.
+func (c *Client) Submit(r event.AuthRequest) (*event.AuthResponse, error) {
+	return c.worker().Submit(r)
+}
+
+// SubmitBatch fulfils the dispatch.BatchWorkerClient interface and submits a
+// batch to the next worker, split into batches of the worker's size. Workers
+// which do not support batching receive each task individually. Failures are
+// returned as error results of the failed tasks (or the tasks of the failed
+// batch) only.
+func (c *Client) SubmitBatch(reqs []event.AuthRequest) ([]*event.AuthResponse, error) {
+	wc := c.worker()
+	if b, ok := wc.(dispatch.BatchWorkerClient); ok && b.BatchSize() > 1 {
+		var resps []*event.AuthResponse
+		for start := 0; start < len(reqs); start += b.BatchSize() {
+			end := start + b.BatchSize()
+			if end > len(reqs) {
+				end = len(reqs)
+			}
+			batch, err := b.SubmitBatch(reqs[start:end])
+			if err == nil && len(batch) != end-start {
+				err = retry.Errorf(retry.ClassParse, "worker returned %d results for %d tasks", len(batch), end-start)
+			}
+			if err != nil {
+				batch = make([]*event.AuthResponse, end-start)
+				for i := range batch {
+					batch[i] = errorResponse(err)
+				}
+			}
+			resps = append(resps, batch...)
+		}
+		return resps, nil
+	}
+
+	resps := make([]*event.AuthResponse, len(reqs))
+	var wg sync.WaitGroup
+	for i := range reqs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, err := wc.Submit(reqs[i])
+			if err != nil {
+				resp = errorResponse(err)
+			}
+			resps[i] = resp
+		}(i)
+	}
+	wg.Wait()
+	return resps, nil
+}
+
+// errorResponse returns the result of a task which failed with err.
+func errorResponse(err error) *event.AuthResponse {
+	return &event.AuthResponse{
+		Error:      err.Error(),
+		ErrorClass: string(retry.Classify(err)),
+	}
+}
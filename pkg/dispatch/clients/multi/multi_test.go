@@ -0,0 +1,163 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package multi
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/praetorian-inc/trident/pkg/dispatch"
+	"github.com/praetorian-inc/trident/pkg/event"
+)
+
+type fakeDriver struct{}
+
+func (fakeDriver) New(opts map[string]string) (dispatch.WorkerClient, error) {
+	return &fakeWorker{name: opts["name"]}, nil
+}
+
+type fakeWorker struct {
+	name string
+}
+
+func (w *fakeWorker) Submit(r event.AuthRequest) (*event.AuthResponse, error) {
+	if r.Username == "fail" {
+		return nil, errors.New("worker failed")
+	}
+	return &event.AuthResponse{Username: r.Username, IP: w.name}, nil
+}
+
+// fakeBatchWorker fails batches which contain a task of the user "fail".
+type fakeBatchWorker struct {
+	fakeWorker
+	batches int
+}
+
+func (w *fakeBatchWorker) BatchSize() int {
+	return 2
+}
+
+func (w *fakeBatchWorker) SubmitBatch(reqs []event.AuthRequest) ([]*event.AuthResponse, error) {
+	w.batches++
+	var resps []*event.AuthResponse
+	for _, r := range reqs {
+		resp, err := w.Submit(r)
+		if err != nil {
+			return nil, err
+		}
+		resps = append(resps, resp)
+	}
+	return resps, nil
+}
+
+func init() {
+	dispatch.Register("fake", fakeDriver{})
+}
+
+func TestSubmit(t *testing.T) {
+	wc, err := Driver{}.New(map[string]string{
+		"workers": `[{"driver":"fake","config":{"name":"aws"},"weight":2},{"driver":"fake","config":{"name":"azure"}}]`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts := make(map[string]int)
+	for i := 0; i < 6; i++ {
+		resp, err := wc.Submit(event.AuthRequest{Username: "alice"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[resp.IP]++
+	}
+	if counts["aws"] != 4 || counts["azure"] != 2 {
+		t.Errorf("unexpected task distribution: %v", counts)
+	}
+}
+
+func TestSubmitBatch(t *testing.T) {
+	wc, err := Driver{}.New(map[string]string{
+		"workers":    `[{"driver":"fake","config":{"name":"aws"}}]`,
+		"batch_size": "2",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := wc.(*Client)
+	if c.BatchSize() != 2 {
+		t.Errorf("got batch size %d, want 2", c.BatchSize())
+	}
+
+	resps, err := c.SubmitBatch([]event.AuthRequest{{Username: "alice"}, {Username: "fail"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resps[0].Username != "alice" || resps[0].Error != "" {
+		t.Errorf("unexpected first result: %+v", resps[0])
+	}
+	if resps[1].Error != "worker failed" {
+		t.Errorf("expected failed task to be returned as an error result, got %+v", resps[1])
+	}
+}
+
+func TestSubmitBatchChunks(t *testing.T) {
+	w := &fakeBatchWorker{fakeWorker: fakeWorker{name: "aws"}}
+	c := &Client{Workers: []dispatch.WorkerClient{w}, Batch: 5, schedule: []int{0}}
+
+	resps, err := c.SubmitBatch([]event.AuthRequest{
+		{Username: "alice"}, {Username: "bob"},
+		{Username: "carol"}, {Username: "fail"},
+		{Username: "dave"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.batches != 3 || len(resps) != 5 {
+		t.Fatalf("got %d results in %d batches, want 5 results in 3 batches", len(resps), w.batches)
+	}
+	for i, want := range []string{"alice", "bob", "", "", "dave"} {
+		if want == "" {
+			if resps[i].Error != "worker failed" {
+				t.Errorf("expected task %d of the failed batch to be an error result, got %+v", i, resps[i])
+			}
+			continue
+		}
+		if resps[i].Username != want || resps[i].Error != "" {
+			t.Errorf("unexpected result %d: %+v", i, resps[i])
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	var tests = []struct {
+		name string
+		opts map[string]string
+	}{
+		{"missing workers", map[string]string{}},
+		{"no workers", map[string]string{"workers": "[]"}},
+		{"unknown driver", map[string]string{"workers": `[{"driver":"unknown"}]`}},
+		{"nested", map[string]string{"workers": `[{"driver":"multi"}]`}},
+		{"negative weight", map[string]string{"workers": `[{"driver":"fake","weight":-1}]`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := (Driver{}).New(tt.opts); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
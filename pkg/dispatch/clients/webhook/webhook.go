@@ -16,13 +16,21 @@ package webhook
 
 import (
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"net/url"
+	"path"
+	"strconv"
 
+	"github.com/praetorian-inc/trident/pkg/auth/token"
 	"github.com/praetorian-inc/trident/pkg/dispatch"
 	"github.com/praetorian-inc/trident/pkg/event"
+	"github.com/praetorian-inc/trident/pkg/retry"
 )
 
 func init() {
@@ -34,27 +42,107 @@ type Driver struct{}
 
 // New is used to create a webhook worker client and accepts the following
 // configuration options:
-//  url:    an HTTPS link to the webhook server.
-//  token:  a shared secret used to authenticate the client to the webhook server.
-//  header: the HTTP header used for authentication (defaults to X-Access-Token).
+//  url:         an HTTPS link to the webhook server.
+//  token:       a shared secret used to authenticate the client to the webhook
+//               server (legacy, prefer signing_key).
+//  header:      the HTTP header used for authentication (defaults to
+//               X-Access-Token, or X-Trident-Token when signing_key is set).
+//  signing_key: a shared secret used to mint short-lived, request-bound
+//               tokens. signing keys rotate automatically every hour.
+//  cert, key:   paths to a PEM client certificate and key used for mutual TLS.
+//  ca:          path to a PEM CA bundle used to verify the webhook server.
+//  batch_size:  the number of tasks sent per request to the server's /batch
+//               endpoint (defaults to 1, i.e. no batching).
+// Either token or signing_key must be provided.
 func (Driver) New(opts map[string]string) (dispatch.WorkerClient, error) {
 	url, ok := opts["url"]
 	if !ok {
 		return nil, fmt.Errorf("webhook client requires 'url' config parameter")
 	}
-	token, ok := opts["token"]
-	if !ok {
-		return nil, fmt.Errorf("webhook client requires 'token' config parameter")
+
+	c := &Client{
+		URL:        url,
+		HTTPClient: http.DefaultClient,
+		Batch:      1,
 	}
-	header, ok := opts["header"]
-	if !ok {
-		header = "X-Access-Token"
+
+	if v, ok := opts["batch_size"]; ok {
+		size, err := strconv.Atoi(v)
+		if err != nil || size < 1 {
+			return nil, fmt.Errorf("webhook client requires a positive integer 'batch_size'")
+		}
+		c.Batch = size
+	}
+
+	if key, ok := opts["signing_key"]; ok {
+		c.Signer = &token.Signer{
+			Secret: []byte(key),
+			Header: opts["header"],
+		}
+	} else {
+		tok, ok := opts["token"]
+		if !ok {
+			return nil, fmt.Errorf("webhook client requires 'token' or 'signing_key' config parameter")
+		}
+		header, ok := opts["header"]
+		if !ok {
+			header = "X-Access-Token"
+		}
+		c.Header = header
+		c.Token = tok
+	}
+
+	tlsConfig, err := clientTLSConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		c.HTTPClient = &http.Client{
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		}
+	}
+
+	return c, nil
+}
+
+// clientTLSConfig builds a TLS configuration for mutual TLS from the cert, key
+// and ca options. nil is returned if none of these options are set.
+func clientTLSConfig(opts map[string]string) (*tls.Config, error) {
+	certFile, hasCert := opts["cert"]
+	keyFile, hasKey := opts["key"]
+	caFile, hasCA := opts["ca"]
+	if !hasCert && !hasKey && !hasCA {
+		return nil, nil
+	}
+	if hasCert != hasKey {
+		return nil, fmt.Errorf("webhook client requires both 'cert' and 'key' for mutual TLS")
+	}
+
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if hasCert {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if hasCA {
+		pem, err := ioutil.ReadFile(caFile) // nolint:gosec
+		if err != nil {
+			return nil, fmt.Errorf("error reading ca bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in ca bundle %s", caFile)
+		}
+		config.RootCAs = pool
 	}
-	return &Client{
-		URL:    url,
-		Header: header,
-		Token:  token,
-	}, nil
+
+	return config, nil
 }
 
 // Client implements the dispatch.WorkerClient interface for webhooks.
@@ -68,34 +156,97 @@ type Client struct {
 
 	// Token is an authorization token used to communicate with the worker
 	Token string
+
+	// Signer mints short-lived tokens for each request. When set, Header and
+	// Token are ignored.
+	Signer *token.Signer
+
+	// HTTPClient is the client used to send requests (configured for mutual
+	// TLS when a client certificate is provided)
+	HTTPClient *http.Client
+
+	// Batch is the number of tasks sent per request to the batch endpoint
+	Batch int
+}
+
+// BatchSize fulfils the dispatch.BatchWorkerClient interface.
+func (w *Client) BatchSize() int {
+	return w.Batch
 }
 
 // Submit fulfils the dispatch.WorkerClient interface and submits a task to the
 // configured webhook server.
 func (w *Client) Submit(r event.AuthRequest) (*event.AuthResponse, error) {
-	data, _ := json.Marshal(r)
-	req, err := http.NewRequest("POST", w.URL, bytes.NewBuffer(data))
+	var res event.AuthResponse
+	err := w.post(w.URL, r, &res)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set(w.Header, w.Token)
+	return &res, nil
+}
 
-	resp, err := http.DefaultClient.Do(req)
+// SubmitBatch fulfils the dispatch.BatchWorkerClient interface and submits
+// several tasks to the webhook server's /batch endpoint.
+func (w *Client) SubmitBatch(reqs []event.AuthRequest) ([]*event.AuthResponse, error) {
+	u, err := url.Parse(w.URL)
 	if err != nil {
 		return nil, err
 	}
+	u.Path = path.Join("/", u.Path, "batch")
+
+	var res event.BatchResponse
+	err = w.post(u.String(), event.BatchRequest{Tasks: reqs}, &res)
+	if err != nil {
+		return nil, err
+	}
+
+	resps := make([]*event.AuthResponse, len(res.Results))
+	for i := range res.Results {
+		resps[i] = &res.Results[i]
+	}
+	return resps, nil
+}
+
+// post sends an authenticated JSON request to the webhook server and decodes
+// the response into v.
+func (w *Client) post(target string, body, v interface{}) error {
+	data, _ := json.Marshal(body)
+	req, err := http.NewRequest("POST", target, bytes.NewBuffer(data))
+	if err != nil {
+		return err
+	}
+	if w.Signer != nil {
+		err = w.Signer.Auth(req)
+		if err != nil {
+			return err
+		}
+	} else {
+		req.Header.Set(w.Header, w.Token)
+	}
+
+	resp, err := w.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
 	defer resp.Body.Close() // nolint:errcheck
 
 	if resp.StatusCode != 200 {
 		var res event.ErrorResponse
 		err = json.NewDecoder(resp.Body).Decode(&res)
 		if err != nil {
-			return nil, err
+			// the error was not returned by the worker (e.g. a load balancer)
+			return retry.Errorf(retry.ClassifyStatus(resp.StatusCode),
+				"unexpected status code from worker: %d", resp.StatusCode)
+		}
+		if res.Class == "" {
+			res.Class = string(retry.ClassifyStatus(resp.StatusCode))
 		}
-		return nil, errors.New(res.ErrorMsg)
+		return retry.New(retry.Class(res.Class), errors.New(res.ErrorMsg))
 	}
 
-	var res event.AuthResponse
-	err = json.NewDecoder(resp.Body).Decode(&res)
-	return &res, err
+	err = json.NewDecoder(resp.Body).Decode(v)
+	if err != nil {
+		return retry.New(retry.ClassParse, err)
+	}
+	return nil
 }
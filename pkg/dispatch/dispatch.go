@@ -39,85 +39,522 @@ package dispatch
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"cloud.google.com/go/pubsub"
-
 	"github.com/praetorian-inc/trident/pkg/event"
+	"github.com/praetorian-inc/trident/pkg/queue"
+	"github.com/praetorian-inc/trident/pkg/retry"
 )
 
 // Dispatcher creates a data pipeline which accepts tasks, sends them to a
 // worker, and publishes the result. This pipeline can be visualized as:
-//  PubSub Subscription --> WorkerClient --> PubSub Topic
+//  Queue Subscription --> WorkerClient --> Queue Topic
 type Dispatcher struct {
 	wc WorkerClient
 
-	sub     *pubsub.Subscription
-	resultc *pubsub.Topic
+	batchTimeout time.Duration
+	drainTimeout time.Duration
+
+	claims       Claimer
+	claimRenewal time.Duration
+
+	// the claims of the tasks held by the dispatcher, renewed until their
+	// messages are acknowledged or returned to the queue
+	heldMu sync.Mutex
+	held   map[string]struct{}
+
+	sub     queue.Subscription
+	resultc queue.Topic
+
+	// submitted tasks whose results are not yet published
+	wg       sync.WaitGroup
+	inflight int64
 }
 
 // Options is used to configure a Dispatcher
 type Options struct {
 
-	// ProjectID is the Google Cloud Platform project ID
+	// BatchTimeout is the longest a task waits for a batch to fill before
+	// the partial batch is submitted (defaults to DefaultBatchTimeout)
+	BatchTimeout time.Duration
+
+	// DrainTimeout is the longest the dispatcher waits for in-flight tasks
+	// once it is shutting down (defaults to DefaultDrainTimeout)
+	DrainTimeout time.Duration
+
+	// MaxOutstanding limits the number of tasks handled at once (defaults to
+	// queue.DefaultMaxOutstanding, or four batches for batching workers)
+	MaxOutstanding int
+
+	// Queue is the name of the queue driver (defaults to "pubsub")
+	Queue string
+
+	// QueueConfig configures the queue driver
+	QueueConfig queue.Options
+
+	// ProjectID is the Google Cloud Platform project ID, used as the
+	// project_id of the pubsub queue driver if it is not configured
 	ProjectID string
 
-	// SubscriptionID is the Pub/Sub subscription used by the dispatcher to
-	// listen for incoming tasks.
+	// SubscriptionID is the subscription used by the dispatcher to listen for
+	// incoming tasks.
 	SubscriptionID string
 
-	// ResultTopicID is the Pub/Sub topic ID used by the dispatcher to publish
-	// results..
+	// ResultTopicID is the topic used by the dispatcher to publish results.
 	ResultTopicID string
+
+	// ResultQueue is the name of the queue driver results are published to
+	// (defaults to Queue), e.g. "webhook" to post results to the orchestrator
+	ResultQueue string
+
+	// ResultQueueConfig configures the result queue driver (defaults to
+	// QueueConfig)
+	ResultQueueConfig queue.Options
+
+	// Claims, if set, claims each task before it is submitted so that a task
+	// is never executed twice (e.g. when its message is redelivered)
+	Claims Claimer
+
+	// ClaimRenewal is how often the claims of the tasks held by the
+	// dispatcher are renewed (defaults to DefaultClaimRenewal)
+	ClaimRenewal time.Duration
+}
+
+// Claimer claims tasks by their idempotency key. ClaimTask returns false if
+// the task was already claimed. RenewClaims renews the claims of tasks in
+// flight, which are only taken over once they are no longer renewed.
+// ReleaseClaim releases the claim of a task which was returned to the queue
+// without being executed.
+type Claimer interface {
+	ClaimTask(key string, campaignID uint) (bool, error)
+	RenewClaims(keys []string) error
+	ReleaseClaim(key string) error
 }
 
 // NewDispatcher creates a dispatcher based on the provided options and worker.
 func NewDispatcher(ctx context.Context, opts Options, wc WorkerClient) (*Dispatcher, error) {
-	client, err := pubsub.NewClient(ctx, opts.ProjectID)
+	batchTimeout := opts.BatchTimeout
+	if batchTimeout == 0 {
+		batchTimeout = DefaultBatchTimeout
+	}
+	drainTimeout := opts.DrainTimeout
+	if drainTimeout == 0 {
+		drainTimeout = DefaultDrainTimeout
+	}
+
+	settings := queue.Settings{MaxOutstanding: opts.MaxOutstanding}
+	if b, ok := wc.(BatchWorkerClient); ok && b.BatchSize() > 1 && settings.MaxOutstanding == 0 {
+		// allow several batches to be in flight at once
+		settings.MaxOutstanding = 4 * b.BatchSize()
+	}
+
+	driver, config := queue.Config(opts.Queue, opts.QueueConfig, opts.ProjectID)
+	sub, err := queue.OpenSubscription(driver, opts.SubscriptionID, settings, config)
+	if err != nil {
+		return nil, err
+	}
+	if opts.ResultQueue != "" {
+		driver, config = queue.Config(opts.ResultQueue, opts.ResultQueueConfig, opts.ProjectID)
+	}
+	resultc, err := queue.OpenTopic(driver, opts.ResultTopicID, config)
 	if err != nil {
 		return nil, err
 	}
-
-	sub := client.Subscription(opts.SubscriptionID)
-	sub.ReceiveSettings.Synchronous = true
-	sub.ReceiveSettings.MaxOutstandingMessages = 10
 
 	return &Dispatcher{
-		wc:      wc,
-		sub:     sub,
-		resultc: client.Topic(opts.ResultTopicID),
+		wc:           wc,
+		batchTimeout: batchTimeout,
+		drainTimeout: drainTimeout,
+		claims:       opts.Claims,
+		claimRenewal: opts.ClaimRenewal,
+		sub:          sub,
+		resultc:      resultc,
 	}, nil
 }
 
-// Listen listens for task messages on the Pub/Sub subscription. Tasks are sent
-// to the worker and results are then published to the Pub/Sub topic.
-func (d *Dispatcher) Listen(ctx context.Context) error {
-	return d.sub.Receive(ctx, func(ctx context.Context, msg *pubsub.Message) {
-		// always ACK messages to avoid infinite loop handling a bad message
-		defer msg.Ack()
+// Backlog returns the number of tasks waiting in the dispatcher's
+// subscription, or queue.ErrBacklogUnsupported if the queue cannot report it.
+func (d *Dispatcher) Backlog(ctx context.Context) (int64, error) {
+	return queue.Backlog(ctx, d.sub)
+}
 
-		var req event.AuthRequest
-		err := json.Unmarshal(msg.Data, &req)
-		if err != nil {
-			log.Printf("error unmarshaling: %s", err)
+// DefaultBatchTimeout is the default longest time a task waits for a batch
+// to fill.
+const DefaultBatchTimeout = time.Second
+
+// DefaultDrainTimeout is the default longest time a shutting down dispatcher
+// waits for in-flight tasks, within the default 30s grace period of
+// Kubernetes pods.
+const DefaultDrainTimeout = 25 * time.Second
+
+// DefaultClaimRenewal is the default interval at which the claims of the
+// tasks held by a dispatcher are renewed, well within db.ClaimLease.
+const DefaultClaimRenewal = 30 * time.Second
+
+// ErrDrainTimeout is returned by Listen if in-flight tasks did not complete
+// within the drain timeout. their messages are redelivered.
+var ErrDrainTimeout = errors.New("dispatch: timed out waiting for in-flight tasks")
+
+// decode parses a task message. false is returned if the task is invalid or
+// has expired, in which case the message is acknowledged and dropped.
+func decode(msg *queue.Message) (event.AuthRequest, bool) {
+	var req event.AuthRequest
+	err := json.Unmarshal(msg.Data, &req)
+	if err != nil {
+		log.Printf("error unmarshaling: %s", err)
+		msg.Ack()
+		return req, false
+	}
+
+	if time.Now().After(req.NotAfter) {
+		msg.Ack()
+		return req, false
+	}
+	return req, true
+}
+
+// claim claims a task before it is submitted. false is returned if the task
+// was already claimed, in which case the message is acknowledged and dropped,
+// or if the claim failed, in which case the message is redelivered later.
+func (d *Dispatcher) claim(msg *queue.Message, req event.AuthRequest) bool {
+	if d.claims == nil || req.Key == "" {
+		return true
+	}
+
+	ok, err := d.claims.ClaimTask(req.Key, req.CampaignID)
+	if err != nil {
+		log.Printf("error claiming task: %s", err)
+		msg.Nack()
+		return false
+	}
+	if !ok {
+		log.Printf("skipping task %s: already claimed", req.Key)
+		msg.Ack()
+		return false
+	}
+
+	d.heldMu.Lock()
+	if d.held == nil {
+		d.held = make(map[string]struct{})
+	}
+	d.held[req.Key] = struct{}{}
+	d.heldMu.Unlock()
+	return true
+}
+
+// settle stops renewing the claim of a task once its message has been
+// acknowledged or returned to the queue.
+func (d *Dispatcher) settle(req event.AuthRequest) {
+	d.heldMu.Lock()
+	delete(d.held, req.Key)
+	d.heldMu.Unlock()
+}
+
+// renewClaims renews the claims of the tasks held by the dispatcher every
+// claim renewal interval until stop is closed.
+func (d *Dispatcher) renewClaims(stop chan struct{}) {
+	interval := d.claimRenewal
+	if interval == 0 {
+		interval = DefaultClaimRenewal
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
 			return
+		case <-ticker.C:
 		}
 
-		ts := time.Now()
-		if ts.After(req.NotAfter) {
-			return
+		d.heldMu.Lock()
+		keys := make([]string, 0, len(d.held))
+		for key := range d.held {
+			keys = append(keys, key)
 		}
+		d.heldMu.Unlock()
 
-		resp, err := d.wc.Submit(req)
+		if len(keys) == 0 {
+			continue
+		}
+		if err := d.claims.RenewClaims(keys); err != nil {
+			log.Printf("error renewing claims: %s", err)
+		}
+	}
+}
+
+// PublishTimeout is the longest the dispatcher waits for a result to be
+// published.
+const PublishTimeout = 30 * time.Second
+
+// release returns a task which was not submitted to the queue, e.g. because
+// the dispatcher is shutting down, releasing its claim so that it runs when it
+// is redelivered.
+func (d *Dispatcher) release(msg *queue.Message, req event.AuthRequest) {
+	if d.claims != nil && req.Key != "" {
+		d.settle(req)
+		err := d.claims.ReleaseClaim(req.Key)
 		if err != nil {
-			log.Printf("error from worker: %s", err)
+			log.Printf("error releasing claim of task %s: %s", req.Key, err)
+		}
+	}
+	msg.Nack()
+}
+
+// publish publishes the result of a task. if the worker failed, an error
+// result is published so that the failure is tracked by the orchestrator.
+// results are published even if the dispatcher is shutting down so that
+// in-flight tasks are not lost.
+func (d *Dispatcher) publish(req event.AuthRequest, ts time.Time, resp *event.AuthResponse, err error) {
+	if err != nil {
+		log.Printf("error from worker: %s", err)
+		resp = &event.AuthResponse{
+			Error:      err.Error(),
+			ErrorClass: string(retry.Classify(err)),
+		}
+	}
+	if resp.Error != "" {
+		resp.CampaignID = req.CampaignID
+		resp.CredentialID = req.CredentialID
+		resp.Timestamp = ts
+		resp.Username = req.Username
+		resp.Password = req.Password
+		resp.Task = &req
+	}
+	if resp.Captcha {
+		// the scheduler retries challenged tasks once the user's backoff
+		// expires
+		resp.Task = &req
+	}
+	resp.Key = req.Key
+
+	ctx, cancel := context.WithTimeout(context.Background(), PublishTimeout)
+	defer cancel()
+
+	b, _ := json.Marshal(resp)
+	err = d.resultc.Publish(ctx, b)
+	if err != nil {
+		log.Printf("error publishing result: %s", err)
+	}
+}
+
+// Listen listens for task messages on the queue subscription. Tasks are sent
+// to the worker and results are then published to the result topic. if the
+// worker supports batching, tasks are grouped into batches.
+//
+// Once ctx is done, the dispatcher stops receiving tasks and returns those it
+// has not submitted to the queue. Listen returns once the tasks in flight
+// have completed and their results have been published, or ErrDrainTimeout
+// after the drain timeout.
+func (d *Dispatcher) Listen(ctx context.Context) error {
+	if d.claims != nil {
+		// claims are renewed until the tasks in flight are drained
+		stop := make(chan struct{})
+		defer close(stop)
+		go d.renewClaims(stop)
+	}
+
+	if b, ok := d.wc.(BatchWorkerClient); ok && b.BatchSize() > 1 {
+		return d.listenBatch(ctx, b)
+	}
+
+	return d.receive(ctx, func(msg *queue.Message) {
+		req, ok := decode(msg)
+		if !ok {
+			return
+		}
+		if ctx.Err() != nil {
+			// shutting down, the task is redelivered to another dispatcher
+			msg.Nack()
+			return
+		}
+		if !d.claim(msg, req) {
 			return
 		}
+		// always ACK messages to avoid infinite loop handling a bad message
+		defer d.settle(req)
+		defer msg.Ack()
+
+		d.start(1)
+		defer d.done(1)
+
+		ts := time.Now()
+		resp, err := d.wc.Submit(req)
+		d.publish(req, ts, resp, err)
+	}, nil)
+}
+
+// start and done track the tasks in flight.
+func (d *Dispatcher) start(n int) {
+	d.wg.Add(n)
+	atomic.AddInt64(&d.inflight, int64(n))
+}
 
-		b, _ := json.Marshal(resp)
-		d.resultc.Publish(ctx, &pubsub.Message{
-			Data: b,
+func (d *Dispatcher) done(n int) {
+	atomic.AddInt64(&d.inflight, -int64(n))
+	d.wg.Add(-n)
+}
+
+// receive receives task messages until ctx is done or the subscription
+// fails. stopped, if set, is called once the subscription stops delivering
+// messages, before waiting for the tasks in flight.
+func (d *Dispatcher) receive(ctx context.Context, f func(*queue.Message), stopped func()) error {
+	errc := make(chan error, 1)
+	go func() {
+		err := d.sub.Receive(ctx, func(_ context.Context, msg *queue.Message) {
+			f(msg)
 		})
+		if stopped != nil {
+			stopped()
+		}
+		d.wg.Wait()
+		errc <- err
+	}()
+
+	select {
+	case err := <-errc:
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Printf("draining %d in-flight tasks", atomic.LoadInt64(&d.inflight))
+	timer := time.NewTimer(d.drainTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-errc:
+		return nil
+	case <-timer.C:
+		log.Printf("%d tasks still in flight after %s", atomic.LoadInt64(&d.inflight), d.drainTimeout)
+		return ErrDrainTimeout
+	}
+}
+
+// pendingTask is a task waiting to be submitted as part of a batch.
+type pendingTask struct {
+	msg *queue.Message
+	req event.AuthRequest
+}
+
+// listenBatch groups incoming tasks into batches of up to b.BatchSize()
+// tasks, submitting partial batches after the batch timeout.
+func (d *Dispatcher) listenBatch(ctx context.Context, b BatchWorkerClient) error {
+	pending := make(chan pendingTask, b.BatchSize())
+	stop := make(chan struct{})
+	batched := make(chan struct{})
+
+	go func() {
+		defer close(batched)
+		d.batch(ctx, b, pending, stop)
+	}()
+
+	return d.receive(ctx, func(msg *queue.Message) {
+		req, ok := decode(msg)
+		if !ok {
+			return
+		}
+		if ctx.Err() != nil {
+			msg.Nack()
+			return
+		}
+		if !d.claim(msg, req) {
+			return
+		}
+		// the message is acknowledged once its batch has been submitted
+		pending <- pendingTask{msg: msg, req: req}
+	}, func() {
+		close(stop)
+		<-batched
 	})
 }
+
+// batch collects pending tasks into batches until ctx is done (or the
+// subscription fails). tasks which were not submitted by then, including
+// those received until stop is closed, are returned to the queue.
+func (d *Dispatcher) batch(ctx context.Context, b BatchWorkerClient, pending chan pendingTask, stop chan struct{}) {
+	size := b.BatchSize()
+	var batch []pendingTask
+	timer := time.NewTimer(d.batchTimeout)
+	timer.Stop()
+	defer timer.Stop()
+
+	flush := func() {
+		if len(batch) > 0 {
+			d.start(len(batch))
+			go d.submitBatch(b, batch)
+			batch = nil
+		}
+	}
+
+	for running := true; running; {
+		select {
+		case <-ctx.Done():
+			running = false
+		case <-stop:
+			running = false
+		case t := <-pending:
+			if len(batch) == 0 {
+				timer.Reset(d.batchTimeout)
+			}
+			batch = append(batch, t)
+			if len(batch) >= size {
+				if !timer.Stop() {
+					<-timer.C
+				}
+				flush()
+			}
+		case <-timer.C:
+			flush()
+		}
+	}
+
+	for _, t := range batch {
+		d.release(t.msg, t.req)
+	}
+	for {
+		select {
+		case t := <-pending:
+			d.release(t.msg, t.req)
+		case <-stop:
+			for len(pending) > 0 {
+				t := <-pending
+				d.release(t.msg, t.req)
+			}
+			return
+		}
+	}
+}
+
+// submitBatch submits a batch of tasks to the worker and publishes each
+// result.
+func (d *Dispatcher) submitBatch(b BatchWorkerClient, batch []pendingTask) {
+	defer d.done(len(batch))
+
+	reqs := make([]event.AuthRequest, len(batch))
+	for i, t := range batch {
+		reqs[i] = t.req
+	}
+
+	ts := time.Now()
+	resps, err := b.SubmitBatch(reqs)
+	if err == nil && len(resps) != len(reqs) {
+		err = retry.Errorf(retry.ClassParse, "worker returned %d results for %d tasks", len(resps), len(reqs))
+	}
+
+	for i, t := range batch {
+		var resp *event.AuthResponse
+		if err == nil {
+			resp = resps[i]
+		}
+		d.publish(t.req, ts, resp, err)
+		t.msg.Ack()
+		d.settle(t.req)
+	}
+}
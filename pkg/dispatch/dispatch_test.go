@@ -0,0 +1,325 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dispatch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/praetorian-inc/trident/pkg/event"
+	"github.com/praetorian-inc/trident/pkg/queue"
+)
+
+type testClaimer struct {
+	mu      sync.Mutex
+	claimed map[string]bool
+	renewed map[string]int
+	err     error
+}
+
+func (c *testClaimer) ClaimTask(key string, campaignID uint) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return false, c.err
+	}
+	if c.claimed[key] {
+		return false, nil
+	}
+	c.claimed[key] = true
+	return true, nil
+}
+
+func (c *testClaimer) RenewClaims(keys []string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.renewed == nil {
+		c.renewed = make(map[string]int)
+	}
+	for _, key := range keys {
+		c.renewed[key]++
+	}
+	return nil
+}
+
+func (c *testClaimer) renewals(key string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.renewed[key]
+}
+
+func (c *testClaimer) ReleaseClaim(key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.claimed, key)
+	return nil
+}
+
+func TestClaim(t *testing.T) {
+	claims := &testClaimer{claimed: make(map[string]bool)}
+	d := &Dispatcher{claims: claims}
+
+	var tests = []struct {
+		name     string
+		req      event.AuthRequest
+		err      error
+		want     bool
+		wantAck  bool
+		wantNack bool
+	}{
+		{"first delivery", event.AuthRequest{Key: "a"}, nil, true, false, false},
+		{"redelivery", event.AuthRequest{Key: "a"}, nil, false, true, false},
+		{"other task", event.AuthRequest{Key: "b"}, nil, true, false, false},
+		{"no key", event.AuthRequest{}, nil, true, false, false},
+		{"claim error", event.AuthRequest{Key: "c"}, errors.New("db down"), false, false, true},
+	}
+	for _, test := range tests {
+		claims.err = test.err
+		var acked, nacked bool
+		msg := queue.NewMessage(nil, func() { acked = true }, func() { nacked = true })
+		if got := d.claim(msg, test.req); got != test.want {
+			t.Errorf("[%s] got %t, want %t", test.name, got, test.want)
+		}
+		if acked != test.wantAck || nacked != test.wantNack {
+			t.Errorf("[%s] got ack %t and nack %t, want %t and %t",
+				test.name, acked, nacked, test.wantAck, test.wantNack)
+		}
+	}
+
+	if !(&Dispatcher{}).claim(queue.NewMessage(nil, nil, nil), event.AuthRequest{Key: "a"}) {
+		t.Error("expected tasks to run when claims are disabled")
+	}
+}
+
+func TestRenewClaims(t *testing.T) {
+	claims := &testClaimer{claimed: make(map[string]bool)}
+	d := &Dispatcher{claims: claims, claimRenewal: 5 * time.Millisecond}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go d.renewClaims(stop)
+
+	a, b := event.AuthRequest{Key: "a"}, event.AuthRequest{Key: "b"}
+	d.claim(queue.NewMessage(nil, nil, nil), a)
+	d.claim(queue.NewMessage(nil, nil, nil), b)
+	time.Sleep(50 * time.Millisecond)
+	if claims.renewals("a") == 0 || claims.renewals("b") == 0 {
+		t.Fatalf("claims of held tasks were not renewed")
+	}
+
+	// settled tasks are no longer renewed
+	d.settle(a)
+	d.release(queue.NewMessage(nil, nil, func() {}), b)
+	time.Sleep(10 * time.Millisecond)
+	na, nb := claims.renewals("a"), claims.renewals("b")
+	time.Sleep(50 * time.Millisecond)
+	if claims.renewals("a") != na || claims.renewals("b") != nb {
+		t.Errorf("claims of settled tasks were renewed")
+	}
+}
+
+// testSubscription delivers its messages, then the late messages once ctx is
+// done (as a subscription may while it stops).
+type testSubscription struct {
+	msgs, late []*queue.Message
+}
+
+func (s *testSubscription) Receive(ctx context.Context, f func(context.Context, *queue.Message)) error {
+	var wg sync.WaitGroup
+	deliver := func(msgs []*queue.Message) {
+		for _, m := range msgs {
+			wg.Add(1)
+			go func(m *queue.Message) {
+				defer wg.Done()
+				f(ctx, m)
+			}(m)
+		}
+	}
+	deliver(s.msgs)
+	<-ctx.Done()
+	deliver(s.late)
+	wg.Wait()
+	return nil
+}
+
+type testTopic struct {
+	mu        sync.Mutex
+	published int
+}
+
+func (t *testTopic) Publish(ctx context.Context, data []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.published++
+	return nil
+}
+
+// testWorker blocks every submission until release is closed.
+type testWorker struct {
+	size      int
+	started   chan struct{}
+	release   chan struct{}
+	submitted int
+	mu        sync.Mutex
+}
+
+func (w *testWorker) Submit(req event.AuthRequest) (*event.AuthResponse, error) {
+	resps, err := w.SubmitBatch([]event.AuthRequest{req})
+	if err != nil {
+		return nil, err
+	}
+	return resps[0], nil
+}
+
+func (w *testWorker) BatchSize() int { return w.size }
+
+func (w *testWorker) SubmitBatch(reqs []event.AuthRequest) ([]*event.AuthResponse, error) {
+	w.mu.Lock()
+	w.submitted += len(reqs)
+	w.mu.Unlock()
+	w.started <- struct{}{}
+	<-w.release
+	resps := make([]*event.AuthResponse, len(reqs))
+	for i := range resps {
+		resps[i] = &event.AuthResponse{}
+	}
+	return resps, nil
+}
+
+// testMessages returns n task messages along with their acknowledgements.
+func testMessages(prefix string, n int, acks map[string]string, mu *sync.Mutex) []*queue.Message {
+	msgs := make([]*queue.Message, n)
+	for i := range msgs {
+		key := fmt.Sprintf("%s%d", prefix, i)
+		b, _ := json.Marshal(event.AuthRequest{Key: key, NotAfter: time.Now().Add(time.Hour)})
+		set := func(v string) func() {
+			return func() {
+				mu.Lock()
+				defer mu.Unlock()
+				acks[key] = v
+			}
+		}
+		msgs[i] = queue.NewMessage(b, set("ack"), set("nack"))
+	}
+	return msgs
+}
+
+func TestListenDrain(t *testing.T) {
+	var tests = []struct {
+		name      string
+		size      int
+		msgs      int
+		submitted int
+	}{
+		{"single", 1, 2, 2},
+		// a batch of 3 is submitted, the remaining task is returned
+		{"batch", 3, 4, 3},
+	}
+
+	for _, test := range tests {
+		var mu sync.Mutex
+		acks := make(map[string]string)
+		claims := &testClaimer{claimed: make(map[string]bool)}
+		topic := &testTopic{}
+		worker := &testWorker{size: test.size, started: make(chan struct{}, 10), release: make(chan struct{})}
+		d := &Dispatcher{
+			wc:           worker,
+			batchTimeout: time.Hour,
+			drainTimeout: time.Minute,
+			claims:       claims,
+			sub: &testSubscription{
+				msgs: testMessages("task", test.msgs, acks, &mu),
+				late: testMessages("late", 1, acks, &mu),
+			},
+			resultc: topic,
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		errc := make(chan error)
+		go func() { errc <- d.Listen(ctx) }()
+
+		// wait for the submissions, then shut down while they are in flight
+		for i := 0; i < test.submitted/test.size; i++ {
+			<-worker.started
+		}
+		if test.size > 1 {
+			// wait for the remaining task to reach the batch
+			for {
+				claims.mu.Lock()
+				claimed := len(claims.claimed)
+				claims.mu.Unlock()
+				if claimed == test.msgs {
+					break
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}
+		cancel()
+		time.Sleep(10 * time.Millisecond)
+		close(worker.release)
+
+		if err := <-errc; err != nil {
+			t.Errorf("[%s] unexpected error: %s", test.name, err)
+		}
+		if worker.submitted != test.submitted || topic.published != test.submitted {
+			t.Errorf("[%s] expected %d tasks submitted and published, got %d and %d",
+				test.name, test.submitted, worker.submitted, topic.published)
+		}
+
+		var acked, nacked int
+		for _, v := range acks {
+			if v == "ack" {
+				acked++
+			} else {
+				nacked++
+			}
+		}
+		if acked != test.submitted || nacked != test.msgs+1-test.submitted {
+			t.Errorf("[%s] unexpected acknowledgements %v", test.name, acks)
+		}
+		if len(claims.claimed) != test.submitted {
+			t.Errorf("[%s] expected the claims of returned tasks to be released, got %v",
+				test.name, claims.claimed)
+		}
+	}
+}
+
+func TestListenDrainTimeout(t *testing.T) {
+	var mu sync.Mutex
+	acks := make(map[string]string)
+	worker := &testWorker{size: 1, started: make(chan struct{}, 10), release: make(chan struct{})}
+	defer close(worker.release)
+	d := &Dispatcher{
+		wc:           worker,
+		drainTimeout: 10 * time.Millisecond,
+		sub:          &testSubscription{msgs: testMessages("task", 1, acks, &mu)},
+		resultc:      &testTopic{},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error)
+	go func() { errc <- d.Listen(ctx) }()
+	<-worker.started
+	cancel()
+
+	if err := <-errc; err != ErrDrainTimeout {
+		t.Errorf("expected a drain timeout, got %v", err)
+	}
+}
@@ -0,0 +1,251 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package egress binds the outgoing connections of nozzles to local source
+// addresses. Hosts with several egress addresses (e.g. dual-stack hosts or
+// hosts with several interfaces) can spread the logins of a campaign across
+// them; each username is always sent from the same address, so that the
+// requests of a login never change address midway.
+package egress
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Binding selects the local source address of outgoing connections.
+type Binding struct {
+	// Addrs are the candidate source addresses
+	Addrs []net.IP
+
+	// Interface is the name of a network interface whose addresses are
+	// appended to Addrs when the binding is first used
+	Interface string
+
+	// Family restricts the addresses of Interface to IPv4 ("4") or IPv6
+	// ("6"). all addresses are used if it is empty.
+	Family string
+
+	once       sync.Once
+	transports []*http.Transport
+	err        error
+}
+
+// Parse returns the binding configured by the following nozzle options, or
+// nil if neither source_ip nor interface is set:
+//
+// source_ip
+//
+// A comma separated list of local IPv4 or IPv6 addresses to send requests
+// from.
+//
+// interface
+//
+// The name of a local network interface (e.g. eth1) to send requests from.
+// Link-local addresses of the interface are ignored.
+//
+// ip_version
+//
+// Either "4" or "6", restricting the addresses of interface (and the
+// addresses listed in source_ip) to a single address family.
+func Parse(opts map[string]string) (*Binding, error) {
+	b := &Binding{
+		Interface: opts["interface"],
+		Family:    opts["ip_version"],
+	}
+	if b.Family != "" && b.Family != "4" && b.Family != "6" {
+		return nil, fmt.Errorf("ip_version must be 4 or 6, got %q", b.Family)
+	}
+
+	if v, ok := opts["source_ip"]; ok {
+		for _, s := range strings.Split(v, ",") {
+			ip := net.ParseIP(strings.TrimSpace(s))
+			if ip == nil {
+				return nil, fmt.Errorf("source_ip %q is not an IP address", strings.TrimSpace(s))
+			}
+			if !b.accepts(ip) {
+				return nil, fmt.Errorf("source_ip %s is not an IPv%s address", ip, b.Family)
+			}
+			b.Addrs = append(b.Addrs, ip)
+		}
+	}
+
+	if len(b.Addrs) == 0 && b.Interface == "" {
+		if b.Family != "" {
+			return nil, fmt.Errorf("ip_version requires source_ip or interface")
+		}
+		return nil, nil
+	}
+	return b, nil
+}
+
+// accepts returns true if ip belongs to the address family of the binding.
+func (b *Binding) accepts(ip net.IP) bool {
+	switch b.Family {
+	case "4":
+		return ip.To4() != nil
+	case "6":
+		return ip.To4() == nil
+	}
+	return true
+}
+
+// Client returns a copy of base whose connections originate from the source
+// address assigned to key. the transport of base (or http.DefaultTransport)
+// is cloned once per address and shared by every binding. a nil binding
+// returns base unchanged.
+func (b *Binding) Client(base *http.Client, key string) (*http.Client, error) {
+	if b == nil {
+		return base, nil
+	}
+
+	b.once.Do(func() {
+		b.err = b.init(base.Transport)
+	})
+	i, err := b.index(key)
+	if err != nil {
+		return nil, err
+	}
+
+	c := *base
+	c.Transport = b.transports[i]
+	return &c, nil
+}
+
+// index returns the index of the address assigned to key. the assignment is
+// deterministic: a key is always assigned the same address as long as the
+// candidate addresses do not change.
+func (b *Binding) index(key string) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	if len(b.Addrs) == 0 {
+		return 0, fmt.Errorf("no source address to bind to")
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(key)) // nolint:errcheck,gosec
+	return int(h.Sum32() % uint32(len(b.Addrs))), nil
+}
+
+// init resolves the addresses of the interface and creates a transport per
+// address.
+func (b *Binding) init(rt http.RoundTripper) error {
+	if b.Interface != "" {
+		addrs, err := interfaceAddrs(b.Interface)
+		if err != nil {
+			return err
+		}
+		for _, ip := range addrs {
+			if b.accepts(ip) {
+				b.Addrs = append(b.Addrs, ip)
+			}
+		}
+		if len(b.Addrs) == 0 {
+			return fmt.Errorf("interface %s has no usable IPv%s address", b.Interface, b.Family)
+		}
+	}
+
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	base, ok := rt.(*http.Transport)
+	if !ok {
+		return fmt.Errorf("cannot bind connections of a %T", rt)
+	}
+
+	for _, ip := range b.Addrs {
+		b.transports = append(b.transports, cachedTransport(base, ip))
+	}
+	return nil
+}
+
+// transportKey identifies a bound transport by the transport it was cloned
+// from and its source address.
+type transportKey struct {
+	base *http.Transport
+	ip   string
+}
+
+var (
+	transportsMu sync.Mutex
+	transports   = map[transportKey]*http.Transport{}
+)
+
+// cachedTransport returns the transport bound to ip, cloning base the first
+// time the address is used. nozzles are opened for every task, so sharing the
+// transports across bindings lets logins reuse the idle connections of the
+// previous ones instead of leaving them open.
+func cachedTransport(base *http.Transport, ip net.IP) *http.Transport {
+	transportsMu.Lock()
+	defer transportsMu.Unlock()
+
+	key := transportKey{base: base, ip: ip.String()}
+	t, ok := transports[key]
+	if !ok {
+		t = transport(base, ip)
+		transports[key] = t
+	}
+	return t
+}
+
+// transport clones base to dial from ip. connections are only attempted over
+// the address family of ip, so that a target with both A and AAAA records is
+// reached over the family of the source address.
+func transport(base *http.Transport, ip net.IP) *http.Transport {
+	network := "tcp6"
+	if ip.To4() != nil {
+		network = "tcp4"
+	}
+	dialer := &net.Dialer{
+		LocalAddr: &net.TCPAddr{IP: ip},
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+
+	t := base.Clone()
+	t.DialContext = func(ctx context.Context, _, addr string) (net.Conn, error) {
+		return dialer.DialContext(ctx, network, addr)
+	}
+	return t
+}
+
+// interfaceAddrs returns the unicast addresses of the named interface,
+// ignoring link-local addresses which cannot be used without a zone.
+func interfaceAddrs(name string) ([]net.IP, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+
+	var ips []net.IP
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || ipnet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		ips = append(ips, ipnet.IP)
+	}
+	return ips, nil
+}
@@ -0,0 +1,135 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package egress
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	var tests = []struct {
+		desc  string
+		opts  map[string]string
+		nil   bool
+		addrs int
+		err   bool
+	}{
+		{"no binding", map[string]string{"subdomain": "example"}, true, 0, false},
+		{"source ip", map[string]string{"source_ip": "192.0.2.1"}, false, 1, false},
+		{"dual stack", map[string]string{"source_ip": "192.0.2.1, 2001:db8::1"}, false, 2, false},
+		{"interface", map[string]string{"interface": "eth1", "ip_version": "6"}, false, 0, false},
+		{"invalid ip", map[string]string{"source_ip": "example.org"}, false, 0, true},
+		{"family mismatch", map[string]string{"source_ip": "192.0.2.1", "ip_version": "6"}, false, 0, true},
+		{"invalid family", map[string]string{"interface": "eth1", "ip_version": "5"}, false, 0, true},
+		{"family only", map[string]string{"ip_version": "4"}, false, 0, true},
+	}
+
+	for _, test := range tests {
+		b, err := Parse(test.opts)
+		if (err != nil) != test.err {
+			t.Errorf("%s: unexpected error: %v", test.desc, err)
+			continue
+		}
+		if test.err {
+			continue
+		}
+		if (b == nil) != test.nil {
+			t.Errorf("%s: got binding %v", test.desc, b)
+			continue
+		}
+		if b != nil && len(b.Addrs) != test.addrs {
+			t.Errorf("%s: got %d addresses, expected %d", test.desc, len(b.Addrs), test.addrs)
+		}
+	}
+}
+
+func TestClient(t *testing.T) {
+	remote := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		remote <- host
+	}))
+	defer srv.Close()
+
+	b, err := Parse(map[string]string{"interface": "lo", "ip_version": "4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := b.Client(srv.Client(), "user@example.org")
+	if err != nil {
+		t.Skipf("loopback interface unavailable: %s", err)
+	}
+
+	resp, err := c.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close() // nolint:errcheck,gosec
+
+	if host := <-remote; !net.ParseIP(host).Equal(b.Addrs[0]) {
+		t.Errorf("request sent from %s, expected %s", host, b.Addrs[0])
+	}
+}
+
+func TestClientDeterministic(t *testing.T) {
+	b, err := Parse(map[string]string{"source_ip": "192.0.2.1,192.0.2.2,192.0.2.3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := make(map[http.RoundTripper]bool)
+	for i := 0; i < 50; i++ {
+		username := string(rune('a'+i%26)) + "@example.org"
+		c1, err := b.Client(http.DefaultClient, username)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c2, _ := b.Client(http.DefaultClient, username)
+		if c1.Transport != c2.Transport {
+			t.Errorf("%s was assigned different addresses", username)
+		}
+		seen[c1.Transport] = true
+	}
+	if len(seen) != 3 {
+		t.Errorf("usernames spread across %d addresses, expected 3", len(seen))
+	}
+	if c, _ := (*Binding)(nil).Client(http.DefaultClient, "user"); c != http.DefaultClient {
+		t.Errorf("nil binding changed the client")
+	}
+}
+
+func TestClientSharedTransports(t *testing.T) {
+	opts := map[string]string{"source_ip": "192.0.2.1"}
+	b1, err := Parse(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b2, _ := Parse(opts)
+
+	c1, err := b1.Client(http.DefaultClient, "user@example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := b2.Client(http.DefaultClient, "user@example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c1.Transport != c2.Transport {
+		t.Errorf("bindings of the same address did not share a transport")
+	}
+}
@@ -40,6 +40,30 @@ type AuthRequest struct {
 
 	// ProviderMetadata is any required configuration data for the provider
 	ProviderMetadata map[string]string `json:"metadata"`
+
+	// CredentialID is set when the task revalidates a stored credential
+	CredentialID uint `json:"credential_id,omitempty"`
+
+	// Attempt is the number of times this task has been retried
+	Attempt int `json:"attempt,omitempty"`
+
+	// MaxRetries is the maximum number of times this task may be retried
+	MaxRetries int `json:"max_retries,omitempty"`
+
+	// Excluded are the patterns of the accounts the campaign must never
+	// attempt. workers refuse tasks against matching usernames.
+	Excluded []string `json:"excluded,omitempty"`
+
+	// Actions are the read-only post-success actions run once the
+	// credential validates (see the action package)
+	Actions []Action `json:"actions,omitempty"`
+
+	// Key is the idempotency key of the task
+	Key string `json:"key,omitempty"`
+
+	// Fingerprint is set when the task probes the tenant of its campaign
+	// instead of attempting a login (see the fingerprint package)
+	Fingerprint bool `json:"fingerprint,omitempty"`
 }
 
 // AuthResponse represents the response to an authentication attempt.
@@ -50,6 +74,9 @@ type AuthResponse struct {
 	// IP is the originating IP of the credential guess
 	IP string `json:"ip"`
 
+	// Region is the cloud region of the worker which made the guess
+	Region string `json:"region,omitempty"`
+
 	// Timestamp is the time that we made the request
 	Timestamp time.Time `json:"timestamp"`
 
@@ -71,8 +98,92 @@ type AuthResponse struct {
 	// RateLimited indicates the provider has detected a large number of requests
 	RateLimited bool `json:"rate_limited"`
 
+	// Captcha indicates the provider required a CAPTCHA, so the validity of
+	// the credential is unknown
+	Captcha bool `json:"captcha"`
+
+	// PolicyBlocked indicates the credential is valid but the login was
+	// blocked by a policy of the provider (e.g. conditional access, network
+	// zones or device trust)
+	PolicyBlocked bool `json:"policy_blocked"`
+
 	// Additional metadata from the auth provider (e.g. information about MFA)
 	Metadata map[string]interface{} `json:"metadata"`
+
+	// Session is the session captured after a successful login (e.g. a
+	// session token or cookies), sealed by the worker
+	Session string `json:"session,omitempty"`
+
+	// Response is the raw provider response captured by nozzles configured
+	// with capture_response, sealed by the worker. it allows the attempt to
+	// be re-classified later.
+	Response string `json:"response,omitempty"`
+
+	// Findings are recorded by the post-success actions of a valid
+	// credential
+	Findings []Finding `json:"findings,omitempty"`
+
+	// CredentialID is set when the task revalidates a stored credential
+	CredentialID uint `json:"credential_id,omitempty"`
+
+	// Error is set when the task could not be completed by the worker
+	Error string `json:"error,omitempty"`
+
+	// ErrorClass classifies the error (see the retry package)
+	ErrorClass string `json:"error_class,omitempty"`
+
+	// Task is the failed (or CAPTCHA challenged) task, returned so that it can
+	// be retried
+	Task *AuthRequest `json:"task,omitempty"`
+
+	// Key is the idempotency key of the task
+	Key string `json:"key,omitempty"`
+
+	// Fingerprint holds the tenant features detected by a fingerprint task
+	Fingerprint *Fingerprint `json:"fingerprint,omitempty"`
+}
+
+// Fingerprint records the tenant-level security features detected by the
+// probe of a fingerprint task.
+type Fingerprint struct {
+	// ProbedAt is the time of the probe
+	ProbedAt time.Time `json:"probed_at"`
+
+	// Features maps the detected features to their values
+	Features map[string]string `json:"features,omitempty"`
+}
+
+// BatchRequest carries several tasks to be executed by a single worker
+// invocation.
+// Action configures a post-success action of a campaign.
+type Action struct {
+	// Name is the name of the action driver (e.g. smtp_auth)
+	Name string `json:"name"`
+
+	// Options configure the action
+	Options map[string]string `json:"options,omitempty"`
+}
+
+// Finding is the outcome of a post-success action.
+type Finding struct {
+	// Action is the name of the action
+	Action string `json:"action"`
+
+	// Data is the structured data recorded by the action
+	Data map[string]interface{} `json:"data,omitempty"`
+
+	// Error is set when the action could not be completed
+	Error string `json:"error,omitempty"`
+}
+
+type BatchRequest struct {
+	Tasks []AuthRequest `json:"tasks"`
+}
+
+// BatchResponse carries the results of a BatchRequest. Results are in the same
+// order as the tasks; tasks which could not be completed have Error set.
+type BatchResponse struct {
+	Results []AuthResponse `json:"results"`
 }
 
 // ErrorResponse represents a failure in task processing. This response should
@@ -80,4 +191,8 @@ type AuthResponse struct {
 type ErrorResponse struct {
 	// ErrorMsg is the result of error.Error()
 	ErrorMsg string `json:"error"`
+
+	// Class classifies the error as transient or permanent (see the retry
+	// package)
+	Class string `json:"class,omitempty"`
 }
@@ -0,0 +1,79 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package fingerprint detects the tenant-level security features of a
+// campaign's provider (e.g. an Okta organization's pipeline, whether an Azure
+// AD realm is federated, or a VPN portal's banner) and records them with the
+// campaign to inform its scheduling and final report. fingerprinting is
+// enabled on the orchestrator, whose scheduler then queues a single
+// fingerprint task per campaign. a worker runs it at the campaign's NotBefore,
+// from the campaign's egress like any other task, with the campaign's nozzle,
+// which must implement the nozzle.Fingerprinter interface and sends a single
+// request which never attempts a login.
+package fingerprint
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/praetorian-inc/trident/pkg/event"
+	"github.com/praetorian-inc/trident/pkg/nozzle"
+)
+
+// Timeout is the timeout of a probe.
+const Timeout = 30 * time.Second
+
+// ErrUnsupported is returned when the nozzle of a fingerprint task does not
+// implement the nozzle.Fingerprinter interface.
+var ErrUnsupported = errors.New("nozzle does not support fingerprinting")
+
+// Supported returns true if the nozzle of the provider, configured with the
+// metadata, implements the nozzle.Fingerprinter interface. secret references
+// in the metadata are not resolved, the nozzle is only opened to be checked.
+func Supported(provider string, metadata json.RawMessage) bool {
+	var opts map[string]string
+	if len(metadata) > 0 {
+		err := json.Unmarshal(metadata, &opts)
+		if err != nil {
+			return false
+		}
+	}
+	noz, err := nozzle.Open(provider, opts)
+	if err != nil {
+		return false
+	}
+	_, ok := noz.(nozzle.Fingerprinter)
+	return ok
+}
+
+// Probe fingerprints the tenant of the nozzle. the username is one of the
+// campaign's users, for probes which need one (e.g. realm discovery).
+func Probe(ctx context.Context, noz nozzle.Nozzle, username string) (*event.Fingerprint, error) {
+	f, ok := noz.(nozzle.Fingerprinter)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
+	ts := time.Now().UTC()
+	features, err := f.Fingerprint(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	return &event.Fingerprint{ProbedAt: ts, Features: features}, nil
+}
@@ -0,0 +1,102 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fingerprint
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/praetorian-inc/trident/pkg/event"
+	"github.com/praetorian-inc/trident/pkg/nozzle"
+)
+
+type loginNozzle struct{}
+
+func (loginNozzle) Login(ctx context.Context, username, password string) (*event.AuthResponse, error) {
+	return &event.AuthResponse{}, nil
+}
+
+type probedNozzle struct {
+	loginNozzle
+	org string
+}
+
+func (n probedNozzle) Fingerprint(ctx context.Context, username string) (map[string]string, error) {
+	if n.org == "" {
+		return nil, errors.New("connection refused")
+	}
+	return map[string]string{"org": n.org, "user": username}, nil
+}
+
+type driver struct{ fingerprint bool }
+
+func (d driver) New(opts map[string]string) (nozzle.Nozzle, error) {
+	if opts["invalid"] != "" {
+		return nil, errors.New("invalid options")
+	}
+	if d.fingerprint {
+		return probedNozzle{org: opts["org"]}, nil
+	}
+	return loginNozzle{}, nil
+}
+
+func init() {
+	nozzle.Register("fingerprint-test", driver{fingerprint: true})
+	nozzle.Register("fingerprint-unsupported", driver{})
+}
+
+func TestSupported(t *testing.T) {
+	tests := []struct {
+		provider string
+		metadata string
+		ok       bool
+	}{
+		{"fingerprint-test", `{"org":"example"}`, true},
+		{"fingerprint-test", `{"org":"secret:trident/providers/org"}`, true},
+		{"fingerprint-test", ``, true},
+		{"fingerprint-test", `{"invalid":"true"}`, false},
+		{"fingerprint-test", `[1]`, false},
+		{"fingerprint-unsupported", `{}`, false},
+		{"fingerprint-unknown", `{}`, false},
+	}
+	for _, test := range tests {
+		if ok := Supported(test.provider, json.RawMessage(test.metadata)); ok != test.ok {
+			t.Errorf("Supported(%s, %s) = %v, expected %v", test.provider, test.metadata, ok, test.ok)
+		}
+	}
+}
+
+func TestProbe(t *testing.T) {
+	ctx := context.Background()
+
+	fp, err := Probe(ctx, probedNozzle{org: "example"}, "alice@example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fp.ProbedAt.IsZero() || fp.Features["org"] != "example" || fp.Features["user"] != "alice@example.org" {
+		t.Errorf("unexpected fingerprint %+v", fp)
+	}
+
+	_, err = Probe(ctx, probedNozzle{}, "alice@example.org")
+	if err == nil || err.Error() != "connection refused" {
+		t.Errorf("expected the error of the probe, got %v", err)
+	}
+	_, err = Probe(ctx, loginNozzle{}, "alice@example.org")
+	if !errors.Is(err, ErrUnsupported) {
+		t.Errorf("expected ErrUnsupported, got %v", err)
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kms
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// SealedPrefix identifies values sealed by an Envelope.
+const SealedPrefix = "enc:v1:"
+
+// ErrSealed is returned when a sealed value can not be unsealed.
+var ErrSealed = errors.New("kms: invalid sealed value")
+
+// Envelope implements envelope encryption of short values (e.g. passwords in
+// tasks and results). values are encrypted with a data encryption key (DEK)
+// which is itself encrypted by the KeyManager and stored alongside the value,
+// so the KeyManager is only called once per DEK rather than once per value.
+//
+// sealed values have the form enc:v1:<wrapped DEK>.<nonce || ciphertext>.
+type Envelope struct {
+	// Keys wraps and unwraps data encryption keys
+	Keys KeyManager
+
+	mu      sync.Mutex
+	aead    cipher.AEAD
+	wrapped string
+	cache   map[string]cipher.AEAD
+}
+
+// NewEnvelope creates an Envelope using the provided KeyManager.
+func NewEnvelope(keys KeyManager) *Envelope {
+	return &Envelope{Keys: keys}
+}
+
+// IsSealed returns true if the value was produced by Envelope.Seal.
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, SealedPrefix)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// dek returns the data encryption key used for sealing, generating and
+// wrapping it on first use.
+func (e *Envelope) dek(ctx context.Context) (cipher.AEAD, string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.aead != nil {
+		return e.aead, e.wrapped, nil
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, "", err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, "", err
+	}
+	wrapped, err := e.Keys.Encrypt(ctx, key)
+	if err != nil {
+		return nil, "", fmt.Errorf("error wrapping data key: %w", err)
+	}
+
+	e.aead = aead
+	e.wrapped = base64.RawURLEncoding.EncodeToString(wrapped)
+	return e.aead, e.wrapped, nil
+}
+
+// unwrap returns the (cached) data encryption key for a wrapped key.
+func (e *Envelope) unwrap(ctx context.Context, wrapped string) (cipher.AEAD, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if aead, ok := e.cache[wrapped]; ok {
+		return aead, nil
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(wrapped)
+	if err != nil {
+		return nil, ErrSealed
+	}
+	key, err := e.Keys.Decrypt(ctx, b)
+	if err != nil {
+		return nil, fmt.Errorf("error unwrapping data key: %w", err)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.cache == nil {
+		e.cache = make(map[string]cipher.AEAD)
+	}
+	e.cache[wrapped] = aead
+	return aead, nil
+}
+
+// Seal encrypts the plaintext value.
+func (e *Envelope) Seal(ctx context.Context, plaintext string) (string, error) {
+	aead, wrapped, err := e.dek(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	ciphertext := aead.Seal(nonce, nonce, []byte(plaintext), nil)
+
+	return SealedPrefix + wrapped + "." + base64.RawURLEncoding.EncodeToString(ciphertext), nil
+}
+
+// Unseal decrypts a value produced by Seal. values which are not sealed are
+// returned unchanged, allowing plaintext values from before encryption was
+// enabled to be read.
+func (e *Envelope) Unseal(ctx context.Context, value string) (string, error) {
+	if !IsSealed(value) {
+		return value, nil
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(value, SealedPrefix), ".", 2)
+	if len(parts) != 2 {
+		return "", ErrSealed
+	}
+
+	aead, err := e.unwrap(ctx, parts[0])
+	if err != nil {
+		return "", err
+	}
+
+	ciphertext, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || len(ciphertext) < aead.NonceSize() {
+		return "", ErrSealed
+	}
+	size := aead.NonceSize()
+	plaintext, err := aead.Open(nil, ciphertext[:size], ciphertext[size:], nil)
+	if err != nil {
+		return "", ErrSealed
+	}
+	return string(plaintext), nil
+}
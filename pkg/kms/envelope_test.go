@@ -0,0 +1,72 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kms_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/praetorian-inc/trident/pkg/kms"
+	"github.com/praetorian-inc/trident/pkg/kms/local"
+)
+
+func TestEnvelope(t *testing.T) {
+	ctx := context.Background()
+	keys, err := local.Driver{}.New(map[string]string{
+		"key": "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=",
+	})
+	if err != nil {
+		t.Fatalf("error creating key manager: %s", err)
+	}
+
+	type testcase struct {
+		value string
+	}
+
+	testcases := []testcase{
+		{value: "Password1!"},
+		{value: ""},
+		{value: "enc:v1:not-actually-sealed"},
+	}
+
+	sealer := kms.NewEnvelope(keys)
+	opener := kms.NewEnvelope(keys)
+	for _, test := range testcases {
+		sealed, err := sealer.Seal(ctx, test.value)
+		if err != nil {
+			t.Fatalf("error sealing %q: %s", test.value, err)
+		}
+		if !kms.IsSealed(sealed) {
+			t.Errorf("expected %q to be sealed", sealed)
+		}
+		plaintext, err := opener.Unseal(ctx, sealed)
+		if err != nil {
+			t.Fatalf("error unsealing %q: %s", sealed, err)
+		}
+		if plaintext != test.value {
+			t.Errorf("expected %q, got %q", test.value, plaintext)
+		}
+	}
+
+	plaintext, err := opener.Unseal(ctx, "Password1!")
+	if err != nil || plaintext != "Password1!" {
+		t.Errorf("expected plaintext passthrough, got %q (%v)", plaintext, err)
+	}
+
+	_, err = opener.Unseal(ctx, "enc:v1:garbage")
+	if err != kms.ErrSealed {
+		t.Errorf("expected ErrSealed, got %v", err)
+	}
+}
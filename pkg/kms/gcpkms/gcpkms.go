@@ -0,0 +1,87 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcpkms
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+
+	cloudkms "google.golang.org/api/cloudkms/v1"
+
+	"github.com/praetorian-inc/trident/pkg/kms"
+)
+
+// Driver implements the kms.Driver interface.
+type Driver struct{}
+
+func init() {
+	kms.Register("gcpkms", Driver{})
+}
+
+// New is used to create a Google Cloud KMS key manager and accepts the
+// following configuration options:
+//
+// key_name
+//
+// The resource name of a symmetric CryptoKey, e.g.
+// projects/example/locations/global/keyRings/trident/cryptoKeys/results.
+// Application default credentials are used to authenticate to Cloud KMS.
+func (Driver) New(opts map[string]string) (kms.KeyManager, error) {
+	name, ok := opts["key_name"]
+	if !ok {
+		return nil, fmt.Errorf("gcpkms key manager requires 'key_name' config parameter")
+	}
+
+	svc, err := cloudkms.NewService(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	return &KeyManager{
+		Name: name,
+		keys: svc.Projects.Locations.KeyRings.CryptoKeys,
+	}, nil
+}
+
+// KeyManager implements the kms.KeyManager interface for Google Cloud KMS.
+type KeyManager struct {
+	// Name is the CryptoKey resource name
+	Name string
+
+	keys *cloudkms.ProjectsLocationsKeyRingsCryptoKeysService
+}
+
+// Encrypt encrypts the plaintext with the configured CryptoKey.
+func (k *KeyManager) Encrypt(ctx context.Context, plaintext []byte) ([]byte, error) {
+	resp, err := k.keys.Encrypt(k.Name, &cloudkms.EncryptRequest{
+		Plaintext: base64.StdEncoding.EncodeToString(plaintext),
+	}).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(resp.Ciphertext)
+}
+
+// Decrypt decrypts a ciphertext produced by Encrypt.
+func (k *KeyManager) Decrypt(ctx context.Context, ciphertext []byte) ([]byte, error) {
+	resp, err := k.keys.Decrypt(k.Name, &cloudkms.DecryptRequest{
+		Ciphertext: base64.StdEncoding.EncodeToString(ciphertext),
+	}).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(resp.Plaintext)
+}
@@ -0,0 +1,93 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package kms defines an interface for key management services used to
+// encrypt sensitive values (e.g. discovered passwords) at rest. Similar to the
+// nozzle package, drivers register themselves and must be "blank imported".
+//
+//  import (
+//      "github.com/praetorian-inc/trident/pkg/kms"
+//
+//      _ "github.com/praetorian-inc/trident/pkg/kms/gcpkms"
+//      _ "github.com/praetorian-inc/trident/pkg/kms/local"
+//  )
+//
+//  keys, err := kms.Open("gcpkms", map[string]string{"key_name":"projects/..."})
+//  if err != nil {
+//      // handle error
+//  }
+//  ciphertext, err := keys.Encrypt(ctx, []byte("Password1!"))
+//  // ...
+package kms
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+var (
+	driversMu sync.RWMutex
+	drivers   = make(map[string]Driver)
+)
+
+// KeyManager is the interface that wraps encryption and decryption of small
+// secrets with a managed key.
+type KeyManager interface {
+	Encrypt(ctx context.Context, plaintext []byte) ([]byte, error)
+	Decrypt(ctx context.Context, ciphertext []byte) ([]byte, error)
+}
+
+// Driver is the interface that wraps creation of a KeyManager.
+type Driver interface {
+	New(opts map[string]string) (KeyManager, error)
+}
+
+// Options is a type alias for simple marshaling/unmarshaling of key manager
+// configuration options from the environment.
+type Options map[string]string
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface.
+func (opts *Options) UnmarshalText(text []byte) error {
+	return json.Unmarshal(text, (*map[string]string)(opts))
+}
+
+// Open opens a key manager specified by its driver name (e.g. gcpkms) and
+// configures it via the provided opts argument. Each KeyManager should
+// document its configuration options in its New() method.
+func Open(name string, opts map[string]string) (KeyManager, error) {
+	driversMu.RLock()
+	d, ok := drivers[name]
+	driversMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("kms: unknown driver %q (forgotten import?)", name)
+	}
+
+	return d.New(opts)
+}
+
+// Register makes a key manager driver available at the provided name. If
+// register is called twice or if the driver is nil, if panics.
+func Register(name string, driver Driver) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	if driver == nil {
+		panic("kms: Register driver is nil")
+	}
+	if _, dup := drivers[name]; dup {
+		panic("kms: Register called twice for driver " + name)
+	}
+	drivers[name] = driver
+}
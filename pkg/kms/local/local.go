@@ -0,0 +1,90 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package local
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/praetorian-inc/trident/pkg/kms"
+)
+
+// Driver implements the kms.Driver interface.
+type Driver struct{}
+
+func init() {
+	kms.Register("local", Driver{})
+}
+
+// New is used to create a local key manager and accepts the following
+// configuration options:
+//
+// key
+//
+// A base64 encoded 256-bit AES key (e.g. `openssl rand -base64 32`).
+func (Driver) New(opts map[string]string) (kms.KeyManager, error) {
+	encoded, ok := opts["key"]
+	if !ok {
+		return nil, fmt.Errorf("local key manager requires 'key' config parameter")
+	}
+
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding local key: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("local key must be 32 bytes, got %d", len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &KeyManager{aead: aead}, nil
+}
+
+// KeyManager implements the kms.KeyManager interface using AES-256-GCM with a
+// locally configured key.
+type KeyManager struct {
+	aead cipher.AEAD
+}
+
+// Encrypt seals the plaintext, prefixing the ciphertext with a random nonce.
+func (k *KeyManager) Encrypt(ctx context.Context, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return k.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Decrypt opens a ciphertext produced by Encrypt.
+func (k *KeyManager) Decrypt(ctx context.Context, ciphertext []byte) ([]byte, error) {
+	size := k.aead.NonceSize()
+	if len(ciphertext) < size {
+		return nil, errors.New("ciphertext too short")
+	}
+	return k.aead.Open(nil, ciphertext[:size], ciphertext[size:], nil)
+}
@@ -0,0 +1,323 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mangle generates password candidates from a seed wordlist and a set
+// of rules. Rules use a subset of the hashcat rule syntax, one rule per line:
+//
+//  :     do nothing (keep the seed word)
+//  l     lowercase          u     uppercase
+//  c     capitalize         C     invert capitalize
+//  t     toggle case        TN    toggle case at position N
+//  r     reverse            d     duplicate
+//  f     reflect            {     rotate left
+//  }     rotate right       [     delete first character
+//  ]     delete last        DN    delete at position N
+//  'N    truncate at N      $X    append X
+//  ^X    prepend X          sXY   replace X with Y
+//  @X    purge X
+//
+// Positions are 0-9 followed by A-Z (10-35). Additionally, the following
+// macros expand to several rules each and may be combined with functions on
+// the same line (e.g. "c %years $!"):
+//
+//  %case     l, u, c and C
+//  %leet     common leetspeak substitutions, individually and combined
+//  %years    append each of the last five years
+//  %symbols  append a common symbol
+//
+// Blank lines and lines starting with '#' are ignored.
+package mangle
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// MaxCandidates is the maximum number of candidates Generate produces, which
+// guards against rule sets exploding into an unschedulable campaign.
+const MaxCandidates = 1000000
+
+// Years is the number of years expanded by the %years macro.
+const Years = 5
+
+// op is a single rule function.
+type op struct {
+	fn   byte
+	args []byte
+}
+
+// Rule is a compiled rule: a sequence of functions applied in order.
+type Rule []op
+
+var arity = map[byte]int{
+	':': 0, 'l': 0, 'u': 0, 'c': 0, 'C': 0, 't': 0, 'r': 0, 'd': 0, 'f': 0,
+	'{': 0, '}': 0, '[': 0, ']': 0,
+	'T': 1, 'D': 1, '\'': 1, '$': 1, '^': 1, '@': 1,
+	's': 2,
+}
+
+// macros returns the rules a macro expands to.
+func macros(name string, now time.Time) ([]string, bool) {
+	switch name {
+	case "case":
+		return []string{"l", "u", "c", "C"}, true
+	case "leet":
+		return []string{
+			"sa@", "sa4", "se3", "si1", "so0", "ss$", "ss5", "st7",
+			"sa@se3si1so0ss$", "sa4se3si1so0ss5st7",
+		}, true
+	case "years":
+		years := make([]string, 0, Years)
+		for y := now.Year(); y > now.Year()-Years; y-- {
+			var b strings.Builder
+			for _, c := range strconv.Itoa(y) {
+				b.WriteByte('$')
+				b.WriteRune(c)
+			}
+			years = append(years, b.String())
+		}
+		return years, true
+	case "symbols":
+		return []string{"$!", "$@", "$#", "$$", "$?", "$*", "$."}, true
+	}
+	return nil, false
+}
+
+// Compile parses rule lines, expanding macros relative to now (used by the
+// %years macro).
+func Compile(lines []string, now time.Time) ([]Rule, error) {
+	var rules []Rule
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		expanded, err := expand(line, now)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d (%q): %w", i+1, line, err)
+		}
+		for _, l := range expanded {
+			r, err := Parse(l)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d (%q): %w", i+1, line, err)
+			}
+			rules = append(rules, r)
+		}
+	}
+	return rules, nil
+}
+
+// expand replaces the macros in a rule line with each of their rules.
+func expand(line string, now time.Time) ([]string, error) {
+	lines := []string{""}
+	for i := 0; i < len(line); {
+		// macros are only recognized where a function is expected
+		if line[i] == '%' {
+			end := strings.IndexByte(line[i:], ' ')
+			if end < 0 {
+				end = len(line) - i
+			}
+			name := line[i+1 : i+end]
+			rules, ok := macros(name, now)
+			if !ok {
+				return nil, fmt.Errorf("unknown macro %%%s", name)
+			}
+			var next []string
+			for _, l := range lines {
+				for _, r := range rules {
+					next = append(next, l+r)
+				}
+			}
+			lines = next
+			i += end
+			continue
+		}
+
+		n := 1
+		if a, ok := arity[line[i]]; ok {
+			n += a
+		}
+		if i+n > len(line) {
+			n = len(line) - i
+		}
+		for j := range lines {
+			lines[j] += line[i : i+n]
+		}
+		i += n
+	}
+	return lines, nil
+}
+
+// Parse parses a single rule without macros.
+func Parse(line string) (Rule, error) {
+	var r Rule
+	for i := 0; i < len(line); {
+		if line[i] == ' ' {
+			i++
+			continue
+		}
+		n, ok := arity[line[i]]
+		if !ok {
+			return nil, fmt.Errorf("unsupported function %q", line[i])
+		}
+		if i+1+n > len(line) {
+			return nil, fmt.Errorf("function %q requires %d argument(s)", line[i], n)
+		}
+		o := op{fn: line[i], args: []byte(line[i+1 : i+1+n])}
+		if o.fn == 'T' || o.fn == 'D' || o.fn == '\'' {
+			if _, err := position(o.args[0]); err != nil {
+				return nil, err
+			}
+		}
+		r = append(r, o)
+		i += 1 + n
+	}
+	if len(r) == 0 {
+		return nil, fmt.Errorf("empty rule")
+	}
+	return r, nil
+}
+
+// position decodes a rule position (0-9, A-Z).
+func position(c byte) (int, error) {
+	switch {
+	case c >= '0' && c <= '9':
+		return int(c - '0'), nil
+	case c >= 'A' && c <= 'Z':
+		return int(c-'A') + 10, nil
+	}
+	return 0, fmt.Errorf("invalid position %q", c)
+}
+
+// Apply applies the rule to a word.
+func (r Rule) Apply(word string) string {
+	w := []rune(word)
+	for _, o := range r {
+		switch o.fn {
+		case 'l':
+			w = []rune(strings.ToLower(string(w)))
+		case 'u':
+			w = []rune(strings.ToUpper(string(w)))
+		case 'c':
+			w = []rune(strings.ToLower(string(w)))
+			if len(w) > 0 {
+				w[0] = unicode.ToUpper(w[0])
+			}
+		case 'C':
+			w = []rune(strings.ToUpper(string(w)))
+			if len(w) > 0 {
+				w[0] = unicode.ToLower(w[0])
+			}
+		case 't':
+			for i := range w {
+				w[i] = toggle(w[i])
+			}
+		case 'T':
+			if n, _ := position(o.args[0]); n < len(w) {
+				w[n] = toggle(w[n])
+			}
+		case 'r':
+			for i, j := 0, len(w)-1; i < j; i, j = i+1, j-1 {
+				w[i], w[j] = w[j], w[i]
+			}
+		case 'd':
+			w = append(w, w...)
+		case 'f':
+			rev := make([]rune, len(w))
+			for i := range w {
+				rev[len(w)-1-i] = w[i]
+			}
+			w = append(w, rev...)
+		case '{':
+			if len(w) > 0 {
+				w = append(w[1:], w[0])
+			}
+		case '}':
+			if len(w) > 0 {
+				w = append([]rune{w[len(w)-1]}, w[:len(w)-1]...)
+			}
+		case '[':
+			if len(w) > 0 {
+				w = w[1:]
+			}
+		case ']':
+			if len(w) > 0 {
+				w = w[:len(w)-1]
+			}
+		case 'D':
+			if n, _ := position(o.args[0]); n < len(w) {
+				w = append(w[:n:n], w[n+1:]...)
+			}
+		case '\'':
+			if n, _ := position(o.args[0]); n < len(w) {
+				w = w[:n]
+			}
+		case '$':
+			w = append(w, rune(o.args[0]))
+		case '^':
+			w = append([]rune{rune(o.args[0])}, w...)
+		case 's':
+			for i := range w {
+				if w[i] == rune(o.args[0]) {
+					w[i] = rune(o.args[1])
+				}
+			}
+		case '@':
+			purged := w[:0:0]
+			for _, c := range w {
+				if c != rune(o.args[0]) {
+					purged = append(purged, c)
+				}
+			}
+			w = purged
+		}
+	}
+	return string(w)
+}
+
+func toggle(c rune) rune {
+	if unicode.IsUpper(c) {
+		return unicode.ToLower(c)
+	}
+	return unicode.ToUpper(c)
+}
+
+// Generate applies every rule to every word, returning the deduplicated
+// candidates in order. candidates are grouped by seed word so that variants of
+// the first (presumably most likely) words are tried first. if there are no
+// rules, the words are returned as-is.
+func Generate(words []string, rules []Rule) ([]string, error) {
+	if len(rules) == 0 {
+		return words, nil
+	}
+
+	seen := make(map[string]bool)
+	var candidates []string
+	for _, word := range words {
+		for _, r := range rules {
+			c := r.Apply(word)
+			if c == "" || seen[c] {
+				continue
+			}
+			if len(candidates) >= MaxCandidates {
+				return nil, fmt.Errorf("rules generate more than %d candidates", MaxCandidates)
+			}
+			seen[c] = true
+			candidates = append(candidates, c)
+		}
+	}
+	return candidates, nil
+}
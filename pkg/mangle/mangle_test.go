@@ -0,0 +1,116 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mangle
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+type testcase struct {
+	rule     string
+	word     string
+	expected string
+}
+
+func TestApply(t *testing.T) {
+	var testcases = []testcase{
+		{":", "Password", "Password"},
+		{"l", "PassWord", "password"},
+		{"u", "PassWord", "PASSWORD"},
+		{"c", "pASSWORD", "Password"},
+		{"C", "password", "pASSWORD"},
+		{"t", "PassWord", "pASSwORD"},
+		{"T0", "password", "Password"},
+		{"T9", "password", "password"},
+		{"r", "abc", "cba"},
+		{"d", "abc", "abcabc"},
+		{"f", "abc", "abccba"},
+		{"{", "abc", "bca"},
+		{"}", "abc", "cab"},
+		{"[", "abc", "bc"},
+		{"]", "abc", "ab"},
+		{"D1", "abc", "ac"},
+		{"'2", "abcd", "ab"},
+		{"$1", "abc", "abc1"},
+		{"^1", "abc", "1abc"},
+		{"$ $1", "abc", "abc 1"},
+		{"sa@", "banana", "b@n@n@"},
+		{"@a", "banana", "bnn"},
+		{"c $2 $0 $2 $0 $!", "summer", "Summer2020!"},
+		{"cso0", "autumn", "Autumn"},
+		{"c so0", "october", "Oct0ber"},
+	}
+
+	for _, test := range testcases {
+		r, err := Parse(test.rule)
+		if err != nil {
+			t.Errorf("unexpected error parsing %q: %s", test.rule, err)
+			continue
+		}
+		actual := r.Apply(test.word)
+		if actual != test.expected {
+			t.Errorf("rule %q on %q: expected %q, got %q", test.rule, test.word, test.expected, actual)
+		}
+	}
+}
+
+func TestCompile(t *testing.T) {
+	now := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	rules, err := Compile([]string{"# comment", "", ":", "c %years $!", "%symbols"}, now)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(rules) != 1+Years+7 {
+		t.Fatalf("expected %d rules, got %d", 1+Years+7, len(rules))
+	}
+	if actual := rules[1].Apply("summer"); actual != "Summer2020!" {
+		t.Errorf("expected Summer2020!, got %s", actual)
+	}
+	if actual := rules[Years].Apply("summer"); actual != "Summer2016!" {
+		t.Errorf("expected Summer2016!, got %s", actual)
+	}
+
+	for _, line := range []string{"X", "$", "sa", "T!", "%unknown"} {
+		_, err := Compile([]string{line}, now)
+		if err == nil {
+			t.Errorf("expected error compiling %q", line)
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	rules, err := Compile([]string{":", "l", "c", "$1"}, time.Now())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	candidates, err := Generate([]string{"Winter", "winter"}, rules)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := "Winter,winter,Winter1,winter1"
+	if actual := strings.Join(candidates, ","); actual != expected {
+		t.Errorf("expected %s, got %s", expected, actual)
+	}
+
+	words := []string{"a", "b"}
+	candidates, err = Generate(words, nil)
+	if err != nil || len(candidates) != 2 {
+		t.Errorf("expected words to be returned as-is, got %v (%v)", candidates, err)
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package logger implements a notifier which writes alerts to the log.
+package logger
+
+import (
+	"context"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/praetorian-inc/trident/pkg/notify"
+)
+
+func init() {
+	notify.Register("log", Driver{})
+}
+
+// Driver implements the notify.Driver interface.
+type Driver struct{}
+
+// New is used to create a log notifier. it accepts no configuration options.
+func (Driver) New(opts map[string]string) (notify.Notifier, error) {
+	return &Notifier{}, nil
+}
+
+// Notifier implements the notify.Notifier interface by logging alerts.
+type Notifier struct{}
+
+// Notify logs the message as a warning.
+func (n *Notifier) Notify(ctx context.Context, msg notify.Message) error {
+	fields := log.Fields{"campaign": msg.CampaignID}
+	for k, v := range msg.Fields {
+		fields[k] = v
+	}
+	log.WithFields(fields).Warnf("%s: %s", msg.Title, msg.Text)
+	return nil
+}
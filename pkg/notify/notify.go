@@ -0,0 +1,105 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package notify defines an interface used to alert operators about campaign
+// events (e.g. permanently failing tasks). Similar to the nozzle package,
+// notifiers register themselves and must be "blank imported".
+//
+//  import (
+//      "github.com/praetorian-inc/trident/pkg/notify"
+//
+//      _ "github.com/praetorian-inc/trident/pkg/notify/slack"
+//  )
+//
+//  n, err := notify.Open("slack", map[string]string{"webhook_url":"https://hooks.slack.com/..."})
+//  if err != nil {
+//      // handle error
+//  }
+//  err = n.Notify(ctx, notify.Message{Title: "campaign 1 failing"})
+//  // ...
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+var (
+	driversMu sync.RWMutex
+	drivers   = make(map[string]Driver)
+)
+
+// Message is an alert sent to operators.
+type Message struct {
+	// Title is a short summary of the alert
+	Title string `json:"title"`
+
+	// Text describes the alert in more detail
+	Text string `json:"text"`
+
+	// CampaignID is the campaign the alert relates to (if any)
+	CampaignID uint `json:"campaign_id,omitempty"`
+
+	// Fields carries additional structured context
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+// Driver is the interface that wraps creation of a Notifier.
+type Driver interface {
+	New(opts map[string]string) (Notifier, error)
+}
+
+// Notifier is the interface that wraps the Notify method.
+type Notifier interface {
+	Notify(ctx context.Context, msg Message) error
+}
+
+// Options is used to configure a notifier from the environment. the value
+// is a JSON object of string options.
+type Options map[string]string
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface.
+func (opts *Options) UnmarshalText(text []byte) error {
+	return json.Unmarshal(text, (*map[string]string)(opts))
+}
+
+// Open opens a notifier specified by its driver name (e.g. slack) and
+// configures it via the provided opts argument. Each Notifier should document
+// its configuration options in its New() method.
+func Open(name string, opts map[string]string) (Notifier, error) {
+	driversMu.RLock()
+	d, ok := drivers[name]
+	driversMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("notify: unknown driver %q (forgotten import?)", name)
+	}
+
+	return d.New(opts)
+}
+
+// Register makes a notifier driver available at the provided name. If
+// Register is called twice or if the driver is nil, it panics.
+func Register(name string, driver Driver) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	if driver == nil {
+		panic("notify: Register driver is nil")
+	}
+	if _, dup := drivers[name]; dup {
+		panic("notify: Register called twice for driver " + name)
+	}
+	drivers[name] = driver
+}
@@ -0,0 +1,73 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package slack implements a notifier which posts alerts to a Slack incoming
+// webhook.
+package slack
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/praetorian-inc/trident/pkg/notify"
+	"github.com/praetorian-inc/trident/pkg/notify/webhook"
+)
+
+func init() {
+	notify.Register("slack", Driver{})
+}
+
+// Driver implements the notify.Driver interface.
+type Driver struct{}
+
+// New is used to create a Slack notifier and accepts the following
+// configuration options:
+//
+// webhook_url
+//
+// The URL of a Slack incoming webhook.
+func (Driver) New(opts map[string]string) (notify.Notifier, error) {
+	url, ok := opts["webhook_url"]
+	if !ok {
+		return nil, fmt.Errorf("slack notifier requires 'webhook_url' config parameter")
+	}
+	return &Notifier{WebhookURL: url}, nil
+}
+
+// Notifier implements the notify.Notifier interface for Slack.
+type Notifier struct {
+	// WebhookURL is the Slack incoming webhook URL
+	WebhookURL string
+}
+
+// Notify formats the message as Slack markdown and posts it.
+func (n *Notifier) Notify(ctx context.Context, msg notify.Message) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%s*\n%s", msg.Title, msg.Text)
+
+	keys := make([]string, 0, len(msg.Fields))
+	for k := range msg.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, "\n• %s: `%s`", k, msg.Fields[k])
+	}
+
+	data, _ := json.Marshal(map[string]string{"text": b.String()})
+	return webhook.Post(ctx, n.WebhookURL, data, "", "")
+}
@@ -0,0 +1,91 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package webhook implements a notifier which POSTs alerts as JSON.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/praetorian-inc/trident/pkg/notify"
+)
+
+func init() {
+	notify.Register("webhook", Driver{})
+}
+
+// Driver implements the notify.Driver interface.
+type Driver struct{}
+
+// New is used to create a webhook notifier and accepts the following
+// configuration options:
+//  url:    the URL alerts are POSTed to as a JSON notify.Message.
+//  header: an optional HTTP header used for authentication.
+//  token:  the value of the authentication header.
+func (Driver) New(opts map[string]string) (notify.Notifier, error) {
+	url, ok := opts["url"]
+	if !ok {
+		return nil, fmt.Errorf("webhook notifier requires 'url' config parameter")
+	}
+	return &Notifier{
+		URL:    url,
+		Header: opts["header"],
+		Token:  opts["token"],
+	}, nil
+}
+
+// Notifier implements the notify.Notifier interface for webhooks.
+type Notifier struct {
+	// URL is the webhook URL
+	URL string
+
+	// Header and Token optionally authenticate requests
+	Header string
+	Token  string
+}
+
+// Notify POSTs the message to the webhook.
+func (n *Notifier) Notify(ctx context.Context, msg notify.Message) error {
+	data, _ := json.Marshal(msg)
+	return Post(ctx, n.URL, data, n.Header, n.Token)
+}
+
+// Post sends a JSON payload to the provided URL, failing on non-2xx
+// responses.
+func Post(ctx context.Context, url string, data []byte, header, token string) error {
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(data))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	if header != "" {
+		req.Header.Set(header, token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status code from notification webhook: %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -20,7 +20,6 @@ import (
 	"crypto/tls"
 	"encoding/xml"
 	"fmt"
-	"io/ioutil"
 	"net/http"
 	"strings"
 	"time"
@@ -30,6 +29,8 @@ import (
 
 	"github.com/praetorian-inc/trident/pkg/event"
 	"github.com/praetorian-inc/trident/pkg/nozzle"
+	"github.com/praetorian-inc/trident/pkg/retry"
+	"github.com/praetorian-inc/trident/pkg/rules"
 )
 
 const (
@@ -155,7 +156,7 @@ func escape(s string) string {
 	return b.String()
 }
 
-func (n *Nozzle) ntlmStrategy(username, password string) (*event.AuthResponse, error) {
+func (n *Nozzle) ntlmStrategy(ctx context.Context, username, password string) (*event.AuthResponse, error) {
 	url := fmt.Sprintf(windowsTransportURL, n.Domain)
 	data := fmt.Sprintf(windowsTransportRequest, n.Domain, n.Domain)
 
@@ -169,7 +170,7 @@ func (n *Nozzle) ntlmStrategy(username, password string) (*event.AuthResponse, e
 		},
 	}
 
-	req, _ := http.NewRequest("GET", url, strings.NewReader(data))
+	req, _ := http.NewRequestWithContext(ctx, "GET", url, strings.NewReader(data))
 	req.SetBasicAuth(username, password)
 	req.Header.Set("Content-Type", "application/soap+xml")
 	req.Header.Set("User-Agent", n.UserAgent)
@@ -179,26 +180,29 @@ func (n *Nozzle) ntlmStrategy(username, password string) (*event.AuthResponse, e
 	}
 	defer resp.Body.Close() // nolint:errcheck
 
-	if resp.StatusCode == 503 {
-		return nil, fmt.Errorf("ntlm not enabled externally")
-	}
-
-	body, err := ioutil.ReadAll(resp.Body)
+	r, err := rules.NewResponse(resp)
 	if err != nil {
 		return nil, err
 	}
+	if res, ok, err := rules.Classify("adfs", r); ok {
+		return res, err
+	}
+
+	if resp.StatusCode == 503 {
+		return nil, retry.Errorf(retry.ClassConfig, "ntlm not enabled externally")
+	}
 
 	return &event.AuthResponse{
 		Valid:  resp.StatusCode == 200,
 		MFA:    false,
 		Locked: false,
 		Metadata: map[string]interface{}{
-			"xml": string(body),
+			"xml": string(r.Body),
 		},
 	}, nil
 }
 
-func (n *Nozzle) usernameMixedStrategy(username, password string) (*event.AuthResponse, error) {
+func (n *Nozzle) usernameMixedStrategy(ctx context.Context, username, password string) (*event.AuthResponse, error) {
 	url := fmt.Sprintf(usernameMixedURL, n.Domain)
 	data := fmt.Sprintf(usernameMixedRequest,
 		n.Domain, escape(username), escape(password), n.Domain)
@@ -211,7 +215,7 @@ func (n *Nozzle) usernameMixedStrategy(username, password string) (*event.AuthRe
 		},
 	}
 
-	req, _ := http.NewRequest("GET", url, strings.NewReader(data))
+	req, _ := http.NewRequestWithContext(ctx, "GET", url, strings.NewReader(data))
 	req.Header.Set("Content-Type", "application/soap+xml")
 	req.Header.Set("User-Agent", n.UserAgent)
 	resp, err := client.Do(req)
@@ -220,10 +224,13 @@ func (n *Nozzle) usernameMixedStrategy(username, password string) (*event.AuthRe
 	}
 	defer resp.Body.Close() // nolint:errcheck
 
-	body, err := ioutil.ReadAll(resp.Body)
+	r, err := rules.NewResponse(resp)
 	if err != nil {
 		return nil, err
 	}
+	if res, ok, err := rules.Classify("adfs", r); ok {
+		return res, err
+	}
 
 	return &event.AuthResponse{
 		Valid:  resp.StatusCode == 200,
@@ -231,7 +238,7 @@ func (n *Nozzle) usernameMixedStrategy(username, password string) (*event.AuthRe
 		Locked: false,
 		Metadata: map[string]interface{}{
 			"status": resp.StatusCode,
-			"xml":    string(body),
+			"xml":    string(r.Body),
 		},
 	}, nil
 }
@@ -239,17 +246,16 @@ func (n *Nozzle) usernameMixedStrategy(username, password string) (*event.AuthRe
 // Login fulfils the nozzle.Nozzle interface and performs an authentication
 // requests against adfs. This function supports rate limiting and parses valid,
 // invalid, and locked out responses.
-func (n *Nozzle) Login(username, password string) (*event.AuthResponse, error) {
-	ctx := context.Background()
+func (n *Nozzle) Login(ctx context.Context, username, password string) (*event.AuthResponse, error) {
 	err := RateLimiter.Wait(ctx)
 	if err != nil {
 		return nil, err
 	}
 
 	if n.Strategy == "ntlm" {
-		return n.ntlmStrategy(username, password)
+		return n.ntlmStrategy(ctx, username, password)
 	}
 
 	// Default strategy is usernamemixed
-	return n.usernameMixedStrategy(username, password)
+	return n.usernameMixedStrategy(ctx, username, password)
 }
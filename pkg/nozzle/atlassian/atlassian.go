@@ -0,0 +1,284 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package atlassian
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/praetorian-inc/trident/pkg/event"
+	"github.com/praetorian-inc/trident/pkg/nozzle"
+	"github.com/praetorian-inc/trident/pkg/retry"
+	"github.com/praetorian-inc/trident/pkg/rules"
+)
+
+const (
+	// FrozenUserAgent is a static user agent that we use for all requests. This
+	// value is based on the UA client hint work within browsers.
+	// Additional details: https://bugs.chromium.org/p/chromium/issues/detail?id=955620
+	FrozenUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64)" +
+		"AppleWebKit/537.36 (KHTML, like Gecko) Chrome/75.0.3764.0 Safari/537.36"
+
+	// LoginReasonHeader and DeniedReasonHeader are set by the Seraph
+	// authentication framework of Jira and Confluence
+	LoginReasonHeader  = "X-Seraph-LoginReason"
+	DeniedReasonHeader = "X-Authentication-Denied-Reason"
+)
+
+var (
+	// RateLimiter limits requests from the same worker to a maximum of 3/s
+	RateLimiter = rate.NewLimiter(rate.Every(300*time.Millisecond), 1)
+
+	// formPaths are the form login endpoints of each product
+	formPaths = map[string]string{
+		"jira":       "/login.jsp",
+		"confluence": "/dologin.action",
+	}
+)
+
+// Driver implements the nozzle.Driver interface.
+type Driver struct{}
+
+func init() {
+	nozzle.Register("atlassian", Driver{})
+}
+
+// New is used to create a nozzle for self-hosted (Server and Data Center)
+// Jira and Confluence and accepts the following configuration options:
+//
+// domain
+//
+// The hostname of the Jira or Confluence instance (e.g. jira.example.org).
+//
+// path
+//
+// The context path of the instance, if it is not served at the root of the
+// domain (e.g. "/jira").
+//
+// product
+//
+// The product to target: jira (default) or confluence.
+//
+// strategy
+//
+// The login endpoint to use: rest (default for Jira) authenticates against
+// /rest/auth/1/session, and form (default for Confluence, which has no session
+// API) submits the login form.
+//
+// capture_session
+//
+// If "true", the session cookies set for users with valid credentials are
+// captured in the (sealed) session of the result.
+//
+// capture_response
+//
+// If "true", the raw response of each login is captured in the (sealed)
+// response of the result, so that it can be re-classified later.
+func (Driver) New(opts map[string]string) (nozzle.Nozzle, error) {
+	domain, ok := opts["domain"]
+	if !ok {
+		return nil, fmt.Errorf("atlassian nozzle requires 'domain' config parameter")
+	}
+
+	product, ok := opts["product"]
+	if !ok {
+		product = "jira"
+	}
+	if _, ok := formPaths[product]; !ok {
+		return nil, fmt.Errorf("unknown atlassian product %q, expected jira or confluence", product)
+	}
+
+	strategy, ok := opts["strategy"]
+	if !ok {
+		strategy = "rest"
+		if product == "confluence" {
+			strategy = "form"
+		}
+	}
+	switch {
+	case strategy != "rest" && strategy != "form":
+		return nil, fmt.Errorf("unknown atlassian strategy %q, expected rest or form", strategy)
+	case strategy == "rest" && product != "jira":
+		return nil, fmt.Errorf("the rest strategy is only supported by jira")
+	}
+
+	return &Nozzle{
+		Domain:          domain,
+		Path:            strings.TrimSuffix(opts["path"], "/"),
+		Product:         product,
+		Strategy:        strategy,
+		UserAgent:       FrozenUserAgent,
+		CaptureSession:  opts["capture_session"] == "true",
+		CaptureResponse: opts["capture_response"] == "true",
+	}, nil
+}
+
+// Nozzle implements the nozzle.Nozzle interface for Jira and Confluence.
+type Nozzle struct {
+	// Domain is the hostname of the instance
+	Domain string
+
+	// Path is the context path of the instance
+	Path string
+
+	// Product is the targeted product (jira or confluence)
+	Product string
+
+	// Strategy is the login endpoint to use (rest or form)
+	Strategy string
+
+	// UserAgent will override the Go-http-client user-agent in requests
+	UserAgent string
+
+	// CaptureSession captures the session cookies of valid users
+	CaptureSession bool
+
+	// CaptureResponse captures the raw response of each login
+	CaptureResponse bool
+}
+
+// Login fulfils the nozzle.Nozzle interface and performs an authentication
+// request against Jira or Confluence. This function supports rate limiting and
+// parses valid, invalid, and CAPTCHA challenged responses.
+func (n *Nozzle) Login(ctx context.Context, username, password string) (*event.AuthResponse, error) {
+	err := RateLimiter.Wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var req *http.Request
+	if n.Strategy == "form" {
+		form := url.Values{}
+		form.Set("os_username", username)
+		form.Set("os_password", password)
+		form.Set("os_destination", "")
+		form.Set("login", "Log in")
+		req, err = http.NewRequestWithContext(ctx, "POST", n.url(formPaths[n.Product]), strings.NewReader(form.Encode()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		data, _ := json.Marshal(map[string]string{
+			"username": username,
+			"password": password,
+		})
+		req, err = http.NewRequestWithContext(ctx, "POST", n.url("/rest/auth/1/session"), bytes.NewBuffer(data))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+	}
+	// disables the XSRF check of the form login
+	req.Header.Set("X-Atlassian-Token", "no-check")
+	req.Header.Set("User-Agent", n.UserAgent)
+
+	// redirects are not followed so that the Seraph headers of the login
+	// response can be inspected
+	client := &http.Client{
+		Transport: http.DefaultClient.Transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	r, err := rules.NewResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+	res, err := n.Classify(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.Valid && n.CaptureSession {
+		cookies := make(map[string]interface{})
+		for _, c := range resp.Cookies() {
+			cookies[c.Name] = c.Value
+		}
+		res.Session = nozzle.Session(map[string]interface{}{
+			"cookies": cookies,
+		})
+	}
+	if n.CaptureResponse {
+		res.Response = r.Encode()
+	}
+	return res, nil
+}
+
+// Classify fulfils the nozzle.Classifier interface and classifies a response
+// of the login endpoint of the configured strategy.
+func (n *Nozzle) Classify(r *rules.Response) (*event.AuthResponse, error) {
+	if res, ok, err := rules.Classify("atlassian", r); ok {
+		return res, err
+	}
+	if res, ok := rules.DetectCaptcha(r); ok {
+		return res, nil
+	}
+
+	// Seraph reports CAPTCHA challenges the same way on every endpoint, once
+	// a user exceeds the configured number of failed logins
+	denied := r.Header.Get(DeniedReasonHeader)
+	if strings.Contains(denied, "CAPTCHA_CHALLENGE") {
+		return &event.AuthResponse{
+			Captcha: true,
+			Metadata: map[string]interface{}{
+				"challenge":     "seraph",
+				"denied_reason": denied,
+			},
+		}, nil
+	}
+
+	switch r.StatusCode {
+	case 200, 302, 303:
+		valid := r.StatusCode == 200
+		if n.Strategy == "form" {
+			valid = r.Header.Get(LoginReasonHeader) == "OK"
+		}
+
+		return &event.AuthResponse{
+			Valid: valid,
+		}, nil
+	case 401, 403:
+		return &event.AuthResponse{
+			Valid: false,
+		}, nil
+	case 429:
+		return &event.AuthResponse{
+			RateLimited: true,
+		}, nil
+	}
+
+	return nil, retry.Errorf(retry.ClassifyStatus(r.StatusCode),
+		"unhandled status code from atlassian provider: %d", r.StatusCode)
+}
+
+// url returns the URL of an endpoint of the instance.
+func (n *Nozzle) url(endpoint string) string {
+	return fmt.Sprintf("https://%s%s%s", n.Domain, n.Path, endpoint)
+}
@@ -0,0 +1,156 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package atlassian
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/praetorian-inc/trident/pkg/nozzle"
+)
+
+func TestNew(t *testing.T) {
+	var testcases = []struct {
+		desc      string
+		opts      map[string]string
+		strategy  string
+		expecterr bool
+	}{
+		{"jira", map[string]string{"domain": "jira.example.org"}, "rest", false},
+		{"confluence", map[string]string{"domain": "wiki.example.org", "product": "confluence"}, "form", false},
+		{"jira form", map[string]string{"domain": "jira.example.org", "strategy": "form"}, "form", false},
+		{"confluence rest", map[string]string{"domain": "wiki.example.org", "product": "confluence",
+			"strategy": "rest"}, "", true},
+		{"unknown product", map[string]string{"domain": "bb.example.org", "product": "bitbucket"}, "", true},
+		{"missing domain", map[string]string{}, "", true},
+	}
+
+	for _, test := range testcases {
+		noz, err := nozzle.Open("atlassian", test.opts)
+		if test.expecterr {
+			if err == nil {
+				t.Errorf("[%s] expected error", test.desc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("[%s] unexpected error: %s", test.desc, err)
+			continue
+		}
+		if strategy := noz.(*Nozzle).Strategy; strategy != test.strategy {
+			t.Errorf("[%s] strategy was %s, expected %s", test.desc, strategy, test.strategy)
+		}
+	}
+}
+
+func TestLogin(t *testing.T) {
+	var testcases = []struct {
+		desc     string
+		product  string
+		strategy string
+		status   int
+		headers  map[string]string
+		valid    bool
+		captcha  bool
+		limited  bool
+	}{
+		{"rest valid", "jira", "rest", 200, nil, true, false, false},
+		{"rest invalid", "jira", "rest", 401, map[string]string{LoginReasonHeader: "AUTHENTICATED_FAILED"},
+			false, false, false},
+		{"rest captcha", "jira", "rest", 403, map[string]string{LoginReasonHeader: "AUTHENTICATION_DENIED",
+			DeniedReasonHeader: "CAPTCHA_CHALLENGE; login-url=https://jira.example.org/login.jsp"},
+			false, true, false},
+		{"rest rate limited", "jira", "rest", 429, nil, false, false, true},
+		{"form valid", "confluence", "form", 302, map[string]string{LoginReasonHeader: "OK"}, true, false, false},
+		{"form invalid", "confluence", "form", 200, map[string]string{LoginReasonHeader: "AUTHENTICATED_FAILED"},
+			false, false, false},
+		{"form captcha", "jira", "form", 200, map[string]string{LoginReasonHeader: "AUTHENTICATION_DENIED",
+			DeniedReasonHeader: "CAPTCHA_CHALLENGE; login-url=https://jira.example.org/login.jsp"},
+			false, true, false},
+	}
+
+	for _, test := range testcases {
+		srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			expected := "/jira/rest/auth/1/session"
+			if test.strategy == "form" {
+				expected = "/jira" + formPaths[test.product]
+			}
+			if r.URL.Path != expected || r.Header.Get("X-Atlassian-Token") != "no-check" {
+				w.WriteHeader(400)
+				return
+			}
+			for name, value := range test.headers {
+				w.Header().Set(name, value)
+			}
+			if test.status == 302 {
+				w.Header().Set("Location", "/")
+			}
+			w.WriteHeader(test.status)
+		}))
+
+		client := http.DefaultClient
+		http.DefaultClient = srv.Client()
+
+		noz := &Nozzle{
+			Domain:   strings.TrimPrefix(srv.URL, "https://"),
+			Path:     "/jira",
+			Product:  test.product,
+			Strategy: test.strategy,
+		}
+		res, err := noz.Login(context.Background(), "alice", "Password1!")
+
+		http.DefaultClient = client
+		srv.Close()
+
+		if err != nil {
+			t.Errorf("[%s] unexpected error: %s", test.desc, err)
+			continue
+		}
+		if res.Valid != test.valid || res.Captcha != test.captcha || res.RateLimited != test.limited {
+			t.Errorf("[%s] unexpected response %+v", test.desc, res)
+		}
+	}
+}
+
+func TestCaptureSession(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "JSESSIONID", Value: "0123456789ABCDEF"})
+		w.Header().Set(LoginReasonHeader, "OK")
+		w.Header().Set("Location", "/")
+		w.WriteHeader(302)
+	}))
+	defer srv.Close()
+
+	client := http.DefaultClient
+	http.DefaultClient = srv.Client()
+	defer func() { http.DefaultClient = client }()
+
+	noz := &Nozzle{
+		Domain:         strings.TrimPrefix(srv.URL, "https://"),
+		Product:        "confluence",
+		Strategy:       "form",
+		CaptureSession: true,
+	}
+	res, err := noz.Login(context.Background(), "alice", "Password1!")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !res.Valid || res.Session != `{"cookies":{"JSESSIONID":"0123456789ABCDEF"}}` {
+		t.Errorf("unexpected response %+v", res)
+	}
+}
@@ -0,0 +1,314 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigip
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/praetorian-inc/trident/pkg/event"
+	"github.com/praetorian-inc/trident/pkg/nozzle"
+	"github.com/praetorian-inc/trident/pkg/retry"
+	"github.com/praetorian-inc/trident/pkg/rules"
+	"github.com/praetorian-inc/trident/pkg/util"
+)
+
+const (
+	// FrozenUserAgent is a static user agent that we use for all requests. This
+	// value is based on the UA client hint work within browsers.
+	// Additional details: https://bugs.chromium.org/p/chromium/issues/detail?id=955620
+	FrozenUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64)" +
+		"AppleWebKit/537.36 (KHTML, like Gecko) Chrome/75.0.3764.0 Safari/537.36"
+)
+
+var (
+	// RateLimiter limits requests from the same worker to a maximum of 3/s
+	RateLimiter = rate.NewLimiter(rate.Every(300*time.Millisecond), 1)
+
+	// mfaMarkers are found in the pages of an access policy prompting a user
+	// whose password was accepted for a second factor (RADIUS challenges,
+	// OTP and push verification)
+	mfaMarkers = [][]byte{[]byte("_f5_challenge"), []byte(`name="otp"`), []byte("one-time passcode"),
+		[]byte("verification code")}
+
+	// passwordMarkers are found in the page of a user whose password was
+	// accepted but has expired
+	passwordMarkers = [][]byte{[]byte("password has expired"), []byte("must change your password")}
+
+	// lockedMarkers are found in the page of a locked out user
+	lockedMarkers = [][]byte{[]byte("locked out"), []byte("account is locked"),
+		[]byte("account has been locked")}
+
+	// webtopMarkers are found in the webtop users land on once the access
+	// policy allows them
+	webtopMarkers = [][]byte{[]byte("/vdesk/"), []byte("webtop")}
+)
+
+// Driver implements the nozzle.Driver interface.
+type Driver struct{}
+
+func init() {
+	nozzle.Register("bigip", Driver{})
+}
+
+// New is used to create a nozzle for F5 BIG-IP Access Policy Manager (APM)
+// and accepts the following configuration options:
+//
+// domain
+//
+// The hostname of the APM virtual server (e.g. vpn.example.org), optionally
+// with a port.
+//
+// realm
+//
+// The authentication domain submitted with the credentials, for logon pages
+// which prompt for one (their "domain" field).
+//
+// capture_session
+//
+// If "true", the session cookies of users with valid credentials are captured
+// in the (sealed) session of the result.
+//
+// capture_response
+//
+// If "true", the raw response of each login is captured in the (sealed)
+// response of the result, so that it can be re-classified later.
+func (Driver) New(opts map[string]string) (nozzle.Nozzle, error) {
+	domain, ok := opts["domain"]
+	if !ok {
+		return nil, fmt.Errorf("bigip nozzle requires 'domain' config parameter")
+	}
+	err := util.ValidateHost(domain)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bigip domain: %w", err)
+	}
+
+	return &Nozzle{
+		Domain:          domain,
+		Realm:           opts["realm"],
+		UserAgent:       FrozenUserAgent,
+		CaptureSession:  opts["capture_session"] == "true",
+		CaptureResponse: opts["capture_response"] == "true",
+	}, nil
+}
+
+// Nozzle implements the nozzle.Nozzle interface for BIG-IP APM.
+type Nozzle struct {
+	// Domain is the hostname of the virtual server
+	Domain string
+
+	// Realm is the authentication domain submitted with the credentials
+	Realm string
+
+	// UserAgent will override the Go-http-client user-agent in requests
+	UserAgent string
+
+	// CaptureSession captures the session cookies of valid users
+	CaptureSession bool
+
+	// CaptureResponse captures the raw response of each login
+	CaptureResponse bool
+}
+
+// Login fulfils the nozzle.Nozzle interface and submits the logon page of a
+// new access session. The outcome is given by the page the access policy ends
+// on. This function supports rate limiting and parses valid, invalid, locked
+// out and MFA responses.
+func (n *Nozzle) Login(ctx context.Context, username, password string) (*event.AuthResponse, error) {
+	err := RateLimiter.Wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// every attempt runs in its own access session, whose cookies are set
+	// when the virtual server redirects to the logon page
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{
+		Transport: http.DefaultClient.Transport,
+		Jar:       jar,
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", n.url("/"), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", n.UserAgent)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, rules.MaxBodySize)) // nolint:errcheck,gosec
+	resp.Body.Close()                                                      // nolint:errcheck,gosec
+	if resp.StatusCode != 200 {
+		return nil, retry.Errorf(retry.ClassifyStatus(resp.StatusCode),
+			"unable to start bigip access session: %d", resp.StatusCode)
+	}
+
+	form := url.Values{}
+	form.Set("username", username)
+	form.Set("password", password)
+	form.Set("vhost", "standard")
+	if n.Realm != "" {
+		form.Set("domain", n.Realm)
+	}
+	req, err = http.NewRequestWithContext(ctx, "POST", n.url("/my.policy"), strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", n.UserAgent)
+
+	resp, err = client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	r, err := rules.NewResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+	res, err := n.Classify(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.Valid && n.CaptureSession {
+		cookies := make(map[string]interface{})
+		for _, c := range jar.Cookies(resp.Request.URL) {
+			cookies[c.Name] = c.Value
+		}
+		res.Session = nozzle.Session(map[string]interface{}{
+			"cookies": cookies,
+		})
+	}
+	if n.CaptureResponse {
+		res.Response = r.Encode()
+	}
+	return res, nil
+}
+
+// Fingerprint fulfils the nozzle.Fingerprinter interface. it reads the title,
+// banner and server of the access policy's logon page. the access session
+// started by the request is abandoned without submitting credentials.
+func (n *Nozzle) Fingerprint(ctx context.Context, username string) (map[string]string, error) {
+	err := RateLimiter.Wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{
+		Transport: http.DefaultClient.Transport,
+		Jar:       jar,
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", n.url("/"), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", n.UserAgent)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	r, err := rules.NewResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+	if r.StatusCode != 200 {
+		return nil, fmt.Errorf("unexpected status code from bigip login page: %d", r.StatusCode)
+	}
+	return nozzle.PageFingerprint(r), nil
+}
+
+// Classify fulfils the nozzle.Classifier interface and classifies the page
+// the access policy ended on once the logon page was submitted.
+func (n *Nozzle) Classify(r *rules.Response) (*event.AuthResponse, error) {
+	if res, ok, err := rules.Classify("bigip", r); ok {
+		return res, err
+	}
+	if res, ok := rules.DetectCaptcha(r); ok {
+		return res, nil
+	}
+
+	switch r.StatusCode {
+	case 200:
+		body := bytes.ToLower(r.Body)
+		switch {
+		case containsAny(body, mfaMarkers):
+			return &event.AuthResponse{
+				Valid: true,
+				MFA:   true,
+			}, nil
+		case containsAny(body, passwordMarkers):
+			return &event.AuthResponse{
+				Valid: true,
+				Metadata: map[string]interface{}{
+					"password_change": true,
+				},
+			}, nil
+		case containsAny(body, lockedMarkers):
+			return &event.AuthResponse{
+				Locked: true,
+			}, nil
+		}
+
+		// failed logins end on the logon page again, or on the logout page
+		// once the policy allows no more attempts
+		return &event.AuthResponse{
+			Valid: containsAny(body, webtopMarkers),
+		}, nil
+	case 429:
+		return &event.AuthResponse{
+			RateLimited: true,
+		}, nil
+	}
+
+	return nil, retry.Errorf(retry.ClassifyStatus(r.StatusCode),
+		"unhandled status code from bigip provider: %d", r.StatusCode)
+}
+
+// containsAny returns true if the body contains one of the markers.
+func containsAny(body []byte, markers [][]byte) bool {
+	for _, marker := range markers {
+		if bytes.Contains(body, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// url returns the URL of an endpoint of the virtual server.
+func (n *Nozzle) url(endpoint string) string {
+	return fmt.Sprintf("https://%s%s", n.Domain, endpoint)
+}
@@ -0,0 +1,160 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigip
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/praetorian-inc/trident/pkg/nozzle"
+)
+
+func TestNew(t *testing.T) {
+	var testcases = []struct {
+		desc      string
+		opts      map[string]string
+		realm     string
+		expecterr bool
+	}{
+		{"domain", map[string]string{"domain": "vpn.example.org"}, "", false},
+		{"realm", map[string]string{"domain": "vpn.example.org", "realm": "EXAMPLE"}, "EXAMPLE", false},
+		{"url domain", map[string]string{"domain": "https://vpn.example.org/"}, "", true},
+		{"missing options", map[string]string{}, "", true},
+	}
+
+	for _, test := range testcases {
+		noz, err := nozzle.Open("bigip", test.opts)
+		if test.expecterr {
+			if err == nil {
+				t.Errorf("[%s] expected error", test.desc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("[%s] unexpected error: %s", test.desc, err)
+			continue
+		}
+		if realm := noz.(*Nozzle).Realm; realm != test.realm {
+			t.Errorf("[%s] realm was %s, expected %s", test.desc, realm, test.realm)
+		}
+	}
+}
+
+const logonPage = `<form id="auth_form" name="e1" method="post" action="/my.policy">
+<input type="text" name="username"><input type="password" name="password"></form>`
+
+func TestLogin(t *testing.T) {
+	var testcases = []struct {
+		desc     string
+		redirect string
+		page     string
+		valid    bool
+		mfa      bool
+		locked   bool
+		session  bool
+	}{
+		{"valid", "/vdesk/webtop.eui?webtop=/Common/webtop&webtop_type=webtop_full",
+			`<html><title>Webtop</title></html>`, true, false, false, true},
+		{"invalid", "", `<div class="logon_page_error">The username or password is not correct.</div>` + logonPage,
+			false, false, false, false},
+		{"radius challenge", "", `<input type="password" name="_F5_challenge">`, true, true, false, false},
+		{"locked", "", `Your account has been locked out.` + logonPage, false, false, true, false},
+	}
+
+	for _, test := range testcases {
+		srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.URL.Path == "/":
+				http.SetCookie(w, &http.Cookie{Name: "MRHSession", Value: "0123456789abcdef", Path: "/"})
+				http.Redirect(w, r, "/my.policy", 302)
+			case r.URL.Path == "/my.policy" && r.Method == "GET":
+				w.Write([]byte(logonPage)) // nolint:errcheck,gosec
+			case r.URL.Path == "/my.policy" && r.Method == "POST":
+				// the logon page is only accepted within an access session
+				c, err := r.Cookie("MRHSession")
+				if err != nil || c.Value != "0123456789abcdef" || r.FormValue("username") != "alice" {
+					w.WriteHeader(400)
+					return
+				}
+				if test.redirect != "" {
+					http.Redirect(w, r, test.redirect, 302)
+					return
+				}
+				w.Write([]byte(test.page)) // nolint:errcheck,gosec
+			case strings.HasPrefix(r.URL.Path, "/vdesk/"):
+				w.Write([]byte(test.page)) // nolint:errcheck,gosec
+			default:
+				w.WriteHeader(404)
+			}
+		}))
+
+		client := http.DefaultClient
+		http.DefaultClient = srv.Client()
+
+		noz := &Nozzle{Domain: strings.TrimPrefix(srv.URL, "https://"), CaptureSession: true}
+		res, err := noz.Login(context.Background(), "alice", "Password1!")
+
+		http.DefaultClient = client
+		srv.Close()
+
+		if err != nil {
+			t.Errorf("[%s] unexpected error: %s", test.desc, err)
+			continue
+		}
+		if res.Valid != test.valid || res.MFA != test.mfa || res.Locked != test.locked {
+			t.Errorf("[%s] unexpected response %+v", test.desc, res)
+		}
+		if session := strings.Contains(res.Session, "MRHSession"); session != (test.session || test.mfa) {
+			t.Errorf("[%s] unexpected session %q", test.desc, res.Session)
+		}
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			http.SetCookie(w, &http.Cookie{Name: "MRHSession", Value: "0123456789abcdef", Path: "/"})
+			http.Redirect(w, r, "/my.policy", 302)
+		case "/my.policy":
+			if r.Method != "GET" {
+				t.Errorf("unexpected %s request", r.Method)
+			}
+			w.Header().Set("Server", "BigIP")
+			w.Write([]byte(`<html><head><title>Example &amp; Co VPN</title></head>` + // nolint:errcheck,gosec
+				`<div id="banner_message">Authorized use <b>only</b>.</div>` + logonPage + `</html>`))
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer srv.Close()
+
+	client := http.DefaultClient
+	http.DefaultClient = srv.Client()
+	defer func() { http.DefaultClient = client }()
+
+	noz := &Nozzle{Domain: strings.TrimPrefix(srv.URL, "https://")}
+	features, err := noz.Fingerprint(context.Background(), "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if features["title"] != "Example & Co VPN" || features["banner"] != "Authorized use only." ||
+		features["server"] != "BigIP" {
+		t.Errorf("unexpected features %v", features)
+	}
+}
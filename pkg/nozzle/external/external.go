@@ -0,0 +1,199 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package external implements a nozzle which delegates logins to an
+// out-of-tree plugin, so that proprietary drivers can be used without
+// recompiling trident. A plugin is an executable in the worker's plugin
+// directory which is run once per login: it reads a Request as JSON from its
+// standard input and writes a Response as JSON to its standard output.
+package external
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/praetorian-inc/trident/pkg/event"
+	"github.com/praetorian-inc/trident/pkg/nozzle"
+	"github.com/praetorian-inc/trident/pkg/retry"
+)
+
+const (
+	// DefaultTimeout is the longest a plugin may take to answer a login,
+	// unless configured otherwise
+	DefaultTimeout = 30 * time.Second
+
+	// maxStderr is the number of bytes of a failing plugin's standard error
+	// included in the returned error
+	maxStderr = 512
+)
+
+// Dir is the directory plugins are loaded from. Campaigns may only select
+// plugins within it, so that they cannot run arbitrary commands on workers.
+// The nozzle is disabled if Dir is empty.
+var Dir = os.Getenv("TRIDENT_PLUGIN_DIR")
+
+// Driver implements the nozzle.Driver interface.
+type Driver struct{}
+
+func init() {
+	nozzle.Register("external", Driver{})
+}
+
+// New is used to create an external nozzle and accepts the following
+// configuration options:
+//
+// plugin
+//
+// The file name of the plugin within the plugin directory (TRIDENT_PLUGIN_DIR
+// on workers).
+//
+// timeout
+//
+// The longest the plugin may take to answer a login (default 30s).
+//
+// All options, including the above, are passed on to the plugin.
+func (Driver) New(opts map[string]string) (nozzle.Nozzle, error) {
+	if Dir == "" {
+		return nil, fmt.Errorf("external nozzle is disabled, TRIDENT_PLUGIN_DIR is not set")
+	}
+
+	name, ok := opts["plugin"]
+	if !ok {
+		return nil, fmt.Errorf("external nozzle requires 'plugin' config parameter")
+	}
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		return nil, fmt.Errorf("external nozzle plugin must be a file name, got %q", name)
+	}
+	path, err := exec.LookPath(filepath.Join(Dir, name))
+	if err != nil {
+		return nil, fmt.Errorf("error loading plugin %s: %w", name, err)
+	}
+
+	timeout := DefaultTimeout
+	if v, ok := opts["timeout"]; ok {
+		timeout, err = time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing external nozzle timeout: %w", err)
+		}
+	}
+
+	return &Nozzle{
+		Path:    path,
+		Timeout: timeout,
+		Options: opts,
+	}, nil
+}
+
+// Nozzle implements the nozzle.Nozzle interface by running a plugin.
+type Nozzle struct {
+	// Path is the path of the plugin executable
+	Path string
+
+	// Timeout is the longest the plugin may take to answer a login
+	Timeout time.Duration
+
+	// Options are the configuration options passed on to the plugin
+	Options map[string]string
+}
+
+// Request is written to the standard input of a plugin.
+type Request struct {
+	Username string            `json:"username"`
+	Password string            `json:"password"`
+	Options  map[string]string `json:"options"`
+}
+
+// Response is read from the standard output of a plugin. Plugins which could
+// not complete the login set Error, and optionally Class (see the retry
+// package) to control whether it is retried.
+type Response struct {
+	Valid         bool                   `json:"valid"`
+	Locked        bool                   `json:"locked"`
+	MFA           bool                   `json:"mfa"`
+	RateLimited   bool                   `json:"rate_limited"`
+	Captcha       bool                   `json:"captcha"`
+	PolicyBlocked bool                   `json:"policy_blocked"`
+	Metadata      map[string]interface{} `json:"metadata"`
+	Session       map[string]interface{} `json:"session,omitempty"`
+	Error         string                 `json:"error,omitempty"`
+	Class         string                 `json:"class,omitempty"`
+}
+
+// Login fulfils the nozzle.Nozzle interface by running the plugin with the
+// credential. Plugins are responsible for their own rate limiting.
+func (n *Nozzle) Login(ctx context.Context, username, password string) (*event.AuthResponse, error) {
+	input, err := json.Marshal(Request{Username: username, Password: password, Options: n.Options})
+	if err != nil {
+		return nil, err
+	}
+
+	pctx, cancel := context.WithTimeout(ctx, n.Timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(pctx, n.Path) //nolint:gosec
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if pctx.Err() == context.DeadlineExceeded {
+		return nil, retry.Errorf(retry.ClassTimeout, "plugin %s timed out after %s", n.Path, n.Timeout)
+	}
+	if err != nil {
+		msg := stderr.String()
+		if len(msg) > maxStderr {
+			msg = msg[:maxStderr]
+		}
+		return nil, fmt.Errorf("plugin %s failed: %w: %s", n.Path, err, strings.TrimSpace(msg))
+	}
+
+	var resp Response
+	err = json.Unmarshal(stdout.Bytes(), &resp)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing plugin %s response: %w", n.Path, err)
+	}
+	if resp.Error != "" {
+		class := retry.Class(resp.Class)
+		if class == "" {
+			class = retry.ClassUnknown
+		}
+		return nil, retry.New(class, errors.New(resp.Error))
+	}
+
+	r := &event.AuthResponse{
+		Valid:         resp.Valid,
+		Locked:        resp.Locked,
+		MFA:           resp.MFA,
+		RateLimited:   resp.RateLimited,
+		Captcha:       resp.Captcha,
+		PolicyBlocked: resp.PolicyBlocked,
+		Metadata:      resp.Metadata,
+	}
+	if resp.Session != nil {
+		r.Session = nozzle.Session(resp.Session)
+	}
+	return r, nil
+}
@@ -0,0 +1,143 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package external
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/praetorian-inc/trident/pkg/nozzle"
+	"github.com/praetorian-inc/trident/pkg/retry"
+)
+
+// plugin answers logins based on the password, echoing the tenant option
+const plugin = `#!/bin/sh
+read -r req
+case "$req" in
+*'"password":"Password1"'*)
+	echo '{"valid":true,"mfa":true,"session":{"token":"abc"}}' ;;
+*'"password":"Locked1"'*)
+	echo '{"locked":true}' ;;
+*'"password":"Slow1"'*)
+	exec sleep 5 ;;
+*'"password":"Broken1"'*)
+	echo 'tenant unreachable' >&2; exit 1 ;;
+*'"tenant":"example"'*)
+	echo '{"valid":false,"metadata":{"tenant":"example"}}' ;;
+*)
+	echo '{"error":"unknown tenant","class":"config"}' ;;
+esac
+`
+
+func setup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "plugins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(dir) // nolint:errcheck,gosec
+	})
+	err = ioutil.WriteFile(filepath.Join(dir, "example"), []byte(plugin), 0700) //nolint:gosec
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "data"), []byte("{}"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	orig := Dir
+	Dir = dir
+	t.Cleanup(func() { Dir = orig })
+}
+
+func TestNew(t *testing.T) {
+	setup(t)
+
+	var testcases = []struct {
+		desc      string
+		opts      map[string]string
+		expecterr bool
+	}{
+		{"plugin", map[string]string{"plugin": "example"}, false},
+		{"timeout", map[string]string{"plugin": "example", "timeout": "5s"}, false},
+		{"invalid timeout", map[string]string{"plugin": "example", "timeout": "soon"}, true},
+		{"missing plugin", map[string]string{"plugin": "missing"}, true},
+		{"not executable", map[string]string{"plugin": "data"}, true},
+		{"path", map[string]string{"plugin": "../bin/sh"}, true},
+		{"absolute path", map[string]string{"plugin": "/bin/sh"}, true},
+		{"missing options", map[string]string{}, true},
+	}
+
+	for _, test := range testcases {
+		_, err := nozzle.Open("external", test.opts)
+		if (err != nil) != test.expecterr {
+			t.Errorf("[%s] unexpected error: %v", test.desc, err)
+		}
+	}
+
+	Dir = ""
+	_, err := nozzle.Open("external", map[string]string{"plugin": "example"})
+	if err == nil {
+		t.Error("expected the nozzle to be disabled without a plugin directory")
+	}
+}
+
+func TestLogin(t *testing.T) {
+	setup(t)
+
+	noz, err := nozzle.Open("external", map[string]string{"plugin": "example", "tenant": "example",
+		"timeout": "1s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := noz.Login(context.Background(), "alice@example.org", "Password1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Valid || !resp.MFA || resp.Session != `{"token":"abc"}` {
+		t.Errorf("unexpected valid response %+v", resp)
+	}
+
+	resp, err = noz.Login(context.Background(), "alice@example.org", "Locked1")
+	if err != nil || resp.Valid || !resp.Locked {
+		t.Errorf("unexpected locked response %+v (%v)", resp, err)
+	}
+
+	resp, err = noz.Login(context.Background(), "alice@example.org", "Winter2020")
+	if err != nil || resp.Valid || resp.Metadata["tenant"] != "example" {
+		t.Errorf("unexpected invalid response %+v (%v)", resp, err)
+	}
+
+	_, err = noz.Login(context.Background(), "alice@example.org", "Slow1")
+	if retry.Classify(err) != retry.ClassTimeout {
+		t.Errorf("expected a timeout, got %v", err)
+	}
+
+	_, err = noz.Login(context.Background(), "alice@example.org", "Broken1")
+	if err == nil || retry.Classify(err) != retry.ClassUnknown {
+		t.Errorf("expected the plugin to fail, got %v", err)
+	}
+
+	noz.(*Nozzle).Options = map[string]string{"tenant": "other"}
+	_, err = noz.Login(context.Background(), "alice@example.org", "Winter2020")
+	if retry.Classify(err) != retry.ClassConfig {
+		t.Errorf("expected a config error, got %v", err)
+	}
+}
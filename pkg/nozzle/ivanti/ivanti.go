@@ -0,0 +1,330 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ivanti
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/praetorian-inc/trident/pkg/event"
+	"github.com/praetorian-inc/trident/pkg/nozzle"
+	"github.com/praetorian-inc/trident/pkg/retry"
+	"github.com/praetorian-inc/trident/pkg/rules"
+	"github.com/praetorian-inc/trident/pkg/util"
+)
+
+const (
+	// FrozenUserAgent is a static user agent that we use for all requests. This
+	// value is based on the UA client hint work within browsers.
+	// Additional details: https://bugs.chromium.org/p/chromium/issues/detail?id=955620
+	FrozenUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64)" +
+		"AppleWebKit/537.36 (KHTML, like Gecko) Chrome/75.0.3764.0 Safari/537.36"
+
+	// SessionCookie is the cookie holding the session of a signed in user
+	SessionCookie = "DSID"
+)
+
+var (
+	// RateLimiter limits requests from the same worker to a maximum of 3/s
+	RateLimiter = rate.NewLimiter(rate.Every(300*time.Millisecond), 1)
+
+	// mfaPages are the sign-in pages which prompt for a second factor once
+	// the password was accepted (RADIUS challenges, TOTP and secondary
+	// authentication servers)
+	mfaPages = []string{"defender", "totp", "sec-auth", "secondary", "token"}
+)
+
+// Driver implements the nozzle.Driver interface.
+type Driver struct{}
+
+func init() {
+	nozzle.Register("ivanti", Driver{})
+}
+
+// New is used to create a nozzle for Ivanti Connect Secure (formerly Pulse
+// Connect Secure) and accepts the following configuration options:
+//
+// domain
+//
+// The hostname of the appliance (e.g. vpn.example.org), optionally with a port.
+//
+// realm
+//
+// The authentication realm users sign in to (default: Users).
+//
+// sign_in_url
+//
+// The path of the sign-in URL of the realm, if it is not the default sign-in
+// page (e.g. "/partners/" for https://vpn.example.org/partners/).
+//
+// capture_session
+//
+// If "true", the session cookies set for users with valid credentials are
+// captured in the (sealed) session of the result.
+//
+// capture_response
+//
+// If "true", the raw response of each login is captured in the (sealed)
+// response of the result, so that it can be re-classified later.
+func (Driver) New(opts map[string]string) (nozzle.Nozzle, error) {
+	domain, ok := opts["domain"]
+	if !ok {
+		return nil, fmt.Errorf("ivanti nozzle requires 'domain' config parameter")
+	}
+	err := util.ValidateHost(domain)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ivanti domain: %w", err)
+	}
+
+	realm, ok := opts["realm"]
+	if !ok {
+		realm = "Users"
+	}
+
+	// the login endpoint of the default sign-in URL is
+	// /dana-na/auth/url_default/login.cgi, other sign-in URLs are mapped to
+	// their own url_<n> path by the appliance and are reached through the
+	// redirect of their sign-in page
+	signIn := strings.Trim(opts["sign_in_url"], "/")
+
+	return &Nozzle{
+		Domain:          domain,
+		Realm:           realm,
+		SignInURL:       signIn,
+		UserAgent:       FrozenUserAgent,
+		CaptureSession:  opts["capture_session"] == "true",
+		CaptureResponse: opts["capture_response"] == "true",
+	}, nil
+}
+
+// Nozzle implements the nozzle.Nozzle interface for Ivanti Connect Secure.
+type Nozzle struct {
+	// Domain is the hostname of the appliance
+	Domain string
+
+	// Realm is the authentication realm users sign in to
+	Realm string
+
+	// SignInURL is the path of a custom sign-in URL, if any
+	SignInURL string
+
+	// UserAgent will override the Go-http-client user-agent in requests
+	UserAgent string
+
+	// CaptureSession captures the session cookies of valid users
+	CaptureSession bool
+
+	// CaptureResponse captures the raw response of each login
+	CaptureResponse bool
+}
+
+// Login fulfils the nozzle.Nozzle interface and performs an authentication
+// request against the sign-in page of the realm. This function supports rate
+// limiting and parses valid, invalid, locked out and MFA responses.
+func (n *Nozzle) Login(ctx context.Context, username, password string) (*event.AuthResponse, error) {
+	err := RateLimiter.Wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// redirects are not followed, the outcome of the login is given by the
+	// welcome page the appliance redirects to
+	client := &http.Client{
+		Transport: http.DefaultClient.Transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	endpoint, err := n.loginURL(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("tz_offset", "0")
+	form.Set("username", username)
+	form.Set("password", password)
+	form.Set("realm", n.Realm)
+	form.Set("btnSubmit", "Sign In")
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", n.UserAgent)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	r, err := rules.NewResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+	res, err := n.Classify(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.Valid && n.CaptureSession {
+		cookies := make(map[string]interface{})
+		for _, c := range resp.Cookies() {
+			cookies[c.Name] = c.Value
+		}
+		res.Session = nozzle.Session(map[string]interface{}{
+			"cookies": cookies,
+		})
+	}
+	if n.CaptureResponse {
+		res.Response = r.Encode()
+	}
+	return res, nil
+}
+
+// loginURL returns the login endpoint of the sign-in URL. custom sign-in URLs
+// redirect to the welcome page of their url_<n> path, whose login.cgi is the
+// endpoint.
+func (n *Nozzle) loginURL(ctx context.Context, client *http.Client) (string, error) {
+	if n.SignInURL == "" {
+		return n.url("/dana-na/auth/url_default/login.cgi"), nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", n.url("/"+n.SignInURL+"/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", n.UserAgent)
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close() // nolint:errcheck,gosec
+
+	location, err := resp.Location()
+	if err != nil || !strings.Contains(location.Path, "/dana-na/auth/") {
+		return "", retry.Errorf(retry.ClassConfig, "ivanti sign-in URL /%s/ does not redirect to a sign-in page",
+			n.SignInURL)
+	}
+	dir := location.Path[:strings.LastIndex(location.Path, "/")]
+	return n.url(dir + "/login.cgi"), nil
+}
+
+// Fingerprint fulfils the nozzle.Fingerprinter interface. it reads the title,
+// sign-in notice and server of the realm's sign-in page.
+func (n *Nozzle) Fingerprint(ctx context.Context, username string) (map[string]string, error) {
+	err := RateLimiter.Wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	page := n.url("/dana-na/auth/url_default/welcome.cgi")
+	if n.SignInURL != "" {
+		page = n.url("/" + n.SignInURL + "/")
+	}
+	client := &http.Client{Transport: http.DefaultClient.Transport}
+	req, err := http.NewRequestWithContext(ctx, "GET", page, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", n.UserAgent)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	r, err := rules.NewResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+	if r.StatusCode != 200 {
+		return nil, fmt.Errorf("unexpected status code from ivanti login page: %d", r.StatusCode)
+	}
+	return nozzle.PageFingerprint(r), nil
+}
+
+// Classify fulfils the nozzle.Classifier interface and classifies a response
+// of the login endpoint.
+func (n *Nozzle) Classify(r *rules.Response) (*event.AuthResponse, error) {
+	if res, ok, err := rules.Classify("ivanti", r); ok {
+		return res, err
+	}
+	if res, ok := rules.DetectCaptcha(r); ok {
+		return res, nil
+	}
+
+	switch r.StatusCode {
+	case 302, 303:
+		return classifyLocation(r.Header.Get("Location"))
+	case 429:
+		return &event.AuthResponse{
+			RateLimited: true,
+		}, nil
+	}
+
+	return nil, retry.Errorf(retry.ClassifyStatus(r.StatusCode),
+		"unhandled status code from ivanti provider: %d", r.StatusCode)
+}
+
+// classifyLocation classifies a login by the page it redirects to.
+func classifyLocation(location string) (*event.AuthResponse, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, retry.New(retry.ClassParse, err)
+	}
+	page := strings.ToLower(u.Query().Get("p"))
+	metadata := map[string]interface{}{"page": page}
+
+	switch {
+	case strings.HasPrefix(u.Path, "/dana/"):
+		// the user landed on their bookmarks (or the client starter page)
+		return &event.AuthResponse{Valid: true}, nil
+	case page == "failed":
+		return &event.AuthResponse{Valid: false}, nil
+	case page == "user-confirm":
+		// the user already has an active session
+		return &event.AuthResponse{Valid: true, Metadata: metadata}, nil
+	case strings.Contains(page, "password"):
+		// the password was accepted but has expired or must be changed
+		metadata["password_change"] = true
+		return &event.AuthResponse{Valid: true, Metadata: metadata}, nil
+	case page == "ip-blocked":
+		// the appliance blocks source addresses after repeated failures
+		return &event.AuthResponse{RateLimited: true, Metadata: metadata}, nil
+	case strings.Contains(page, "lock"):
+		return &event.AuthResponse{Locked: true, Metadata: metadata}, nil
+	}
+	for _, mfa := range mfaPages {
+		if strings.Contains(page, mfa) {
+			return &event.AuthResponse{Valid: true, MFA: true, Metadata: metadata}, nil
+		}
+	}
+
+	return nil, retry.Errorf(retry.ClassParse, "unexpected redirect from ivanti provider: %s", location)
+}
+
+// url returns the URL of an endpoint of the appliance.
+func (n *Nozzle) url(endpoint string) string {
+	return fmt.Sprintf("https://%s%s", n.Domain, endpoint)
+}
@@ -0,0 +1,170 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ivanti
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/praetorian-inc/trident/pkg/nozzle"
+)
+
+func TestNew(t *testing.T) {
+	var testcases = []struct {
+		desc      string
+		opts      map[string]string
+		realm     string
+		expecterr bool
+	}{
+		{"default realm", map[string]string{"domain": "vpn.example.org"}, "Users", false},
+		{"realm", map[string]string{"domain": "vpn.example.org:8443", "realm": "Contractors"}, "Contractors", false},
+		{"url domain", map[string]string{"domain": "https://vpn.example.org"}, "", true},
+		{"missing options", map[string]string{}, "", true},
+	}
+
+	for _, test := range testcases {
+		noz, err := nozzle.Open("ivanti", test.opts)
+		if test.expecterr {
+			if err == nil {
+				t.Errorf("[%s] expected error", test.desc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("[%s] unexpected error: %s", test.desc, err)
+			continue
+		}
+		if realm := noz.(*Nozzle).Realm; realm != test.realm {
+			t.Errorf("[%s] realm was %s, expected %s", test.desc, realm, test.realm)
+		}
+	}
+}
+
+func TestLogin(t *testing.T) {
+	var testcases = []struct {
+		desc     string
+		signIn   string
+		location string
+		status   int
+		valid    bool
+		mfa      bool
+		locked   bool
+		limited  bool
+		err      bool
+	}{
+		{"valid", "", "/dana/home/starter0.cgi?check=yes", 302, true, false, false, false, false},
+		{"custom sign-in url", "partners", "/dana/home/index.cgi", 302, true, false, false, false, false},
+		{"active session", "", "/dana-na/auth/url_default/welcome.cgi?p=user-confirm&id=1", 302,
+			true, false, false, false, false},
+		{"invalid", "", "/dana-na/auth/url_default/welcome.cgi?p=failed", 302, false, false, false, false, false},
+		{"radius challenge", "", "/dana-na/auth/url_default/welcome.cgi?p=defender&id=1", 302,
+			true, true, false, false, false},
+		{"expired password", "", "/dana-na/auth/url_default/welcome.cgi?p=passwordChange", 302,
+			true, false, false, false, false},
+		{"ip blocked", "", "/dana-na/auth/url_default/welcome.cgi?p=ip-blocked", 302,
+			false, false, false, true, false},
+		{"rate limited", "", "", 429, false, false, false, true, false},
+		{"unknown page", "", "/dana-na/auth/url_default/welcome.cgi?p=maintenance", 302,
+			false, false, false, false, true},
+	}
+
+	for _, test := range testcases {
+		srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/partners/":
+				http.Redirect(w, r, "/dana-na/auth/url_3/welcome.cgi", 302)
+				return
+			case "/dana-na/auth/url_default/login.cgi", "/dana-na/auth/url_3/login.cgi":
+			default:
+				w.WriteHeader(404)
+				return
+			}
+			if r.FormValue("username") != "alice" || r.FormValue("realm") != "Users" {
+				w.WriteHeader(400)
+				return
+			}
+			if test.location != "" {
+				w.Header().Set("Location", test.location)
+			}
+			w.WriteHeader(test.status)
+		}))
+
+		client := http.DefaultClient
+		http.DefaultClient = srv.Client()
+
+		noz := &Nozzle{Domain: strings.TrimPrefix(srv.URL, "https://"), Realm: "Users", SignInURL: test.signIn}
+		res, err := noz.Login(context.Background(), "alice", "Password1!")
+
+		http.DefaultClient = client
+		srv.Close()
+
+		if test.err {
+			if err == nil {
+				t.Errorf("[%s] expected error", test.desc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("[%s] unexpected error: %s", test.desc, err)
+			continue
+		}
+		if res.Valid != test.valid || res.MFA != test.mfa || res.Locked != test.locked ||
+			res.RateLimited != test.limited {
+			t.Errorf("[%s] unexpected response %+v", test.desc, res)
+		}
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/partners/":
+			http.Redirect(w, r, "/dana-na/auth/url_3/welcome.cgi", 302)
+		case "/dana-na/auth/url_default/welcome.cgi":
+			w.Write([]byte(`<title>Ivanti Connect Secure</title>`)) // nolint:errcheck,gosec
+		case "/dana-na/auth/url_3/welcome.cgi":
+			w.Write([]byte(`<title>Partner Portal</title>` + // nolint:errcheck,gosec
+				`<p class="notice">Partners must use their partner account.</p>`))
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer srv.Close()
+
+	client := http.DefaultClient
+	http.DefaultClient = srv.Client()
+	defer func() { http.DefaultClient = client }()
+
+	noz := &Nozzle{Domain: strings.TrimPrefix(srv.URL, "https://")}
+	features, err := noz.Fingerprint(context.Background(), "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if features["title"] != "Ivanti Connect Secure" || features["banner"] != "" {
+		t.Errorf("unexpected features of the default sign-in page %v", features)
+	}
+
+	noz.SignInURL = "partners"
+	features, err = noz.Fingerprint(context.Background(), "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if features["title"] != "Partner Portal" || features["banner"] != "Partners must use their partner account." {
+		t.Errorf("unexpected features of a custom sign-in page %v", features)
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mock implements a nozzle which does not contact any identity
+// provider. it is used to load test the trident pipeline (see the bench
+// command) with configurable latency, success ratio and failure behaviour.
+package mock
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/praetorian-inc/trident/pkg/event"
+	"github.com/praetorian-inc/trident/pkg/nozzle"
+	"github.com/praetorian-inc/trident/pkg/retry"
+)
+
+// Driver implements the nozzle.Driver interface.
+type Driver struct{}
+
+func init() {
+	nozzle.Register("mock", Driver{})
+}
+
+// New is used to create a mock nozzle and accepts the following configuration
+// options, all of which are optional:
+//
+// latency
+//
+// The time each login takes (e.g. 100ms). Defaults to 0.
+//
+// jitter
+//
+// A random duration of up to this value added to the latency. Defaults to 0.
+//
+// success_ratio
+//
+// The fraction of logins which are valid (0 to 1). Defaults to 0.
+//
+// mfa_ratio
+//
+// The fraction of valid logins which require MFA (0 to 1). Defaults to 0.
+//
+// rate_limit_ratio
+//
+// The fraction of logins which are rate limited (0 to 1). Defaults to 0.
+//
+// error_ratio
+//
+// The fraction of logins which fail with a transient server error (0 to 1).
+// Defaults to 0.
+//
+// valid_password
+//
+// If set, logins using this password are always valid and all other logins
+// are invalid (success_ratio is ignored).
+func (Driver) New(opts map[string]string) (nozzle.Nozzle, error) {
+	n := &Nozzle{
+		ValidPassword: opts["valid_password"],
+	}
+
+	durations := map[string]*time.Duration{
+		"latency": &n.Latency,
+		"jitter":  &n.Jitter,
+	}
+	for name, d := range durations {
+		if v, ok := opts[name]; ok {
+			parsed, err := time.ParseDuration(v)
+			if err != nil {
+				return nil, fmt.Errorf("mock nozzle has invalid '%s' config parameter: %w", name, err)
+			}
+			*d = parsed
+		}
+	}
+
+	ratios := map[string]*float64{
+		"success_ratio":    &n.SuccessRatio,
+		"mfa_ratio":        &n.MFARatio,
+		"rate_limit_ratio": &n.RateLimitRatio,
+		"error_ratio":      &n.ErrorRatio,
+	}
+	for name, r := range ratios {
+		if v, ok := opts[name]; ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil || parsed < 0 || parsed > 1 {
+				return nil, fmt.Errorf("mock nozzle requires '%s' config parameter between 0 and 1", name)
+			}
+			*r = parsed
+		}
+	}
+
+	return n, nil
+}
+
+// Nozzle implements the nozzle.Nozzle interface without making any requests.
+type Nozzle struct {
+	Latency        time.Duration
+	Jitter         time.Duration
+	SuccessRatio   float64
+	MFARatio       float64
+	RateLimitRatio float64
+	ErrorRatio     float64
+	ValidPassword  string
+}
+
+// Login fulfils the nozzle.Nozzle interface. it sleeps for the configured
+// latency and then returns a randomized outcome.
+func (n *Nozzle) Login(ctx context.Context, username, password string) (*event.AuthResponse, error) {
+	d := n.Latency
+	if n.Jitter > 0 {
+		d += time.Duration(rand.Int63n(int64(n.Jitter))) // nolint:gosec
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-t.C:
+	}
+
+	roll := rand.Float64() // nolint:gosec
+	if roll < n.ErrorRatio {
+		return nil, retry.Errorf(retry.ClassServer, "mock server error")
+	}
+	if roll < n.ErrorRatio+n.RateLimitRatio {
+		return &event.AuthResponse{RateLimited: true}, nil
+	}
+
+	valid := rand.Float64() < n.SuccessRatio // nolint:gosec
+	if n.ValidPassword != "" {
+		valid = password == n.ValidPassword
+	}
+
+	return &event.AuthResponse{
+		Valid: valid,
+		MFA:   valid && rand.Float64() < n.MFARatio, // nolint:gosec
+		Metadata: map[string]interface{}{
+			"mock": true,
+		},
+	}, nil
+}
@@ -0,0 +1,121 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mock
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/praetorian-inc/trident/pkg/nozzle"
+	"github.com/praetorian-inc/trident/pkg/retry"
+)
+
+type testcase struct {
+	desc        string
+	opts        map[string]string
+	password    string
+	valid       bool
+	rateLimited bool
+	errClass    retry.Class
+}
+
+func TestNozzle(t *testing.T) {
+	var testcases = []testcase{
+		{
+			desc:     "valid password",
+			opts:     map[string]string{"valid_password": "Password1!"},
+			password: "Password1!",
+			valid:    true,
+		},
+		{
+			desc:     "invalid password",
+			opts:     map[string]string{"valid_password": "Password1!"},
+			password: "Invalid1!",
+			valid:    false,
+		},
+		{
+			desc:     "always valid",
+			opts:     map[string]string{"success_ratio": "1"},
+			password: "Invalid1!",
+			valid:    true,
+		},
+		{
+			desc:        "always rate limited",
+			opts:        map[string]string{"rate_limit_ratio": "1"},
+			rateLimited: true,
+		},
+		{
+			desc:     "always errors",
+			opts:     map[string]string{"error_ratio": "1"},
+			errClass: retry.ClassServer,
+		},
+	}
+
+	for _, test := range testcases {
+		noz, err := nozzle.Open("mock", test.opts)
+		if err != nil {
+			t.Fatalf("%s: unable to open nozzle: %s", test.desc, err)
+		}
+
+		res, err := noz.Login(context.Background(), "alice@example.org", test.password)
+		if test.errClass != "" {
+			if retry.Classify(err) != test.errClass {
+				t.Errorf("%s: expected %s error, got %v", test.desc, test.errClass, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: error in login: %s", test.desc, err)
+		}
+		if res.Valid != test.valid || res.RateLimited != test.rateLimited {
+			t.Errorf("%s: unexpected response %+v", test.desc, res)
+		}
+	}
+}
+
+func TestOptions(t *testing.T) {
+	_, err := nozzle.Open("mock", map[string]string{"success_ratio": "2"})
+	if err == nil {
+		t.Errorf("expected error for out of range ratio")
+	}
+
+	noz, err := nozzle.Open("mock", map[string]string{"latency": "20ms"})
+	if err != nil {
+		t.Fatalf("unable to open nozzle: %s", err)
+	}
+	start := time.Now()
+	_, err = noz.Login(context.Background(), "alice@example.org", "Password1!")
+	if err != nil {
+		t.Fatalf("error in login: %s", err)
+	}
+	if time.Since(start) < 20*time.Millisecond {
+		t.Errorf("expected login to take at least 20ms")
+	}
+}
+
+func TestCancel(t *testing.T) {
+	noz, err := nozzle.Open("mock", map[string]string{"latency": "1m"})
+	if err != nil {
+		t.Fatalf("unable to open nozzle: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = noz.Login(ctx, "alice@example.org", "Password1!")
+	if retry.Classify(err) != retry.ClassTimeout {
+		t.Errorf("expected timeout error, got %v", err)
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netskope
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/praetorian-inc/trident/pkg/event"
+	"github.com/praetorian-inc/trident/pkg/nozzle"
+	"github.com/praetorian-inc/trident/pkg/retry"
+	"github.com/praetorian-inc/trident/pkg/rules"
+	"github.com/praetorian-inc/trident/pkg/util"
+)
+
+const (
+	// FrozenUserAgent is a static user agent that we use for all requests. This
+	// value is based on the UA client hint work within browsers.
+	// Additional details: https://bugs.chromium.org/p/chromium/issues/detail?id=955620
+	FrozenUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64)" +
+		"AppleWebKit/537.36 (KHTML, like Gecko) Chrome/75.0.3764.0 Safari/537.36"
+)
+
+var (
+	// RateLimiter limits requests from the same worker to a maximum of 3/s
+	RateLimiter = rate.NewLimiter(rate.Every(300*time.Millisecond), 1)
+
+	// AllowedDomains are the domain suffixes of Netskope tenants. tenants
+	// outside of these domains require the allow_custom_domain option.
+	AllowedDomains = []string{".goskope.com"}
+)
+
+// Driver implements the nozzle.Driver interface.
+type Driver struct{}
+
+func init() {
+	nozzle.Register("netskope", Driver{})
+}
+
+// New is used to create a Netskope nozzle and accepts the following
+// configuration options:
+//
+// tenant
+//
+// The name of the Netskope tenant. If administrators log in at
+// example.goskope.com, the value of tenant is "example".
+//
+// domain
+//
+// The full hostname of the tenant (e.g. example.eu.goskope.com). It takes
+// precedence over tenant and must belong to one of the AllowedDomains unless
+// allow_custom_domain is set.
+//
+// allow_custom_domain
+//
+// If "true", domain may be any hostname.
+//
+// capture_response
+//
+// If "true", the raw response of each login is captured in the (sealed)
+// response of the result, so that it can be re-classified later.
+func (Driver) New(opts map[string]string) (nozzle.Nozzle, error) {
+	domain, ok := opts["domain"]
+	if !ok {
+		tenant, ok := opts["tenant"]
+		if !ok {
+			return nil, fmt.Errorf("netskope nozzle requires 'domain' or 'tenant' config parameter")
+		}
+		domain = tenant + ".goskope.com"
+	}
+
+	err := validateDomain(domain, opts["allow_custom_domain"] == "true")
+	if err != nil {
+		return nil, err
+	}
+
+	return &Nozzle{
+		Domain:          domain,
+		UserAgent:       FrozenUserAgent,
+		CaptureResponse: opts["capture_response"] == "true",
+	}, nil
+}
+
+// validateDomain ensures the domain is a bare hostname and, unless custom
+// domains are allowed, that it belongs to one of the AllowedDomains.
+func validateDomain(domain string, allowCustom bool) error {
+	u, err := url.Parse("https://" + domain)
+	if err != nil {
+		return err
+	}
+	if u.Host != domain || u.Hostname() != domain || domain == "" {
+		return fmt.Errorf("netskope domain must be a hostname, got %q", domain)
+	}
+	if allowCustom {
+		return nil
+	}
+
+	for _, suffix := range AllowedDomains {
+		if util.ValidateURLSuffix(u.String(), suffix) == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("netskope domain %s is not a netskope domain, set allow_custom_domain to "+
+		"target a custom domain", domain)
+}
+
+// Nozzle implements the nozzle.Nozzle interface for Netskope.
+type Nozzle struct {
+	// Domain is the hostname of the Netskope tenant
+	Domain string
+
+	// UserAgent will override the Go-http-client user-agent in requests
+	UserAgent string
+
+	// CaptureResponse captures the raw response of each login
+	CaptureResponse bool
+}
+
+type netskopeAuthResponse struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// Login fulfils the nozzle.Nozzle interface and performs an authentication
+// request against the Netskope admin console. This function supports rate
+// limiting and parses valid, invalid, and locked out responses.
+func (n *Nozzle) Login(ctx context.Context, username, password string) (*event.AuthResponse, error) {
+	err := RateLimiter.Wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("username", username)
+	form.Set("password", password)
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("https://%s/login/authenticate", n.Domain),
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", n.UserAgent)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	r, err := rules.NewResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+	res, err := n.Classify(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if n.CaptureResponse {
+		res.Response = r.Encode()
+	}
+	return res, nil
+}
+
+// Classify fulfils the nozzle.Classifier interface and classifies a response
+// of the admin console login endpoint.
+func (n *Nozzle) Classify(r *rules.Response) (*event.AuthResponse, error) {
+	if res, ok, err := rules.Classify("netskope", r); ok {
+		return res, err
+	}
+	if res, ok := rules.DetectCaptcha(r); ok {
+		return res, nil
+	}
+
+	switch r.StatusCode {
+	case 200, 401, 403:
+		var res netskopeAuthResponse
+		err := json.Unmarshal(r.Body, &res)
+		if err != nil {
+			return nil, retry.New(retry.ClassParse, err)
+		}
+
+		status := strings.ToLower(res.Status)
+		switch {
+		case status == "success":
+			return &event.AuthResponse{
+				Valid: true,
+			}, nil
+		case strings.Contains(status, "mfa"):
+			return &event.AuthResponse{
+				Valid: true,
+				MFA:   true,
+			}, nil
+		}
+		return &event.AuthResponse{
+			Locked: strings.Contains(strings.ToLower(res.Message), "locked"),
+		}, nil
+	case 429:
+		return &event.AuthResponse{
+			RateLimited: true,
+		}, nil
+	}
+
+	return nil, retry.Errorf(retry.ClassifyStatus(r.StatusCode),
+		"unhandled status code from netskope provider: %d", r.StatusCode)
+}
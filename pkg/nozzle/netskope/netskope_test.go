@@ -0,0 +1,106 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netskope
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/praetorian-inc/trident/pkg/nozzle"
+)
+
+func TestNew(t *testing.T) {
+	var testcases = []struct {
+		desc      string
+		opts      map[string]string
+		domain    string
+		expecterr bool
+	}{
+		{"tenant", map[string]string{"tenant": "example"}, "example.goskope.com", false},
+		{"eu tenant", map[string]string{"domain": "example.eu.goskope.com"}, "example.eu.goskope.com", false},
+		{"custom domain", map[string]string{"domain": "sase.example.org"}, "", true},
+		{"allowed custom domain", map[string]string{"domain": "sase.example.org", "allow_custom_domain": "true"},
+			"sase.example.org", false},
+		{"path in tenant", map[string]string{"tenant": "example.org/"}, "", true},
+		{"missing options", map[string]string{}, "", true},
+	}
+
+	for _, test := range testcases {
+		noz, err := nozzle.Open("netskope", test.opts)
+		if test.expecterr {
+			if err == nil {
+				t.Errorf("[%s] expected error", test.desc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("[%s] unexpected error: %s", test.desc, err)
+			continue
+		}
+		if domain := noz.(*Nozzle).Domain; domain != test.domain {
+			t.Errorf("[%s] domain was %s, expected %s", test.desc, domain, test.domain)
+		}
+	}
+}
+
+func TestLogin(t *testing.T) {
+	var testcases = []struct {
+		desc    string
+		status  int
+		body    string
+		valid   bool
+		mfa     bool
+		locked  bool
+		limited bool
+	}{
+		{"valid", 200, `{"status":"success"}`, true, false, false, false},
+		{"mfa", 200, `{"status":"mfa_required"}`, true, true, false, false},
+		{"invalid", 401, `{"status":"error","message":"Invalid username or password"}`, false, false, false, false},
+		{"locked", 403, `{"status":"error","message":"Account locked"}`, false, false, true, false},
+		{"rate limited", 429, ``, false, false, false, true},
+	}
+
+	for _, test := range testcases {
+		srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/login/authenticate" || r.FormValue("username") != "alice@example.org" {
+				w.WriteHeader(400)
+				return
+			}
+			w.WriteHeader(test.status)
+			w.Write([]byte(test.body)) // nolint:errcheck,gosec
+		}))
+
+		client := http.DefaultClient
+		http.DefaultClient = srv.Client()
+
+		noz := &Nozzle{Domain: strings.TrimPrefix(srv.URL, "https://")}
+		res, err := noz.Login(context.Background(), "alice@example.org", "Password1!")
+
+		http.DefaultClient = client
+		srv.Close()
+
+		if err != nil {
+			t.Errorf("[%s] unexpected error: %s", test.desc, err)
+			continue
+		}
+		if res.Valid != test.valid || res.MFA != test.mfa || res.Locked != test.locked ||
+			res.RateLimited != test.limited {
+			t.Errorf("[%s] unexpected response %+v", test.desc, res)
+		}
+	}
+}
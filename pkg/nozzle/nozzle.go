@@ -29,7 +29,7 @@
 //  if err != nil {
 //      // handle error
 //  }
-//  resp, err := noz.Login("username", "password")
+//  resp, err := noz.Login(ctx, "username", "password")
 //  // ...
 //
 // See https://golang.org/doc/effective_go.html#blank_import for more
@@ -37,10 +37,16 @@
 package nozzle
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"html"
+	"regexp"
+	"strings"
 	"sync"
 
 	"github.com/praetorian-inc/trident/pkg/event"
+	"github.com/praetorian-inc/trident/pkg/rules"
 )
 
 var (
@@ -54,9 +60,29 @@ type Driver interface {
 }
 
 // Nozzle is the interface that wraps a basic Login() method to be implemented for
-// each authentication provider we support.
+// each authentication provider we support. Login must be safe to call from
+// multiple goroutines, and should abandon the login (including any requests
+// in flight) once ctx is cancelled or its deadline passes.
 type Nozzle interface {
-	Login(username, password string) (*event.AuthResponse, error)
+	Login(ctx context.Context, username, password string) (*event.AuthResponse, error)
+}
+
+// Classifier is implemented by nozzles which classify a single provider
+// response per login. responses captured with the capture_response option
+// can then be re-classified without repeating the login, e.g. after a fix to
+// the nozzle's classification.
+type Classifier interface {
+	Classify(resp *rules.Response) (*event.AuthResponse, error)
+}
+
+// Fingerprinter is implemented by nozzles which detect the tenant-level
+// security features of their provider (e.g. an Okta organization's pipeline
+// or a VPN portal's banner) with a single benign request, which never
+// attempts a login. username is one of the campaign's users, for providers
+// whose tenant is only known from the domain of its users. the features are
+// recorded with the campaign to inform scheduling and reporting.
+type Fingerprinter interface {
+	Fingerprint(ctx context.Context, username string) (map[string]string, error)
 }
 
 // Open opens a nozzle specified by the nozzle driver name (e.g. okta) and
@@ -88,3 +114,54 @@ func Register(name string, driver Driver) {
 	}
 	drivers[name] = driver
 }
+
+// Session encodes the session a nozzle captured after a successful login
+// (e.g. tokens or cookies) for the Session field of an AuthResponse. workers
+// seal sessions before they are published, and drop them if they cannot.
+func Session(values map[string]interface{}) string {
+	b, _ := json.Marshal(values)
+	return string(b)
+}
+
+var (
+	titlePattern  = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	bannerPattern = regexp.MustCompile(`(?is)<(?:div|p|span|td)[^>]*\s(?:id|class)="[^"]*` +
+		`(?:banner|disclaimer|notice)[^"]*"[^>]*>(.*?)</(?:div|p|span|td)>`)
+	tagPattern   = regexp.MustCompile(`<[^>]*>`)
+	punctPattern = regexp.MustCompile(`\s+([.,;:!?])`)
+)
+
+// maxBannerLength caps the characters of the page features of
+// PageFingerprint.
+const maxBannerLength = 256
+
+// PageFingerprint returns the features of a login page for a Fingerprinter:
+// its title, the text of its banner, disclaimer or notice, and the Server
+// header of the response, if any.
+func PageFingerprint(resp *rules.Response) map[string]string {
+	features := make(map[string]string)
+	if m := titlePattern.FindSubmatch(resp.Body); m != nil {
+		if title := pageText(m[1]); title != "" {
+			features["title"] = title
+		}
+	}
+	if m := bannerPattern.FindSubmatch(resp.Body); m != nil {
+		if banner := pageText(m[1]); banner != "" {
+			features["banner"] = banner
+		}
+	}
+	if server := resp.Header.Get("Server"); server != "" {
+		features["server"] = server
+	}
+	return features
+}
+
+// pageText strips the tags of an HTML fragment and collapses its whitespace.
+func pageText(fragment []byte) string {
+	text := html.UnescapeString(string(tagPattern.ReplaceAll(fragment, []byte(" "))))
+	text = punctPattern.ReplaceAllString(strings.Join(strings.Fields(text), " "), "$1")
+	if r := []rune(text); len(r) > maxBannerLength {
+		text = string(r[:maxBannerLength])
+	}
+	return text
+}
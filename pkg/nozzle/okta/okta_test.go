@@ -15,13 +15,21 @@
 package okta
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"math/rand"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/praetorian-inc/trident/pkg/egress"
 	"github.com/praetorian-inc/trident/pkg/nozzle"
+	"github.com/praetorian-inc/trident/pkg/replay"
+	"github.com/praetorian-inc/trident/pkg/rules"
 )
 
 func TestMain(m *testing.M) {
@@ -89,7 +97,7 @@ func TestNozzle(t *testing.T) {
 	}
 
 	for _, test := range testcases {
-		res, err := noz.Login(test.username, test.password)
+		res, err := noz.Login(context.Background(), test.username, test.password)
 		if err != nil {
 			//t.Errorf("error in login: %s", err)
 			//continue
@@ -107,3 +115,339 @@ func TestNozzle(t *testing.T) {
 		}
 	}
 }
+
+func TestNew(t *testing.T) {
+	var testcases = []struct {
+		desc      string
+		opts      map[string]string
+		domain    string
+		expecterr bool
+	}{
+		{"subdomain", map[string]string{"subdomain": "example"}, "example.okta.com", false},
+		{"emea cell", map[string]string{"domain": "example.okta-emea.com"}, "example.okta-emea.com", false},
+		{"preview cell", map[string]string{"domain": "example.oktapreview.com"}, "example.oktapreview.com", false},
+		{"domain overrides subdomain", map[string]string{"domain": "example.okta-emea.com", "subdomain": "other"},
+			"example.okta-emea.com", false},
+		{"custom domain", map[string]string{"domain": "id.example.org"}, "", true},
+		{"allowed custom domain", map[string]string{"domain": "id.example.org", "allow_custom_domain": "true"},
+			"id.example.org", false},
+		{"path in domain", map[string]string{"domain": "example.com/.okta.com"}, "", true},
+		{"path in subdomain", map[string]string{"subdomain": "example.com/"}, "", true},
+		{"path in custom domain", map[string]string{"domain": "id.example.org/x", "allow_custom_domain": "true"}, "", true},
+		{"missing options", map[string]string{}, "", true},
+		{"source ip", map[string]string{"subdomain": "example", "source_ip": "2001:db8::1"}, "example.okta.com", false},
+		{"invalid source ip", map[string]string{"subdomain": "example", "source_ip": "eth0"}, "", true},
+	}
+
+	for _, test := range testcases {
+		noz, err := nozzle.Open("okta", test.opts)
+		if test.expecterr {
+			if err == nil {
+				t.Errorf("[%s] expected error", test.desc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("[%s] unexpected error: %s", test.desc, err)
+			continue
+		}
+		if domain := noz.(*Nozzle).Domain; domain != test.domain {
+			t.Errorf("[%s] domain was %s, expected %s", test.desc, domain, test.domain)
+		}
+	}
+}
+
+func TestEnumerateFactors(t *testing.T) {
+	factors := []map[string]string{
+		{"id": "opf1", "factorType": "push", "provider": "OKTA"},
+		{"id": "sms1", "factorType": "sms", "provider": "OKTA"},
+	}
+
+	var testcases = []struct {
+		desc     string
+		embedded bool
+		paths    []string
+	}{
+		{"factors in response", true, []string{"/api/v1/authn", "/api/v1/authn/cancel"}},
+		{"state token follow-up", false, []string{"/api/v1/authn", "/api/v1/authn", "/api/v1/authn/cancel"}},
+	}
+
+	for _, test := range testcases {
+		var paths []string
+		srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			paths = append(paths, r.URL.Path)
+			var body map[string]string
+			json.NewDecoder(r.Body).Decode(&body) // nolint:errcheck,gosec
+
+			res := map[string]interface{}{
+				"status":     "MFA_REQUIRED",
+				"stateToken": "token",
+				"_embedded":  map[string]interface{}{},
+			}
+			if test.embedded || body["stateToken"] == "token" {
+				res["_embedded"] = map[string]interface{}{"factors": factors}
+			}
+			json.NewEncoder(w).Encode(res) // nolint:errcheck,gosec
+		}))
+
+		client := http.DefaultClient
+		http.DefaultClient = srv.Client()
+
+		noz := &Nozzle{
+			Domain:           strings.TrimPrefix(srv.URL, "https://"),
+			EnumerateFactors: true,
+		}
+		res, err := noz.Login(context.Background(), "alice@example.org", "Password1!")
+
+		http.DefaultClient = client
+		srv.Close()
+
+		if err != nil {
+			t.Errorf("[%s] unexpected error: %s", test.desc, err)
+			continue
+		}
+		if !res.Valid || !res.MFA {
+			t.Errorf("[%s] expected a valid MFA response, got %+v", test.desc, res)
+		}
+		got, ok := res.Metadata["factors"].([]Factor)
+		if !ok || len(got) != 2 || got[0].Type != "push" || got[1].Type != "sms" {
+			t.Errorf("[%s] unexpected factors %+v", test.desc, res.Metadata["factors"])
+		}
+		if res.Metadata["push_enrolled"] != true {
+			t.Errorf("[%s] expected push_enrolled", test.desc)
+		}
+		if strings.Join(paths, ",") != strings.Join(test.paths, ",") {
+			t.Errorf("[%s] requested %v, expected %v", test.desc, paths, test.paths)
+		}
+	}
+}
+
+func TestEnumerateFactorsFailure(t *testing.T) {
+	var paths []string
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body) // nolint:errcheck,gosec
+
+		if body["stateToken"] == "token" && r.URL.Path == "/api/v1/authn" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`{"status":"MFA_REQUIRED","stateToken":"token","_embedded":{}}`)) // nolint:errcheck,gosec
+	}))
+	defer srv.Close()
+
+	client := http.DefaultClient
+	http.DefaultClient = srv.Client()
+	defer func() { http.DefaultClient = client }()
+
+	noz := &Nozzle{Domain: strings.TrimPrefix(srv.URL, "https://"), EnumerateFactors: true}
+	res, err := noz.Login(context.Background(), "alice@example.org", "Password1!")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !res.Valid || !res.MFA {
+		t.Errorf("expected a valid MFA response, got %+v", res)
+	}
+	if _, ok := res.Metadata["factors"]; ok || res.Metadata["factors_error"] == nil {
+		t.Errorf("expected the factors error to be recorded, got %+v", res.Metadata)
+	}
+	if want := "/api/v1/authn,/api/v1/authn,/api/v1/authn/cancel"; strings.Join(paths, ",") != want {
+		t.Errorf("requested %v, expected %s", paths, want)
+	}
+}
+
+func TestDeniedByPolicy(t *testing.T) {
+	var testcases = []struct {
+		desc    string
+		status  int
+		body    string
+		valid   bool
+		limited bool
+		err     bool
+	}{
+		{"invalid", 401, `{"errorCode":"E0000004","errorSummary":"Authentication failed"}`, false, false, false},
+		{"denied by policy", 403, `{"errorCode":"E0000006","errorSummary":"You do not have permission to ` +
+			`perform the requested action"}`, false, true, false},
+		{"other forbidden", 403, `{"errorCode":"E0000005","errorSummary":"Invalid session"}`, false, false, true},
+	}
+
+	for _, test := range testcases {
+		srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(test.status)
+			w.Write([]byte(test.body)) // nolint:errcheck,gosec
+		}))
+
+		client := http.DefaultClient
+		http.DefaultClient = srv.Client()
+
+		noz := &Nozzle{Domain: strings.TrimPrefix(srv.URL, "https://")}
+		res, err := noz.Login(context.Background(), "alice@example.org", "Password1!")
+
+		http.DefaultClient = client
+		srv.Close()
+
+		if test.err {
+			if err == nil {
+				t.Errorf("[%s] expected error", test.desc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("[%s] unexpected error: %s", test.desc, err)
+			continue
+		}
+		if res.Valid != test.valid || res.RateLimited != test.limited || res.PolicyBlocked {
+			t.Errorf("[%s] unexpected response %+v", test.desc, res)
+		}
+	}
+}
+
+func TestCaptureResponse(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":"LOCKED_OUT"}`)) // nolint:errcheck,gosec
+	}))
+	defer srv.Close()
+
+	client := http.DefaultClient
+	http.DefaultClient = srv.Client()
+	defer func() { http.DefaultClient = client }()
+
+	noz := &Nozzle{Domain: strings.TrimPrefix(srv.URL, "https://"), CaptureResponse: true}
+	res, err := noz.Login(context.Background(), "alice@example.org", "Password1!")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !res.Locked || res.Response == "" {
+		t.Fatalf("expected a locked response with the raw response, got %+v", res)
+	}
+
+	r, err := rules.DecodeResponse(res.Response)
+	if err != nil {
+		t.Fatalf("unable to decode response: %s", err)
+	}
+	reclassified, err := noz.Classify(r)
+	if err != nil || !reclassified.Locked || reclassified.Valid {
+		t.Errorf("unexpected re-classification %+v, %v", reclassified, err)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	recordings, err := replay.Load("testdata/recordings.jsonl")
+	if err != nil {
+		t.Fatalf("unable to load recordings: %s", err)
+	}
+	for _, m := range replay.Replay(&Nozzle{}, recordings) {
+		t.Error(m)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	client := http.DefaultClient
+	http.DefaultClient = srv.Client()
+	defer func() { http.DefaultClient = client }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// the login is abandoned either while waiting on the rate limiter or
+	// while waiting on the provider
+	noz := &Nozzle{Domain: strings.TrimPrefix(srv.URL, "https://")}
+	start := time.Now()
+	_, err := noz.Login(ctx, "alice@example.org", "Password1!")
+	if err == nil {
+		t.Fatalf("expected an error once the context expires")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("login was not abandoned once the context expired")
+	}
+}
+
+func TestEgress(t *testing.T) {
+	remote := make(chan string, 1)
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remote <- r.RemoteAddr
+		w.WriteHeader(401)
+		fmt.Fprint(w, `{"errorCode":"E0000004"}`)
+	}))
+	defer srv.Close()
+
+	client := http.DefaultClient
+	http.DefaultClient = srv.Client()
+	defer func() { http.DefaultClient = client }()
+
+	bind, err := egress.Parse(map[string]string{"source_ip": "127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	noz := &Nozzle{Domain: strings.TrimPrefix(srv.URL, "https://"), Egress: bind}
+
+	res, err := noz.Login(context.Background(), "user@example.org", "Password1!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Valid {
+		t.Errorf("expected an invalid login")
+	}
+	if addr := <-remote; !strings.HasPrefix(addr, "127.0.0.1:") {
+		t.Errorf("request sent from %s, expected 127.0.0.1", addr)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	var testcases = []struct {
+		desc     string
+		status   int
+		body     string
+		features map[string]string
+		err      bool
+	}{
+		{"identity engine", 200, `{"id":"00o1a2b3c4","pipeline":"idx","_links":{}}`,
+			map[string]string{"org_id": "00o1a2b3c4", "pipeline": "idx", "engine": "identity engine",
+				"rate_limit": "100"}, false},
+		{"classic", 200, `{"id":"00o5d6e7f8","pipeline":"v1"}`,
+			map[string]string{"org_id": "00o5d6e7f8", "pipeline": "v1", "engine": "classic",
+				"rate_limit": "100"}, false},
+		{"blocked", 403, `{"errorCode":"E0000006"}`, map[string]string{"blocked": "true", "rate_limit": "100"}, false},
+		{"not found", 404, `{"errorCode":"E0000007"}`, nil, true},
+	}
+
+	for _, test := range testcases {
+		srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != "GET" || r.URL.Path != "/.well-known/okta-organization" {
+				t.Errorf("[%s] unexpected request %s %s", test.desc, r.Method, r.URL.Path)
+			}
+			w.Header().Set("X-Rate-Limit-Limit", "100")
+			w.WriteHeader(test.status)
+			w.Write([]byte(test.body)) // nolint:errcheck,gosec
+		}))
+
+		client := http.DefaultClient
+		http.DefaultClient = srv.Client()
+
+		noz := &Nozzle{Domain: strings.TrimPrefix(srv.URL, "https://")}
+		features, err := noz.Fingerprint(context.Background(), "alice@example.org")
+
+		http.DefaultClient = client
+		srv.Close()
+
+		if test.err {
+			if err == nil {
+				t.Errorf("[%s] expected error", test.desc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("[%s] unexpected error: %s", test.desc, err)
+			continue
+		}
+		if fmt.Sprint(features) != fmt.Sprint(test.features) {
+			t.Errorf("[%s] got features %v, expected %v", test.desc, features, test.features)
+		}
+	}
+}
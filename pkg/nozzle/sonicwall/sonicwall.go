@@ -0,0 +1,302 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sonicwall
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/praetorian-inc/trident/pkg/event"
+	"github.com/praetorian-inc/trident/pkg/nozzle"
+	"github.com/praetorian-inc/trident/pkg/retry"
+	"github.com/praetorian-inc/trident/pkg/rules"
+	"github.com/praetorian-inc/trident/pkg/util"
+)
+
+const (
+	// FrozenUserAgent is a static user agent that we use for all requests. This
+	// value is based on the UA client hint work within browsers.
+	// Additional details: https://bugs.chromium.org/p/chromium/issues/detail?id=955620
+	FrozenUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64)" +
+		"AppleWebKit/537.36 (KHTML, like Gecko) Chrome/75.0.3764.0 Safari/537.36"
+
+	// SessionCookie is the cookie holding the session of a signed in user
+	SessionCookie = "swap"
+)
+
+var (
+	// RateLimiter limits requests from the same worker to a maximum of 3/s
+	RateLimiter = rate.NewLimiter(rate.Every(300*time.Millisecond), 1)
+
+	// mfaMarkers are found in the response prompting a user whose password
+	// was accepted for a one-time password
+	mfaMarkers = [][]byte{[]byte("one time password"), []byte("one-time password"),
+		[]byte("two-factor"), []byte("otpcode"), []byte("tfacode")}
+
+	// lockedMarkers are found in the response of a locked out user
+	lockedMarkers = [][]byte{[]byte("locked out"), []byte("account is locked"),
+		[]byte("account has been locked")}
+)
+
+// Driver implements the nozzle.Driver interface.
+type Driver struct{}
+
+func init() {
+	nozzle.Register("sonicwall", Driver{})
+}
+
+// New is used to create a nozzle for the SSL VPN portals of SonicWall Secure
+// Mobile Access (SMA 100 series) and SonicOS firewalls, which NetExtender signs
+// in to, and accepts the following configuration options:
+//
+// domain
+//
+// The hostname of the portal (e.g. vpn.example.org), optionally with a port
+// (SonicOS firewalls usually serve it on port 4433).
+//
+// realm
+//
+// The authentication domain users sign in to (default: LocalDomain).
+//
+// portal
+//
+// The name of the portal (default: VirtualOffice).
+//
+// capture_session
+//
+// If "true", the session cookies set for users with valid credentials are
+// captured in the (sealed) session of the result.
+//
+// capture_response
+//
+// If "true", the raw response of each login is captured in the (sealed)
+// response of the result, so that it can be re-classified later.
+func (Driver) New(opts map[string]string) (nozzle.Nozzle, error) {
+	domain, ok := opts["domain"]
+	if !ok {
+		return nil, fmt.Errorf("sonicwall nozzle requires 'domain' config parameter")
+	}
+	err := util.ValidateHost(domain)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sonicwall domain: %w", err)
+	}
+
+	realm, ok := opts["realm"]
+	if !ok {
+		realm = "LocalDomain"
+	}
+	portal, ok := opts["portal"]
+	if !ok {
+		portal = "VirtualOffice"
+	}
+
+	return &Nozzle{
+		Domain:          domain,
+		Realm:           realm,
+		Portal:          portal,
+		UserAgent:       FrozenUserAgent,
+		CaptureSession:  opts["capture_session"] == "true",
+		CaptureResponse: opts["capture_response"] == "true",
+	}, nil
+}
+
+// Nozzle implements the nozzle.Nozzle interface for SonicWall SSL VPN portals.
+type Nozzle struct {
+	// Domain is the hostname of the portal
+	Domain string
+
+	// Realm is the authentication domain users sign in to
+	Realm string
+
+	// Portal is the name of the portal
+	Portal string
+
+	// UserAgent will override the Go-http-client user-agent in requests
+	UserAgent string
+
+	// CaptureSession captures the session cookies of valid users
+	CaptureSession bool
+
+	// CaptureResponse captures the raw response of each login
+	CaptureResponse bool
+}
+
+// Login fulfils the nozzle.Nozzle interface and performs an authentication
+// request against the login endpoint used by the portal and NetExtender. This
+// function supports rate limiting and parses valid, invalid, locked out and
+// MFA responses.
+func (n *Nozzle) Login(ctx context.Context, username, password string) (*event.AuthResponse, error) {
+	err := RateLimiter.Wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("username", username)
+	form.Set("password", password)
+	form.Set("domain", n.Realm)
+	form.Set("portalname", n.Portal)
+	form.Set("state", "login")
+	form.Set("login", "true")
+	form.Set("verifyCert", "0")
+	form.Set("ajax", "true")
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("https://%s/cgi-bin/userLogin", n.Domain),
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", n.UserAgent)
+
+	// redirects are not followed so that the session cookie of the login
+	// response can be inspected
+	client := &http.Client{
+		Transport: http.DefaultClient.Transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	r, err := rules.NewResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+	res, err := n.Classify(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.Valid && n.CaptureSession {
+		cookies := make(map[string]interface{})
+		for _, c := range resp.Cookies() {
+			cookies[c.Name] = c.Value
+		}
+		res.Session = nozzle.Session(map[string]interface{}{
+			"cookies": cookies,
+		})
+	}
+	if n.CaptureResponse {
+		res.Response = r.Encode()
+	}
+	return res, nil
+}
+
+// Fingerprint fulfils the nozzle.Fingerprinter interface. it reads the title,
+// login banner and server of the portal's login page.
+func (n *Nozzle) Fingerprint(ctx context.Context, username string) (map[string]string, error) {
+	err := RateLimiter.Wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Transport: http.DefaultClient.Transport}
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("https://%s/cgi-bin/welcome", n.Domain), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", n.UserAgent)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	r, err := rules.NewResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+	if r.StatusCode != 200 {
+		return nil, fmt.Errorf("unexpected status code from sonicwall login page: %d", r.StatusCode)
+	}
+	return nozzle.PageFingerprint(r), nil
+}
+
+// Classify fulfils the nozzle.Classifier interface and classifies a response
+// of the login endpoint. the portal sets a session cookie once the password
+// is accepted, including for users who are then prompted for a one-time
+// password.
+func (n *Nozzle) Classify(r *rules.Response) (*event.AuthResponse, error) {
+	if res, ok, err := rules.Classify("sonicwall", r); ok {
+		return res, err
+	}
+	if res, ok := rules.DetectCaptcha(r); ok {
+		return res, nil
+	}
+
+	switch r.StatusCode {
+	case 200, 302, 303:
+		body := bytes.ToLower(r.Body)
+		switch {
+		case containsAny(body, mfaMarkers):
+			return &event.AuthResponse{
+				Valid: true,
+				MFA:   true,
+			}, nil
+		case containsAny(body, lockedMarkers):
+			return &event.AuthResponse{
+				Locked: true,
+			}, nil
+		}
+
+		return &event.AuthResponse{
+			Valid: hasSession(r.Header),
+		}, nil
+	case 429:
+		return &event.AuthResponse{
+			RateLimited: true,
+		}, nil
+	}
+
+	return nil, retry.Errorf(retry.ClassifyStatus(r.StatusCode),
+		"unhandled status code from sonicwall provider: %d", r.StatusCode)
+}
+
+// containsAny returns true if the body contains one of the markers.
+func containsAny(body []byte, markers [][]byte) bool {
+	for _, marker := range markers {
+		if bytes.Contains(body, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasSession returns true if the response sets a session cookie. cookies
+// which the response clears (empty, expired or "deleted") do not count.
+func hasSession(header http.Header) bool {
+	resp := http.Response{Header: header}
+	for _, c := range resp.Cookies() {
+		if c.Name != SessionCookie || c.Value == "" || strings.EqualFold(c.Value, "deleted") {
+			continue
+		}
+		if c.MaxAge < 0 || (!c.Expires.IsZero() && c.Expires.Before(time.Now())) {
+			continue
+		}
+		return true
+	}
+	return false
+}
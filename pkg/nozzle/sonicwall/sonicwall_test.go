@@ -0,0 +1,141 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sonicwall
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/praetorian-inc/trident/pkg/nozzle"
+)
+
+func TestNew(t *testing.T) {
+	var testcases = []struct {
+		desc      string
+		opts      map[string]string
+		realm     string
+		expecterr bool
+	}{
+		{"default realm", map[string]string{"domain": "vpn.example.org"}, "LocalDomain", false},
+		{"realm", map[string]string{"domain": "fw.example.org:4433", "realm": "EXAMPLE"}, "EXAMPLE", false},
+		{"path in domain", map[string]string{"domain": "vpn.example.org/cgi-bin"}, "", true},
+		{"missing options", map[string]string{}, "", true},
+	}
+
+	for _, test := range testcases {
+		noz, err := nozzle.Open("sonicwall", test.opts)
+		if test.expecterr {
+			if err == nil {
+				t.Errorf("[%s] expected error", test.desc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("[%s] unexpected error: %s", test.desc, err)
+			continue
+		}
+		if realm := noz.(*Nozzle).Realm; realm != test.realm {
+			t.Errorf("[%s] realm was %s, expected %s", test.desc, realm, test.realm)
+		}
+	}
+}
+
+func TestLogin(t *testing.T) {
+	var testcases = []struct {
+		desc    string
+		status  int
+		cookie  string
+		body    string
+		valid   bool
+		mfa     bool
+		locked  bool
+		limited bool
+	}{
+		{"valid", 200, "swap=T3BlbiBTZXNhbWU=; path=/; secure", "", true, false, false, false},
+		{"invalid", 200, "swap=; path=/", "Invalid username or password", false, false, false, false},
+		{"cleared session", 200, "swap=deleted; Max-Age=0", "", false, false, false, false},
+		{"mfa", 200, "swap=T3BlbiBTZXNhbWU=", "Please enter your One Time Password", true, true, false, false},
+		{"locked", 200, "", "Your account has been locked out", false, false, true, false},
+		{"rate limited", 429, "", "", false, false, false, true},
+	}
+
+	for _, test := range testcases {
+		srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/cgi-bin/userLogin" || r.FormValue("username") != "alice" ||
+				r.FormValue("domain") != "LocalDomain" || r.FormValue("login") != "true" {
+				w.WriteHeader(400)
+				return
+			}
+			if test.cookie != "" {
+				w.Header().Set("Set-Cookie", test.cookie)
+			}
+			w.WriteHeader(test.status)
+			w.Write([]byte(test.body)) // nolint:errcheck,gosec
+		}))
+
+		client := http.DefaultClient
+		http.DefaultClient = srv.Client()
+
+		noz := &Nozzle{Domain: strings.TrimPrefix(srv.URL, "https://"), Realm: "LocalDomain", Portal: "VirtualOffice"}
+		res, err := noz.Login(context.Background(), "alice", "Password1!")
+
+		http.DefaultClient = client
+		srv.Close()
+
+		if err != nil {
+			t.Errorf("[%s] unexpected error: %s", test.desc, err)
+			continue
+		}
+		if res.Valid != test.valid || res.MFA != test.mfa || res.Locked != test.locked ||
+			res.RateLimited != test.limited {
+			t.Errorf("[%s] unexpected response %+v", test.desc, res)
+		}
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" || r.URL.Path != "/cgi-bin/welcome" {
+			w.WriteHeader(404)
+			return
+		}
+		w.Header().Set("Server", "SonicWALL SSL-VPN Web Server")
+		w.Write([]byte(`<html><title>Virtual Office</title>` + // nolint:errcheck,gosec
+			`<td class="loginDisclaimer">This system is for authorized users.</td></html>`))
+	}))
+	defer srv.Close()
+
+	client := http.DefaultClient
+	http.DefaultClient = srv.Client()
+	defer func() { http.DefaultClient = client }()
+
+	noz := &Nozzle{Domain: strings.TrimPrefix(srv.URL, "https://")}
+	features, err := noz.Fingerprint(context.Background(), "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if features["title"] != "Virtual Office" || features["banner"] != "This system is for authorized users." ||
+		features["server"] != "SonicWALL SSL-VPN Web Server" {
+		t.Errorf("unexpected features %v", features)
+	}
+
+	noz.Domain += "/missing"
+	if _, err = noz.Fingerprint(context.Background(), "alice"); err == nil {
+		t.Errorf("expected an error for a missing login page")
+	}
+}
@@ -0,0 +1,323 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zscaler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/praetorian-inc/trident/pkg/event"
+	"github.com/praetorian-inc/trident/pkg/nozzle"
+	"github.com/praetorian-inc/trident/pkg/retry"
+	"github.com/praetorian-inc/trident/pkg/rules"
+)
+
+const (
+	// FrozenUserAgent is a static user agent that we use for all requests. This
+	// value is based on the UA client hint work within browsers.
+	// Additional details: https://bugs.chromium.org/p/chromium/issues/detail?id=955620
+	FrozenUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64)" +
+		"AppleWebKit/537.36 (KHTML, like Gecko) Chrome/75.0.3764.0 Safari/537.36"
+
+	// PortalAdmin is the ZIA admin portal, which authenticates administrators
+	PortalAdmin = "admin"
+
+	// PortalUser is the end user login portal, which authenticates users
+	// against the hosted database or the directory of the organization
+	PortalUser = "user"
+)
+
+var (
+	// RateLimiter limits requests from the same worker to a maximum of 3/s
+	RateLimiter = rate.NewLimiter(rate.Every(300*time.Millisecond), 1)
+
+	// AllowedClouds are the Zscaler clouds. other clouds require the
+	// allow_custom_domain option.
+	AllowedClouds = []string{
+		"zscaler.net", "zscalerone.net", "zscalertwo.net", "zscalerthree.net",
+		"zscloud.net", "zscalerbeta.net", "zscalergov.net",
+	}
+
+	// SessionCookies are the cookies the end user login portal sets once a
+	// user is authenticated. a redirect without one of these is not proof of
+	// a valid login, as the portal also redirects to identity providers and
+	// maintenance pages.
+	SessionCookies = []string{"ZS_SESSION_CODE", "_sm_au_d"}
+)
+
+// Driver implements the nozzle.Driver interface.
+type Driver struct{}
+
+func init() {
+	nozzle.Register("zscaler", Driver{})
+}
+
+// New is used to create a Zscaler nozzle and accepts the following
+// configuration options:
+//
+// cloud
+//
+// The Zscaler cloud of the organization (defaults to zscaler.net). If
+// administrators log in at admin.zscalertwo.net, the value of cloud is
+// "zscalertwo.net". It must be one of the AllowedClouds unless
+// allow_custom_domain is set.
+//
+// allow_custom_domain
+//
+// If "true", cloud may be any domain.
+//
+// portal
+//
+// The portal to authenticate against: admin (default), the ZIA admin portal,
+// or user, the end user login portal.
+func (Driver) New(opts map[string]string) (nozzle.Nozzle, error) {
+	cloud, ok := opts["cloud"]
+	if !ok {
+		cloud = AllowedClouds[0]
+	}
+
+	err := validateCloud(cloud, opts["allow_custom_domain"] == "true")
+	if err != nil {
+		return nil, err
+	}
+
+	portal, ok := opts["portal"]
+	if !ok {
+		portal = PortalAdmin
+	}
+	if portal != PortalAdmin && portal != PortalUser {
+		return nil, fmt.Errorf("unknown zscaler portal %q", portal)
+	}
+
+	host := "admin." + cloud
+	if portal == PortalUser {
+		host = "login." + cloud
+	}
+
+	return &Nozzle{
+		Domain:    host,
+		Portal:    portal,
+		UserAgent: FrozenUserAgent,
+	}, nil
+}
+
+// validateCloud ensures the cloud is a bare domain and, unless custom domains
+// are allowed, that it is one of the AllowedClouds.
+func validateCloud(cloud string, allowCustom bool) error {
+	u, err := url.Parse("https://" + cloud)
+	if err != nil {
+		return err
+	}
+	if u.Host != cloud || u.Hostname() != cloud || cloud == "" {
+		return fmt.Errorf("zscaler cloud must be a domain, got %q", cloud)
+	}
+	if allowCustom {
+		return nil
+	}
+
+	for _, allowed := range AllowedClouds {
+		if cloud == allowed {
+			return nil
+		}
+	}
+	return fmt.Errorf("zscaler cloud %s is not a zscaler cloud, set allow_custom_domain to "+
+		"target a custom domain", cloud)
+}
+
+// Nozzle implements the nozzle.Nozzle interface for Zscaler.
+type Nozzle struct {
+	// Domain is the hostname of the portal (e.g. admin.zscaler.net)
+	Domain string
+
+	// Portal is the portal authenticated against (admin or user)
+	Portal string
+
+	// UserAgent will override the Go-http-client user-agent in requests
+	UserAgent string
+}
+
+type zscalerError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Login fulfils the nozzle.Nozzle interface and performs an authentication
+// request against the configured Zscaler portal. This function supports rate
+// limiting and parses valid, invalid, and locked out responses.
+func (n *Nozzle) Login(ctx context.Context, username, password string) (*event.AuthResponse, error) {
+	err := RateLimiter.Wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if n.Portal == PortalUser {
+		return n.userLogin(ctx, username, password)
+	}
+	return n.adminLogin(ctx, username, password)
+}
+
+// adminLogin authenticates against the session API of the admin portal.
+func (n *Nozzle) adminLogin(ctx context.Context, username, password string) (*event.AuthResponse, error) {
+	data, _ := json.Marshal(map[string]string{
+		"username": username,
+		"password": password,
+	})
+	req, err := http.NewRequestWithContext(ctx, "POST",
+		fmt.Sprintf("https://%s/zsapi/v1/authenticatedSession", n.Domain), bytes.NewBuffer(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", n.UserAgent)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	r, err := rules.NewResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+	if res, ok, err := rules.Classify("zscaler", r); ok {
+		return res, err
+	}
+	if res, ok := rules.DetectCaptcha(r); ok {
+		return res, nil
+	}
+
+	switch resp.StatusCode {
+	case 200:
+		var session map[string]interface{}
+		err = json.Unmarshal(r.Body, &session)
+		if err != nil {
+			return nil, retry.New(retry.ClassParse, err)
+		}
+		return &event.AuthResponse{
+			Valid: true,
+			Metadata: map[string]interface{}{
+				"auth_type": session["authType"],
+			},
+		}, nil
+	case 401, 403:
+		var zerr zscalerError
+		json.Unmarshal(r.Body, &zerr) // nolint:errcheck,gosec
+		return &event.AuthResponse{
+			Locked: isLocked(zerr.Code + " " + zerr.Message),
+		}, nil
+	case 429:
+		return &event.AuthResponse{
+			RateLimited: true,
+		}, nil
+	}
+
+	return nil, retry.Errorf(retry.ClassifyStatus(resp.StatusCode),
+		"unhandled status code from zscaler provider: %d", resp.StatusCode)
+}
+
+// userLogin authenticates against the end user login portal, which sets a
+// session cookie and redirects to the requested page on success and redirects
+// back to the login page on failure.
+func (n *Nozzle) userLogin(ctx context.Context, username, password string) (*event.AuthResponse, error) {
+	form := url.Values{}
+	form.Set("username", username)
+	form.Set("password", password)
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("https://%s/sfc_sso", n.Domain),
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", n.UserAgent)
+
+	client := &http.Client{
+		Transport: http.DefaultClient.Transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	r, err := rules.NewResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+	if res, ok, err := rules.Classify("zscaler", r); ok {
+		return res, err
+	}
+	if res, ok := rules.DetectCaptcha(r); ok {
+		return res, nil
+	}
+
+	switch resp.StatusCode {
+	case 302, 303:
+		location := resp.Header.Get("Location")
+		if strings.Contains(location, "authfailed") || strings.Contains(location, "error") {
+			return &event.AuthResponse{
+				Locked: isLocked(location),
+			}, nil
+		}
+		if hasSession(resp) {
+			return &event.AuthResponse{
+				Valid: true,
+			}, nil
+		}
+		return nil, retry.Errorf(retry.ClassUnknown,
+			"unhandled redirect from zscaler provider without a session: %s", location)
+	case 200:
+		// the login page is rendered again with an error message
+		return &event.AuthResponse{
+			Locked: isLocked(string(r.Body)),
+		}, nil
+	case 429:
+		return &event.AuthResponse{
+			RateLimited: true,
+		}, nil
+	}
+
+	return nil, retry.Errorf(retry.ClassifyStatus(resp.StatusCode),
+		"unhandled status code from zscaler provider: %d", resp.StatusCode)
+}
+
+// hasSession returns true if the response sets one of the SessionCookies.
+func hasSession(resp *http.Response) bool {
+	for _, cookie := range resp.Cookies() {
+		for _, name := range SessionCookies {
+			if cookie.Name == name && cookie.Value != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isLocked returns true if an error message reports a locked account.
+func isLocked(msg string) bool {
+	msg = strings.ToLower(msg)
+	return strings.Contains(msg, "locked")
+}
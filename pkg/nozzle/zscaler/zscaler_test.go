@@ -0,0 +1,126 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zscaler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/praetorian-inc/trident/pkg/nozzle"
+)
+
+func TestNew(t *testing.T) {
+	var testcases = []struct {
+		desc      string
+		opts      map[string]string
+		domain    string
+		expecterr bool
+	}{
+		{"default cloud", map[string]string{}, "admin.zscaler.net", false},
+		{"other cloud", map[string]string{"cloud": "zscalertwo.net"}, "admin.zscalertwo.net", false},
+		{"user portal", map[string]string{"cloud": "zscloud.net", "portal": "user"}, "login.zscloud.net", false},
+		{"unknown portal", map[string]string{"portal": "vpn"}, "", true},
+		{"custom cloud", map[string]string{"cloud": "example.org"}, "", true},
+		{"allowed custom cloud", map[string]string{"cloud": "example.org", "allow_custom_domain": "true"},
+			"admin.example.org", false},
+		{"path in cloud", map[string]string{"cloud": "example.org/zscaler.net"}, "", true},
+	}
+
+	for _, test := range testcases {
+		noz, err := nozzle.Open("zscaler", test.opts)
+		if test.expecterr {
+			if err == nil {
+				t.Errorf("[%s] expected error", test.desc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("[%s] unexpected error: %s", test.desc, err)
+			continue
+		}
+		if domain := noz.(*Nozzle).Domain; domain != test.domain {
+			t.Errorf("[%s] domain was %s, expected %s", test.desc, domain, test.domain)
+		}
+	}
+}
+
+func TestLogin(t *testing.T) {
+	var testcases = []struct {
+		desc     string
+		portal   string
+		status   int
+		location string
+		cookie   string
+		body     string
+		valid    bool
+		locked   bool
+		limited  bool
+		err      bool
+	}{
+		{"admin valid", PortalAdmin, 200, "", "", `{"authType":"ADMIN_LOGIN"}`, true, false, false, false},
+		{"admin invalid", PortalAdmin, 401, "", "", `{"code":"AUTHENTICATION_FAILED"}`, false, false, false, false},
+		{"admin locked", PortalAdmin, 403, "", "", `{"code":"ACCOUNT_LOCKED","message":"Account is locked"}`,
+			false, true, false, false},
+		{"admin rate limited", PortalAdmin, 429, "", "", `{}`, false, false, true, false},
+		{"user valid", PortalUser, 302, "https://www.example.org/", "ZS_SESSION_CODE=abc123", "",
+			true, false, false, false},
+		{"user invalid", PortalUser, 302, "/sfc_sso?authfailed=1", "", "", false, false, false, false},
+		{"user locked", PortalUser, 200, "", "", "<p>Your account has been locked</p>", false, true, false, false},
+		{"user redirect without session", PortalUser, 302, "https://idp.example.org/saml", "", "",
+			false, false, false, true},
+	}
+
+	for _, test := range testcases {
+		srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if test.location != "" {
+				w.Header().Set("Location", test.location)
+			}
+			if test.cookie != "" {
+				w.Header().Set("Set-Cookie", test.cookie)
+			}
+			w.WriteHeader(test.status)
+			w.Write([]byte(test.body)) // nolint:errcheck,gosec
+		}))
+
+		client := http.DefaultClient
+		http.DefaultClient = srv.Client()
+
+		noz := &Nozzle{
+			Domain: strings.TrimPrefix(srv.URL, "https://"),
+			Portal: test.portal,
+		}
+		res, err := noz.Login(context.Background(), "alice@example.org", "Password1!")
+
+		http.DefaultClient = client
+		srv.Close()
+
+		if test.err {
+			if err == nil {
+				t.Errorf("[%s] expected error, got %+v", test.desc, res)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("[%s] unexpected error: %s", test.desc, err)
+			continue
+		}
+		if res.Valid != test.valid || res.Locked != test.locked || res.RateLimited != test.limited {
+			t.Errorf("[%s] unexpected response %+v", test.desc, res)
+		}
+	}
+}
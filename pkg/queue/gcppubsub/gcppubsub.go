@@ -0,0 +1,94 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package gcppubsub implements the queue.Driver interface for Google Cloud
+// Pub/Sub.
+package gcppubsub
+
+import (
+	"context"
+	"fmt"
+
+	"cloud.google.com/go/pubsub"
+
+	"github.com/praetorian-inc/trident/pkg/queue"
+)
+
+// Driver implements the queue.Driver interface.
+type Driver struct{}
+
+func init() {
+	queue.Register("pubsub", Driver{})
+}
+
+func client(opts map[string]string) (*pubsub.Client, string, error) {
+	project, ok := opts["project_id"]
+	if !ok {
+		return nil, "", fmt.Errorf("pubsub queue requires 'project_id' config parameter")
+	}
+	c, err := pubsub.NewClient(context.Background(), project)
+	return c, project, err
+}
+
+// Topic opens the Pub/Sub topic with the given ID and accepts the following
+// configuration options:
+//
+// project_id
+//
+// The Google Cloud Platform project ID.
+func (Driver) Topic(name string, opts map[string]string) (queue.Topic, error) {
+	c, _, err := client(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Topic{topic: c.Topic(name)}, nil
+}
+
+// Subscription opens the Pub/Sub subscription with the given ID and accepts
+// the same configuration options as Topic.
+func (Driver) Subscription(name string, settings queue.Settings, opts map[string]string) (queue.Subscription, error) {
+	c, project, err := client(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	sub := c.SubscriptionInProject(name, project)
+	sub.ReceiveSettings.Synchronous = true
+	sub.ReceiveSettings.MaxOutstandingMessages = settings.MaxOutstanding
+	return &Subscription{sub: sub}, nil
+}
+
+// Topic implements the queue.Topic interface.
+type Topic struct {
+	topic *pubsub.Topic
+}
+
+// Publish publishes a message and waits for the server to accept it.
+func (t *Topic) Publish(ctx context.Context, data []byte) error {
+	_, err := t.topic.Publish(ctx, &pubsub.Message{Data: data}).Get(ctx)
+	return err
+}
+
+// Subscription implements the queue.Subscription interface.
+type Subscription struct {
+	sub *pubsub.Subscription
+}
+
+// Receive receives messages from the subscription. Nacked messages are
+// redelivered by Pub/Sub.
+func (s *Subscription) Receive(ctx context.Context, f func(context.Context, *queue.Message)) error {
+	return s.sub.Receive(ctx, func(ctx context.Context, msg *pubsub.Message) {
+		f(ctx, queue.NewMessage(msg.Data, msg.Ack, msg.Nack))
+	})
+}
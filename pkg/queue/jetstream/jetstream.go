@@ -0,0 +1,201 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package jetstream implements the queue.Driver interface for NATS JetStream.
+// Each topic is a subject and each subscription is a durable pull consumer.
+package jetstream
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/praetorian-inc/trident/pkg/queue"
+)
+
+const (
+	// DefaultAckDeadline is how long a message may go unacknowledged before
+	// it is redelivered.
+	DefaultAckDeadline = time.Minute
+
+	// PollInterval is the longest a subscription waits for new messages
+	// before checking whether it has been cancelled.
+	PollInterval = 2 * time.Second
+)
+
+// Driver implements the queue.Driver interface.
+type Driver struct{}
+
+func init() {
+	queue.Register("nats", Driver{})
+}
+
+func connect(opts map[string]string) (nats.JetStreamContext, error) {
+	url, ok := opts["url"]
+	if !ok {
+		url = nats.DefaultURL
+	}
+
+	nc, err := nats.Connect(url)
+	if err != nil {
+		return nil, err
+	}
+	return nc.JetStream()
+}
+
+// Topic opens the subject with the given name and accepts the following
+// configuration options:
+//
+// url
+//
+// The NATS server URL (defaults to nats://127.0.0.1:4222). Credentials may be
+// included in the URL.
+//
+// stream
+//
+// If set, a stream with this name capturing the subject is created if it does
+// not already exist. Otherwise, the stream must be provisioned separately.
+func (Driver) Topic(name string, opts map[string]string) (queue.Topic, error) {
+	js, err := connect(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if stream, ok := opts["stream"]; ok {
+		_, err = js.StreamInfo(stream)
+		if err != nil {
+			_, err = js.AddStream(&nats.StreamConfig{
+				Name:     stream,
+				Subjects: []string{name},
+			})
+			if err != nil {
+				return nil, fmt.Errorf("error creating stream %s: %w", stream, err)
+			}
+		}
+	}
+	return &Topic{js: js, subject: name}, nil
+}
+
+// Subscription opens a durable consumer, named "subject/durable", and accepts
+// the same configuration options as Topic, along with:
+//
+// ack_deadline
+//
+// How long a message may go unacknowledged before it is redelivered (e.g.
+// "30s", defaults to DefaultAckDeadline).
+func (Driver) Subscription(name string, settings queue.Settings, opts map[string]string) (queue.Subscription, error) {
+	parts := strings.SplitN(name, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("nats subscription must be named \"subject/durable\", got %q", name)
+	}
+
+	deadline := DefaultAckDeadline
+	if v, ok := opts["ack_deadline"]; ok {
+		var err error
+		deadline, err = time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid nats queue ack_deadline: %w", err)
+		}
+	}
+
+	t, err := Driver{}.Topic(parts[0], opts)
+	if err != nil {
+		return nil, err
+	}
+
+	sub, err := t.(*Topic).js.PullSubscribe(parts[0], parts[1],
+		nats.ManualAck(),
+		nats.AckWait(deadline),
+		nats.MaxAckPending(settings.MaxOutstanding),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &Subscription{sub: sub, max: settings.MaxOutstanding}, nil
+}
+
+// Topic implements the queue.Topic interface.
+type Topic struct {
+	js      nats.JetStreamContext
+	subject string
+}
+
+// Publish publishes a message and waits for the stream to acknowledge it.
+func (t *Topic) Publish(ctx context.Context, data []byte) error {
+	_, err := t.js.Publish(t.subject, data, nats.Context(ctx))
+	return err
+}
+
+// Subscription implements the queue.Subscription interface.
+type Subscription struct {
+	sub *nats.Subscription
+	max int
+}
+
+// Backlog fulfils the queue.Backlogger interface and returns the number of
+// messages pending delivery or acknowledgement.
+func (s *Subscription) Backlog(ctx context.Context) (int64, error) {
+	info, err := s.sub.ConsumerInfo()
+	if err != nil {
+		return 0, err
+	}
+	return int64(info.NumPending) + int64(info.NumAckPending), nil
+}
+
+// Receive fetches messages from the durable consumer. Nacked messages, and
+// messages not acknowledged within the ack deadline, are redelivered by the
+// server.
+func (s *Subscription) Receive(ctx context.Context, f func(context.Context, *queue.Message)) error {
+	sem := make(chan struct{}, s.max)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for ctx.Err() == nil {
+		free := s.max - len(sem)
+		if free == 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(10 * time.Millisecond):
+			}
+			continue
+		}
+
+		msgs, err := s.sub.Fetch(free, nats.MaxWait(PollInterval))
+		if err == nats.ErrTimeout {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, m := range msgs {
+			sem <- struct{}{}
+			wg.Add(1)
+			go func(m *nats.Msg) {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+				ack := func() { m.Ack() }  // nolint:errcheck
+				nack := func() { m.Nak() } // nolint:errcheck
+				f(ctx, queue.NewMessage(m.Data, ack, nack))
+			}(m)
+		}
+	}
+	return nil
+}
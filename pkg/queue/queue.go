@@ -0,0 +1,202 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package queue defines an interface for the message queues that carry tasks
+// from the orchestrator to the dispatchers and results back. Delivery is
+// at-least-once: a message that is not acknowledged (or is negatively
+// acknowledged) is redelivered. Similar to the kms package, drivers register
+// themselves and must be "blank imported".
+//
+//  import (
+//      "github.com/praetorian-inc/trident/pkg/queue"
+//
+//      _ "github.com/praetorian-inc/trident/pkg/queue/gcppubsub"
+//      _ "github.com/praetorian-inc/trident/pkg/queue/redisstream"
+//  )
+//
+//  topic, err := queue.OpenTopic("redis", "tasks", map[string]string{"addr":"localhost:6379"})
+//  if err != nil {
+//      // handle error
+//  }
+//  err = topic.Publish(ctx, []byte(`{"username":"..."}`))
+//  // ...
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var (
+	driversMu sync.RWMutex
+	drivers   = make(map[string]Driver)
+)
+
+// DefaultDriver is the queue driver used when none is configured.
+const DefaultDriver = "pubsub"
+
+// DefaultMaxOutstanding is the default number of messages a subscription
+// handles concurrently.
+const DefaultMaxOutstanding = 10
+
+// Message is a message received from a Subscription. Exactly one of Ack or
+// Nack should be called once the message has been handled; calls after the
+// first are ignored.
+type Message struct {
+	Data []byte
+
+	once sync.Once
+	ack  func()
+	nack func()
+}
+
+// NewMessage creates a message whose acknowledgement is handled by the
+// provided functions. It is intended for use by drivers.
+func NewMessage(data []byte, ack, nack func()) *Message {
+	return &Message{Data: data, ack: ack, nack: nack}
+}
+
+// Ack acknowledges the message so that it is not redelivered.
+func (m *Message) Ack() {
+	m.once.Do(m.ack)
+}
+
+// Nack negatively acknowledges the message so that it is redelivered.
+func (m *Message) Nack() {
+	m.once.Do(m.nack)
+}
+
+// Topic is the interface that wraps publishing messages.
+type Topic interface {
+	// Publish blocks until the message has been accepted by the queue.
+	Publish(ctx context.Context, data []byte) error
+}
+
+// Subscription is the interface that wraps receiving messages.
+type Subscription interface {
+	// Receive calls f concurrently, for at most MaxOutstanding messages at a
+	// time, until ctx is done or a non-retryable error occurs.
+	Receive(ctx context.Context, f func(context.Context, *Message)) error
+}
+
+// Backlogger is implemented by subscriptions which can report the number of
+// messages waiting to be handled, including unacknowledged messages.
+type Backlogger interface {
+	Backlog(ctx context.Context) (int64, error)
+}
+
+// ErrBacklogUnsupported is returned when a subscription cannot report its
+// backlog.
+var ErrBacklogUnsupported = errors.New("queue: subscription does not report its backlog")
+
+// Backlog returns the backlog of the subscription if it implements
+// Backlogger.
+func Backlog(ctx context.Context, sub Subscription) (int64, error) {
+	b, ok := sub.(Backlogger)
+	if !ok {
+		return 0, ErrBacklogUnsupported
+	}
+	return b.Backlog(ctx)
+}
+
+// Settings configures a Subscription.
+type Settings struct {
+	// MaxOutstanding is the maximum number of unacknowledged messages handled
+	// at once (defaults to DefaultMaxOutstanding)
+	MaxOutstanding int
+}
+
+// Driver is the interface that wraps creation of topics and subscriptions.
+type Driver interface {
+	Topic(name string, opts map[string]string) (Topic, error)
+	Subscription(name string, settings Settings, opts map[string]string) (Subscription, error)
+}
+
+// Options is a type alias for simple marshaling/unmarshaling of queue
+// configuration options from the environment.
+type Options map[string]string
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface.
+func (opts *Options) UnmarshalText(text []byte) error {
+	return json.Unmarshal(text, (*map[string]string)(opts))
+}
+
+func driver(name string) (Driver, error) {
+	driversMu.RLock()
+	d, ok := drivers[name]
+	driversMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("queue: unknown driver %q (forgotten import?)", name)
+	}
+	return d, nil
+}
+
+// OpenTopic opens a topic by name using the driver specified by its name (e.g.
+// pubsub) and configures it via the provided opts argument. Each Driver should
+// document its configuration options.
+func OpenTopic(driverName, name string, opts map[string]string) (Topic, error) {
+	d, err := driver(driverName)
+	if err != nil {
+		return nil, err
+	}
+	return d.Topic(name, opts)
+}
+
+// OpenSubscription opens a subscription by name using the driver specified by
+// its name (e.g. pubsub) and configures it via the provided opts argument.
+func OpenSubscription(driverName, name string, settings Settings, opts map[string]string) (Subscription, error) {
+	d, err := driver(driverName)
+	if err != nil {
+		return nil, err
+	}
+	if settings.MaxOutstanding <= 0 {
+		settings.MaxOutstanding = DefaultMaxOutstanding
+	}
+	return d.Subscription(name, settings, opts)
+}
+
+// Config returns the queue driver and its options. The driver defaults to
+// DefaultDriver, whose project_id defaults to projectID so that deployments
+// configured only with a project keep working.
+func Config(driver string, opts Options, projectID string) (string, Options) {
+	if driver == "" {
+		driver = DefaultDriver
+	}
+	if driver == DefaultDriver && opts["project_id"] == "" && projectID != "" {
+		config := Options{}
+		for k, v := range opts {
+			config[k] = v
+		}
+		config["project_id"] = projectID
+		opts = config
+	}
+	return driver, opts
+}
+
+// Register makes a queue driver available at the provided name. If register
+// is called twice or if the driver is nil, if panics.
+func Register(name string, driver Driver) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	if driver == nil {
+		panic("queue: Register driver is nil")
+	}
+	if _, dup := drivers[name]; dup {
+		panic("queue: Register called twice for driver " + name)
+	}
+	drivers[name] = driver
+}
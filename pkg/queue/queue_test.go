@@ -0,0 +1,96 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+import (
+	"context"
+	"testing"
+)
+
+type testDriver struct{}
+
+func (testDriver) Topic(name string, opts map[string]string) (Topic, error) {
+	return nil, nil
+}
+
+func (testDriver) Subscription(name string, settings Settings, opts map[string]string) (Subscription, error) {
+	return testSubscription(settings), nil
+}
+
+type testSubscription Settings
+
+func (testSubscription) Receive(ctx context.Context, f func(context.Context, *Message)) error {
+	return nil
+}
+
+func TestMessage(t *testing.T) {
+	var acks, nacks int
+	msg := NewMessage([]byte("data"), func() { acks++ }, func() { nacks++ })
+	msg.Ack()
+	msg.Nack()
+	msg.Ack()
+	if acks != 1 || nacks != 0 {
+		t.Errorf("got %d acks and %d nacks, want 1 and 0", acks, nacks)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	var tests = []struct {
+		name      string
+		driver    string
+		opts      Options
+		projectID string
+		want      string
+		wantOpts  Options
+	}{
+		{"default", "", nil, "proj", "pubsub", Options{"project_id": "proj"}},
+		{"configured project", "pubsub", Options{"project_id": "other"}, "proj", "pubsub", Options{"project_id": "other"}},
+		{"redis", "redis", Options{"addr": "localhost:6379"}, "proj", "redis", Options{"addr": "localhost:6379"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			driver, opts := Config(tt.driver, tt.opts, tt.projectID)
+			if driver != tt.want {
+				t.Errorf("got driver %q, want %q", driver, tt.want)
+			}
+			if len(opts) != len(tt.wantOpts) {
+				t.Fatalf("got opts %v, want %v", opts, tt.wantOpts)
+			}
+			for k, v := range tt.wantOpts {
+				if opts[k] != v {
+					t.Errorf("got %s=%q, want %q", k, opts[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestOpenSubscription(t *testing.T) {
+	Register("test", testDriver{})
+
+	sub, err := OpenSubscription("test", "tasks", Settings{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub.(testSubscription).MaxOutstanding != DefaultMaxOutstanding {
+		t.Errorf("got max outstanding %d, want %d", sub.(testSubscription).MaxOutstanding, DefaultMaxOutstanding)
+	}
+
+	_, err = OpenTopic("unknown", "tasks", nil)
+	if err == nil {
+		t.Error("expected error opening unknown driver")
+	}
+}
@@ -0,0 +1,355 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package redisstream implements the queue.Driver interface for Redis
+// Streams. Each topic is a stream and each subscription is a consumer group
+// on that stream.
+package redisstream
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+
+	"github.com/praetorian-inc/trident/pkg/queue"
+)
+
+const (
+	// DefaultAckDeadline is how long a message may go unacknowledged before
+	// it is redelivered to another consumer.
+	DefaultAckDeadline = time.Minute
+
+	// PollInterval is the longest a subscription blocks waiting for new
+	// messages before checking for unacknowledged ones.
+	PollInterval = 2 * time.Second
+
+	// MaxBacklogScan is the most undelivered entries counted when reporting
+	// the backlog of a subscription.
+	MaxBacklogScan = 10000
+
+	dataField = "data"
+)
+
+// Driver implements the queue.Driver interface.
+type Driver struct{}
+
+func init() {
+	queue.Register("redis", Driver{})
+}
+
+func client(opts map[string]string) (*redis.Client, error) {
+	addr, ok := opts["addr"]
+	if !ok {
+		return nil, fmt.Errorf("redis queue requires 'addr' config parameter")
+	}
+
+	var db int
+	if v, ok := opts["db"]; ok {
+		var err error
+		db, err = strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis queue db: %w", err)
+		}
+	}
+
+	c := redis.NewClient(&redis.Options{
+		Addr:       addr,
+		Password:   opts["password"],
+		MaxRetries: 10,
+		DB:         db,
+	})
+	return c, c.Ping().Err()
+}
+
+// Topic opens the stream with the given name and accepts the following
+// configuration options:
+//
+// addr
+//
+// The address of the Redis server (e.g. localhost:6379).
+//
+// password
+//
+// The Redis password, if any.
+//
+// db
+//
+// The Redis database number (defaults to 0).
+//
+// max_len
+//
+// If set, the stream is trimmed to approximately this many entries.
+func (Driver) Topic(name string, opts map[string]string) (queue.Topic, error) {
+	c, err := client(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var maxLen int64
+	if v, ok := opts["max_len"]; ok {
+		maxLen, err = strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis queue max_len: %w", err)
+		}
+	}
+	return &Topic{client: c, stream: name, maxLen: maxLen}, nil
+}
+
+// Subscription opens a consumer group, named "stream/group", creating the
+// stream and group if they do not exist. It accepts the same configuration
+// options as Topic, along with:
+//
+// ack_deadline
+//
+// How long a message may go unacknowledged before it is redelivered (e.g.
+// "30s", defaults to DefaultAckDeadline).
+//
+// consumer
+//
+// The name of this consumer within the group (defaults to the hostname and
+// process ID). Names must be unique within a group.
+func (Driver) Subscription(name string, settings queue.Settings, opts map[string]string) (queue.Subscription, error) {
+	parts := strings.SplitN(name, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("redis subscription must be named \"stream/group\", got %q", name)
+	}
+
+	c, err := client(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := DefaultAckDeadline
+	if v, ok := opts["ack_deadline"]; ok {
+		deadline, err = time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis queue ack_deadline: %w", err)
+		}
+	}
+
+	consumer, ok := opts["consumer"]
+	if !ok {
+		host, _ := os.Hostname()
+		consumer = fmt.Sprintf("%s-%d", host, os.Getpid())
+	}
+
+	err = c.XGroupCreateMkStream(parts[0], parts[1], "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil, err
+	}
+
+	return &Subscription{
+		client:      c,
+		stream:      parts[0],
+		group:       parts[1],
+		consumer:    consumer,
+		ackDeadline: deadline,
+		max:         settings.MaxOutstanding,
+	}, nil
+}
+
+// Topic implements the queue.Topic interface.
+type Topic struct {
+	client *redis.Client
+	stream string
+	maxLen int64
+}
+
+// Publish appends a message to the stream.
+func (t *Topic) Publish(ctx context.Context, data []byte) error {
+	return t.client.WithContext(ctx).XAdd(&redis.XAddArgs{
+		Stream:       t.stream,
+		MaxLenApprox: t.maxLen,
+		Values:       map[string]interface{}{dataField: data},
+	}).Err()
+}
+
+// Subscription implements the queue.Subscription interface.
+type Subscription struct {
+	client      *redis.Client
+	stream      string
+	group       string
+	consumer    string
+	ackDeadline time.Duration
+	max         int
+}
+
+// Receive reads messages from the consumer group. Messages left pending for
+// longer than the ack deadline, including those of consumers that have
+// exited, are claimed and redelivered.
+func (s *Subscription) Receive(ctx context.Context, f func(context.Context, *queue.Message)) error {
+	sem := make(chan struct{}, s.max)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	handle := func(msgs []redis.XMessage) {
+		for _, m := range msgs {
+			if m.ID == "" {
+				// the entry was deleted while pending
+				continue
+			}
+			sem <- struct{}{}
+			wg.Add(1)
+			go func(m redis.XMessage) {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+				f(ctx, s.message(m))
+			}(m)
+		}
+	}
+
+	var lastClaim time.Time
+	for ctx.Err() == nil {
+		free := s.max - len(sem)
+		if free == 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(10 * time.Millisecond):
+			}
+			continue
+		}
+
+		if time.Since(lastClaim) > s.ackDeadline/2 {
+			lastClaim = time.Now()
+			msgs, err := s.claim(int64(free))
+			if err != nil {
+				return err
+			}
+			if len(msgs) > 0 {
+				handle(msgs)
+				continue
+			}
+		}
+
+		streams, err := s.client.XReadGroup(&redis.XReadGroupArgs{
+			Group:    s.group,
+			Consumer: s.consumer,
+			Streams:  []string{s.stream, ">"},
+			Count:    int64(free),
+			Block:    PollInterval,
+		}).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for _, st := range streams {
+			handle(st.Messages)
+		}
+	}
+	return nil
+}
+
+// Backlog fulfils the queue.Backlogger interface and returns the number of
+// pending entries plus the number of entries not yet delivered to the group
+// (counting at most MaxBacklogScan undelivered entries).
+func (s *Subscription) Backlog(ctx context.Context) (int64, error) {
+	c := s.client.WithContext(ctx)
+	groups, err := c.XInfoGroups(s.stream).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, g := range groups {
+		if g.Name != s.group {
+			continue
+		}
+
+		start := g.LastDeliveredID
+		if start == "0-0" {
+			start = "-"
+		}
+		entries, err := c.XRangeN(s.stream, start, "+", MaxBacklogScan+1).Result()
+		if err != nil {
+			return 0, err
+		}
+		undelivered := int64(len(entries))
+		if len(entries) > 0 && entries[0].ID == g.LastDeliveredID {
+			undelivered--
+		}
+		return g.Pending + undelivered, nil
+	}
+	return 0, fmt.Errorf("redis consumer group %s not found", s.group)
+}
+
+// claim takes ownership of up to count messages that have been pending for
+// longer than the ack deadline.
+func (s *Subscription) claim(count int64) ([]redis.XMessage, error) {
+	pending, err := s.client.XPendingExt(&redis.XPendingExtArgs{
+		Stream: s.stream,
+		Group:  s.group,
+		Start:  "-",
+		End:    "+",
+		Count:  count,
+	}).Result()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, p := range pending {
+		if p.Idle >= s.ackDeadline {
+			ids = append(ids, p.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	return s.client.XClaim(&redis.XClaimArgs{
+		Stream:   s.stream,
+		Group:    s.group,
+		Consumer: s.consumer,
+		MinIdle:  s.ackDeadline,
+		Messages: ids,
+	}).Result()
+}
+
+// message wraps a stream entry. acknowledging the message removes it from
+// the group's pending list. a nacked message is re-added to the stream so
+// that it is redelivered immediately rather than after the ack deadline.
+func (s *Subscription) message(m redis.XMessage) *queue.Message {
+	data, _ := m.Values[dataField].(string)
+
+	ack := func() {
+		err := s.client.XAck(s.stream, s.group, m.ID).Err()
+		if err != nil {
+			log.Printf("error acknowledging message %s: %s", m.ID, err)
+		}
+	}
+	nack := func() {
+		_, err := s.client.TxPipelined(func(pipe redis.Pipeliner) error {
+			pipe.XAdd(&redis.XAddArgs{
+				Stream: s.stream,
+				Values: map[string]interface{}{dataField: data},
+			})
+			pipe.XAck(s.stream, s.group, m.ID)
+			return nil
+		})
+		if err != nil {
+			log.Printf("error requeuing message %s: %s", m.ID, err)
+		}
+	}
+	return queue.NewMessage([]byte(data), ack, nack)
+}
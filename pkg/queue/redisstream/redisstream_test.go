@@ -0,0 +1,176 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redisstream
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"github.com/praetorian-inc/trident/pkg/queue"
+)
+
+func open(t *testing.T, settings queue.Settings) (queue.Topic, queue.Subscription) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(mr.Close)
+
+	opts := map[string]string{"addr": mr.Addr(), "ack_deadline": "200ms", "consumer": "test"}
+	topic, err := Driver{}.Topic("tasks", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := Driver{}.Subscription("tasks/workers", settings, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return topic, sub
+}
+
+func publish(t *testing.T, topic queue.Topic, data ...string) {
+	for _, d := range data {
+		err := topic.Publish(context.Background(), []byte(d))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// receive runs f on every message until n messages were acknowledged, and
+// returns the number of deliveries of each message.
+func receive(t *testing.T, sub queue.Subscription, n int, f func(*queue.Message, int) bool) map[string]int {
+	ctx, cancel := context.WithCancel(context.Background())
+	var mu sync.Mutex
+	deliveries := make(map[string]int)
+	acked := make(chan struct{}, n)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- sub.Receive(ctx, func(ctx context.Context, msg *queue.Message) {
+			mu.Lock()
+			deliveries[string(msg.Data)]++
+			delivery := deliveries[string(msg.Data)]
+			mu.Unlock()
+			if f(msg, delivery) {
+				msg.Ack()
+				select {
+				case acked <- struct{}{}:
+				default:
+				}
+			}
+		})
+	}()
+
+	timeout := time.After(10 * time.Second)
+	for i := 0; i < n; i++ {
+		select {
+		case <-acked:
+		case <-timeout:
+			t.Errorf("%d of %d messages acknowledged", i, n)
+			i = n
+		}
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	return deliveries
+}
+
+func backlog(t *testing.T, sub queue.Subscription) int64 {
+	n, err := queue.Backlog(context.Background(), sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestAck(t *testing.T) {
+	topic, sub := open(t, queue.Settings{MaxOutstanding: 10})
+	publish(t, topic, "a", "b")
+	if n := backlog(t, sub); n != 2 {
+		t.Errorf("backlog of %d messages, expected 2", n)
+	}
+
+	deliveries := receive(t, sub, 2, func(*queue.Message, int) bool { return true })
+	if deliveries["a"] != 1 || deliveries["b"] != 1 {
+		t.Errorf("unexpected deliveries %v", deliveries)
+	}
+	if n := backlog(t, sub); n != 0 {
+		t.Errorf("backlog of %d messages after acknowledging them", n)
+	}
+}
+
+func TestRedelivery(t *testing.T) {
+	topic, sub := open(t, queue.Settings{MaxOutstanding: 10})
+	publish(t, topic, "nacked", "lost")
+
+	// a nacked message is redelivered immediately, a message which is never
+	// acknowledged once its ack deadline expires
+	deliveries := receive(t, sub, 2, func(msg *queue.Message, delivery int) bool {
+		if delivery > 1 {
+			return true
+		}
+		if string(msg.Data) == "nacked" {
+			msg.Nack()
+		}
+		return false
+	})
+	if deliveries["nacked"] != 2 || deliveries["lost"] != 2 {
+		t.Errorf("unexpected deliveries %v", deliveries)
+	}
+	if n := backlog(t, sub); n != 0 {
+		t.Errorf("backlog of %d messages after acknowledging them", n)
+	}
+}
+
+func TestMaxOutstanding(t *testing.T) {
+	topic, sub := open(t, queue.Settings{MaxOutstanding: 2})
+	var data []string
+	for i := 0; i < 6; i++ {
+		data = append(data, fmt.Sprint(i))
+	}
+	publish(t, topic, data...)
+
+	var mu sync.Mutex
+	var active, max int
+	receive(t, sub, len(data), func(*queue.Message, int) bool {
+		mu.Lock()
+		active++
+		if active > max {
+			max = active
+		}
+		mu.Unlock()
+
+		time.Sleep(50 * time.Millisecond)
+
+		mu.Lock()
+		active--
+		mu.Unlock()
+		return true
+	})
+	if max > 2 {
+		t.Errorf("%d messages handled at once, expected at most 2", max)
+	}
+}
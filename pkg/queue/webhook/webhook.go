@@ -0,0 +1,128 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package webhook implements a publish-only queue.Driver which sends each
+// message to an HTTP endpoint, signed with a short-lived token. It is used by
+// dispatchers to return results to the orchestrator's /results/ingest
+// endpoint when no queue is reachable from both.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/praetorian-inc/trident/pkg/auth/token"
+	"github.com/praetorian-inc/trident/pkg/queue"
+)
+
+// ErrPublishOnly is returned when a subscription is opened.
+var ErrPublishOnly = errors.New("webhook queue only supports publishing")
+
+// Driver implements the queue.Driver interface.
+type Driver struct{}
+
+func init() {
+	queue.Register("webhook", Driver{})
+}
+
+// Topic creates a topic publishing to an HTTP endpoint. The topic name is
+// ignored. It accepts the following configuration options:
+//
+// url
+//
+// The HTTPS URL messages are posted to (e.g.
+// https://trident.example.org/results/ingest).
+//
+// signing_key
+//
+// The secret used to sign each message. It is either a key shared by every
+// worker or, along with key_id, a key specific to this worker.
+//
+// key_id
+//
+// The ID of a per-worker signing_key, as configured on the orchestrator.
+//
+// insecure
+//
+// If "true", url may use plain HTTP (e.g. for local testing).
+func (Driver) Topic(name string, opts map[string]string) (queue.Topic, error) {
+	u, ok := opts["url"]
+	if !ok {
+		return nil, fmt.Errorf("webhook queue requires 'url' config parameter")
+	}
+	if !strings.HasPrefix(u, "https://") && opts["insecure"] != "true" {
+		return nil, fmt.Errorf("webhook queue url must use https")
+	}
+	key, ok := opts["signing_key"]
+	if !ok {
+		return nil, fmt.Errorf("webhook queue requires 'signing_key' config parameter")
+	}
+
+	return &Topic{
+		URL:        u,
+		HTTPClient: http.DefaultClient,
+		Signer: &token.Signer{
+			Secret: []byte(key),
+			KeyID:  opts["key_id"],
+		},
+	}, nil
+}
+
+// Subscription fulfils the queue.Driver interface but always fails, since
+// messages are delivered to the HTTP endpoint instead.
+func (Driver) Subscription(name string, settings queue.Settings, opts map[string]string) (queue.Subscription, error) {
+	return nil, ErrPublishOnly
+}
+
+// Topic implements the queue.Topic interface for HTTP endpoints.
+type Topic struct {
+	// URL is the endpoint messages are posted to
+	URL string
+
+	// HTTPClient is the client used to send requests
+	HTTPClient *http.Client
+
+	// Signer signs each request
+	Signer *token.Signer
+}
+
+// Publish fulfils the queue.Topic interface. The message is accepted once the
+// endpoint responds with a 2xx status code.
+func (t *Topic) Publish(ctx context.Context, data []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", t.URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	err = t.Signer.Auth(req)
+	if err != nil {
+		return err
+	}
+
+	resp, err := t.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status code from %s: %d", t.URL, resp.StatusCode)
+	}
+	return nil
+}
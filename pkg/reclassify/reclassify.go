@@ -0,0 +1,161 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package reclassify re-runs the classification of a nozzle over the raw
+// provider responses captured with its capture_response option. it corrects
+// historical results, e.g. after fixing a nozzle which mis-detected lockouts,
+// without repeating the attempts.
+package reclassify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+	"github.com/praetorian-inc/trident/pkg/event"
+	"github.com/praetorian-inc/trident/pkg/kms"
+	"github.com/praetorian-inc/trident/pkg/nozzle"
+	"github.com/praetorian-inc/trident/pkg/rules"
+)
+
+// Outcome is the classification of an attempt.
+type Outcome struct {
+	Valid         bool `json:"valid"`
+	Locked        bool `json:"locked"`
+	MFA           bool `json:"mfa"`
+	RateLimited   bool `json:"rate_limited"`
+	Captcha       bool `json:"captcha"`
+	PolicyBlocked bool `json:"policy_blocked"`
+}
+
+// OutcomeOf returns the classification of a result.
+func OutcomeOf(res *db.Result) Outcome {
+	return Outcome{
+		Valid:         res.Valid,
+		Locked:        res.Locked,
+		MFA:           res.MFA,
+		RateLimited:   res.RateLimited,
+		Captcha:       res.Captcha,
+		PolicyBlocked: res.PolicyBlocked,
+	}
+}
+
+// String lists the flags set by the classification, e.g. "valid, mfa".
+func (o Outcome) String() string {
+	var flags []string
+	if o.Valid {
+		flags = append(flags, "valid")
+	} else {
+		flags = append(flags, "invalid")
+	}
+	for _, f := range []struct {
+		set  bool
+		name string
+	}{
+		{o.Locked, "locked"},
+		{o.MFA, "mfa"},
+		{o.RateLimited, "rate limited"},
+		{o.Captcha, "captcha"},
+		{o.PolicyBlocked, "policy blocked"},
+	} {
+		if f.set {
+			flags = append(flags, f.name)
+		}
+	}
+	return strings.Join(flags, ", ")
+}
+
+// Change records a result whose classification changed.
+type Change struct {
+	Result *db.Result
+	Before Outcome
+	After  Outcome
+}
+
+// Reclassifier re-classifies results from their captured responses.
+type Reclassifier struct {
+	// Classifier is the nozzle of the campaign
+	Classifier nozzle.Classifier
+
+	// Envelope unseals the captured responses
+	Envelope *kms.Envelope
+}
+
+// New returns a Reclassifier for the nozzle, which must implement the
+// nozzle.Classifier interface.
+func New(noz nozzle.Nozzle, envelope *kms.Envelope) (*Reclassifier, error) {
+	c, ok := noz.(nozzle.Classifier)
+	if !ok {
+		return nil, fmt.Errorf("the nozzle does not support re-classification")
+	}
+	return &Reclassifier{Classifier: c, Envelope: envelope}, nil
+}
+
+// Result re-classifies a result from its captured response. if the outcome
+// changed, the classification and metadata of the result are replaced and
+// changed is true.
+func (r *Reclassifier) Result(ctx context.Context, res *db.Result) (change Change, changed bool, err error) {
+	data := res.Response
+	if kms.IsSealed(data) {
+		if r.Envelope == nil {
+			return change, false, fmt.Errorf("the response of result %d is sealed and no key manager is configured",
+				res.ID)
+		}
+		data, err = r.Envelope.Unseal(ctx, data)
+		if err != nil {
+			return change, false, fmt.Errorf("error unsealing the response of result %d: %w", res.ID, err)
+		}
+	}
+
+	resp, err := rules.DecodeResponse(data)
+	if err != nil {
+		return change, false, fmt.Errorf("error decoding the response of result %d: %w", res.ID, err)
+	}
+	classified, err := r.Classifier.Classify(resp)
+	if err != nil {
+		return change, false, fmt.Errorf("error classifying result %d: %w", res.ID, err)
+	}
+
+	change = Change{Result: res, Before: OutcomeOf(res), After: ResponseOutcome(classified)}
+	if change.Before == change.After {
+		return change, false, nil
+	}
+
+	metadata, err := json.Marshal(classified.Metadata)
+	if err != nil {
+		return change, false, err
+	}
+	res.Valid = classified.Valid
+	res.Locked = classified.Locked
+	res.MFA = classified.MFA
+	res.RateLimited = classified.RateLimited
+	res.Captcha = classified.Captcha
+	res.PolicyBlocked = classified.PolicyBlocked
+	res.Metadata = metadata
+	return change, true, nil
+}
+
+// ResponseOutcome returns the classification of a nozzle response.
+func ResponseOutcome(res *event.AuthResponse) Outcome {
+	return Outcome{
+		Valid:         res.Valid,
+		Locked:        res.Locked,
+		MFA:           res.MFA,
+		RateLimited:   res.RateLimited,
+		Captcha:       res.Captcha,
+		PolicyBlocked: res.PolicyBlocked,
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reclassify
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+	"github.com/praetorian-inc/trident/pkg/event"
+	"github.com/praetorian-inc/trident/pkg/kms"
+	"github.com/praetorian-inc/trident/pkg/kms/local"
+	"github.com/praetorian-inc/trident/pkg/rules"
+)
+
+// testNozzle classifies 401 responses as locked, as a fixed nozzle would
+type testNozzle struct{}
+
+func (testNozzle) Login(ctx context.Context, username, password string) (*event.AuthResponse, error) {
+	return nil, errors.New("unexpected login")
+}
+
+func (testNozzle) Classify(r *rules.Response) (*event.AuthResponse, error) {
+	switch r.StatusCode {
+	case 200:
+		return &event.AuthResponse{Valid: true}, nil
+	case 401:
+		return &event.AuthResponse{Locked: true, Metadata: map[string]interface{}{"reason": "locked"}}, nil
+	}
+	return nil, errors.New("unhandled status code")
+}
+
+type loginOnly struct{}
+
+func (loginOnly) Login(ctx context.Context, username, password string) (*event.AuthResponse, error) {
+	return &event.AuthResponse{}, nil
+}
+
+func TestResult(t *testing.T) {
+	ctx := context.Background()
+	keys, err := local.Driver{}.New(map[string]string{
+		"key": "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=",
+	})
+	if err != nil {
+		t.Fatalf("error creating key manager: %s", err)
+	}
+	envelope := kms.NewEnvelope(keys)
+
+	seal := func(status int) string {
+		sealed, err := envelope.Seal(ctx, (&rules.Response{StatusCode: status, Header: http.Header{}}).Encode())
+		if err != nil {
+			t.Fatalf("error sealing response: %s", err)
+		}
+		return sealed
+	}
+
+	r, err := New(testNozzle{}, envelope)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var testcases = []struct {
+		desc    string
+		result  db.Result
+		changed bool
+		after   Outcome
+		err     bool
+	}{
+		{"lockout detected", db.Result{Response: seal(401)}, true, Outcome{Locked: true}, false},
+		{"unchanged", db.Result{Valid: true, Response: seal(200)}, false, Outcome{Valid: true}, false},
+		{"plaintext response", db.Result{Response: (&rules.Response{StatusCode: 200}).Encode()},
+			true, Outcome{Valid: true}, false},
+		{"classification error", db.Result{Response: seal(500)}, false, Outcome{}, true},
+		{"invalid response", db.Result{Response: "{"}, false, Outcome{}, true},
+	}
+
+	for _, test := range testcases {
+		res := test.result
+		change, changed, err := r.Result(ctx, &res)
+		if test.err {
+			if err == nil {
+				t.Errorf("[%s] expected error", test.desc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("[%s] unexpected error: %s", test.desc, err)
+			continue
+		}
+		if changed != test.changed || change.After != test.after {
+			t.Errorf("[%s] changed %t to %s, expected %t to %s", test.desc, changed, change.After,
+				test.changed, test.after)
+		}
+		if OutcomeOf(&res) != test.after {
+			t.Errorf("[%s] result is %s, expected %s", test.desc, OutcomeOf(&res), test.after)
+		}
+	}
+
+	res := db.Result{Response: seal(401)}
+	_, _, err = (&Reclassifier{Classifier: testNozzle{}}).Result(ctx, &res)
+	if err == nil {
+		t.Errorf("expected an error unsealing a response without a key manager")
+	}
+
+	_, err = New(loginOnly{}, envelope)
+	if err == nil {
+		t.Errorf("expected an error for a nozzle which cannot classify responses")
+	}
+}
+
+func TestOutcomeString(t *testing.T) {
+	if s := (Outcome{}).String(); s != "invalid" {
+		t.Errorf("unexpected outcome %q", s)
+	}
+	if s := (Outcome{Valid: true, MFA: true, PolicyBlocked: true}).String(); s != "valid, mfa, policy blocked" {
+		t.Errorf("unexpected outcome %q", s)
+	}
+}
@@ -0,0 +1,388 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package recon fingerprints the identity providers used by a domain before a
+// campaign is created: Azure AD realm discovery, Okta organizations, MX and
+// autodiscover records, and common VPN portals. each detected provider is
+// reported with its evidence and, if trident has a nozzle for it, a suggested
+// nozzle configuration.
+//
+// recon only sends unauthenticated requests which do not reference any user
+// of the domain other than a placeholder, so it never generates login events.
+package recon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Timeout is the timeout of each request sent by Run.
+const Timeout = 10 * time.Second
+
+var (
+	// realmURL is the Azure AD user realm discovery endpoint
+	realmURL = "https://login.microsoftonline.com/getuserrealm.srf?login=%s&json=1"
+
+	// oktaURL is the OpenID configuration of an Okta organization
+	oktaURL = "https://%s.okta.com/.well-known/openid-configuration"
+
+	// lookupMX and lookupCNAME resolve DNS records, replaced by tests
+	lookupMX    = net.DefaultResolver.LookupMX
+	lookupCNAME = net.DefaultResolver.LookupCNAME
+)
+
+// VPNHosts are the subdomains probed for VPN portals.
+var VPNHosts = []string{"vpn", "remote", "sslvpn", "access", "portal"}
+
+// portals maps VPN products to the path of their login page and a marker of
+// that page.
+var portals = []struct {
+	product string
+	path    string
+	marker  string
+}{
+	{"Palo Alto GlobalProtect", "/global-protect/login.esp", "GlobalProtect"},
+	{"Pulse Secure", "/dana-na/auth/url_default/welcome.cgi", "dana-na"},
+	{"Fortinet FortiGate", "/remote/login", "fgt_lang"},
+	{"Cisco AnyConnect", "/+CSCOE+/logon.html", "webvpn"},
+	{"SonicWall", "/cgi-bin/welcome", "SonicWall"},
+}
+
+// Finding is an identity provider detected for the domain.
+type Finding struct {
+	// Product is the detected product (e.g. Okta, Azure AD)
+	Product string `json:"product"`
+
+	// Evidence describes how the product was detected
+	Evidence string `json:"evidence"`
+
+	// Provider is the nozzle to target the product with, if any
+	Provider string `json:"provider,omitempty"`
+
+	// Config is the suggested provider metadata of the nozzle
+	Config map[string]string `json:"config,omitempty"`
+}
+
+// Report is the outcome of Run.
+type Report struct {
+	Domain   string    `json:"domain"`
+	MX       []string  `json:"mx"`
+	Findings []Finding `json:"findings"`
+
+	// Errors lists the checks which could not be completed
+	Errors []string `json:"errors,omitempty"`
+}
+
+// check fingerprints a single kind of provider.
+type check func(ctx context.Context, client *http.Client, domain string) ([]Finding, error)
+
+// Run fingerprints the providers of domain. checks run concurrently, and a
+// failing check is recorded in the report rather than failing the recon.
+func Run(ctx context.Context, domain string) (*Report, error) {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	u, err := url.Parse("https://" + domain)
+	if err != nil || domain == "" || u.Host != domain || strings.Contains(domain, ":") {
+		return nil, fmt.Errorf("recon requires a domain name, got %q", domain)
+	}
+
+	client := &http.Client{
+		Transport: http.DefaultClient.Transport,
+		Timeout:   Timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	r := &Report{Domain: domain}
+	checks := map[string]check{
+		"mx": func(ctx context.Context, client *http.Client, domain string) ([]Finding, error) {
+			mx, findings, err := checkMX(ctx, domain)
+			r.MX = mx
+			return findings, err
+		},
+		"autodiscover": checkAutodiscover,
+		"azure ad":     checkRealm,
+		"okta":         checkOkta,
+		"vpn":          checkVPN,
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, c := range checks {
+		wg.Add(1)
+		go func(name string, c check) {
+			defer wg.Done()
+			findings, err := c(ctx, client, domain)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				r.Errors = append(r.Errors, fmt.Sprintf("%s: %s", name, err))
+			}
+			r.Findings = append(r.Findings, findings...)
+		}(name, c)
+	}
+	wg.Wait()
+
+	sort.Slice(r.Findings, func(i, j int) bool {
+		if r.Findings[i].Product != r.Findings[j].Product {
+			return r.Findings[i].Product < r.Findings[j].Product
+		}
+		return r.Findings[i].Evidence < r.Findings[j].Evidence
+	})
+	sort.Strings(r.Errors)
+	return r, nil
+}
+
+// Providers returns the distinct nozzle configurations suggested by the
+// findings.
+func (r *Report) Providers() []Finding {
+	var suggested []Finding
+	seen := make(map[string]bool)
+	for _, f := range r.Findings {
+		if f.Provider == "" {
+			continue
+		}
+		key, _ := json.Marshal([]interface{}{f.Provider, f.Config})
+		if seen[string(key)] {
+			continue
+		}
+		seen[string(key)] = true
+		suggested = append(suggested, f)
+	}
+	return suggested
+}
+
+// checkMX returns the mail exchangers of the domain, which reveal hosted
+// Exchange Online and Google Workspace tenants.
+func checkMX(ctx context.Context, domain string) ([]string, []Finding, error) {
+	records, err := lookupMX(ctx, domain)
+	if err != nil {
+		if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+
+	var mx []string
+	var findings []Finding
+	for _, rec := range records {
+		host := strings.ToLower(strings.TrimSuffix(rec.Host, "."))
+		mx = append(mx, host)
+		switch {
+		case strings.HasSuffix(host, ".mail.protection.outlook.com"):
+			findings = append(findings, Finding{
+				Product:  "Exchange Online",
+				Evidence: "MX " + host,
+				Provider: "o365",
+			})
+		case strings.HasSuffix(host, ".google.com") || strings.HasSuffix(host, ".googlemail.com"):
+			findings = append(findings, Finding{
+				Product:  "Google Workspace",
+				Evidence: "MX " + host,
+			})
+		}
+	}
+	return mx, findings, nil
+}
+
+// checkAutodiscover resolves the autodiscover record of the domain, which
+// points to Exchange Online for Microsoft 365 tenants.
+func checkAutodiscover(ctx context.Context, client *http.Client, domain string) ([]Finding, error) {
+	cname, err := lookupCNAME(ctx, "autodiscover."+domain)
+	if err != nil {
+		if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	cname = strings.ToLower(strings.TrimSuffix(cname, "."))
+	if cname != "autodiscover.outlook.com" {
+		return nil, nil
+	}
+	return []Finding{{
+		Product:  "Exchange Online",
+		Evidence: "autodiscover." + domain + " CNAME " + cname,
+		Provider: "o365",
+	}}, nil
+}
+
+// userRealm is the response of the Azure AD realm discovery endpoint.
+type userRealm struct {
+	NameSpaceType       string `json:"NameSpaceType"`
+	FederationBrandName string `json:"FederationBrandName"`
+	AuthURL             string `json:"AuthURL"`
+}
+
+// checkRealm queries the Azure AD realm of a placeholder user of the domain.
+// federated realms reveal the on-premises (or third party) identity provider.
+func checkRealm(ctx context.Context, client *http.Client, domain string) ([]Finding, error) {
+	var realm userRealm
+	err := getJSON(ctx, client, fmt.Sprintf(realmURL, url.QueryEscape("trident@"+domain)), &realm)
+	if err != nil {
+		return nil, err
+	}
+
+	switch realm.NameSpaceType {
+	case "Managed":
+		return []Finding{{
+			Product:  "Azure AD",
+			Evidence: "managed realm (" + realm.FederationBrandName + ")",
+			Provider: "o365",
+		}}, nil
+	case "Federated":
+		findings := []Finding{{
+			Product:  "Azure AD",
+			Evidence: "federated realm (" + realm.FederationBrandName + ")",
+		}}
+		auth, err := url.Parse(realm.AuthURL)
+		if err != nil || auth.Hostname() == "" {
+			return findings, nil
+		}
+		host := auth.Hostname()
+		switch {
+		case strings.HasSuffix(host, ".okta.com"):
+			findings = append(findings, Finding{
+				Product:  "Okta",
+				Evidence: "federated realm redirects to " + host,
+				Provider: "okta",
+				Config:   map[string]string{"subdomain": strings.TrimSuffix(host, ".okta.com")},
+			})
+		case strings.Contains(strings.ToLower(auth.Path), "/adfs/"):
+			findings = append(findings, Finding{
+				Product:  "ADFS",
+				Evidence: "federated realm redirects to " + host,
+				Provider: "adfs",
+				Config:   map[string]string{"domain": host},
+			})
+		default:
+			findings = append(findings, Finding{
+				Product:  "Federated identity provider",
+				Evidence: "federated realm redirects to " + host,
+			})
+		}
+		return findings, nil
+	}
+	return nil, nil
+}
+
+// checkOkta looks for an Okta organization named after the domain (e.g.
+// example.okta.com for example.com).
+func checkOkta(ctx context.Context, client *http.Client, domain string) ([]Finding, error) {
+	subdomain := strings.SplitN(domain, ".", 2)[0]
+	var config struct {
+		Issuer string `json:"issuer"`
+	}
+	err := getJSON(ctx, client, fmt.Sprintf(oktaURL, subdomain), &config)
+	if err == errNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []Finding{{
+		Product:  "Okta",
+		Evidence: "organization " + subdomain + ".okta.com exists",
+		Provider: "okta",
+		Config:   map[string]string{"subdomain": subdomain},
+	}}, nil
+}
+
+// checkVPN probes the VPNHosts of the domain for the login page of common VPN
+// products. hosts which do not resolve or respond are skipped.
+func checkVPN(ctx context.Context, client *http.Client, domain string) ([]Finding, error) {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var findings []Finding
+	for _, h := range VPNHosts {
+		wg.Add(1)
+		go func(host string) {
+			defer wg.Done()
+			for _, p := range portals {
+				status, body, err := get(ctx, client, "https://"+host+p.path)
+				if err != nil {
+					// the host does not resolve or respond
+					return
+				}
+				if status == 200 && strings.Contains(strings.ToLower(string(body)), strings.ToLower(p.marker)) {
+					mu.Lock()
+					findings = append(findings, Finding{
+						Product:  p.product,
+						Evidence: "login page at https://" + host + p.path,
+					})
+					mu.Unlock()
+					return
+				}
+			}
+		}(h + "." + domain)
+	}
+	wg.Wait()
+	return findings, nil
+}
+
+var errNotFound = fmt.Errorf("not found")
+
+// getJSON decodes the JSON response of a GET request. errNotFound is returned
+// for 404 responses.
+func getJSON(ctx context.Context, client *http.Client, u string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	switch resp.StatusCode {
+	case 200:
+		return json.NewDecoder(resp.Body).Decode(v)
+	case 404:
+		return errNotFound
+	}
+	// redirects are not followed, so unknown organizations which redirect
+	// to a landing page are not found either
+	if resp.StatusCode >= 300 && resp.StatusCode < 400 {
+		return errNotFound
+	}
+	return fmt.Errorf("unexpected status code %d from %s", resp.StatusCode, req.URL.Host)
+}
+
+// get returns the status code and body of the response to a GET request.
+func get(ctx context.Context, client *http.Client, u string) (int, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	return resp.StatusCode, body, err
+}
@@ -0,0 +1,108 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package recon
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Host + r.URL.Path {
+		case "login.microsoftonline.com/getuserrealm.srf":
+			if r.URL.Query().Get("login") != "trident@example.com" {
+				t.Errorf("unexpected realm discovery login %q", r.URL.Query().Get("login"))
+			}
+			w.Write([]byte(`{"NameSpaceType":"Federated","FederationBrandName":"Example",` + // nolint:errcheck,gosec
+				`"AuthURL":"https://sts.example.com/adfs/ls/?username=trident%40example.com"}`))
+		case "example.okta.com/.well-known/openid-configuration":
+			w.Write([]byte(`{"issuer":"https://example.okta.com"}`)) // nolint:errcheck,gosec
+		case "vpn.example.com/global-protect/login.esp":
+			w.Write([]byte(`<title>GlobalProtect Portal</title>`)) // nolint:errcheck,gosec
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	// every host resolves to the test server
+	client := http.DefaultClient
+	http.DefaultClient = &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return net.Dial(network, srv.Listener.Addr().String())
+		},
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, // nolint:gosec
+	}}
+	defer func() { http.DefaultClient = client }()
+
+	mx, cname := lookupMX, lookupCNAME
+	lookupMX = func(ctx context.Context, name string) ([]*net.MX, error) {
+		return []*net.MX{{Host: "example-com.mail.protection.outlook.com.", Pref: 0}}, nil
+	}
+	lookupCNAME = func(ctx context.Context, host string) (string, error) {
+		return "", &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	defer func() { lookupMX, lookupCNAME = mx, cname }()
+
+	r, err := Run(context.Background(), "Example.com.")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(r.Errors) > 0 {
+		t.Errorf("unexpected errors: %v", r.Errors)
+	}
+	if !reflect.DeepEqual(r.MX, []string{"example-com.mail.protection.outlook.com"}) {
+		t.Errorf("unexpected mx records %v", r.MX)
+	}
+
+	products := make(map[string]Finding)
+	for _, f := range r.Findings {
+		products[f.Product] = f
+	}
+	for _, product := range []string{"ADFS", "Azure AD", "Exchange Online", "Okta", "Palo Alto GlobalProtect"} {
+		if _, ok := products[product]; !ok {
+			t.Errorf("expected %s to be detected, got %+v", product, r.Findings)
+		}
+	}
+	if c := products["ADFS"].Config; c["domain"] != "sts.example.com" {
+		t.Errorf("unexpected adfs config %v", c)
+	}
+	if c := products["Okta"].Config; c["subdomain"] != "example" {
+		t.Errorf("unexpected okta config %v", c)
+	}
+
+	var providers []string
+	for _, f := range r.Providers() {
+		providers = append(providers, f.Provider)
+	}
+	if !reflect.DeepEqual(providers, []string{"adfs", "o365", "okta"}) {
+		t.Errorf("unexpected suggested providers %v", providers)
+	}
+}
+
+func TestRunInvalidDomain(t *testing.T) {
+	for _, domain := range []string{"example.com/path", "user@example.com", "example.com:443", ""} {
+		_, err := Run(context.Background(), domain)
+		if err == nil {
+			t.Errorf("expected an error for %q", domain)
+		}
+	}
+}
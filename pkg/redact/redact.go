@@ -0,0 +1,248 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package redact replaces the credentials stored in the results of a campaign
+// with tokens, so that read-only users of a shared deployment can review the
+// outcomes of a campaign without seeing plaintext credentials. Tokens are
+// derived from the value and a per-campaign key with HMAC-SHA256: the same
+// password (or username) always maps to the same token within a campaign,
+// which keeps results comparable. The replaced values are sealed in a
+// separate table and only revealed to operators.
+package redact
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+	"github.com/praetorian-inc/trident/pkg/kms"
+)
+
+const (
+	// TokenPrefix identifies redaction tokens.
+	TokenPrefix = "tok:v1:"
+
+	// KindUsername and KindPassword are the kinds of redacted values
+	KindUsername = "username"
+	KindPassword = "password"
+)
+
+// IsToken returns true if the value is a redaction token.
+func IsToken(value string) bool {
+	return strings.HasPrefix(value, TokenPrefix)
+}
+
+// Token derives the token of a value from a campaign's key.
+func Token(key []byte, kind, value string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(kind + "\x00" + value)) // nolint:errcheck,gosec
+	return TokenPrefix + hex.EncodeToString(mac.Sum(nil)[:12])
+}
+
+// Finder is the interface that wraps looking up the redactions of tokens.
+type Finder interface {
+	SelectRedactions([]string) ([]db.Redaction, error)
+}
+
+// Store is the interface that wraps the storage of redactions.
+type Store interface {
+	Finder
+	CampaignRedaction(uint) (db.RedactionMode, string, error)
+	InsertRedactions([]db.Redaction) error
+}
+
+// NewKey generates the redaction key of a campaign, sealed by the envelope.
+func NewKey(ctx context.Context, envelope *kms.Envelope) (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return envelope.Seal(ctx, hex.EncodeToString(key))
+}
+
+type campaignKey struct {
+	mode db.RedactionMode
+	key  []byte
+}
+
+// Redactor redacts the results of campaigns before they are stored.
+type Redactor struct {
+	// DB stores the redactions and the redaction settings of campaigns
+	DB Store
+
+	// Envelope unseals the keys and passwords, and seals the redacted values
+	Envelope *kms.Envelope
+
+	mu   sync.Mutex
+	keys map[uint]campaignKey
+}
+
+// key returns the (cached) redaction mode and key of a campaign. they never
+// change once the campaign is created.
+func (r *Redactor) key(ctx context.Context, campaignID uint) (campaignKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if k, ok := r.keys[campaignID]; ok {
+		return k, nil
+	}
+
+	mode, sealed, err := r.DB.CampaignRedaction(campaignID)
+	if err != nil {
+		return campaignKey{}, err
+	}
+	k := campaignKey{mode: mode}
+	if mode != db.RedactionNone {
+		if sealed == "" {
+			return k, fmt.Errorf("campaign %d has no redaction key", campaignID)
+		}
+		plaintext, err := r.Envelope.Unseal(ctx, sealed)
+		if err != nil {
+			return k, err
+		}
+		k.key, err = hex.DecodeString(plaintext)
+		if err != nil {
+			return k, fmt.Errorf("invalid redaction key of campaign %d: %w", campaignID, err)
+		}
+	}
+
+	if r.keys == nil {
+		r.keys = make(map[uint]campaignKey)
+	}
+	r.keys[campaignID] = k
+	return k, nil
+}
+
+// Redact returns the result to store: the result itself if its campaign is
+// not redacted, or a copy with its credentials replaced with tokens. the
+// replaced values are recorded before the copy is returned. if an error is
+// returned, the copy keeps the credentials with its password sealed, so that
+// the result is not lost; read-only users never receive credentials of a
+// redacted campaign which are not tokens.
+func (r *Redactor) Redact(ctx context.Context, res *db.Result) (*db.Result, error) {
+	if res.CampaignID == 0 {
+		return res, nil
+	}
+	k, err := r.key(ctx, res.CampaignID)
+	if err != nil {
+		return r.seal(ctx, res), err
+	}
+	if k.mode == db.RedactionNone {
+		return res, nil
+	}
+
+	redacted := *res
+	var redactions []db.Redaction
+	if res.Password != "" {
+		password, err := r.Envelope.Unseal(ctx, res.Password)
+		if err != nil {
+			return r.seal(ctx, res), err
+		}
+		redacted.Password, err = r.redaction(ctx, &redactions, res.CampaignID, k.key, KindPassword, password)
+		if err != nil {
+			return r.seal(ctx, res), err
+		}
+	}
+	if k.mode.Usernames() && res.Username != "" {
+		redacted.Username, err = r.redaction(ctx, &redactions, res.CampaignID, k.key, KindUsername, res.Username)
+		if err != nil {
+			return r.seal(ctx, res), err
+		}
+	}
+
+	err = r.DB.InsertRedactions(redactions)
+	if err != nil {
+		return r.seal(ctx, res), err
+	}
+	return &redacted, nil
+}
+
+// seal returns a copy of a result which could not be redacted, with its
+// password sealed. the password is withheld if it cannot be sealed.
+func (r *Redactor) seal(ctx context.Context, res *db.Result) *db.Result {
+	sealed := *res
+	if sealed.Password == "" || kms.IsSealed(sealed.Password) {
+		return &sealed
+	}
+
+	var err error
+	sealed.Password, err = r.Envelope.Seal(ctx, res.Password)
+	if err != nil {
+		sealed.Password = ""
+	}
+	return &sealed
+}
+
+// redaction appends the redaction of a value and returns its token.
+func (r *Redactor) redaction(ctx context.Context, redactions *[]db.Redaction, campaignID uint, key []byte,
+	kind, value string) (string, error) {
+	token := Token(key, kind, value)
+	sealed, err := r.Envelope.Seal(ctx, value)
+	if err != nil {
+		return "", err
+	}
+	*redactions = append(*redactions, db.Redaction{
+		Token:      token,
+		CampaignID: campaignID,
+		Kind:       kind,
+		Value:      sealed,
+	})
+	return token, nil
+}
+
+// Reveal replaces the tokens of the results with the values they redact.
+// tokens without a recorded redaction are left in place.
+func Reveal(ctx context.Context, f Finder, envelope *kms.Envelope, results []db.Result) error {
+	var tokens []string
+	seen := make(map[string]bool)
+	for _, res := range results {
+		for _, v := range []string{res.Username, res.Password} {
+			if IsToken(v) && !seen[v] {
+				seen[v] = true
+				tokens = append(tokens, v)
+			}
+		}
+	}
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	redactions, err := f.SelectRedactions(tokens)
+	if err != nil {
+		return err
+	}
+	values := make(map[string]string, len(redactions))
+	for _, r := range redactions {
+		values[r.Token], err = envelope.Unseal(ctx, r.Value)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i := range results {
+		if v, ok := values[results[i].Username]; ok {
+			results[i].Username = v
+		}
+		if v, ok := values[results[i].Password]; ok {
+			results[i].Password = v
+		}
+	}
+	return nil
+}
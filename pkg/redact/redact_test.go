@@ -0,0 +1,163 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redact
+
+import (
+	"context"
+	"testing"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+	"github.com/praetorian-inc/trident/pkg/kms"
+	"github.com/praetorian-inc/trident/pkg/kms/local"
+)
+
+type fakeStore struct {
+	mode       db.RedactionMode
+	key        string
+	redactions map[string]db.Redaction
+}
+
+func (f *fakeStore) CampaignRedaction(id uint) (db.RedactionMode, string, error) {
+	return f.mode, f.key, nil
+}
+
+func (f *fakeStore) InsertRedactions(redactions []db.Redaction) error {
+	for _, r := range redactions {
+		if _, ok := f.redactions[r.Token]; !ok {
+			f.redactions[r.Token] = r
+		}
+	}
+	return nil
+}
+
+func (f *fakeStore) SelectRedactions(tokens []string) ([]db.Redaction, error) {
+	var redactions []db.Redaction
+	for _, token := range tokens {
+		if r, ok := f.redactions[token]; ok {
+			redactions = append(redactions, r)
+		}
+	}
+	return redactions, nil
+}
+
+func newEnvelope(t *testing.T) *kms.Envelope {
+	keys, err := local.Driver{}.New(map[string]string{
+		"key": "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=",
+	})
+	if err != nil {
+		t.Fatalf("error creating key manager: %s", err)
+	}
+	return kms.NewEnvelope(keys)
+}
+
+func TestRedact(t *testing.T) {
+	ctx := context.Background()
+	envelope := newEnvelope(t)
+	key, err := NewKey(ctx, envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := envelope.Seal(ctx, "Password1!")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		mode     db.RedactionMode
+		username bool
+		password bool
+	}{
+		{db.RedactionNone, false, false},
+		{db.RedactionPasswords, false, true},
+		{db.RedactionCredentials, true, true},
+	}
+
+	for _, test := range tests {
+		store := &fakeStore{mode: test.mode, key: key, redactions: make(map[string]db.Redaction)}
+		r := &Redactor{DB: store, Envelope: envelope}
+
+		res := &db.Result{CampaignID: 1, Username: "alice@example.org", Password: sealed, Valid: true}
+		stored, err := r.Redact(ctx, res)
+		if err != nil {
+			t.Fatalf("[%s] unexpected error: %s", test.mode, err)
+		}
+		if res.Username != "alice@example.org" || res.Password != sealed {
+			t.Errorf("[%s] the plaintext result was modified", test.mode)
+		}
+		if IsToken(stored.Username) != test.username || IsToken(stored.Password) != test.password {
+			t.Errorf("[%s] got username %q and password %q", test.mode, stored.Username, stored.Password)
+		}
+		if !stored.Valid {
+			t.Errorf("[%s] the outcome was not kept", test.mode)
+		}
+
+		if test.mode == db.RedactionNone {
+			continue
+		}
+
+		// tokens are stable within a campaign, whether the password is
+		// sealed or not
+		again, err := r.Redact(ctx, &db.Result{CampaignID: 1, Username: "alice@example.org", Password: "Password1!"})
+		if err != nil || again.Password != stored.Password || again.Username != stored.Username {
+			t.Errorf("[%s] tokens changed between results", test.mode)
+		}
+
+		results := []db.Result{*stored}
+		err = Reveal(ctx, store, envelope, results)
+		if err != nil {
+			t.Fatalf("[%s] error revealing: %s", test.mode, err)
+		}
+		if results[0].Username != "alice@example.org" || results[0].Password != "Password1!" {
+			t.Errorf("[%s] got %q and %q after reveal", test.mode, results[0].Username, results[0].Password)
+		}
+	}
+}
+
+func TestRedactError(t *testing.T) {
+	ctx := context.Background()
+	store := &fakeStore{mode: db.RedactionCredentials, key: "invalid", redactions: make(map[string]db.Redaction)}
+	r := &Redactor{DB: store, Envelope: newEnvelope(t)}
+
+	res := &db.Result{CampaignID: 1, Username: "alice@example.org", Password: "Password1!", Valid: true}
+	stored, err := r.Redact(ctx, res)
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if stored.Username != res.Username || !stored.Valid {
+		t.Errorf("expected the result to be kept, got %+v", stored)
+	}
+	if !kms.IsSealed(stored.Password) {
+		t.Errorf("expected the password to be sealed, got %q", stored.Password)
+	}
+	if res.Password != "Password1!" {
+		t.Errorf("the plaintext result was modified")
+	}
+}
+
+func TestToken(t *testing.T) {
+	a := Token([]byte("campaign-1"), KindPassword, "Password1!")
+	if a != Token([]byte("campaign-1"), KindPassword, "Password1!") {
+		t.Errorf("tokens are not deterministic")
+	}
+	if a == Token([]byte("campaign-2"), KindPassword, "Password1!") {
+		t.Errorf("tokens of different campaigns must differ")
+	}
+	if a == Token([]byte("campaign-1"), KindUsername, "Password1!") {
+		t.Errorf("tokens of different kinds must differ")
+	}
+	if !IsToken(a) || IsToken("Password1!") {
+		t.Errorf("unexpected IsToken result")
+	}
+}
@@ -0,0 +1,244 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package replay records the provider responses of a nozzle and replays them
+// through its classification. recordings are sanitized so they can be kept
+// as test fixtures, and replaying them never contacts the provider, which
+// makes them regression tests of a nozzle's parsing against real provider
+// behavior:
+//
+//	noz, err := nozzle.Open("okta", map[string]string{
+//	    "subdomain":        "example",
+//	    "capture_response": "true",
+//	})
+//	rec := replay.NewRecorder("okta", noz, f)
+//	res, err := rec.Login(ctx, "alice@example.org", "Winter2020!")
+//
+//	recordings, err := replay.Load("testdata/okta.jsonl")
+//	for _, m := range replay.Replay(noz.(nozzle.Classifier), recordings) {
+//	    // the classification of m.Recording changed
+//	}
+package replay
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/praetorian-inc/trident/pkg/event"
+	"github.com/praetorian-inc/trident/pkg/nozzle"
+	"github.com/praetorian-inc/trident/pkg/reclassify"
+	"github.com/praetorian-inc/trident/pkg/rules"
+)
+
+// Redacted replaces the sensitive values of a recorded response.
+const Redacted = "REDACTED"
+
+// sensitiveHeaders are replaced wholesale, as they carry sessions or
+// credentials.
+var sensitiveHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "X-Csrf-Token"}
+
+// tokenFields matches the JSON fields of a body which carry tokens.
+var tokenFields = regexp.MustCompile(
+	`"(sessionToken|stateToken|access_token|refresh_token|id_token|token|csrf_token)"\s*:\s*"[^"]*"`)
+
+// Recording is a sanitized provider response and the outcome the nozzle
+// classified it as when it was recorded.
+type Recording struct {
+	// Provider is the nozzle which made the attempt
+	Provider string `json:"provider"`
+
+	// Response is the sanitized provider response
+	Response *rules.Response `json:"response"`
+
+	// Outcome is the expected classification of the response
+	Outcome reclassify.Outcome `json:"outcome"`
+
+	// RecordedAt is the time of the attempt
+	RecordedAt time.Time `json:"recorded_at"`
+}
+
+// Sanitize returns a copy of the response without the attempted credential,
+// the session cookies and authorization headers, and the tokens of a JSON
+// body.
+func Sanitize(resp *rules.Response, username, password string) *rules.Response {
+	var secrets []*regexp.Regexp
+	for _, s := range []string{username, password} {
+		if s == "" {
+			continue
+		}
+		secrets = append(secrets, regexp.MustCompile(`(?i)`+regexp.QuoteMeta(s)))
+		if escaped := url.QueryEscape(s); escaped != s {
+			secrets = append(secrets, regexp.MustCompile(`(?i)`+regexp.QuoteMeta(escaped)))
+		}
+	}
+	scrub := func(b []byte) []byte {
+		for _, re := range secrets {
+			b = re.ReplaceAllLiteral(b, []byte(Redacted))
+		}
+		return b
+	}
+
+	c := &rules.Response{
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header.Clone(),
+		Body:       scrub(append([]byte(nil), resp.Body...)),
+	}
+	c.Body = tokenFields.ReplaceAll(c.Body, []byte(`"$1":"`+Redacted+`"`))
+	for _, values := range c.Header {
+		for i := range values {
+			values[i] = string(scrub([]byte(values[i])))
+		}
+	}
+	for _, name := range sensitiveHeaders {
+		if len(c.Header.Values(name)) > 0 {
+			c.Header.Set(name, Redacted)
+		}
+	}
+	return c
+}
+
+// Recorder wraps a nozzle and records the sanitized response of each login
+// as a line of JSON. the nozzle must capture its responses (see the
+// capture_response nozzle option); logins without a captured response are
+// not recorded.
+type Recorder struct {
+	nozzle.Nozzle
+
+	// Provider is the name of the nozzle
+	Provider string
+
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewRecorder returns a Recorder of the nozzle writing recordings to w.
+func NewRecorder(provider string, noz nozzle.Nozzle, w io.Writer) *Recorder {
+	return &Recorder{Nozzle: noz, Provider: provider, enc: json.NewEncoder(w)}
+}
+
+// Login fulfils the nozzle.Nozzle interface and records the response of the
+// wrapped nozzle. failing to record a response is logged, and does not fail
+// the login.
+func (r *Recorder) Login(ctx context.Context, username, password string) (*event.AuthResponse, error) {
+	res, err := r.Nozzle.Login(ctx, username, password)
+	if err != nil || res.Response == "" {
+		return res, err
+	}
+
+	resp, err := rules.DecodeResponse(res.Response)
+	if err != nil {
+		log.Warnf("unable to record the response of %s: %s", r.Provider, err)
+		return res, nil
+	}
+	rec := Recording{
+		Provider:   r.Provider,
+		Response:   Sanitize(resp, username, password),
+		Outcome:    reclassify.ResponseOutcome(res),
+		RecordedAt: time.Now().UTC(),
+	}
+
+	r.mu.Lock()
+	err = r.enc.Encode(&rec)
+	r.mu.Unlock()
+	if err != nil {
+		log.Warnf("unable to record the response of %s: %s", r.Provider, err)
+	}
+	return res, nil
+}
+
+// Read reads the recordings written by a Recorder.
+func Read(rd io.Reader) ([]Recording, error) {
+	var recordings []Recording
+	scanner := bufio.NewScanner(rd)
+	scanner.Buffer(make([]byte, 64*1024), 2*rules.MaxCaptureSize+64*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var rec Recording
+		err := json.Unmarshal(scanner.Bytes(), &rec)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if rec.Response == nil {
+			return nil, fmt.Errorf("line %d: recording has no response", line)
+		}
+		recordings = append(recordings, rec)
+	}
+	return recordings, scanner.Err()
+}
+
+// Load reads the recordings of a file written by a Recorder.
+func Load(path string) ([]Recording, error) {
+	f, err := os.Open(path) // nolint:gosec
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() // nolint:errcheck,gosec
+	return Read(f)
+}
+
+// Mismatch is a recording whose response is no longer classified as
+// recorded.
+type Mismatch struct {
+	// Index is the position of the recording
+	Index int
+
+	Recording *Recording
+
+	// Got is the current classification of the response, unless Err is set
+	Got reclassify.Outcome
+
+	// Err is the error of the classification, if it failed
+	Err error
+}
+
+func (m Mismatch) String() string {
+	if m.Err != nil {
+		return fmt.Sprintf("recording %d (%s, status %d): expected %s, got error: %s",
+			m.Index, m.Recording.Provider, m.Recording.Response.StatusCode, m.Recording.Outcome, m.Err)
+	}
+	return fmt.Sprintf("recording %d (%s, status %d): expected %s, got %s",
+		m.Index, m.Recording.Provider, m.Recording.Response.StatusCode, m.Recording.Outcome, m.Got)
+}
+
+// Replay classifies each recorded response and returns the recordings whose
+// classification differs from the recorded outcome. the classification
+// rules set with rules.Set are applied as they are by the nozzle.
+func Replay(c nozzle.Classifier, recordings []Recording) []Mismatch {
+	var mismatches []Mismatch
+	for i := range recordings {
+		rec := &recordings[i]
+		res, err := c.Classify(rec.Response)
+		if err != nil {
+			mismatches = append(mismatches, Mismatch{Index: i, Recording: rec, Err: err})
+			continue
+		}
+		got := reclassify.ResponseOutcome(res)
+		if got != rec.Outcome {
+			mismatches = append(mismatches, Mismatch{Index: i, Recording: rec, Got: got})
+		}
+	}
+	return mismatches
+}
@@ -0,0 +1,136 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replay
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/praetorian-inc/trident/pkg/event"
+	"github.com/praetorian-inc/trident/pkg/rules"
+)
+
+func TestSanitize(t *testing.T) {
+	resp := &rules.Response{
+		StatusCode: 200,
+		Header: http.Header{
+			"Set-Cookie":   []string{"sid=abc123; Secure"},
+			"Content-Type": []string{"application/json"},
+			"Location":     []string{"/login?user=alice%40example.org"},
+		},
+		Body: []byte(`{"status":"SUCCESS","sessionToken":"20111ZxbHqC","login":"Alice@Example.org","hint":"Winter2020!"}`),
+	}
+	c := Sanitize(resp, "alice@example.org", "Winter2020!")
+
+	for _, secret := range []string{"abc123", "20111ZxbHqC", "Alice@Example.org", "alice%40example.org", "Winter2020!"} {
+		if bytes.Contains(c.Body, []byte(secret)) || strings.Contains(strings.Join(c.Header.Values("Set-Cookie"), ""),
+			secret) || strings.Contains(c.Header.Get("Location"), secret) {
+			t.Errorf("sanitized response contains %q: %s %v", secret, c.Body, c.Header)
+		}
+	}
+	if !bytes.Contains(c.Body, []byte(`"status":"SUCCESS"`)) || c.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("sanitized response lost unrelated fields: %s %v", c.Body, c.Header)
+	}
+	if !bytes.Contains(resp.Body, []byte("20111ZxbHqC")) || resp.Header.Get("Set-Cookie") == Redacted {
+		t.Errorf("the original response was modified")
+	}
+}
+
+type fakeNozzle struct{}
+
+func (fakeNozzle) Login(ctx context.Context, username, password string) (*event.AuthResponse, error) {
+	if username == "error" {
+		return nil, errors.New("connection refused")
+	}
+	resp := &rules.Response{StatusCode: 200, Body: []byte(`{"user":"` + username + `","password":"` + password + `"}`)}
+	if username == "uncaptured" {
+		return &event.AuthResponse{}, nil
+	}
+	return &event.AuthResponse{Valid: password == "Winter2020!", Response: resp.Encode()}, nil
+}
+
+// fakeClassifier classifies every response as valid
+type fakeClassifier struct{}
+
+func (fakeClassifier) Classify(resp *rules.Response) (*event.AuthResponse, error) {
+	if resp.StatusCode != 200 {
+		return nil, errors.New("unhandled status code")
+	}
+	return &event.AuthResponse{Valid: true}, nil
+}
+
+func TestRecordReplay(t *testing.T) {
+	var buf bytes.Buffer
+	rec := NewRecorder("fake", fakeNozzle{}, &buf)
+
+	ctx := context.Background()
+	for _, attempt := range [][2]string{
+		{"alice@example.org", "Winter2020!"},
+		{"bob@example.org", "Summer2020!"},
+		{"uncaptured", "Winter2020!"},
+		{"error", "Winter2020!"},
+	} {
+		res, err := rec.Login(ctx, attempt[0], attempt[1])
+		if attempt[0] == "error" {
+			if err == nil {
+				t.Errorf("expected the error of the nozzle")
+			}
+			continue
+		}
+		if err != nil || res == nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	recordings, err := Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recordings) != 2 || !recordings[0].Outcome.Valid || recordings[1].Outcome.Valid {
+		t.Fatalf("unexpected recordings %+v", recordings)
+	}
+	for _, r := range recordings {
+		if bytes.Contains(r.Response.Body, []byte("example.org")) || bytes.Contains(r.Response.Body, []byte("2020!")) {
+			t.Errorf("recording contains credentials: %s", r.Response.Body)
+		}
+	}
+
+	// bob's invalid attempt is now classified as valid
+	mismatches := Replay(fakeClassifier{}, recordings)
+	if len(mismatches) != 1 || mismatches[0].Index != 1 || !mismatches[0].Got.Valid {
+		t.Errorf("unexpected mismatches %+v", mismatches)
+	}
+
+	recordings[0].Response.StatusCode = 500
+	mismatches = Replay(fakeClassifier{}, recordings)
+	if len(mismatches) != 2 || mismatches[0].Err == nil {
+		t.Errorf("expected a classification error, got %+v", mismatches)
+	}
+}
+
+func TestRead(t *testing.T) {
+	_, err := Read(strings.NewReader(`{"provider":"okta","outcome":{"valid":true}}`))
+	if err == nil {
+		t.Errorf("expected an error for a recording without a response")
+	}
+	_, err = Read(strings.NewReader("{\"provider\":\"okta\",\"response\":{\"status\":200}}\n\nnot json\n"))
+	if err == nil || !strings.HasPrefix(err.Error(), "line 3:") {
+		t.Errorf("expected an error on line 3, got %v", err)
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"sort"
+	"time"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+)
+
+// EgressStats summarizes the attempts made from a single egress IP, so that
+// operators can show which IPs generated which traffic and correlate blocks
+// applied by the target.
+type EgressStats struct {
+	IP     string `json:"ip"`
+	Region string `json:"region"`
+
+	// Attempts is the number of attempts made from the IP
+	Attempts int `json:"attempts"`
+
+	// Valid is the number of attempts which found a valid password
+	Valid int `json:"valid"`
+
+	// Locked is the number of attempts against locked accounts
+	Locked int `json:"locked"`
+
+	// RateLimited is the number of attempts which were rate limited
+	RateLimited int `json:"rate_limited"`
+
+	// Captcha is the number of attempts challenged with a CAPTCHA
+	Captcha int `json:"captcha"`
+
+	// Errors is the number of attempts which failed with an error
+	Errors int `json:"errors"`
+
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// Egress returns the statistics of each egress IP, ordered by first use.
+// results without an IP (e.g. tasks which never reached a worker) are
+// ignored.
+func Egress(results []db.Result) []EgressStats {
+	byIP := make(map[string]*EgressStats)
+	for i := range results {
+		res := &results[i]
+		if res.IP == "" {
+			continue
+		}
+
+		e, ok := byIP[res.IP]
+		if !ok {
+			e = &EgressStats{IP: res.IP, FirstSeen: res.Timestamp, LastSeen: res.Timestamp}
+			byIP[res.IP] = e
+		}
+		if res.Region != "" {
+			e.Region = res.Region
+		}
+
+		e.Attempts++
+		switch {
+		case res.Error != "":
+			e.Errors++
+		case res.RateLimited:
+			e.RateLimited++
+		case res.Captcha:
+			e.Captcha++
+		case res.Locked:
+			e.Locked++
+		case res.Valid:
+			e.Valid++
+		}
+
+		if res.Timestamp.Before(e.FirstSeen) {
+			e.FirstSeen = res.Timestamp
+		}
+		if res.Timestamp.After(e.LastSeen) {
+			e.LastSeen = res.Timestamp
+		}
+	}
+
+	stats := make([]EgressStats, 0, len(byIP))
+	for _, e := range byIP {
+		stats = append(stats, *e)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].FirstSeen.Equal(stats[j].FirstSeen) {
+			return stats[i].IP < stats[j].IP
+		}
+		return stats[i].FirstSeen.Before(stats[j].FirstSeen)
+	})
+	return stats
+}
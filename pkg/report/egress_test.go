@@ -0,0 +1,50 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"testing"
+	"time"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+)
+
+func TestEgress(t *testing.T) {
+	start := time.Date(2020, 10, 1, 9, 0, 0, 0, time.UTC)
+	at := func(minutes int) time.Time {
+		return start.Add(time.Duration(minutes) * time.Minute)
+	}
+	results := []db.Result{
+		{IP: "203.0.113.2", Region: "us-east-1", Timestamp: at(5), Valid: true},
+		{IP: "198.51.100.1", Region: "us-central1", Timestamp: at(1)},
+		{IP: "198.51.100.1", Region: "us-central1", Timestamp: at(3), RateLimited: true},
+		{IP: "203.0.113.2", Region: "us-east-1", Timestamp: at(6), Error: "blocked"},
+		{Timestamp: at(0), Error: "worker unavailable"},
+	}
+
+	stats := Egress(results)
+	expected := []EgressStats{
+		{IP: "198.51.100.1", Region: "us-central1", Attempts: 2, RateLimited: 1, FirstSeen: at(1), LastSeen: at(3)},
+		{IP: "203.0.113.2", Region: "us-east-1", Attempts: 2, Valid: 1, Errors: 1, FirstSeen: at(5), LastSeen: at(6)},
+	}
+	if len(stats) != len(expected) {
+		t.Fatalf("expected %d egress IPs, got %+v", len(expected), stats)
+	}
+	for i := range expected {
+		if stats[i] != expected[i] {
+			t.Errorf("expected %+v, got %+v", expected[i], stats[i])
+		}
+	}
+}
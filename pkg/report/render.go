@@ -0,0 +1,148 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"strings"
+	"text/template"
+	"time"
+)
+
+var funcs = map[string]interface{}{
+	"percent": func(f float64) string {
+		return fmt.Sprintf("%.1f%%", f*100)
+	},
+	"duration": func(d time.Duration) string {
+		if d == 0 {
+			return "-"
+		}
+		return d.Round(time.Second).String()
+	},
+	"date": func(t time.Time) string {
+		return t.Format("2006-01-02 15:04 MST")
+	},
+	"cell": func(s string) string {
+		return strings.NewReplacer("|", "\\|", "\n", " ").Replace(s)
+	},
+	"domain": func(d string) string {
+		if d == "" {
+			return "(none)"
+		}
+		return d
+	},
+}
+
+const markdownTemplate = `# Password Spraying Report
+
+Generated {{ date .GeneratedAt }}.
+
+## Summary
+
+| Accounts | Attempts | Valid | Success Rate | MFA Coverage | Blocked by Policy | Locked | Lockout Rate |
+|---------:|---------:|------:|-------------:|-------------:|------------------:|-------:|-------------:|
+{{ with .Totals }}| {{ .Accounts }} | {{ .Attempts }} | {{ .Valid }} | {{ percent .SuccessRate }} | {{ percent .MFACoverage }} | {{ .Blocked }} | {{ .Locked }} | {{ percent .LockoutRate }} |{{ end }}
+
+## Campaigns
+
+| Campaign | Provider | Start | Accounts | Valid | Success Rate | MFA Coverage | Lockout Rate | Time to First Valid |
+|---------:|----------|-------|---------:|------:|-------------:|-------------:|-------------:|--------------------:|
+{{ range .Campaigns }}| {{ .ID }} | {{ .Provider }} | {{ date .NotBefore }} | {{ .Accounts }} | {{ .Valid }} | {{ percent .SuccessRate }} | {{ percent .MFACoverage }} | {{ percent .LockoutRate }} | {{ duration .TimeToFirstValid }} |
+{{ end }}{{ with .Fingerprinted }}
+## Tenant Features
+
+| Campaign | Provider | Features |
+|---------:|----------|----------|
+{{ range . }}| {{ .ID }} | {{ .Provider }} | {{ cell .Fingerprint.String }} |
+{{ end }}{{ end }}
+## Domains
+
+| Domain | Accounts | Valid | Success Rate | MFA Coverage | Lockout Rate |
+|--------|---------:|------:|-------------:|-------------:|-------------:|
+{{ range .Domains }}| {{ cell (domain .Domain) }} | {{ .Accounts }} | {{ .Valid }} | {{ percent .SuccessRate }} | {{ percent .MFACoverage }} | {{ percent .LockoutRate }} |
+{{ end }}
+## Weakest Passwords
+
+| Password | Valid Accounts | Attempts | Success Rate |
+|----------|---------------:|---------:|-------------:|
+{{ range .WeakPasswords }}| {{ cell .Password }} | {{ .Valid }} | {{ .Attempts }} | {{ percent .SuccessRate }} |
+{{ end }}`
+
+const htmlTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Password Spraying Report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; }
+th { background: #eee; }
+td.n { text-align: right; }
+</style>
+</head>
+<body>
+<h1>Password Spraying Report</h1>
+<p>Generated {{ date .GeneratedAt }}.</p>
+
+<h2>Summary</h2>
+<table>
+<tr><th>Accounts</th><th>Attempts</th><th>Valid</th><th>Success Rate</th><th>MFA Coverage</th><th>Blocked by Policy</th><th>Locked</th><th>Lockout Rate</th></tr>
+{{ with .Totals }}<tr><td class="n">{{ .Accounts }}</td><td class="n">{{ .Attempts }}</td><td class="n">{{ .Valid }}</td><td class="n">{{ percent .SuccessRate }}</td><td class="n">{{ percent .MFACoverage }}</td><td class="n">{{ .Blocked }}</td><td class="n">{{ .Locked }}</td><td class="n">{{ percent .LockoutRate }}</td></tr>{{ end }}
+</table>
+
+<h2>Campaigns</h2>
+<table>
+<tr><th>Campaign</th><th>Provider</th><th>Start</th><th>Accounts</th><th>Valid</th><th>Success Rate</th><th>MFA Coverage</th><th>Lockout Rate</th><th>Time to First Valid</th></tr>
+{{ range .Campaigns }}<tr><td class="n">{{ .ID }}</td><td>{{ .Provider }}</td><td>{{ date .NotBefore }}</td><td class="n">{{ .Accounts }}</td><td class="n">{{ .Valid }}</td><td class="n">{{ percent .SuccessRate }}</td><td class="n">{{ percent .MFACoverage }}</td><td class="n">{{ percent .LockoutRate }}</td><td class="n">{{ duration .TimeToFirstValid }}</td></tr>
+{{ end }}</table>
+{{ with .Fingerprinted }}
+<h2>Tenant Features</h2>
+<table>
+<tr><th>Campaign</th><th>Provider</th><th>Features</th></tr>
+{{ range . }}<tr><td class="n">{{ .ID }}</td><td>{{ .Provider }}</td><td>{{ .Fingerprint.String }}</td></tr>
+{{ end }}</table>
+{{ end }}
+<h2>Domains</h2>
+<table>
+<tr><th>Domain</th><th>Accounts</th><th>Valid</th><th>Success Rate</th><th>MFA Coverage</th><th>Lockout Rate</th></tr>
+{{ range .Domains }}<tr><td>{{ domain .Domain }}</td><td class="n">{{ .Accounts }}</td><td class="n">{{ .Valid }}</td><td class="n">{{ percent .SuccessRate }}</td><td class="n">{{ percent .MFACoverage }}</td><td class="n">{{ percent .LockoutRate }}</td></tr>
+{{ end }}</table>
+
+<h2>Weakest Passwords</h2>
+<table>
+<tr><th>Password</th><th>Valid Accounts</th><th>Attempts</th><th>Success Rate</th></tr>
+{{ range .WeakPasswords }}<tr><td>{{ .Password }}</td><td class="n">{{ .Valid }}</td><td class="n">{{ .Attempts }}</td><td class="n">{{ percent .SuccessRate }}</td></tr>
+{{ end }}</table>
+</body>
+</html>
+`
+
+var (
+	markdown = template.Must(template.New("markdown").Funcs(funcs).Parse(markdownTemplate))
+	html     = htmltemplate.Must(htmltemplate.New("html").Funcs(funcs).Parse(htmlTemplate))
+)
+
+// Markdown renders the report as Markdown.
+func Markdown(w io.Writer, r *Report) error {
+	return markdown.Execute(w, r)
+}
+
+// HTML renders the report as a standalone HTML document.
+func HTML(w io.Writer, r *Report) error {
+	return html.Execute(w, r)
+}
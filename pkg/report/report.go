@@ -0,0 +1,312 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package report aggregates the results of one or more campaigns into
+// statistics suitable for engagement deliverables, and renders them as
+// Markdown or HTML.
+package report
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+)
+
+// TopPasswords is the number of passwords listed as the weakest passwords in
+// rendered reports.
+const TopPasswords = 10
+
+// Stats summarizes the attempts made against a set of accounts. accounts are
+// counted once, regardless of the number of attempts made against them.
+type Stats struct {
+	// Accounts is the number of accounts attempted
+	Accounts int `json:"accounts"`
+
+	// Attempts is the number of completed attempts
+	Attempts int `json:"attempts"`
+
+	// Valid is the number of accounts with a valid password
+	Valid int `json:"valid"`
+
+	// MFA is the number of valid accounts which require MFA
+	MFA int `json:"mfa"`
+
+	// Blocked is the number of valid accounts whose login was blocked by a
+	// policy of the provider (e.g. conditional access)
+	Blocked int `json:"blocked"`
+
+	// Locked is the number of accounts found to be locked
+	Locked int `json:"locked"`
+
+	// SuccessRate is the ratio of accounts with a valid password
+	SuccessRate float64 `json:"success_rate"`
+
+	// MFACoverage is the ratio of valid accounts which require MFA
+	MFACoverage float64 `json:"mfa_coverage"`
+
+	// LockoutRate is the ratio of accounts found to be locked
+	LockoutRate float64 `json:"lockout_rate"`
+}
+
+// DomainStats are the statistics of the accounts of a single domain.
+type DomainStats struct {
+	Domain string `json:"domain"`
+	Stats
+}
+
+// PasswordStats are the statistics of a single password.
+type PasswordStats struct {
+	Password string `json:"password"`
+
+	// Attempts is the number of accounts the password was attempted against
+	Attempts int `json:"attempts"`
+
+	// Valid is the number of accounts the password is valid for
+	Valid int `json:"valid"`
+
+	// SuccessRate is the ratio of attempts which were valid
+	SuccessRate float64 `json:"success_rate"`
+}
+
+// CampaignSummary summarizes a single campaign.
+type CampaignSummary struct {
+	ID        uint      `json:"id"`
+	Provider  string    `json:"provider"`
+	Team      string    `json:"team"`
+	NotBefore time.Time `json:"not_before"`
+	Stats
+
+	// TimeToFirstValid is the time between the first attempt and the first
+	// valid credential. it is zero if no valid credential was found.
+	TimeToFirstValid time.Duration `json:"time_to_first_valid"`
+
+	// Fingerprint is the tenant-level security features detected when the
+	// campaign was created
+	Fingerprint db.Fingerprint `json:"fingerprint"`
+}
+
+// Report aggregates the results of a set of campaigns.
+type Report struct {
+	GeneratedAt time.Time         `json:"generated_at"`
+	Totals      Stats             `json:"totals"`
+	Campaigns   []CampaignSummary `json:"campaigns"`
+	Domains     []DomainStats     `json:"domains"`
+	Passwords   []PasswordStats   `json:"passwords"`
+}
+
+// WeakPasswords returns the passwords which were valid for the most accounts.
+func (r *Report) WeakPasswords() []PasswordStats {
+	var weak []PasswordStats
+	for _, p := range r.Passwords {
+		if p.Valid > 0 && len(weak) < TopPasswords {
+			weak = append(weak, p)
+		}
+	}
+	return weak
+}
+
+// Fingerprinted returns the campaigns whose tenant was fingerprinted.
+func (r *Report) Fingerprinted() []CampaignSummary {
+	var probed []CampaignSummary
+	for _, c := range r.Campaigns {
+		if !c.Fingerprint.ProbedAt.IsZero() {
+			probed = append(probed, c)
+		}
+	}
+	return probed
+}
+
+// account tracks the state of a single account.
+type account struct {
+	valid, mfa, blocked, locked bool
+}
+
+// tally accumulates the accounts and attempts of a Stats.
+type tally struct {
+	accounts map[string]*account
+	attempts int
+}
+
+func newTally() *tally {
+	return &tally{accounts: make(map[string]*account)}
+}
+
+func (t *tally) add(res *db.Result) {
+	a, ok := t.accounts[res.Username]
+	if !ok {
+		a = &account{}
+		t.accounts[res.Username] = a
+	}
+	t.attempts++
+	a.valid = a.valid || res.Valid
+	a.mfa = a.mfa || (res.Valid && res.MFA)
+	a.blocked = a.blocked || (res.Valid && res.PolicyBlocked)
+	a.locked = a.locked || res.Locked
+}
+
+func (t *tally) stats() Stats {
+	s := Stats{Accounts: len(t.accounts), Attempts: t.attempts}
+	for _, a := range t.accounts {
+		if a.valid {
+			s.Valid++
+		}
+		if a.mfa {
+			s.MFA++
+		}
+		if a.blocked {
+			s.Blocked++
+		}
+		if a.locked {
+			s.Locked++
+		}
+	}
+	s.SuccessRate = ratio(s.Valid, s.Accounts)
+	s.MFACoverage = ratio(s.MFA, s.Valid)
+	s.LockoutRate = ratio(s.Locked, s.Accounts)
+	return s
+}
+
+func ratio(n, d int) float64 {
+	if d == 0 {
+		return 0
+	}
+	return float64(n) / float64(d)
+}
+
+// domain returns the domain of a username (e.g. example.org for
+// alice@example.org, or EXAMPLE for EXAMPLE\alice).
+func domain(username string) string {
+	if i := strings.LastIndexByte(username, '@'); i >= 0 {
+		return strings.ToLower(username[i+1:])
+	}
+	if i := strings.IndexByte(username, '\\'); i >= 0 {
+		return strings.ToLower(username[:i])
+	}
+	return ""
+}
+
+// Build aggregates the results of the campaigns. results of tasks which
+// failed with an error are ignored.
+func Build(campaigns []db.Campaign, results []db.Result) *Report {
+	totals := newTally()
+	byCampaign := make(map[uint]*tally)
+	byDomain := make(map[string]*tally)
+	first := make(map[uint]time.Time)
+	firstValid := make(map[uint]time.Time)
+
+	type passwordTally struct {
+		attempts, valid map[string]bool
+	}
+	byPassword := make(map[string]*passwordTally)
+
+	for i := range results {
+		res := &results[i]
+		if res.Error != "" {
+			continue
+		}
+
+		totals.add(res)
+		if byCampaign[res.CampaignID] == nil {
+			byCampaign[res.CampaignID] = newTally()
+		}
+		byCampaign[res.CampaignID].add(res)
+		d := domain(res.Username)
+		if byDomain[d] == nil {
+			byDomain[d] = newTally()
+		}
+		byDomain[d].add(res)
+
+		if t, ok := first[res.CampaignID]; !ok || res.Timestamp.Before(t) {
+			first[res.CampaignID] = res.Timestamp
+		}
+		if t, ok := firstValid[res.CampaignID]; res.Valid && (!ok || res.Timestamp.Before(t)) {
+			firstValid[res.CampaignID] = res.Timestamp
+		}
+
+		// passwords are omitted for users who may not read them
+		if res.Password == "" {
+			continue
+		}
+		p := byPassword[res.Password]
+		if p == nil {
+			p = &passwordTally{attempts: make(map[string]bool), valid: make(map[string]bool)}
+			byPassword[res.Password] = p
+		}
+		p.attempts[res.Username] = true
+		if res.Valid {
+			p.valid[res.Username] = true
+		}
+	}
+
+	r := &Report{
+		GeneratedAt: time.Now(),
+		Totals:      totals.stats(),
+		Campaigns:   make([]CampaignSummary, 0, len(campaigns)),
+		Domains:     make([]DomainStats, 0, len(byDomain)),
+		Passwords:   make([]PasswordStats, 0, len(byPassword)),
+	}
+
+	for _, c := range campaigns {
+		t := byCampaign[c.ID]
+		if t == nil {
+			t = newTally()
+		}
+		s := CampaignSummary{
+			ID:          c.ID,
+			Provider:    c.Provider,
+			Team:        c.Team,
+			NotBefore:   c.NotBefore,
+			Stats:       t.stats(),
+			Fingerprint: c.Fingerprint,
+		}
+		if v, ok := firstValid[c.ID]; ok {
+			s.TimeToFirstValid = v.Sub(first[c.ID])
+		}
+		r.Campaigns = append(r.Campaigns, s)
+	}
+	sort.Slice(r.Campaigns, func(i, j int) bool { return r.Campaigns[i].ID < r.Campaigns[j].ID })
+
+	for d, t := range byDomain {
+		r.Domains = append(r.Domains, DomainStats{Domain: d, Stats: t.stats()})
+	}
+	sort.Slice(r.Domains, func(i, j int) bool {
+		if r.Domains[i].Accounts != r.Domains[j].Accounts {
+			return r.Domains[i].Accounts > r.Domains[j].Accounts
+		}
+		return r.Domains[i].Domain < r.Domains[j].Domain
+	})
+
+	for password, p := range byPassword {
+		r.Passwords = append(r.Passwords, PasswordStats{
+			Password:    password,
+			Attempts:    len(p.attempts),
+			Valid:       len(p.valid),
+			SuccessRate: ratio(len(p.valid), len(p.attempts)),
+		})
+	}
+	sort.Slice(r.Passwords, func(i, j int) bool {
+		a, b := r.Passwords[i], r.Passwords[j]
+		if a.Valid != b.Valid {
+			return a.Valid > b.Valid
+		}
+		if a.SuccessRate != b.SuccessRate {
+			return a.SuccessRate > b.SuccessRate
+		}
+		return a.Password < b.Password
+	})
+
+	return r
+}
@@ -0,0 +1,112 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+)
+
+func TestBuild(t *testing.T) {
+	start := time.Date(2020, 10, 1, 9, 0, 0, 0, time.UTC)
+	campaigns := []db.Campaign{
+		{Model: db.Model{ID: 2}, Provider: "o365"},
+		{Model: db.Model{ID: 1}, Provider: "okta", NotBefore: start},
+	}
+	result := func(campaign uint, minutes int, username, password string, valid, mfa, locked bool) db.Result {
+		return db.Result{
+			CampaignID: campaign, Timestamp: start.Add(time.Duration(minutes) * time.Minute),
+			Username: username, Password: password, Valid: valid, MFA: mfa, Locked: locked,
+		}
+	}
+	results := []db.Result{
+		result(1, 0, "alice@example.org", "Winter2020!", false, false, false),
+		result(1, 1, "bob@example.org", "Winter2020!", true, true, false),
+		result(1, 2, "carol@example.org", "Winter2020!", false, false, true),
+		result(1, 10, "alice@example.org", "Password1", true, false, false),
+		result(1, 11, "dave@EXAMPLE.com", "Password1", true, false, false),
+		{CampaignID: 1, Username: "eve@example.org", Error: "timeout"},
+	}
+
+	r := Build(campaigns, results)
+
+	totals := Stats{Accounts: 4, Attempts: 5, Valid: 3, MFA: 1, Locked: 1,
+		SuccessRate: 0.75, MFACoverage: 1.0 / 3, LockoutRate: 0.25}
+	if r.Totals != totals {
+		t.Errorf("expected totals %+v, got %+v", totals, r.Totals)
+	}
+
+	if len(r.Campaigns) != 2 || r.Campaigns[0].ID != 1 || r.Campaigns[1].ID != 2 {
+		t.Fatalf("unexpected campaigns %+v", r.Campaigns)
+	}
+	if r.Campaigns[0].TimeToFirstValid != time.Minute {
+		t.Errorf("expected time to first valid of 1m, got %s", r.Campaigns[0].TimeToFirstValid)
+	}
+	if r.Campaigns[1].Accounts != 0 || r.Campaigns[1].TimeToFirstValid != 0 {
+		t.Errorf("expected an empty campaign, got %+v", r.Campaigns[1])
+	}
+
+	if len(r.Domains) != 2 || r.Domains[0].Domain != "example.org" || r.Domains[0].Accounts != 3 ||
+		r.Domains[1].Domain != "example.com" || r.Domains[1].Valid != 1 {
+		t.Errorf("unexpected domains %+v", r.Domains)
+	}
+
+	if len(r.Passwords) != 2 || r.Passwords[0].Password != "Password1" || r.Passwords[0].SuccessRate != 1 ||
+		r.Passwords[1].Password != "Winter2020!" || r.Passwords[1].Valid != 1 || r.Passwords[1].Attempts != 3 {
+		t.Errorf("unexpected passwords %+v", r.Passwords)
+	}
+}
+
+func TestRender(t *testing.T) {
+	r := Build([]db.Campaign{{Model: db.Model{ID: 1}, Provider: "okta"}, {
+		Model:    db.Model{ID: 2},
+		Provider: "okta",
+		Fingerprint: db.Fingerprint{
+			ProbedAt: time.Date(2020, 10, 1, 9, 0, 0, 0, time.UTC),
+			Features: map[string]string{"org_id": "00o1", "engine": "classic"},
+		},
+	}}, []db.Result{
+		{CampaignID: 1, Username: "alice@example.org", Password: "<b>|Pass", Valid: true},
+	})
+
+	var buf bytes.Buffer
+	err := Markdown(&buf, r)
+	if err != nil {
+		t.Fatalf("error rendering markdown: %s", err)
+	}
+	if !strings.Contains(buf.String(), `| <b>\|Pass | 1 | 1 | 100.0% |`) {
+		t.Errorf("markdown is missing the weakest password:\n%s", buf.String())
+	}
+	if !strings.Contains(buf.String(), "| 2 | okta | engine: classic, org_id: 00o1 |") ||
+		strings.Contains(buf.String(), "| 1 | okta | none |") {
+		t.Errorf("markdown is missing the tenant features:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	err = HTML(&buf, r)
+	if err != nil {
+		t.Fatalf("error rendering html: %s", err)
+	}
+	if !strings.Contains(buf.String(), "<td>&lt;b&gt;|Pass</td>") {
+		t.Errorf("html is missing the escaped weakest password:\n%s", buf.String())
+	}
+	if !strings.Contains(buf.String(), "<td>engine: classic, org_id: 00o1</td>") {
+		t.Errorf("html is missing the tenant features:\n%s", buf.String())
+	}
+}
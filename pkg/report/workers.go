@@ -0,0 +1,63 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"time"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+)
+
+const (
+	// WorkerHealthy workers recently completed most of their attempts
+	WorkerHealthy = "healthy"
+
+	// WorkerDegraded workers recently failed or were rate limited on most of
+	// their attempts
+	WorkerDegraded = "degraded"
+
+	// WorkerIdle workers have not made an attempt for WorkerIdleAfter
+	WorkerIdle = "idle"
+
+	// WorkerIdleAfter is the time after its last attempt a worker is idle
+	WorkerIdleAfter = 5 * time.Minute
+)
+
+// WorkerHealth summarizes the recent attempts of a worker, identified by its
+// egress IP.
+type WorkerHealth struct {
+	EgressStats
+
+	// Status is WorkerHealthy, WorkerDegraded or WorkerIdle
+	Status string `json:"status"`
+}
+
+// Workers returns the health of each worker which made one of the results,
+// ordered by first use.
+func Workers(results []db.Result, now time.Time) []WorkerHealth {
+	stats := Egress(results)
+	workers := make([]WorkerHealth, len(stats))
+	for i, e := range stats {
+		status := WorkerHealthy
+		switch {
+		case now.Sub(e.LastSeen) > WorkerIdleAfter:
+			status = WorkerIdle
+		case 2*(e.Errors+e.RateLimited) > e.Attempts:
+			status = WorkerDegraded
+		}
+		workers[i] = WorkerHealth{EgressStats: e, Status: status}
+	}
+	return workers
+}
@@ -0,0 +1,52 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"testing"
+	"time"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+)
+
+func TestWorkers(t *testing.T) {
+	now := time.Date(2020, 10, 1, 9, 0, 0, 0, time.UTC)
+	ago := func(minutes int) time.Time {
+		return now.Add(-time.Duration(minutes) * time.Minute)
+	}
+	results := []db.Result{
+		{IP: "198.51.100.1", Timestamp: ago(1)},
+		{IP: "198.51.100.1", Timestamp: ago(2), Error: "timeout"},
+		{IP: "198.51.100.2", Timestamp: ago(1), RateLimited: true},
+		{IP: "198.51.100.2", Timestamp: ago(3), Error: "timeout"},
+		{IP: "198.51.100.2", Timestamp: ago(4)},
+		{IP: "198.51.100.3", Timestamp: ago(10)},
+	}
+
+	expected := map[string]string{
+		"198.51.100.1": WorkerHealthy,
+		"198.51.100.2": WorkerDegraded,
+		"198.51.100.3": WorkerIdle,
+	}
+	workers := Workers(results, now)
+	if len(workers) != len(expected) {
+		t.Fatalf("expected %d workers, got %+v", len(expected), workers)
+	}
+	for _, w := range workers {
+		if w.Status != expected[w.IP] {
+			t.Errorf("expected %s to be %s, got %s", w.IP, expected[w.IP], w.Status)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package retention enforces the retention period of engagement data.
+// campaigns which ended more than a retention period ago are archived to an
+// archive.Store, as JSONL whose every line is sealed with a kms.Envelope, and
+// are then purged from the database along with their results, failed tasks
+// and task claims.
+package retention
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/praetorian-inc/trident/pkg/archive"
+	"github.com/praetorian-inc/trident/pkg/db"
+	"github.com/praetorian-inc/trident/pkg/kms"
+)
+
+// pageSize is the number of results read from the database at once.
+const pageSize = 1000
+
+// maxLine bounds the length of a line read from an archive.
+const maxLine = 16 << 20
+
+// record types of archive lines.
+const (
+	TypeCampaign   = "campaign"
+	TypeResult     = "result"
+	TypeFailedTask = "failed_task"
+	TypeRedaction  = "redaction"
+)
+
+// Datastore is the interface that wraps the database operations used to
+// archive and purge campaigns.
+type Datastore interface {
+	SelectExpiredCampaigns(before time.Time) ([]db.Campaign, error)
+	SelectResultsAfter(campaignID, afterID uint, limit int) ([]db.Result, error)
+	SelectFailedTasks(db.Query) ([]db.FailedTask, error)
+	SelectCampaignRedactions(campaignID uint) ([]db.Redaction, error)
+	PurgeCampaign(campaignID uint) error
+}
+
+// Record is a line of an archive: the campaign itself, one of its results, one
+// of its failed tasks or one of its redactions.
+type Record struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Report describes an archived (or purged) campaign.
+type Report struct {
+	CampaignID  uint
+	Object      string
+	Results     int
+	FailedTasks int
+}
+
+// Archiver archives and purges campaigns.
+type Archiver struct {
+	// DB is the datastore campaigns are read from and purged from
+	DB Datastore
+
+	// Store receives archived campaigns. if nil, campaigns are purged without
+	// being archived.
+	Store archive.Store
+
+	// Envelope seals each line of an archive. it is required if Store is set.
+	Envelope *kms.Envelope
+
+	// Lock serializes Enforce across processes. if nil, Enforce always runs.
+	Lock Locker
+}
+
+// LockName is the name of the lock held while retention is enforced.
+const LockName = "retention"
+
+// Locker acquires locks shared by every orchestrator.
+type Locker interface {
+	// TryLock acquires the named lock for ttl. it returns false if the lock
+	// is already held.
+	TryLock(name string, ttl time.Duration) (bool, error)
+}
+
+// Expired returns the campaigns which ended more than maxAge ago.
+func (a *Archiver) Expired(maxAge time.Duration) ([]db.Campaign, error) {
+	return a.DB.SelectExpiredCampaigns(time.Now().Add(-maxAge))
+}
+
+// Archive writes the campaign to the archive store, if any, then purges it
+// from the database. the campaign is only purged once its archive has been
+// written successfully.
+func (a *Archiver) Archive(ctx context.Context, c db.Campaign) (Report, error) {
+	report := Report{CampaignID: c.ID}
+	if a.Store != nil {
+		if a.Envelope == nil {
+			return report, fmt.Errorf("archiving campaigns requires a key manager")
+		}
+		report.Object = fmt.Sprintf("campaign-%d-%s.jsonl", c.ID, time.Now().UTC().Format("20060102T150405Z"))
+		err := a.write(ctx, c, &report)
+		if err != nil {
+			return report, fmt.Errorf("error archiving campaign %d: %w", c.ID, err)
+		}
+	}
+
+	err := a.DB.PurgeCampaign(c.ID)
+	if err != nil {
+		return report, fmt.Errorf("error purging campaign %d: %w", c.ID, err)
+	}
+	return report, nil
+}
+
+// write seals the records of the campaign to a temporary file which is then
+// uploaded to the archive store.
+func (a *Archiver) write(ctx context.Context, c db.Campaign, report *Report) error {
+	f, err := os.CreateTemp("", "trident-archive-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // nolint:errcheck
+	defer f.Close()           // nolint:errcheck
+
+	w := bufio.NewWriter(f)
+	put := func(typ string, v interface{}) error {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		line, err := json.Marshal(Record{Type: typ, Data: data})
+		if err != nil {
+			return err
+		}
+		sealed, err := a.Envelope.Seal(ctx, string(line))
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, sealed)
+		return err
+	}
+
+	err = put(TypeCampaign, c)
+	if err != nil {
+		return err
+	}
+
+	var after uint
+	for {
+		results, err := a.DB.SelectResultsAfter(c.ID, after, pageSize)
+		if err != nil {
+			return err
+		}
+		for i := range results {
+			err = put(TypeResult, &results[i])
+			if err != nil {
+				return err
+			}
+		}
+		report.Results += len(results)
+		if len(results) < pageSize {
+			break
+		}
+		after = results[len(results)-1].ID
+	}
+
+	tasks, err := a.DB.SelectFailedTasks(db.Query{Filter: map[string]interface{}{"campaign_id": c.ID}})
+	if err != nil {
+		return err
+	}
+	for i := range tasks {
+		err = put(TypeFailedTask, &tasks[i])
+		if err != nil {
+			return err
+		}
+	}
+	report.FailedTasks = len(tasks)
+
+	// the values replaced by the tokens of redacted results remain sealed
+	redactions, err := a.DB.SelectCampaignRedactions(c.ID)
+	if err != nil {
+		return err
+	}
+	for i := range redactions {
+		err = put(TypeRedaction, &redactions[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	return a.Store.Put(ctx, report.Object, f, size)
+}
+
+// Enforce periodically archives and purges every campaign which ended more
+// than maxAge ago. If the archiver has a Lock, each run first acquires it for
+// interval and is skipped if another process holds it, so that concurrent
+// orchestrators do not archive the same campaigns. This function blocks until
+// the context is cancelled.
+func (a *Archiver) Enforce(ctx context.Context, maxAge, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if a.lock(interval) {
+			a.enforce(ctx, maxAge)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// lock returns true if this process may enforce retention for ttl.
+func (a *Archiver) lock(ttl time.Duration) bool {
+	if a.Lock == nil {
+		return true
+	}
+	ok, err := a.Lock.TryLock(LockName, ttl)
+	if err != nil {
+		log.Errorf("error acquiring retention lock: %s", err)
+		return false
+	}
+	return ok
+}
+
+// enforce archives and purges every campaign which ended more than maxAge ago.
+func (a *Archiver) enforce(ctx context.Context, maxAge time.Duration) {
+	campaigns, err := a.Expired(maxAge)
+	if err != nil {
+		log.Errorf("error selecting expired campaigns: %s", err)
+	}
+	for _, c := range campaigns {
+		report, err := a.Archive(ctx, c)
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		log.WithFields(log.Fields{
+			"campaign":     report.CampaignID,
+			"object":       report.Object,
+			"results":      report.Results,
+			"failed_tasks": report.FailedTasks,
+		}).Info("campaign past its retention period purged")
+	}
+}
+
+// Read unseals the lines of an archive, calling fn with each record.
+func Read(ctx context.Context, r io.Reader, envelope *kms.Envelope, fn func(Record) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxLine)
+	for scanner.Scan() {
+		line, err := envelope.Unseal(ctx, scanner.Text())
+		if err != nil {
+			return err
+		}
+		var rec Record
+		err = json.Unmarshal([]byte(line), &rec)
+		if err != nil {
+			return err
+		}
+		err = fn(rec)
+		if err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
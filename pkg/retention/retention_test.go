@@ -0,0 +1,229 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retention
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/praetorian-inc/trident/pkg/archive"
+	"github.com/praetorian-inc/trident/pkg/db"
+	"github.com/praetorian-inc/trident/pkg/kms"
+	"github.com/praetorian-inc/trident/pkg/kms/local"
+
+	_ "github.com/praetorian-inc/trident/pkg/archive/file"
+)
+
+type testDB struct {
+	campaigns  []db.Campaign
+	results    []db.Result
+	tasks      []db.FailedTask
+	redactions []db.Redaction
+	purged     []uint
+}
+
+func (d *testDB) SelectExpiredCampaigns(before time.Time) ([]db.Campaign, error) {
+	var expired []db.Campaign
+	for _, c := range d.campaigns {
+		if c.NotAfter.Before(before) {
+			expired = append(expired, c)
+		}
+	}
+	return expired, nil
+}
+
+func (d *testDB) SelectResultsAfter(campaignID, afterID uint, limit int) ([]db.Result, error) {
+	var results []db.Result
+	for _, r := range d.results {
+		if r.CampaignID == campaignID && r.ID > afterID && len(results) < limit {
+			results = append(results, r)
+		}
+	}
+	return results, nil
+}
+
+func (d *testDB) SelectFailedTasks(q db.Query) ([]db.FailedTask, error) {
+	var tasks []db.FailedTask
+	for _, t := range d.tasks {
+		if t.CampaignID == q.Filter["campaign_id"] {
+			tasks = append(tasks, t)
+		}
+	}
+	return tasks, nil
+}
+
+func (d *testDB) SelectCampaignRedactions(campaignID uint) ([]db.Redaction, error) {
+	var redactions []db.Redaction
+	for _, r := range d.redactions {
+		if r.CampaignID == campaignID {
+			redactions = append(redactions, r)
+		}
+	}
+	return redactions, nil
+}
+
+func (d *testDB) PurgeCampaign(campaignID uint) error {
+	d.purged = append(d.purged, campaignID)
+	return nil
+}
+
+type failingStore struct{}
+
+func (failingStore) Put(ctx context.Context, name string, r io.Reader, size int64) error {
+	return errors.New("bucket not found")
+}
+
+func TestArchive(t *testing.T) {
+	ctx := context.Background()
+	keys, err := local.Driver{}.New(map[string]string{
+		"key": "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=",
+	})
+	if err != nil {
+		t.Fatalf("error creating key manager: %s", err)
+	}
+	envelope := kms.NewEnvelope(keys)
+
+	dir, err := ioutil.TempDir("", "retention")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint:errcheck
+	store, err := archive.Open("file", map[string]string{"dir": dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	d := &testDB{
+		campaigns: []db.Campaign{
+			{Model: db.Model{ID: 1}, NotAfter: now.Add(-100 * 24 * time.Hour)},
+			{Model: db.Model{ID: 2}, NotAfter: now.Add(-time.Hour)},
+		},
+		tasks:      []db.FailedTask{{Model: db.Model{ID: 1}, CampaignID: 1, Username: "failed"}},
+		redactions: []db.Redaction{{Token: "tok:v1:00", CampaignID: 1, Kind: "password", Value: "enc:v1:00"}},
+	}
+	for i := 1; i <= pageSize+500; i++ {
+		d.results = append(d.results, db.Result{Model: db.Model{ID: uint(i)}, CampaignID: 1, Username: "user"})
+	}
+	d.results = append(d.results, db.Result{Model: db.Model{ID: 9999}, CampaignID: 2})
+
+	a := &Archiver{DB: d, Store: store, Envelope: envelope}
+	expired, err := a.Expired(90 * 24 * time.Hour)
+	if err != nil || len(expired) != 1 || expired[0].ID != 1 {
+		t.Fatalf("unexpected expired campaigns %v (%v)", expired, err)
+	}
+
+	report, err := a.Archive(ctx, expired[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Results != pageSize+500 || report.FailedTasks != 1 || !strings.HasPrefix(report.Object, "campaign-1-") {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if len(d.purged) != 1 || d.purged[0] != 1 {
+		t.Errorf("unexpected purged campaigns %v", d.purged)
+	}
+
+	f, err := os.Open(filepath.Join(dir, report.Object))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close() // nolint:errcheck
+
+	counts := make(map[string]int)
+	err = Read(ctx, f, envelope, func(rec Record) error {
+		counts[rec.Type]++
+		if rec.Type == TypeResult {
+			var res db.Result
+			err := json.Unmarshal(rec.Data, &res)
+			if err != nil || res.Username != "user" {
+				t.Errorf("unexpected result %s (%v)", rec.Data, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts[TypeCampaign] != 1 || counts[TypeResult] != pageSize+500 || counts[TypeFailedTask] != 1 ||
+		counts[TypeRedaction] != 1 {
+		t.Errorf("unexpected records %v", counts)
+	}
+
+	// the campaign is kept if its archive could not be written
+	d.purged = nil
+	a.Store = failingStore{}
+	_, err = a.Archive(ctx, expired[0])
+	if err == nil || len(d.purged) != 0 {
+		t.Errorf("expected the campaign to be kept (%v)", err)
+	}
+
+	// an archive store requires a key manager
+	a.Envelope = nil
+	_, err = a.Archive(ctx, expired[0])
+	if err == nil || len(d.purged) != 0 {
+		t.Errorf("expected an error without a key manager (%v)", err)
+	}
+
+	// campaigns are purged without being archived without an archive store
+	a.Store = nil
+	report, err = a.Archive(ctx, expired[0])
+	if err != nil || report.Object != "" || len(d.purged) != 1 {
+		t.Errorf("unexpected purge %+v (%v)", report, err)
+	}
+}
+
+type testLock struct {
+	held  bool
+	names []string
+}
+
+func (l *testLock) TryLock(name string, ttl time.Duration) (bool, error) {
+	l.names = append(l.names, name)
+	if l.held {
+		return false, nil
+	}
+	l.held = true
+	return true, nil
+}
+
+func TestEnforceLock(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	d := &testDB{campaigns: []db.Campaign{{Model: db.Model{ID: 1}, NotAfter: time.Now().Add(-48 * time.Hour)}}}
+	lock := &testLock{held: true}
+	a := &Archiver{DB: d, Lock: lock}
+
+	// another orchestrator holds the lock
+	a.Enforce(ctx, 24*time.Hour, time.Hour)
+	if len(d.purged) != 0 || len(lock.names) != 1 || lock.names[0] != LockName {
+		t.Errorf("expected retention to be skipped, purged %v (locks %v)", d.purged, lock.names)
+	}
+
+	lock.held = false
+	a.Enforce(ctx, 24*time.Hour, time.Hour)
+	if len(d.purged) != 1 {
+		t.Errorf("expected the expired campaign to be purged, purged %v", d.purged)
+	}
+}
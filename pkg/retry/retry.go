@@ -0,0 +1,215 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package retry classifies task errors as transient or permanent and computes
+// the exponential backoff used to retry transient failures. Nozzles and
+// workers annotate errors with a Class via New or Errorf. Errors without an
+// explicit class are classified by inspecting the underlying error.
+package retry
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Class categorizes the cause of a task failure.
+type Class string
+
+const (
+	// ClassTimeout indicates a network timeout
+	ClassTimeout Class = "timeout"
+
+	// ClassNetwork indicates a network error other than a timeout (e.g. a
+	// connection reset)
+	ClassNetwork Class = "network"
+
+	// ClassServer indicates the provider returned an HTTP 5xx
+	ClassServer Class = "server_error"
+
+	// ClassRateLimit indicates the provider (or worker) is rate limiting
+	ClassRateLimit Class = "rate_limit"
+
+	// ClassParse indicates the provider's response could not be parsed. the
+	// login was attempted, so retrying would attempt it again
+	ClassParse Class = "parse_error"
+
+	// ClassConfig indicates the task is misconfigured (e.g. an unknown
+	// nozzle, an invalid tenant or an authentication failure to the worker)
+	ClassConfig Class = "config"
+
+	// ClassNotFound indicates the provider endpoint does not exist
+	ClassNotFound Class = "not_found"
+
+	// ClassDenied indicates the worker refused to attempt an excluded or
+	// protected account
+	ClassDenied Class = "denied"
+
+	// ClassUnknown is used for errors which could not be classified
+	ClassUnknown Class = "unknown"
+)
+
+// Transient returns true if a task failing with this class may succeed when
+// retried. parse and unknown errors are permanent, as the provider may have
+// already received (and counted) the login attempt.
+func (c Class) Transient() bool {
+	switch c {
+	case ClassConfig, ClassNotFound, ClassDenied, ClassParse, ClassUnknown:
+		return false
+	}
+	return true
+}
+
+// Error annotates an error with its Class.
+type Error struct {
+	Class Class
+	Err   error
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// New annotates err with the provided class.
+func New(class Class, err error) error {
+	return &Error{Class: class, Err: err}
+}
+
+// Errorf formats an error annotated with the provided class.
+func Errorf(class Class, format string, a ...interface{}) error {
+	return &Error{Class: class, Err: fmt.Errorf(format, a...)}
+}
+
+// ClassifyStatus classifies an unexpected HTTP status code.
+func ClassifyStatus(code int) Class {
+	switch {
+	case code == http.StatusTooManyRequests:
+		return ClassRateLimit
+	case code == http.StatusNotFound:
+		return ClassNotFound
+	case code == http.StatusRequestTimeout, code == http.StatusGatewayTimeout:
+		return ClassTimeout
+	case code >= 500:
+		return ClassServer
+	case code >= 400:
+		return ClassConfig
+	}
+	return ClassUnknown
+}
+
+// Classify returns the Class of an error. annotated errors keep their class,
+// otherwise network and decoding errors are recognized.
+func Classify(err error) Class {
+	if err == nil {
+		return ""
+	}
+
+	var re *Error
+	if errors.As(err, &re) {
+		return re.Class
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ClassTimeout
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && !dnsErr.Temporary() && !dnsErr.Timeout() {
+		// the provider's hostname does not resolve
+		return ClassConfig
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		if netErr.Timeout() {
+			return ClassTimeout
+		}
+		return ClassNetwork
+	}
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var xmlErr *xml.SyntaxError
+	if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) || errors.As(err, &xmlErr) ||
+		errors.Is(err, io.ErrUnexpectedEOF) {
+		return ClassParse
+	}
+
+	return ClassUnknown
+}
+
+// Policy configures how transient failures are retried.
+type Policy struct {
+	// MaxRetries is the maximum number of times a task is retried
+	MaxRetries int
+
+	// Base is the delay before the first retry. the delay doubles with each
+	// subsequent attempt.
+	Base time.Duration
+
+	// Max caps the delay between attempts
+	Max time.Duration
+
+	// Jitter randomizes each delay by up to this fraction (e.g. 0.2) to
+	// avoid retrying many tasks at once
+	Jitter float64
+}
+
+// DefaultPolicy is used when no policy is configured.
+var DefaultPolicy = Policy{
+	MaxRetries: 3,
+	Base:       30 * time.Second,
+	Max:        30 * time.Minute,
+	Jitter:     0.2,
+}
+
+// Retry returns true if a task which has already been retried attempt times
+// should be retried after failing with the provided class.
+func (p Policy) Retry(class Class, attempt int) bool {
+	return class.Transient() && attempt < p.MaxRetries
+}
+
+// Backoff returns the delay before retrying a task which has already been
+// retried attempt times. rate limited tasks back off four times as long.
+func (p Policy) Backoff(class Class, attempt int) time.Duration {
+	if attempt > 30 {
+		attempt = 30
+	}
+
+	d := p.Base << uint(attempt)
+	if class == ClassRateLimit {
+		d *= 4
+	}
+	if d > p.Max || d <= 0 {
+		d = p.Max
+	}
+
+	if p.Jitter > 0 {
+		d += time.Duration(p.Jitter * rand.Float64() * float64(d)) // nolint:gosec
+	}
+	return d
+}
@@ -0,0 +1,119 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestClassify(t *testing.T) {
+	type testcase struct {
+		err      error
+		expected Class
+	}
+
+	var syntaxErr error = json.Unmarshal([]byte("{"), &struct{}{})
+
+	testcases := []testcase{
+		{err: Errorf(ClassConfig, "unknown nozzle"), expected: ClassConfig},
+		{err: fmt.Errorf("wrapped: %w", New(ClassRateLimit, errors.New("slow down"))), expected: ClassRateLimit},
+		{err: context.DeadlineExceeded, expected: ClassTimeout},
+		{err: &net.DNSError{Err: "no such host", Name: "example.invalid"}, expected: ClassConfig},
+		{err: &net.DNSError{Err: "i/o timeout", IsTimeout: true}, expected: ClassTimeout},
+		{err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}, expected: ClassNetwork},
+		{err: syntaxErr, expected: ClassParse},
+		{err: errors.New("something else"), expected: ClassUnknown},
+	}
+
+	for _, test := range testcases {
+		actual := Classify(test.err)
+		if actual != test.expected {
+			t.Errorf("%v: expected %s, got %s", test.err, test.expected, actual)
+		}
+	}
+}
+
+func TestClassifyStatus(t *testing.T) {
+	type testcase struct {
+		code      int
+		expected  Class
+		transient bool
+	}
+
+	testcases := []testcase{
+		{code: 429, expected: ClassRateLimit, transient: true},
+		{code: 503, expected: ClassServer, transient: true},
+		{code: 504, expected: ClassTimeout, transient: true},
+		{code: 404, expected: ClassNotFound, transient: false},
+		{code: 403, expected: ClassConfig, transient: false},
+	}
+
+	for _, test := range testcases {
+		actual := ClassifyStatus(test.code)
+		if actual != test.expected {
+			t.Errorf("%d: expected %s, got %s", test.code, test.expected, actual)
+		}
+		if actual.Transient() != test.transient {
+			t.Errorf("%d: expected transient=%t", test.code, test.transient)
+		}
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	p := Policy{MaxRetries: 2, Base: time.Second, Max: 10 * time.Second}
+
+	if !p.Retry(ClassServer, 1) {
+		t.Errorf("expected server error to be retried")
+	}
+	if p.Retry(ClassServer, 2) {
+		t.Errorf("expected retries to be exhausted")
+	}
+	if p.Retry(ClassNotFound, 0) {
+		t.Errorf("expected permanent error not to be retried")
+	}
+	if p.Retry(ClassDenied, 0) {
+		t.Errorf("expected denied task not to be retried")
+	}
+	if p.Retry(ClassParse, 0) || p.Retry(ClassUnknown, 0) {
+		t.Errorf("expected attempted logins with unexpected responses not to be retried")
+	}
+
+	type testcase struct {
+		class    Class
+		attempt  int
+		expected time.Duration
+	}
+
+	testcases := []testcase{
+		{class: ClassServer, attempt: 0, expected: time.Second},
+		{class: ClassServer, attempt: 2, expected: 4 * time.Second},
+		{class: ClassServer, attempt: 10, expected: 10 * time.Second},
+		{class: ClassRateLimit, attempt: 1, expected: 8 * time.Second},
+		{class: ClassServer, attempt: 100, expected: 10 * time.Second},
+	}
+
+	for _, test := range testcases {
+		actual := p.Backoff(test.class, test.attempt)
+		if actual != test.expected {
+			t.Errorf("%s attempt %d: expected %s, got %s", test.class, test.attempt, test.expected, actual)
+		}
+	}
+}
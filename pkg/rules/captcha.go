@@ -0,0 +1,54 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rules
+
+import (
+	"bytes"
+
+	"github.com/praetorian-inc/trident/pkg/event"
+)
+
+// challenges maps CAPTCHA services to markers of their widget in a response
+// body. markers are matched case sensitively, as they appear in the scripts
+// and class names embedded by each service.
+var challenges = []struct {
+	name    string
+	markers [][]byte
+}{
+	{"recaptcha", [][]byte{[]byte("google.com/recaptcha"), []byte("g-recaptcha"), []byte("grecaptcha")}},
+	{"hcaptcha", [][]byte{[]byte("hcaptcha.com/1/api.js"), []byte("h-captcha")}},
+	{"turnstile", [][]byte{[]byte("challenges.cloudflare.com/turnstile"), []byte("cf-turnstile")}},
+	{"arkose", [][]byte{[]byte("arkoselabs.com"), []byte("funcaptcha")}},
+}
+
+// DetectCaptcha returns a CAPTCHA outcome if the response challenges the
+// client with one of the known CAPTCHA services. the name of the service is
+// recorded in the "challenge" metadata. nozzles call it after Classify, since
+// a challenged attempt says nothing about the validity of the credential.
+func DetectCaptcha(resp *Response) (*event.AuthResponse, bool) {
+	for _, c := range challenges {
+		for _, marker := range c.markers {
+			if bytes.Contains(resp.Body, marker) {
+				return &event.AuthResponse{
+					Captcha: true,
+					Metadata: map[string]interface{}{
+						"challenge": c.name,
+					},
+				}, true
+			}
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,401 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package rules implements a response classification engine driven by
+// YAML-defined rules. Nozzles pass provider responses to Classify before
+// applying their built-in classification, which lets operators fix the
+// classification of a changed provider response without a new release.
+//
+//	rules:
+//	  - name: okta-password-expired
+//	    provider: okta
+//	    match:
+//	      status: [200]
+//	      json:
+//	        status: PASSWORD_EXPIRED
+//	    result:
+//	      valid: true
+//	  - name: o365-smart-lockout
+//	    provider: o365
+//	    match:
+//	      body: AADSTS50053
+//	    result:
+//	      locked: true
+//
+// Headers, body and json matchers are regular expressions. json matchers are
+// keyed by a dotted path into the response body (e.g. _embedded.user.id).
+// The first matching rule wins.
+package rules
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+
+	"github.com/praetorian-inc/trident/pkg/event"
+	"github.com/praetorian-inc/trident/pkg/retry"
+)
+
+// MaxBodySize is the maximum number of bytes of a response body which are
+// matched against rules.
+const MaxBodySize = 1 << 20
+
+// MaxCaptureSize is the maximum number of bytes of a response body which are
+// kept by Encode.
+const MaxCaptureSize = 64 << 10
+
+// Response is a provider response to classify.
+type Response struct {
+	StatusCode int         `json:"status"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body"`
+}
+
+// NewResponse reads the body of an HTTP response. the caller remains
+// responsible for closing the body.
+func NewResponse(resp *http.Response) (*Response, error) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, resp.Body, MaxBodySize))
+	if err != nil {
+		return nil, err
+	}
+	return &Response{
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Body:       body,
+	}, nil
+}
+
+// Encode encodes the response for the Response field of an AuthResponse, so
+// that the attempt can be re-classified later without repeating it. bodies
+// are truncated to MaxCaptureSize.
+func (r *Response) Encode() string {
+	c := *r
+	if len(c.Body) > MaxCaptureSize {
+		c.Body = c.Body[:MaxCaptureSize]
+	}
+	data, _ := json.Marshal(&c)
+	return string(data)
+}
+
+// DecodeResponse decodes a response encoded by Encode.
+func DecodeResponse(data string) (*Response, error) {
+	var r Response
+	err := json.Unmarshal([]byte(data), &r)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// Matcher defines the conditions a response must meet for a rule to match.
+// every condition which is set must match.
+type Matcher struct {
+	// Status is the list of matching status codes
+	Status []int `yaml:"status"`
+
+	// Headers maps header names to regular expressions
+	Headers map[string]string `yaml:"headers"`
+
+	// Body is a regular expression matched against the response body
+	Body string `yaml:"body"`
+
+	// JSON maps dotted paths into a JSON response body to regular
+	// expressions
+	JSON map[string]string `yaml:"json"`
+
+	headers map[string]*regexp.Regexp
+	body    *regexp.Regexp
+	json    map[string]*regexp.Regexp
+}
+
+// Outcome is the classification of a matching response.
+type Outcome struct {
+	Valid       bool `yaml:"valid"`
+	Locked      bool `yaml:"locked"`
+	MFA         bool `yaml:"mfa"`
+	RateLimited bool `yaml:"rate_limited"`
+	Captcha     bool `yaml:"captcha"`
+
+	// PolicyBlocked marks a valid credential whose login was blocked by a
+	// policy of the provider, and should be set along with valid
+	PolicyBlocked bool `yaml:"policy_blocked"`
+
+	// Error, if set, fails the task with the given message
+	Error string `yaml:"error"`
+
+	// Class classifies the error (see the retry package). it defaults to
+	// unknown.
+	Class retry.Class `yaml:"class"`
+}
+
+// Rule classifies the responses of a provider matching its Matcher.
+type Rule struct {
+	// Name identifies the rule and is recorded in the result metadata
+	Name string `yaml:"name"`
+
+	// Provider is the nozzle the rule applies to
+	Provider string `yaml:"provider"`
+
+	Match  Matcher `yaml:"match"`
+	Result Outcome `yaml:"result"`
+}
+
+// Ruleset is an ordered list of rules.
+type Ruleset struct {
+	Rules []Rule `yaml:"rules"`
+}
+
+// Parse parses and compiles a YAML ruleset.
+func Parse(data []byte) (*Ruleset, error) {
+	var rs Ruleset
+	err := yaml.UnmarshalStrict(data, &rs)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range rs.Rules {
+		err = rs.Rules[i].compile()
+		if err != nil {
+			return nil, fmt.Errorf("rule %d (%s): %w", i, rs.Rules[i].Name, err)
+		}
+	}
+	return &rs, nil
+}
+
+// Load reads a ruleset from a file or an http(s) URL.
+func Load(source string) (*Ruleset, error) {
+	if !strings.HasPrefix(source, "https://") && !strings.HasPrefix(source, "http://") {
+		data, err := ioutil.ReadFile(source) // nolint:gosec
+		if err != nil {
+			return nil, err
+		}
+		return Parse(data)
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(source)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("unexpected status code fetching rules: %d", resp.StatusCode)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+func (r *Rule) compile() error {
+	if r.Name == "" || r.Provider == "" {
+		return fmt.Errorf("rules require a name and a provider")
+	}
+
+	var err error
+	m := &r.Match
+	m.headers = make(map[string]*regexp.Regexp, len(m.Headers))
+	for name, expr := range m.Headers {
+		m.headers[name], err = regexp.Compile(expr)
+		if err != nil {
+			return err
+		}
+	}
+	if m.Body != "" {
+		m.body, err = regexp.Compile(m.Body)
+		if err != nil {
+			return err
+		}
+	}
+	m.json = make(map[string]*regexp.Regexp, len(m.JSON))
+	for path, expr := range m.JSON {
+		m.json[path], err = regexp.Compile(expr)
+		if err != nil {
+			return err
+		}
+	}
+
+	if r.Result.Error != "" && r.Result.Class == "" {
+		r.Result.Class = retry.ClassUnknown
+	}
+	return nil
+}
+
+// Matches returns true if the response meets every condition of the matcher.
+func (m *Matcher) Matches(resp *Response) bool {
+	if len(m.Status) > 0 && !containsInt(m.Status, resp.StatusCode) {
+		return false
+	}
+	for name, re := range m.headers {
+		if !re.MatchString(resp.Header.Get(name)) {
+			return false
+		}
+	}
+	if m.body != nil && !m.body.Match(resp.Body) {
+		return false
+	}
+	if len(m.json) > 0 {
+		var doc interface{}
+		if json.Unmarshal(resp.Body, &doc) != nil {
+			return false
+		}
+		for path, re := range m.json {
+			v, ok := lookup(doc, path)
+			if !ok || !re.MatchString(v) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Apply returns the classification of a response matching the rule.
+func (r *Rule) Apply() (*event.AuthResponse, error) {
+	if r.Result.Error != "" {
+		return nil, retry.Errorf(r.Result.Class, "%s (rule %s)", r.Result.Error, r.Name)
+	}
+	return &event.AuthResponse{
+		Valid:         r.Result.Valid,
+		Locked:        r.Result.Locked,
+		MFA:           r.Result.MFA,
+		RateLimited:   r.Result.RateLimited,
+		Captcha:       r.Result.Captcha,
+		PolicyBlocked: r.Result.PolicyBlocked,
+		Metadata: map[string]interface{}{
+			"rule": r.Name,
+		},
+	}, nil
+}
+
+// Match returns the first rule of the provider matching the response, or nil.
+func (rs *Ruleset) Match(provider string, resp *Response) *Rule {
+	for i := range rs.Rules {
+		r := &rs.Rules[i]
+		if r.Provider == provider && r.Match.Matches(resp) {
+			return r
+		}
+	}
+	return nil
+}
+
+// lookup resolves a dotted path in a decoded JSON document. array elements
+// are addressed by their index.
+func lookup(doc interface{}, path string) (string, bool) {
+	for _, key := range strings.Split(path, ".") {
+		switch v := doc.(type) {
+		case map[string]interface{}:
+			var ok bool
+			doc, ok = v[key]
+			if !ok {
+				return "", false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return "", false
+			}
+			doc = v[i]
+		default:
+			return "", false
+		}
+	}
+
+	switch v := doc.(type) {
+	case string:
+		return v, true
+	case nil:
+		return "", false
+	case map[string]interface{}, []interface{}:
+		data, _ := json.Marshal(v)
+		return string(data), true
+	default:
+		return fmt.Sprint(v), true
+	}
+}
+
+func containsInt(values []int, v int) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	defaultMu sync.RWMutex
+	defaults  = &Ruleset{}
+)
+
+// Set replaces the ruleset used by Classify.
+func Set(rs *Ruleset) {
+	defaultMu.Lock()
+	defaults = rs
+	defaultMu.Unlock()
+}
+
+// Classify applies the first rule of the provider matching the response. ok
+// is false if no rule matched, in which case the nozzle should classify the
+// response itself.
+func Classify(provider string, resp *Response) (res *event.AuthResponse, ok bool, err error) {
+	defaultMu.RLock()
+	rs := defaults
+	defaultMu.RUnlock()
+
+	r := rs.Match(provider, resp)
+	if r == nil {
+		return nil, false, nil
+	}
+	res, err = r.Apply()
+	return res, true, err
+}
+
+// Watch loads the ruleset from source and reloads it every interval. a
+// ruleset which fails to load is logged and the previous ruleset is kept.
+// Watch returns an error if the initial load fails.
+func Watch(source string, interval time.Duration) error {
+	rs, err := Load(source)
+	if err != nil {
+		return err
+	}
+	Set(rs)
+	log.Infof("loaded %d classification rules from %s", len(rs.Rules), source)
+
+	if interval <= 0 {
+		return nil
+	}
+	go func() {
+		for range time.Tick(interval) {
+			rs, err := Load(source)
+			if err != nil {
+				log.Errorf("error reloading classification rules: %s", err)
+				continue
+			}
+			Set(rs)
+			log.Debugf("reloaded %d classification rules from %s", len(rs.Rules), source)
+		}
+	}()
+	return nil
+}
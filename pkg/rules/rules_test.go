@@ -0,0 +1,185 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rules
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/praetorian-inc/trident/pkg/retry"
+)
+
+var testRules = []byte(`
+rules:
+  - name: okta-expired
+    provider: okta
+    match:
+      status: [200]
+      json:
+        status: ^PASSWORD_EXPIRED$
+    result:
+      valid: true
+  - name: okta-factor
+    provider: okta
+    match:
+      json:
+        _embedded.factors.0.factorType: push
+    result:
+      valid: true
+      mfa: true
+  - name: o365-lockout
+    provider: o365
+    match:
+      status: [400, 401]
+      body: AADSTS50053
+    result:
+      locked: true
+  - name: o365-blocked
+    provider: o365
+    match:
+      headers:
+        X-Ms-Blocked: "1"
+    result:
+      error: blocked by the provider
+      class: config
+`)
+
+type testcase struct {
+	desc     string
+	provider string
+	resp     Response
+	rule     string
+}
+
+func TestMatch(t *testing.T) {
+	rs, err := Parse(testRules)
+	if err != nil {
+		t.Fatalf("unable to parse rules: %s", err)
+	}
+
+	var testcases = []testcase{
+		{"json match", "okta", Response{200, http.Header{}, []byte(`{"status":"PASSWORD_EXPIRED"}`)}, "okta-expired"},
+		{"status mismatch", "okta", Response{401, http.Header{}, []byte(`{"status":"PASSWORD_EXPIRED"}`)}, ""},
+		{"json value mismatch", "okta", Response{200, http.Header{}, []byte(`{"status":"SUCCESS"}`)}, ""},
+		{"json array path", "okta", Response{200, http.Header{},
+			[]byte(`{"_embedded":{"factors":[{"factorType":"push"}]}}`)}, "okta-factor"},
+		{"invalid json", "okta", Response{200, http.Header{}, []byte(`PASSWORD_EXPIRED`)}, ""},
+		{"body match", "o365", Response{400, http.Header{},
+			[]byte(`{"error_description":"AADSTS50053: locked"}`)}, "o365-lockout"},
+		{"other provider", "okta", Response{400, http.Header{}, []byte(`AADSTS50053`)}, ""},
+		{"header match", "o365", Response{200, http.Header{"X-Ms-Blocked": {"1"}}, nil}, "o365-blocked"},
+		{"header missing", "o365", Response{200, http.Header{}, nil}, ""},
+	}
+
+	for _, test := range testcases {
+		r := rs.Match(test.provider, &test.resp)
+		name := ""
+		if r != nil {
+			name = r.Name
+		}
+		if name != test.rule {
+			t.Errorf("[%s] matched rule %q, expected %q", test.desc, name, test.rule)
+		}
+	}
+}
+
+func TestClassify(t *testing.T) {
+	rs, err := Parse(testRules)
+	if err != nil {
+		t.Fatalf("unable to parse rules: %s", err)
+	}
+	Set(rs)
+	defer Set(&Ruleset{})
+
+	res, ok, err := Classify("o365", &Response{401, http.Header{}, []byte("AADSTS50053")})
+	if !ok || err != nil || !res.Locked || res.Metadata["rule"] != "o365-lockout" {
+		t.Errorf("unexpected classification %+v, %t, %v", res, ok, err)
+	}
+
+	_, ok, err = Classify("o365", &Response{200, http.Header{"X-Ms-Blocked": {"1"}}, nil})
+	var rerr *retry.Error
+	if !ok || !errors.As(err, &rerr) || rerr.Class != retry.ClassConfig {
+		t.Errorf("expected a config error, got %v", err)
+	}
+
+	_, ok, _ = Classify("adfs", &Response{200, http.Header{}, nil})
+	if ok {
+		t.Errorf("expected no rule to match")
+	}
+}
+
+func TestEncode(t *testing.T) {
+	r := &Response{400, http.Header{"Content-Type": {"application/json"}}, []byte(`{"error":"invalid_grant"}`)}
+	decoded, err := DecodeResponse(r.Encode())
+	if err != nil {
+		t.Fatalf("unable to decode response: %s", err)
+	}
+	if !reflect.DeepEqual(decoded, r) {
+		t.Errorf("decoded %+v, expected %+v", decoded, r)
+	}
+
+	large := &Response{200, http.Header{}, bytes.Repeat([]byte("a"), MaxCaptureSize+1)}
+	decoded, err = DecodeResponse(large.Encode())
+	if err != nil || len(decoded.Body) != MaxCaptureSize {
+		t.Errorf("expected the body to be truncated to %d bytes", MaxCaptureSize)
+	}
+	if len(large.Body) != MaxCaptureSize+1 {
+		t.Errorf("expected the encoded response to be left unchanged")
+	}
+
+	_, err = DecodeResponse("not json")
+	if err == nil {
+		t.Errorf("expected an error decoding an invalid response")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	var testcases = []string{
+		"rules:\n  - name: x\n    match: {}\n",
+		"rules:\n  - name: x\n    provider: okta\n    match:\n      body: \"(\"\n",
+		"rules:\n  - name: x\n    provider: okta\n    unknown: true\n",
+	}
+	for _, test := range testcases {
+		_, err := Parse([]byte(test))
+		if err == nil {
+			t.Errorf("expected error parsing %q", test)
+		}
+	}
+}
+
+func TestDetectCaptcha(t *testing.T) {
+	var testcases = []struct {
+		body      string
+		challenge string
+	}{
+		{`<script src="https://www.google.com/recaptcha/api.js"></script>`, "recaptcha"},
+		{`<div class="h-captcha" data-sitekey="x"></div>`, "hcaptcha"},
+		{`<div class="cf-turnstile"></div>`, "turnstile"},
+		{`{"status":"MFA_REQUIRED"}`, ""},
+	}
+	for _, test := range testcases {
+		res, ok := DetectCaptcha(&Response{200, http.Header{}, []byte(test.body)})
+		if ok != (test.challenge != "") {
+			t.Errorf("unexpected detection of %q: %t", test.body, ok)
+			continue
+		}
+		if ok && (!res.Captcha || res.Metadata["challenge"] != test.challenge) {
+			t.Errorf("unexpected outcome %+v for %q", res, test.body)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+	"github.com/praetorian-inc/trident/pkg/usernames"
+)
+
+// AccountLockedKeyF is the format string of the key marking an account which
+// was recently locked out, in any campaign. it expires with the cooldown.
+const AccountLockedKeyF = "account.%s.locked"
+
+// lockedKey returns the key marking the account of a username as locked out.
+func lockedKey(username string) string {
+	_, normalized := usernames.Identity(username)
+	return fmt.Sprintf(AccountLockedKeyF, normalized)
+}
+
+// cooldownTTL returns how much longer an account locked out at the provided
+// time is held off, or 0 if its cooldown has expired.
+func cooldownTTL(lockedAt time.Time, cooldown time.Duration, now time.Time) time.Duration {
+	ttl := lockedAt.Add(cooldown).Sub(now)
+	if ttl < 0 {
+		return 0
+	}
+	return ttl
+}
+
+// recordAccount records the outcome of a result against the state of its
+// account, and marks an account found locked out so that no campaign attempts
+// it again until its cooldown expires.
+func (s *PubSubScheduler) recordAccount(res *db.Result) {
+	if res.Error != "" {
+		return
+	}
+	err := s.db.RecordAccount(res)
+	if err != nil {
+		log.Printf("error recording account state: %s", err)
+	}
+
+	if !res.Locked || s.cooldown <= 0 {
+		return
+	}
+	lockedAt := res.Timestamp
+	if lockedAt.IsZero() {
+		lockedAt = time.Now()
+	}
+	ttl := cooldownTTL(lockedAt, s.cooldown, time.Now())
+	if ttl == 0 {
+		return
+	}
+	err = s.cache.Set(lockedKey(res.Username), lockedAt.Unix(), ttl).Err()
+	if err != nil {
+		log.Printf("error marking account locked out: %s", err)
+	}
+}
+
+// loadLockouts marks the accounts locked out within the cooldown according
+// to the database, e.g. when the task schedule's cache was flushed.
+func (s *PubSubScheduler) loadLockouts() error {
+	if s.cooldown <= 0 {
+		return nil
+	}
+	now := time.Now()
+	accounts, err := s.db.SelectLockedAccounts(now.Add(-s.cooldown))
+	if err != nil || len(accounts) == 0 {
+		return err
+	}
+
+	pipe := s.cache.Pipeline()
+	for _, a := range accounts {
+		ttl := cooldownTTL(*a.LastLocked, s.cooldown, now)
+		if ttl > 0 {
+			pipe.Set(lockedKey(a.Username), a.LastLocked.Unix(), ttl)
+		}
+	}
+	_, err = pipe.Exec()
+	return err
+}
+
+// cooling returns how much longer the account of a task is held off after it
+// was locked out, or 0 if the task may run.
+func (s *PubSubScheduler) cooling(task *db.Task) (time.Duration, error) {
+	if s.cooldown <= 0 {
+		return 0, nil
+	}
+	ttl, err := s.cache.PTTL(lockedKey(task.Username)).Result()
+	if err == redis.Nil || ttl < 0 {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error checking account lockout: %w", err)
+	}
+	return ttl, nil
+}
@@ -0,0 +1,101 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+	"github.com/praetorian-inc/trident/pkg/fingerprint"
+)
+
+// FingerprintKey returns the idempotency key of a campaign's fingerprint task.
+func FingerprintKey(campaignID uint) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d/fingerprint", campaignID)))
+	return hex.EncodeToString(sum[:])
+}
+
+// fingerprintUser returns the user named by the probe of a campaign's tenant:
+// the first of its users (with exclusions applied) which is not a tripwire
+// account, so that the probe never touches an account operators watch.
+func fingerprintUser(campaign db.Campaign, users []string) (string, bool) {
+	for _, u := range users {
+		if !isTripwire(campaign.Guardrails, u) {
+			return u, true
+		}
+	}
+	return "", false
+}
+
+// scheduleFingerprint schedules the fingerprint task of the campaign, which
+// runs at the campaign's NotBefore like its first attempts. campaigns whose
+// nozzle cannot fingerprint, or which have no user the probe may name, are
+// not fingerprinted.
+func (s *PubSubScheduler) scheduleFingerprint(campaign db.Campaign, users []string) {
+	if !fingerprint.Supported(campaign.Provider, campaign.ProviderMetadata) {
+		return
+	}
+	username, ok := fingerprintUser(campaign, users)
+	if !ok {
+		log.Printf("campaign %d: not fingerprinting the tenant, every user is excluded or a tripwire", campaign.ID)
+		return
+	}
+
+	// fingerprint tasks are not attempts, so they carry no password and
+	// are not subject to the campaign's limits
+	err := s.pushCampaignTask(&db.Task{
+		CampaignID:       campaign.ID,
+		NotBefore:        campaign.NotBefore,
+		NotAfter:         campaign.NotAfter,
+		Username:         username,
+		Provider:         campaign.Provider,
+		ProviderMetadata: campaign.ProviderMetadata,
+		Excluded:         campaign.Excluded,
+		Key:              FingerprintKey(campaign.ID),
+		Fingerprint:      true,
+	}, campaign.ID)
+	if err != nil {
+		log.Printf("error in redis push fingerprint task: %s", err)
+	}
+}
+
+// isFingerprint returns true if the result is that of a fingerprint task.
+func isFingerprint(res *db.Result) bool {
+	return res.Fingerprint != nil || (res.Task != nil && res.Task.Fingerprint)
+}
+
+// recordFingerprint records the outcome of a fingerprint task with its
+// campaign. failed probes are recorded with their error rather than retried,
+// as fingerprints are informational and never block a campaign.
+func (s *PubSubScheduler) recordFingerprint(res *db.Result) {
+	fp := db.Fingerprint{ProbedAt: res.Timestamp.UTC(), Error: res.Error}
+	if res.Fingerprint != nil {
+		fp = *res.Fingerprint
+	}
+	if fp.ProbedAt.IsZero() {
+		fp.ProbedAt = time.Now().UTC()
+	}
+
+	err := s.db.UpdateCampaignFingerprint(res.CampaignID, fp)
+	if err != nil {
+		log.Printf("error recording fingerprint of campaign %d: %s", res.CampaignID, err)
+		return
+	}
+	log.Printf("campaign %d: fingerprinted tenant from %s: %s", res.CampaignID, res.IP, fp)
+}
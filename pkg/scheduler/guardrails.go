@@ -0,0 +1,192 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+	"github.com/praetorian-inc/trident/pkg/notify"
+)
+
+const (
+	// RateLimitedKeyF, OutcomesKeyF and TrippedKeyF are the format strings
+	// of the keys tracking a campaign's guardrails
+	RateLimitedKeyF = "campaign%d.ratelimited"
+	OutcomesKeyF    = "campaign%d.outcomes"
+	TrippedKeyF     = "campaign%d.tripped"
+
+	// GuardrailMinAttempts is the number of attempts a campaign makes before
+	// its lockout rate is checked, so that the first lockouts do not trip it
+	GuardrailMinAttempts = 20
+
+	// GuardrailCacheTTL is how long the guardrails of a campaign are cached
+	GuardrailCacheTTL = time.Minute
+
+	// GuardrailCooldown is the shortest time between two trips of the same
+	// campaign, so that results already in flight do not trip it again
+	GuardrailCooldown = time.Minute
+)
+
+type cachedGuardrails struct {
+	db.Guardrails
+	expires time.Time
+}
+
+// guardrails returns the guardrails of a campaign, cached for
+// GuardrailCacheTTL since they do not change once the campaign is created.
+func (s *PubSubScheduler) guardrails(campaignID uint) (db.Guardrails, error) {
+	s.guardMu.Lock()
+	defer s.guardMu.Unlock()
+
+	cached, ok := s.guards[campaignID]
+	if ok && time.Now().Before(cached.expires) {
+		return cached.Guardrails, nil
+	}
+
+	g, _, err := s.db.CampaignGuardrails(campaignID)
+	if err != nil {
+		return g, err
+	}
+	if s.guards == nil {
+		s.guards = make(map[uint]cachedGuardrails)
+	}
+	s.guards[campaignID] = cachedGuardrails{Guardrails: g, expires: time.Now().Add(GuardrailCacheTTL)}
+	return g, nil
+}
+
+// isTripwire returns true if the username is one of the tripwire accounts.
+func isTripwire(g db.Guardrails, username string) bool {
+	for _, tripwire := range g.Tripwires {
+		if tripwire == username {
+			return true
+		}
+	}
+	return false
+}
+
+// lockoutTripped returns true if the lockout rate of the attempts exceeds the
+// maximum lockout rate, once enough attempts were made.
+func lockoutTripped(g db.Guardrails, attempts, locked int64) bool {
+	if g.MaxLockoutRate <= 0 || attempts < GuardrailMinAttempts {
+		return false
+	}
+	return float64(locked)*100/float64(attempts) > g.MaxLockoutRate
+}
+
+// checkGuardrails records the outcome of a result against its campaign's
+// guardrails and trips them if a threshold is reached. failed tasks are not
+// attempts and are ignored.
+func (s *PubSubScheduler) checkGuardrails(res *db.Result) {
+	if res.CampaignID == 0 || res.CredentialID != 0 || res.Error != "" {
+		return
+	}
+	g, err := s.guardrails(res.CampaignID)
+	if err != nil {
+		log.Printf("error loading campaign guardrails: %s", err)
+		return
+	}
+	if !g.Enabled() {
+		return
+	}
+
+	if res.Locked && isTripwire(g, res.Username) {
+		s.trip(res.CampaignID, g, fmt.Sprintf("tripwire account %s is locked", res.Username))
+		return
+	}
+
+	if g.MaxConsecutiveRateLimited > 0 {
+		key := fmt.Sprintf(RateLimitedKeyF, res.CampaignID)
+		if res.RateLimited {
+			n, err := s.cache.Incr(key).Result()
+			if err != nil {
+				log.Printf("error recording rate limited attempt: %s", err)
+			} else if n >= int64(g.MaxConsecutiveRateLimited) {
+				s.trip(res.CampaignID, g, fmt.Sprintf("%d consecutive attempts were rate limited", n))
+				return
+			}
+		} else if err := s.cache.Del(key).Err(); err != nil {
+			log.Printf("error resetting rate limited attempts: %s", err)
+		}
+	}
+
+	if g.MaxLockoutRate > 0 {
+		key := fmt.Sprintf(OutcomesKeyF, res.CampaignID)
+		var locked int64
+		if res.Locked {
+			locked = 1
+		}
+		pipe := s.cache.TxPipeline()
+		attempts := pipe.HIncrBy(key, "attempts", 1)
+		lockouts := pipe.HIncrBy(key, "locked", locked)
+		_, err := pipe.Exec()
+		if err != nil {
+			log.Printf("error recording attempt outcome: %s", err)
+			return
+		}
+		if lockoutTripped(g, attempts.Val(), lockouts.Val()) {
+			s.trip(res.CampaignID, g, fmt.Sprintf("lockout rate of %.1f%% over %d attempts exceeds %g%%",
+				float64(lockouts.Val())*100/float64(attempts.Val()), attempts.Val(), g.MaxLockoutRate))
+		}
+	}
+}
+
+// trip pauses or aborts a campaign whose guardrail tripped and notifies
+// operators. the guardrail counters are reset, so that a resumed campaign
+// starts from a clean slate. the tripped key only suppresses duplicate trips:
+// if it cannot be recorded, the campaign is paused or aborted regardless.
+func (s *PubSubScheduler) trip(campaignID uint, g db.Guardrails, reason string) {
+	first, err := s.cache.SetNX(fmt.Sprintf(TrippedKeyF, campaignID), reason, GuardrailCooldown).Result()
+	if err != nil {
+		log.Printf("error recording tripped guardrail: %s", err)
+	} else if !first {
+		return
+	}
+
+	status := db.CampaignStatus(db.CampaignStatusPaused)
+	if g.Action() == db.GuardrailAbort {
+		status = db.CampaignStatusCancelled
+	}
+	err = s.db.UpdateCampaignStatus(campaignID, status)
+	if err != nil {
+		log.Printf("error updating campaign status: %s", err)
+	}
+	err = s.cache.Del(fmt.Sprintf(RateLimitedKeyF, campaignID), fmt.Sprintf(OutcomesKeyF, campaignID)).Err()
+	if err != nil {
+		log.Printf("error resetting campaign guardrails: %s", err)
+	}
+	log.Printf("campaign %d guardrail tripped (%s): %s", campaignID, g.Action(), reason)
+
+	if s.alert == nil {
+		return
+	}
+	go func() {
+		err := s.alert.Notify(context.Background(), notify.Message{
+			Title:      fmt.Sprintf("campaign %d: guardrail tripped, campaign %s", campaignID, strings.ToLower(string(status))),
+			Text:       reason,
+			CampaignID: campaignID,
+			Fields: map[string]string{
+				"action": string(g.Action()),
+			},
+		})
+		if err != nil {
+			log.Printf("error sending notification: %s", err)
+		}
+	}()
+}
@@ -0,0 +1,240 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+)
+
+const (
+	// InFlightKeyF, AttemptsKeyF and UserAttemptsKeyF are the format strings
+	// of the keys tracking a campaign's limits
+	InFlightKeyF     = "campaign%d.inflight"
+	AttemptsKeyF     = "campaign%d.attempts"
+	UserAttemptsKeyF = "campaign%d.user.%s"
+
+	// InFlightTimeout is the longest a published task counts as in flight
+	// if its result is never consumed (e.g. the task was lost)
+	InFlightTimeout = 5 * time.Minute
+
+	// CaptchaKeyF is the format string of the key marking a user of a
+	// campaign who was challenged with a CAPTCHA
+	CaptchaKeyF = "campaign%d.captcha.%s"
+
+	// CaptchaBackoff is how long attempts against a user are postponed once
+	// the provider challenges them with a CAPTCHA
+	CaptchaBackoff = time.Hour
+
+	// CaptchaSkipTTL is how long a user is skipped if the window of the
+	// challenged task is unknown
+	CaptchaSkipTTL = 7 * 24 * time.Hour
+)
+
+// limitScript atomically checks a campaign's limits and, if none is reached,
+// records the attempt. it returns 0 if the task may be published, the number
+// of the limit reached (1: in flight, 2: per hour) or, if the user's limit is
+// reached, the time (ms) at which their oldest attempt leaves the window.
+//
+// KEYS: in flight, attempts, user attempts
+// ARGV: now (ms), in flight expiry (ms), in flight member, attempt member,
+//       max in flight, max per hour, max per user, user window (ms)
+var limitScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local maxInFlight = tonumber(ARGV[5])
+local maxPerHour = tonumber(ARGV[6])
+local maxPerUser = tonumber(ARGV[7])
+local window = tonumber(ARGV[8])
+
+if maxInFlight > 0 then
+	redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now)
+	if redis.call('ZCARD', KEYS[1]) >= maxInFlight then
+		return 1
+	end
+end
+if maxPerHour > 0 then
+	redis.call('ZREMRANGEBYSCORE', KEYS[2], '-inf', now - 3600000)
+	if redis.call('ZCARD', KEYS[2]) >= maxPerHour then
+		return 2
+	end
+end
+if maxPerUser > 0 then
+	redis.call('ZREMRANGEBYSCORE', KEYS[3], '-inf', now - window)
+	if redis.call('ZCARD', KEYS[3]) >= maxPerUser then
+		local oldest = redis.call('ZRANGE', KEYS[3], 0, 0, 'WITHSCORES')
+		return tonumber(oldest[2]) + window
+	end
+end
+
+if maxInFlight > 0 then
+	redis.call('ZADD', KEYS[1], ARGV[2], ARGV[3])
+	redis.call('PEXPIRE', KEYS[1], ARGV[2] - now)
+end
+if maxPerHour > 0 then
+	redis.call('ZADD', KEYS[2], now, ARGV[4])
+	redis.call('PEXPIRE', KEYS[2], 3600000)
+end
+if maxPerUser > 0 then
+	redis.call('ZADD', KEYS[3], now, ARGV[4])
+	redis.call('PEXPIRE', KEYS[3], window)
+end
+return 0
+`)
+
+// inFlightMember identifies a task in the in flight set. results carry the
+// same username and (possibly sealed) password as their task.
+func inFlightMember(username, password string) string {
+	return username + "\x00" + password
+}
+
+// acquire records an attempt of the task against its campaign's limits. if a
+// limit has been reached, false is returned and the attempt is not recorded.
+// if the user's limit was reached, the task is postponed until the user may be
+// attempted again, so that it does not hold up the tasks of other users.
+func (s *PubSubScheduler) acquire(task *db.Task) (bool, error) {
+	l := task.Limits
+	if l == nil || !l.Enabled() || task.CredentialID != 0 {
+		return true, nil
+	}
+
+	now := time.Now()
+	ms := func(t time.Time) int64 {
+		return t.UnixNano() / int64(time.Millisecond)
+	}
+	member := inFlightMember(task.Username, task.Password)
+
+	reached, err := limitScript.Run(s.cache, []string{
+		fmt.Sprintf(InFlightKeyF, task.CampaignID),
+		fmt.Sprintf(AttemptsKeyF, task.CampaignID),
+		fmt.Sprintf(UserAttemptsKeyF, task.CampaignID, task.Username),
+	},
+		ms(now), ms(now.Add(InFlightTimeout)), member,
+		fmt.Sprintf("%s\x00%d", member, now.UnixNano()),
+		l.MaxInFlight, l.MaxAttemptsPerHour, l.MaxAttemptsPerUser,
+		int64(l.UserWindow/time.Millisecond),
+	).Int64()
+	if err != nil {
+		return false, fmt.Errorf("error checking campaign limits: %w", err)
+	}
+	if reached > 2 {
+		task.NotBefore = time.Unix(0, reached*int64(time.Millisecond))
+	}
+	return reached == 0, nil
+}
+
+// release removes the task of a result from its campaign's in flight set.
+func (s *PubSubScheduler) release(res *db.Result) {
+	if res.CampaignID == 0 || res.CredentialID != 0 {
+		return
+	}
+	err := s.cache.ZRem(fmt.Sprintf(InFlightKeyF, res.CampaignID),
+		inFlightMember(res.Username, res.Password)).Err()
+	if err != nil {
+		log.Printf("error releasing in flight task: %s", err)
+	}
+}
+
+// backoff applies the campaign's CAPTCHA action to the user of a result if
+// the provider challenged them with a CAPTCHA. the challenged attempt says
+// nothing about the credential, so unless the user is skipped, its task is
+// retried once the backoff expires (possibly from another egress IP).
+func (s *PubSubScheduler) backoff(res *db.Result) {
+	if !res.Captcha || res.CampaignID == 0 || res.CredentialID != 0 {
+		return
+	}
+	g, err := s.guardrails(res.CampaignID)
+	if err != nil {
+		log.Printf("error loading campaign guardrails: %s", err)
+	}
+
+	key := fmt.Sprintf(CaptchaKeyF, res.CampaignID, res.Username)
+	if g.Captcha() == db.CaptchaSkip {
+		ttl := skipTTL(res.Task, time.Now())
+		if ttl == 0 {
+			return
+		}
+		if err := s.cache.Set(key, captchaSkipped, ttl).Err(); err != nil {
+			log.Printf("error skipping user: %s", err)
+		}
+		return
+	}
+
+	if err := s.cache.Set(key, 1, CaptchaBackoff).Err(); err != nil {
+		log.Printf("error backing off user: %s", err)
+	}
+	if res.Task != nil {
+		task := *res.Task
+		task.NotBefore = time.Now().Add(CaptchaBackoff)
+		task.Key = rekey(task.Key, fmt.Sprintf("captcha%d", time.Now().UnixNano()))
+		if task.NotBefore.Before(task.NotAfter) {
+			if err := s.pushCampaignTask(&task, task.CampaignID); err != nil {
+				log.Printf("error rescheduling challenged task: %s", err)
+			}
+		}
+	}
+
+	if g.Captcha() == db.CaptchaTrip {
+		s.trip(res.CampaignID, g, fmt.Sprintf("user %s was challenged with a CAPTCHA", res.Username))
+	}
+}
+
+// skipTTL returns how long the user of a challenged task is skipped: until
+// the campaign's window closes, or CaptchaSkipTTL if the task is unknown. it
+// returns 0 if the window has already closed and there is nothing to skip.
+func skipTTL(task *db.Task, now time.Time) time.Duration {
+	if task == nil {
+		return CaptchaSkipTTL
+	}
+	ttl := task.NotAfter.Sub(now)
+	if ttl < 0 {
+		return 0
+	}
+	return ttl
+}
+
+// captchaSkipped is the value of the CaptchaKeyF key of a skipped user.
+const captchaSkipped = "skip"
+
+// backedOff returns how much longer the attempts against the user of a task
+// are postponed, or 0 if the task may run. skip is true if the user is no
+// longer attempted.
+func (s *PubSubScheduler) backedOff(task *db.Task) (wait time.Duration, skip bool, err error) {
+	if task.CredentialID != 0 {
+		return 0, false, nil
+	}
+	key := fmt.Sprintf(CaptchaKeyF, task.CampaignID, task.Username)
+	pipe := s.cache.Pipeline()
+	value := pipe.Get(key)
+	ttl := pipe.PTTL(key)
+	_, err = pipe.Exec()
+	if err == redis.Nil {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("error checking user backoff: %w", err)
+	}
+	if value.Val() == captchaSkipped {
+		return 0, true, nil
+	}
+	if ttl.Val() < 0 {
+		return 0, false, nil
+	}
+	return ttl.Val(), false, nil
+}
@@ -0,0 +1,93 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+)
+
+// Preset is a named low-and-slow pace which may be selected when creating a
+// campaign. A preset attempts one password against every user per window and
+// limits each user to a single attempt per window, so that retried, requeued
+// or backed off tasks never bring a second password inside it.
+type Preset struct {
+	// Name selects the preset
+	Name string
+
+	// Description summarizes the preset for operators
+	Description string
+
+	// Window is both the interval between passwords and the window in which
+	// a user is attempted at most once
+	Window time.Duration
+}
+
+// Presets are the built-in presets. Their windows leave a margin over the
+// lockout observation windows they are meant to stay outside of.
+var Presets = []Preset{
+	{
+		Name:        "ad-default",
+		Description: "one password every 35m, outside the default Active Directory observation window (30m)",
+		Window:      35 * time.Minute,
+	},
+	{
+		Name:        "ad-hourly",
+		Description: "one password every 65m, outside observation windows of up to an hour",
+		Window:      65 * time.Minute,
+	},
+	{
+		Name:        "daily",
+		Description: "one password a day",
+		Window:      24 * time.Hour,
+	},
+}
+
+// LookupPreset returns the built-in preset with the given name.
+func LookupPreset(name string) (Preset, error) {
+	names := make([]string, len(Presets))
+	for i, p := range Presets {
+		if p.Name == name {
+			return p, nil
+		}
+		names[i] = p.Name
+	}
+	return Preset{}, fmt.Errorf("unknown preset %q (expected one of %s)", name, strings.Join(names, ", "))
+}
+
+// Apply sets the schedule interval and per user limit of the campaign. Other
+// limits of the campaign are left as they are.
+func (p Preset) Apply(campaign *db.Campaign) {
+	campaign.Preset = p.Name
+	campaign.ScheduleInterval = p.Window
+	campaign.MaxAttemptsPerUser = 1
+	campaign.UserWindow = p.Window
+}
+
+// ApplyPreset applies the preset selected by the campaign, if any.
+func ApplyPreset(campaign *db.Campaign) error {
+	if campaign.Preset == "" {
+		return nil
+	}
+	p, err := LookupPreset(campaign.Preset)
+	if err != nil {
+		return err
+	}
+	p.Apply(campaign)
+	return nil
+}
@@ -16,15 +16,26 @@ package scheduler
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
-	"cloud.google.com/go/pubsub"
 	"github.com/go-redis/redis/v7"
 
+	"github.com/praetorian-inc/trident/pkg/credentials"
 	"github.com/praetorian-inc/trident/pkg/db"
+	"github.com/praetorian-inc/trident/pkg/kms"
+	"github.com/praetorian-inc/trident/pkg/mangle"
+	"github.com/praetorian-inc/trident/pkg/notify"
+	"github.com/praetorian-inc/trident/pkg/queue"
+	"github.com/praetorian-inc/trident/pkg/redact"
+	"github.com/praetorian-inc/trident/pkg/retry"
+	"github.com/praetorian-inc/trident/pkg/stream"
+	"github.com/praetorian-inc/trident/pkg/usernames"
 )
 
 const (
@@ -33,22 +44,60 @@ const (
 
 	// CacheKeyR format string for the redis Scan function
 	CacheKeyR = "campaign*.tasks"
+
+	// RevalidationWindow is the amount of time a credential revalidation
+	// task may wait before it expires
+	RevalidationWindow = time.Hour
+
+	// RequeueWindow is the amount of time a requeued task whose window has
+	// already closed may wait before it expires
+	RequeueWindow = time.Hour
+
+	// AlertInterval limits how often the same failure of a campaign is
+	// reported to operators
+	AlertInterval = 15 * time.Minute
+
+	// LockKeyF is the format string of the key of a lock shared by every
+	// orchestrator
+	LockKeyF = "lock.%s"
 )
 
 // Scheduler is an interface which wraps several scheduling functions together.
 type Scheduler interface {
 	Schedule(db.Campaign) error
+	Revalidate([]db.Credential) error
+	Pending(campaignID uint) (int64, time.Time, error)
+	Requeue(db.Campaign, []db.Task) error
+	Ingest(context.Context, db.Result) error
 	ProduceTasks()
 	ConsumeResults() error
 }
 
 // PubSubScheduler implements the scheduler interface and produces/consumes to
-// Google Cloud Pub/Sub.
+// a message queue (Google Cloud Pub/Sub by default).
 type PubSubScheduler struct {
-	db    *db.TridentDB
-	cache *redis.Client
-	pub   *pubsub.Topic
-	sub   *pubsub.Subscription
+	db       *db.TridentDB
+	vault    *credentials.Vault
+	redactor *redact.Redactor
+	env      *kms.Envelope
+	retry    retry.Policy
+	alert    notify.Notifier
+	hub      *stream.Hub
+	cache    *redis.Client
+	pub      queue.Topic
+	sub      queue.Subscription
+
+	alertMu sync.Mutex
+	alerted map[string]time.Time
+
+	insertOnce sync.Once
+	insertc    chan *db.Result
+
+	guardMu sync.Mutex
+	guards  map[uint]cachedGuardrails
+
+	cooldown    time.Duration
+	fingerprint bool
 }
 
 // Options is used to configure a PubSubScheduler.
@@ -56,14 +105,21 @@ type Options struct {
 	// Database is a pointer to the database struct.
 	Database *db.TridentDB
 
-	// ProjectID is the Google Cloud Platform project ID
+	// Queue is the name of the queue driver (defaults to "pubsub")
+	Queue string
+
+	// QueueConfig configures the queue driver
+	QueueConfig queue.Options
+
+	// ProjectID is the Google Cloud Platform project ID, used as the
+	// project_id of the pubsub queue driver if it is not configured
 	ProjectID string
 
-	// TopicID is the Pub/Sub topic ID used by the producer to publish tasks.
+	// TopicID is the topic used by the producer to publish tasks.
 	TopicID string
 
-	// SubscriptionID is the Pub/Sub subscription used by the consumer to pull
-	// task results.
+	// SubscriptionID is the subscription used by the consumer to pull task
+	// results.
 	SubscriptionID string
 
 	// RedisURI is the URI to the Redis instance (used for storing the task schedule)
@@ -71,21 +127,49 @@ type Options struct {
 
 	// RedisPassword is the Redis password
 	RedisPassword string
+
+	// Vault, if set, stores valid results in the credential vault
+	Vault *credentials.Vault
+
+	// Redactor, if set, redacts the credentials of results before they are
+	// stored, for campaigns configured with a redaction mode
+	Redactor *redact.Redactor
+
+	// Envelope, if set, encrypts task passwords before they are queued
+	Envelope *kms.Envelope
+
+	// RetryPolicy controls the backoff of failed tasks. the number of
+	// retries is configured per campaign.
+	RetryPolicy retry.Policy
+
+	// Notifier, if set, alerts operators of permanently failing tasks
+	Notifier notify.Notifier
+
+	// Hub, if set, receives every consumed campaign result
+	Hub *stream.Hub
+
+	// AccountCooldown is how long an account found locked out is not
+	// attempted by any campaign (0 disables the cooldown)
+	AccountCooldown time.Duration
+
+	// Fingerprint, if true, schedules a fingerprint task with each campaign
+	// (see the fingerprint package)
+	Fingerprint bool
 }
 
 // NewPubSubScheduler creates a PubSubScheduler given the provided Options.
 // This call will attempt to ping the provided RedisURI and error if this
 // connection fails.
 func NewPubSubScheduler(opts Options) (*PubSubScheduler, error) {
-	ctx := context.Background()
-	client, err := pubsub.NewClient(ctx, opts.ProjectID)
+	driver, config := queue.Config(opts.Queue, opts.QueueConfig, opts.ProjectID)
+	pub, err := queue.OpenTopic(driver, opts.TopicID, config)
+	if err != nil {
+		return nil, err
+	}
+	sub, err := queue.OpenSubscription(driver, opts.SubscriptionID, queue.Settings{}, config)
 	if err != nil {
 		return nil, err
 	}
-
-	sub := client.SubscriptionInProject(opts.SubscriptionID, opts.ProjectID)
-	sub.ReceiveSettings.Synchronous = true
-	sub.ReceiveSettings.MaxOutstandingMessages = 10
 
 	cache := redis.NewClient(&redis.Options{
 		Addr:       opts.RedisURI,
@@ -98,12 +182,26 @@ func NewPubSubScheduler(opts Options) (*PubSubScheduler, error) {
 		return nil, err
 	}
 
-	return &PubSubScheduler{
-		db:    opts.Database,
-		cache: cache,
-		sub:   sub,
-		pub:   client.Topic(opts.TopicID),
-	}, nil
+	s := &PubSubScheduler{
+		db:       opts.Database,
+		vault:    opts.Vault,
+		redactor: opts.Redactor,
+		env:      opts.Envelope,
+		retry:    opts.RetryPolicy,
+		alert:    opts.Notifier,
+		hub:      opts.Hub,
+		cache:    cache,
+		sub:      sub,
+		pub:      pub,
+		cooldown: opts.AccountCooldown,
+
+		fingerprint: opts.Fingerprint,
+	}
+	err = s.loadLockouts()
+	if err != nil {
+		return nil, fmt.Errorf("error loading locked out accounts: %w", err)
+	}
+	return s, nil
 }
 
 func (s *PubSubScheduler) pushCampaignTask(task *db.Task, campaignID uint) error {
@@ -113,6 +211,14 @@ func (s *PubSubScheduler) pushCampaignTask(task *db.Task, campaignID uint) error
 	}).Err()
 }
 
+// seal encrypts a task password if task encryption is enabled.
+func (s *PubSubScheduler) seal(password string) (string, error) {
+	if s.env == nil {
+		return password, nil
+	}
+	return s.env.Seal(context.Background(), password)
+}
+
 func (s *PubSubScheduler) popTask(task *db.Task, campaignKey string) error {
 	z, err := s.cache.BZPopMin(5*time.Second, campaignKey).Result()
 	if err != nil {
@@ -129,11 +235,33 @@ func (s *PubSubScheduler) popTask(task *db.Task, campaignKey string) error {
 //
 // Additionally, this scheduler prefers to schedule credential guesses for a
 // single password at a time, allowing the maximum time to pass before guessing
-// a given username again.
+// a given username again. Detection campaigns are instead deliberately noisy,
+// see slot.
 func (s *PubSubScheduler) Schedule(campaign db.Campaign) error {
-	t := campaign.NotBefore
-	for _, p := range campaign.Passwords {
-		for _, u := range campaign.Users {
+	passwords, err := Passwords(campaign)
+	if err != nil {
+		return err
+	}
+
+	users := Users(campaign)
+	if excluded := len(campaign.Users) - len(users); excluded > 0 {
+		log.Printf("campaign %d: skipping %d excluded users", campaign.ID, excluded)
+	}
+
+	if s.fingerprint {
+		s.scheduleFingerprint(campaign, users)
+	}
+
+	for i, password := range passwords {
+		p, err := s.seal(password)
+		if err != nil {
+			return fmt.Errorf("error encrypting password: %w", err)
+		}
+		for j, u := range users {
+			t := campaign.NotBefore.Add(time.Duration(slot(campaign, i, j, len(passwords))) * campaign.ScheduleInterval)
+			if t.After(campaign.NotAfter) {
+				continue
+			}
 			err := s.pushCampaignTask(&db.Task{
 				CampaignID:       campaign.ID,
 				NotBefore:        t,
@@ -142,14 +270,151 @@ func (s *PubSubScheduler) Schedule(campaign db.Campaign) error {
 				Password:         p,
 				Provider:         campaign.Provider,
 				ProviderMetadata: campaign.ProviderMetadata,
+				MaxRetries:       campaign.MaxRetries,
+				Limits:           limits(campaign),
+				Excluded:         campaign.Excluded,
+				Actions:          campaign.Actions,
+				Key:              TaskKey(campaign.ID, i, u),
 			}, campaign.ID)
 			if err != nil {
 				log.Printf("error in redis push task: %s", err)
 			}
 		}
-		t = t.Add(campaign.ScheduleInterval)
-		if t.After(campaign.NotAfter) {
-			return nil
+	}
+	return nil
+}
+
+// slot returns the number of schedule intervals after the start of the
+// campaign at which the password at index i is attempted against the user at
+// index j. spray campaigns attempt one password against every user per
+// interval. detection campaigns attempt every password against a user in a
+// burst, one attempt per interval without any jitter, before moving on to the
+// next user, so that each account sees the rapid failures detections look
+// for.
+func slot(campaign db.Campaign, i, j, passwords int) int64 {
+	if campaign.Mode == db.CampaignModeDetection {
+		return int64(j*passwords + i)
+	}
+	return int64(i)
+}
+
+// TaskKey returns the idempotency key of a campaign's attempt of the
+// password at the given index against a user. keys are deterministic so that
+// rescheduling a campaign yields the same keys.
+func TaskKey(campaignID uint, password int, username string) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d/%d/%s", campaignID, password, username)))
+	return hex.EncodeToString(sum[:])
+}
+
+// rekey derives the key of a deliberate re-execution of a task (a retry or a
+// requeue), which must not be blocked by the claim of the original key.
+// tasks without a key (e.g. revalidation tasks) are left without one.
+func rekey(key, reason string) string {
+	if key == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(key + "/" + reason))
+	return hex.EncodeToString(sum[:])
+}
+
+// limits returns the limits carried by the campaign's tasks, if any.
+func limits(campaign db.Campaign) *db.Limits {
+	if !campaign.Limits.Enabled() {
+		return nil
+	}
+	l := campaign.Limits
+	return &l
+}
+
+// Users returns the users of the campaign which are not excluded.
+func Users(campaign db.Campaign) []string {
+	if len(campaign.Excluded) == 0 {
+		return campaign.Users
+	}
+	var users []string
+	for _, u := range campaign.Users {
+		if !usernames.Excluded(campaign.Excluded, u) {
+			users = append(users, u)
+		}
+	}
+	return users
+}
+
+// Passwords returns the candidate passwords of the campaign, generated by
+// applying its password rules to its passwords.
+func Passwords(campaign db.Campaign) ([]string, error) {
+	rules, err := mangle.Compile(campaign.PasswordRules, campaign.NotBefore)
+	if err != nil {
+		return nil, fmt.Errorf("error compiling password rules: %w", err)
+	}
+	return mangle.Generate(campaign.Passwords, rules)
+}
+
+// ScheduledTasks returns the number of tasks Schedule creates for the
+// campaign, as tasks which fall outside the campaign's window are discarded.
+func ScheduledTasks(campaign db.Campaign) int64 {
+	candidates, err := Passwords(campaign)
+	if err != nil || len(candidates) == 0 || campaign.NotBefore.After(campaign.NotAfter) {
+		return 0
+	}
+	passwords := int64(len(candidates))
+	users := int64(len(Users(campaign)))
+	if campaign.ScheduleInterval <= 0 {
+		return passwords * users
+	}
+
+	fit := int64(campaign.NotAfter.Sub(campaign.NotBefore)/campaign.ScheduleInterval) + 1
+	if campaign.Mode == db.CampaignModeDetection {
+		// every attempt takes an interval of its own
+		if fit < passwords*users {
+			return fit
+		}
+		return passwords * users
+	}
+	if fit < passwords {
+		passwords = fit
+	}
+	return passwords * users
+}
+
+// Pending returns the number of tasks left in the campaign's schedule along
+// with the time the last of these tasks is scheduled to run.
+func (s *PubSubScheduler) Pending(campaignID uint) (int64, time.Time, error) {
+	key := fmt.Sprintf(CacheKeyF, campaignID)
+
+	count, err := s.cache.ZCard(key).Result()
+	if err != nil || count == 0 {
+		return 0, time.Time{}, err
+	}
+
+	last, err := s.cache.ZRevRangeWithScores(key, 0, 0).Result()
+	if err != nil || len(last) == 0 {
+		return count, time.Time{}, err
+	}
+	return count, time.Unix(0, int64(last[0].Score)), nil
+}
+
+// Revalidate schedules a task for each provided credential, to be run as soon
+// as possible. revalidation tasks are not associated with a campaign.
+func (s *PubSubScheduler) Revalidate(creds []db.Credential) error {
+	now := time.Now()
+	for _, c := range creds {
+		p, err := s.seal(c.Password)
+		if err != nil {
+			return fmt.Errorf("error encrypting password: %w", err)
+		}
+		err = s.pushCampaignTask(&db.Task{
+			NotBefore:        now,
+			NotAfter:         now.Add(RevalidationWindow),
+			Username:         c.Username,
+			Password:         p,
+			Provider:         c.Provider,
+			ProviderMetadata: c.ProviderMetadata,
+			CredentialID:     c.ID,
+			MaxRetries:       s.retry.MaxRetries,
+		}, 0)
+		if err != nil {
+			return err
 		}
 	}
 	return nil
@@ -157,9 +422,13 @@ func (s *PubSubScheduler) Schedule(campaign db.Campaign) error {
 
 func (s *PubSubScheduler) publishTask(ctx context.Context, task *db.Task) error {
 
-	taskStatus, err := s.db.GetCampaignStatus(task.CampaignID)
-	if err != nil {
-		return fmt.Errorf("Error checking campaign status during scheduling: %w", err)
+	var taskStatus db.CampaignStatus = db.CampaignStatusActive
+	var err error
+	if task.CredentialID == 0 {
+		taskStatus, err = s.db.GetCampaignStatus(task.CampaignID)
+		if err != nil {
+			return fmt.Errorf("Error checking campaign status during scheduling: %w", err)
+		}
 	}
 
 	// check if task.CampaignID belongs to a cancelled/halted Campaign. If so skip it.
@@ -168,8 +437,55 @@ func (s *PubSubScheduler) publishTask(ctx context.Context, task *db.Task) error
 		return nil
 	}
 
-	if time.Until(task.NotBefore) > 5*time.Second || taskStatus == db.CampaignStatusPaused {
-		// our task was not ready or the campaign is paused, reschedule it
+	ready := time.Until(task.NotBefore) <= 5*time.Second && taskStatus != db.CampaignStatusPaused
+	if ready {
+		// the user was challenged with a CAPTCHA, wait for the backoff to
+		// expire before trying them again, or drop the task if the user is
+		// skipped
+		wait, skip, err := s.backedOff(task)
+		if err != nil {
+			return err
+		}
+		if skip {
+			return nil
+		}
+		if wait > 0 {
+			task.NotBefore = time.Now().Add(wait)
+			ready = false
+		}
+	}
+	if ready {
+		// the account was recently locked out, possibly by another campaign,
+		// hold off until its cooldown expires or drop the task if the
+		// campaign ends first
+		wait, err := s.cooling(task)
+		if err != nil {
+			return err
+		}
+		if wait > 0 {
+			task.NotBefore = time.Now().Add(wait)
+			if !task.NotBefore.Before(task.NotAfter) {
+				log.Printf("campaign %d: skipping %s, locked out until the campaign ends", task.CampaignID, task.Username)
+				return nil
+			}
+			ready = false
+		}
+	}
+	if ready {
+		ready, err = s.acquire(task)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !ready {
+		// our task was not ready, the campaign is paused, a limit has been
+		// reached or the user is backed off, reschedule it unless its window
+		// has closed in the meantime
+		if !time.Now().Before(task.NotAfter) {
+			log.Printf("campaign %d: dropping the task of %s, its window has closed", task.CampaignID, task.Username)
+			return nil
+		}
 		err := s.pushCampaignTask(task, task.CampaignID)
 		if err != nil {
 			return fmt.Errorf("error rescheduling task: %w", err)
@@ -178,10 +494,7 @@ func (s *PubSubScheduler) publishTask(ctx context.Context, task *db.Task) error
 	} else {
 		// our task was ready, run it!
 		b, _ := json.Marshal(task)
-		publishResults := s.pub.Publish(ctx, &pubsub.Message{
-			Data: b,
-		})
-		_, err := publishResults.Get(ctx)
+		err := s.pub.Publish(ctx, b)
 		if err != nil {
 			return fmt.Errorf("error publishing task: %w", err)
 		}
@@ -189,7 +502,14 @@ func (s *PubSubScheduler) publishTask(ctx context.Context, task *db.Task) error
 	return nil
 }
 
-// ProduceTasks will poll the task schedule and publish tasks to pub/sub when
+// TryLock acquires the named lock for ttl, shared by every orchestrator using
+// the same redis. it returns false if the lock is already held; the lock is
+// never released before ttl expires.
+func (s *PubSubScheduler) TryLock(name string, ttl time.Duration) (bool, error) {
+	return s.cache.SetNX(fmt.Sprintf(LockKeyF, name), time.Now().Unix(), ttl).Result()
+}
+
+// ProduceTasks will poll the task schedule and publish tasks to the queue when
 // the top task is ready.
 func (s *PubSubScheduler) ProduceTasks() {
 	ctx := context.Background()
@@ -219,13 +539,124 @@ func (s *PubSubScheduler) ProduceTasks() {
 	}
 }
 
-// ConsumeResults will stream results from pub/sub and store them in the
+// retryTask reschedules the task of a failed result if its error is
+// transient and the task has retries remaining. if the task is not retried,
+// operators are alerted and false is returned.
+func (s *PubSubScheduler) retryTask(res *db.Result) bool {
+	class := retry.Class(res.ErrorClass)
+	task := res.Task
+
+	if task != nil {
+		policy := s.retry
+		policy.MaxRetries = task.MaxRetries
+		if policy.Retry(class, task.Attempt) {
+			task.NotBefore = time.Now().Add(policy.Backoff(class, task.Attempt))
+			task.Attempt++
+			task.Key = rekey(task.Key, fmt.Sprintf("attempt%d", task.Attempt))
+			if task.NotBefore.Before(task.NotAfter) {
+				err := s.pushCampaignTask(task, task.CampaignID)
+				if err == nil {
+					return true
+				}
+				log.Printf("error rescheduling failed task: %s", err)
+			}
+		}
+	}
+
+	if task != nil {
+		err := s.db.InsertFailedTask(&db.FailedTask{
+			CampaignID: task.CampaignID,
+			Username:   task.Username,
+			Provider:   task.Provider,
+			Attempt:    task.Attempt,
+			Error:      res.Error,
+			ErrorClass: res.ErrorClass,
+			Task:       *task,
+		})
+		if err != nil {
+			log.Printf("error inserting failed task: %s", err)
+		}
+	}
+
+	s.notify(res)
+	return false
+}
+
+// Requeue schedules failed tasks of a campaign to be run again as soon as
+// possible, with their retries reset. a task whose window has closed may wait
+// up to RequeueWindow, but never past the end of its campaign; nothing is
+// requeued once the campaign's window has ended.
+func (s *PubSubScheduler) Requeue(c db.Campaign, tasks []db.Task) error {
+	now := time.Now()
+	if !now.Before(c.NotAfter) {
+		log.Printf("not requeueing %d tasks of campaign %d, its window has ended", len(tasks), c.ID)
+		return nil
+	}
+	for i := range tasks {
+		task := tasks[i]
+		task.Attempt = 0
+		task.Key = rekey(task.Key, fmt.Sprintf("requeue%d", now.UnixNano()))
+		task.NotBefore = now
+		if task.NotAfter.Before(now.Add(RequeueWindow)) {
+			task.NotAfter = now.Add(RequeueWindow)
+		}
+		if task.NotAfter.After(c.NotAfter) {
+			task.NotAfter = c.NotAfter
+		}
+		err := s.pushCampaignTask(&task, task.CampaignID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// notify alerts operators that a campaign's task has failed. alerts are
+// limited to one per campaign and error class every AlertInterval.
+func (s *PubSubScheduler) notify(res *db.Result) {
+	if s.alert == nil {
+		return
+	}
+
+	key := fmt.Sprintf("%d/%s", res.CampaignID, res.ErrorClass)
+	s.alertMu.Lock()
+	if time.Since(s.alerted[key]) < AlertInterval {
+		s.alertMu.Unlock()
+		return
+	}
+	if s.alerted == nil {
+		s.alerted = make(map[string]time.Time)
+	}
+	s.alerted[key] = time.Now()
+	s.alertMu.Unlock()
+
+	reason := "retries exhausted"
+	if !retry.Class(res.ErrorClass).Transient() {
+		reason = "permanent error"
+	}
+
+	go func() {
+		err := s.alert.Notify(context.Background(), notify.Message{
+			Title:      fmt.Sprintf("campaign %d: task failed (%s)", res.CampaignID, reason),
+			Text:       res.Error,
+			CampaignID: res.CampaignID,
+			Fields: map[string]string{
+				"class":    res.ErrorClass,
+				"username": res.Username,
+			},
+		})
+		if err != nil {
+			log.Printf("error sending notification: %s", err)
+		}
+	}()
+}
+
+// ConsumeResults will stream results from the queue and store them in the
 // database. Valid results are written directly to the database and invalid
 // results are batched by the db.StreamingInsertResults function.
 func (s *PubSubScheduler) ConsumeResults() error {
 	ctx := context.Background()
-	results := s.db.StreamingInsertResults()
-	return s.sub.Receive(ctx, func(ctx context.Context, msg *pubsub.Message) {
+	return s.sub.Receive(ctx, func(ctx context.Context, msg *queue.Message) {
 		var res db.Result
 		err := json.Unmarshal(msg.Data, &res)
 		if err != nil {
@@ -234,17 +665,104 @@ func (s *PubSubScheduler) ConsumeResults() error {
 			return
 		}
 
-		if res.Valid {
-			err = s.db.InsertResult(&res)
-			if err != nil {
-				log.Printf("error inserting result into db: %s", err)
-				results <- &res
-			}
-		} else {
-			results <- &res
-		}
-
 		// ACK only if everything else succeeded
+		err = s.consume(ctx, &res)
+		if err != nil {
+			msg.Nack()
+			return
+		}
 		msg.Ack()
 	})
 }
+
+// Ingest records a result received outside of the result queue, e.g. posted
+// to the orchestrator by a worker. It is handled exactly like a consumed
+// result.
+func (s *PubSubScheduler) Ingest(ctx context.Context, res db.Result) error {
+	return s.consume(ctx, &res)
+}
+
+// inserts returns the channel of results batched by the
+// db.StreamingInsertResults function, shared by every consumer.
+func (s *PubSubScheduler) inserts() chan *db.Result {
+	s.insertOnce.Do(func() {
+		s.insertc = s.db.StreamingInsertResults()
+	})
+	return s.insertc
+}
+
+// consume stores a result and retries its task if it failed. an error is
+// returned if the result should be redelivered.
+func (s *PubSubScheduler) consume(ctx context.Context, res *db.Result) error {
+	results := s.inserts()
+	s.release(res)
+
+	// drop results which were already recorded, e.g. redelivered results
+	if res.Key != "" {
+		first, err := s.db.CompleteClaim(res.Key, res.CampaignID)
+		if err != nil {
+			log.Printf("error completing claim: %s", err)
+			return err
+		}
+		if !first {
+			log.Printf("dropping duplicate result of task %s", res.Key)
+			return nil
+		}
+	}
+
+	// fingerprint results only update their campaign
+	if isFingerprint(res) {
+		s.recordFingerprint(res)
+		return nil
+	}
+
+	if s.vault != nil {
+		err := s.vault.Record(ctx, res)
+		if err != nil {
+			log.Printf("error recording credential: %s", err)
+		}
+	}
+
+	if res.Error != "" && s.retryTask(res) {
+		return nil
+	}
+
+	s.recordAccount(res)
+
+	// revalidation results only update the credential vault
+	if res.CredentialID != 0 {
+		return nil
+	}
+
+	s.backoff(res)
+
+	// the redacted copy is stored and streamed, while the scheduler keeps
+	// checking the plaintext result. a result which could not be redacted is
+	// stored with its password sealed, and its credentials are withheld from
+	// read-only users
+	stored := res
+	if s.redactor != nil {
+		var err error
+		stored, err = s.redactor.Redact(ctx, res)
+		if err != nil {
+			log.Printf("error redacting result: %s", err)
+		}
+	}
+
+	if stored.Valid {
+		err := s.db.InsertResult(stored)
+		if err != nil {
+			log.Printf("error inserting result into db: %s", err)
+			results <- stored
+		}
+	} else {
+		results <- stored
+	}
+
+	if s.hub != nil {
+		s.hub.Publish(*stored)
+	}
+
+	s.checkGuardrails(res)
+	return nil
+}
@@ -0,0 +1,316 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+)
+
+func TestScheduledTasks(t *testing.T) {
+	type testcase struct {
+		name     string
+		campaign db.Campaign
+		expected int64
+	}
+
+	start := time.Now()
+	users := []string{"alice", "bob"}
+	passwords := []string{"Password1!", "Password2!", "Password3!"}
+
+	testcases := []testcase{
+		{
+			name: "window fits every password",
+			campaign: db.Campaign{
+				NotBefore: start, NotAfter: start.Add(time.Hour), ScheduleInterval: time.Minute,
+				Users: users, Passwords: passwords,
+			},
+			expected: 6,
+		},
+		{
+			name: "window fits two passwords",
+			campaign: db.Campaign{
+				NotBefore: start, NotAfter: start.Add(time.Minute), ScheduleInterval: time.Minute,
+				Users: users, Passwords: passwords,
+			},
+			expected: 4,
+		},
+		{
+			name: "excluded user",
+			campaign: db.Campaign{
+				NotBefore: start, NotAfter: start.Add(time.Hour), ScheduleInterval: time.Minute,
+				Users: users, Passwords: passwords, Excluded: []string{"B*"},
+			},
+			expected: 3,
+		},
+		{
+			name: "window already closed",
+			campaign: db.Campaign{
+				NotBefore: start, NotAfter: start.Add(-time.Minute), ScheduleInterval: time.Minute,
+				Users: users, Passwords: passwords,
+			},
+			expected: 0,
+		},
+		{
+			name: "password rules",
+			campaign: db.Campaign{
+				NotBefore: start, NotAfter: start.Add(time.Hour), ScheduleInterval: time.Minute,
+				Users: users, Passwords: passwords, PasswordRules: []string{":", "l", "u"},
+			},
+			expected: 18,
+		},
+		{
+			name: "invalid password rules",
+			campaign: db.Campaign{
+				NotBefore: start, NotAfter: start.Add(time.Hour), ScheduleInterval: time.Minute,
+				Users: users, Passwords: passwords, PasswordRules: []string{"X"},
+			},
+			expected: 0,
+		},
+		{
+			name: "detection window fits every attempt",
+			campaign: db.Campaign{
+				NotBefore: start, NotAfter: start.Add(time.Hour), ScheduleInterval: time.Minute,
+				Users: users, Passwords: passwords, Mode: db.CampaignModeDetection,
+			},
+			expected: 6,
+		},
+		{
+			name: "detection window fits four attempts",
+			campaign: db.Campaign{
+				NotBefore: start, NotAfter: start.Add(3 * time.Minute), ScheduleInterval: time.Minute,
+				Users: users, Passwords: passwords, Mode: db.CampaignModeDetection,
+			},
+			expected: 4,
+		},
+	}
+
+	for _, test := range testcases {
+		actual := ScheduledTasks(test.campaign)
+		if actual != test.expected {
+			t.Errorf("%s: expected %d tasks, got %d", test.name, test.expected, actual)
+		}
+	}
+}
+
+func TestSlot(t *testing.T) {
+	spray := db.Campaign{}
+	detection := db.Campaign{Mode: db.CampaignModeDetection}
+
+	// spray campaigns attempt each password against every user at once
+	if s := slot(spray, 1, 0, 3); s != 1 {
+		t.Errorf("spray: expected slot 1, got %d", s)
+	}
+	if s := slot(spray, 1, 1, 3); s != 1 {
+		t.Errorf("spray: expected slot 1, got %d", s)
+	}
+
+	// detection campaigns attempt every password against a user back to back
+	if s := slot(detection, 2, 0, 3); s != 2 {
+		t.Errorf("detection: expected slot 2, got %d", s)
+	}
+	if s := slot(detection, 0, 1, 3); s != 3 {
+		t.Errorf("detection: expected slot 3, got %d", s)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	if limits(db.Campaign{}) != nil {
+		t.Error("expected no limits for a campaign without caps")
+	}
+
+	campaign := db.Campaign{Limits: db.Limits{MaxInFlight: 5}}
+	l := limits(campaign)
+	if l == nil || l.MaxInFlight != 5 {
+		t.Fatalf("unexpected limits %+v", l)
+	}
+	l.MaxInFlight = 1
+	if campaign.MaxInFlight != 5 {
+		t.Error("task limits must not alias the campaign's limits")
+	}
+
+	type testcase struct {
+		name   string
+		limits db.Limits
+		valid  bool
+	}
+	testcases := []testcase{
+		{"no limits", db.Limits{}, true},
+		{"per user", db.Limits{MaxAttemptsPerUser: 1, UserWindow: time.Hour}, true},
+		{"per user without window", db.Limits{MaxAttemptsPerUser: 1}, false},
+		{"negative", db.Limits{MaxAttemptsPerHour: -1}, false},
+	}
+	for _, test := range testcases {
+		err := test.limits.Validate()
+		if (err == nil) != test.valid {
+			t.Errorf("[%s] unexpected validation result: %v", test.name, err)
+		}
+	}
+}
+
+func TestTaskKey(t *testing.T) {
+	key := TaskKey(1, 0, "alice")
+	if key != TaskKey(1, 0, "alice") {
+		t.Error("expected task keys to be deterministic")
+	}
+	for _, other := range []string{TaskKey(2, 0, "alice"), TaskKey(1, 1, "alice"), TaskKey(1, 0, "bob")} {
+		if other == key {
+			t.Errorf("expected distinct tasks to have distinct keys, got %s twice", key)
+		}
+	}
+
+	retried := rekey(key, "attempt1")
+	if retried == key || retried != rekey(key, "attempt1") {
+		t.Error("expected retries to be deterministically rekeyed")
+	}
+	if rekey("", "attempt1") != "" {
+		t.Error("expected tasks without a key to remain without one")
+	}
+}
+
+func TestFingerprintUser(t *testing.T) {
+	c := db.Campaign{
+		Users:    []string{"admin@example.org", "canary@example.org", "alice@example.org"},
+		Excluded: []string{"admin@*"},
+	}
+	c.Guardrails.Tripwires = []string{"canary@example.org"}
+
+	u, ok := fingerprintUser(c, Users(c))
+	if !ok || u != "alice@example.org" {
+		t.Errorf("expected the probe to name alice@example.org, got %q", u)
+	}
+
+	c.Users = c.Users[:2]
+	if u, ok := fingerprintUser(c, Users(c)); ok {
+		t.Errorf("expected no user to be named, got %q", u)
+	}
+
+	if isFingerprint(&db.Result{}) || !isFingerprint(&db.Result{Task: &db.Task{Fingerprint: true}}) ||
+		!isFingerprint(&db.Result{Fingerprint: &db.Fingerprint{}}) {
+		t.Error("expected only the results of fingerprint tasks to be recognized")
+	}
+	if FingerprintKey(1) == FingerprintKey(2) || FingerprintKey(1) == TaskKey(1, 0, "alice@example.org") {
+		t.Error("expected fingerprint tasks to have distinct keys")
+	}
+}
+
+func TestGuardrails(t *testing.T) {
+	g := db.Guardrails{MaxLockoutRate: 5, Tripwires: []string{"canary@example.org"}}
+
+	if !isTripwire(g, "canary@example.org") || isTripwire(g, "alice@example.org") {
+		t.Error("unexpected tripwire match")
+	}
+
+	type testcase struct {
+		name     string
+		attempts int64
+		locked   int64
+		tripped  bool
+	}
+	testcases := []testcase{
+		{"below threshold", 100, 5, false},
+		{"above threshold", 100, 6, true},
+		{"too few attempts", GuardrailMinAttempts - 1, 10, false},
+	}
+	for _, test := range testcases {
+		if lockoutTripped(g, test.attempts, test.locked) != test.tripped {
+			t.Errorf("[%s] expected tripped to be %t", test.name, test.tripped)
+		}
+	}
+	if lockoutTripped(db.Guardrails{}, 100, 100) {
+		t.Error("expected a disabled lockout guardrail never to trip")
+	}
+
+	validation := []struct {
+		name       string
+		guardrails db.Guardrails
+		valid      bool
+	}{
+		{"no guardrails", db.Guardrails{}, true},
+		{"abort", db.Guardrails{MaxConsecutiveRateLimited: 3, GuardrailAction: db.GuardrailAbort}, true},
+		{"rate above 100%", db.Guardrails{MaxLockoutRate: 101}, false},
+		{"unknown action", db.Guardrails{GuardrailAction: "explode"}, false},
+		{"skip on captcha", db.Guardrails{OnCaptcha: db.CaptchaSkip}, true},
+		{"unknown captcha action", db.Guardrails{OnCaptcha: "solve"}, false},
+	}
+	for _, test := range validation {
+		err := test.guardrails.Validate()
+		if (err == nil) != test.valid {
+			t.Errorf("[%s] unexpected validation result: %v", test.name, err)
+		}
+	}
+}
+
+func TestPresets(t *testing.T) {
+	campaign := db.Campaign{Limits: db.Limits{MaxInFlight: 5}}
+	err := ApplyPreset(&campaign)
+	if err != nil || campaign.ScheduleInterval != 0 || campaign.MaxAttemptsPerUser != 0 {
+		t.Fatalf("expected a campaign without a preset to be left as is, got %+v (%v)", campaign, err)
+	}
+
+	campaign.Preset = "ad-default"
+	err = ApplyPreset(&campaign)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if campaign.ScheduleInterval != 35*time.Minute || campaign.UserWindow != 35*time.Minute ||
+		campaign.MaxAttemptsPerUser != 1 || campaign.MaxInFlight != 5 {
+		t.Errorf("unexpected campaign pace %+v", campaign)
+	}
+
+	for _, p := range Presets {
+		campaign := db.Campaign{Preset: p.Name}
+		err := ApplyPreset(&campaign)
+		if err != nil || campaign.Limits.Validate() != nil {
+			t.Errorf("expected preset %s to yield valid limits", p.Name)
+		}
+	}
+
+	campaign.Preset = "fast"
+	if ApplyPreset(&campaign) == nil {
+		t.Error("expected an unknown preset to be rejected")
+	}
+}
+
+func TestAccountCooldown(t *testing.T) {
+	now := time.Now()
+	if ttl := cooldownTTL(now.Add(-time.Hour), 24*time.Hour, now); ttl != 23*time.Hour {
+		t.Errorf("expected a locked out account to be held off for 23h, got %s", ttl)
+	}
+	if ttl := cooldownTTL(now.Add(-48*time.Hour), 24*time.Hour, now); ttl != 0 {
+		t.Errorf("expected the cooldown to have expired, got %s", ttl)
+	}
+
+	// accounts are shared by every campaign, whatever the case of the
+	// username
+	if lockedKey("Alice@Example.org") != lockedKey("alice@example.org") {
+		t.Error("expected usernames to share the key of their account")
+	}
+}
+
+func TestSkipTTL(t *testing.T) {
+	now := time.Now()
+	if ttl := skipTTL(&db.Task{NotAfter: now.Add(time.Hour)}, now); ttl != time.Hour {
+		t.Errorf("expected the user to be skipped until the window closes, got %s", ttl)
+	}
+	if ttl := skipTTL(&db.Task{NotAfter: now.Add(-time.Hour)}, now); ttl != 0 {
+		t.Errorf("expected nothing to skip once the window closed, got %s", ttl)
+	}
+	if ttl := skipTTL(nil, now); ttl != CaptchaSkipTTL {
+		t.Errorf("expected an unknown task to be skipped for %s, got %s", CaptchaSkipTTL, ttl)
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcpsecretmanager
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	secretmanager "google.golang.org/api/secretmanager/v1"
+
+	"github.com/praetorian-inc/trident/pkg/secrets"
+)
+
+// Driver implements the secrets.Driver interface.
+type Driver struct{}
+
+func init() {
+	secrets.Register("gcpsecretmanager", Driver{})
+}
+
+// New is used to create a Google Cloud Secret Manager secret store and
+// accepts the following configuration options:
+//
+// project
+//
+// The project of secrets referenced by their short name. Secrets may also be
+// referenced by their resource name, e.g. projects/example/secrets/db-dsn or
+// projects/example/secrets/db-dsn/versions/3.
+//
+// Secrets referenced without a version are read at their latest version.
+// Application default credentials are used to authenticate to Secret Manager.
+func (Driver) New(opts map[string]string) (secrets.Store, error) {
+	svc, err := secretmanager.NewService(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	return &Store{
+		Project:  opts["project"],
+		versions: svc.Projects.Secrets.Versions,
+	}, nil
+}
+
+// Store implements the secrets.Store interface for Google Cloud Secret
+// Manager.
+type Store struct {
+	// Project is the project of secrets referenced by their short name
+	Project string
+
+	versions *secretmanager.ProjectsSecretsVersionsService
+}
+
+// Get reads the referenced secret version.
+func (s *Store) Get(ctx context.Context, name string) (string, error) {
+	name, err := s.resource(name)
+	if err != nil {
+		return "", err
+	}
+	resp, err := s.versions.Access(name).Context(ctx).Do()
+	if err != nil {
+		return "", err
+	}
+	b, err := base64.StdEncoding.DecodeString(resp.Payload.Data)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// resource returns the resource name of the secret version referenced by
+// name.
+func (s *Store) resource(name string) (string, error) {
+	if !strings.HasPrefix(name, "projects/") {
+		if s.Project == "" {
+			return "", fmt.Errorf("secret %s requires the 'project' config parameter", name)
+		}
+		name = fmt.Sprintf("projects/%s/secrets/%s", s.Project, name)
+	}
+	if !strings.Contains(name, "/versions/") {
+		name += "/versions/latest"
+	}
+	return name, nil
+}
@@ -0,0 +1,288 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package secrets defines an interface for secret stores (e.g. HashiCorp
+// Vault) from which configuration secrets are fetched at runtime, so that they
+// do not live in environment variables or Terraform state. Configuration
+// values of the form "secret:<name>" are references to the secret <name> of
+// the configured store. Similar to the nozzle package, drivers register
+// themselves and must be "blank imported".
+//
+//  import (
+//      "github.com/praetorian-inc/trident/pkg/secrets"
+//
+//      _ "github.com/praetorian-inc/trident/pkg/secrets/gcpsecretmanager"
+//      _ "github.com/praetorian-inc/trident/pkg/secrets/vault"
+//  )
+//
+//  store, err := secrets.Open("vault", map[string]string{"address":"https://..."})
+//  if err != nil {
+//      // handle error
+//  }
+//  dsn, err := secrets.Resolve(ctx, store, "secret:trident/db#dsn")
+//  // ...
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Prefix marks a configuration value as a reference to a secret.
+const Prefix = "secret:"
+
+var (
+	driversMu sync.RWMutex
+	drivers   = make(map[string]Driver)
+)
+
+// Store is the interface that wraps fetching a secret by name.
+type Store interface {
+	Get(ctx context.Context, name string) (string, error)
+}
+
+// Driver is the interface that wraps creation of a Store.
+type Driver interface {
+	New(opts map[string]string) (Store, error)
+}
+
+// Options is a type alias for simple marshaling/unmarshaling of secret store
+// configuration options from the environment.
+type Options map[string]string
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface.
+func (opts *Options) UnmarshalText(text []byte) error {
+	return json.Unmarshal(text, (*map[string]string)(opts))
+}
+
+// Open opens a secret store specified by its driver name (e.g. vault) and
+// configures it via the provided opts argument. Each Store should document its
+// configuration options in its New() method.
+func Open(name string, opts map[string]string) (Store, error) {
+	driversMu.RLock()
+	d, ok := drivers[name]
+	driversMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("secrets: unknown driver %q (forgotten import?)", name)
+	}
+
+	return d.New(opts)
+}
+
+// Register makes a secret store driver available at the provided name. If
+// register is called twice or if the driver is nil, if panics.
+func Register(name string, driver Driver) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	if driver == nil {
+		panic("secrets: Register driver is nil")
+	}
+	if _, dup := drivers[name]; dup {
+		panic("secrets: Register called twice for driver " + name)
+	}
+	drivers[name] = driver
+}
+
+// IsReference returns true if the value is a reference to a secret.
+func IsReference(value string) bool {
+	return strings.HasPrefix(value, Prefix)
+}
+
+// Resolve returns the secret referenced by the value, or the value itself if
+// it is not a reference. store may be nil if no store is configured, in which
+// case references are an error.
+func Resolve(ctx context.Context, store Store, value string) (string, error) {
+	if !IsReference(value) {
+		return value, nil
+	}
+	name := strings.TrimPrefix(value, Prefix)
+	if store == nil {
+		return "", fmt.Errorf("secret %s is referenced but no secret store is configured", name)
+	}
+	secret, err := store.Get(ctx, name)
+	if err != nil {
+		return "", fmt.Errorf("error fetching secret %s: %w", name, err)
+	}
+	return secret, nil
+}
+
+// ResolveOptions returns a copy of the options with references replaced by
+// their secrets (e.g. the provider metadata of a task).
+func ResolveOptions(ctx context.Context, store Store, opts map[string]string) (map[string]string, error) {
+	resolved := make(map[string]string, len(opts))
+	for k, v := range opts {
+		secret, err := Resolve(ctx, store, v)
+		if err != nil {
+			return nil, err
+		}
+		resolved[k] = secret
+	}
+	return resolved, nil
+}
+
+// InScope returns true if the secret name begins with the prefix. An empty
+// prefix scopes no secrets.
+func InScope(name, prefix string) bool {
+	return prefix != "" && strings.HasPrefix(name, prefix) && !strings.Contains(name, "..")
+}
+
+// CheckOptions returns an error if any of the options reference a secret
+// outside of the prefix (see InScope). It is used to reject user supplied
+// configuration, such as the provider metadata of a campaign, that names
+// secrets it should not be able to read.
+func CheckOptions(opts map[string]string, prefix string) error {
+	for k, v := range opts {
+		if !IsReference(v) {
+			continue
+		}
+		name := strings.TrimPrefix(v, Prefix)
+		if !InScope(name, prefix) {
+			return fmt.Errorf("option %s references secret %s outside of the allowed prefix %q", k, name, prefix)
+		}
+	}
+	return nil
+}
+
+// Expand replaces the references in the string, []byte and map[string]string
+// fields of the struct pointed to by spec (e.g. the configuration of a
+// command read with envconfig) with their secrets.
+func Expand(ctx context.Context, store Store, spec interface{}) error {
+	v := reflect.ValueOf(spec)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("secrets: Expand requires a pointer to a struct, got %T", spec)
+	}
+	v = v.Elem()
+
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+		if !f.CanSet() {
+			continue
+		}
+		switch {
+		case f.Kind() == reflect.String:
+			secret, err := Resolve(ctx, store, f.String())
+			if err != nil {
+				return err
+			}
+			f.SetString(secret)
+		case f.Kind() == reflect.Slice && f.Type().Elem().Kind() == reflect.Uint8:
+			secret, err := Resolve(ctx, store, string(f.Bytes()))
+			if err != nil {
+				return err
+			}
+			f.SetBytes([]byte(secret))
+		case f.Kind() == reflect.Map && f.Type().Key().Kind() == reflect.String &&
+			f.Type().Elem().Kind() == reflect.String:
+			iter := f.MapRange()
+			for iter.Next() {
+				secret, err := Resolve(ctx, store, iter.Value().String())
+				if err != nil {
+					return err
+				}
+				f.SetMapIndex(iter.Key(), reflect.ValueOf(secret).Convert(f.Type().Elem()))
+			}
+		}
+	}
+	return nil
+}
+
+// Load opens the named secret store and expands the references of spec (see
+// Expand). If name is empty, no store is opened and nil is returned, but
+// references in spec are still an error.
+func Load(name string, opts map[string]string, spec interface{}) (Store, error) {
+	var store Store
+	if name != "" {
+		var err error
+		store, err = Open(name, opts)
+		if err != nil {
+			return nil, fmt.Errorf("error opening secret store: %w", err)
+		}
+	}
+	return store, Expand(context.Background(), store, spec)
+}
+
+// Cache wraps a Store, keeping fetched secrets for a TTL so that frequently
+// resolved secrets (e.g. those of every task) are not fetched every time.
+type Cache struct {
+	Store Store
+	TTL   time.Duration
+
+	mu      sync.Mutex
+	secrets map[string]cachedSecret
+}
+
+type cachedSecret struct {
+	value   string
+	expires time.Time
+}
+
+// NewCache returns a Cache of the store, or nil if the store is nil.
+func NewCache(store Store, ttl time.Duration) Store {
+	if store == nil {
+		return nil
+	}
+	return &Cache{Store: store, TTL: ttl}
+}
+
+// Get implements the Store interface.
+func (c *Cache) Get(ctx context.Context, name string) (string, error) {
+	c.mu.Lock()
+	s, ok := c.secrets[name]
+	c.mu.Unlock()
+	if ok && time.Now().Before(s.expires) {
+		return s.value, nil
+	}
+
+	value, err := c.Store.Get(ctx, name)
+	if err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	if c.secrets == nil {
+		c.secrets = make(map[string]cachedSecret)
+	}
+	c.secrets[name] = cachedSecret{value: value, expires: time.Now().Add(c.TTL)}
+	c.mu.Unlock()
+	return value, nil
+}
+
+// Scoped wraps a Store, refusing to fetch secrets outside of a prefix (see
+// InScope). Workers resolve the references of task configuration through it so
+// that a campaign cannot have arbitrary secrets of the store sent to its target.
+type Scoped struct {
+	Store  Store
+	Prefix string
+}
+
+// NewScoped returns a Scoped store, or nil if the store is nil.
+func NewScoped(store Store, prefix string) Store {
+	if store == nil {
+		return nil
+	}
+	return &Scoped{Store: store, Prefix: prefix}
+}
+
+// Get implements the Store interface.
+func (s *Scoped) Get(ctx context.Context, name string) (string, error) {
+	if !InScope(name, s.Prefix) {
+		return "", fmt.Errorf("secret %s is outside of the allowed prefix %q", name, s.Prefix)
+	}
+	return s.Store.Get(ctx, name)
+}
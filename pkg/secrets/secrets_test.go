@@ -0,0 +1,164 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// mapStore is a Store backed by a map, counting the secrets fetched.
+type mapStore struct {
+	secrets map[string]string
+	fetched int
+}
+
+func (m *mapStore) Get(ctx context.Context, name string) (string, error) {
+	m.fetched++
+	s, ok := m.secrets[name]
+	if !ok {
+		return "", fmt.Errorf("secret not found")
+	}
+	return s, nil
+}
+
+func TestExpand(t *testing.T) {
+	store := &mapStore{secrets: map[string]string{"db": "postgres://trident:hunter2@db/trident", "key": "k1"}}
+
+	type spec struct {
+		DSN      string
+		LogLevel string
+		Token    []byte
+		Empty    []byte
+		Config   Options
+		Port     int
+		private  string
+	}
+	s := spec{
+		DSN:      "secret:db",
+		LogLevel: "INFO",
+		Token:    []byte("secret:key"),
+		Config:   Options{"api_key": "secret:key", "region": "us"},
+		private:  "secret:missing",
+	}
+	err := Expand(context.Background(), store, &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.DSN != "postgres://trident:hunter2@db/trident" || s.LogLevel != "INFO" || string(s.Token) != "k1" ||
+		s.Config["api_key"] != "k1" || s.Config["region"] != "us" || s.private != "secret:missing" {
+		t.Errorf("unexpected expanded spec %+v", s)
+	}
+
+	s.DSN = "secret:missing"
+	if Expand(context.Background(), store, &s) == nil {
+		t.Error("expected a missing secret to be an error")
+	}
+	if Expand(context.Background(), nil, &spec{DSN: "secret:db"}) == nil {
+		t.Error("expected a reference without a store to be an error")
+	}
+	if Expand(context.Background(), nil, &spec{DSN: "postgres://db"}) != nil {
+		t.Error("expected a spec without references to be left as is without a store")
+	}
+	if Expand(context.Background(), store, s) == nil {
+		t.Error("expected a non-pointer spec to be an error")
+	}
+}
+
+func TestResolveOptions(t *testing.T) {
+	store := &mapStore{secrets: map[string]string{"okta/client": "s3cr3t"}}
+	opts := map[string]string{"subdomain": "example", "client_secret": "secret:okta/client"}
+
+	resolved, err := ResolveOptions(context.Background(), store, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolved["client_secret"] != "s3cr3t" || resolved["subdomain"] != "example" {
+		t.Errorf("unexpected resolved options %v", resolved)
+	}
+	if opts["client_secret"] != "secret:okta/client" {
+		t.Error("expected the options not to be modified")
+	}
+}
+
+func TestCache(t *testing.T) {
+	store := &mapStore{secrets: map[string]string{"key": "k1"}}
+	cache := NewCache(store, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		v, err := cache.Get(context.Background(), "key")
+		if err != nil || v != "k1" {
+			t.Fatalf("unexpected secret %q (%v)", v, err)
+		}
+	}
+	if store.fetched != 1 {
+		t.Errorf("expected the secret to be fetched once, got %d", store.fetched)
+	}
+
+	cache.(*Cache).TTL = 0
+	cache.(*Cache).secrets["key"] = cachedSecret{value: "k1", expires: time.Now()}
+	_, err := cache.Get(context.Background(), "key")
+	if err != nil || store.fetched != 2 {
+		t.Errorf("expected an expired secret to be fetched again")
+	}
+
+	if NewCache(nil, time.Minute) != nil {
+		t.Error("expected no cache without a store")
+	}
+}
+
+func TestScoped(t *testing.T) {
+	store := &mapStore{secrets: map[string]string{"trident/providers/okta": "s3cr3t", "trident/db": "dsn"}}
+	scoped := NewScoped(store, "trident/providers/")
+
+	v, err := scoped.Get(context.Background(), "trident/providers/okta")
+	if err != nil || v != "s3cr3t" {
+		t.Errorf("unexpected secret %q (%v)", v, err)
+	}
+	for _, name := range []string{"trident/db", "trident/providers/../db"} {
+		_, err = scoped.Get(context.Background(), name)
+		if err == nil {
+			t.Errorf("expected secret %s outside of the prefix to be refused", name)
+		}
+	}
+	_, err = NewScoped(store, "").Get(context.Background(), "trident/providers/okta")
+	if err == nil {
+		t.Error("expected an empty prefix to refuse every secret")
+	}
+	if store.fetched != 1 {
+		t.Errorf("expected only the scoped secret to be fetched, got %d", store.fetched)
+	}
+}
+
+func TestCheckOptions(t *testing.T) {
+	tests := []struct {
+		opts   map[string]string
+		prefix string
+		ok     bool
+	}{
+		{map[string]string{"subdomain": "example"}, "", true},
+		{map[string]string{"client_secret": "secret:trident/providers/okta"}, "trident/providers/", true},
+		{map[string]string{"client_secret": "secret:trident/db"}, "trident/providers/", false},
+		{map[string]string{"client_secret": "secret:trident/providers/okta"}, "", false},
+	}
+	for _, test := range tests {
+		err := CheckOptions(test.opts, test.prefix)
+		if (err == nil) != test.ok {
+			t.Errorf("CheckOptions(%v, %q) = %v, expected ok=%v", test.opts, test.prefix, err, test.ok)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/praetorian-inc/trident/pkg/secrets"
+)
+
+// DefaultKey is the key of a secret read when a reference does not name one.
+const DefaultKey = "value"
+
+// Driver implements the secrets.Driver interface.
+type Driver struct{}
+
+func init() {
+	secrets.Register("vault", Driver{})
+}
+
+// New is used to create a HashiCorp Vault secret store, reading secrets from
+// a KV version 2 secrets engine, and accepts the following configuration
+// options:
+//
+// address
+//
+// The address of the Vault server (default VAULT_ADDR).
+//
+// token
+//
+// The Vault token (default VAULT_TOKEN).
+//
+// token_file
+//
+// A file containing the Vault token, e.g. the sink of a Vault agent. The file
+// is read for every secret so that the agent may renew the token. It takes
+// precedence over token.
+//
+// mount
+//
+// The mount path of the KV secrets engine (default "secret").
+//
+// namespace
+//
+// The Vault Enterprise namespace of the secrets engine.
+//
+// Secrets are referenced as "<path>#<key>", e.g. "trident/db#dsn". The key
+// defaults to DefaultKey.
+func (Driver) New(opts map[string]string) (secrets.Store, error) {
+	address := opts["address"]
+	if address == "" {
+		address = os.Getenv("VAULT_ADDR")
+	}
+	if address == "" {
+		return nil, fmt.Errorf("vault secret store requires 'address' config parameter")
+	}
+	u, err := url.Parse(address)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("vault address must be an http(s) URL, got %q", address)
+	}
+
+	token := opts["token"]
+	if token == "" {
+		token = os.Getenv("VAULT_TOKEN")
+	}
+	if token == "" && opts["token_file"] == "" {
+		return nil, fmt.Errorf("vault secret store requires 'token' or 'token_file' config parameter")
+	}
+
+	mount := opts["mount"]
+	if mount == "" {
+		mount = "secret"
+	}
+
+	return &Store{
+		Address:   strings.TrimSuffix(address, "/"),
+		Token:     token,
+		TokenFile: opts["token_file"],
+		Mount:     strings.Trim(mount, "/"),
+		Namespace: opts["namespace"],
+	}, nil
+}
+
+// Store implements the secrets.Store interface for HashiCorp Vault.
+type Store struct {
+	// Address is the address of the Vault server
+	Address string
+
+	// Token authenticates to Vault, unless TokenFile is set
+	Token string
+
+	// TokenFile is read for the token of every request if set
+	TokenFile string
+
+	// Mount is the mount path of the KV version 2 secrets engine
+	Mount string
+
+	// Namespace is the Vault Enterprise namespace, if any
+	Namespace string
+}
+
+type kvResponse struct {
+	Data struct {
+		Data map[string]interface{} `json:"data"`
+	} `json:"data"`
+}
+
+// Get reads the key of a secret referenced as "<path>#<key>".
+func (s *Store) Get(ctx context.Context, name string) (string, error) {
+	path, key := name, DefaultKey
+	if i := strings.LastIndex(name, "#"); i >= 0 {
+		path, key = name[:i], name[i+1:]
+	}
+
+	token := s.Token
+	if s.TokenFile != "" {
+		b, err := ioutil.ReadFile(s.TokenFile)
+		if err != nil {
+			return "", fmt.Errorf("error reading vault token: %w", err)
+		}
+		token = strings.TrimSpace(string(b))
+	}
+
+	u := fmt.Sprintf("%s/v1/%s/data/%s", s.Address, s.Mount, strings.Trim(path, "/"))
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if s.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", s.Namespace)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status code from vault: %d", resp.StatusCode)
+	}
+
+	var kv kvResponse
+	err = json.NewDecoder(resp.Body).Decode(&kv)
+	if err != nil {
+		return "", fmt.Errorf("error decoding vault response: %w", err)
+	}
+	value, ok := kv.Data.Data[key].(string)
+	if !ok {
+		return "", fmt.Errorf("vault secret %s has no string key %q", path, key)
+	}
+	return value, nil
+}
@@ -0,0 +1,103 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/praetorian-inc/trident/pkg/secrets"
+)
+
+func TestGet(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "s.token" {
+			w.WriteHeader(403)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/kv/data/trident/db":
+			w.Write([]byte(`{"data":{"data":{"dsn":"postgres://db","value":"default"}}}`)) // nolint:errcheck
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer srv.Close()
+
+	store, err := secrets.Open("vault", map[string]string{"address": srv.URL, "token": "s.token", "mount": "kv"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var testcases = []struct {
+		name      string
+		value     string
+		expecterr bool
+	}{
+		{"trident/db#dsn", "postgres://db", false},
+		{"trident/db", "default", false},
+		{"trident/db#missing", "", true},
+		{"trident/missing#dsn", "", true},
+	}
+	for _, test := range testcases {
+		v, err := store.Get(context.Background(), test.name)
+		if (err != nil) != test.expecterr || v != test.value {
+			t.Errorf("[%s] unexpected secret %q (%v)", test.name, v, err)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "vault")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint:errcheck
+	tokenFile := filepath.Join(dir, "token")
+	err = ioutil.WriteFile(tokenFile, []byte("s.token\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = secrets.Open("vault", map[string]string{"address": srv.URL, "token_file": tokenFile, "mount": "kv"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := store.Get(context.Background(), "trident/db#dsn")
+	if err != nil || v != "postgres://db" {
+		t.Errorf("unexpected secret %q with a token file (%v)", v, err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	var testcases = []struct {
+		desc      string
+		opts      map[string]string
+		expecterr bool
+	}{
+		{"token", map[string]string{"address": "https://vault.example.org", "token": "s.token"}, false},
+		{"token file", map[string]string{"address": "https://vault.example.org", "token_file": "/vault/token"}, false},
+		{"invalid address", map[string]string{"address": "vault.example.org", "token": "s.token"}, true},
+	}
+	for _, test := range testcases {
+		_, err := secrets.Open("vault", test.opts)
+		if (err != nil) != test.expecterr {
+			t.Errorf("[%s] unexpected error: %v", test.desc, err)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/middleware"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/praetorian-inc/trident/pkg/auth"
+	"github.com/praetorian-inc/trident/pkg/auth/rbac"
+	"github.com/praetorian-inc/trident/pkg/db"
+	"github.com/praetorian-inc/trident/pkg/parse"
+)
+
+// the value recorded in place of redacted parameters
+const redacted = "[redacted]"
+
+// MaxAuditSize is the largest request body accepted by audited calls, as the
+// body is read in full to record the parameters of the call.
+const MaxAuditSize = 32 << 20
+
+// Audit returns a middleware recording each call to the wrapped handler in
+// the audit log, along with the authenticated principal, the parameters of
+// the call and the returned status code. denied calls are recorded as well.
+//
+// the entry is written once the handler has responded, so that its status is
+// known, and a failed write is logged rather than failing the call: an
+// unavailable audit log must not prevent operators from pausing or cancelling
+// a campaign.
+func (s *Server) Audit(action string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var body []byte
+			if r.Body != nil {
+				var err error
+				body, err = ioutil.ReadAll(http.MaxBytesReader(w, r.Body, MaxAuditSize))
+				if err != nil && len(body) == MaxAuditSize {
+					http.Error(w, http.StatusText(413), 413)
+					return
+				}
+				if err != nil {
+					http.Error(w, http.StatusText(400), 400)
+					return
+				}
+				r.Body.Close() // nolint:errcheck,gosec
+				r.Body = ioutil.NopCloser(bytes.NewReader(body))
+			}
+
+			start := time.Now()
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r)
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+
+			params, campaignID := auditParams(body, r.URL.Query())
+			entry := &db.AuditEntry{
+				Timestamp:  start,
+				Action:     action,
+				Method:     r.Method,
+				Path:       r.URL.Path,
+				CampaignID: campaignID,
+				Params:     params,
+				Status:     status,
+				RemoteAddr: r.RemoteAddr,
+			}
+			entry.Principal, entry.Role = s.auditPrincipal(r)
+
+			err := s.DB.InsertAuditEntry(entry)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"action":    action,
+					"principal": entry.Principal,
+				}).Errorf("error recording audit entry: %s", err)
+			}
+		})
+	}
+}
+
+// auditPrincipal returns the identity and role of the operator making the
+// request. the role is empty if the operator has none.
+func (s *Server) auditPrincipal(r *http.Request) (string, string) {
+	identity, _ := auth.FromContext(r.Context())
+	if s.Policy == nil {
+		return identity, string(rbac.RoleAdmin)
+	}
+	p, err := s.Policy.Principal(identity)
+	if err != nil {
+		return identity, ""
+	}
+	return identity, string(p.Role)
+}
+
+// auditParams returns the parameters of a call, from its JSON body or its
+// query string, with passwords and secrets redacted. the campaign targeted by
+// the call is returned if the parameters identify one.
+func auditParams(body []byte, query map[string][]string) (json.RawMessage, uint) {
+	params := make(map[string]interface{})
+	if len(bytes.TrimSpace(body)) > 0 {
+		if json.Unmarshal(body, &params) != nil {
+			params = map[string]interface{}{"body": redacted}
+		}
+	}
+	for k, v := range query {
+		if len(v) == 1 {
+			params[k] = v[0]
+		} else {
+			params[k] = v
+		}
+	}
+
+	campaignID := auditCampaign(params)
+	if filter, ok := params["Filter"].(map[string]interface{}); ok && campaignID == 0 {
+		campaignID = auditCampaign(filter)
+	}
+
+	redactParams(params)
+	data, err := json.Marshal(params)
+	if err != nil {
+		return json.RawMessage("{}"), campaignID
+	}
+	return data, campaignID
+}
+
+// auditCampaign returns the campaign ID found in the parameters, or 0.
+func auditCampaign(params map[string]interface{}) uint {
+	for _, key := range []string{"campaign_id", "CampaignID", "ID", "id"} {
+		switch v := params[key].(type) {
+		case float64:
+			if v > 0 {
+				return uint(v)
+			}
+		case string:
+			var id uint
+			if json.Unmarshal([]byte(v), &id) == nil {
+				return id
+			}
+		}
+	}
+	return 0
+}
+
+// redactParams replaces the values of password and secret parameters in place,
+// recursively. lists of passwords are replaced by their length.
+func redactParams(params map[string]interface{}) {
+	for k, v := range params {
+		key := strings.ToLower(k)
+		if strings.Contains(key, "password") || strings.Contains(key, "secret") ||
+			strings.Contains(key, "token") {
+			if list, ok := v.([]interface{}); ok {
+				params[k] = fmt.Sprintf("[%d redacted]", len(list))
+			} else {
+				params[k] = redacted
+			}
+			continue
+		}
+		if m, ok := v.(map[string]interface{}); ok {
+			redactParams(m)
+		}
+	}
+}
+
+// AuditHandler returns the audit log entries matching a user defined filter
+// via JSON. only admins may read the audit log.
+func (s *Server) AuditHandler(w http.ResponseWriter, r *http.Request) {
+	var q db.AuditQuery
+
+	_, ok := s.authorize(w, r, rbac.RoleAdmin)
+	if !ok {
+		return
+	}
+
+	err := parse.DecodeJSONBody(w, r, &q)
+	if err != nil {
+		var mr *parse.MalformedRequest
+		if errors.As(err, &mr) {
+			http.Error(w, mr.Msg, mr.Status)
+		} else {
+			log.Errorf("unknown error decoding json: %s", err)
+			http.Error(w, http.StatusText(500), 500)
+		}
+		return
+	}
+
+	entries, err := s.DB.SelectAuditEntries(q)
+	if err != nil {
+		log.Printf("error querying database: %s", err)
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(&entries)
+	if err != nil {
+		log.Errorf("error encoding audit entries: %s", err)
+		return
+	}
+}
@@ -0,0 +1,301 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/praetorian-inc/trident/pkg/auth"
+	"github.com/praetorian-inc/trident/pkg/auth/rbac"
+	"github.com/praetorian-inc/trident/pkg/db"
+	"github.com/praetorian-inc/trident/pkg/kms"
+	"github.com/praetorian-inc/trident/pkg/redact"
+)
+
+// authorize returns the principal making the request if it holds at least the
+// provided role. if not, an error response is written and false is returned.
+// when no policy is configured, every request is treated as an admin.
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request, role rbac.Role) (rbac.Principal, bool) {
+	if s.Policy == nil {
+		return rbac.Admin, true
+	}
+
+	identity, ok := auth.FromContext(r.Context())
+	if !ok {
+		http.Error(w, http.StatusText(401), 401)
+		return rbac.Principal{}, false
+	}
+
+	p, err := s.Policy.Principal(identity)
+	if err != nil || !p.Has(role) {
+		log.WithFields(log.Fields{
+			"identity": identity,
+			"role":     role,
+		}).Warn("access denied")
+		http.Error(w, http.StatusText(403), 403)
+		return rbac.Principal{}, false
+	}
+
+	return p, true
+}
+
+// visibleCampaigns returns the campaigns the principal is allowed to access.
+func (s *Server) visibleCampaigns(p rbac.Principal) ([]db.Campaign, error) {
+	campaigns, err := s.DB.ListCampaign()
+	if err != nil {
+		return nil, err
+	}
+	if p.Role == rbac.RoleAdmin {
+		return campaigns, nil
+	}
+
+	var visible []db.Campaign
+	for _, c := range campaigns {
+		if p.CanAccess(c.Team) {
+			visible = append(visible, c)
+		}
+	}
+	return visible, nil
+}
+
+// visibleCampaign returns the campaign with the given ID, or nil if it does
+// not exist or the principal is not allowed to access it.
+func (s *Server) visibleCampaign(p rbac.Principal, id uint) (*db.Campaign, error) {
+	campaigns, err := s.visibleCampaigns(p)
+	if err != nil {
+		return nil, err
+	}
+	for i := range campaigns {
+		if campaigns[i].ID == id {
+			return &campaigns[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// scopeFilter restricts a results filter to the campaigns the principal is
+// allowed to access. false is returned if the filter cannot match any
+// accessible campaign.
+func (s *Server) scopeFilter(p rbac.Principal, filter map[string]interface{}) (map[string]interface{}, bool, error) {
+	if p.Role == rbac.RoleAdmin {
+		return filter, true, nil
+	}
+
+	campaigns, err := s.visibleCampaigns(p)
+	if err != nil {
+		return nil, false, err
+	}
+	allowed := make(map[uint]bool, len(campaigns))
+	for _, c := range campaigns {
+		allowed[c.ID] = true
+	}
+
+	if filter == nil {
+		filter = make(map[string]interface{})
+	}
+
+	// intersect any requested campaigns with the accessible campaigns
+	var requested []interface{}
+	switch v := filter["campaign_id"].(type) {
+	case nil:
+		for id := range allowed {
+			requested = append(requested, id)
+		}
+	case []interface{}:
+		requested = v
+	default:
+		requested = []interface{}{v}
+	}
+
+	var ids []uint
+	for _, v := range requested {
+		var id uint
+		switch n := v.(type) {
+		case float64:
+			id = uint(n)
+		case uint:
+			id = n
+		default:
+			continue
+		}
+		if allowed[id] {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, false, nil
+	}
+
+	filter["campaign_id"] = ids
+	return filter, true, nil
+}
+
+// unsealResults decrypts the passwords, captured sessions and captured
+// responses of results for operators, and reveals the credentials of redacted
+// campaigns. read-only users never receive decrypted passwords, sessions or
+// responses, and only receive the tokens of redacted credentials: results
+// stored before they could be redacted are withheld their credentials.
+func (s *Server) unsealResults(ctx context.Context, p rbac.Principal, results []db.Result) error {
+	if s.Envelope != nil && p.Has(rbac.RoleOperator) {
+		err := redact.Reveal(ctx, s.DB, s.Envelope, results)
+		if err != nil {
+			return err
+		}
+	}
+	modes, err := s.redactionModes(p)
+	if err != nil {
+		return err
+	}
+	for i := range results {
+		if mode := modes[results[i].CampaignID]; mode != db.RedactionNone {
+			if !redact.IsToken(results[i].Password) {
+				results[i].Password = ""
+			}
+			if mode.Usernames() && !redact.IsToken(results[i].Username) {
+				results[i].Username = ""
+			}
+		}
+		// sessions and responses are always sealed, so they are withheld
+		// if they cannot be unsealed
+		if s.Envelope == nil {
+			results[i].Session = ""
+			results[i].Response = ""
+			continue
+		}
+		err := s.unseal(ctx, p, &results[i].Password)
+		if err != nil {
+			return err
+		}
+		err = s.unseal(ctx, p, &results[i].Session)
+		if err != nil {
+			return err
+		}
+		err = s.unseal(ctx, p, &results[i].Response)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unseal decrypts a single password in place for operators and clears it for
+// read-only users.
+func (s *Server) unseal(ctx context.Context, p rbac.Principal, password *string) error {
+	if s.Envelope == nil || !kms.IsSealed(*password) {
+		return nil
+	}
+	if !p.Has(rbac.RoleOperator) {
+		*password = ""
+		return nil
+	}
+	plaintext, err := s.Envelope.Unseal(ctx, *password)
+	if err != nil {
+		return err
+	}
+	*password = plaintext
+	return nil
+}
+
+// sealPasswords encrypts the passwords of a campaign before it is stored, if
+// password encryption is enabled.
+func (s *Server) sealPasswords(ctx context.Context, passwords []string) ([]string, error) {
+	if s.Envelope == nil || !s.EncryptPasswords {
+		return passwords, nil
+	}
+	sealed := make([]string, len(passwords))
+	for i, password := range passwords {
+		var err error
+		sealed[i], err = s.Envelope.Seal(ctx, password)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sealed, nil
+}
+
+// unsealCampaign decrypts the passwords of a campaign for operators and
+// withholds sealed passwords from read-only users.
+func (s *Server) unsealCampaign(ctx context.Context, p rbac.Principal, c *db.Campaign) error {
+	var passwords []string
+	for i := range c.Passwords {
+		password := c.Passwords[i]
+		sealed := kms.IsSealed(password)
+		err := s.unseal(ctx, p, &password)
+		if err != nil {
+			return err
+		}
+		if sealed && password == "" {
+			continue
+		}
+		passwords = append(passwords, password)
+	}
+	c.Passwords = passwords
+	return nil
+}
+
+// redactCampaign withholds the credentials configured in a redacted campaign
+// from read-only users: its passwords and, if usernames are redacted too, its
+// users.
+func redactCampaign(p rbac.Principal, c *db.Campaign) {
+	if p.Has(rbac.RoleOperator) || c.Redaction == db.RedactionNone {
+		return
+	}
+	c.Passwords = nil
+	c.PasswordRules = nil
+	if c.Redaction.Usernames() {
+		c.Users = nil
+		c.Names = nil
+		c.Excluded = nil
+		c.Tripwires = nil
+	}
+}
+
+// redactedUsernames returns the campaigns whose usernames are withheld from
+// the principal, or nil for operators.
+func (s *Server) redactedUsernames(p rbac.Principal) (map[uint]bool, error) {
+	modes, err := s.redactionModes(p)
+	if err != nil || modes == nil {
+		return nil, err
+	}
+	hidden := make(map[uint]bool)
+	for id, mode := range modes {
+		if mode.Usernames() {
+			hidden[id] = true
+		}
+	}
+	return hidden, nil
+}
+
+// redactionModes returns the redaction modes of the redacted campaigns
+// visible to the principal, or nil for operators.
+func (s *Server) redactionModes(p rbac.Principal) (map[uint]db.RedactionMode, error) {
+	if p.Has(rbac.RoleOperator) {
+		return nil, nil
+	}
+	campaigns, err := s.visibleCampaigns(p)
+	if err != nil {
+		return nil, err
+	}
+	modes := make(map[uint]db.RedactionMode)
+	for _, c := range campaigns {
+		if c.Redaction != db.RedactionNone {
+			modes[c.ID] = c.Redaction
+		}
+	}
+	return modes, nil
+}
@@ -17,13 +17,26 @@ package server
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
+	"github.com/praetorian-inc/trident/pkg/action"
+	"github.com/praetorian-inc/trident/pkg/auth/rbac"
+	"github.com/praetorian-inc/trident/pkg/auth/token"
+	"github.com/praetorian-inc/trident/pkg/credentials"
 	"github.com/praetorian-inc/trident/pkg/db"
+	"github.com/praetorian-inc/trident/pkg/detection"
+	"github.com/praetorian-inc/trident/pkg/kms"
 	"github.com/praetorian-inc/trident/pkg/parse"
+	"github.com/praetorian-inc/trident/pkg/redact"
+	"github.com/praetorian-inc/trident/pkg/retry"
 	"github.com/praetorian-inc/trident/pkg/scheduler"
+	"github.com/praetorian-inc/trident/pkg/secrets"
+	"github.com/praetorian-inc/trident/pkg/stream"
+	"github.com/praetorian-inc/trident/pkg/usernames"
 )
 
 // Server carries context for the http handlers to work from. it keeps track of
@@ -31,6 +44,35 @@ import (
 type Server struct {
 	DB  db.Datastore
 	Sch scheduler.Scheduler
+
+	// Policy maps operators to roles and teams. if nil, access control is
+	// disabled and every operator may access every campaign.
+	Policy *rbac.Policy
+
+	// Vault stores discovered credentials. if nil, the credential vault is
+	// disabled.
+	Vault *credentials.Vault
+
+	// Envelope decrypts result passwords for operators. if nil, passwords
+	// are assumed to be stored in plaintext.
+	Envelope *kms.Envelope
+
+	// EncryptPasswords seals the passwords of campaigns with the Envelope
+	// before they are stored, like the passwords of their tasks and results.
+	EncryptPasswords bool
+
+	// Hub streams results as they are consumed. if nil, result streaming is
+	// disabled.
+	Hub *stream.Hub
+
+	// Ingest verifies the signature of results posted by workers. if nil,
+	// result ingestion over HTTP is disabled.
+	Ingest *token.Verifier
+
+	// SecretPrefix is the prefix of the secrets that the provider metadata
+	// and actions of campaigns may reference. if empty, campaigns may not
+	// reference secrets.
+	SecretPrefix string
 }
 
 // HealthzHandler is for k8s health checking, this always returns 200
@@ -41,9 +83,20 @@ func (s *Server) HealthzHandler(w http.ResponseWriter, r *http.Request) {}
 // schedules the campaign.
 func (s *Server) CampaignHandler(w http.ResponseWriter, r *http.Request) {
 	log.Info("creating campaign")
-	var c db.Campaign
+	var req struct {
+		db.Campaign
+
+		// MaxRetries shadows the campaign's, to tell an omitted limit
+		// (which defaults to the retry policy's) from no retries
+		MaxRetries *int `json:"max_retries"`
+	}
+
+	p, ok := s.authorize(w, r, rbac.RoleOperator)
+	if !ok {
+		return
+	}
 
-	err := parse.DecodeJSONBody(w, r, &c)
+	err := parse.DecodeJSONBody(w, r, &req)
 	if err != nil {
 		var mr *parse.MalformedRequest
 		if errors.As(err, &mr) {
@@ -55,7 +108,130 @@ func (s *Server) CampaignHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	c := req.Campaign
+	c.MaxRetries = retry.DefaultPolicy.MaxRetries
+	if req.MaxRetries != nil {
+		c.MaxRetries = *req.MaxRetries
+	}
+	if c.MaxRetries < 0 {
+		http.Error(w, "max_retries must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	// campaigns default to the operator's team if they only belong to one
+	if c.Team == "" && len(p.Teams) == 1 {
+		c.Team = p.Teams[0]
+	}
+	if !p.CanAccess(c.Team) {
+		http.Error(w, "campaign team is not accessible", http.StatusForbidden)
+		return
+	}
+
+	switch c.Mode {
+	case db.CampaignModeSpray:
+	case db.CampaignModeDetection:
+		// detection campaigns must never attempt real passwords, so the
+		// requested passwords are replaced with known-bad passwords
+		n := len(c.Passwords)
+		if n == 0 {
+			n = detection.DefaultAttempts
+		}
+		c.Passwords, err = detection.Passwords(n)
+		if err != nil {
+			log.Errorf("error generating detection passwords: %s", err)
+			http.Error(w, http.StatusText(500), 500)
+			return
+		}
+		c.PasswordRules = nil
+	default:
+		http.Error(w, fmt.Sprintf("unknown campaign mode %q", c.Mode), http.StatusBadRequest)
+		return
+	}
+
+	_, err = scheduler.Passwords(c)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = scheduler.ApplyPreset(&c)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = c.Limits.Validate()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = c.Guardrails.Validate()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = usernames.ValidateExclusions(c.Excluded)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = c.Redaction.Validate()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if c.Redaction != db.RedactionNone {
+		if s.Envelope == nil {
+			http.Error(w, "redaction requires a key manager", http.StatusBadRequest)
+			return
+		}
+		c.RedactionKey, err = redact.NewKey(r.Context(), s.Envelope)
+		if err != nil {
+			log.Errorf("error generating redaction key: %s", err)
+			http.Error(w, http.StatusText(500), 500)
+			return
+		}
+	}
+
+	for _, a := range c.Actions {
+		_, err = action.Open(a.Name, a.Options)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	err = s.checkSecrets(c)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// the fingerprint is only recorded by the campaign's fingerprint task
+	c.Fingerprint = db.Fingerprint{}
+
+	generated, err := usernames.Generate(c.Names, c.UsernameFormats)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.Users = appendUnique(c.Users, generated)
+
+	// passwords are sealed at rest like the passwords of results, while the
+	// campaign is scheduled with the plaintext passwords
+	passwords := c.Passwords
+	c.Passwords, err = s.sealPasswords(r.Context(), passwords)
+	if err != nil {
+		log.Errorf("error encrypting campaign passwords: %s", err)
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+
 	err = s.DB.InsertCampaign(&c)
+	c.Passwords = passwords
 	if err != nil {
 		log.WithFields(log.Fields{
 			"campaign": c,
@@ -75,11 +251,55 @@ func (s *Server) CampaignHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// checkSecrets returns an error if the provider metadata or actions of the
+// campaign reference secrets outside of the server's prefix, which workers
+// would otherwise resolve and send to the campaign's target.
+func (s *Server) checkSecrets(c db.Campaign) error {
+	var opts map[string]string
+	if len(c.ProviderMetadata) > 0 {
+		err := json.Unmarshal(c.ProviderMetadata, &opts)
+		if err != nil {
+			return fmt.Errorf("invalid provider metadata: %w", err)
+		}
+	}
+	err := secrets.CheckOptions(opts, s.SecretPrefix)
+	if err != nil {
+		return fmt.Errorf("provider metadata: %w", err)
+	}
+	for _, a := range c.Actions {
+		err = secrets.CheckOptions(a.Options, s.SecretPrefix)
+		if err != nil {
+			return fmt.Errorf("action %s: %w", a.Name, err)
+		}
+	}
+	return nil
+}
+
+// appendUnique appends the values missing from a list.
+func appendUnique(list []string, values []string) []string {
+	seen := make(map[string]bool, len(list))
+	for _, v := range list {
+		seen[v] = true
+	}
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
 // ResultsHandler takes a user defined database query (returned fields + filter)
 // and applies it, returning the results in JSON
 func (s *Server) ResultsHandler(w http.ResponseWriter, r *http.Request) {
 	var q db.Query
 
+	p, ok := s.authorize(w, r, rbac.RoleReadOnly)
+	if !ok {
+		return
+	}
+
 	err := parse.DecodeJSONBody(w, r, &q)
 	if err != nil {
 		var mr *parse.MalformedRequest
@@ -92,12 +312,29 @@ func (s *Server) ResultsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	results, err := s.DB.SelectResults(q)
+	q.Filter, ok, err = s.scopeFilter(p, q.Filter)
+	if err != nil {
+		log.Printf("error querying database: %s", err)
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+
+	results := []db.Result{}
+	if ok {
+		results, err = s.DB.SelectResults(q)
+	}
 	if err != nil {
 		log.Printf("error querying database: %s", err)
 		http.Error(w, http.StatusText(500), 500)
 	}
 
+	err = s.unsealResults(r.Context(), p, results)
+	if err != nil {
+		log.Errorf("error decrypting results: %s", err)
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+
 	err = json.NewEncoder(w).Encode(&results)
 	if err != nil {
 		log.WithFields(log.Fields{
@@ -112,11 +349,25 @@ func (s *Server) ResultsHandler(w http.ResponseWriter, r *http.Request) {
 func (s *Server) CampaignListHandler(w http.ResponseWriter, r *http.Request) {
 	var campaigns []db.Campaign
 
-	campaigns, err := s.DB.ListCampaign()
+	p, ok := s.authorize(w, r, rbac.RoleReadOnly)
+	if !ok {
+		return
+	}
+
+	campaigns, err := s.visibleCampaigns(p)
 	if err != nil {
 		log.Printf("error querying database: %s", err)
 		http.Error(w, http.StatusText(500), 500)
 	}
+	for i := range campaigns {
+		err = s.unsealCampaign(r.Context(), p, &campaigns[i])
+		if err != nil {
+			log.Errorf("error decrypting campaign: %s", err)
+			http.Error(w, http.StatusText(500), 500)
+			return
+		}
+		redactCampaign(p, &campaigns[i])
+	}
 
 	err = json.NewEncoder(w).Encode(&campaigns)
 	if err != nil {
@@ -133,6 +384,11 @@ func (s *Server) CampaignDescribeHandler(w http.ResponseWriter, r *http.Request)
 	var q db.Query
 	var campaign db.Campaign
 
+	p, ok := s.authorize(w, r, rbac.RoleReadOnly)
+	if !ok {
+		return
+	}
+
 	err := parse.DecodeJSONBody(w, r, &q)
 	if err != nil {
 		var mr *parse.MalformedRequest
@@ -151,6 +407,18 @@ func (s *Server) CampaignDescribeHandler(w http.ResponseWriter, r *http.Request)
 		http.Error(w, http.StatusText(500), 500)
 	}
 
+	if !p.CanAccess(campaign.Team) {
+		http.Error(w, http.StatusText(404), 404)
+		return
+	}
+	err = s.unsealCampaign(r.Context(), p, &campaign)
+	if err != nil {
+		log.Errorf("error decrypting campaign: %s", err)
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+	redactCampaign(p, &campaign)
+
 	err = json.NewEncoder(w).Encode(&campaign)
 	if err != nil {
 		log.WithFields(log.Fields{
@@ -160,6 +428,117 @@ func (s *Server) CampaignDescribeHandler(w http.ResponseWriter, r *http.Request)
 	}
 }
 
+// RateWindow is the window used to compute the current attempt rate of a
+// campaign.
+const RateWindow = 5 * time.Minute
+
+// progress computes the progress of a single campaign.
+func (s *Server) progress(c db.Campaign) (db.CampaignProgress, error) {
+	now := time.Now()
+	p, err := s.DB.CampaignProgress(c.ID, now.Add(-RateWindow))
+	if err != nil {
+		return p, err
+	}
+
+	p.Provider = c.Provider
+	p.Status = c.Status
+	if p.Status == "" {
+		p.Status = db.CampaignStatusActive
+	}
+	p.Scheduled = scheduler.ScheduledTasks(c)
+	p.Rate = float64(p.Recent) / RateWindow.Minutes()
+
+	var last time.Time
+	p.Pending, last, err = s.Sch.Pending(c.ID)
+	if err != nil || p.Pending == 0 {
+		return p, err
+	}
+
+	// the schedule is the best estimate unless the campaign has fallen
+	// behind it, in which case the current rate is used
+	p.ProjectedCompletion = last
+	if last.Before(now) && p.Rate > 0 {
+		p.ProjectedCompletion = now.Add(time.Duration(float64(p.Pending) / p.Rate * float64(time.Minute)))
+	}
+	if p.ProjectedCompletion.After(c.NotAfter) {
+		p.ProjectedCompletion = c.NotAfter
+	}
+	return p, nil
+}
+
+// CampaignProgressHandler takes an optional campaign ID and returns the
+// progress of that campaign (or of every visible campaign) via JSON
+func (s *Server) CampaignProgressHandler(w http.ResponseWriter, r *http.Request) {
+	var q struct {
+		ID uint
+	}
+
+	p, ok := s.authorize(w, r, rbac.RoleReadOnly)
+	if !ok {
+		return
+	}
+
+	err := parse.DecodeJSONBody(w, r, &q)
+	if err != nil {
+		var mr *parse.MalformedRequest
+		if errors.As(err, &mr) {
+			http.Error(w, mr.Msg, mr.Status)
+		} else {
+			log.Errorf("unknown error decoding json: %s", err)
+			http.Error(w, http.StatusText(500), 500)
+		}
+		return
+	}
+
+	campaigns, err := s.visibleCampaigns(p)
+	if err != nil {
+		log.Printf("error querying database: %s", err)
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+
+	progress := []db.CampaignProgress{}
+	for _, c := range campaigns {
+		if q.ID != 0 && c.ID != q.ID {
+			continue
+		}
+		// the campaign list omits the schedule, so load the full campaign
+		c, err = s.DB.DescribeCampaign(db.Query{
+			Filter: map[string]interface{}{"id": c.ID},
+		})
+		if err != nil {
+			log.Printf("error querying database: %s", err)
+			http.Error(w, http.StatusText(500), 500)
+			return
+		}
+		// the scheduled tasks are counted from the plaintext passwords
+		err = s.unsealCampaign(r.Context(), rbac.Admin, &c)
+		if err != nil {
+			log.Errorf("error decrypting campaign: %s", err)
+			http.Error(w, http.StatusText(500), 500)
+			return
+		}
+		cp, err := s.progress(c)
+		if err != nil {
+			log.Printf("error computing campaign progress: %s", err)
+			http.Error(w, http.StatusText(500), 500)
+			return
+		}
+		progress = append(progress, cp)
+	}
+
+	if q.ID != 0 && len(progress) == 0 {
+		http.Error(w, http.StatusText(404), 404)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(&progress)
+	if err != nil {
+		log.Errorf("error encoding campaign progress: %s", err)
+		return
+	}
+}
+
 // StatusUpdateHandler takes a campaignID from the user, then
 // sets its status based on the post body content.
 func (s *Server) StatusUpdateHandler(w http.ResponseWriter, r *http.Request) {
@@ -170,6 +549,11 @@ func (s *Server) StatusUpdateHandler(w http.ResponseWriter, r *http.Request) {
 
 	var postBody StatusUpdateHandler
 
+	p, ok := s.authorize(w, r, rbac.RoleOperator)
+	if !ok {
+		return
+	}
+
 	err := parse.DecodeJSONBody(w, r, &postBody)
 	if err != nil {
 		var mr *parse.MalformedRequest
@@ -182,6 +566,16 @@ func (s *Server) StatusUpdateHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if p.Role != rbac.RoleAdmin {
+		campaign, err := s.DB.DescribeCampaign(db.Query{
+			Filter: map[string]interface{}{"id": postBody.ID},
+		})
+		if err != nil || !p.CanAccess(campaign.Team) {
+			http.Error(w, http.StatusText(404), 404)
+			return
+		}
+	}
+
 	err = s.DB.UpdateCampaignStatus(postBody.ID, postBody.Status)
 	if err != nil {
 		log.Printf("error updating database: %s", err)
@@ -190,3 +584,263 @@ func (s *Server) StatusUpdateHandler(w http.ResponseWriter, r *http.Request) {
 
 	log.Infof("campaign id=%d status has been set to %s", postBody.ID, postBody.Status)
 }
+
+// CredentialsHandler returns the credentials stored in the vault via JSON.
+// passwords are only decrypted if requested by an operator.
+func (s *Server) CredentialsHandler(w http.ResponseWriter, r *http.Request) {
+	var q struct {
+		Reveal bool
+	}
+
+	p, ok := s.authorize(w, r, rbac.RoleReadOnly)
+	if !ok {
+		return
+	}
+
+	err := parse.DecodeJSONBody(w, r, &q)
+	if err != nil {
+		var mr *parse.MalformedRequest
+		if errors.As(err, &mr) {
+			http.Error(w, mr.Msg, mr.Status)
+		} else {
+			log.Errorf("unknown error decoding json: %s", err)
+			http.Error(w, http.StatusText(500), 500)
+		}
+		return
+	}
+
+	if s.Vault == nil {
+		http.Error(w, "credential vault is not configured", http.StatusNotImplemented)
+		return
+	}
+	if q.Reveal && !p.Has(rbac.RoleOperator) {
+		http.Error(w, http.StatusText(403), 403)
+		return
+	}
+
+	creds, err := s.DB.ListCredentials()
+	if err != nil {
+		log.Printf("error querying database: %s", err)
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+
+	hidden, err := s.redactedUsernames(p)
+	if err != nil {
+		log.Printf("error querying database: %s", err)
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+
+	visible := []db.Credential{}
+	for _, c := range creds {
+		if !p.CanAccess(c.Team) {
+			continue
+		}
+		if hidden[c.LastCampaignID] {
+			c.Username = ""
+		}
+		visible = append(visible, c)
+	}
+
+	if q.Reveal {
+		err = s.Vault.Reveal(r.Context(), visible)
+		if err != nil {
+			log.Errorf("error revealing credentials: %s", err)
+			http.Error(w, http.StatusText(500), 500)
+			return
+		}
+		log.WithFields(log.Fields{
+			"identity": p.Identity,
+			"count":    len(visible),
+		}).Info("revealed credentials")
+	}
+
+	err = json.NewEncoder(w).Encode(&visible)
+	if err != nil {
+		log.Errorf("error encoding credentials: %s", err)
+		return
+	}
+}
+
+// FailedTasksHandler takes a user defined database filter and returns the
+// matching tasks from the dead-letter table via JSON
+func (s *Server) FailedTasksHandler(w http.ResponseWriter, r *http.Request) {
+	var q db.Query
+
+	p, ok := s.authorize(w, r, rbac.RoleReadOnly)
+	if !ok {
+		return
+	}
+
+	err := parse.DecodeJSONBody(w, r, &q)
+	if err != nil {
+		var mr *parse.MalformedRequest
+		if errors.As(err, &mr) {
+			http.Error(w, mr.Msg, mr.Status)
+		} else {
+			log.Errorf("unknown error decoding json: %s", err)
+			http.Error(w, http.StatusText(500), 500)
+		}
+		return
+	}
+
+	q.Filter, ok, err = s.scopeFilter(p, q.Filter)
+	if err != nil {
+		log.Printf("error querying database: %s", err)
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+
+	tasks := []db.FailedTask{}
+	if ok {
+		tasks, err = s.DB.SelectFailedTasks(q)
+		if err != nil {
+			log.Printf("error querying database: %s", err)
+			http.Error(w, http.StatusText(500), 500)
+			return
+		}
+	}
+
+	hidden, err := s.redactedUsernames(p)
+	if err != nil {
+		log.Printf("error querying database: %s", err)
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+
+	for i := range tasks {
+		err = s.unseal(r.Context(), p, &tasks[i].Task.Password)
+		if err != nil {
+			log.Errorf("error decrypting failed task: %s", err)
+			http.Error(w, http.StatusText(500), 500)
+			return
+		}
+		if hidden[tasks[i].CampaignID] {
+			tasks[i].Username = ""
+			tasks[i].Task.Username = ""
+		}
+	}
+
+	err = json.NewEncoder(w).Encode(&tasks)
+	if err != nil {
+		log.Errorf("error encoding failed tasks: %s", err)
+		return
+	}
+}
+
+// RequeueHandler takes a list of failed task IDs (or a campaign ID to requeue
+// all of its failed tasks), schedules them again and removes them from the
+// dead-letter table. tasks of campaigns whose window has ended are not
+// requeued. the number of requeued and expired tasks is returned via JSON
+func (s *Server) RequeueHandler(w http.ResponseWriter, r *http.Request) {
+	var q struct {
+		IDs        []uint
+		CampaignID uint
+	}
+
+	p, ok := s.authorize(w, r, rbac.RoleOperator)
+	if !ok {
+		return
+	}
+
+	err := parse.DecodeJSONBody(w, r, &q)
+	if err != nil {
+		var mr *parse.MalformedRequest
+		if errors.As(err, &mr) {
+			http.Error(w, mr.Msg, mr.Status)
+		} else {
+			log.Errorf("unknown error decoding json: %s", err)
+			http.Error(w, http.StatusText(500), 500)
+		}
+		return
+	}
+
+	filter := map[string]interface{}{}
+	switch {
+	case len(q.IDs) > 0:
+		filter["id"] = q.IDs
+	case q.CampaignID != 0:
+		filter["campaign_id"] = q.CampaignID
+	default:
+		http.Error(w, "either IDs or CampaignID is required", http.StatusBadRequest)
+		return
+	}
+
+	filter, ok, err = s.scopeFilter(p, filter)
+	if err != nil {
+		log.Printf("error querying database: %s", err)
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+
+	failed := []db.FailedTask{}
+	if ok {
+		failed, err = s.DB.SelectFailedTasks(db.Query{Filter: filter})
+		if err != nil {
+			log.Printf("error querying database: %s", err)
+			http.Error(w, http.StatusText(500), 500)
+			return
+		}
+	}
+
+	// tasks are requeued per campaign, and those of campaigns whose window
+	// has ended are left in the dead-letter table
+	byCampaign := make(map[uint][]db.FailedTask)
+	var order []uint
+	for _, f := range failed {
+		if _, ok := byCampaign[f.CampaignID]; !ok {
+			order = append(order, f.CampaignID)
+		}
+		byCampaign[f.CampaignID] = append(byCampaign[f.CampaignID], f)
+	}
+
+	var requeued, expired int
+	now := time.Now()
+	for _, id := range order {
+		// revalidation tasks belong to no campaign and get a window of
+		// their own
+		campaign := db.Campaign{NotAfter: now.Add(scheduler.RevalidationWindow)}
+		if id != 0 {
+			campaign, err = s.DB.DescribeCampaign(db.Query{Filter: map[string]interface{}{"id": id}})
+			if err != nil {
+				log.Errorf("error reading campaign %d: %s", id, err)
+				http.Error(w, http.StatusText(500), 500)
+				return
+			}
+		}
+		if !now.Before(campaign.NotAfter) {
+			expired += len(byCampaign[id])
+			continue
+		}
+
+		tasks := make([]db.Task, 0, len(byCampaign[id]))
+		ids := make([]uint, 0, len(byCampaign[id]))
+		for _, f := range byCampaign[id] {
+			tasks = append(tasks, f.Task)
+			ids = append(ids, f.ID)
+		}
+
+		err = s.Sch.Requeue(campaign, tasks)
+		if err != nil {
+			log.Errorf("error requeueing tasks: %s", err)
+			http.Error(w, http.StatusText(500), 500)
+			return
+		}
+
+		err = s.DB.DeleteFailedTasks(ids)
+		if err != nil {
+			log.Errorf("error removing requeued tasks: %s", err)
+			http.Error(w, http.StatusText(500), 500)
+			return
+		}
+		requeued += len(tasks)
+	}
+
+	log.Infof("requeued %d failed tasks, %d of ended campaigns were not requeued", requeued, expired)
+	err = json.NewEncoder(w).Encode(map[string]int{"requeued": requeued, "expired": expired})
+	if err != nil {
+		log.Errorf("error encoding response: %s", err)
+		return
+	}
+}
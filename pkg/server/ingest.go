@@ -0,0 +1,72 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/praetorian-inc/trident/pkg/auth/token"
+	"github.com/praetorian-inc/trident/pkg/db"
+)
+
+// MaxIngestSize is the largest result accepted by the IngestHandler.
+const MaxIngestSize = 1 << 20
+
+// IngestHandler records a result posted by a worker. results must be signed
+// with a shared or per-worker key, which binds the signature to the body and a
+// timestamp so that results can neither be forged nor replayed. ingestion is
+// disabled (404) unless the server is configured with an Ingest verifier.
+func (s *Server) IngestHandler(w http.ResponseWriter, r *http.Request) {
+	if s.Ingest == nil {
+		http.Error(w, http.StatusText(404), 404)
+		return
+	}
+
+	// the body is read to verify its signature, bound it beforehand
+	r.Body = http.MaxBytesReader(w, r.Body, MaxIngestSize)
+	err := s.Ingest.Verify(r)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"key_id": r.Header.Get(token.KeyIDHeader),
+			"remote": r.RemoteAddr,
+		}).Warnf("rejected result: %s", err)
+		http.Error(w, http.StatusText(403), 403)
+		return
+	}
+
+	// results are decoded like results consumed from the queue, which may
+	// carry fields unknown to db.Result
+	var res db.Result
+	err = json.NewDecoder(r.Body).Decode(&res)
+	if err != nil {
+		http.Error(w, "request body contains malformed json", 400)
+		return
+	}
+
+	if res.CampaignID == 0 {
+		http.Error(w, "result requires a campaign_id", 400)
+		return
+	}
+
+	err = s.Sch.Ingest(r.Context(), res)
+	if err != nil {
+		log.Printf("error ingesting result: %s", err)
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/praetorian-inc/trident/pkg/auth/rbac"
+	"github.com/praetorian-inc/trident/pkg/db"
+	"github.com/praetorian-inc/trident/pkg/parse"
+	"github.com/praetorian-inc/trident/pkg/report"
+)
+
+// ReportHandler aggregates the results of the requested campaigns (or of
+// every accessible campaign) into a report, returned via JSON
+func (s *Server) ReportHandler(w http.ResponseWriter, r *http.Request) {
+	var q struct {
+		CampaignIDs []uint
+	}
+
+	p, ok := s.authorize(w, r, rbac.RoleReadOnly)
+	if !ok {
+		return
+	}
+
+	err := parse.DecodeJSONBody(w, r, &q)
+	if err != nil {
+		var mr *parse.MalformedRequest
+		if errors.As(err, &mr) {
+			http.Error(w, mr.Msg, mr.Status)
+		} else {
+			log.Errorf("unknown error decoding json: %s", err)
+			http.Error(w, http.StatusText(500), 500)
+		}
+		return
+	}
+
+	visible, err := s.visibleCampaigns(p)
+	if err != nil {
+		log.Printf("error querying database: %s", err)
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+
+	requested := make(map[uint]bool, len(q.CampaignIDs))
+	for _, id := range q.CampaignIDs {
+		requested[id] = true
+	}
+
+	var campaigns []db.Campaign
+	var ids []interface{}
+	for _, c := range visible {
+		if len(requested) > 0 && !requested[c.ID] {
+			continue
+		}
+		// the campaign list omits the schedule, so load the full campaign
+		c, err = s.DB.DescribeCampaign(db.Query{
+			Filter: map[string]interface{}{"id": c.ID},
+		})
+		if err != nil {
+			log.Printf("error querying database: %s", err)
+			http.Error(w, http.StatusText(500), 500)
+			return
+		}
+		campaigns = append(campaigns, c)
+		ids = append(ids, c.ID)
+	}
+
+	if len(campaigns) < len(requested) {
+		http.Error(w, http.StatusText(404), 404)
+		return
+	}
+
+	results := []db.Result{}
+	if len(ids) > 0 {
+		results, err = s.DB.SelectResults(db.Query{
+			ReturnedFields: []string{"*"},
+			Filter:         map[string]interface{}{"campaign_id": ids},
+		})
+		if err != nil {
+			log.Printf("error querying database: %s", err)
+			http.Error(w, http.StatusText(500), 500)
+			return
+		}
+	}
+
+	err = s.unsealResults(r.Context(), p, results)
+	if err != nil {
+		log.Errorf("error decrypting results: %s", err)
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(report.Build(campaigns, results))
+	if err != nil {
+		log.Errorf("error encoding report: %s", err)
+		return
+	}
+}
@@ -15,17 +15,42 @@
 package server
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/praetorian-inc/trident/pkg/auth"
+	"github.com/praetorian-inc/trident/pkg/auth/rbac"
+	"github.com/praetorian-inc/trident/pkg/auth/token"
 	"github.com/praetorian-inc/trident/pkg/db"
+	"github.com/praetorian-inc/trident/pkg/detection"
+	"github.com/praetorian-inc/trident/pkg/kms"
+	"github.com/praetorian-inc/trident/pkg/kms/local"
+	"github.com/praetorian-inc/trident/pkg/redact"
+	"github.com/praetorian-inc/trident/pkg/report"
+	"github.com/praetorian-inc/trident/pkg/stream"
+
+	_ "github.com/praetorian-inc/trident/pkg/action/smtpauth"
 )
 
-type mockDB struct{}
+type mockDB struct {
+	mu         sync.Mutex
+	audit      []db.AuditEntry
+	redactions []db.Redaction
+	campaigns  map[uint]db.Campaign
+	failed     []db.FailedTask
+	deleted    []uint
+	inserted   []db.Campaign
+}
 
 func (m *mockDB) IsCampaignCancelled(campaignID uint) (bool, error) {
 	//For now, always return false, but maybe we can make this return true for odd campaignIDs
@@ -33,6 +58,7 @@ func (m *mockDB) IsCampaignCancelled(campaignID uint) (bool, error) {
 }
 
 func (m *mockDB) InsertCampaign(c *db.Campaign) error {
+	m.inserted = append(m.inserted, *c)
 	return nil
 }
 
@@ -80,19 +106,66 @@ func (m *mockDB) SelectResults(q db.Query) ([]db.Result, error) {
 	return results, nil
 }
 
+func (m *mockDB) SelectRecentResults(ids []uint, since time.Time) ([]db.Result, error) {
+	return []db.Result{
+		{CampaignID: 0, IP: "192.0.2.1", Region: "us-central1", Timestamp: time.Now()},
+		{CampaignID: 0, IP: "192.0.2.2", Timestamp: time.Now(), Error: "timeout"},
+	}, nil
+}
+
 func (m *mockDB) InsertResult(r *db.Result) error {
 
 	return nil
 }
 
 func (m *mockDB) ListCampaign() ([]db.Campaign, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.campaigns != nil {
+		var campaigns []db.Campaign
+		for id, c := range m.campaigns {
+			c.ID = id
+			campaigns = append(campaigns, listed(c))
+		}
+		return campaigns, nil
+	}
 	return []db.Campaign{
 		{Provider: "okta", ProviderMetadata: json.RawMessage(`{"subdomain": "example"}`)},
 		{Provider: "adfs", ProviderMetadata: json.RawMessage(`{"domain": "adfs.example.com"}`)},
 	}, nil
 }
 
+// listed returns the columns of a campaign selected by db.ListColumns, like
+// the real ListCampaign.
+func listed(c db.Campaign) db.Campaign {
+	var l db.Campaign
+	for _, col := range db.ListColumns {
+		switch col {
+		case "id":
+			l.ID = c.ID
+		case "provider":
+			l.Provider = c.Provider
+		case "provider_metadata":
+			l.ProviderMetadata = c.ProviderMetadata
+		case "status":
+			l.Status = c.Status
+		case "team":
+			l.Team = c.Team
+		case "redaction":
+			l.Redaction = c.Redaction
+		case "created_at":
+			l.CreatedAt = c.CreatedAt
+		}
+	}
+	return l
+}
+
 func (m *mockDB) DescribeCampaign(query db.Query) (db.Campaign, error) {
+	if id, ok := query.Filter["id"].(uint); ok {
+		if c, ok := m.campaigns[id]; ok {
+			return c, nil
+		}
+	}
 	return db.Campaign{
 		Provider:         "okta",
 		ProviderMetadata: json.RawMessage(`{"subdomain":"example"}`),
@@ -100,16 +173,91 @@ func (m *mockDB) DescribeCampaign(query db.Query) (db.Campaign, error) {
 
 }
 
+func (m *mockDB) UpsertCredential(c *db.Credential) error {
+	return nil
+}
+
+func (m *mockDB) ListCredentials() ([]db.Credential, error) {
+	return []db.Credential{}, nil
+}
+
+func (m *mockDB) SelectStaleCredentials(before time.Time) ([]db.Credential, error) {
+	return []db.Credential{}, nil
+}
+
+func (m *mockDB) UpdateCredentialValidation(id uint, valid bool, ts time.Time) error {
+	return nil
+}
+
+func (m *mockDB) CampaignProgress(id uint, since time.Time) (db.CampaignProgress, error) {
+	return db.CampaignProgress{CampaignID: id}, nil
+}
+
+func (m *mockDB) InsertFailedTask(t *db.FailedTask) error {
+	return nil
+}
+
+func (m *mockDB) SelectFailedTasks(q db.Query) ([]db.FailedTask, error) {
+	return m.failed, nil
+}
+
+func (m *mockDB) DeleteFailedTasks(ids []uint) error {
+	m.deleted = append(m.deleted, ids...)
+	return nil
+}
+
+func (m *mockDB) SelectRedactions(tokens []string) ([]db.Redaction, error) {
+	var redactions []db.Redaction
+	for _, r := range m.redactions {
+		for _, token := range tokens {
+			if r.Token == token {
+				redactions = append(redactions, r)
+			}
+		}
+	}
+	return redactions, nil
+}
+
+func (m *mockDB) InsertAuditEntry(entry *db.AuditEntry) error {
+	m.audit = append(m.audit, *entry)
+	return nil
+}
+
+func (m *mockDB) SelectAuditEntries(q db.AuditQuery) ([]db.AuditEntry, error) {
+	return m.audit, nil
+}
+
 func (m *mockDB) Close() error {
 	return nil
 }
 
-type mockScheduler struct{}
+type mockScheduler struct {
+	ingested []db.Result
+	requeued []db.Task
+}
 
 func (m *mockScheduler) Schedule(c db.Campaign) error {
 	return nil
 }
 
+func (m *mockScheduler) Revalidate(creds []db.Credential) error {
+	return nil
+}
+
+func (m *mockScheduler) Pending(id uint) (int64, time.Time, error) {
+	return 0, time.Time{}, nil
+}
+
+func (m *mockScheduler) Requeue(c db.Campaign, tasks []db.Task) error {
+	m.requeued = append(m.requeued, tasks...)
+	return nil
+}
+
+func (m *mockScheduler) Ingest(ctx context.Context, res db.Result) error {
+	m.ingested = append(m.ingested, res)
+	return nil
+}
+
 func (m *mockScheduler) ProduceTasks() {
 }
 
@@ -211,6 +359,93 @@ func TestCampaignHandler(t *testing.T) {
 	}
 }
 
+func TestCampaignMaxRetries(t *testing.T) {
+	var tests = []struct {
+		body     string
+		expected int
+		status   int
+	}{
+		{`{"users":["alice"],"passwords":["Password1!"],"provider":"okta"}`, 3, http.StatusOK},
+		{`{"users":["alice"],"passwords":["Password1!"],"provider":"okta","max_retries":0}`, 0, http.StatusOK},
+		{`{"users":["alice"],"passwords":["Password1!"],"provider":"okta","max_retries":5}`, 5, http.StatusOK},
+		{`{"users":["alice"],"passwords":["Password1!"],"provider":"okta","max_retries":-1}`, 0,
+			http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		mdb := &mockDB{}
+		s := initServer()
+		s.DB = mdb
+
+		req, err := http.NewRequest("POST", "/campaign", strings.NewReader(test.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(s.CampaignHandler).ServeHTTP(rr, req)
+		if rr.Code != test.status {
+			t.Errorf("%s: got status %d, want %d", test.body, rr.Code, test.status)
+			continue
+		}
+		if test.status == http.StatusOK && mdb.inserted[0].MaxRetries != test.expected {
+			t.Errorf("%s: got max retries %d, want %d", test.body, mdb.inserted[0].MaxRetries, test.expected)
+		}
+	}
+}
+
+func TestCampaignPasswordsSealed(t *testing.T) {
+	keys, err := local.Driver{}.New(map[string]string{
+		"key": "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=",
+	})
+	if err != nil {
+		t.Fatalf("error creating key manager: %s", err)
+	}
+	mdb := &mockDB{}
+	s := initServer()
+	s.DB = mdb
+	s.Envelope = kms.NewEnvelope(keys)
+	s.EncryptPasswords = true
+
+	requestBody, err := json.Marshal(map[string]interface{}{
+		"not_before":        "2020-08-28T00:00:00Z",
+		"not_after":         "2020-08-29T00:00:00Z",
+		"schedule_interval": 500000000,
+		"users":             []string{"alice@example.org"},
+		"passwords":         []string{"Password1!"},
+		"provider":          "okta",
+		"provider_metadata": map[string]interface{}{"subdomain": "example"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest("POST", "/campaign", bytes.NewBuffer(requestBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(s.CampaignHandler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || len(mdb.inserted) != 1 {
+		t.Fatalf("campaign was not created: %d %s", rr.Code, rr.Body.String())
+	}
+
+	c := mdb.inserted[0]
+	if len(c.Passwords) != 1 || !kms.IsSealed(c.Passwords[0]) {
+		t.Fatalf("expected the stored passwords to be sealed, got %v", c.Passwords)
+	}
+
+	ctx := context.Background()
+	operator := c
+	err = s.unsealCampaign(ctx, rbac.Principal{Role: rbac.RoleOperator}, &operator)
+	if err != nil || len(operator.Passwords) != 1 || operator.Passwords[0] != "Password1!" {
+		t.Errorf("expected operators to receive the passwords, got %v (%v)", operator.Passwords, err)
+	}
+	reader := c
+	err = s.unsealCampaign(ctx, rbac.Principal{Role: rbac.RoleReadOnly}, &reader)
+	if err != nil || len(reader.Passwords) != 0 {
+		t.Errorf("expected read-only users not to receive the passwords, got %v (%v)", reader.Passwords, err)
+	}
+}
+
 func TestResultsHandler(t *testing.T) {
 	s := initServer()
 	requestBody, err := json.Marshal(map[string]interface{}{
@@ -239,3 +474,669 @@ func TestResultsHandler(t *testing.T) {
 			status, http.StatusOK)
 	}
 }
+
+func TestUnsealResults(t *testing.T) {
+	ctx := context.Background()
+	keys, err := local.Driver{}.New(map[string]string{
+		"key": "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=",
+	})
+	if err != nil {
+		t.Fatalf("error creating key manager: %s", err)
+	}
+	envelope := kms.NewEnvelope(keys)
+	session, err := envelope.Seal(ctx, `{"session_token":"abc"}`)
+	if err != nil {
+		t.Fatalf("error sealing session: %s", err)
+	}
+
+	s := initServer()
+	s.Envelope = envelope
+	results := []db.Result{{Password: "Password1!", Session: session}}
+	err = s.unsealResults(ctx, rbac.Principal{Role: rbac.RoleOperator}, results)
+	if err != nil || results[0].Session != `{"session_token":"abc"}` {
+		t.Errorf("expected operators to receive the session, got %q (%v)", results[0].Session, err)
+	}
+
+	results = []db.Result{{Session: session}}
+	err = s.unsealResults(ctx, rbac.Principal{Role: rbac.RoleReadOnly}, results)
+	if err != nil || results[0].Session != "" {
+		t.Errorf("expected read-only users not to receive the session, got %q (%v)", results[0].Session, err)
+	}
+
+	results = []db.Result{{Response: session}}
+	err = s.unsealResults(ctx, rbac.Principal{Role: rbac.RoleReadOnly}, results)
+	if err != nil || results[0].Response != "" {
+		t.Errorf("expected read-only users not to receive the response, got %q (%v)", results[0].Response, err)
+	}
+
+	s.Envelope = nil
+	results = []db.Result{{Session: session, Response: session}}
+	err = s.unsealResults(ctx, rbac.Admin, results)
+	if err != nil || results[0].Session != "" || results[0].Response != "" {
+		t.Errorf("expected sealed sessions and responses to be withheld without an envelope, got %q, %q",
+			results[0].Session, results[0].Response)
+	}
+}
+
+func TestRedaction(t *testing.T) {
+	ctx := context.Background()
+	keys, err := local.Driver{}.New(map[string]string{
+		"key": "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=",
+	})
+	if err != nil {
+		t.Fatalf("error creating key manager: %s", err)
+	}
+	envelope := kms.NewEnvelope(keys)
+	password, err := envelope.Seal(ctx, "Password1!")
+	if err != nil {
+		t.Fatalf("error sealing password: %s", err)
+	}
+
+	token := redact.Token([]byte("key"), redact.KindPassword, "Password1!")
+	s := initServer()
+	s.Envelope = envelope
+	s.DB = &mockDB{redactions: []db.Redaction{{Token: token, Kind: redact.KindPassword, Value: password}}}
+
+	results := []db.Result{{Username: "alice@example.org", Password: token}}
+	err = s.unsealResults(ctx, rbac.Principal{Role: rbac.RoleReadOnly}, results)
+	if err != nil || results[0].Password != token {
+		t.Errorf("expected read-only users to receive the token, got %q (%v)", results[0].Password, err)
+	}
+	err = s.unsealResults(ctx, rbac.Principal{Role: rbac.RoleOperator}, results)
+	if err != nil || results[0].Password != "Password1!" {
+		t.Errorf("expected operators to receive the password, got %q (%v)", results[0].Password, err)
+	}
+
+	// results stored before they could be redacted keep their credentials,
+	// which read-only users do not receive
+	s.DB = &mockDB{campaigns: map[uint]db.Campaign{1: {Team: "acme", Redaction: db.RedactionCredentials}}}
+	results = []db.Result{{CampaignID: 1, Username: "alice@example.org", Password: password, Valid: true}}
+	err = s.unsealResults(ctx, rbac.Principal{Role: rbac.RoleReadOnly, Teams: []string{"acme"}}, results)
+	if err != nil || results[0].Username != "" || results[0].Password != "" || !results[0].Valid {
+		t.Errorf("expected read-only users not to receive unredacted credentials, got %+v (%v)", results[0], err)
+	}
+	results = []db.Result{{CampaignID: 1, Username: "alice@example.org", Password: password}}
+	err = s.unsealResults(ctx, rbac.Principal{Role: rbac.RoleOperator}, results)
+	if err != nil || results[0].Username != "alice@example.org" || results[0].Password != "Password1!" {
+		t.Errorf("expected operators to receive unredacted credentials, got %+v (%v)", results[0], err)
+	}
+
+	c := db.Campaign{
+		Users:     []string{"alice@example.org"},
+		Passwords: []string{"Password1!"},
+		Redaction: db.RedactionPasswords,
+	}
+	redactCampaign(rbac.Principal{Role: rbac.RoleOperator}, &c)
+	if len(c.Passwords) != 1 {
+		t.Errorf("expected operators to receive the passwords of the campaign")
+	}
+	redactCampaign(rbac.Principal{Role: rbac.RoleReadOnly}, &c)
+	if len(c.Passwords) != 0 || len(c.Users) != 1 {
+		t.Errorf("expected read-only users to only receive the users, got %v and %v", c.Users, c.Passwords)
+	}
+	c.Redaction = db.RedactionCredentials
+	redactCampaign(rbac.Principal{Role: rbac.RoleReadOnly}, &c)
+	if len(c.Users) != 0 {
+		t.Errorf("expected read-only users not to receive the users, got %v", c.Users)
+	}
+}
+
+func TestCampaignHandlerRBAC(t *testing.T) {
+	s := initServer()
+	s.Policy = &rbac.Policy{
+		Users: map[string]rbac.Principal{
+			"reader@example.org":   {Role: rbac.RoleReadOnly, Teams: []string{"acme"}},
+			"operator@example.org": {Role: rbac.RoleOperator, Teams: []string{"acme"}},
+		},
+	}
+
+	var testcases = []struct {
+		identity string
+		team     string
+		status   int
+	}{
+		{"reader@example.org", "acme", http.StatusForbidden},
+		{"operator@example.org", "acme", http.StatusOK},
+		{"operator@example.org", "", http.StatusOK},
+		{"operator@example.org", "globex", http.StatusForbidden},
+		{"unknown@example.org", "acme", http.StatusForbidden},
+	}
+
+	for _, test := range testcases {
+		requestBody, err := json.Marshal(map[string]interface{}{
+			"provider": "okta",
+			"team":     test.team,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req, err := http.NewRequest("POST", "/campaign", bytes.NewBuffer(requestBody))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req = req.WithContext(auth.NewContext(req.Context(), test.identity))
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(s.CampaignHandler).ServeHTTP(rr, req)
+
+		if status := rr.Code; status != test.status {
+			t.Errorf("[%s, %q] handler returned wrong status code: got %v want %v",
+				test.identity, test.team, status, test.status)
+		}
+	}
+}
+
+func TestResultsStreamHandler(t *testing.T) {
+	s := initServer()
+	s.Hub = &stream.Hub{}
+
+	ts := httptest.NewServer(http.HandlerFunc(s.ResultsStreamHandler))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/results/stream?campaign_id=1&valid=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+
+	s.Hub.Publish(db.Result{CampaignID: 2, Username: "eve", Valid: true})
+	s.Hub.Publish(db.Result{CampaignID: 1, Username: "bob", Valid: false})
+	s.Hub.Publish(db.Result{CampaignID: 1, Username: "alice", Valid: true})
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		var res db.Result
+		err = json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &res)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Username != "alice" {
+			t.Errorf("expected only alice's result, got %s", res.Username)
+		}
+		return
+	}
+	t.Errorf("stream ended without a result: %v", scanner.Err())
+}
+
+func TestResultsStreamVisibility(t *testing.T) {
+	defer func(refresh time.Duration) { StreamRefresh = refresh }(StreamRefresh)
+	StreamRefresh = 10 * time.Millisecond
+
+	mdb := &mockDB{campaigns: map[uint]db.Campaign{
+		1: {Team: "acme"},
+		2: {Team: "other"},
+	}}
+	s := initServer()
+	s.DB = mdb
+	s.Hub = &stream.Hub{}
+	s.Policy = &rbac.Policy{Users: map[string]rbac.Principal{
+		"reader@example.org": {Role: rbac.RoleReadOnly, Teams: []string{"acme"}},
+	}}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(auth.NewContext(r.Context(), "reader@example.org"))
+		s.ResultsStreamHandler(w, r)
+	}))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/results/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	scanner := bufio.NewScanner(resp.Body)
+	next := func() string {
+		for scanner.Scan() {
+			line := scanner.Text()
+			if !strings.HasPrefix(line, "data: ") {
+				continue
+			}
+			var res db.Result
+			err = json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &res)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return res.Username
+		}
+		t.Fatalf("stream ended without a result: %v", scanner.Err())
+		return ""
+	}
+
+	s.Hub.Publish(db.Result{CampaignID: 2, Username: "eve"})
+	s.Hub.Publish(db.Result{CampaignID: 1, Username: "alice"})
+	if username := next(); username != "alice" {
+		t.Errorf("expected alice's result, got %s", username)
+	}
+
+	// campaign 2 is moved to the reader's team, and campaign 1 away from it,
+	// after the stream started
+	mdb.mu.Lock()
+	mdb.campaigns[1], mdb.campaigns[2] = db.Campaign{Team: "other"}, db.Campaign{Team: "acme"}
+	mdb.mu.Unlock()
+	time.Sleep(50 * time.Millisecond)
+
+	s.Hub.Publish(db.Result{CampaignID: 1, Username: "mallory"})
+	s.Hub.Publish(db.Result{CampaignID: 2, Username: "bob"})
+	if username := next(); username != "bob" {
+		t.Errorf("expected bob's result, got %s", username)
+	}
+}
+
+func TestReportHandler(t *testing.T) {
+	s := initServer()
+
+	type testcase struct {
+		desc   string
+		body   string
+		status int
+	}
+	testcases := []testcase{
+		{"every campaign", `{}`, http.StatusOK},
+		{"unknown campaign", `{"CampaignIDs": [5]}`, http.StatusNotFound},
+	}
+
+	for _, test := range testcases {
+		req, err := http.NewRequest("POST", "/report", strings.NewReader(test.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(s.ReportHandler).ServeHTTP(rr, req)
+
+		if rr.Code != test.status {
+			t.Errorf("[%s] handler returned wrong status code: got %v want %v",
+				test.desc, rr.Code, test.status)
+			continue
+		}
+		if rr.Code != http.StatusOK {
+			continue
+		}
+
+		var r report.Report
+		err = json.NewDecoder(rr.Body).Decode(&r)
+		if err != nil {
+			t.Fatalf("[%s] error decoding report: %s", test.desc, err)
+		}
+		if len(r.Campaigns) != 2 || r.Totals.Attempts != 1 {
+			t.Errorf("[%s] unexpected report %+v", test.desc, r)
+		}
+	}
+}
+
+func TestCampaignHandlerDetection(t *testing.T) {
+	s := initServer()
+
+	type testcase struct {
+		desc   string
+		mode   string
+		status int
+	}
+	testcases := []testcase{
+		{"detection campaign", "detection", http.StatusOK},
+		{"unknown mode", "unknown", http.StatusBadRequest},
+	}
+
+	for _, test := range testcases {
+		requestBody, err := json.Marshal(map[string]interface{}{
+			"not_before":     "2020-08-28T00:00:00Z",
+			"not_after":      "2020-08-29T00:00:00Z",
+			"mode":           test.mode,
+			"users":          []string{"alice@example.org"},
+			"passwords":      []string{"Password1", "Password2"},
+			"password_rules": []string{":"},
+			"provider":       "okta",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req, err := http.NewRequest("POST", "/campaign", bytes.NewBuffer(requestBody))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(s.CampaignHandler).ServeHTTP(rr, req)
+
+		if rr.Code != test.status {
+			t.Errorf("[%s] handler returned wrong status code: got %v want %v",
+				test.desc, rr.Code, test.status)
+			continue
+		}
+		if rr.Code != http.StatusOK {
+			continue
+		}
+
+		var c db.Campaign
+		err = json.NewDecoder(rr.Body).Decode(&c)
+		if err != nil {
+			t.Fatalf("[%s] error decoding campaign: %s", test.desc, err)
+		}
+		if len(c.Passwords) != 2 || len(c.PasswordRules) != 0 {
+			t.Errorf("[%s] unexpected passwords %v (rules %v)", test.desc, c.Passwords, c.PasswordRules)
+		}
+		for _, p := range c.Passwords {
+			if !strings.HasPrefix(p, detection.PasswordPrefix) {
+				t.Errorf("[%s] password %s is not a detection password", test.desc, p)
+			}
+		}
+	}
+}
+
+func TestEgressHandler(t *testing.T) {
+	s := initServer()
+
+	type testcase struct {
+		desc   string
+		body   string
+		status int
+	}
+	testcases := []testcase{
+		{"known campaign", `{"ID": 0}`, http.StatusOK},
+		{"unknown campaign", `{"ID": 5}`, http.StatusNotFound},
+	}
+
+	for _, test := range testcases {
+		req, err := http.NewRequest("POST", "/campaign/egress", strings.NewReader(test.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(s.EgressHandler).ServeHTTP(rr, req)
+
+		if rr.Code != test.status {
+			t.Errorf("[%s] handler returned wrong status code: got %v want %v",
+				test.desc, rr.Code, test.status)
+			continue
+		}
+		if rr.Code != http.StatusOK {
+			continue
+		}
+
+		var stats []report.EgressStats
+		err = json.NewDecoder(rr.Body).Decode(&stats)
+		if err != nil {
+			t.Fatalf("[%s] error decoding egress: %s", test.desc, err)
+		}
+	}
+}
+
+func TestWorkersHandler(t *testing.T) {
+	s := initServer()
+
+	req, err := http.NewRequest("GET", "/workers", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(s.WorkersHandler).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	var workers []report.WorkerHealth
+	err = json.NewDecoder(rr.Body).Decode(&workers)
+	if err != nil {
+		t.Fatalf("error decoding workers: %s", err)
+	}
+	if len(workers) != 2 || workers[0].Status != report.WorkerHealthy {
+		t.Errorf("unexpected workers %+v", workers)
+	}
+}
+
+func TestCampaignHandlerLimits(t *testing.T) {
+	s := initServer()
+	s.SecretPrefix = "trident/providers/"
+
+	type testcase struct {
+		desc   string
+		limits map[string]interface{}
+		status int
+	}
+	testcases := []testcase{
+		{"valid limits", map[string]interface{}{
+			"max_attempts_per_user": 1, "user_window": int64(time.Hour), "max_in_flight": 10,
+		}, http.StatusOK},
+		{"per user limit without window", map[string]interface{}{"max_attempts_per_user": 1}, http.StatusBadRequest},
+		{"negative limit", map[string]interface{}{"max_attempts_per_hour": -1}, http.StatusBadRequest},
+		{"preset", map[string]interface{}{"preset": "ad-default"}, http.StatusOK},
+		{"unknown preset", map[string]interface{}{"preset": "fast"}, http.StatusBadRequest},
+		{"exclusions", map[string]interface{}{"excluded": []string{"admin-*@example.org"}}, http.StatusOK},
+		{"invalid exclusion", map[string]interface{}{"excluded": []string{"[admin"}}, http.StatusBadRequest},
+		{"actions", map[string]interface{}{"actions": []map[string]interface{}{{"name": "smtp_auth"}}}, http.StatusOK},
+		{"unknown action", map[string]interface{}{"actions": []map[string]interface{}{{"name": "send_mail"}}},
+			http.StatusBadRequest},
+		{"redaction without key manager", map[string]interface{}{"redaction": "passwords"}, http.StatusBadRequest},
+		{"unknown redaction", map[string]interface{}{"redaction": "hash"}, http.StatusBadRequest},
+		{"provider secret", map[string]interface{}{
+			"provider_metadata": map[string]string{"api_key": "secret:trident/providers/okta"},
+		}, http.StatusOK},
+		{"provider secret outside prefix", map[string]interface{}{
+			"provider_metadata": map[string]string{"api_key": "secret:trident/db"},
+		}, http.StatusBadRequest},
+		{"action secret outside prefix", map[string]interface{}{"actions": []map[string]interface{}{
+			{"name": "smtp_auth", "options": map[string]string{"host": "secret:trident/db"}},
+		}}, http.StatusBadRequest},
+	}
+
+	for _, test := range testcases {
+		body := map[string]interface{}{
+			"not_before": "2020-08-28T00:00:00Z",
+			"not_after":  "2020-08-29T00:00:00Z",
+			"users":      []string{"alice@example.org"},
+			"passwords":  []string{"Password1"},
+			"provider":   "okta",
+		}
+		for k, v := range test.limits {
+			body[k] = v
+		}
+		requestBody, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req, err := http.NewRequest("POST", "/campaign", bytes.NewBuffer(requestBody))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(s.CampaignHandler).ServeHTTP(rr, req)
+
+		if rr.Code != test.status {
+			t.Errorf("[%s] handler returned wrong status code: got %v want %v",
+				test.desc, rr.Code, test.status)
+		}
+	}
+}
+
+func TestRequeueHandler(t *testing.T) {
+	active := db.Campaign{NotAfter: time.Now().Add(time.Hour)}
+	active.ID = 1
+	ended := db.Campaign{NotAfter: time.Now().Add(-time.Hour)}
+	ended.ID = 2
+
+	failed := make([]db.FailedTask, 3)
+	for i, campaignID := range []uint{1, 2, 1} {
+		failed[i].ID = uint(10 + i)
+		failed[i].CampaignID = campaignID
+		failed[i].Task.CampaignID = campaignID
+	}
+	mdb := &mockDB{campaigns: map[uint]db.Campaign{1: active, 2: ended}, failed: failed}
+	sch := &mockScheduler{}
+	s := initServer()
+	s.DB = mdb
+	s.Sch = sch
+
+	req, err := http.NewRequest("POST", "/tasks/errors/requeue", strings.NewReader(`{"IDs":[10,11,12]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(s.RequeueHandler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	var res map[string]int
+	err = json.NewDecoder(rr.Body).Decode(&res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res["requeued"] != 2 || res["expired"] != 1 {
+		t.Errorf("unexpected response %v", res)
+	}
+	if len(sch.requeued) != 2 || !reflect.DeepEqual(mdb.deleted, []uint{10, 12}) {
+		t.Errorf("expected only the tasks of the active campaign to be requeued, got %d (deleted %v)",
+			len(sch.requeued), mdb.deleted)
+	}
+}
+
+func TestIngestHandler(t *testing.T) {
+	sch := &mockScheduler{}
+	s := initServer()
+	s.Sch = sch
+	s.Ingest = token.NewVerifier("shared", time.Minute)
+	s.Ingest.Keys = map[string][]byte{"worker-1": []byte("secret-1")}
+
+	type testcase struct {
+		desc   string
+		signer *token.Signer
+		body   string
+		status int
+	}
+	testcases := []testcase{
+		{"shared key", &token.Signer{Secret: []byte("shared")},
+			`{"campaign_id":1,"username":"alice@example.org","valid":true}`, http.StatusOK},
+		{"per-worker key", &token.Signer{Secret: []byte("secret-1"), KeyID: "worker-1"},
+			`{"campaign_id":1,"username":"bob@example.org","valid":false}`, http.StatusOK},
+		{"unsigned", nil, `{"campaign_id":1,"username":"eve@example.org","valid":true}`, http.StatusForbidden},
+		{"wrong key", &token.Signer{Secret: []byte("other")},
+			`{"campaign_id":1,"username":"eve@example.org","valid":true}`, http.StatusForbidden},
+		{"missing campaign", &token.Signer{Secret: []byte("shared")},
+			`{"username":"eve@example.org","valid":true}`, http.StatusBadRequest},
+	}
+
+	for _, test := range testcases {
+		req, err := http.NewRequest("POST", "/results/ingest", bytes.NewBufferString(test.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.signer != nil {
+			if err = test.signer.Auth(req); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(s.IngestHandler).ServeHTTP(rr, req)
+
+		if rr.Code != test.status {
+			t.Errorf("[%s] handler returned wrong status code: got %v want %v",
+				test.desc, rr.Code, test.status)
+		}
+	}
+
+	if len(sch.ingested) != 2 || sch.ingested[0].Username != "alice@example.org" {
+		t.Errorf("unexpected ingested results %+v", sch.ingested)
+	}
+
+	// ingestion is disabled without a verifier
+	s.Ingest = nil
+	req, err := http.NewRequest("POST", "/results/ingest", bytes.NewBufferString(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(s.IngestHandler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestAudit(t *testing.T) {
+	mdb := &mockDB{}
+	s := initServer()
+	s.DB = mdb
+
+	requestBody := `{"ID":3,"Status":"halted","Passwords":["Password1","Password2"],"Filter":{"password":"x"}}`
+	req, err := http.NewRequest("POST", "/campaign/status", bytes.NewBufferString(requestBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = req.WithContext(auth.NewContext(req.Context(), "alice@example.org"))
+
+	var received string
+	handler := s.Audit("campaign.status")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the body remains readable by the audited handler
+		b, _ := ioutil.ReadAll(r.Body)
+		received = string(b)
+		http.Error(w, http.StatusText(403), 403)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if received != requestBody {
+		t.Errorf("handler received %q", received)
+	}
+	if len(mdb.audit) != 1 {
+		t.Fatalf("expected 1 audit entry, got %d", len(mdb.audit))
+	}
+	entry := mdb.audit[0]
+	if entry.Principal != "alice@example.org" || entry.Role != "admin" || entry.Action != "campaign.status" ||
+		entry.CampaignID != 3 || entry.Status != 403 {
+		t.Errorf("unexpected audit entry %+v", entry)
+	}
+
+	var params map[string]interface{}
+	err = json.Unmarshal(entry.Params, &params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params["Passwords"] != "[2 redacted]" || params["Filter"].(map[string]interface{})["password"] != "[redacted]" ||
+		params["Status"] != "halted" {
+		t.Errorf("unexpected audit params %s", entry.Params)
+	}
+
+	// oversized bodies are rejected before they reach the handler
+	req, err = http.NewRequest("POST", "/campaign", bytes.NewReader(make([]byte, MaxAuditSize+1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	called := false
+	rr := httptest.NewRecorder()
+	s.Audit("campaign.create")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})).ServeHTTP(rr, req)
+	if called || rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized body: handler called %t, status %d", called, rr.Code)
+	}
+
+	// only admins may read the audit log
+	s.Policy = &rbac.Policy{Users: map[string]rbac.Principal{
+		"bob@example.org": {Role: rbac.RoleOperator},
+	}}
+	req, err = http.NewRequest("POST", "/audit", bytes.NewBufferString(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = req.WithContext(auth.NewContext(req.Context(), "bob@example.org"))
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(s.AuditHandler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/praetorian-inc/trident/pkg/auth/rbac"
+	"github.com/praetorian-inc/trident/pkg/db"
+	"github.com/praetorian-inc/trident/pkg/stream"
+)
+
+// StreamHeartbeat is the interval between keep-alive comments sent to idle
+// result streams.
+const StreamHeartbeat = 15 * time.Second
+
+// StreamRefresh is the interval at which the campaigns visible to a non-admin
+// subscriber are refreshed, so that the stream follows the campaigns of their
+// teams as they are created, moved or deleted.
+var StreamRefresh = 30 * time.Second
+
+// ResultsStreamHandler streams results as Server-Sent Events as they are
+// consumed. results can be filtered with the campaign_id and valid query
+// parameters. the visibility of each result is checked as it is sent.
+func (s *Server) ResultsStreamHandler(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.authorize(w, r, rbac.RoleReadOnly)
+	if !ok {
+		return
+	}
+
+	if s.Hub == nil {
+		http.Error(w, "result streaming is not configured", http.StatusNotImplemented)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	var filter stream.Filter
+	filter.ValidOnly = r.URL.Query().Get("valid") == "true"
+
+	if v := r.URL.Query().Get("campaign_id"); v != "" {
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid campaign_id", http.StatusBadRequest)
+			return
+		}
+		filter.Campaigns = map[uint]bool{uint(id): true}
+	}
+
+	// restrict non-admins to the campaigns of their teams
+	var visible map[uint]bool
+	if p.Role != rbac.RoleAdmin {
+		var err error
+		visible, err = s.streamVisibility(p)
+		if err != nil {
+			log.Printf("error querying database: %s", err)
+			http.Error(w, http.StatusText(500), 500)
+			return
+		}
+	}
+
+	results, cancel := s.Hub.Subscribe(filter)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(StreamHeartbeat)
+	defer heartbeat.Stop()
+	refresh := time.NewTicker(StreamRefresh)
+	defer refresh.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n") // nolint:errcheck
+		case <-refresh.C:
+			if visible == nil {
+				continue
+			}
+			v, err := s.streamVisibility(p)
+			if err != nil {
+				// withhold results until their visibility is known
+				log.Errorf("error refreshing visible campaigns: %s", err)
+				v = map[uint]bool{}
+			}
+			visible = v
+			continue
+		case res := <-results:
+			if visible != nil && !visible[res.CampaignID] {
+				continue
+			}
+			batch := []db.Result{res}
+			err := s.unsealResults(r.Context(), p, batch)
+			if err != nil {
+				log.Errorf("error decrypting result: %s", err)
+				continue
+			}
+			b, err := json.Marshal(&batch[0])
+			if err != nil {
+				log.Errorf("error encoding result: %s", err)
+				continue
+			}
+			fmt.Fprintf(w, "event: result\ndata: %s\n\n", b) // nolint:errcheck
+		}
+		flusher.Flush()
+	}
+}
+
+// streamVisibility returns the set of campaigns whose results may be streamed
+// to a non-admin principal.
+func (s *Server) streamVisibility(p rbac.Principal) (map[uint]bool, error) {
+	campaigns, err := s.visibleCampaigns(p)
+	if err != nil {
+		return nil, err
+	}
+	visible := make(map[uint]bool, len(campaigns))
+	for _, c := range campaigns {
+		visible[c.ID] = true
+	}
+	return visible, nil
+}
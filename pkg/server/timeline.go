@@ -0,0 +1,83 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/praetorian-inc/trident/pkg/auth/rbac"
+	"github.com/praetorian-inc/trident/pkg/db"
+	"github.com/praetorian-inc/trident/pkg/detection"
+	"github.com/praetorian-inc/trident/pkg/parse"
+)
+
+// TimelineHandler takes a campaignID from the user and returns every attempt
+// of the campaign (timestamp, source IP, target account and outcome) in
+// chronological order via JSON, to be compared against SIEM alerts
+func (s *Server) TimelineHandler(w http.ResponseWriter, r *http.Request) {
+	var q struct {
+		ID uint
+	}
+
+	p, ok := s.authorize(w, r, rbac.RoleReadOnly)
+	if !ok {
+		return
+	}
+
+	err := parse.DecodeJSONBody(w, r, &q)
+	if err != nil {
+		var mr *parse.MalformedRequest
+		if errors.As(err, &mr) {
+			http.Error(w, mr.Msg, mr.Status)
+		} else {
+			log.Errorf("unknown error decoding json: %s", err)
+			http.Error(w, http.StatusText(500), 500)
+		}
+		return
+	}
+
+	campaign, err := s.visibleCampaign(p, q.ID)
+	if err != nil {
+		log.Printf("error querying database: %s", err)
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+	if campaign == nil {
+		http.Error(w, http.StatusText(404), 404)
+		return
+	}
+
+	results, err := s.DB.SelectResults(db.Query{
+		ReturnedFields: []string{"timestamp", "ip", "region", "username", "valid", "locked",
+			"rate_limited", "error"},
+		Filter: map[string]interface{}{"campaign_id": q.ID},
+	})
+	if err != nil {
+		log.Printf("error querying database: %s", err)
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(detection.Timeline(*campaign, results))
+	if err != nil {
+		log.Errorf("error encoding timeline: %s", err)
+		return
+	}
+}
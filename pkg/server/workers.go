@@ -0,0 +1,65 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/praetorian-inc/trident/pkg/auth/rbac"
+	"github.com/praetorian-inc/trident/pkg/report"
+)
+
+// WorkerWindow is the period of recent attempts the health of workers is
+// computed from.
+const WorkerWindow = 15 * time.Minute
+
+// WorkersHandler returns the health of the workers which made attempts for
+// the visible campaigns within the WorkerWindow via JSON
+func (s *Server) WorkersHandler(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.authorize(w, r, rbac.RoleReadOnly)
+	if !ok {
+		return
+	}
+
+	campaigns, err := s.visibleCampaigns(p)
+	if err != nil {
+		log.Printf("error querying database: %s", err)
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+	ids := make([]uint, len(campaigns))
+	for i, c := range campaigns {
+		ids[i] = c.ID
+	}
+
+	now := time.Now()
+	results, err := s.DB.SelectRecentResults(ids, now.Add(-WorkerWindow))
+	if err != nil {
+		log.Printf("error querying database: %s", err)
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(report.Workers(results, now))
+	if err != nil {
+		log.Errorf("error encoding workers: %s", err)
+		return
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package stream fans out results consumed by the scheduler to subscribers
+// (e.g. operators following a campaign's results live).
+package stream
+
+import (
+	"sync"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+)
+
+// SubscriberBuffer is the number of results buffered per subscriber. results
+// are dropped for subscribers which fall further behind.
+const SubscriberBuffer = 256
+
+// Filter selects the results delivered to a subscriber.
+type Filter struct {
+	// Campaigns limits results to these campaigns (all campaigns if nil)
+	Campaigns map[uint]bool
+
+	// ValidOnly limits results to valid credentials
+	ValidOnly bool
+}
+
+// Match returns true if the result passes the filter.
+func (f Filter) Match(res *db.Result) bool {
+	if f.ValidOnly && !res.Valid {
+		return false
+	}
+	if f.Campaigns != nil && !f.Campaigns[res.CampaignID] {
+		return false
+	}
+	return true
+}
+
+type subscriber struct {
+	filter Filter
+	c      chan db.Result
+}
+
+// Hub broadcasts published results to its subscribers. the zero value is
+// ready to use.
+type Hub struct {
+	mu   sync.RWMutex
+	subs map[*subscriber]struct{}
+}
+
+// Subscribe registers a subscriber for results matching the filter. the
+// returned function must be called to unsubscribe, after which the channel is
+// closed.
+func (h *Hub) Subscribe(f Filter) (<-chan db.Result, func()) {
+	sub := &subscriber{
+		filter: f,
+		c:      make(chan db.Result, SubscriberBuffer),
+	}
+
+	h.mu.Lock()
+	if h.subs == nil {
+		h.subs = make(map[*subscriber]struct{})
+	}
+	h.subs[sub] = struct{}{}
+	h.mu.Unlock()
+
+	var once sync.Once
+	return sub.c, func() {
+		once.Do(func() {
+			h.mu.Lock()
+			delete(h.subs, sub)
+			h.mu.Unlock()
+			close(sub.c)
+		})
+	}
+}
+
+// Publish delivers a result to every matching subscriber without blocking.
+func (h *Hub) Publish(res db.Result) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for sub := range h.subs {
+		if !sub.filter.Match(&res) {
+			continue
+		}
+		select {
+		case sub.c <- res:
+		default:
+			// slow subscriber, drop the result
+		}
+	}
+}
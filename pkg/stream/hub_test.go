@@ -0,0 +1,60 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stream
+
+import (
+	"testing"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+)
+
+func TestHub(t *testing.T) {
+	var h Hub
+
+	all, cancelAll := h.Subscribe(Filter{})
+	valid, cancelValid := h.Subscribe(Filter{
+		Campaigns: map[uint]bool{1: true},
+		ValidOnly: true,
+	})
+
+	results := []db.Result{
+		{CampaignID: 1, Username: "alice", Valid: true},
+		{CampaignID: 1, Username: "bob", Valid: false},
+		{CampaignID: 2, Username: "eve", Valid: true},
+	}
+	for _, res := range results {
+		h.Publish(res)
+	}
+
+	if len(all) != 3 {
+		t.Errorf("expected 3 results, got %d", len(all))
+	}
+	if len(valid) != 1 {
+		t.Fatalf("expected 1 filtered result, got %d", len(valid))
+	}
+	if res := <-valid; res.Username != "alice" {
+		t.Errorf("expected alice, got %s", res.Username)
+	}
+
+	cancelValid()
+	cancelValid()
+	if _, ok := <-valid; ok {
+		t.Errorf("expected channel to be closed")
+	}
+
+	// publishing after unsubscribing must not panic
+	h.Publish(results[0])
+	cancelAll()
+}
@@ -0,0 +1,75 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package usernames
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Excluded returns true if the username matches one of the exclusion
+// patterns. patterns are matched case insensitively, and may use the
+// wildcards of path.Match to exclude groups of accounts, such as
+// "admin-*@example.org", "*@board.example.org" or "CORP\svc-*". unlike
+// path.Match, a backslash is not an escape character and matches itself, as
+// in down-level logon names (DOMAIN\user).
+func Excluded(patterns []string, username string) bool {
+	username = strings.ToLower(username)
+	for _, pattern := range patterns {
+		ok, err := path.Match(literalBackslashes(strings.ToLower(pattern)), username)
+		if err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateExclusions returns an error if one of the exclusion patterns is
+// malformed.
+func ValidateExclusions(patterns []string) error {
+	for _, pattern := range patterns {
+		if strings.TrimSpace(pattern) == "" {
+			return fmt.Errorf("empty exclusion pattern")
+		}
+		_, err := path.Match(literalBackslashes(pattern), "")
+		if err != nil {
+			return fmt.Errorf("invalid exclusion pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// literalBackslashes escapes the backslashes of a pattern, so that path.Match
+// matches them literally.
+func literalBackslashes(pattern string) string {
+	return strings.ReplaceAll(pattern, `\`, `\\`)
+}
+
+// Identity returns the domain and the normalized username which identify an
+// account across campaigns. usernames are lowercased, and the domain is the
+// part after the @ of a user principal name (user@example.org) or before the
+// backslash of a down-level logon name (EXAMPLE\user). the domain of a bare
+// username is empty.
+func Identity(username string) (domain, normalized string) {
+	normalized = strings.ToLower(strings.TrimSpace(username))
+	if i := strings.LastIndex(normalized, "@"); i >= 0 {
+		return normalized[i+1:], normalized
+	}
+	if i := strings.Index(normalized, `\`); i >= 0 {
+		return normalized[:i], normalized
+	}
+	return "", normalized
+}
@@ -0,0 +1,232 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package usernames generates usernames from a list of people's names and
+// username format templates, such as "{f}{last}@example.org" or
+// "{first}.{last}". Templates support the following placeholders:
+//
+//  {first}   first name          {f}   first initial
+//  {middle}  middle name(s)      {m}   middle initial
+//  {last}    last name           {l}   last initial
+//
+// Names are lowercased and stripped of accents, spaces and punctuation.
+package usernames
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Name is a person's name split into its parts.
+type Name struct {
+	First  string
+	Middle string
+	Last   string
+}
+
+// ParseName splits a full name. the first word is the first name, the last
+// word is the last name and any words in between are middle names.
+func ParseName(full string) Name {
+	words := strings.Fields(full)
+	switch len(words) {
+	case 0:
+		return Name{}
+	case 1:
+		return Name{First: words[0]}
+	}
+	return Name{
+		First:  words[0],
+		Middle: strings.Join(words[1:len(words)-1], " "),
+		Last:   words[len(words)-1],
+	}
+}
+
+var folds = strings.NewReplacer(
+	"à", "a", "á", "a", "â", "a", "ã", "a", "ä", "a", "å", "a", "æ", "ae",
+	"ç", "c", "è", "e", "é", "e", "ê", "e", "ë", "e", "ì", "i", "í", "i",
+	"î", "i", "ï", "i", "ñ", "n", "ò", "o", "ó", "o", "ô", "o", "õ", "o",
+	"ö", "o", "ø", "o", "ù", "u", "ú", "u", "û", "u", "ü", "u", "ý", "y",
+	"ÿ", "y", "ß", "ss",
+)
+
+// normalize lowercases a name part and strips it of accents, spaces and
+// punctuation (e.g. "O'Brien-Núñez" becomes "obriennunez").
+func normalize(s string) string {
+	s = folds.Replace(strings.ToLower(s))
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, s)
+}
+
+func initial(s string) string {
+	for _, r := range s {
+		return string(r)
+	}
+	return ""
+}
+
+var placeholder = regexp.MustCompile(`\{([a-z]+)\}`)
+
+// Template is a compiled username format template.
+type Template struct {
+	format string
+}
+
+// ParseTemplate validates a username format template.
+func ParseTemplate(format string) (*Template, error) {
+	matches := placeholder.FindAllStringSubmatch(format, -1)
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("template %q has no placeholders", format)
+	}
+	for _, m := range matches {
+		switch m[1] {
+		case "first", "f", "middle", "m", "last", "l":
+		default:
+			return nil, fmt.Errorf("template %q has unknown placeholder {%s}", format, m[1])
+		}
+	}
+	if strings.ContainsAny(placeholder.ReplaceAllString(format, ""), "{}") {
+		return nil, fmt.Errorf("template %q has unbalanced braces", format)
+	}
+	return &Template{format: format}, nil
+}
+
+// Execute generates the username of a person. ok is false if the template
+// requires a part of the name the person does not have (e.g. a middle name).
+func (t *Template) Execute(n Name) (username string, ok bool) {
+	first, middle, last := normalize(n.First), normalize(n.Middle), normalize(n.Last)
+	parts := map[string]string{
+		"first":  first,
+		"f":      initial(first),
+		"middle": middle,
+		"m":      initial(middle),
+		"last":   last,
+		"l":      initial(last),
+	}
+
+	ok = true
+	username = placeholder.ReplaceAllStringFunc(t.format, func(m string) string {
+		v := parts[m[1:len(m)-1]]
+		if v == "" {
+			ok = false
+		}
+		return v
+	})
+	return username, ok
+}
+
+// Generate returns the deduplicated usernames of every person for every
+// template, grouped by person.
+func Generate(names []string, formats []string) ([]string, error) {
+	if len(names) > 0 && len(formats) == 0 {
+		return nil, fmt.Errorf("at least one username format is required")
+	}
+
+	templates := make([]*Template, 0, len(formats))
+	for _, f := range formats {
+		t, err := ParseTemplate(f)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, t)
+	}
+
+	seen := make(map[string]bool)
+	var usernames []string
+	for _, full := range names {
+		n := ParseName(full)
+		for _, t := range templates {
+			u, ok := t.Execute(n)
+			if !ok || seen[u] {
+				continue
+			}
+			seen[u] = true
+			usernames = append(usernames, u)
+		}
+	}
+	return usernames, nil
+}
+
+// ReadCSV reads full names from a CSV export (e.g. a LinkedIn scrape). if the
+// first row is a header, the first and last names are read from the columns
+// named like "first name" and "last name", or the full name from a column
+// named like "name". otherwise, rows are read as first and last name columns
+// (or a single full name column).
+func ReadCSV(r io.Reader) ([]string, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	first, last, full := -1, -1, -1
+	for i, col := range records[0] {
+		col = strings.ToLower(col)
+		switch {
+		case strings.Contains(col, "first") && first < 0:
+			first = i
+		case (strings.Contains(col, "last") || strings.Contains(col, "surname")) && last < 0:
+			last = i
+		case strings.Contains(col, "name") && full < 0:
+			full = i
+		}
+	}
+
+	header := first >= 0 || last >= 0 || full >= 0
+	switch {
+	case header:
+		records = records[1:]
+		// first and last name columns take precedence over full names
+		if first >= 0 && last >= 0 {
+			full = -1
+		} else if full < 0 {
+			return nil, fmt.Errorf("csv header has no name columns")
+		}
+	default:
+		first, last = 0, 1
+	}
+
+	var names []string
+	for _, rec := range records {
+		var name string
+		switch {
+		case full >= 0 && full < len(rec):
+			name = rec[full]
+		case len(rec) == 1:
+			name = rec[0]
+		case first >= 0 && first < len(rec) && last < len(rec):
+			// multi-word last names (e.g. "van der berg") are joined so
+			// that they are not split into middle names
+			name = rec[first] + " " + strings.Join(strings.Fields(rec[last]), "")
+		}
+		name = strings.Join(strings.Fields(name), " ")
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
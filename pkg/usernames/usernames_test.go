@@ -0,0 +1,158 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package usernames
+
+import (
+	"strings"
+	"testing"
+)
+
+type testcase struct {
+	format   string
+	name     string
+	expected string
+	ok       bool
+}
+
+func TestExecute(t *testing.T) {
+	var testcases = []testcase{
+		{"{f}{last}@example.org", "Alice Smith", "asmith@example.org", true},
+		{"{first}.{last}", "Alice Smith", "alice.smith", true},
+		{"{first}{l}", "Alice Smith", "alices", true},
+		{"{first}.{m}.{last}", "Alice B. Smith", "alice.b.smith", true},
+		{"{first}.{m}.{last}", "Alice Smith", "", false},
+		{"{last}", "Alice", "", false},
+		{"{first}.{last}", "Zoë O'Brien-Núñez", "zoe.obriennunez", true},
+		{"{first}_{last}", "  Bob   Jones ", "bob_jones", true},
+	}
+
+	for _, test := range testcases {
+		tmpl, err := ParseTemplate(test.format)
+		if err != nil {
+			t.Errorf("unexpected error parsing %q: %s", test.format, err)
+			continue
+		}
+		actual, ok := tmpl.Execute(ParseName(test.name))
+		if ok != test.ok || (ok && actual != test.expected) {
+			t.Errorf("%q on %q: expected (%q, %t), got (%q, %t)",
+				test.format, test.name, test.expected, test.ok, actual, ok)
+		}
+	}
+
+	for _, format := range []string{"alice", "{first", "{nickname}", "{first}}"} {
+		_, err := ParseTemplate(format)
+		if err == nil {
+			t.Errorf("expected error parsing %q", format)
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	actual, err := Generate([]string{"Alice Smith", "Adam Smith", "Bob Jones"},
+		[]string{"{f}{last}", "{first}.{last}"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := "asmith,alice.smith,adam.smith,bjones,bob.jones"
+	if strings.Join(actual, ",") != expected {
+		t.Errorf("expected %s, got %s", expected, strings.Join(actual, ","))
+	}
+
+	_, err = Generate([]string{"Alice Smith"}, nil)
+	if err == nil {
+		t.Errorf("expected an error without formats")
+	}
+}
+
+func TestReadCSV(t *testing.T) {
+	var testcases = []struct {
+		desc     string
+		csv      string
+		expected string
+	}{
+		{"linkedin export", "First Name,Last Name,Title\nAlice,Smith,CEO\nBob,van der Berg,CTO\n",
+			"Alice Smith,Bob vanderBerg"},
+		{"full name column", "Company,Full Name\nAcme,Alice B Smith\n", "Alice B Smith"},
+		{"no header", "Alice,Smith\nBob,Jones\n", "Alice Smith,Bob Jones"},
+		{"single column", "Alice Smith\nBob Jones\n", "Alice Smith,Bob Jones"},
+	}
+
+	for _, test := range testcases {
+		names, err := ReadCSV(strings.NewReader(test.csv))
+		if err != nil {
+			t.Errorf("[%s] unexpected error: %s", test.desc, err)
+			continue
+		}
+		if actual := strings.Join(names, ","); actual != test.expected {
+			t.Errorf("[%s] expected %s, got %s", test.desc, test.expected, actual)
+		}
+	}
+}
+
+func TestExcluded(t *testing.T) {
+	patterns := []string{"breakglass@example.org", "admin-*@example.org", "*@board.example.org",
+		`CORP\admin`, `BOARD\*`}
+
+	var testcases = []struct {
+		username string
+		excluded bool
+	}{
+		{"breakglass@example.org", true},
+		{"BreakGlass@Example.org", true},
+		{"admin-alice@example.org", true},
+		{"carol@board.example.org", true},
+		{"alice@example.org", false},
+		{"admin@example.org", false},
+		{"breakglass@example.org.evil", false},
+		{`corp\admin`, true},
+		{`CORP\Admin`, true},
+		{`CORP\alice`, false},
+		{`board\carol`, true},
+	}
+
+	for _, test := range testcases {
+		if actual := Excluded(patterns, test.username); actual != test.excluded {
+			t.Errorf("[%s] expected excluded to be %t", test.username, test.excluded)
+		}
+	}
+
+	if err := ValidateExclusions(patterns); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	for _, pattern := range []string{"[admin", " "} {
+		if err := ValidateExclusions([]string{pattern}); err == nil {
+			t.Errorf("expected an error for pattern %q", pattern)
+		}
+	}
+}
+
+func TestIdentity(t *testing.T) {
+	var testcases = []struct {
+		username string
+		domain   string
+		expected string
+	}{
+		{"Alice@Example.org", "example.org", "alice@example.org"},
+		{` EXAMPLE\Bob `, "example", `example\bob`},
+		{"carol", "", "carol"},
+	}
+
+	for _, test := range testcases {
+		domain, username := Identity(test.username)
+		if domain != test.domain || username != test.expected {
+			t.Errorf("[%s] unexpected identity %q, %q", test.username, domain, username)
+		}
+	}
+}
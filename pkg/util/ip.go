@@ -18,9 +18,21 @@ import (
 	"bytes"
 	"io/ioutil"
 	"net/http"
+	"os"
+	"path"
+	"time"
 )
 
-// ExternalIP returns the external IP address via the default route to the // Internet.
+// RegionEnv lists the environment variables checked for the region of a
+// worker, in order: an explicit override, AWS Lambda and Azure Functions.
+var RegionEnv = []string{"WORKER_REGION", "AWS_REGION", "REGION_NAME"}
+
+// gcpRegionURL is the metadata server endpoint returning the region of Cloud
+// Run services (projects/<number>/regions/<region>).
+const gcpRegionURL = "http://metadata.google.internal/computeMetadata/v1/instance/region"
+
+// ExternalIP returns the external IP address via the default route to the
+// Internet.
 func ExternalIP() (string, error) {
 	resp, err := http.Get("https://checkip.amazonaws.com")
 	if err != nil {
@@ -35,3 +47,33 @@ func ExternalIP() (string, error) {
 
 	return string(bytes.TrimSpace(buf)), nil
 }
+
+// Region returns the cloud region the process runs in, using RegionEnv and
+// falling back to the Google Cloud metadata server. An empty string is
+// returned if the region is unknown.
+func Region() string {
+	for _, env := range RegionEnv {
+		if v := os.Getenv(env); v != "" {
+			return v
+		}
+	}
+
+	req, err := http.NewRequest("GET", gcpRegionURL, nil)
+	if err != nil {
+		return ""
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	client := &http.Client{Timeout: 2 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return ""
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	buf, err := ioutil.ReadAll(resp.Body)
+	if err != nil || resp.StatusCode != 200 {
+		return ""
+	}
+	return path.Base(string(bytes.TrimSpace(buf)))
+}
@@ -39,3 +39,17 @@ func ValidateURLSuffix(rawurl, domainSuffix string) error {
 	}
 	return nil
 }
+
+// ValidateHost ensures the provided value is a bare host (a hostname or IP
+// address, optionally followed by a port) rather than a URL, so that it can be
+// safely interpolated into https://<host>/ URLs.
+func ValidateHost(host string) error {
+	u, err := url.Parse("https://" + host)
+	if err != nil {
+		return err
+	}
+	if host == "" || u.Host != host || u.Hostname() == "" || u.User != nil {
+		return fmt.Errorf("expected a hostname, got %q", host)
+	}
+	return nil
+}
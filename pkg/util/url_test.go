@@ -59,3 +59,25 @@ func TestValidation(t *testing.T) {
 	}
 
 }
+
+func TestValidateHost(t *testing.T) {
+	var testcases = []struct {
+		host      string
+		expecterr bool
+	}{
+		{"vpn.example.org", false},
+		{"vpn.example.org:4433", false},
+		{"10.0.0.1", false},
+		{"", true},
+		{"vpn.example.org/dana-na", true},
+		{"user@vpn.example.org", true},
+		{"https://vpn.example.org", true},
+		{":443", true},
+	}
+	for _, test := range testcases {
+		err := ValidateHost(test.host)
+		if (err != nil) != test.expecterr {
+			t.Errorf("[%s] unexpected validation result: %v", test.host, err)
+		}
+	}
+}
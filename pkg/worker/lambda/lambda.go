@@ -0,0 +1,94 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package lambda adapts an HTTP worker to AWS Lambda behind an API Gateway
+// proxy integration, so that the webhook worker's handlers can run unchanged
+// with egress from AWS.
+package lambda
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// Handler returns a Lambda handler which converts each API Gateway proxy
+// request to an HTTP request, serves it with h and converts the response.
+func Handler(h http.Handler) func(context.Context, events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	return func(ctx context.Context, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		req, err := NewRequest(ctx, event)
+		if err != nil {
+			return events.APIGatewayProxyResponse{}, err
+		}
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		return events.APIGatewayProxyResponse{
+			StatusCode:        w.Code,
+			MultiValueHeaders: w.Header(),
+			Body:              w.Body.String(),
+		}, nil
+	}
+}
+
+// NewRequest converts an API Gateway proxy request to an HTTP request. The
+// path is relative to the API Gateway stage.
+func NewRequest(ctx context.Context, event events.APIGatewayProxyRequest) (*http.Request, error) {
+	body := []byte(event.Body)
+	if event.IsBase64Encoded {
+		var err error
+		body, err = base64.StdEncoding.DecodeString(event.Body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	query := url.Values{}
+	for k, v := range event.QueryStringParameters {
+		query.Set(k, v)
+	}
+	for k, vs := range event.MultiValueQueryStringParameters {
+		query[k] = vs
+	}
+
+	u := url.URL{
+		Path:     event.Path,
+		RawQuery: query.Encode(),
+	}
+	req, err := http.NewRequest(event.HTTPMethod, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range event.Headers {
+		req.Header.Set(k, v)
+	}
+	for k, vs := range event.MultiValueHeaders {
+		req.Header.Del(k)
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+
+	req.Host = req.Header.Get("Host")
+	req.RemoteAddr = net.JoinHostPort(event.RequestContext.Identity.SourceIP, "0")
+	return req.WithContext(ctx), nil
+}
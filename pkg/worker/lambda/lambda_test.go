@@ -0,0 +1,63 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lambda
+
+import (
+	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestHandler(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Method != "POST" || r.URL.Path != "/batch" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if r.Header.Get("X-Trident-Token") != "v1.token" {
+			t.Errorf("unexpected token header %q", r.Header.Get("X-Trident-Token"))
+		}
+		if string(body) != `{"tasks":[]}` {
+			t.Errorf("unexpected body %q", body)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(201)
+		w.Write([]byte(`{"results":[]}`)) // nolint:errcheck
+	})
+
+	resp, err := Handler(h)(context.Background(), events.APIGatewayProxyRequest{
+		Path:            "/batch",
+		HTTPMethod:      "POST",
+		Headers:         map[string]string{"x-trident-token": "v1.token"},
+		Body:            base64.StdEncoding.EncodeToString([]byte(`{"tasks":[]}`)),
+		IsBase64Encoded: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 201 {
+		t.Errorf("got status %d, want 201", resp.StatusCode)
+	}
+	if resp.Body != `{"results":[]}` {
+		t.Errorf("got body %q", resp.Body)
+	}
+	if ct := resp.MultiValueHeaders["Content-Type"]; len(ct) != 1 || ct[0] != "application/json" {
+		t.Errorf("got content type %v", ct)
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package pull implements a long-running worker which pulls tasks from the
+// queue directly rather than being invoked by a dispatcher, avoiding the cold
+// starts of serverless workers. It is intended to run on Kubernetes and
+// exposes probes and a backlog metric for the horizontal pod autoscaler.
+package pull
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/praetorian-inc/trident/pkg/event"
+	"github.com/praetorian-inc/trident/pkg/worker/webhook"
+)
+
+// Worker implements the dispatch.WorkerClient interface by executing tasks
+// in-process, and records the metrics served by MetricsHandler.
+type Worker struct {
+	// Server executes tasks
+	Server *webhook.Server
+
+	// Concurrency is the number of tasks executed at once
+	Concurrency int
+
+	ready    int32
+	inflight int64
+	tasks    uint64
+	failures uint64
+	backlog  int64 // -1 if unknown
+}
+
+// NewWorker creates a Worker which executes up to concurrency tasks at once.
+func NewWorker(s *webhook.Server, concurrency int) *Worker {
+	return &Worker{
+		Server:      s,
+		Concurrency: concurrency,
+		backlog:     -1,
+	}
+}
+
+// Submit fulfils the dispatch.WorkerClient interface.
+func (w *Worker) Submit(req event.AuthRequest) (*event.AuthResponse, error) {
+	atomic.AddInt64(&w.inflight, 1)
+	defer atomic.AddInt64(&w.inflight, -1)
+
+	res, err := w.Server.Execute(context.Background(), req)
+	atomic.AddUint64(&w.tasks, 1)
+	if err != nil {
+		atomic.AddUint64(&w.failures, 1)
+	}
+	return res, err
+}
+
+// SetReady marks the worker as ready (or not) to receive tasks.
+func (w *Worker) SetReady(ready bool) {
+	var v int32
+	if ready {
+		v = 1
+	}
+	atomic.StoreInt32(&w.ready, v)
+}
+
+// WatchBacklog polls the backlog every interval until ctx is done. errors
+// are logged and leave the last known backlog in place.
+func (w *Worker) WatchBacklog(ctx context.Context, backlog func(context.Context) (int64, error), interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := backlog(ctx)
+		if err != nil {
+			log.Printf("error reading queue backlog: %s", err)
+		} else {
+			atomic.StoreInt64(&w.backlog, n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// HealthzHandler is the liveness probe and always returns an HTTP 200 ok.
+func (w *Worker) HealthzHandler(rw http.ResponseWriter, r *http.Request) {}
+
+// ReadyzHandler is the readiness probe and returns an HTTP 503 until the
+// worker is listening for tasks, and once it begins shutting down.
+func (w *Worker) ReadyzHandler(rw http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&w.ready) == 0 {
+		http.Error(rw, http.StatusText(503), 503)
+	}
+}
+
+// MetricsHandler serves the worker's metrics in the Prometheus text format.
+// trident_queue_backlog is omitted if the queue cannot report its backlog;
+// autoscalers should target its average value across workers.
+func (w *Worker) MetricsHandler(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	if backlog := atomic.LoadInt64(&w.backlog); backlog >= 0 {
+		metric(rw, "trident_queue_backlog", "gauge",
+			"Tasks waiting in the queue subscription, including unacknowledged tasks.", backlog)
+	}
+	metric(rw, "trident_worker_concurrency", "gauge",
+		"Maximum number of tasks executed at once.", w.Concurrency)
+	metric(rw, "trident_worker_inflight_tasks", "gauge",
+		"Tasks currently being executed.", atomic.LoadInt64(&w.inflight))
+	metric(rw, "trident_worker_tasks_total", "counter",
+		"Tasks executed.", atomic.LoadUint64(&w.tasks))
+	metric(rw, "trident_worker_task_errors_total", "counter",
+		"Tasks which failed with an error.", atomic.LoadUint64(&w.failures))
+}
+
+func metric(rw http.ResponseWriter, name, kind, help string, value interface{}) {
+	fmt.Fprintf(rw, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, kind, name, value) // nolint:errcheck
+}
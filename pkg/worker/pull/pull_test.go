@@ -0,0 +1,94 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pull
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/praetorian-inc/trident/pkg/event"
+	"github.com/praetorian-inc/trident/pkg/worker/webhook"
+
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/mock"
+)
+
+func TestWorker(t *testing.T) {
+	w := NewWorker(&webhook.Server{}, 5)
+
+	res, err := w.Submit(event.AuthRequest{
+		Provider:         "mock",
+		ProviderMetadata: map[string]string{"valid_password": "Password1!"},
+		Username:         "alice",
+		Password:         "Password1!",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Valid {
+		t.Error("expected valid result")
+	}
+
+	_, err = w.Submit(event.AuthRequest{Provider: "unknown"})
+	if err == nil {
+		t.Error("expected error for unknown provider")
+	}
+
+	rec := httptest.NewRecorder()
+	w.MetricsHandler(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		"trident_worker_concurrency 5\n",
+		"trident_worker_inflight_tasks 0\n",
+		"trident_worker_tasks_total 2\n",
+		"trident_worker_task_errors_total 1\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %q:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "trident_queue_backlog") {
+		t.Error("backlog reported before it is known")
+	}
+}
+
+func TestWatchBacklog(t *testing.T) {
+	w := NewWorker(&webhook.Server{}, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w.WatchBacklog(ctx, func(context.Context) (int64, error) { return 42, nil }, time.Minute)
+
+	rec := httptest.NewRecorder()
+	w.MetricsHandler(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), "trident_queue_backlog 42\n") {
+		t.Errorf("expected backlog metric:\n%s", rec.Body.String())
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	w := NewWorker(&webhook.Server{}, 1)
+
+	for _, ready := range []bool{false, true, false} {
+		w.SetReady(ready)
+		rec := httptest.NewRecorder()
+		w.ReadyzHandler(rec, httptest.NewRequest("GET", "/readyz", nil))
+		if (rec.Code == 200) != ready {
+			t.Errorf("ready=%v: got status %d", ready, rec.Code)
+		}
+	}
+}
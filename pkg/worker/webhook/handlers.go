@@ -15,21 +15,49 @@
 package webhook
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 
+	"github.com/praetorian-inc/trident/pkg/action"
 	"github.com/praetorian-inc/trident/pkg/event"
+	"github.com/praetorian-inc/trident/pkg/fingerprint"
+	"github.com/praetorian-inc/trident/pkg/kms"
 	"github.com/praetorian-inc/trident/pkg/nozzle"
+	"github.com/praetorian-inc/trident/pkg/retry"
+	"github.com/praetorian-inc/trident/pkg/secrets"
+	"github.com/praetorian-inc/trident/pkg/usernames"
 	"github.com/praetorian-inc/trident/pkg/util"
 )
 
+// SecretTTL is how long secrets referenced by the provider metadata of tasks
+// should be cached by workers.
+const SecretTTL = 5 * time.Minute
+
 // Server implements an HTTP server handler for handling tasks.
 type Server struct {
-	ip string
+	mu     sync.RWMutex
+	ip     string
+	region string
+
+	// Envelope decrypts sealed task passwords. if nil, tasks are expected
+	// to carry plaintext passwords.
+	Envelope *kms.Envelope
+
+	// Secrets resolves the secret references in the provider metadata of
+	// tasks (e.g. "secret:okta/client#secret"). if nil, tasks referencing
+	// secrets fail.
+	Secrets secrets.Store
+
+	// Protected are patterns of accounts the worker never attempts,
+	// whatever the campaign (see usernames.Excluded)
+	Protected []string
 }
 
 // NewWebhookServer creates a new Server.
@@ -39,19 +67,173 @@ func NewWebhookServer() (*Server, error) {
 		log.Fatal(err)
 	}
 	return &Server{
-		ip: externalIP,
+		ip:     externalIP,
+		region: util.Region(),
 	}, nil
 }
 
+// WatchEgress refreshes the egress IP reported with results every interval,
+// since the egress IP of a long-lived or serverless worker may change.
+func (s *Server) WatchEgress(interval time.Duration) {
+	for range time.Tick(interval) {
+		ip, err := util.ExternalIP()
+		if err != nil {
+			log.Printf("error refreshing egress ip: %s", err)
+			continue
+		}
+
+		s.mu.Lock()
+		if ip != s.ip {
+			log.Printf("egress ip changed from %s to %s", s.ip, ip)
+		}
+		s.ip = ip
+		s.mu.Unlock()
+	}
+}
+
+// egress returns the current egress IP and region of the worker.
+func (s *Server) egress() (string, string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.ip, s.region
+}
+
 // HealthzHandler returns an HTTP 200 ok always.
 func (s *Server) HealthzHandler(w http.ResponseWriter, r *http.Request) {}
 
 func httperr(w http.ResponseWriter, err error) {
-	res := event.ErrorResponse{ErrorMsg: err.Error()}
+	res := event.ErrorResponse{
+		ErrorMsg: err.Error(),
+		Class:    string(retry.Classify(err)),
+	}
 	w.WriteHeader(500)
 	json.NewEncoder(w).Encode(&res) // nolint:errcheck,gosec
 }
 
+// Execute runs a single task using the nozzle interface. tasks against
+// accounts excluded by their campaign or protected by the worker are refused.
+func (s *Server) Execute(ctx context.Context, req event.AuthRequest) (*event.AuthResponse, error) {
+	if usernames.Excluded(req.Excluded, req.Username) || usernames.Excluded(s.Protected, req.Username) {
+		log.Warnf("refusing task of campaign %d against protected account %s", req.CampaignID, req.Username)
+		return nil, retry.Errorf(retry.ClassDenied, "refusing to attempt protected account %s", req.Username)
+	}
+
+	opts, err := secrets.ResolveOptions(ctx, s.Secrets, req.ProviderMetadata)
+	if err != nil {
+		return nil, retry.Errorf(retry.ClassConfig, "error resolving provider secrets: %w", err)
+	}
+
+	noz, err := nozzle.Open(req.Provider, opts)
+	if err != nil {
+		return nil, retry.Errorf(retry.ClassConfig, "error opening nozzle: %w", err)
+	}
+
+	if req.Fingerprint {
+		return s.fingerprint(ctx, req, noz)
+	}
+
+	password := req.Password
+	if s.Envelope != nil {
+		password, err = s.Envelope.Unseal(ctx, req.Password)
+		if err != nil {
+			return nil, retry.Errorf(retry.ClassConfig, "error decrypting password: %w", err)
+		}
+	} else if kms.IsSealed(password) {
+		return nil, retry.Errorf(retry.ClassConfig, "received encrypted password without a key manager")
+	}
+
+	ts := time.Now()
+	res, err := noz.Login(ctx, req.Username, password)
+	if err != nil {
+		return nil, fmt.Errorf("error authenticating to %s provider: %w", req.Provider, err)
+	}
+
+	// fill in generic AuthResult values
+	res.CampaignID = req.CampaignID
+	res.CredentialID = req.CredentialID
+	res.Username = req.Username
+	res.Password = req.Password // remains sealed if encryption is enabled
+	res.Timestamp = ts
+	res.IP, res.Region = s.egress()
+
+	if res.Valid {
+		res.Findings = s.runActions(ctx, req, password)
+	}
+
+	// captured sessions and responses never leave the worker in plaintext
+	res.Session, err = s.seal(ctx, "session", req.Username, res.Session)
+	if err != nil {
+		return nil, err
+	}
+	res.Response, err = s.seal(ctx, "response", req.Username, res.Response)
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// fingerprint probes the tenant of the task's campaign instead of attempting a
+// login (see the fingerprint package).
+func (s *Server) fingerprint(ctx context.Context, req event.AuthRequest,
+	noz nozzle.Nozzle) (*event.AuthResponse, error) {
+	fp, err := fingerprint.Probe(ctx, noz, req.Username)
+	if errors.Is(err, fingerprint.ErrUnsupported) {
+		return nil, retry.Errorf(retry.ClassConfig, "error fingerprinting %s provider: %w", req.Provider, err)
+	} else if err != nil {
+		return nil, fmt.Errorf("error fingerprinting %s provider: %w", req.Provider, err)
+	}
+
+	res := &event.AuthResponse{
+		CampaignID:  req.CampaignID,
+		Username:    req.Username,
+		Timestamp:   fp.ProbedAt,
+		Fingerprint: fp,
+	}
+	res.IP, res.Region = s.egress()
+	return res, nil
+}
+
+// runActions runs the post-success actions of a task once its credential is
+// known to be valid.
+func (s *Server) runActions(ctx context.Context, req event.AuthRequest, password string) []event.Finding {
+	var findings []event.Finding
+	for _, spec := range req.Actions {
+		opts, err := secrets.ResolveOptions(ctx, s.Secrets, spec.Options)
+		if err != nil {
+			findings = append(findings, event.Finding{
+				Action: spec.Name,
+				Error:  fmt.Sprintf("error resolving action secrets: %s", err),
+			})
+			continue
+		}
+
+		f := action.Run(ctx, event.Action{Name: spec.Name, Options: opts}, req.Username, password)
+		if f.Error != "" {
+			log.Warnf("action %s failed for %s: %s", f.Action, req.Username, f.Error)
+		}
+		findings = append(findings, f)
+	}
+	return findings
+}
+
+// seal encrypts a value captured by a nozzle. the value is dropped if no key
+// manager is configured.
+func (s *Server) seal(ctx context.Context, kind, username, value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	if s.Envelope == nil {
+		log.Printf("dropping %s captured for %s, no key manager is configured", kind, username)
+		return "", nil
+	}
+	sealed, err := s.Envelope.Seal(ctx, value)
+	if err != nil {
+		return "", retry.Errorf(retry.ClassConfig, "error encrypting %s: %w", kind, err)
+	}
+	return sealed, nil
+}
+
 // EventHandler accepts an AuthRequest, executes the task using the nozzle
 // interface and returns the AuthResponse via JSON.
 func (s *Server) EventHandler(w http.ResponseWriter, r *http.Request) {
@@ -59,29 +241,52 @@ func (s *Server) EventHandler(w http.ResponseWriter, r *http.Request) {
 
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		httperr(w, fmt.Errorf("error decoding body: %w", err))
+		httperr(w, retry.Errorf(retry.ClassParse, "error decoding body: %w", err))
 		return
 	}
 
-	noz, err := nozzle.Open(req.Provider, req.ProviderMetadata)
+	res, err := s.Execute(r.Context(), req)
 	if err != nil {
-		httperr(w, fmt.Errorf("error opening nozzle: %w", err))
+		httperr(w, err)
 		return
 	}
 
-	ts := time.Now()
-	res, err := noz.Login(req.Username, req.Password)
+	json.NewEncoder(w).Encode(&res) // nolint:errcheck,gosec
+}
+
+// BatchHandler accepts a BatchRequest, executes each task concurrently and
+// returns a BatchResponse via JSON. tasks which fail are returned with their
+// error rather than failing the whole batch.
+func (s *Server) BatchHandler(w http.ResponseWriter, r *http.Request) {
+	var req event.BatchRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		httperr(w, fmt.Errorf("error authenticating to %s provider: %w", req.Provider, err))
+		httperr(w, retry.Errorf(retry.ClassParse, "error decoding body: %w", err))
 		return
 	}
 
-	// fill in generic AuthResult values
-	res.CampaignID = req.CampaignID
-	res.Username = req.Username
-	res.Password = req.Password
-	res.Timestamp = ts
-	res.IP = s.ip
+	res := event.BatchResponse{
+		Results: make([]event.AuthResponse, len(req.Tasks)),
+	}
+
+	var wg sync.WaitGroup
+	for i := range req.Tasks {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			result, err := s.Execute(r.Context(), req.Tasks[i])
+			if err != nil {
+				res.Results[i] = event.AuthResponse{
+					Error:      err.Error(),
+					ErrorClass: string(retry.Classify(err)),
+				}
+				return
+			}
+			res.Results[i] = *result
+		}(i)
+	}
+	wg.Wait()
 
 	json.NewEncoder(w).Encode(&res) // nolint:errcheck,gosec
 }
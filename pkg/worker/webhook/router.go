@@ -0,0 +1,66 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+)
+
+// NewRouter returns the worker's HTTP handler. auth is used to authenticate
+// every request (see AccessTokenVerifier and token.Verifier). The router is
+// shared by every worker runtime (e.g. Cloud Run, Lambda, Azure Functions).
+func NewRouter(s *Server, auth func(http.Handler) http.Handler) http.Handler {
+	r := chi.NewRouter()
+
+	// A good base middleware stack
+	r.Use(middleware.RequestID)
+	r.Use(middleware.RealIP)
+	r.Use(middleware.Logger)
+	r.Use(middleware.Recoverer)
+
+	// Set a timeout value on the request context (ctx), that will signal
+	// through ctx.Done() that the request has timed out and further
+	// processing should be stopped.
+	r.Use(middleware.Timeout(60 * time.Second))
+
+	// Insert authenication middleware to verify access token on all requests
+	r.Use(auth)
+
+	r.Get("/healthz", s.HealthzHandler)
+	r.Post("/", s.EventHandler)
+	r.Post("/batch", s.BatchHandler)
+
+	return r
+}
+
+// AccessTokenVerifier returns a middleware which requires the static access
+// token in the X-Access-Token header (legacy, prefer token.Verifier).
+func AccessTokenVerifier(accessToken []byte) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := r.Header.Get("X-Access-Token")
+			if subtle.ConstantTimeCompare([]byte(token), accessToken) == 0 {
+				http.Error(w, http.StatusText(403), 403)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -30,8 +30,9 @@ locals {
   }
 
   worker_config = jsonencode({
-    "url"   = var.worker_url,
-    "token" = var.worker_token,
+    "url"         = var.worker_url,
+    "signing_key" = var.worker_token,
+    "batch_size"  = tostring(var.worker_batch_size),
   })
 }
 
@@ -64,3 +64,9 @@ variable "worker_name" {
   type        = string
   default     = "webhook"
 }
+
+variable "worker_batch_size" {
+  description = "The number of tasks to submit per worker invocation"
+  type        = number
+  default     = 1
+}
@@ -30,7 +30,7 @@ resource "google_cloud_run_service" "worker" {
         image = var.image
 
         env {
-          name = "ACCESS_TOKEN"
+          name = "SIGNING_KEYS"
           value = random_id.token.hex
         }
       }